@@ -0,0 +1,343 @@
+// Package avtransport is a small UPnP AV control point: it finds media
+// renderers on the network, and has them play URLs through their
+// AVTransport service.
+package avtransport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"github.com/anacrolix/dms/ssdp"
+	"github.com/anacrolix/dms/upnp"
+)
+
+const (
+	MediaRendererType = "urn:schemas-upnp-org:device:MediaRenderer:1"
+	// The AVTransport service type, without the version.
+	serviceTypePrefix = "urn:schemas-upnp-org:service:AVTransport:"
+	// Device descriptions and control responses are small.
+	maxResponseSize = 1 << 20
+)
+
+// A media renderer with an AVTransport service.
+type Renderer struct {
+	UDN          string
+	FriendlyName string
+	Manufacturer string
+	ModelName    string
+	// The URL of the device description.
+	Location string
+	// The AVTransport service's type, with its version, and control URL.
+	ServiceType string
+	ControlURL  string
+}
+
+type deviceDesc struct {
+	URLBase string `xml:"URLBase"`
+	Device  device `xml:"device"`
+}
+
+type device struct {
+	DeviceType   string         `xml:"deviceType"`
+	FriendlyName string         `xml:"friendlyName"`
+	Manufacturer string         `xml:"manufacturer"`
+	ModelName    string         `xml:"modelName"`
+	UDN          string         `xml:"UDN"`
+	Services     []upnp.Service `xml:"serviceList>service"`
+	Devices      []device       `xml:"deviceList>device"`
+}
+
+// Returns the first device in the tree with an AVTransport service.
+func (me *device) findAVTransport() (*device, *upnp.Service) {
+	for i, s := range me.Services {
+		if strings.HasPrefix(s.ServiceType, serviceTypePrefix) {
+			return me, &me.Services[i]
+		}
+	}
+	for i := range me.Devices {
+		if d, s := me.Devices[i].findAVTransport(); d != nil {
+			return d, s
+		}
+	}
+	return nil, nil
+}
+
+// Parses the device description at the location into a Renderer.
+func parseDeviceDesc(location string, b []byte) (*Renderer, error) {
+	var desc deviceDesc
+	if err := xml.Unmarshal(b, &desc); err != nil {
+		return nil, err
+	}
+	d, s := desc.Device.findAVTransport()
+	if d == nil {
+		return nil, fmt.Errorf("%s has no AVTransport service", location)
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if desc.URLBase != "" {
+		if base, err = url.Parse(desc.URLBase); err != nil {
+			return nil, err
+		}
+	}
+	control, err := base.Parse(strings.TrimSpace(s.ControlURL))
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{
+		UDN:          d.UDN,
+		FriendlyName: d.FriendlyName,
+		Manufacturer: d.Manufacturer,
+		ModelName:    d.ModelName,
+		Location:     location,
+		ServiceType:  s.ServiceType,
+		ControlURL:   control.String(),
+	}, nil
+}
+
+// Fetches and parses the device description at the location.
+func Describe(ctx context.Context, location string) (*Renderer, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", location, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseDeviceDesc(location, b)
+}
+
+// Searches for media renderers on the interfaces for the duration of the
+// context, or the timeout if sooner, returning those with an AVTransport
+// service.
+func Discover(ctx context.Context, ifs []net.Interface, timeout time.Duration) ([]*Renderer, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	mx := max(1, int(timeout/time.Second))
+	msg := []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdp.AddrString + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: " + strconv.Itoa(mx) + "\r\n" +
+		"ST: " + MediaRendererType + "\r\n\r\n")
+	pc := ipv4.NewPacketConn(conn)
+	sent := false
+	for _, if_ := range ifs {
+		if if_.Flags&net.FlagMulticast == 0 || pc.SetMulticastInterface(&if_) != nil {
+			continue
+		}
+		if _, err := conn.WriteTo(msg, ssdp.NetAddr); err == nil {
+			sent = true
+		}
+	}
+	if !sent {
+		// Let the OS pick the interface.
+		if _, err := conn.WriteTo(msg, ssdp.NetAddr); err != nil {
+			return nil, err
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	locations := make(map[string]bool)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		if loc := resp.Header.Get("Location"); loc != "" {
+			locations[loc] = true
+		}
+	}
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		ret []*Renderer
+	)
+	seen := make(map[string]bool)
+	for loc := range locations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := Describe(ctx, loc)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// Renderers on several interfaces answer on each.
+			if !seen[r.UDN] {
+				seen[r.UDN] = true
+				ret = append(ret, r)
+			}
+		}()
+	}
+	wg.Wait()
+	return ret, nil
+}
+
+// Invokes the AVTransport action on instance 0 with the arguments, returning
+// the response's arguments by name.
+func (me *Renderer) call(ctx context.Context, action string, args ...[2]string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s"><InstanceID>0</InstanceID>`, action, me.ServiceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+	req, err := http.NewRequestWithContext(ctx, "POST", me.ControlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, me.ServiceType, action))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault struct {
+			Error upnp.Error `xml:"Body>Fault>detail>UPnPError"`
+		}
+		if xml.Unmarshal(b, &fault) == nil && fault.Error.Code != 0 {
+			return nil, fmt.Errorf("%s on %s: %w", action, me.FriendlyName, &fault.Error)
+		}
+		return nil, fmt.Errorf("%s on %s: %s", action, me.FriendlyName, resp.Status)
+	}
+	var env struct {
+		Body struct {
+			Response struct {
+				Args []struct {
+					XMLName xml.Name
+					Value   string `xml:",chardata"`
+				} `xml:",any"`
+			} `xml:",any"`
+		}
+	}
+	if err := xml.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	ret := make(map[string]string)
+	for _, arg := range env.Body.Response.Args {
+		ret[arg.XMLName.Local] = arg.Value
+	}
+	return ret, nil
+}
+
+// Has the renderer load the URL, described by the DIDL-Lite metadata, which
+// may be empty.
+func (me *Renderer) SetAVTransportURI(ctx context.Context, uri, metadata string) error {
+	_, err := me.call(ctx, "SetAVTransportURI", [2]string{"CurrentURI", uri}, [2]string{"CurrentURIMetaData", metadata})
+	return err
+}
+
+func (me *Renderer) Play(ctx context.Context) error {
+	_, err := me.call(ctx, "Play", [2]string{"Speed", "1"})
+	return err
+}
+
+func (me *Renderer) Pause(ctx context.Context) error {
+	_, err := me.call(ctx, "Pause")
+	return err
+}
+
+func (me *Renderer) Stop(ctx context.Context) error {
+	_, err := me.call(ctx, "Stop")
+	return err
+}
+
+// Seeks to the position in the current track.
+func (me *Renderer) Seek(ctx context.Context, position time.Duration) error {
+	_, err := me.call(ctx, "Seek", [2]string{"Unit", "REL_TIME"}, [2]string{"Target", FormatTime(position)})
+	return err
+}
+
+// What the renderer is playing, and how far it's got.
+type Status struct {
+	// Such as "PLAYING", "PAUSED_PLAYBACK", "STOPPED" or "NO_MEDIA_PRESENT".
+	State    string
+	URI      string
+	Position time.Duration
+	Duration time.Duration
+}
+
+// Returns the transport state and position.
+func (me *Renderer) Status(ctx context.Context) (s Status, err error) {
+	info, err := me.call(ctx, "GetTransportInfo")
+	if err != nil {
+		return
+	}
+	s.State = info["CurrentTransportState"]
+	pos, err := me.call(ctx, "GetPositionInfo")
+	if err != nil {
+		return
+	}
+	s.URI = pos["TrackURI"]
+	// Renderers that don't know give NOT_IMPLEMENTED, which is left as zero.
+	s.Position, _ = ParseTime(pos["RelTime"])
+	s.Duration, _ = ParseTime(pos["TrackDuration"])
+	return s, nil
+}
+
+// Formats a duration as AVTransport times are, as H+:MM:SS.
+func FormatTime(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// Parses an AVTransport time, H+:MM:SS with optional fractions of a second.
+func ParseTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err1 := strconv.ParseUint(parts[0], 10, 32)
+	m, err2 := strconv.ParseUint(parts[1], 10, 8)
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil || m > 59 || sec < 0 || sec >= 60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second)), nil
+}
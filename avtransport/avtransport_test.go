@@ -0,0 +1,131 @@
+package avtransport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rendererDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:MediaRenderer:1</deviceType>
+    <friendlyName>Living Room TV</friendlyName>
+    <manufacturer>Acme</manufacturer>
+    <modelName>TV 3000</modelName>
+    <UDN>uuid:tv</UDN>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:RenderingControl:1</serviceType>
+        <controlURL>/rc</controlURL>
+      </service>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:AVTransport:1</serviceType>
+        <controlURL>upnp/control/avt</controlURL>
+      </service>
+    </serviceList>
+  </device>
+</root>`
+
+// A renderer that records the actions it's sent, and faults seeks.
+func fakeRenderer(t *testing.T, actions *[]string) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/desc.xml":
+			io.WriteString(w, rendererDesc)
+			return
+		case "/upnp/control/avt":
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+		*actions = append(*actions, action+" "+string(b))
+		if strings.HasSuffix(action, `#Seek"`) {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>710</errorCode><errorDescription>Seek mode not supported</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+			return
+		}
+		resp := ""
+		switch {
+		case strings.HasSuffix(action, `#GetTransportInfo"`):
+			resp = `<CurrentTransportState>PLAYING</CurrentTransportState><CurrentTransportStatus>OK</CurrentTransportStatus>`
+		case strings.HasSuffix(action, `#GetPositionInfo"`):
+			resp = `<Track>1</Track><TrackDuration>1:02:03</TrackDuration><TrackURI>http://dms/res</TrackURI><RelTime>0:00:10.500</RelTime><AbsTime>NOT_IMPLEMENTED</AbsTime>`
+		}
+		io.WriteString(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:XResponse xmlns:u="urn:schemas-upnp-org:service:AVTransport:1">`+resp+`</u:XResponse></s:Body></s:Envelope>`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestRenderer(t *testing.T) {
+	var actions []string
+	s := fakeRenderer(t, &actions)
+	ctx := context.Background()
+	r, err := Describe(ctx, s.URL+"/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.UDN != "uuid:tv" || r.FriendlyName != "Living Room TV" || r.ControlURL != s.URL+"/upnp/control/avt" {
+		t.Fatalf("got %+v", r)
+	}
+	if err := r.SetAVTransportURI(ctx, "http://dms/res?path=a&b", `<DIDL-Lite/>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Play(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 ||
+		!strings.HasPrefix(actions[0], `"urn:schemas-upnp-org:service:AVTransport:1#SetAVTransportURI"`) ||
+		!strings.Contains(actions[0], `<CurrentURI>http://dms/res?path=a&amp;b</CurrentURI><CurrentURIMetaData>&lt;DIDL-Lite/&gt;</CurrentURIMetaData>`) ||
+		!strings.Contains(actions[1], `<InstanceID>0</InstanceID><Speed>1</Speed>`) {
+		t.Fatalf("got %q", actions)
+	}
+	err = r.Seek(ctx, 90*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "710 Seek mode not supported") {
+		t.Fatalf("got %v", err)
+	}
+	if !strings.Contains(actions[2], "<Target>0:01:30</Target>") {
+		t.Fatalf("got %q", actions[2])
+	}
+	status, err := r.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != "PLAYING" || status.URI != "http://dms/res" || status.Position != 10500*time.Millisecond || status.Duration != time.Hour+2*time.Minute+3*time.Second {
+		t.Fatalf("got %+v", status)
+	}
+}
+
+func TestNoAVTransport(t *testing.T) {
+	desc := strings.Replace(rendererDesc, "AVTransport", "ConnectionManager", 1)
+	if _, err := parseDeviceDesc("http://tv/desc.xml", []byte(desc)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"0:00:00":     0,
+		"1:02:03":     time.Hour + 2*time.Minute + 3*time.Second,
+		"100:00:01.5": 100*time.Hour + 1500*time.Millisecond,
+	} {
+		if got, err := ParseTime(s); err != nil || got != want {
+			t.Errorf("%q: got %v, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"NOT_IMPLEMENTED", "", "1:60:00", "0:00"} {
+		if _, err := ParseTime(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+	if got := FormatTime(time.Hour + 2*time.Minute + 3400*time.Millisecond); got != "1:02:03" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/anacrolix/dms/dlna/dms"
+	"github.com/anacrolix/dms/dlna/dms/clientprofile"
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/transcode"
+)
+
+// A configuration file with every setting described, for -writeConfig.
+//
+//go:embed data/dms.jsonc
+var configTemplate []byte
+
+// Writes the configuration file template to path, or stdout if it's "-". An
+// existing file isn't overwritten.
+func writeConfigTemplate(path string) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(configTemplate)
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(configTemplate)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Blanks out // comments, and commas before a closing brace or bracket, so
+// that the rest can be decoded as JSON. Offsets are kept, so errors can be
+// placed in the original.
+func stripJSONComments(b []byte) []byte {
+	ret := bytes.Clone(b)
+	inString := false
+	lastComma := -1
+	for i := 0; i < len(ret); i++ {
+		c := ret[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			lastComma = -1
+		case c == '/' && i+1 < len(ret) && ret[i+1] == '/':
+			for ; i < len(ret) && ret[i] != '\n'; i++ {
+				ret[i] = ' '
+			}
+		case c == ',':
+			lastComma = i
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				ret[lastComma] = ' '
+			}
+			lastComma = -1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		default:
+			lastComma = -1
+		}
+	}
+	return ret
+}
+
+// Decodes a configuration file. If strict, unknown settings are an error.
+func decodeConfig(b []byte, config *dmsConfig, strict bool) error {
+	b = stripJSONComments(b)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(config)
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	}
+	if offset >= 0 {
+		line := 1 + bytes.Count(b[:min(offset, int64(len(b)))], []byte("\n"))
+		return fmt.Errorf("line %d: %w", line, err)
+	}
+	return err
+}
+
+// Returns the problems with the configuration file, as found by -checkConfig.
+func checkConfig(path string) (problems []error, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c dmsConfig
+	if err := decodeConfig(b, &c, true); err != nil {
+		return []error{err}, nil
+	}
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, a...))
+	}
+	if c.Path == "" {
+		if len(c.Roots) == 0 {
+			add("path: not set")
+		}
+	} else if fi, err := os.Stat(c.Path); err != nil {
+		add("path: %v", err)
+	} else if !fi.IsDir() {
+		add("path: %q isn't a directory", c.Path)
+	} else {
+		for _, setting := range []struct {
+			name  string
+			paths []string
+		}{{"audiobookPaths", c.AudiobookPaths}, {"warmUpPaths", c.WarmUpPaths}, {"protectedPaths", c.ProtectedPaths}} {
+			for _, p := range setting.paths {
+				if _, err := os.Stat(filepath.Join(c.Path, p)); err != nil {
+					add("%s: %v", setting.name, err)
+				}
+			}
+		}
+	}
+	rootNames := make(map[string]bool)
+	for i, r := range c.Roots {
+		setting := fmt.Sprintf("roots[%d]", i)
+		switch {
+		case r.Name == "" || r.Name == "." || r.Name == ".." || strings.ContainsAny(r.Name, `/\`):
+			add("%s: name %q isn't a folder name", setting, r.Name)
+		case rootNames[r.Name]:
+			add("%s: name %q is used by another root", setting, r.Name)
+		case r.Name == "Torrents" || r.Name == "Recordings":
+			add("%s: name %q is used for %s", setting, r.Name, strings.ToLower(r.Name))
+		}
+		rootNames[r.Name] = true
+		if fi, err := os.Stat(r.Path); err != nil {
+			add("%s: %v", setting, err)
+		} else if !fi.IsDir() {
+			add("%s: %q isn't a directory", setting, r.Path)
+		}
+	}
+	if c.Http != "" {
+		if _, _, err := net.SplitHostPort(c.Http); err != nil {
+			add("http: %v", err)
+		}
+	}
+	if c.IfName != "" {
+		if _, err := net.InterfaceByName(c.IfName); err != nil {
+			add("ifName: %q: %v", c.IfName, err)
+		}
+	}
+	if _, err := template.New("friendlyName").Parse(c.FriendlyName); err != nil {
+		add("friendlyName: %v", err)
+	}
+	if c.NotifyInterval < 0 {
+		add("notifyInterval: negative")
+	}
+	if c.DeviceIcon != "" {
+		if _, err := os.Stat(c.DeviceIcon); err != nil {
+			add("deviceIcon: %v", err)
+		}
+	}
+	for _, size := range c.DeviceIconSizes {
+		for _, s := range strings.Split(size, ":") {
+			if _, err := strconv.Atoi(s); err != nil {
+				add("deviceIconSizes: bad size %q", size)
+				break
+			}
+		}
+	}
+	checkIPs := func(setting, ips string) {
+		if ips == "" {
+			return
+		}
+		for _, el := range strings.Split(ips, ",") {
+			if net.ParseIP(el) == nil {
+				if _, _, err := net.ParseCIDR(el); err != nil {
+					add("%s: %q isn't an IP or CIDR", setting, el)
+				}
+			}
+		}
+	}
+	checkIPs("allowedIps", c.AllowedIps)
+	for i, cr := range c.ClientRoots {
+		setting := fmt.Sprintf("clientRoots[%d]", i)
+		checkIPs(setting, cr.IPs)
+		if len(cr.Paths) == 0 {
+			add("%s: no paths, so the clients see nothing", setting)
+		}
+		for _, p := range cr.Paths {
+			if c.Path == "" {
+				break
+			}
+			if _, err := os.Stat(filepath.Join(c.Path, p)); err != nil {
+				add("%s: %v", setting, err)
+			}
+		}
+	}
+	if len(c.ProtectedPaths) != 0 && c.PIN == "" {
+		add("pin: not set, so protectedPaths are never shown")
+	}
+	if c.ControlRate < 0 || c.ControlBurst < 0 {
+		add("controlRate and controlBurst: negative")
+	}
+	for _, o := range c.CORSOrigins {
+		if o == "*" {
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			add("corsOrigins: %q isn't * or an origin like https://example.com", o)
+		}
+	}
+	for _, m := range c.CORSMethods {
+		if m == "" || strings.ToUpper(m) != m || strings.ContainsAny(m, " ,") {
+			add("corsMethods: %q isn't an HTTP method like GET", m)
+		}
+	}
+	if c.RemoteAccess {
+		if c.RemotePassword == "" {
+			add("remotePassword: not set, so remote access can't start")
+		}
+		if c.RemoteHttp != "" {
+			if _, _, err := net.SplitHostPort(c.RemoteHttp); err != nil {
+				add("remoteHttp: %v", err)
+			} else if c.RemoteHttp == c.Http {
+				add("remoteHttp: the same as http")
+			}
+		}
+		if (c.RemoteCert == "") != (c.RemoteKey == "") {
+			add("remoteCert and remoteKey: only one is set")
+		}
+		if c.BanThreshold == 0 {
+			add("banThreshold: not set, so clients on the internet can guess the remotePassword forever")
+		}
+	}
+	if c.Https != "" {
+		if _, _, err := net.SplitHostPort(c.Https); err != nil {
+			add("https: %v", err)
+		} else if c.Https == c.Http || c.Https == c.RemoteHttp {
+			add("https: the same as http or remoteHttp")
+		}
+		if (c.HttpsCert == "") != (c.HttpsKey == "") {
+			add("httpsCert and httpsKey: only one is set")
+		}
+	} else if c.AdvertiseHttps {
+		add("advertiseHttps: https not set")
+	}
+	if c.BanThreshold < 0 || c.BanDuration < 0 {
+		add("banThreshold and banDuration: negative")
+	}
+	if c.FSTimeout < 0 {
+		add("fsTimeout: negative")
+	}
+	if c.SlideshowDuration < 0 {
+		add("slideshowDuration: negative")
+	}
+	if c.UnlockDuration < 0 {
+		add("unlockDuration: negative")
+	}
+	if c.FFprobeCacheMaxSize < 0 {
+		add("ffprobeCacheMaxSize: negative")
+	}
+	if c.TranscodeLogMaxAge < 0 || c.TranscodeLogMaxSize < 0 {
+		add("transcodeLogMaxAge and transcodeLogMaxSize: negative")
+	}
+	if c.GeoNamesPath != "" && c.NoSearch {
+		add("geoNamesPath: photos are located from the search index, which noSearch disables")
+	}
+	if len(c.Collections) != 0 && c.NoSearch {
+		add("collections: items are found in the search index, which noSearch disables")
+	}
+	for i, col := range c.Collections {
+		name := col.Title
+		if name == "" {
+			add("collections[%d]: no title", i)
+			name = strconv.Itoa(i)
+		}
+		if strings.TrimSpace(col.Criteria) != "" {
+			if err := search.CheckCriteria(col.Criteria); err != nil {
+				add("collections: %q: criteria: %v", name, err)
+			}
+		}
+		if _, err := filepath.Match(col.Path, ""); err != nil {
+			add("collections: %q: path %q: %v", name, col.Path, err)
+		}
+	}
+	if c.TorrentWatchDir != "" && c.TorrentDataDir == "" {
+		add("torrentWatchDir: torrentDataDir not set, so no torrents are added")
+	}
+	for i, ch := range c.Channels {
+		if ch.Name == "" {
+			add("channels: %d: no name", i)
+		}
+		if u, err := url.Parse(ch.URL); err != nil || u.Host == "" {
+			add("channels: %q: bad url %q", ch.Name, ch.URL)
+		}
+	}
+	if (len(c.Channels) != 0 || len(c.ChannelSources) != 0) && c.RecordingsDir == "" {
+		add("channels and channelSources: recordingsDir not set, so nothing is recorded")
+	}
+	if c.ForceTranscodeTo != "" && !slices.Contains(dms.TranscodeNames(), c.ForceTranscodeTo) {
+		add("forceTranscodeTo: unknown transcode %q, want one of %q", c.ForceTranscodeTo, dms.TranscodeNames())
+	}
+	if primary, _, _ := strings.Cut(strings.ToLower(c.Language), "-"); c.Language != "" && !slices.Contains(dms.Languages(), primary) {
+		add("language: unsupported language %q, want one of %q", c.Language, dms.Languages())
+	}
+	if c.WarmUpRecent < 0 || c.WarmUpConcurrency < 0 {
+		add("warmUpRecent and warmUpConcurrency: negative")
+	}
+	checkMimeTypes := func(setting string, m map[string]string) {
+		for ext, mt := range m {
+			if strings.TrimPrefix(ext, ".") == "" {
+				add("%s: empty extension", setting)
+			}
+			if _, _, err := mime.ParseMediaType(mt); err != nil || !strings.Contains(mt, "/") {
+				add("%s: %q isn't a MIME-type", setting, mt)
+			}
+		}
+	}
+	checkMimeTypes("mimeTypes", c.MimeTypes)
+	names := make(map[string]bool)
+	for i, p := range c.ClientProfiles {
+		name := p.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		} else if names[name] {
+			add("clientProfiles: %q: duplicate name", name)
+		}
+		names[name] = true
+		if p.UserAgent == "" {
+			add("clientProfiles: %q: userAgent not set, so it matches nothing", name)
+		}
+		for kind := range p.DLNAFlags {
+			if _, ok := clientprofile.DefaultDLNAFlags[kind]; !ok {
+				add("clientProfiles: %q: unknown resource kind %q in dlnaFlags", name, kind)
+			}
+		}
+		if p.ReplayGain != "" && p.ReplayGain != "track" && p.ReplayGain != "album" {
+			add("clientProfiles: %q: replayGain %q isn't \"track\" or \"album\"", name, p.ReplayGain)
+		}
+		if _, ok := clientprofile.RatingAge(p.MaxRating); p.MaxRating != "" && !ok {
+			add("clientProfiles: %q: unknown maxRating %q", name, p.MaxRating)
+		}
+		if p.MaxSampleRate < 0 || p.MaxBitDepth < 0 {
+			add("clientProfiles: %q: negative maxSampleRate or maxBitDepth", name)
+		}
+		checkMimeTypes(fmt.Sprintf("clientProfiles: %q: mimeTypes", name), p.MimeTypes)
+		for _, lang := range slices.Concat(p.AudioLanguages, p.SubtitleLanguages) {
+			if len(lang) != 2 && len(lang) != 3 {
+				add("clientProfiles: %q: %q isn't a two or three letter ISO 639 code", name, lang)
+			}
+		}
+		switch p.Deinterlace {
+		case "", clientprofile.DeinterlaceBWDIF, clientprofile.DeinterlaceYADIF, clientprofile.DeinterlaceOff:
+		default:
+			add("clientProfiles: %q: deinterlace %q isn't \"bwdif\", \"yadif\" or \"off\"", name, p.Deinterlace)
+		}
+		switch p.TranscodeDelivery {
+		case "", clientprofile.DeliveryChunked, clientprofile.DeliveryClose, clientprofile.DeliveryLength:
+		default:
+			add("clientProfiles: %q: transcodeDelivery %q isn't \"chunked\", \"close\" or \"length\"", name, p.TranscodeDelivery)
+		}
+		switch p.View {
+		case "", clientprofile.ViewFolders, clientprofile.ViewTypes, clientprofile.ViewBoth:
+		default:
+			add("clientProfiles: %q: view %q isn't \"folders\", \"types\" or \"both\"", name, p.View)
+		}
+		for _, r := range p.Resources {
+			if r != "raw" && r != "hls" && !slices.Contains(dms.TranscodeNames(), r) {
+				add("clientProfiles: %q: unknown resource %q, want \"raw\", \"hls\" or one of %q", name, r, dms.TranscodeNames())
+			}
+		}
+		if p.NoSubtitles && len(p.SubtitleLanguages) != 0 {
+			add("clientProfiles: %q: subtitleLanguages are ignored with noSubtitles", name)
+		}
+	}
+	encoders := make(map[string]bool)
+	for i, e := range c.Encoders {
+		name := e.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		} else if encoders[name] {
+			add("encoders: %q: duplicate name", name)
+		}
+		encoders[name] = true
+		if !transcode.KnownHWEncoderAPI(e.API) {
+			add("encoders: %q: unknown api %q, want one of %q", name, e.API, []string{transcode.VAAPI, transcode.NVENC, transcode.QSV})
+		}
+		if e.MaxSessions < 0 {
+			add("encoders: %q: negative maxSessions", name)
+		}
+		for _, ts := range e.Transcodes {
+			if !slices.Contains(dms.EncoderTranscodes(), ts) {
+				add("encoders: %q: transcode %q doesn't encode H.264, want one of %q", name, ts, dms.EncoderTranscodes())
+			}
+		}
+	}
+	if c.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("otlpEndpoint: %q isn't an http or https URL", c.OTLPEndpoint)
+		}
+	}
+	if c.LastFM != nil && (c.LastFM.APIKey == "" || c.LastFM.Secret == "") {
+		add("lastFM: apiKey and secret are needed")
+	}
+	if c.ListenBrainz != nil && c.ListenBrainz.Token == "" {
+		add("listenBrainz: token not set")
+	}
+	return
+}
+
+// Returns a copy of the config that doesn't share its slices, so loading a
+// configuration file into one leaves the other as it was.
+func (config *dmsConfig) clone() *dmsConfig {
+	c := *config
+	c.DeviceIconSizes = slices.Clone(c.DeviceIconSizes)
+	c.IgnorePaths = slices.Clone(c.IgnorePaths)
+	c.AllowedIpNets = slices.Clone(c.AllowedIpNets)
+	c.ClientProfiles = slices.Clone(c.ClientProfiles)
+	c.MimeTypes = maps.Clone(c.MimeTypes)
+	c.Encoders = slices.Clone(c.Encoders)
+	c.AudiobookPaths = slices.Clone(c.AudiobookPaths)
+	c.ProtectedPaths = slices.Clone(c.ProtectedPaths)
+	c.CORSOrigins = slices.Clone(c.CORSOrigins)
+	c.CORSMethods = slices.Clone(c.CORSMethods)
+	c.ClientRoots = slices.Clone(c.ClientRoots)
+	c.WarmUpPaths = slices.Clone(c.WarmUpPaths)
+	c.Collections = slices.Clone(c.Collections)
+	c.Channels = slices.Clone(c.Channels)
+	c.ChannelSources = slices.Clone(c.ChannelSources)
+	c.Roots = slices.Clone(c.Roots)
+	return &c
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/dms/dlna/dms"
+)
+
+func TestStripJSONComments(t *testing.T) {
+	var c dmsConfig
+	err := decodeConfig([]byte(`{
+  // A comment.
+  "path": "http://not/a/comment", // Another.
+  "ignorePaths": ["a", "b",],
+}`), &c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Path != "http://not/a/comment" || len(c.IgnorePaths) != 2 {
+		t.Errorf("got %+v", c)
+	}
+	err = decodeConfig([]byte("{\n\"path\": \"x\",\n\"noProbe\": 1\n}"), &c, true)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3: ") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// Every setting in the template is valid once uncommented.
+func TestConfigTemplate(t *testing.T) {
+	uncomment := regexp.MustCompile(`(?m)^(\s*)// (\s*["{}\[\]])`)
+	b := uncomment.ReplaceAll(configTemplate, []byte("$1$2"))
+	dir := t.TempDir()
+	b = []byte(strings.ReplaceAll(string(b), `"/path/to/media"`, `"`+dir+`"`))
+	b = []byte(strings.ReplaceAll(string(b), `"/mnt/`, `"`+dir+`/mnt/`))
+	for _, p := range []string{"Audiobooks", "Music/New", "Private", "Public", "mnt/movies", "mnt/music"} {
+		if err := os.MkdirAll(filepath.Join(dir, p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var c dmsConfig
+	if err := decodeConfig(b, &c, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.ClientProfiles) != 1 || c.LastFM == nil || c.WarmUpConcurrency != 2 || len(c.Encoders) != 2 || c.Encoders[1].API != "nvenc" || c.TorrentWatchDir == "" {
+		t.Errorf("got %+v", c)
+	}
+	for _, name := range dms.TranscodeNames() {
+		if !strings.Contains(string(configTemplate), `"`+name+`"`) {
+			t.Errorf("transcode %q not described", name)
+		}
+	}
+
+	path := filepath.Join(dir, "dms.json")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := checkConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The examples that can't be valid everywhere.
+	var got []string
+	for _, p := range problems {
+		got = append(got, strings.SplitN(p.Error(), ":", 2)[0])
+	}
+	slices.Sort(got)
+	if want := []string{"deviceIcon", "lastFM", "listenBrainz"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", problems, want)
+	}
+}
+
+func TestMakeIpNets(t *testing.T) {
+	nets := makeIpNets("192.168.1.5,fd00::5,10.0.0.0/8")
+	allowed := func(ip string) bool {
+		for _, n := range nets {
+			if n.Contains(net.ParseIP(ip)) {
+				return true
+			}
+		}
+		return false
+	}
+	for ip, want := range map[string]bool{
+		"192.168.1.5": true,
+		"192.168.1.6": false,
+		"fd00::5":     true,
+		"fd00::6":     false,
+		"10.1.2.3":    true,
+	} {
+		if got := allowed(ip); got != want {
+			t.Errorf("%s: got %v", ip, got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna/dms"
+)
+
+// How long streams and other requests in progress are given to finish when
+// dms is told to stop.
+const shutdownTimeout = 10 * time.Second
+
+// How often the TorrentWatchDir is checked for .torrent files.
+const torrentWatchInterval = 10 * time.Second
+
+// How long a ChannelSource is given to list its channels.
+const channelFetchTimeout = 10 * time.Second
+
+// Returns the configured channels, followed by those listed by the sources,
+// such as HDHomeRun tuners and IPTV playlists. Sources that can't be reached
+// are logged and skipped.
+func recordingChannels(logger log.Logger, channels []dms.Channel, sources []string) []dms.Channel {
+	ret := slices.Clone(channels)
+	for _, source := range sources {
+		ctx, cancel := context.WithTimeout(context.Background(), channelFetchTimeout)
+		cs, err := dms.FetchChannels(ctx, source)
+		cancel()
+		if err != nil {
+			logger.Printf("fetching channels: %v", err)
+			continue
+		}
+		logger.Printf("found %d channels at %q", len(cs), source)
+		ret = append(ret, cs...)
+	}
+	return ret
+}
+
+// Writes the process ID to the file, unless it names another dms that's still
+// running.
+func writePidFile(path string) error {
+	if b, err := os.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+		if err == nil && pid != os.Getpid() && processExists(pid) {
+			return fmt.Errorf("already running as pid %d, according to %s", pid, path)
+		}
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
+
+func logStats(logger log.Logger, s dms.Stats) {
+	cacheItems := func(n int) string {
+		if n < 0 {
+			return "unknown"
+		}
+		return strconv.Itoa(n)
+	}
+	logger.Printf("up %v, serving %d streams", s.Uptime.Round(time.Second), s.Streams)
+	logger.Printf("cached: %s probe results, %s thumbnails, %s DIDL-Lite items",
+		cacheItems(s.FFProbeCacheItems), cacheItems(s.ThumbnailCacheItems), cacheItems(s.DIDLCacheItems))
+	logger.Printf("%d documents in the search index, %d directories in the library", s.SearchDocuments, s.LibraryDirectories)
+	logger.Printf("%d transcodes running, %d ended (%d failed) using %v of CPU time and sending %d bytes",
+		s.RunningTranscodes, s.Transcodes.Sessions, s.Transcodes.Failures,
+		time.Duration(s.Transcodes.CPUTime*float64(time.Second)).Round(time.Second), s.Transcodes.Bytes)
+	for _, name := range slices.Sorted(maps.Keys(s.EncoderSessions)) {
+		logger.Printf("encoder %s: %d transcodes", name, s.EncoderSessions[name])
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// Reloads the configuration file.
+	reloadSignal os.Signal = syscall.SIGHUP
+	// Logs the server's stats.
+	statusSignal os.Signal = syscall.SIGUSR1
+	// Handled as well as interrupts and SIGTERM.
+	daemonSignals = []os.Signal{reloadSignal, statusSignal}
+)
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+)
+
+// Windows has no signals for these.
+var (
+	reloadSignal  os.Signal
+	statusSignal  os.Signal
+	daemonSignals []os.Signal
+)
+
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
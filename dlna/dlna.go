@@ -2,6 +2,7 @@ package dlna
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -10,13 +11,45 @@ const (
 	TimeSeekRangeDomain   = "TimeSeekRange.dlna.org"
 	ContentFeaturesDomain = "contentFeatures.dlna.org"
 	TransferModeDomain    = "transferMode.dlna.org"
+	PlaySpeedDomain       = "PlaySpeed.dlna.org"
+	RealTimeInfoDomain    = "realTimeInfo.dlna.org"
+	// Requests availableSeekRange.dlna.org in the response.
+	GetAvailableSeekRangeDomain = "getAvailableSeekRange.dlna.org"
+	AvailableSeekRangeDomain    = "availableSeekRange.dlna.org"
 )
 
+// realTimeInfo.dlna.org value for content with an unbounded transport lag,
+// which is what live and growing content is.
+const RealTimeInfoUnboundedLag = "DLNA.ORG_TLAG=*"
+
+// DLNA.ORG_FLAGS bits. Only the primary flags are defined, the reserved
+// remainder is always zero.
+const (
+	FlagSenderPaced             = 1 << 31
+	FlagTimeBasedSeek           = 1 << 30
+	FlagByteBasedSeek           = 1 << 29
+	FlagPlayContainer           = 1 << 28
+	FlagS0Increase              = 1 << 27
+	FlagSNIncrease              = 1 << 26
+	FlagRTSPPause               = 1 << 25
+	FlagStreamingTransferMode   = 1 << 24
+	FlagInteractiveTransferMode = 1 << 23
+	FlagBackgroundTransferMode  = 1 << 22
+	FlagConnectionStall         = 1 << 21
+	FlagDLNAV15                 = 1 << 20
+)
+
+// Formats primary flags as a DLNA.ORG_FLAGS value.
+func FormatFlags(flags uint32) string {
+	return fmt.Sprintf("%08X%024d", flags, 0)
+}
+
 type ContentFeatures struct {
 	ProfileName     string
 	SupportTimeSeek bool
 	SupportRange    bool
-	// Play speeds, DLNA.ORG_PS would go here if supported.
+	// Server-side play speeds other than normal, e.g. "-2", "1/2", "4".
+	PlaySpeeds []string
 	Transcoded bool
 	// DLNA.ORG_FLAGS go here if you need to tweak.
 	Flags string
@@ -33,24 +66,76 @@ func BinaryInt(b bool) uint {
 // flags are in hex. trailing 24 zeroes, 26 are after the space
 // "DLNA.ORG_OP=" time-seek-range-supp bytes-range-header-supp
 func (cf ContentFeatures) String() (ret string) {
+	// This is built for every resource of every item browsed, so avoids fmt.
+	var b strings.Builder
+	b.Grow(128)
 	// DLNA.ORG_PN=[a-zA-Z0-9_]*
-	params := make([]string, 0, 3)
 	if cf.ProfileName != "" {
-		params = append(params, "DLNA.ORG_PN="+cf.ProfileName)
+		b.WriteString("DLNA.ORG_PN=")
+		b.WriteString(cf.ProfileName)
+		b.WriteByte(';')
+	}
+	b.WriteString("DLNA.ORG_OP=")
+	b.WriteByte(binaryDigit(cf.SupportTimeSeek))
+	b.WriteByte(binaryDigit(cf.SupportRange))
+	if len(cf.PlaySpeeds) != 0 {
+		b.WriteString(";DLNA.ORG_PS=")
+		b.WriteString(strings.Join(cf.PlaySpeeds, ","))
 	}
-	params = append(params, fmt.Sprintf(
-		"DLNA.ORG_OP=%b%b;DLNA.ORG_CI=%b",
-		BinaryInt(cf.SupportTimeSeek),
-		BinaryInt(cf.SupportRange),
-		BinaryInt(cf.Transcoded)))
+	b.WriteString(";DLNA.ORG_CI=")
+	b.WriteByte(binaryDigit(cf.Transcoded))
 	// https://stackoverflow.com/questions/29182754/c-dlna-generate-dlna-org-flags
 	// DLNA_ORG_FLAG_STREAMING_TRANSFER_MODE | DLNA_ORG_FLAG_BACKGROUND_TRANSFERT_MODE | DLNA_ORG_FLAG_CONNECTION_STALL | DLNA_ORG_FLAG_DLNA_V15
 	flags := "01700000000000000000000000000000"
 	if cf.Flags != "" {
 		flags = cf.Flags
 	}
-	params = append(params, "DLNA.ORG_FLAGS="+flags)
-	return strings.Join(params, ";")
+	b.WriteString(";DLNA.ORG_FLAGS=")
+	b.WriteString(flags)
+	return b.String()
+}
+
+func binaryDigit(b bool) byte {
+	return '0' + byte(BinaryInt(b))
+}
+
+// Returns an HTTP protocolInfo for resources of the MIME type with the
+// content features.
+func HTTPProtocolInfo(mimeType string, cf ContentFeatures) string {
+	return "http-get:*:" + mimeType + ":" + cf.String()
+}
+
+// Parses a PlaySpeed.dlna.org header value, such as "speed=-1/2".
+func ParsePlaySpeed(s string) (speed float64, err error) {
+	if !strings.HasPrefix(s, "speed=") {
+		err = fmt.Errorf("invalid play speed: %s", s)
+		return
+	}
+	s = s[len("speed="):]
+	numStr, denStr, isFraction := strings.Cut(s, "/")
+	num, err := strconv.Atoi(numStr)
+	if err != nil {
+		return
+	}
+	if isFraction {
+		var den int
+		den, err = strconv.Atoi(denStr)
+		if err != nil {
+			return
+		}
+		if den <= 0 {
+			err = fmt.Errorf("invalid play speed: %s", s)
+			return
+		}
+		speed = float64(num) / float64(den)
+		return
+	}
+	if num == 0 {
+		err = fmt.Errorf("invalid play speed: %s", s)
+		return
+	}
+	speed = float64(num)
+	return
 }
 
 func ParseNPTTime(s string) (time.Duration, error) {
@@ -109,3 +194,13 @@ func (me NPTRange) String() (ret string) {
 	}
 	return
 }
+
+// Formats an availableSeekRange.dlna.org value for limited random access mode
+// 1, where the seekable range may grow. A negative size omits the byte range.
+func FormatAvailableSeekRange(npt NPTRange, size int64) string {
+	ret := fmt.Sprintf("1 npt=%s-%s", FormatNPTTime(npt.Start), FormatNPTTime(npt.End))
+	if size >= 0 {
+		ret += fmt.Sprintf(" bytes=0-%d", max(size-1, 0))
+	}
+	return ret
+}
@@ -2,6 +2,7 @@ package dlna
 
 import (
 	"testing"
+	"time"
 )
 
 func TestContentFeaturesString(t *testing.T) {
@@ -14,3 +15,56 @@ func TestContentFeaturesString(t *testing.T) {
 		t.Fatal(a)
 	}
 }
+
+func TestContentFeaturesPlaySpeeds(t *testing.T) {
+	a := ContentFeatures{
+		ProfileName:     "MPEG_PS_PAL",
+		SupportTimeSeek: true,
+		PlaySpeeds:      []string{"-2", "2"},
+		Transcoded:      true,
+	}.String()
+	e := "DLNA.ORG_PN=MPEG_PS_PAL;DLNA.ORG_OP=10;DLNA.ORG_PS=-2,2;DLNA.ORG_CI=1;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+	if e != a {
+		t.Fatal(a)
+	}
+}
+
+func TestParsePlaySpeed(t *testing.T) {
+	for s, e := range map[string]float64{
+		"speed=2":    2,
+		"speed=-8":   -8,
+		"speed=1/2":  0.5,
+		"speed=-1/4": -0.25,
+	} {
+		a, err := ParsePlaySpeed(s)
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if a != e {
+			t.Fatalf("%s: expected %v, got %v", s, e, a)
+		}
+	}
+	for _, s := range []string{"2", "speed=", "speed=0", "speed=1/0", "speed=2abc", "speed=1/2x", "speed=1/2/3"} {
+		if _, err := ParsePlaySpeed(s); err == nil {
+			t.Fatalf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestFormatFlags(t *testing.T) {
+	a := FormatFlags(FlagStreamingTransferMode | FlagBackgroundTransferMode | FlagConnectionStall | FlagDLNAV15)
+	if e := "01700000000000000000000000000000"; e != a {
+		t.Fatal(a)
+	}
+}
+
+func TestFormatAvailableSeekRange(t *testing.T) {
+	a := FormatAvailableSeekRange(NPTRange{End: 90 * time.Second}, 1000)
+	if e := "1 npt=00:00:00.000-00:01:30.000 bytes=0-999"; e != a {
+		t.Fatal(a)
+	}
+	a = FormatAvailableSeekRange(NPTRange{End: time.Second}, -1)
+	if e := "1 npt=00:00:00.000-00:00:01.000"; e != a {
+		t.Fatal(a)
+	}
+}
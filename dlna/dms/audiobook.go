@@ -0,0 +1,68 @@
+package dms
+
+import (
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// A file with this name marks the folder containing it as an audiobook.
+const audiobookMarker = ".audiobook"
+
+// Returns the folder of the audiobook containing the file, if it's in one.
+// Folders are audiobooks if they're listed in Server.AudiobookPaths, or
+// contain an audiobookMarker file.
+func (me *Server) audiobookRoot(filePath string) (string, bool) {
+	for dir := path.Dir(path.Clean(filePath)); ; dir = path.Dir(dir) {
+		if me.isAudiobookPath(dir) {
+			return dir, true
+		}
+		if _, err := fs.Stat(me.FS, path.Join(dir, audiobookMarker)); err == nil {
+			return dir, true
+		}
+		if dir == "." || dir == "/" {
+			return "", false
+		}
+	}
+}
+
+func (me *Server) isAudiobookPath(dir string) bool {
+	for _, p := range me.AudiobookPaths {
+		if p != "" && path.Clean(strings.TrimPrefix(p, "/")) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func (me *Server) isAudiobook(filePath string) bool {
+	_, ok := me.audiobookRoot(filePath)
+	return ok
+}
+
+// Returns the file to carry on with for each audiobook the client has been
+// listening to, most recent first. That's the last file played, or the next
+// one if it was finished.
+func (me *Server) continueListening(client string) (paths []string) {
+	if me.Playback == nil {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, e := range me.Playback.recent(client) {
+		book, ok := me.audiobookRoot(e.Path)
+		if !ok || seen[book] {
+			continue
+		}
+		seen[book] = true
+		if !e.Finished() {
+			paths = append(paths, e.Path)
+			continue
+		}
+		tracks, _ := me.streamTracks(book)
+		if i := slices.Index(tracks, e.Path); i >= 0 && i+1 < len(tracks) {
+			paths = append(paths, tracks[i+1])
+		}
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package dms
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+// Query parameter of transcode resources picking the audio track, counting
+// from 1.
+const audioTrackQueryKey = "audioTrack"
+
+// ISO 639-1 codes of common languages, and the ISO 639-2 codes ffmpeg tags
+// streams with. Some languages have both a bibliographic and a terminology
+// code.
+var iso639 = map[string][]string{
+	"ar": {"ara"},
+	"cs": {"cze", "ces"},
+	"da": {"dan"},
+	"de": {"ger", "deu"},
+	"el": {"gre", "ell"},
+	"en": {"eng"},
+	"es": {"spa"},
+	"fi": {"fin"},
+	"fr": {"fre", "fra"},
+	"he": {"heb"},
+	"hi": {"hin"},
+	"hu": {"hun"},
+	"it": {"ita"},
+	"ja": {"jpn"},
+	"ko": {"kor"},
+	"nl": {"dut", "nld"},
+	"no": {"nor"},
+	"pl": {"pol"},
+	"pt": {"por"},
+	"ru": {"rus"},
+	"sv": {"swe"},
+	"tr": {"tur"},
+	"uk": {"ukr"},
+	"zh": {"chi", "zho"},
+}
+
+// Returns the ISO 639 codes of the language with the given code, in lower
+// case, starting with it.
+func languageCodes(lang string) []string {
+	lang = strings.ToLower(lang)
+	for one, twos := range iso639 {
+		if codes := append([]string{one}, twos...); slices.Contains(codes, lang) {
+			return append([]string{lang}, slices.DeleteFunc(codes, func(c string) bool { return c == lang })...)
+		}
+	}
+	return []string{lang}
+}
+
+// Reports whether two ISO 639 codes, of either kind, are the same language.
+func sameLanguage(a, b string) bool {
+	return a != "" && slices.Contains(languageCodes(a), strings.ToLower(b))
+}
+
+// Returns the file's streams of the type, such as "audio", in order.
+func streamsOfType(info *ffprobe.Info, codecType string) (ret []map[string]interface{}) {
+	if info == nil {
+		return
+	}
+	for _, s := range info.Streams {
+		if s["codec_type"] == codecType {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+func audioStreams(info *ffprobe.Info) []map[string]interface{} {
+	return streamsOfType(info, "audio")
+}
+
+// Returns the audio stream for the track, counting from 1, or the first if
+// the track is zero. It's nil if there's no such stream.
+func audioStream(info *ffprobe.Info, track int) map[string]interface{} {
+	streams := audioStreams(info)
+	if track == 0 {
+		track = 1
+	}
+	if track > len(streams) {
+		return nil
+	}
+	return streams[track-1]
+}
+
+func streamTag(s map[string]interface{}, name string) string {
+	tags, _ := s["tags"].(map[string]interface{})
+	v, _ := tags[name].(string)
+	return v
+}
+
+// Returns the audio track to transcode for the request: the one in the
+// audioTrack query parameter, or failing that, the first in the client's
+// preferred AudioLanguages, or the one marked as the default. It's zero if
+// the file has fewer than two tracks, or none is picked.
+func (me *Server) audioTrack(r *http.Request, info *ffprobe.Info) int {
+	streams := audioStreams(info)
+	if len(streams) < 2 {
+		return 0
+	}
+	if track, err := strconv.Atoi(r.URL.Query().Get(audioTrackQueryKey)); err == nil && track >= 1 && track <= len(streams) {
+		return track
+	}
+	if p := me.clientProfile(r.UserAgent()); p != nil {
+		for _, lang := range p.AudioLanguages {
+			for i, s := range streams {
+				if sameLanguage(lang, streamTag(s, "language")) {
+					return i + 1
+				}
+			}
+		}
+	}
+	for i, s := range streams {
+		if disposition, _ := s["disposition"].(map[string]interface{}); streamInt(disposition, "default") == 1 {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package dms
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func TestAudioTrack(t *testing.T) {
+	s := &Server{
+		ClientProfiles: []ClientProfile{
+			{UserAgent: "FrenchTV", AudioLanguages: []string{"fr", "en"}},
+			{UserAgent: "GermanTV", AudioLanguages: []string{"de"}},
+		},
+	}
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[
+		{"index":0,"codec_type":"video"},
+		{"index":1,"codec_type":"audio","channels":6,"tags":{"language":"eng"}},
+		{"index":2,"codec_type":"audio","channels":2,"tags":{"language":"fre"},"disposition":{"default":0}},
+		{"index":3,"codec_type":"audio","channels":2,"tags":{"language":"jpn"},"disposition":{"default":1}}
+	]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		userAgent, query string
+		want             int
+	}{
+		{"FrenchTV", "", 2},
+		{"FrenchTV", "?audioTrack=1", 1},
+		// Out of range.
+		{"FrenchTV", "?audioTrack=4", 2},
+		{"GermanTV", "", 3},
+		{"OtherTV", "", 3},
+	} {
+		r := httptest.NewRequest("GET", "/res"+c.query, nil)
+		r.Header.Set("User-Agent", c.userAgent)
+		if track := s.audioTrack(r, &info); track != c.want {
+			t.Errorf("%s%s: got track %d, want %d", c.userAgent, c.query, track, c.want)
+		}
+	}
+	if opts := s.clientAudioOptions("FrenchTV", &info, 2); opts.Track != 2 {
+		t.Errorf("got %+v", opts)
+	}
+	if !sameLanguage("FR", "fra") || !sameLanguage("ger", "deu") || sameLanguage("en", "fre") || sameLanguage("", "") {
+		t.Error("languages matched wrongly")
+	}
+}
@@ -0,0 +1,154 @@
+package dms
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+// What an audit found wrong with a file.
+type AuditProblem string
+
+const (
+	// Not offered to clients at all.
+	AuditIgnored AuditProblem = "ignored"
+	// ffprobe couldn't read it, so it's offered without metadata.
+	AuditProbeFailed AuditProblem = "probe failed"
+	// Offered with the device icon in place of a thumbnail.
+	AuditNoThumbnail AuditProblem = "no thumbnail"
+	// Transcoded or remuxed for the client rather than served as it is.
+	AuditTranscoded AuditProblem = "transcoded"
+)
+
+// A problem found with a file by Audit.
+type AuditFinding struct {
+	// The path relative to the root.
+	Path    string
+	Problem AuditProblem
+	Reason  string
+}
+
+func (me AuditFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", me.Problem, me.Path, me.Reason)
+}
+
+// Walks the library, and reports each file that would be ignored, fails
+// probing, lacks a thumbnail, or is transcoded for the client with the given
+// User-Agent, as browsing would find them. It doesn't need SSDP, but unless
+// NoProbe is set, the HTTP server must be running, as files are probed through
+// it.
+func (me *Server) Audit(ctx context.Context, userAgent string, report func(AuditFinding)) error {
+	_, thumbnailerErr := exec.LookPath("ffmpegthumbnailer")
+	return fs.WalkDir(me.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == "." {
+				return err
+			}
+			report(AuditFinding{p, AuditIgnored, err.Error()})
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		reason, err := me.ignoreReason(p)
+		if err != nil {
+			reason = err.Error()
+		}
+		if reason != "" {
+			report(AuditFinding{p, AuditIgnored, reason})
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			report(AuditFinding{p, AuditIgnored, "non-regular file"})
+			return nil
+		}
+		isDmsMetadata := strings.HasSuffix(p, dmsMetadataSuffix)
+		if isDmsMetadata && me.AllowDynamicStreams {
+			return nil
+		}
+		mt, err := me.mimeTypeByPath(p)
+		if err != nil {
+			report(AuditFinding{p, AuditIgnored, err.Error()})
+			return nil
+		}
+		if !mt.IsMedia() {
+			reason := fmt.Sprintf("non-media file (%s)", mt)
+			if isDmsMetadata {
+				reason = "dynamic streams aren't allowed"
+			}
+			report(AuditFinding{p, AuditIgnored, reason})
+			return nil
+		}
+		var info *ffprobe.Info
+		if !me.NoProbe {
+			info, err = me.ffmpegProbe(ctx, p)
+			if err == ffprobe.ExeNotFound {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err != nil {
+				report(AuditFinding{p, AuditProbeFailed, err.Error()})
+			} else if info == nil {
+				report(AuditFinding{p, AuditProbeFailed, "failed earlier, and the file hasn't changed since"})
+			}
+		}
+		switch {
+		case thumbnailerErr != nil:
+			report(AuditFinding{p, AuditNoThumbnail, "ffmpegthumbnailer isn't installed"})
+		case mt.IsAudio() && info != nil && firstStream(info, "video") == nil:
+			report(AuditFinding{p, AuditNoThumbnail, "no embedded cover art"})
+		}
+		if me.NoTranscode {
+			return nil
+		}
+		for _, reason := range me.transcodeReasons(p, mt, userAgent, info) {
+			report(AuditFinding{p, AuditTranscoded, reason})
+		}
+		return nil
+	})
+}
+
+// Returns why a file is transcoded or remuxed when served to the client with
+// the User-Agent.
+func (me *Server) transcodeReasons(p string, mt mimeType, userAgent string, info *ffprobe.Info) (ret []string) {
+	if mt.IsAudio() {
+		if _, exceeds := me.audioCaps(userAgent, info); exceeds {
+			ret = append(ret, "exceeds the client's sample rate or bit depth")
+		} else if me.adjustsAudio(userAgent, info) {
+			ret = append(ret, "ReplayGain is applied")
+		}
+	}
+	if mt.IsVideo() && me.onlyTranscodesVideo(userAgent, info) {
+		codec, _ := me.undecodableVideo(userAgent, info)
+		ret = append(ret, fmt.Sprintf("the client doesn't decode %s video", codec))
+	}
+	if mt.IsDSD() {
+		if profile := me.clientProfile(userAgent); profile != nil && profile.DoP {
+			ret = append(ret, "DSD is sent as DoP")
+		} else {
+			ret = append(ret, "DSD is decoded to PCM")
+		}
+	}
+	if me.ForceTranscodeTo != "" && transcodes[me.ForceTranscodeTo].appliesTo(mt) {
+		ret = append(ret, "forced to "+me.ForceTranscodeTo)
+	}
+	if me.needsFaststart(p) {
+		ret = append(ret, "remuxed with its index at the start")
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package dms
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+)
+
+func TestAudit(t *testing.T) {
+	srv := &Server{
+		FS: fstest.MapFS{
+			"Music/01.mp3":        {},
+			"Music/.hidden.mp3":   {},
+			"Music/cover.txt":     {},
+			"Music/thumbs/02.mp3": {},
+			"Films/a.mkv":         {},
+			"stream.dms.json":     {},
+		},
+		RootObjectPath:   ".",
+		NoProbe:          true,
+		IgnoreHidden:     true,
+		IgnorePaths:      []string{"thumbs"},
+		ForceTranscodeTo: "lpcm",
+		Logger:           log.Default,
+	}
+	found := make(map[string]AuditProblem)
+	err := srv.Audit(context.Background(), "", func(f AuditFinding) {
+		if f.Problem != AuditNoThumbnail {
+			found[f.Path] = f.Problem
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]AuditProblem{
+		"Music/.hidden.mp3":   AuditIgnored,
+		"Music/cover.txt":     AuditIgnored,
+		"Music/thumbs/02.mp3": AuditIgnored,
+		"stream.dms.json":     AuditIgnored,
+		"Music/01.mp3":        AuditTranscoded,
+	}
+	if len(found) != len(want) {
+		t.Errorf("got %v", found)
+	}
+	for p, problem := range want {
+		if found[p] != problem {
+			t.Errorf("%s: got %q, want %q", p, found[p], problem)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := srv.Audit(ctx, "", func(AuditFinding) {}); err != context.Canceled {
+		t.Errorf("got %v", err)
+	}
+}
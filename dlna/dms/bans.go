@@ -0,0 +1,165 @@
+package dms
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const bansAPIPath = "/api/bans"
+
+// How long a client is banned for if Server.BanDuration isn't set.
+const defaultBanDuration = time.Hour
+
+// Offences older than this are forgotten.
+const banWindow = 10 * time.Minute
+
+type offenceRecord struct {
+	count int
+	since time.Time
+}
+
+// The clients' recent offences, and those banned, with when they're let
+// back in. The zero value is ready for use.
+type banTable struct {
+	mu       sync.Mutex
+	offences map[string]*offenceRecord
+	banned   map[string]time.Time
+}
+
+// Counts an offence by the client, reporting whether it's reached the
+// threshold, and so was banned until the given time.
+func (me *banTable) offence(client string, threshold int, until, now time.Time) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for c, o := range me.offences {
+		if now.Sub(o.since) >= banWindow {
+			delete(me.offences, c)
+		}
+	}
+	if me.offences == nil {
+		me.offences = make(map[string]*offenceRecord)
+	}
+	o, ok := me.offences[client]
+	if !ok {
+		o = &offenceRecord{since: now}
+		me.offences[client] = o
+	}
+	o.count++
+	if o.count < threshold {
+		return false
+	}
+	delete(me.offences, client)
+	if me.banned == nil {
+		me.banned = make(map[string]time.Time)
+	}
+	me.banned[client] = until
+	return true
+}
+
+// Reports whether the client is banned.
+func (me *banTable) isBanned(client string, now time.Time) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	until, ok := me.banned[client]
+	if ok && !now.Before(until) {
+		delete(me.banned, client)
+		return false
+	}
+	return ok
+}
+
+// Lets the client back in, and forgets its offences, reporting whether it
+// was banned.
+func (me *banTable) unban(client string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	_, ok := me.banned[client]
+	delete(me.banned, client)
+	delete(me.offences, client)
+	return ok
+}
+
+type ban struct {
+	Client string    `json:"client"`
+	Until  time.Time `json:"until"`
+}
+
+// Returns the clients banned, those let back in soonest first.
+func (me *banTable) list(now time.Time) (ret []ban) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for c, until := range me.banned {
+		if now.Before(until) {
+			ret = append(ret, ban{c, until})
+		}
+	}
+	slices.SortFunc(ret, func(a, b ban) int {
+		if c := a.Until.Compare(b.Until); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Client, b.Client)
+	})
+	return
+}
+
+func (me *Server) banDuration() time.Duration {
+	if me.BanDuration > 0 {
+		return me.BanDuration
+	}
+	return defaultBanDuration
+}
+
+// Counts a forbidden or malformed request against its client, banning it
+// once it's made BanThreshold of them in banWindow. Clients on the loopback
+// interface aren't banned, so the server's own ffmpeg and the bans API are
+// always reachable from it.
+func (me *Server) offence(r *http.Request, what string) {
+	if me.BanThreshold <= 0 {
+		return
+	}
+	client := playbackClient(r)
+	if ip := net.ParseIP(client); ip != nil && ip.IsLoopback() {
+		return
+	}
+	now := time.Now()
+	if me.bans.offence(client, me.BanThreshold, now.Add(me.banDuration()), now) {
+		me.Logger.Printf("banning %s for %s after %d offences, the last %s", client, me.banDuration(), me.BanThreshold, what)
+	}
+}
+
+// Reports whether the request comes from a banned client.
+func (me *Server) banned(r *http.Request) bool {
+	return me.BanThreshold > 0 && me.bans.isBanned(playbackClient(r), time.Now())
+}
+
+// GET lists the banned clients with when they're let back in. DELETE, or
+// POST, lets the client in the client form value back in.
+func (me *Server) serveBansAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST", "DELETE":
+		ip := net.ParseIP(strings.TrimSpace(r.FormValue("client")))
+		if ip == nil {
+			http.Error(w, "client isn't an IP address", http.StatusBadRequest)
+			return
+		}
+		if me.bans.unban(ip.String()) {
+			me.Logger.Printf("unbanned %s", ip)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ret := me.bans.list(time.Now())
+	if ret == nil {
+		ret = []ban{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
@@ -0,0 +1,94 @@
+package dms
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestBanTable(t *testing.T) {
+	var bans banTable
+	now := time.Unix(1000, 0)
+	until := now.Add(time.Hour)
+	if bans.offence("scanner", 3, until, now) || bans.offence("scanner", 3, until, now) {
+		t.Fatal("banned before the threshold")
+	}
+	bans.offence("tv", 3, until, now)
+	bans.offence("tv", 3, until, now)
+	if !bans.offence("scanner", 3, until, now) || !bans.isBanned("scanner", now) {
+		t.Fatal("not banned at the threshold")
+	}
+	// Offences from long ago don't count.
+	if bans.offence("tv", 3, until, now.Add(banWindow)) {
+		t.Fatal("banned for old offences")
+	}
+	if bans.isBanned("scanner", until) {
+		t.Fatal("still banned after the ban")
+	}
+	bans.offence("scanner", 1, until, now)
+	if l := bans.list(now); len(l) != 1 || l[0].Client != "scanner" || !l[0].Until.Equal(until) {
+		t.Fatalf("got %+v", l)
+	}
+	if !bans.unban("scanner") || bans.isBanned("scanner", now) {
+		t.Fatal("not unbanned")
+	}
+}
+
+func TestBans(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.0.0.0/8")
+	_, host, _ := net.ParseCIDR("127.0.0.0/8")
+	s := &Server{
+		Logger:        log.Default,
+		AllowedIpNets: []*net.IPNet{lan, host},
+		BanThreshold:  2,
+	}
+	if err := s.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	s.httpServeMux = http.NewServeMux()
+	s.initMux(s.httpServeMux)
+	h := s.newHTTPServer().Handler
+	do := func(method, target, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	const scanner = "192.0.2.1:4000"
+	for range 2 {
+		if w := do("POST", serviceControlURL, scanner); w.Code != http.StatusForbidden {
+			t.Fatalf("got %d", w.Code)
+		}
+	}
+	if w := do("GET", rootDescPath, scanner); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "banned") {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	if w := do("GET", rootDescPath, "10.0.0.2:4000"); w.Code != http.StatusOK {
+		t.Fatalf("another client got %d", w.Code)
+	}
+	// The server's own host is never banned.
+	for range 3 {
+		do("POST", serviceControlURL, "127.0.0.1:4000")
+	}
+	w := do("GET", bansAPIPath, "127.0.0.1:4000")
+	var bans []ban
+	if err := json.Unmarshal(w.Body.Bytes(), &bans); err != nil || len(bans) != 1 || bans[0].Client != "192.0.2.1" {
+		t.Fatalf("got %v, %s", err, w.Body)
+	}
+	w = do("DELETE", bansAPIPath+"?"+url.Values{"client": {"192.0.2.1"}}.Encode(), "127.0.0.1:4000")
+	if w.Body.String() != "[]\n" {
+		t.Fatalf("got %s after unbanning", w.Body)
+	}
+	// It's still outside AllowedIpNets, but no longer banned.
+	if w := do("GET", rootDescPath, scanner); w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "banned") {
+		t.Fatalf("got %d: %s after unbanning", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,203 @@
+package dms
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+// The manufacturer given in the device description by default.
+const defaultManufacturer = "Matt Joiner <anacrolix@gmail.com>"
+
+// How the server presents itself to clients. The zero values of the fields
+// are the defaults of the Server fields of the same names.
+type Branding struct {
+	// A template, as for Server.FriendlyName.
+	FriendlyName    string
+	ModelName       string
+	Manufacturer    string
+	ModelNumber     string
+	SerialNumber    string
+	ModelURL        string
+	VendorXML       string
+	PresentationURL string
+	Icons           []Icon
+	// If Icons is empty, the standard icon set is generated from this.
+	IconSource image.Image
+}
+
+// Returns how the server presents itself.
+func (srv *Server) Branding() Branding {
+	srv.brandingMu.RLock()
+	defer srv.brandingMu.RUnlock()
+	return Branding{
+		FriendlyName:    srv.FriendlyName,
+		ModelName:       srv.ModelName,
+		Manufacturer:    srv.Manufacturer,
+		ModelNumber:     srv.ModelNumber,
+		SerialNumber:    srv.SerialNumber,
+		ModelURL:        srv.ModelURL,
+		VendorXML:       srv.VendorXML,
+		PresentationURL: srv.PresentationURL,
+		Icons:           srv.Icons,
+	}
+}
+
+// Changes how an initialized server presents itself. The device description
+// is regenerated, and the device announced again, so that control points
+// show the change. The device keeps its UUID, even if renamed.
+func (srv *Server) SetBranding(b Branding) (err error) {
+	name := b.FriendlyName
+	if name == "" {
+		name = getDefaultFriendlyName()
+	} else if name, err = expandFriendlyName(name, srv.friendlyNameData); err != nil {
+		return fmt.Errorf("expanding FriendlyName: %w", err)
+	}
+	if err = checkVendorXML(b.VendorXML); err != nil {
+		return
+	}
+	icons := b.Icons
+	if len(icons) == 0 && b.IconSource != nil {
+		if icons, err = GenerateIcons(b.IconSource); err != nil {
+			return fmt.Errorf("generating icons: %w", err)
+		}
+	}
+	srv.brandingMu.Lock()
+	srv.FriendlyName = name
+	srv.ModelName = b.ModelName
+	srv.Manufacturer = b.Manufacturer
+	srv.ModelNumber = b.ModelNumber
+	srv.SerialNumber = b.SerialNumber
+	srv.ModelURL = b.ModelURL
+	srv.VendorXML = b.VendorXML
+	srv.PresentationURL = b.PresentationURL
+	srv.Icons = icons
+	desc, err := srv.makeRootDesc()
+	if err == nil {
+		srv.rootDescXML = desc
+	}
+	srv.brandingMu.Unlock()
+	if err != nil {
+		return
+	}
+	srv.ssdpMu.Lock()
+	for s := range srv.ssdpServers {
+		s.Reannounce()
+	}
+	srv.ssdpMu.Unlock()
+	return
+}
+
+// The vendor elements every device description has.
+const defaultVendorXML = `
+     <dlna:X_DLNACAP/>
+     <dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
+     <dlna:X_DLNADOC>M-DMS-1.50</dlna:X_DLNADOC>
+     <sec:ProductCap>smi,DCM10,getMediaInfo.sec,getCaptionInfo.sec</sec:ProductCap>
+     <sec:X_ProductCap>smi,DCM10,getMediaInfo.sec,getCaptionInfo.sec</sec:X_ProductCap>`
+
+// Checks that extra vendor XML is a well-formed sequence of elements, so
+// it can't break the device description.
+func checkVendorXML(s string) error {
+	d := xml.NewDecoder(strings.NewReader("<device>" + s + "</device>"))
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("bad VendorXML: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+			// Only the wrapping element may close it.
+			if depth == 0 {
+				if _, err := d.Token(); err != io.EOF {
+					return errors.New("bad VendorXML: unbalanced elements")
+				}
+				return nil
+			}
+		case xml.CharData:
+			if depth == 1 && len(strings.TrimSpace(string(tok))) != 0 {
+				return errors.New("bad VendorXML: text outside elements")
+			}
+		case xml.ProcInst, xml.Directive:
+			return errors.New("bad VendorXML: only elements are allowed")
+		}
+	}
+}
+
+// Indents extra vendor XML as the default elements are.
+func vendorXML(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	return "\n     " + s
+}
+
+// Returns the device description.
+func (srv *Server) makeRootDesc() ([]byte, error) {
+	modelName := srv.ModelName
+	if modelName == "" {
+		modelName = rootDeviceModelName
+	}
+	manufacturer := srv.Manufacturer
+	if manufacturer == "" {
+		manufacturer = defaultManufacturer
+	}
+	presentationURL := srv.PresentationURL
+	if presentationURL == "" {
+		presentationURL = "/"
+	}
+	b, err := xml.MarshalIndent(
+		upnp.DeviceDesc{
+			NSDLNA:      "urn:schemas-dlna-org:device-1-0",
+			NSSEC:       "http://www.sec.co.kr/dlna",
+			SpecVersion: upnp.SpecVersion{Major: 1, Minor: 0},
+			Device: upnp.Device{
+				DeviceType:   rootDeviceType,
+				FriendlyName: srv.FriendlyName,
+				Manufacturer: manufacturer,
+				ModelName:    modelName,
+				ModelNumber:  srv.ModelNumber,
+				ModelURL:     srv.ModelURL,
+				SerialNumber: srv.SerialNumber,
+				UDN:          srv.rootDeviceUUID,
+				VendorXML:    defaultVendorXML + vendorXML(srv.VendorXML),
+				ServiceList: func() (ss []upnp.Service) {
+					for _, s := range srv.upnpServices() {
+						ss = append(ss, s.Service)
+					}
+					return
+				}(),
+				IconList: func() (ret []upnp.Icon) {
+					for i, di := range srv.Icons {
+						ret = append(ret, upnp.Icon{
+							Height:   di.Height,
+							Width:    di.Width,
+							Depth:    di.Depth,
+							Mimetype: di.Mimetype,
+							URL:      fmt.Sprintf("%s/%d", deviceIconPath, i),
+						})
+					}
+					return
+				}(),
+				PresentationURL: presentationURL,
+			},
+		},
+		" ", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(`<?xml version="1.0"?>`), b...), nil
+}
@@ -0,0 +1,114 @@
+package dms
+
+import (
+	"context"
+	"image"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna/dms/dmstest"
+)
+
+func TestSetBranding(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	srv := &Server{
+		FS:            fstest.MapFS{},
+		HTTPConn:      l,
+		Interfaces:    []net.Interface{},
+		FriendlyName:  "before",
+		NoProbe:       true,
+		AllowedIpNets: []*net.IPNet{all},
+		Logger:        log.Default,
+	}
+	if err := srv.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+	base := "http://" + l.Addr().String()
+	client := &dmstest.Client{}
+	dev, err := client.OpenDevice(context.Background(), base+rootDescPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := dev.Desc.Device
+	if d.FriendlyName != "before" || d.ModelName != rootDeviceModelName || d.PresentationURL != "/" || len(d.IconList) != 0 {
+		t.Errorf("got %+v", d)
+	}
+	uuid := d.UDN
+	if resp, err := http.Get(base + deviceIconPath + "/0"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got %v, %v", resp, err)
+	}
+
+	err = srv.SetBranding(Branding{
+		FriendlyName:    "Acme {{.Hostname}}",
+		ModelName:       "Acme Media",
+		ModelNumber:     "2",
+		SerialNumber:    "A-123",
+		ModelURL:        "http://acme.example/media",
+		VendorXML:       `<sec:deviceID>acme</sec:deviceID>`,
+		PresentationURL: "http://acme.example/",
+		IconSource:      image.NewRGBA(image.Rect(0, 0, 10, 10)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err = client.OpenDevice(context.Background(), base+rootDescPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = dev.Desc.Device
+	if d.FriendlyName != "Acme "+srv.friendlyNameData.Hostname || d.ModelName != "Acme Media" || d.PresentationURL != "http://acme.example/" {
+		t.Errorf("got %+v", d)
+	}
+	if d.ModelNumber != "2" || d.SerialNumber != "A-123" || d.ModelURL != "http://acme.example/media" || !strings.Contains(d.VendorXML, "<sec:deviceID>acme</sec:deviceID>") || !strings.Contains(d.VendorXML, "X_DLNADOC") {
+		t.Errorf("got %+v", d)
+	}
+	if d.UDN != uuid || d.Manufacturer != defaultManufacturer {
+		t.Errorf("got UDN %q, manufacturer %q", d.UDN, d.Manufacturer)
+	}
+	if len(d.IconList) != 6 {
+		t.Fatalf("got %d icons", len(d.IconList))
+	}
+	resp, err := http.Get(base + d.IconList[5].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("got %v %v", resp.Status, resp.Header)
+	}
+	if srv.Branding().FriendlyName != d.FriendlyName {
+		t.Errorf("got %+v", srv.Branding())
+	}
+
+	if err := srv.SetBranding(Branding{FriendlyName: "{{"}); err == nil {
+		t.Error("no error for bad template")
+	}
+}
+
+func TestCheckVendorXML(t *testing.T) {
+	for s, ok := range map[string]bool{
+		"":                               true,
+		"<sec:deviceID>a</sec:deviceID>": true,
+		"<a/>\n<b x=\"1\"><c/></b>":      true,
+		"<a>":                            false,
+		"</device><device>":              false,
+		"text":                           false,
+		"<a/>text":                       false,
+		`<?xml version="1.0"?><a/>`:      false,
+	} {
+		if err := checkVendorXML(s); (err == nil) != ok {
+			t.Errorf("%q: got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package dms
+
+import (
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/lrucache"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const (
+	// The number of probe results kept when no FFProbeCache is given.
+	defaultFFProbeCacheItems = 10000
+	// The number of thumbnails kept when no ThumbnailCache is given.
+	defaultThumbnailCacheItems = 500
+	// The number of DIDL-Lite items kept when no DIDLCache is given.
+	defaultDIDLCacheItems = 10000
+	// The number of converted images kept when no ImageCache is given.
+	defaultImageCacheItems = 100
+	// The number of extracted subtitle tracks kept when no SubtitleCache is
+	// given.
+	defaultSubtitleCacheItems = 100
+)
+
+// A Cache whose keys can be listed, so that its contents can be exported.
+type ListableCache interface {
+	Cache
+	Keys() []interface{}
+}
+
+// A Cache whose items can be dropped, so that those of files that have
+// changed or gone don't linger until they're evicted.
+type DeletableCache interface {
+	Cache
+	Delete(key interface{})
+}
+
+// Public definition so that external modules can persist cache contents.
+type FfprobeCacheItem struct {
+	Key   ffmpegInfoCacheKey
+	Value *ffprobe.Info
+}
+
+type thumbnailCacheKey struct {
+	Path    string
+	ModTime int64
+	// The image format, as given to ffmpegthumbnailer.
+	Format string
+}
+
+type convertedImageCacheKey struct {
+	Path    string
+	ModTime int64
+}
+
+type convertedSubtitleCacheKey struct {
+	Path    string
+	ModTime int64
+}
+
+type subtitleCacheKey struct {
+	Path    string
+	ModTime int64
+	// Among the file's subtitle streams.
+	Index int
+}
+
+type ThumbnailCacheItem struct {
+	Key   thumbnailCacheKey
+	Value []byte
+}
+
+// The DIDL-Lite item for a media file depends on the address and the profile
+// of the client it's for.
+type didlCacheKey struct {
+	Path      string
+	ModTime   int64
+	Host      string
+	UserAgent string
+}
+
+type DIDLCacheItem struct {
+	Key   didlCacheKey
+	Value upnpav.Item
+}
+
+// The contents of a Server's caches, so that embedders can persist them with
+// their own storage, and restore them when the server is next started. It can
+// be encoded as JSON.
+type CacheSnapshot struct {
+	FFProbe    []FfprobeCacheItem
+	Thumbnails []ThumbnailCacheItem
+	DIDL       []DIDLCacheItem
+}
+
+// Sets the caches not given to bounded in-memory ones.
+func (srv *Server) initCaches() {
+	if srv.FFProbeCache == nil {
+		srv.FFProbeCache = lrucache.New(defaultFFProbeCacheItems, 0)
+	}
+	if srv.ThumbnailCache == nil {
+		srv.ThumbnailCache = lrucache.New(defaultThumbnailCacheItems, 0)
+	}
+	if srv.DIDLCache == nil {
+		srv.DIDLCache = lrucache.New(defaultDIDLCacheItems, 0)
+	}
+	if srv.ImageCache == nil {
+		srv.ImageCache = lrucache.New(defaultImageCacheItems, 0)
+	}
+	if srv.SubtitleCache == nil {
+		srv.SubtitleCache = lrucache.New(defaultSubtitleCacheItems, 0)
+	}
+}
+
+// Returns the contents of the server's caches. Caches that aren't a
+// ListableCache are left out.
+func (srv *Server) SnapshotCaches() (ret CacheSnapshot) {
+	srv.initCaches()
+	each := func(c Cache, f func(key, value interface{})) {
+		lc, ok := c.(ListableCache)
+		if !ok {
+			return
+		}
+		for _, key := range lc.Keys() {
+			if value, ok := c.Get(key); ok {
+				f(key, value)
+			}
+		}
+	}
+	each(srv.FFProbeCache, func(key, value interface{}) {
+		k, kok := key.(ffmpegInfoCacheKey)
+		v, vok := value.(*ffprobe.Info)
+		if kok && vok {
+			ret.FFProbe = append(ret.FFProbe, FfprobeCacheItem{k, v})
+		}
+	})
+	each(srv.ThumbnailCache, func(key, value interface{}) {
+		k, kok := key.(thumbnailCacheKey)
+		v, vok := value.([]byte)
+		if kok && vok {
+			ret.Thumbnails = append(ret.Thumbnails, ThumbnailCacheItem{k, v})
+		}
+	})
+	each(srv.DIDLCache, func(key, value interface{}) {
+		k, kok := key.(didlCacheKey)
+		v, vok := value.(upnpav.Item)
+		if kok && vok {
+			ret.DIDL = append(ret.DIDL, DIDLCacheItem{k, v})
+		}
+	})
+	return
+}
+
+// Adds the contents of a snapshot to the server's caches. Items for files
+// that have changed since are never used, as the keys include modification
+// times. DIDL-Lite items reflect the configuration when they were cached, so
+// the snapshot should be discarded if that changes.
+func (srv *Server) RestoreCaches(s CacheSnapshot) {
+	srv.initCaches()
+	// Snapshots list the most recently used first, so add them in reverse to
+	// keep that order.
+	for i := len(s.FFProbe) - 1; i >= 0; i-- {
+		srv.FFProbeCache.Set(s.FFProbe[i].Key, s.FFProbe[i].Value)
+	}
+	for i := len(s.Thumbnails) - 1; i >= 0; i-- {
+		srv.ThumbnailCache.Set(s.Thumbnails[i].Key, s.Thumbnails[i].Value)
+	}
+	for i := len(s.DIDL) - 1; i >= 0; i-- {
+		srv.DIDLCache.Set(s.DIDL[i].Key, s.DIDL[i].Value)
+	}
+}
@@ -0,0 +1,106 @@
+package dms
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os/exec"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"Music/a.mp3": {Data: []byte("a")},
+	}
+	cds := &contentDirectoryService{Server: &Server{
+		FS:             mapFS,
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+	}}
+	cds.initCaches()
+	fi, err := mapFS.Stat("Music/a.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := cds.cdsObjectToUpnpavObject(context.Background(), object{"Music/a.mp3", "."}, fi, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cds.ThumbnailCache.Set(thumbnailCacheKey{"Music/a.mp3", 1, "jpeg"}, []byte("jpeg"))
+
+	b, err := json.Marshal(cds.SnapshotCaches())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot CacheSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.DIDL) != 1 || len(snapshot.Thumbnails) != 1 {
+		t.Fatalf("got %d DIDL items and %d thumbnails", len(snapshot.DIDL), len(snapshot.Thumbnails))
+	}
+
+	restored := &contentDirectoryService{Server: &Server{
+		FS:             mapFS,
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+	}}
+	restored.RestoreCaches(snapshot)
+	if v, ok := restored.ThumbnailCache.Get(thumbnailCacheKey{"Music/a.mp3", 1, "jpeg"}); !ok || string(v.([]byte)) != "jpeg" {
+		t.Errorf("thumbnail: got %v, %v", v, ok)
+	}
+	// The item comes from the cache, rather than being built again.
+	key := didlCacheKey{"Music/a.mp3", fi.ModTime().UnixNano(), "localhost", ""}
+	if v, ok := restored.DIDLCache.Get(key); !ok || v.(upnpav.Item).ID != obj.(upnpav.Item).ID {
+		t.Errorf("DIDL item: got %v, %v", v, ok)
+	}
+	cached := obj.(upnpav.Item)
+	cached.Title = "from cache"
+	restored.DIDLCache.Set(key, cached)
+	again, err := restored.cdsObjectToUpnpavObject(context.Background(), object{"Music/a.mp3", "."}, fi, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := again.(upnpav.Item).Title; title != "from cache" {
+		t.Errorf("got title %q", title)
+	}
+}
+
+func TestProbeFailureCaching(t *testing.T) {
+	if _, err := exec.LookPath("ffprobe"); err == nil {
+		t.Skip("needs ffprobe to be missing")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &Server{
+		FS: fstest.MapFS{
+			"a.mkv": {},
+			"b.mkv": {},
+		},
+		HTTPConn: l,
+	}
+	s.initCaches()
+	// A missing ffprobe isn't the file's fault.
+	if _, err := s.ffmpegProbe(context.Background(), "a.mkv"); err != ffprobe.ExeNotFound {
+		t.Fatalf("got %v", err)
+	}
+	if _, ok := s.FFProbeCache.Get(ffmpegInfoCacheKey{"a.mkv", time.Time{}.UnixNano()}); ok {
+		t.Error("cached a missing ffprobe")
+	}
+	// A failure cached earlier is returned without probing again.
+	s.FFProbeCache.Set(ffmpegInfoCacheKey{"b.mkv", time.Time{}.UnixNano()}, (*ffprobe.Info)(nil))
+	if info, err := s.ffmpegProbe(context.Background(), "b.mkv"); info != nil || err != nil {
+		t.Errorf("got %v, %v", info, err)
+	}
+}
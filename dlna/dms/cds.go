@@ -1,24 +1,29 @@
 package dms
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io/fs"
-	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/anacrolix/ffprobe"
 	"github.com/anacrolix/log"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/dlna/dms/cds"
 	"github.com/anacrolix/dms/misc"
+	"github.com/anacrolix/dms/search"
 	"github.com/anacrolix/dms/upnp"
 	"github.com/anacrolix/dms/upnpav"
 )
@@ -31,13 +36,14 @@ type contentDirectoryService struct {
 }
 
 func (cds *contentDirectoryService) updateIDString() string {
-	return fmt.Sprintf("%d", uint32(os.Getpid()))
+	return strconv.FormatUint(uint64(cds.containerUpdates.systemUpdateID()), 10)
 }
 
 type dmsDynamicStreamResource struct {
 	// (optional) DLNA profile name to include in the response e.g. MPEG_PS_PAL
 	DlnaProfileName string
-	// (optional) DLNA.ORG_FLAGS if you need to override the default (8D500000000000000000000000000000)
+	// (optional) DLNA.ORG_FLAGS if you need to override the default (8D500000000000000000000000000000,
+	// unless configured otherwise for the client)
 	DlnaFlags string
 	// required: mime type, e.g. video/mpeg
 	MimeType string
@@ -45,6 +51,12 @@ type dmsDynamicStreamResource struct {
 	Resolution string
 	// (optional) bitrate, e.g. 721
 	Bitrate uint
+	// (optional) duration of this version, if it differs from the item's
+	Duration string
+	// (optional) audio sample rate in Hz, e.g. 48000
+	SampleFrequency uint
+	// (optional) number of audio channels, e.g. 2
+	NrAudioChannels uint
 	// required: OS command to generate this resource on the fly
 	Command string
 }
@@ -56,12 +68,22 @@ type dmsDynamicMediaItem struct {
 	Type string
 	// (optional) duration, e.g. 0:21:37.922
 	Duration string
-	// required: an array of available versions
+	// (optional) a description of the content
+	Description string
+	// (optional) artwork: an http(s) URL, or the path of an image relative to
+	// the .dms.json file. Defaults to a thumbnail
+	Artwork string
+	// required, unless Items is given: an array of available versions, best
+	// first, from which the renderer picks one it can play
 	Resources []dmsDynamicStreamResource
+	// (optional) media items, each with its own resources, listed in a
+	// container with this item's title and artwork instead
+	Items []dmsDynamicMediaItem
 }
 
-func readDynamicStream(metadataPath string) (*dmsDynamicMediaItem, error) {
-	bytes, err := ioutil.ReadFile(metadataPath)
+// Reads the .dms.json file from the FS.
+func (me *Server) readDynamicStream(metadataPath string) (*dmsDynamicMediaItem, error) {
+	bytes, err := fs.ReadFile(me.FS, metadataPath)
 	if err != nil {
 		return nil, err
 	}
@@ -73,27 +95,160 @@ func readDynamicStream(metadataPath string) (*dmsDynamicMediaItem, error) {
 	return &re, nil
 }
 
+// Reads the media item of the .dms.json file, or of one of its Items if item
+// isn't empty. Items have their container's artwork unless they set their own.
+func (me *Server) readDynamicStreamItem(metadataPath, item string) (*dmsDynamicMediaItem, error) {
+	dmsMediaItem, err := me.readDynamicStream(metadataPath)
+	if err != nil || item == "" {
+		return dmsMediaItem, err
+	}
+	i, err := strconv.Atoi(item)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= len(dmsMediaItem.Items) {
+		return nil, fmt.Errorf("invalid item %d, corresponding media item not found", i)
+	}
+	child := dmsMediaItem.Items[i]
+	if child.Artwork == "" {
+		child.Artwork = dmsMediaItem.Artwork
+	}
+	return &child, nil
+}
+
+// Returns the .dms.json file object of an item in its Items, and the item's
+// index.
+func dynamicStreamChild(o object) (parent object, i int, ok bool) {
+	parent = o
+	parent.Path = path.Dir(o.Path)
+	if !strings.HasSuffix(parent.Path, dmsMetadataSuffix) {
+		return
+	}
+	i, err := strconv.Atoi(path.Base(o.Path))
+	return parent, i, err == nil
+}
+
 func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObject object, fileInfo fs.FileInfo, host, userAgent string) (ret interface{}, err error) {
 	// at this point we know that entryFilePath points to a .dms.json file; slurp and parse
-	dmsMediaItem, err := readDynamicStream(cdsObject.FilePath())
+	dmsMediaItem, err := me.readDynamicStream(cdsObject.FilePath())
 	if err != nil {
 		me.Logger.Printf("%s ignored: %v", cdsObject.FilePath(), err)
 		return
 	}
-
+	if dmsMediaItem.Title == "" {
+		dmsMediaItem.Title = strings.TrimSuffix(fileInfo.Name(), dmsMetadataSuffix)
+	}
+	if len(dmsMediaItem.Items) == 0 {
+		ret = me.dynamicStreamItem(cdsObject, "", dmsMediaItem, fileInfo, host, userAgent)
+		return
+	}
 	obj := upnpav.Object{
-		ID:         cdsObject.ID(),
-		Restricted: 1,
-		ParentID:   cdsObject.ParentID(),
+		ID:          cdsObject.ID(),
+		Restricted:  1,
+		ParentID:    cdsObject.ParentID(),
+		Class:       "object.container",
+		Title:       dmsMediaItem.Title,
+		Description: dmsMediaItem.Description,
+		Date:        upnpav.Timestamp{Time: fileInfo.ModTime()},
 	}
-	iconURI := (&url.URL{
-		Scheme: "http",
-		Host:   host,
-		Path:   iconPath,
-		RawQuery: url.Values{
-			"path": {cdsObject.Path},
-		}.Encode(),
+	obj.AlbumArtURI, _ = me.dynamicStreamArtwork(cdsObject, "", dmsMediaItem, host, userAgent)
+	ret = upnpav.Container{Object: obj, ChildCount: len(dmsMediaItem.Items)}
+	return
+}
+
+// Returns the items of a .dms.json file with Items.
+func (me *contentDirectoryService) dynamicStreamChildren(parent object, host, userAgent string) (ret []interface{}, err error) {
+	dmsMediaItem, err := me.readDynamicStream(parent.FilePath())
+	if err != nil {
+		return
+	}
+	for i := range dmsMediaItem.Items {
+		child := parent
+		child.Path = path.Join(parent.Path, strconv.Itoa(i))
+		obj, err := me.dynamicStreamChildObject(child, host, userAgent)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, obj)
+	}
+	return
+}
+
+// Returns the item of a .dms.json file's Items that the object is.
+func (me *contentDirectoryService) dynamicStreamChildObject(o object, host, userAgent string) (ret interface{}, err error) {
+	parent, i, _ := dynamicStreamChild(o)
+	fileInfo, err := fs.Stat(me.FS, parent.FilePath())
+	if err != nil {
+		return
+	}
+	dmsMediaItem, err := me.readDynamicStreamItem(parent.FilePath(), strconv.Itoa(i))
+	if err != nil {
+		return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "%s", err)
+	}
+	if dmsMediaItem.Title == "" {
+		dmsMediaItem.Title = fmt.Sprintf("%s %d", strings.TrimSuffix(fileInfo.Name(), dmsMetadataSuffix), i+1)
+	}
+	return me.dynamicStreamItem(o, strconv.Itoa(i), dmsMediaItem, fileInfo, host, userAgent), nil
+}
+
+// Returns the URL of a dynamic stream's artwork, and the protocolInfo of its
+// res. item is the index of the stream in its .dms.json file's Items, if it's
+// one of them.
+func (me *contentDirectoryService) dynamicStreamArtwork(o object, item string, dmsMediaItem *dmsDynamicMediaItem, host, userAgent string) (uri, protocolInfo string) {
+	if isAbsURL(dmsMediaItem.Artwork) {
+		return dmsMediaItem.Artwork, "http-get:*:" + artworkMimeType(dmsMediaItem.Artwork) + ":*"
+	}
+	metadataPath := o.Path
+	if item != "" {
+		metadataPath = path.Dir(o.Path)
+	}
+	query := url.Values{"path": {metadataPath}}
+	if item != "" {
+		query.Set("item", item)
+	}
+	protocolInfo = me.thumbnailProtocolInfo(userAgent)
+	if dmsMediaItem.Artwork != "" {
+		protocolInfo = "http-get:*:" + artworkMimeType(dmsMediaItem.Artwork) + ":*"
+	} else {
+		// A thumbnail, or the server's icon.
+		query.Set("c", "jpeg")
+	}
+	uri = (&url.URL{
+		Scheme:   me.urlScheme(host),
+		Host:     host,
+		Path:     iconPath,
+		RawQuery: query.Encode(),
 	}).String()
+	return
+}
+
+func isAbsURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs()
+}
+
+// Returns the MIME type of an artwork image with the given path or URL.
+func artworkMimeType(p string) string {
+	if u, err := url.Parse(p); err == nil {
+		p = u.Path
+	}
+	if t, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(p))); err == nil && strings.HasPrefix(t, "image/") {
+		return t
+	}
+	return "image/jpeg"
+}
+
+// Returns the item of a dynamic stream. item is the index of the stream in its
+// .dms.json file's Items, if it's one of them.
+func (me *contentDirectoryService) dynamicStreamItem(cdsObject object, item string, dmsMediaItem *dmsDynamicMediaItem, fileInfo fs.FileInfo, host, userAgent string) upnpav.Item {
+	obj := upnpav.Object{
+		ID:          cdsObject.ID(),
+		Restricted:  1,
+		ParentID:    cdsObject.ParentID(),
+		Title:       dmsMediaItem.Title,
+		Description: dmsMediaItem.Description,
+	}
+	iconURI, iconProtocolInfo := me.dynamicStreamArtwork(cdsObject, item, dmsMediaItem, host, userAgent)
 	obj.Icon = iconURI
 	// TODO(anacrolix): This might not be necessary due to item res image
 	// element.
@@ -107,68 +262,63 @@ func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObjec
 	default:
 		obj.Class = "object.item.videoItem"
 	}
-
-	obj.Title = dmsMediaItem.Title
-	if obj.Title == "" {
-		obj.Title = strings.TrimSuffix(fileInfo.Name(), dmsMetadataSuffix)
-	}
 	obj.Date = upnpav.Timestamp{Time: fileInfo.ModTime()}
 
-	item := upnpav.Item{
+	query := url.Values{"path": {cdsObject.Path}}
+	if item != "" {
+		query.Set("path", path.Dir(cdsObject.Path))
+		query.Set("item", item)
+	}
+	ret := upnpav.Item{
 		Object: obj,
 		// Capacity: 1 for icon, plus resources.
 		Res: make([]upnpav.Resource, 0, 1+len(dmsMediaItem.Resources)),
 	}
 	for i, dmsStream := range dmsMediaItem.Resources {
-		// default flags borrowed from Serviio: DLNA_ORG_FLAG_SENDER_PACED | DLNA_ORG_FLAG_S0_INCREASE | DLNA_ORG_FLAG_SN_INCREASE | DLNA_ORG_FLAG_STREAMING_TRANSFER_MODE | DLNA_ORG_FLAG_BACKGROUND_TRANSFERT_MODE | DLNA_ORG_FLAG_DLNA_V15
-		flags := "8D500000000000000000000000000000"
+		me.noteMimeType(mimeType(dmsStream.MimeType))
+		flags := me.dlnaFlags(userAgent, DynamicResource)
 		if dmsStream.DlnaFlags != "" {
 			flags = dmsStream.DlnaFlags
 		}
-		item.Res = append(item.Res, upnpav.Resource{
+		duration := dmsStream.Duration
+		if duration == "" {
+			duration = dmsMediaItem.Duration
+		}
+		query.Set("index", strconv.Itoa(i))
+		ret.Res = append(ret.Res, upnpav.Resource{
 			URL: (&url.URL{
-				Scheme: "http",
-				Host:   host,
-				Path:   resPath,
-				RawQuery: url.Values{
-					"path":  {cdsObject.Path},
-					"index": {strconv.Itoa(i)},
-				}.Encode(),
+				Scheme:   me.urlScheme(host),
+				Host:     host,
+				Path:     resPath,
+				RawQuery: query.Encode(),
 			}).String(),
-			ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", dmsStream.MimeType, dlna.ContentFeatures{
+			ProtocolInfo: dlna.HTTPProtocolInfo(dmsStream.MimeType, dlna.ContentFeatures{
 				ProfileName:     dmsStream.DlnaProfileName,
 				SupportRange:    false,
 				SupportTimeSeek: false,
 				Transcoded:      true,
 				Flags:           flags,
-			}.String()),
-			Bitrate:    dmsStream.Bitrate,
-			Duration:   dmsMediaItem.Duration,
-			Resolution: dmsStream.Resolution,
+			}),
+			Bitrate:         dmsStream.Bitrate,
+			Duration:        duration,
+			Resolution:      dmsStream.Resolution,
+			SampleFrequency: dmsStream.SampleFrequency,
+			NrAudioChannels: dmsStream.NrAudioChannels,
 		})
 	}
 
 	// and an icon
-	item.Res = append(item.Res, upnpav.Resource{
-		URL: (&url.URL{
-			Scheme: "http",
-			Host:   host,
-			Path:   iconPath,
-			RawQuery: url.Values{
-				"path": {cdsObject.Path},
-				"c":    {"jpeg"},
-			}.Encode(),
-		}).String(),
-		ProtocolInfo: "http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN",
+	ret.Res = append(ret.Res, upnpav.Resource{
+		URL:          iconURI,
+		ProtocolInfo: iconProtocolInfo,
 	})
-
-	ret = item
-	return
+	return ret
 }
 
 // Turns the given entry and DMS host into a UPnP object. A nil object is
 // returned if the entry is not of interest.
 func (me *contentDirectoryService) cdsObjectToUpnpavObject(
+	ctx context.Context,
 	cdsObject object,
 	fileInfo fs.FileInfo,
 	host, userAgent string,
@@ -204,7 +354,7 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 		me.Logger.Printf("%s ignored: non-regular file", cdsObject.FilePath())
 		return
 	}
-	mimeType, err := MimeTypeByPath(me.FS, entryFilePath)
+	mimeType, err := me.mimeTypeByPath(entryFilePath)
 	if err != nil {
 		return
 	}
@@ -218,8 +368,17 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 		}
 		return
 	}
+	me.noteMimeType(mimeType)
+	cacheKey := didlCacheKey{cdsObject.Path, fileInfo.ModTime().UnixNano(), host, userAgent}
+	if me.DIDLCache != nil {
+		if cached, ok := me.DIDLCache.Get(cacheKey); ok {
+			return cached.(upnpav.Item), nil
+		}
+	}
+	// Whether the item can be reused for later requests.
+	cacheable := true
 	iconURI := (&url.URL{
-		Scheme: "http",
+		Scheme: me.urlScheme(host),
 		Host:   host,
 		Path:   iconPath,
 		RawQuery: url.Values{
@@ -230,18 +389,20 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 	// TODO(anacrolix): This might not be necessary due to item res image
 	// element.
 	obj.AlbumArtURI = iconURI
-	obj.Class = "object.item." + mimeType.Type() + "Item"
 	var (
-		ffInfo        *ffprobe.Info
-		nativeBitrate uint
-		resDuration   string
+		ffInfo      *ffprobe.Info
+		resDuration string
 	)
 	if !me.NoProbe {
-		ffInfo, probeErr := me.ffmpegProbe(entryFilePath)
+		var probeErr error
+		ffInfo, probeErr = me.ffmpegProbe(ctx, entryFilePath)
 		switch probeErr {
+		case ctx.Err():
+			// The request went away. Items missing metadata because of it
+			// mustn't outlive it.
+			cacheable = false
 		case nil:
 			if ffInfo != nil {
-				nativeBitrate, _ = ffInfo.Bitrate()
 				if d, err := ffInfo.Duration(); err == nil {
 					resDuration = misc.FormatDurationSexagesimal(d)
 				}
@@ -251,51 +412,130 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 			me.Logger.Printf("error probing %s: %s", entryFilePath, probeErr)
 		}
 	}
+	mimeType = me.probedMimeType(entryFilePath, mimeType, ffInfo)
+	obj.Class = "object.item." + mimeType.Type() + "Item"
+	var photoDoc search.Document
+	if mimeType.IsAudio() || mimeType.IsVideo() {
+		setObjectMetadata(&obj, me.searchDocument(entryFilePath, fileInfo, mimeType, ffInfo))
+	} else if mimeType.IsImage() {
+		photoDoc = me.photoDocument(entryFilePath, fileInfo, mimeType)
+		setObjectMetadata(&obj, photoDoc)
+	}
+	if me.Recordings != nil && mimeType.IsVideo() {
+		if rec, ok := me.Recordings.byPath(entryFilePath); ok {
+			setRecordingMetadata(&obj, rec)
+		}
+	}
+	if obj.Date.IsZero() {
+		// Clients sorting by date have something to go on.
+		obj.Date = upnpav.Timestamp{Time: fileInfo.ModTime()}
+	}
+	gapless, haveGapless := probeGapless(ffInfo)
+	if haveGapless && mimeType.IsAudio() {
+		// More exact than the container's duration.
+		resDuration = misc.FormatDurationSexagesimal(gapless.Duration())
+	}
 	if obj.Title == "" {
 		obj.Title = fileInfo.Name()
 	}
 	resolution := func() string {
-		if ffInfo != nil {
-			for _, strm := range ffInfo.Streams {
-				if strm["codec_type"] != "video" {
-					continue
-				}
-				width := strm["width"]
-				height := strm["height"]
-				return fmt.Sprintf("%.0fx%.0f", width, height)
-			}
+		if strm := firstStream(ffInfo, "video"); strm != nil {
+			return strconv.FormatInt(streamInt(strm, "width"), 10) + "x" + strconv.FormatInt(streamInt(strm, "height"), 10)
+		}
+		if v := photoDoc.Fields["res@resolution"]; len(v) != 0 {
+			return v[0]
 		}
 		return ""
 	}()
+	probed := probedResStreamInfo(ffInfo)
 	item := upnpav.Item{
 		Object: obj,
 		// Capacity: 1 for raw, 1 for icon, plus transcodes.
 		Res: make([]upnpav.Resource, 0, 2+len(transcodes)),
 	}
-	item.Res = append(item.Res, upnpav.Resource{
-		URL: (&url.URL{
-			Scheme: "http",
-			Host:   host,
-			Path:   resPath,
-			RawQuery: url.Values{
-				"path": {cdsObject.Path},
-			}.Encode(),
-		}).String(),
-		ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", mimeType, dlna.ContentFeatures{
-			SupportRange: true,
-		}.String()),
-		Bitrate:    nativeBitrate,
-		Duration:   resDuration,
-		Size:       uint64(fileInfo.Size()),
-		Resolution: resolution,
-	})
+	// The file and its transcodes, which the client's profile may reorder.
+	var media []namedResource
+	if haveGapless && mimeType.IsAudio() {
+		item.Desc = append(item.Desc, gapless.desc())
+	}
+	if mimeType.IsAudio() && !me.NoTranscode && me.adjustsAudio(userAgent, ffInfo) {
+		// The client can't play the file as intended, so only offer it
+		// adjusted.
+		res := upnpav.Resource{
+			URL: (&url.URL{
+				Scheme: me.urlScheme(host),
+				Host:   host,
+				Path:   resPath,
+				RawQuery: url.Values{
+					"path":      {cdsObject.Path},
+					"transcode": {adjustedTranscodeKey},
+				}.Encode(),
+			}).String(),
+			ProtocolInfo: dlna.HTTPProtocolInfo("audio/flac", dlna.ContentFeatures{
+				SupportTimeSeek: true,
+				Transcoded:      true,
+				Flags:           me.dlnaFlags(userAgent, TranscodeResource),
+			}),
+			Duration: resDuration,
+		}
+		transcodedResStreamInfo(adjustedAudioSpec, probed, me.clientAudioOptions(userAgent, ffInfo, 0)).setAttrs(&res)
+		media = append(media, namedResource{rawResourceName, res})
+	} else if mimeType.IsVideo() && me.onlyTranscodesVideo(userAgent, ffInfo) {
+		// The client can't decode the video, so it's only offered
+		// transcoded, below.
+	} else {
+		size, supportRange := uint64(fileInfo.Size()), true
+		if !me.NoTranscode && me.needsFaststart(entryFilePath) {
+			// It's served remuxed, from the cache if it's there.
+			size, supportRange = 0, false
+			if cfi, _, ok := me.faststartCopy(entryFilePath, fileInfo); ok {
+				size, supportRange = uint64(cfi.Size()), true
+			} else {
+				// The copy may be made before the file changes.
+				cacheable = false
+			}
+		}
+		clientMimeType, profileMimeType := me.clientMimeType(userAgent, entryFilePath, mimeType), mimeType
+		if me.convertsImage(userAgent, mimeType) {
+			// It's served converted, so its size isn't known until then.
+			clientMimeType, profileMimeType, size = "image/jpeg", "image/jpeg", 0
+		}
+		res := upnpav.Resource{
+			URL: (&url.URL{
+				Scheme: me.urlScheme(host),
+				Host:   host,
+				Path:   resPath,
+				RawQuery: url.Values{
+					"path": {cdsObject.Path},
+				}.Encode(),
+			}).String(),
+			ProtocolInfo: dlna.HTTPProtocolInfo(clientMimeType.String(), dlna.ContentFeatures{
+				ProfileName:     dlnaProfileName(profileMimeType, entryFilePath, ffInfo),
+				SupportRange:    supportRange,
+				SupportTimeSeek: me.rawTimeSeekable(mimeType),
+				Flags:           me.dlnaFlags(userAgent, me.rawResourceKind(mimeType, entryFilePath, fileInfo)),
+			}),
+			Duration:   resDuration,
+			Size:       size,
+			Resolution: resolution,
+		}
+		probed.setAttrs(&res)
+		media = append(media, namedResource{rawResourceName, res})
+	}
+	if mimeType.IsAudio() && !me.NoTranscode {
+		media = append(media, me.transcodeResources(host, cdsObject.Path, mimeType, resolution, resDuration, userAgent, ffInfo)...)
+	}
 	if mimeType.IsVideo() {
 		if !me.NoTranscode {
-			item.Res = append(item.Res, transcodeResources(host, cdsObject.Path, resolution, resDuration)...)
+			// Transcodes are turned upright.
+			media = append(media, me.transcodeResources(host, cdsObject.Path, mimeType, uprightResolution(resolution, videoRotation(ffInfo)), resDuration, userAgent, ffInfo)...)
 		}
+	}
+	item.Res = append(item.Res, me.orderResources(userAgent, media)...)
+	if p := me.clientProfile(userAgent); mimeType.IsVideo() && (p == nil || !p.NoSubtitles) {
 		item.Res = append(item.Res, upnpav.Resource{
 			URL: (&url.URL{
-				Scheme: "http",
+				Scheme: me.urlScheme(host),
 				Host:   host,
 				Path:   subtitlePath,
 				RawQuery: url.Values{
@@ -304,11 +544,15 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 			}).String(),
 			ProtocolInfo: "http-get:*:text/plain",
 		})
+		item.Res = append(item.Res, me.embeddedSubtitleResources(host, cdsObject.Path, ffInfo)...)
+		if u, ok := me.captionURL(host, userAgent, cdsObject.Path, entryFilePath, ffInfo); ok {
+			item.CaptionInfoEx = []upnpav.CaptionInfo{{Type: "srt", URL: u}}
+		}
 	}
 	if mimeType.IsVideo() || mimeType.IsImage() {
 		item.Res = append(item.Res, upnpav.Resource{
 			URL: (&url.URL{
-				Scheme: "http",
+				Scheme: me.urlScheme(host),
 				Host:   host,
 				Path:   iconPath,
 				RawQuery: url.Values{
@@ -316,34 +560,61 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 					"c":    {"jpeg"},
 				}.Encode(),
 			}).String(),
-			ProtocolInfo: "http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN",
+			ProtocolInfo: me.thumbnailProtocolInfo(userAgent),
 		})
 	}
+	if me.DIDLCache != nil && cacheable {
+		me.DIDLCache.Set(cacheKey, item)
+	}
 	ret = item
 	return
 }
 
+// Returns the protocolInfo for item thumbnails served from iconPath.
+func (me *contentDirectoryService) thumbnailProtocolInfo(userAgent string) string {
+	return "http-get:*:image/jpeg:" + dlna.ContentFeatures{
+		ProfileName:  "JPEG_TN",
+		SupportRange: true,
+		Flags:        me.dlnaFlags(userAgent, ThumbnailResource),
+	}.String()
+}
+
 // Returns all the upnpav objects in a directory.
 func (me *contentDirectoryService) readContainer(
+	ctx context.Context,
 	o object,
-	host, userAgent string,
+	host, userAgent, client string,
 ) (ret []interface{}, err error) {
 	sfis := sortableFileInfoSlice{
 		// TODO(anacrolix): Dig up why this special cast was added.
 		FoldersLast: strings.Contains(userAgent, `AwoX/1.1`),
 	}
+	_, span := me.startSpan(ctx, "read dir", attribute.String("file.path", o.FilePath()))
 	sfis.fileInfoSlice, err = o.readDir(me.FS)
+	endSpan(span, err)
 	if err != nil {
 		return
 	}
 	sort.Sort(sfis)
-	for _, fi := range sfis.fileInfoSlice {
-		child := object{path.Join(o.Path, fi.Name()), me.RootObjectPath}
-		obj, err := me.cdsObjectToUpnpavObject(child, fi, host, userAgent)
+	if item := me.slideshowItem(o, host, userAgent, client); item != nil {
+		ret = append(ret, *item)
+	}
+	entries := me.containerEntries(o, sfis.fileInfoSlice)
+	me.sortDiscTracks(ctx, entries)
+	for _, e := range entries {
+		if me.hiddenFrom(client, e.FilePath()) || !e.IsDir() && me.hiddenByRating(userAgent, e.FilePath()) {
+			continue
+		}
+		obj, err := me.cdsObjectToUpnpavObject(ctx, e.object, e.FileInfo, host, userAgent)
 		if err != nil {
-			me.Logger.Printf("error with %s: %s", child.FilePath(), err)
+			me.Logger.Printf("error with %s: %s", e.FilePath(), err)
 			continue
 		}
+		if item, ok := obj.(upnpav.Item); ok && e.Disc != 0 {
+			// Listed as part of the album, rather than the disc folder.
+			item.ParentID = o.ID()
+			obj = item
+		}
 		if obj != nil {
 			ret = append(ret, obj)
 		}
@@ -351,12 +622,61 @@ func (me *contentDirectoryService) readContainer(
 	return
 }
 
-type browse struct {
-	ObjectID       string
-	BrowseFlag     string
-	Filter         string
-	StartingIndex  int
-	RequestedCount int
+// Returns the requested page of the items in the container matching the
+// search, and how many match in all.
+func (me *contentDirectoryService) search(ctx context.Context, args cds.SearchArgs, host, userAgent, client string) (ret []interface{}, totalMatches int, err error) {
+	ctx, span := me.startSpan(ctx, "search",
+		attribute.String("upnp.container_id", args.ContainerID),
+		attribute.String("upnp.search_criteria", args.SearchCriteria),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("upnp.total_matches", totalMatches))
+		endSpan(span, err)
+	}()
+	if strings.HasPrefix(args.ContainerID, virtualIDPrefix) {
+		return nil, 0, upnp.Errorf(upnpav.NoSuchContainerErrorCode, "can't search %s", args.ContainerID)
+	}
+	container, err := me.objectFromID(args.ContainerID)
+	if err != nil {
+		return nil, 0, upnp.Errorf(upnpav.NoSuchContainerErrorCode, "%s", err.Error())
+	}
+	if me.hiddenFrom(client, container.FilePath()) {
+		return nil, 0, upnp.Errorf(upnpav.NoSuchContainerErrorCode, "no such container")
+	}
+	ids, err := me.Search.Search(args.SearchCriteria)
+	if err != nil {
+		return nil, 0, upnp.Errorf(upnpav.UnsupportedOrInvalidSearchCriteriaErrorCode, "%s", err.Error())
+	}
+	if !container.IsRoot() {
+		prefix := container.FilePath() + "/"
+		var within []string
+		for _, id := range ids {
+			if strings.HasPrefix(id, prefix) {
+				within = append(within, id)
+			}
+		}
+		ids = within
+	}
+	ids = slices.DeleteFunc(ids, func(id string) bool {
+		return me.hiddenFrom(client, id) || me.hiddenByRating(userAgent, id)
+	})
+	totalMatches = len(ids)
+	ids = cds.Page(ids, args.StartingIndex, args.RequestedCount)
+	for _, id := range ids {
+		fi, err := fs.Stat(me.FS, id)
+		if err != nil {
+			continue
+		}
+		obj, err := me.cdsObjectToUpnpavObject(ctx, object{id, me.RootObjectPath}, fi, host, userAgent)
+		if err != nil {
+			me.Logger.Printf("error with %s: %s", id, err)
+			continue
+		}
+		if obj != nil {
+			ret = append(ret, obj)
+		}
+	}
+	return
 }
 
 // ContentDirectory object from ObjectID.
@@ -373,9 +693,42 @@ func (me *contentDirectoryService) objectFromID(id string) (o object, err error)
 	return
 }
 
+// Returns the children of the container with the ID, for the client.
+func (me *contentDirectoryService) browseChildren(ctx context.Context, id string, obj object, host, userAgent, client string) (objs []interface{}, err error) {
+	ctx, span := me.startSpan(ctx, "browse children", attribute.String("upnp.object_id", id))
+	defer func() {
+		span.SetAttributes(attribute.Int("upnp.children", len(objs)))
+		endSpan(span, err)
+	}()
+	if vc, ok := me.virtualContainerByID(id); ok {
+		return me.virtualContainerChildren(ctx, vc, host, userAgent, client), nil
+	}
+	if objs, ok := me.placesChildren(ctx, id, host, userAgent, client); ok {
+		return objs, nil
+	}
+	if me.hiddenFrom(client, obj.FilePath()) {
+		return nil, fmt.Errorf("no such object")
+	}
+	if me.OnBrowseDirectChildren != nil {
+		return me.OnBrowseDirectChildren(obj.Path, obj.RootObjectPath, host, userAgent)
+	}
+	if me.AllowDynamicStreams && strings.HasSuffix(obj.FilePath(), dmsMetadataSuffix) {
+		return me.dynamicStreamChildren(obj, host, userAgent)
+	}
+	if obj.IsRoot() && !me.clientProfile(userAgent).ShowsFolders() {
+		return me.rootVirtualContainers(userAgent, client), nil
+	}
+	objs, err = me.readContainer(ctx, obj, host, userAgent, client)
+	if err == nil && obj.IsRoot() {
+		objs = append(me.rootVirtualContainers(userAgent, client), objs...)
+	}
+	return
+}
+
 func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
-	host := r.Host
+	host := requestHost(r)
 	userAgent := r.UserAgent()
+	client := playbackClient(r)
 	switch action {
 	case "GetSystemUpdateID":
 		return [][2]string{
@@ -386,50 +739,53 @@ func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http
 			{"SortCaps", "dc:title"},
 		}, nil
 	case "Browse":
-		var browse browse
+		var browse cds.BrowseArgs
 		if err := xml.Unmarshal([]byte(argsXML), &browse); err != nil {
 			return nil, err
 		}
+		if me.Provider != nil {
+			return me.browseProvider(r.Context(), browse, host, userAgent)
+		}
 		obj, err := me.objectFromID(browse.ObjectID)
 		if err != nil {
 			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "%s", err.Error())
 		}
 		switch browse.BrowseFlag {
 		case "BrowseDirectChildren":
-			var objs []interface{}
-			if me.OnBrowseDirectChildren == nil {
-				objs, err = me.readContainer(obj, host, userAgent)
-			} else {
-				objs, err = me.OnBrowseDirectChildren(obj.Path, obj.RootObjectPath, host, userAgent)
-			}
+			objs, err := me.browseChildren(r.Context(), browse.ObjectID, obj, host, userAgent, client)
 			if err != nil {
 				return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "%s", err.Error())
 			}
+			me.annotatePlayback(objs, client)
 			totalMatches := len(objs)
-			objs = objs[func() (low int) {
-				low = browse.StartingIndex
-				if low > len(objs) {
-					low = len(objs)
-				}
-				return
-			}():]
-			if browse.RequestedCount != 0 && int(browse.RequestedCount) < len(objs) {
-				objs = objs[:browse.RequestedCount]
-			}
-			result, err := xml.Marshal(objs)
+			objs = cds.Page(objs, browse.StartingIndex, browse.RequestedCount)
+			result, err := cds.MarshalDIDL(objs)
 			if err != nil {
 				return nil, err
 			}
 			return [][2]string{
-				{"Result", didl_lite(string(result))},
-				{"NumberReturned", fmt.Sprint(len(objs))},
-				{"TotalMatches", fmt.Sprint(totalMatches)},
+				{"Result", result},
+				{"NumberReturned", strconv.Itoa(len(objs))},
+				{"TotalMatches", strconv.Itoa(totalMatches)},
 				{"UpdateID", me.updateIDString()},
 			}, nil
 		case "BrowseMetadata":
 			var ret interface{}
 			var err error
-			if me.OnBrowseMetadata == nil {
+			if vc, ok := me.virtualContainerByID(browse.ObjectID); ok {
+				ret = me.virtualContainerObject(vc, userAgent, client)
+			} else if c, ok := me.placesObject(browse.ObjectID, userAgent, client); ok {
+				ret = c
+			} else if item, ok := me.slideshowObject(browse.ObjectID, host, userAgent, client); ok {
+				if item == nil {
+					return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "no such object")
+				}
+				ret = *item
+			} else if me.hiddenFrom(client, obj.FilePath()) {
+				return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "no such object")
+			} else if _, _, ok := dynamicStreamChild(obj); ok && me.AllowDynamicStreams {
+				ret, err = me.dynamicStreamChildObject(obj, host, userAgent)
+			} else if me.OnBrowseMetadata == nil {
 				var fileInfo fs.FileInfo
 				fileInfo, err = fs.Stat(me.FS, obj.FilePath())
 				if err != nil {
@@ -441,19 +797,24 @@ func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http
 					}
 					return nil, err
 				}
-				ret, err = me.cdsObjectToUpnpavObject(obj, fileInfo, host, userAgent)
+				if !fileInfo.IsDir() && me.hiddenByRating(userAgent, obj.FilePath()) {
+					return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "no such object")
+				}
+				ret, err = me.cdsObjectToUpnpavObject(r.Context(), obj, fileInfo, host, userAgent)
 			} else {
 				ret, err = me.OnBrowseMetadata(obj.Path, obj.RootObjectPath, host, userAgent)
 			}
 			if err != nil {
 				return nil, err
 			}
-			buf, err := xml.Marshal(ret)
+			objs := []interface{}{ret}
+			me.annotatePlayback(objs, client)
+			result, err := cds.MarshalDIDL(objs)
 			if err != nil {
 				return nil, err
 			}
 			return [][2]string{
-				{"Result", didl_lite(func() string { return string(buf) }())},
+				{"Result", result},
 				{"NumberReturned", "1"},
 				{"TotalMatches", "1"},
 				{"UpdateID", me.updateIDString()},
@@ -466,8 +827,35 @@ func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http
 			)
 		}
 	case "GetSearchCapabilities":
+		caps := ""
+		if me.Search != nil {
+			caps = searchCapabilities
+		}
+		return [][2]string{
+			{"SearchCaps", caps},
+		}, nil
+	case "Search":
+		if me.Search == nil {
+			return nil, upnp.InvalidActionError
+		}
+		var args cds.SearchArgs
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, err
+		}
+		objs, totalMatches, err := me.search(r.Context(), args, host, userAgent, client)
+		if err != nil {
+			return nil, err
+		}
+		me.annotatePlayback(objs, client)
+		result, err := cds.MarshalDIDL(objs)
+		if err != nil {
+			return nil, err
+		}
 		return [][2]string{
-			{"SearchCaps", ""},
+			{"Result", result},
+			{"NumberReturned", strconv.Itoa(len(objs))},
+			{"TotalMatches", strconv.Itoa(totalMatches)},
+			{"UpdateID", me.updateIDString()},
 		}, nil
 	// Samsung Extensions
 	case "X_GetFeatureList":
@@ -522,7 +910,7 @@ func (me *contentDirectoryService) isOfInterest(
 		return
 	}
 
-	mimeType, err := MimeTypeByPath(me.FS, entryFilePath)
+	mimeType, err := me.mimeTypeByPath(entryFilePath)
 	if err != nil {
 		return
 	}
@@ -539,11 +927,10 @@ func (cds *contentDirectoryService) objectChildCount(me object) (count int) {
 	if err != nil {
 		return
 	}
-	for _, fi := range fileInfoSlice {
-		child := object{path.Join(me.Path, fi.Name()), cds.RootObjectPath}
-		isChild, err := cds.isOfInterest(child, fi)
+	for _, e := range cds.containerEntries(me, fileInfoSlice) {
+		isChild, err := cds.isOfInterest(e.object, e.FileInfo)
 		if err != nil {
-			cds.Logger.Printf("error with %s: %s", child.FilePath(), err)
+			cds.Logger.Printf("error with %s: %s", e.FilePath(), err)
 			continue
 		}
 
@@ -596,7 +983,7 @@ func (o object) ID() string {
 }
 
 func (o *object) IsRoot() bool {
-	return o.Path == "./"
+	return o.Path == "./" || o.Path == "."
 }
 
 // Returns the object's parent ObjectID. Fortunately it can be deduced from the
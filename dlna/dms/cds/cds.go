@@ -0,0 +1,84 @@
+// Package cds has the parts of the UPnP ContentDirectory service that don't
+// depend on where the content comes from: action arguments, paging and
+// DIDL-Lite encoding.
+package cds
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sync"
+)
+
+// Arguments of the Browse action.
+type BrowseArgs struct {
+	ObjectID       string
+	BrowseFlag     string
+	Filter         string
+	StartingIndex  int
+	RequestedCount int
+}
+
+// Arguments of the Search action.
+type SearchArgs struct {
+	ContainerID    string
+	SearchCriteria string
+	Filter         string
+	StartingIndex  int
+	RequestedCount int
+	SortCriteria   string
+}
+
+// Returns the page of objs starting at start, with at most count objects, or
+// all the rest if count is zero.
+func Page[T any](objs []T, start, count int) []T {
+	objs = objs[min(max(start, 0), len(objs)):]
+	if count > 0 && count < len(objs) {
+		objs = objs[:count]
+	}
+	return objs
+}
+
+const (
+	didlLiteStart = `<DIDL-Lite` +
+		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
+		` xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/"` +
+		` xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"` +
+		` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/"` +
+		` xmlns:sec="http://www.sec.co.kr/">`
+	didlLiteEnd = `</DIDL-Lite>`
+)
+
+// Larger buffers aren't kept for reuse, so one huge folder doesn't pin memory.
+const maxPooledBufferSize = 4 << 20
+
+// An XML encoder bound to its own buffer, so both can be reused together.
+type didlEncoder struct {
+	buf bytes.Buffer
+	enc *xml.Encoder
+}
+
+var didlEncoderPool = sync.Pool{
+	New: func() interface{} {
+		e := new(didlEncoder)
+		e.enc = xml.NewEncoder(&e.buf)
+		return e
+	},
+}
+
+// Returns the DIDL-Lite document listing the objects, such as upnpav.Items
+// and upnpav.Containers.
+func MarshalDIDL(objs []interface{}) (string, error) {
+	e := didlEncoderPool.Get().(*didlEncoder)
+	e.buf.Reset()
+	e.buf.WriteString(didlLiteStart)
+	if err := e.enc.Encode(objs); err != nil {
+		// The encoder may be left mid element, so isn't reused.
+		return "", err
+	}
+	e.buf.WriteString(didlLiteEnd)
+	ret := e.buf.String()
+	if e.buf.Cap() <= maxPooledBufferSize {
+		didlEncoderPool.Put(e)
+	}
+	return ret, nil
+}
@@ -0,0 +1,48 @@
+package cds
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestMarshalDIDL(t *testing.T) {
+	objs := []interface{}{
+		upnpav.Container{Object: upnpav.Object{ID: "Films", Title: `"Tom & Jerry's"`}, ChildCount: 2},
+		upnpav.Item{Object: upnpav.Object{ID: "a.mkv", Title: "<a>"}},
+		nil,
+	}
+	// Reuses the pooled encoder.
+	for range 2 {
+		didl, err := MarshalDIDL(objs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inner, err := xml.Marshal(objs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := didlLiteStart + string(inner) + didlLiteEnd; didl != want {
+			t.Errorf("got %q, want %q", didl, want)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	objs := []int{0, 1, 2, 3, 4}
+	for _, c := range []struct {
+		start, count int
+		want         []int
+	}{
+		{0, 0, []int{0, 1, 2, 3, 4}},
+		{1, 2, []int{1, 2}},
+		{3, 10, []int{3, 4}},
+		{9, 1, []int{}},
+	} {
+		if got := Page(objs, c.start, c.count); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Page(%d, %d) = %v, want %v", c.start, c.count, got, c.want)
+		}
+	}
+}
@@ -1,8 +1,14 @@
 package dms
 
 import (
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
 )
 
 func TestEscapeObjectID(t *testing.T) {
@@ -26,3 +32,78 @@ func TestRootParentObjectID(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestDynamicStreamItems(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Live/news.dms.json": {Data: []byte(`{
+				"Title": "News", "Artwork": "https://example.com/news.png", "Description": "Rolling news",
+				"Resources": [
+					{"MimeType": "video/mp4", "Resolution": "1920x1080", "Command": "hd"},
+					{"MimeType": "video/mp4", "Resolution": "640x360", "Duration": "1:00:00", "Command": "sd"}
+				]
+			}`)},
+			"Live/radio.dms.json": {Data: []byte(`{
+				"Title": "Radio", "Artwork": "radio.png",
+				"Items": [
+					{"Title": "Radio 1", "Type": "audio", "Resources": [{"MimeType": "audio/mpeg", "SampleFrequency": 44100, "NrAudioChannels": 2, "Command": "r1"}]},
+					{"Type": "audio", "Artwork": "radio2.jpg", "Resources": [{"MimeType": "audio/mpeg", "Command": "r2"}]}
+				]
+			}`)},
+			"Live/radio.png": {Data: []byte("png")},
+		},
+		RootObjectPath:      ".",
+		AllowDynamicStreams: true,
+		NoTranscode:         true,
+		Logger:              log.Default,
+		FFProbeCache:        mapCache{},
+	}
+	s.FFProbeCache.Set(ffmpegInfoCacheKey{"Live/radio.png", time.Time{}.UnixNano()}, &ffprobe.Info{})
+	cdService := &contentDirectoryService{Server: s}
+	browse := func(id, flag string) string {
+		ret, err := cdService.Handle("Browse", []byte("<u:Browse><ObjectID>"+id+"</ObjectID><BrowseFlag>"+flag+"</BrowseFlag></u:Browse>"), httptest.NewRequest("POST", "/", nil))
+		if err != nil {
+			t.Fatalf("%s %s: %v", flag, id, err)
+		}
+		return ret[0][1]
+	}
+
+	live := browse("Live", "BrowseDirectChildren")
+	for _, want := range []string{
+		`<upnp:albumArtURI>https://example.com/news.png</upnp:albumArtURI>`,
+		`<dc:description>Rolling news</dc:description>`,
+		`protocolInfo="http-get:*:image/png:*">https://example.com/news.png</res>`,
+		`resolution="640x360">http://example.com/res?index=1&amp;path=Live%2Fnews.dms.json</res>`,
+		`<container id="Live%2Fradio.dms.json" parentID="Live" restricted="1" searchable="0" childCount="2">`,
+	} {
+		if !strings.Contains(live, want) {
+			t.Errorf("Live lacks %s:\n%s", want, live)
+		}
+	}
+	if strings.Count(live, `duration="1:00:00"`) != 1 {
+		t.Errorf("the SD version's duration isn't its own:\n%s", live)
+	}
+
+	radio := browse("Live%2Fradio.dms.json", "BrowseDirectChildren")
+	for _, want := range []string{
+		`<item id="Live%2Fradio.dms.json%2F0" parentID="Live%2Fradio.dms.json" restricted="1" searchable="0">`,
+		`<dc:title>Radio 1</dc:title>`,
+		`<dc:title>radio 2</dc:title>`,
+		`sampleFrequency="44100" nrAudioChannels="2">http://example.com/res?index=0&amp;item=0&amp;path=Live%2Fradio.dms.json</res>`,
+		`protocolInfo="http-get:*:image/png:*">http://example.com/icon?item=0&amp;path=Live%2Fradio.dms.json</res>`,
+		`protocolInfo="http-get:*:image/jpeg:*">http://example.com/icon?item=1&amp;path=Live%2Fradio.dms.json</res>`,
+	} {
+		if !strings.Contains(radio, want) {
+			t.Errorf("radio lacks %s:\n%s", want, radio)
+		}
+	}
+	if got := browse("Live%2Fradio.dms.json%2F1", "BrowseMetadata"); !strings.Contains(got, "<dc:title>radio 2</dc:title>") {
+		t.Errorf("got %s", got)
+	}
+
+	w := httptest.NewRecorder()
+	s.serveIcon(w, httptest.NewRequest("GET", "/icon?item=0&path=Live%2Fradio.dms.json", nil))
+	if w.Body.String() != "png" {
+		t.Errorf("served artwork %q", w.Body)
+	}
+}
@@ -0,0 +1,199 @@
+package dms
+
+import (
+	"io/fs"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/dlna/dms/clientprofile"
+	"github.com/anacrolix/dms/transcode"
+)
+
+// Behaviour specific to a kind of client. The first profile in
+// Server.ClientProfiles that matches a request applies.
+type ClientProfile = clientprofile.Profile
+
+type ResourceKind = clientprofile.ResourceKind
+
+const (
+	RawResource       = clientprofile.RawResource
+	ImageResource     = clientprofile.ImageResource
+	TranscodeResource = clientprofile.TranscodeResource
+	DynamicResource   = clientprofile.DynamicResource
+	ThumbnailResource = clientprofile.ThumbnailResource
+	GrowingResource   = clientprofile.GrowingResource
+)
+
+// Returns the profile for the client with the given User-Agent, or nil.
+func (me *Server) clientProfile(userAgent string) *ClientProfile {
+	return clientprofile.Profiles(me.ClientProfiles).Match(userAgent)
+}
+
+// Returns the DLNA.ORG_FLAGS for a kind of resource served to a client.
+func (me *Server) dlnaFlags(userAgent string, kind ResourceKind) string {
+	return me.clientProfile(userAgent).Flags(kind)
+}
+
+// Returns the kind of resource a file is when served as is.
+func (me *Server) rawResourceKind(mt mimeType, filePath string, fi fs.FileInfo) ResourceKind {
+	if mt.IsImage() {
+		return ImageResource
+	}
+	if fi != nil && me.isGrowing(filePath, fi) {
+		return GrowingResource
+	}
+	return RawResource
+}
+
+// Reports whether the file is still being written to, because it's the file
+// of a recording under way, or it has been seen to grow.
+func (me *Server) isGrowing(filePath string, fi fs.FileInfo) bool {
+	if me.Recordings != nil {
+		if r, ok := me.Recordings.byPath(filePath); ok && r.State == RecordingActive && r.Paths[len(r.Paths)-1] == filePath {
+			return true
+		}
+	}
+	return me.growingFiles.IsGrowing(filePath, me.liveFileInfo(filePath, fi))
+}
+
+// Returns the file's info from the filesystem, rather than the Library, whose
+// sizes are only as fresh as the last scan. It's fi if that fails.
+func (me *Server) liveFileInfo(filePath string, fi fs.FileInfo) fs.FileInfo {
+	if me.Library == nil {
+		return fi
+	}
+	if live, err := fs.Stat(me.liveFS, filePath); err == nil {
+		return live
+	}
+	return fi
+}
+
+// Returns the options that bring an audio file within the sample rate and bit
+// depth limits of the client, and whether the file exceeds them.
+func (me *Server) audioCaps(userAgent string, info *ffprobe.Info) (opts transcode.AudioOptions, exceeds bool) {
+	p := me.clientProfile(userAgent)
+	a := firstStream(info, "audio")
+	if p == nil || a == nil {
+		return
+	}
+	rate := int(streamInt(a, "sample_rate"))
+	if p.MaxSampleRate != 0 && rate > p.MaxSampleRate {
+		opts.SampleRate = cappedSampleRate(rate, p.MaxSampleRate)
+		exceeds = true
+	}
+	depth := int(max(streamInt(a, "bits_per_raw_sample"), streamInt(a, "bits_per_sample")))
+	if p.MaxBitDepth != 0 && depth > p.MaxBitDepth {
+		opts.SampleFormat = "s16"
+		if p.MaxBitDepth >= 24 {
+			// FLAC stores s32 as 24 bits.
+			opts.SampleFormat = "s32"
+		}
+		exceeds = true
+	}
+	return
+}
+
+// Returns the highest sample rate within the limit, preferring a whole
+// fraction of the original rate so the resampling is simple.
+func cappedSampleRate(rate, limit int) int {
+	for _, base := range []int{44100, 48000} {
+		if rate%base != 0 || base > limit {
+			continue
+		}
+		for base*2 <= limit {
+			base *= 2
+		}
+		return base
+	}
+	return limit
+}
+
+// Returns the adjustments transcodes make to the audio track for the client,
+// given the probed source.
+func (me *Server) clientAudioOptions(userAgent string, info *ffprobe.Info, track int) (opts transcode.AudioOptions) {
+	opts, _ = me.audioCaps(userAgent, info)
+	opts.Track = track
+	p := me.clientProfile(userAgent)
+	a := audioStream(info, track)
+	if p == nil || a == nil {
+		return
+	}
+	if gain, ok := replayGain(info, p.ReplayGain); ok {
+		opts.Filters = append(opts.Filters, volumeFilter(gain+p.ReplayGainPreamp))
+	}
+	if p.Downmix && streamInt(a, "channels") > 2 {
+		d := transcode.DefaultDownmix
+		if p.DownmixCoefficients != nil {
+			d = *p.DownmixCoefficients
+		}
+		if f := d.Filter(streamString(a, "channel_layout")); f != "" {
+			opts.Filters = append(opts.Filters, f)
+		} else {
+			// Leave unfamiliar layouts to ffmpeg's own matrix.
+			opts.Channels = 2
+		}
+	}
+	if p.Loudnorm && !p.Gapless {
+		l := transcode.DefaultLoudnorm
+		if p.LoudnormTargets != nil {
+			l = *p.LoudnormTargets
+		}
+		opts.Filters = append(opts.Filters, l.Filter())
+		if opts.SampleRate == 0 {
+			// Otherwise the output is at loudnorm's internal 192kHz.
+			opts.SampleRate = int(streamInt(a, "sample_rate"))
+		}
+	}
+	return
+}
+
+// Reports whether audio files are transcoded for the client rather than served
+// as they are.
+func (me *Server) adjustsAudio(userAgent string, info *ffprobe.Info) bool {
+	if _, exceeds := me.audioCaps(userAgent, info); exceeds {
+		return true
+	}
+	if p := me.clientProfile(userAgent); p != nil {
+		_, ok := replayGain(info, p.ReplayGain)
+		return ok
+	}
+	return false
+}
+
+// Returns the codec of the video if the client can't decode it, so it's only
+// offered transcoded. Unprobed files are assumed to be fine.
+func (me *Server) undecodableVideo(userAgent string, info *ffprobe.Info) (codec string, ok bool) {
+	v := movingVideoStream(info)
+	if v == nil {
+		return
+	}
+	codec = streamString(v, "codec_name")
+	return codec, codec != "" && !me.clientProfile(userAgent).DecodesVideo(codec)
+}
+
+// Returns the ffmpeg filter deinterlacing the video when it's transcoded for
+// the client, or "" if it isn't interlaced or the client wants it left so.
+func (me *Server) deinterlaceFilter(userAgent string, info *ffprobe.Info) string {
+	if v := movingVideoStream(info); v == nil || !streamInterlaced(v) {
+		return ""
+	}
+	return me.clientProfile(userAgent).DeinterlaceFilter()
+}
+
+// Reports whether the video is only offered to the client transcoded, because
+// it can't decode the video, but can decode a transcode of it.
+func (me *Server) onlyTranscodesVideo(userAgent string, info *ffprobe.Info) bool {
+	if me.NoTranscode {
+		return false
+	}
+	if _, ok := me.undecodableVideo(userAgent, info); !ok {
+		return false
+	}
+	profile := me.clientProfile(userAgent)
+	for _, ts := range transcodes {
+		if ts.videoCodec != "" && profile.DecodesVideo(ts.videoCodec) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+// Package clientprofile describes behaviour specific to kinds of DLNA client,
+// matched by User-Agent.
+package clientprofile
+
+import (
+	"strings"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/transcode"
+)
+
+// The kinds of resource offered for an item, which may be treated differently
+// by clients.
+type ResourceKind string
+
+const (
+	RawResource       ResourceKind = "raw"
+	ImageResource     ResourceKind = "image"
+	TranscodeResource ResourceKind = "transcode"
+	DynamicResource   ResourceKind = "dynamic"
+	ThumbnailResource ResourceKind = "thumbnail"
+	// A raw file that's still being written to, such as a recording.
+	GrowingResource ResourceKind = "growing"
+)
+
+// DLNA.ORG_FLAGS per kind of resource. Files and transcodes are streamed, and
+// may be stalled by the client. Images and thumbnails are fetched
+// interactively. Dynamic streams are live, and paced by the sender.
+var DefaultDLNAFlags = map[ResourceKind]string{
+	RawResource: dlna.FormatFlags(dlna.FlagStreamingTransferMode |
+		dlna.FlagBackgroundTransferMode | dlna.FlagConnectionStall | dlna.FlagDLNAV15),
+	ImageResource: dlna.FormatFlags(dlna.FlagInteractiveTransferMode |
+		dlna.FlagBackgroundTransferMode | dlna.FlagConnectionStall | dlna.FlagDLNAV15),
+	TranscodeResource: dlna.FormatFlags(dlna.FlagStreamingTransferMode |
+		dlna.FlagBackgroundTransferMode | dlna.FlagConnectionStall | dlna.FlagDLNAV15),
+	DynamicResource: dlna.FormatFlags(dlna.FlagSenderPaced | dlna.FlagS0Increase |
+		dlna.FlagSNIncrease | dlna.FlagStreamingTransferMode |
+		dlna.FlagBackgroundTransferMode | dlna.FlagDLNAV15),
+	ThumbnailResource: dlna.FormatFlags(dlna.FlagInteractiveTransferMode |
+		dlna.FlagBackgroundTransferMode | dlna.FlagDLNAV15),
+	GrowingResource: dlna.FormatFlags(dlna.FlagSNIncrease | dlna.FlagStreamingTransferMode |
+		dlna.FlagBackgroundTransferMode | dlna.FlagConnectionStall | dlna.FlagDLNAV15),
+}
+
+// Behaviour specific to a kind of client.
+type Profile struct {
+	// Used for logging.
+	Name string
+	// Matches requests whose User-Agent contains this string.
+	UserAgent string
+	// DLNA.ORG_FLAGS to use in place of the defaults, by resource kind.
+	DLNAFlags map[ResourceKind]string
+	// The client plays DSD sent as DoP (DSD over PCM) in 24 bit WAV.
+	DoP bool
+	// Audio with a higher sample rate or bit depth is resampled on the fly to
+	// fit, for receivers that can't cope with hi-res streams. Zero means no
+	// limit.
+	MaxSampleRate int
+	MaxBitDepth   int
+	// Surround audio is mixed down to stereo when transcoding, for receivers
+	// that can't decode multichannel streams.
+	Downmix bool
+	// Replaces transcode.DefaultDownmix.
+	DownmixCoefficients *transcode.Downmix
+	// Transcoded audio is normalized to a consistent loudness.
+	Loudnorm bool
+	// Replaces transcode.DefaultLoudnorm.
+	LoudnormTargets *transcode.Loudnorm
+	// Either "track" or "album". Audio with ReplayGain or R128 gain tags is
+	// served with the gain applied, for renderers that ignore the tags.
+	ReplayGain string
+	// Added to the ReplayGain adjustment, in dB.
+	ReplayGainPreamp float64
+	// The renderer plays albums gaplessly. Adjustments that vary across
+	// track boundaries, like loudness normalization, are skipped.
+	Gapless bool
+	// The highest parental rating of video listed, such as "PG", "TV-14" or
+	// an age like "12". Videos rated higher in their NFO files are hidden
+	// from Browse and Search, for children's TVs.
+	MaxRating string
+	// Videos without a rating are hidden too, if MaxRating is set.
+	HideUnrated bool
+	// Languages of the audio track to transcode videos with, most preferred
+	// first, as ISO 639 codes like "fr" or "fre". Otherwise the track marked
+	// as the default is used.
+	AudioLanguages []string
+	// Languages of the embedded subtitles to burn into transcoded videos,
+	// most preferred first. Videos without subtitles in any of them are
+	// transcoded without. They're also preferred among subtitle files named
+	// like "Film.fr.srt".
+	SubtitleLanguages []string
+	// Subtitles aren't offered to the renderer, nor burnt in unless a
+	// transcode URL asks for them.
+	NoSubtitles bool
+	// The ffprobe names of the video codecs the renderer decodes, such as
+	// "h264", "hevc", "vp9" or "av1". Videos in others are only offered
+	// transcoded, as are transcodes to them. Empty means it decodes them all.
+	VideoCodecs []string
+	// How interlaced videos, such as DVB recordings and DVD rips, are
+	// deinterlaced when transcoded: "bwdif", the default, "yadif", which is
+	// faster, or "off" to leave them interlaced.
+	Deinterlace string
+	// How transcodes are sent: "chunked", the default, "close", without
+	// chunking and ending the stream by closing the connection, or "length",
+	// which buffers the start of the transcode to state a Content-Length,
+	// estimated from it unless the whole transcode fits.
+	TranscodeDelivery string
+	// The MIME-types of the images the renderer shows, such as "image/jpeg"
+	// and "image/png". Images of others, such as WebP or AVIF, are served
+	// converted to JPEG. Empty means it shows them all.
+	ImageTypes []string
+	// MIME-types by file extension, such as "mkv": "video/x-mkv", to give
+	// the client in place of the usual ones. It doesn't change which files
+	// are listed, or how they're handled.
+	MimeTypes map[string]string
+	// What the renderer sees in the root: "folders", the default, for the
+	// shared folders as they are, "types", for containers listing all the
+	// music, videos and photos flat, or "both", the types ahead of the
+	// folders.
+	View string
+	// The resources offered for each item, in order, by name: "raw" for the
+	// file as it is, or adjusted to the profile's audio limits, and
+	// transcodes by their names, such as "t", "web" or "chromecast", and
+	// "hls" for videos as HLS, which is only offered if named. Renderers
+	// tend to play the first they can. Those not named aren't
+	// offered, unless none named apply to the item. Empty means the file
+	// first, then every transcode.
+	Resources []string
+	// Answer the renderer's SSDP searches with the HTTPS location, if there's
+	// an HTTPS listener, so it browses and streams over TLS.
+	HTTPS bool
+}
+
+// Reports whether the profile applies to the client with the User-Agent.
+func (me *Profile) Matches(userAgent string) bool {
+	return me.UserAgent != "" && strings.Contains(userAgent, me.UserAgent)
+}
+
+// Returns the DLNA.ORG_FLAGS for a kind of resource served to the client. The
+// profile may be nil, for clients without one.
+func (me *Profile) Flags(kind ResourceKind) string {
+	if me != nil {
+		if flags, ok := me.DLNAFlags[kind]; ok {
+			return flags
+		}
+	}
+	return DefaultDLNAFlags[kind]
+}
+
+// Reports whether the client decodes video in the codec, named as ffprobe
+// does. The profile may be nil, for clients without one.
+func (me *Profile) DecodesVideo(codec string) bool {
+	if me == nil || len(me.VideoCodecs) == 0 {
+		return true
+	}
+	for _, c := range me.VideoCodecs {
+		if strings.EqualFold(c, codec) {
+			return true
+		}
+	}
+	return false
+}
+
+// The values of Profile.Deinterlace.
+const (
+	DeinterlaceBWDIF = "bwdif"
+	DeinterlaceYADIF = "yadif"
+	DeinterlaceOff   = "off"
+)
+
+// Returns the ffmpeg filter deinterlacing videos transcoded for the client,
+// or "" if they're left interlaced. The profile may be nil, for clients
+// without one.
+func (me *Profile) DeinterlaceFilter() string {
+	if me == nil || me.Deinterlace == "" {
+		return DeinterlaceBWDIF
+	}
+	if me.Deinterlace == DeinterlaceOff {
+		return ""
+	}
+	return me.Deinterlace
+}
+
+// The values of Profile.TranscodeDelivery.
+const (
+	DeliveryChunked = "chunked"
+	DeliveryClose   = "close"
+	DeliveryLength  = "length"
+)
+
+// Returns how transcodes are sent to the client. The profile may be nil, for
+// clients without one.
+func (me *Profile) Delivery() string {
+	if me == nil || me.TranscodeDelivery == "" {
+		return DeliveryChunked
+	}
+	return me.TranscodeDelivery
+}
+
+// Reports whether the client shows images of the MIME-type. The profile may
+// be nil, for clients without one.
+func (me *Profile) ShowsImage(mimeType string) bool {
+	if me == nil || len(me.ImageTypes) == 0 {
+		return true
+	}
+	for _, t := range me.ImageTypes {
+		if strings.EqualFold(t, mimeType) {
+			return true
+		}
+	}
+	return false
+}
+
+// The values of Profile.View.
+const (
+	ViewFolders = "folders"
+	ViewTypes   = "types"
+	ViewBoth    = "both"
+)
+
+// Reports whether the client sees the shared folders in the root. The profile
+// may be nil, for clients without one.
+func (me *Profile) ShowsFolders() bool {
+	return me == nil || me.View != ViewTypes
+}
+
+// Reports whether the client sees containers of each media type in the root.
+// The profile may be nil, for clients without one.
+func (me *Profile) ShowsMediaTypes() bool {
+	return me != nil && (me.View == ViewTypes || me.View == ViewBoth)
+}
+
+// Profiles in order of preference.
+type Profiles []Profile
+
+// Returns the first profile matching the client with the User-Agent, or nil.
+func (me Profiles) Match(userAgent string) *Profile {
+	for i := range me {
+		if me[i].Matches(userAgent) {
+			return &me[i]
+		}
+	}
+	return nil
+}
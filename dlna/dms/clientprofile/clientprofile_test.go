@@ -0,0 +1,119 @@
+package clientprofile
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	ps := Profiles{
+		{Name: "any"},
+		{Name: "tv", UserAgent: "PickyTV", DLNAFlags: map[ResourceKind]string{
+			RawResource: "21700000000000000000000000000000",
+		}},
+	}
+	p := ps.Match("PickyTV/1.0")
+	if p == nil || p.Name != "tv" {
+		t.Fatalf("got %+v", p)
+	}
+	if a := p.Flags(RawResource); a != "21700000000000000000000000000000" {
+		t.Error(a)
+	}
+	if a := p.Flags(ImageResource); a != DefaultDLNAFlags[ImageResource] {
+		t.Error(a)
+	}
+	// Profiles without a User-Agent don't match everything.
+	p = ps.Match("OtherTV/1.0")
+	if p != nil {
+		t.Fatalf("got %+v", p)
+	}
+	if a := p.Flags(RawResource); a != DefaultDLNAFlags[RawResource] {
+		t.Error(a)
+	}
+}
+
+func TestRatingAge(t *testing.T) {
+	for _, tc := range []struct {
+		rating string
+		age    int
+		ok     bool
+	}{
+		{"Rated PG-13", 13, true},
+		{"US:R", 17, true},
+		{"tv-y7", 7, true},
+		{"UK:12A", 12, true},
+		{"Germany:FSK 16", 16, true},
+		{"FSK-6", 6, true},
+		{"16+", 16, true},
+		{"Not Rated", 0, false},
+		{"", 0, false},
+	} {
+		age, ok := RatingAge(tc.rating)
+		if age != tc.age || ok != tc.ok {
+			t.Errorf("RatingAge(%q) = %d, %v, want %d, %v", tc.rating, age, ok, tc.age, tc.ok)
+		}
+	}
+}
+
+func TestAllowsRating(t *testing.T) {
+	var none *Profile
+	if !none.AllowsRating("R") {
+		t.Error("nil profile hides R")
+	}
+	p := &Profile{MaxRating: "PG"}
+	for rating, want := range map[string]bool{"G": true, "PG": true, "TV-14": false, "FSK 12": false, "": true} {
+		if got := p.AllowsRating(rating); got != want {
+			t.Errorf("AllowsRating(%q) = %v", rating, got)
+		}
+	}
+	p.HideUnrated = true
+	if p.AllowsRating("") {
+		t.Error("unrated allowed with HideUnrated")
+	}
+}
+
+func TestDecodesVideo(t *testing.T) {
+	var none *Profile
+	if !none.DecodesVideo("av1") || !(&Profile{}).DecodesVideo("av1") {
+		t.Error("clients without codecs given decode everything")
+	}
+	p := &Profile{VideoCodecs: []string{"h264", "HEVC"}}
+	if !p.DecodesVideo("hevc") || p.DecodesVideo("vp9") {
+		t.Errorf("got %v, %v", p.DecodesVideo("hevc"), p.DecodesVideo("vp9"))
+	}
+}
+
+func TestShowsImage(t *testing.T) {
+	var none *Profile
+	if !none.ShowsImage("image/webp") || !(&Profile{}).ShowsImage("image/webp") {
+		t.Error("clients without image types given show everything")
+	}
+	p := &Profile{ImageTypes: []string{"image/jpeg", "image/PNG"}}
+	if !p.ShowsImage("image/png") || p.ShowsImage("image/avif") {
+		t.Errorf("got %v, %v", p.ShowsImage("image/png"), p.ShowsImage("image/avif"))
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	var none *Profile
+	if d := none.Delivery(); d != "chunked" {
+		t.Errorf("got %q without a profile", d)
+	}
+	if d := (&Profile{TranscodeDelivery: "length"}).Delivery(); d != "length" {
+		t.Errorf("got %q", d)
+	}
+}
+
+func TestDeinterlaceFilter(t *testing.T) {
+	var none *Profile
+	for _, test := range []struct {
+		p    *Profile
+		want string
+	}{
+		{none, "bwdif"},
+		{&Profile{}, "bwdif"},
+		{&Profile{Deinterlace: "yadif"}, "yadif"},
+		{&Profile{Deinterlace: "off"}, ""},
+	} {
+		if f := test.p.DeinterlaceFilter(); f != test.want {
+			t.Errorf("%+v: got %q", test.p, f)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package clientprofile
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The minimum ages of the parental ratings that aren't ages themselves: the
+// US film and TV ratings, and the UK's.
+var ratingAges = map[string]int{
+	"G":        0,
+	"PG":       10,
+	"PG-13":    13,
+	"R":        17,
+	"NC-17":    18,
+	"TV-Y":     0,
+	"TV-Y7":    7,
+	"TV-Y7-FV": 7,
+	"TV-G":     0,
+	"TV-PG":    10,
+	"TV-14":    14,
+	"TV-MA":    17,
+	"U":        0,
+	"UC":       0,
+	"12A":      12,
+	"R18":      18,
+	"X":        18,
+}
+
+// Returns the minimum age for a parental rating, as found in NFO files: one
+// of the US or UK ratings, or an age, such as "12", "FSK 16" or "16+". Any
+// "Rated " or country prefix, such as "US:", is ignored.
+func RatingAge(rating string) (age int, ok bool) {
+	s := strings.ToUpper(strings.TrimSpace(rating))
+	s = strings.TrimPrefix(s, "RATED ")
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		s = strings.TrimSpace(s[i+1:])
+	}
+	if age, ok := ratingAges[s]; ok {
+		return age, true
+	}
+	s = strings.TrimLeft(strings.TrimPrefix(s, "FSK"), " -")
+	s = strings.TrimSuffix(s, "+")
+	age, err := strconv.Atoi(s)
+	if err != nil || age < 0 || age > 21 {
+		return 0, false
+	}
+	return age, true
+}
+
+// Reports whether the client may list media with the parental rating, which
+// is empty for unrated media. The profile may be nil, for clients without
+// one.
+func (me *Profile) AllowsRating(rating string) bool {
+	if me == nil || me.MaxRating == "" {
+		return true
+	}
+	maxAge, ok := RatingAge(me.MaxRating)
+	if !ok {
+		return true
+	}
+	age, ok := RatingAge(rating)
+	if !ok {
+		return !me.HideUnrated
+	}
+	return age <= maxAge
+}
@@ -0,0 +1,252 @@
+package dms
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/dlna/dms/clientprofile"
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestDLNAFlagsClientProfile(t *testing.T) {
+	s := &Server{
+		ClientProfiles: []ClientProfile{{
+			Name:      "picky",
+			UserAgent: "PickyTV",
+			DLNAFlags: map[ResourceKind]string{
+				RawResource: "21700000000000000000000000000000",
+			},
+		}},
+	}
+	if a := s.dlnaFlags("PickyTV/1.0", RawResource); a != "21700000000000000000000000000000" {
+		t.Fatal(a)
+	}
+	if a := s.dlnaFlags("PickyTV/1.0", ImageResource); a != clientprofile.DefaultDLNAFlags[ImageResource] {
+		t.Fatal(a)
+	}
+	if a := s.dlnaFlags("OtherTV/1.0", RawResource); a != clientprofile.DefaultDLNAFlags[RawResource] {
+		t.Fatal(a)
+	}
+}
+
+func TestAudioCaps(t *testing.T) {
+	s := &Server{
+		ClientProfiles: []ClientProfile{{
+			UserAgent:     "OldReceiver",
+			MaxSampleRate: 48000,
+			MaxBitDepth:   16,
+		}},
+	}
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[{"codec_type":"audio","codec_name":"flac","sample_rate":"176400","bits_per_raw_sample":"24"}]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	opts, exceeds := s.audioCaps("OldReceiver/2.0", &info)
+	if !exceeds || opts.SampleRate != 44100 || opts.SampleFormat != "s16" {
+		t.Fatalf("unexpected caps %+v, %v", opts, exceeds)
+	}
+	if _, exceeds := s.audioCaps("NewReceiver/1.0", &info); exceeds {
+		t.Fatal("unprofiled client was capped")
+	}
+	if a := cappedSampleRate(192000, 96000); a != 96000 {
+		t.Fatal(a)
+	}
+	if a := cappedSampleRate(50000, 48000); a != 48000 {
+		t.Fatal(a)
+	}
+}
+
+func TestClientAudioOptionsDownmix(t *testing.T) {
+	s := &Server{
+		ClientProfiles: []ClientProfile{{
+			UserAgent: "StereoTV",
+			Downmix:   true,
+			DownmixCoefficients: &transcode.Downmix{
+				Center:   0.5,
+				Surround: 0.5,
+				LFE:      0.25,
+			},
+		}},
+	}
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[{"codec_type":"audio","codec_name":"ac3","channels":6,"channel_layout":"5.1(side)"}]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	opts := s.clientAudioOptions("StereoTV", &info, 0)
+	e := "pan=stereo|FL=FL+0.5*FC+0.25*LFE+0.5*SL|FR=FR+0.5*FC+0.25*LFE+0.5*SR"
+	if len(opts.Filters) != 1 || opts.Filters[0] != e {
+		t.Fatalf("expected filter %q but got %q", e, opts.Filters)
+	}
+	if opts := s.clientAudioOptions("SurroundTV", &info, 0); !opts.IsZero() {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+}
+
+func TestClientAudioOptionsLoudnorm(t *testing.T) {
+	s := &Server{
+		ClientProfiles: []ClientProfile{{
+			UserAgent:       "Kitchen",
+			Loudnorm:        true,
+			LoudnormTargets: &transcode.Loudnorm{Integrated: -16},
+		}},
+	}
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[{"codec_type":"audio","codec_name":"aac","channels":2,"sample_rate":"48000"}]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	opts := s.clientAudioOptions("Kitchen", &info, 0)
+	if len(opts.Filters) != 1 || opts.Filters[0] != "loudnorm=I=-16" {
+		t.Fatalf("unexpected filters %q", opts.Filters)
+	}
+	if opts.SampleRate != 48000 {
+		t.Fatalf("sample rate not kept: %d", opts.SampleRate)
+	}
+}
+
+func TestVideoCodecs(t *testing.T) {
+	s := &Server{
+		ClientProfiles: []ClientProfile{
+			{UserAgent: "OldTV", VideoCodecs: []string{"h264", "MPEG2VIDEO"}},
+			{UserAgent: "HEVCOnlyBox", VideoCodecs: []string{"hevc"}},
+		},
+	}
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[{"codec_type":"video","codec_name":"hevc"},{"codec_type":"audio","codec_name":"aac"}]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	if !s.onlyTranscodesVideo("OldTV/2015", &info) {
+		t.Error("HEVC served as is to an old TV")
+	}
+	// Nothing it decodes can be made, so it gets the file.
+	if s.onlyTranscodesVideo("HEVCOnlyBox", &info) {
+		t.Error("HEVC only box gets no video")
+	}
+	if s.onlyTranscodesVideo("NewTV/2024", &info) || s.onlyTranscodesVideo("OldTV/2015", nil) {
+		t.Error("transcoded for an unprofiled client or unprobed file")
+	}
+	var codecs []string
+	for _, r := range s.transcodeResources("localhost", "a.mkv", "video/x-matroska", "", "", "OldTV/2015", nil) {
+		switch {
+		case strings.Contains(r.URL, "transcode=vp8"):
+			codecs = append(codecs, "vp8")
+		case strings.Contains(r.URL, "transcode=t"):
+			codecs = append(codecs, "mpeg2video")
+		}
+	}
+	if slices.Contains(codecs, "vp8") || !slices.Contains(codecs, "mpeg2video") {
+		t.Errorf("offered transcodes to %q", codecs)
+	}
+	s.NoTranscode = true
+	if s.onlyTranscodesVideo("OldTV/2015", &info) {
+		t.Error("transcoding is off")
+	}
+}
+
+func TestDeinterlaceFilter(t *testing.T) {
+	s := &Server{
+		ClientProfiles: []ClientProfile{
+			{UserAgent: "FastTV", Deinterlace: "yadif"},
+			{UserAgent: "CRT", Deinterlace: "off"},
+		},
+	}
+	var interlaced, progressive ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[{"codec_type":"video","codec_name":"mpeg2video","field_order":"tt"}]}`), &interlaced); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[{"codec_type":"video","codec_name":"h264","field_order":"progressive"}]}`), &progressive); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		userAgent string
+		info      *ffprobe.Info
+		want      string
+	}{
+		{"NewTV", &interlaced, "bwdif"},
+		{"FastTV", &interlaced, "yadif"},
+		{"CRT", &interlaced, ""},
+		{"NewTV", &progressive, ""},
+		{"NewTV", nil, ""},
+	} {
+		if f := s.deinterlaceFilter(test.userAgent, test.info); f != test.want {
+			t.Errorf("%s: got %q", test.userAgent, f)
+		}
+	}
+}
+
+func TestResourceOrder(t *testing.T) {
+	if !slices.Equal(slices.Sorted(slices.Values(transcodeOrder)), TranscodeNames()) {
+		t.Fatalf("transcodeOrder %q doesn't list every transcode", transcodeOrder)
+	}
+	s := &Server{
+		ClientProfiles: []ClientProfile{
+			{UserAgent: "Chromecast", Resources: []string{"chromecast", "raw"}},
+			{UserAgent: "Shield", Resources: []string{"raw"}},
+			{UserAgent: "CastOnly", Resources: []string{"chromecast"}},
+		},
+	}
+	names := func(userAgent string, mt mimeType) (ret []string) {
+		media := append([]namedResource{{rawResourceName, upnpav.Resource{URL: "raw"}}}, s.transcodeResources("localhost", "a", mt, "", "", userAgent, nil)...)
+		for _, r := range s.orderResources(userAgent, media) {
+			name := "raw"
+			if u, _ := url.Parse(r.URL); u.Query().Has("transcode") {
+				name = u.Query().Get("transcode")
+			}
+			ret = append(ret, name)
+		}
+		return
+	}
+	for _, tc := range []struct {
+		userAgent string
+		mt        mimeType
+		want      []string
+	}{
+		{"VLC", "video/x-matroska", []string{"raw", "t", "vp8", "chromecast", "web"}},
+		{"Chromecast", "video/x-matroska", []string{"chromecast", "raw"}},
+		{"Shield", "video/x-matroska", []string{"raw"}},
+		// None of the transcodes it names apply to audio, so it gets them all.
+		{"CastOnly", "audio/mpeg", []string{"raw", "lpcm"}},
+		{"Chromecast", "audio/mpeg", []string{"raw"}},
+	} {
+		if got := names(tc.userAgent, tc.mt); !slices.Equal(got, tc.want) {
+			t.Errorf("%s, %s: got %q, want %q", tc.userAgent, tc.mt, got, tc.want)
+		}
+	}
+}
+
+// Growth is seen through the Library, whose sizes are those of the last scan.
+func TestGrowingWithLibrary(t *testing.T) {
+	mapFS := fstest.MapFS{"Recordings/match.ts": {Data: []byte("a")}}
+	lib, err := OpenLibrary(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Close()
+	s := &Server{closed: make(chan struct{}), Library: lib, liveFS: mapFS, FS: lib.FS(mapFS)}
+	dirs, err := s.scanLibrary(mapFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib.set(dirs)
+	kind := func() ResourceKind {
+		fi, err := fs.Stat(s.FS, "Recordings/match.ts")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.rawResourceKind(mimeType("video/mp2t"), "Recordings/match.ts", fi)
+	}
+	if k := kind(); k != RawResource {
+		t.Fatalf("before growing: %q", k)
+	}
+	mapFS["Recordings/match.ts"].Data = []byte("ab")
+	if k := kind(); k != GrowingResource {
+		t.Fatalf("after growing: %q", k)
+	}
+}
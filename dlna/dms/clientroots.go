@@ -0,0 +1,75 @@
+package dms
+
+import (
+	"net"
+	"path"
+	"strings"
+)
+
+// Limits the clients in some networks to parts of the library, such as
+// guests to a public folder.
+type ClientRoot struct {
+	// The networks of the clients it applies to.
+	Nets []*net.IPNet
+	// Folders, relative to the root, that the clients see. Of the rest, they
+	// only see the folders leading to these.
+	Paths []string
+}
+
+func (me *ClientRoot) matches(client string) bool {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return false
+	}
+	for _, n := range me.Nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the first of Server.ClientRoots that applies to the client, or nil.
+func (me *Server) clientRoot(client string) *ClientRoot {
+	for i := range me.ClientRoots {
+		if me.ClientRoots[i].matches(client) {
+			return &me.ClientRoots[i]
+		}
+	}
+	return nil
+}
+
+// Reports whether the file or folder is outside the ClientRoot for the
+// client.
+func (me *Server) outsideClientRoot(client, filePath string) bool {
+	cr := me.clientRoot(client)
+	if cr == nil {
+		return false
+	}
+	filePath = path.Clean(filePath)
+	for _, p := range cr.Paths {
+		p = path.Clean(strings.TrimPrefix(p, "/"))
+		if isWithin(filePath, p) || isWithin(p, filePath) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether p is dir or inside it.
+func isWithin(p, dir string) bool {
+	return dir == "." || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// Reports whether the client isn't allowed the file or folder, because it's
+// outside its ClientRoot, or in a protected folder it hasn't unlocked.
+func (me *Server) restrictedFrom(client, filePath string) bool {
+	return me.outsideClientRoot(client, filePath) || me.lockedFrom(client, filePath)
+}
+
+// Reports whether the file or folder is kept from the client, because it's
+// restrictedFrom it, or in HiddenPaths. It's applied to everything the client
+// browses, searches or fetches.
+func (me *Server) hiddenFrom(client, filePath string) bool {
+	return me.restrictedFrom(client, filePath) || me.softHidden(filePath)
+}
@@ -0,0 +1,89 @@
+package dms
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestClientRoots(t *testing.T) {
+	_, guests, _ := net.ParseCIDR("192.168.2.0/24")
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv":        {},
+			"Media/Public/Up.mkv":   {},
+			"Media/Private/Day.mkv": {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		Search:         &search.Index{},
+		ClientRoots:    []ClientRoot{{Nets: []*net.IPNet{guests}, Paths: []string{"Media/Public"}}},
+	}
+	s.indexLibrary()
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	cdService := &contentDirectoryService{Server: s}
+	const guest, owner = "192.168.2.7", "192.168.1.7"
+	titles := func(id, client string) (ret []string) {
+		obj, _ := cdService.objectFromID(id)
+		objs, err := cdService.browseChildren(context.Background(), id, obj, "localhost", "", client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range objs {
+			switch o := o.(type) {
+			case upnpav.Container:
+				ret = append(ret, o.Title)
+			case upnpav.Item:
+				ret = append(ret, o.Title)
+			}
+		}
+		return
+	}
+	if a := titles("0", owner); !slices.Equal(a, []string{"Films", "Media"}) {
+		t.Errorf("owner sees %q", a)
+	}
+	// Guests see the folders leading to theirs.
+	if a := titles("0", guest); !slices.Equal(a, []string{"Media"}) {
+		t.Errorf("guest sees %q", a)
+	}
+	if a := titles("Media", guest); !slices.Equal(a, []string{"Public"}) {
+		t.Errorf("guest sees %q", a)
+	}
+	if a := titles("Media%2FPublic", guest); !slices.Equal(a, []string{"Up.mkv"}) {
+		t.Errorf("guest sees %q", a)
+	}
+	_, total, err := cdService.search(context.Background(), cds.SearchArgs{
+		ContainerID:    "0",
+		SearchCriteria: `upnp:class derivedfrom "object.item.videoItem"`,
+	}, "localhost", "", guest)
+	if err != nil || total != 1 {
+		t.Errorf("guest searched %d videos: %v", total, err)
+	}
+	for p, want := range map[string]int{
+		"Media/Public/Up.mkv":   http.StatusOK,
+		"Media/Private/Day.mkv": http.StatusNotFound,
+		"Films/Heat.mkv":        http.StatusNotFound,
+	} {
+		req := httptest.NewRequest("GET", "/res?path="+url.QueryEscape(p), nil)
+		req.RemoteAddr = guest + ":1234"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("guest fetching %s got %d", p, w.Code)
+		}
+	}
+}
@@ -1,13 +1,23 @@
 package dms
 
 import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
 )
 
-// const defaultProtocolInfo = "http-get:*:video/mpeg:*,http-get:*:video/mp4:*,http-get:*:video/vnd.dlna.mpeg-tts:*,http-get:*:video/avi:*,http-get:*:video/x-matroska:*,http-get:*:video/x-ms-wmv:*,http-get:*:video/wtv:*,http-get:*:audio/mpeg:*,http-get:*:audio/mp3:*,http-get:*:audio/mp4:*,http-get:*:audio/x-ms-wma*,http-get:*:audio/wav:*,http-get:*:audio/L16:*,http-get:*image/jpeg:*,http-get:*image/png:*,http-get:*image/gif:*,http-get:*image/tiff:*"
-const defaultProtocolInfo = "http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_SM,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_MED,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_LRG,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_RES_H_V,http-get:*:image/png:DLNA.ORG_PN=PNG_TN,http-get:*:image/png:DLNA.ORG_PN=PNG_LRG,http-get:*:image/gif:DLNA.ORG_PN=GIF_LRG,http-get:*:audio/mpeg:DLNA.ORG_PN=MP3,http-get:*:audio/L16:DLNA.ORG_PN=LPCM,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_24_AC3_ISO;SONY.COM_PN=AVC_TS_HD_24_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_24_AC3;SONY.COM_PN=AVC_TS_HD_24_AC3,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_24_AC3_T;SONY.COM_PN=AVC_TS_HD_24_AC3_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_PS_PAL,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_PS_NTSC,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_50_L2_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_60_L2_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_SD_EU_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_EU,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_EU_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_50_AC3_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_HD_50_L2_ISO;SONY.COM_PN=HD2_50_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_60_AC3_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_HD_60_L2_ISO;SONY.COM_PN=HD2_60_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_HD_50_L2_T;SONY.COM_PN=HD2_50_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_HD_60_L2_T;SONY.COM_PN=HD2_60_T,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_50_AC3_ISO;SONY.COM_PN=AVC_TS_HD_50_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_50_AC3;SONY.COM_PN=AVC_TS_HD_50_AC3,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_60_AC3_ISO;SONY.COM_PN=AVC_TS_HD_60_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_60_AC3;SONY.COM_PN=AVC_TS_HD_60_AC3,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_50_AC3_T;SONY.COM_PN=AVC_TS_HD_50_AC3_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_60_AC3_T;SONY.COM_PN=AVC_TS_HD_60_AC3_T,http-get:*:video/x-mp2t-mphl-188:*,http-get:*:video/*:*,http-get:*:audio/*:*,http-get:*:image/*:*,http-get:*:text/srt:*,http-get:*:text/smi:*,http-get:*:text/ssa:*,http-get:*:*:*"
+// Protocol info for resources that are served regardless of library content.
+var fixedSourceProtocolInfo = []string{
+	"http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN",
+	"http-get:*:text/plain:*",
+}
 
 type connectionManagerService struct {
 	*Server
@@ -16,27 +26,195 @@ type connectionManagerService struct {
 
 func (cms *connectionManagerService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
 	switch action {
-	case ".GetCurrentConnectionInfo":
+	case "GetCurrentConnectionInfo":
+		var args struct {
+			ConnectionID int
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.Errorf(upnp.ArgumentValueInvalidErrorCode, "%s", err.Error())
+		}
+		c, ok := cms.connections.get(args.ConnectionID)
+		if !ok {
+			if args.ConnectionID != 0 {
+				return nil, upnp.Errorf(upnpav.InvalidConnectionReferenceErrorCode, "no such connection: %d", args.ConnectionID)
+			}
+			// Connection 0 always exists, it's the default for peers that
+			// don't prepare connections.
+			c = connection{PeerConnectionID: -1, Direction: "Output", Status: "OK"}
+		}
 		return [][2]string{
-			{"ConnectionID", "0"},
 			{"RcsID", "-1"},
 			{"AVTransportID", "-1"},
-			{"ProtocolInfo", ""},
-			{"PeerConnectionManager", ""},
-			{"PeerConnectionID", "-1"},
-			{"Direction", "Output"},
-			{"Status", "OK"},
+			{"ProtocolInfo", c.ProtocolInfo},
+			{"PeerConnectionManager", c.PeerConnectionManager},
+			{"PeerConnectionID", strconv.Itoa(c.PeerConnectionID)},
+			{"Direction", c.Direction},
+			{"Status", c.Status},
 		}, nil
 	case "GetCurrentConnectionIDs":
+		ids := []string{"0"}
+		for _, id := range cms.connections.ids() {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		return [][2]string{
+			{"ConnectionIDs", strings.Join(ids, ",")},
+		}, nil
+	case "PrepareForConnection":
+		var args struct {
+			RemoteProtocolInfo    string
+			PeerConnectionManager string
+			PeerConnectionID      int
+			Direction             string
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.Errorf(upnp.ArgumentValueInvalidErrorCode, "%s", err.Error())
+		}
+		// We can only be the source of a connection.
+		if args.Direction != "Output" {
+			return nil, upnp.Errorf(upnpav.IncompatibleDirectionsErrorCode, "unsupported direction: %q", args.Direction)
+		}
+		if !cms.canSource(args.RemoteProtocolInfo) {
+			return nil, upnp.Errorf(upnpav.IncompatibleProtocolInfoErrorCode, "incompatible protocol info: %q", args.RemoteProtocolInfo)
+		}
+		id := cms.connections.add(connection{
+			ProtocolInfo:          args.RemoteProtocolInfo,
+			PeerConnectionManager: args.PeerConnectionManager,
+			PeerConnectionID:      args.PeerConnectionID,
+			Direction:             args.Direction,
+			Status:                "OK",
+			RemoteAddr:            r.RemoteAddr,
+			prepared:              true,
+		})
 		return [][2]string{
-			{"ConnectionIDs", ""},
+			{"ConnectionID", strconv.Itoa(id)},
+			{"AVTransportID", "-1"},
+			{"RcsID", "-1"},
 		}, nil
+	case "ConnectionComplete":
+		var args struct {
+			ConnectionID int
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.Errorf(upnp.ArgumentValueInvalidErrorCode, "%s", err.Error())
+		}
+		if !cms.connections.remove(args.ConnectionID) {
+			return nil, upnp.Errorf(upnpav.InvalidConnectionReferenceErrorCode, "no such connection: %d", args.ConnectionID)
+		}
+		return [][2]string{}, nil
 	case "GetProtocolInfo":
 		return [][2]string{
-			{"Source", defaultProtocolInfo},
+			{"Source", cms.sourceProtocolInfo()},
 			{"Sink", ""},
 		}, nil
 	default:
 		return nil, upnp.InvalidActionError
 	}
 }
+
+// Records a MIME type of media that has been offered to clients.
+func (me *Server) noteMimeType(mt mimeType) {
+	me.mimeTypesMu.Lock()
+	defer me.mimeTypesMu.Unlock()
+	if me.mimeTypes == nil {
+		me.mimeTypes = make(map[mimeType]struct{})
+	}
+	me.mimeTypes[mt] = struct{}{}
+}
+
+// Walks the library once, guessing MIME types from file names, as Run starts.
+// Content is not sniffed, as that would require opening every file.
+func (me *Server) scanMimeTypes() {
+	err := fs.WalkDir(me.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip what we can't read, the walk is best effort.
+			return nil
+		}
+		select {
+		case <-me.closed:
+			return fs.SkipAll
+		default:
+		}
+		if d.IsDir() {
+			if p == "." {
+				return nil
+			}
+			if ignored, _ := me.IgnorePath(p); ignored {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		mt, ok := configuredMimeType(me.MimeTypes, d.Name())
+		if !ok {
+			mt = mimeTypeByBaseName(d.Name())
+		}
+		if mt.IsMedia() {
+			me.noteMimeType(mt)
+		}
+		return nil
+	})
+	if err != nil {
+		me.Logger.Printf("error scanning library mime types: %v", err)
+	}
+	me.mimeTypesMu.Lock()
+	me.mimeTypesScanned = true
+	me.mimeTypesMu.Unlock()
+}
+
+// Returns the SourceProtocolInfo value: the raw media types found in the
+// library, and the outputs of the configured transcodes. It's a wildcard until
+// the library has been scanned.
+func (me *Server) sourceProtocolInfo() string {
+	set := make(map[string]struct{})
+	me.mimeTypesMu.Lock()
+	if !me.mimeTypesScanned {
+		me.mimeTypesMu.Unlock()
+		return "http-get:*:*:*"
+	}
+	for mt := range me.mimeTypes {
+		set[fmt.Sprintf("http-get:*:%s:*", mt)] = struct{}{}
+	}
+	me.mimeTypesMu.Unlock()
+	if !me.NoTranscode {
+		for _, ts := range transcodes {
+			pn := "*"
+			if ts.DLNAProfileName != "" {
+				pn = "DLNA.ORG_PN=" + ts.DLNAProfileName
+			}
+			set[fmt.Sprintf("http-get:*:%s:%s", ts.mimeType, pn)] = struct{}{}
+		}
+	}
+	for _, pi := range fixedSourceProtocolInfo {
+		set[pi] = struct{}{}
+	}
+	ret := make([]string, 0, len(set))
+	for pi := range set {
+		ret = append(ret, pi)
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ",")
+}
+
+// Reports whether the given protocol info, as offered by a peer, matches
+// something we can provide. Only the protocol and content format fields are
+// considered, and wildcards in either match anything.
+func (me *Server) canSource(protocolInfo string) bool {
+	remote := strings.SplitN(protocolInfo, ":", 4)
+	if len(remote) != 4 {
+		return false
+	}
+	for _, pi := range strings.Split(me.sourceProtocolInfo(), ",") {
+		local := strings.SplitN(pi, ":", 4)
+		if len(local) != 4 {
+			continue
+		}
+		if !protocolInfoFieldMatch(remote[0], local[0]) || !protocolInfoFieldMatch(remote[2], local[2]) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func protocolInfoFieldMatch(a, b string) bool {
+	return a == "*" || b == "*" || strings.EqualFold(a, b)
+}
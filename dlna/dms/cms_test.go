@@ -0,0 +1,128 @@
+package dms
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSourceProtocolInfo(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"music/a.mp3":  {},
+			"notes.txt":    {},
+			"video/b.webm": {},
+		},
+		NoTranscode: true,
+	}
+	if a := s.sourceProtocolInfo(); a != "http-get:*:*:*" {
+		t.Fatalf("before scanning: %s", a)
+	}
+	s.scanMimeTypes()
+	e := "http-get:*:audio/mpeg:*,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN,http-get:*:text/plain:*,http-get:*:video/webm:*"
+	if a := s.sourceProtocolInfo(); a != e {
+		t.Fatal(a)
+	}
+}
+
+func TestConnectionTable(t *testing.T) {
+	var ct connectionTable
+	a := ct.add(connection{ProtocolInfo: "http-get:*:audio/mpeg:*"})
+	b := ct.add(connection{})
+	if a == b || a == 0 || b == 0 {
+		t.Fatalf("bad connection ids: %d, %d", a, b)
+	}
+	if c, ok := ct.get(a); !ok || c.ProtocolInfo != "http-get:*:audio/mpeg:*" {
+		t.Fatal(c)
+	}
+	if !ct.remove(a) || ct.remove(a) {
+		t.Fatal("unexpected remove result")
+	}
+	if ids := ct.ids(); len(ids) != 1 || ids[0] != b {
+		t.Fatal(ids)
+	}
+}
+
+func TestStreamConnections(t *testing.T) {
+	s := &Server{
+		FS:          fstest.MapFS{"a.mp3": {}},
+		NoTranscode: true,
+	}
+	cms := &connectionManagerService{Server: s}
+	handle := func(action, args string) map[string]string {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		ret, err := cms.Handle(action, []byte("<u:"+action+">"+args+"</u:"+action+">"), r)
+		if err != nil {
+			t.Fatalf("%s: %v", action, err)
+		}
+		m := make(map[string]string)
+		for _, arg := range ret {
+			m[arg[0]] = arg[1]
+		}
+		return m
+	}
+	id := handle("PrepareForConnection", "<RemoteProtocolInfo>http-get:*:audio/mpeg:*</RemoteProtocolInfo><PeerConnectionManager>uuid:tv/urn:upnp-org:serviceId:ConnectionManager</PeerConnectionManager><PeerConnectionID>3</PeerConnectionID><Direction>Output</Direction>")["ConnectionID"]
+
+	started := make(chan struct{})
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer s.trackConnection(r, "http-get:*:audio/mpeg:DLNA.ORG_OP=01")()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	ts.Config.ConnContext = withNetConn
+	ts.Start()
+	defer ts.Close()
+	got := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(ts.URL)
+		if err == nil {
+			_, err = resp.Body.Read(make([]byte, 1))
+			resp.Body.Close()
+		}
+		got <- err
+	}()
+	<-started
+
+	// The stream is served on the prepared connection.
+	if ids := handle("GetCurrentConnectionIDs", "")["ConnectionIDs"]; ids != "0,"+id {
+		t.Fatalf("got connection IDs %q, want 0,%s", ids, id)
+	}
+	if n := s.connections.streams(); n != 1 {
+		t.Fatalf("got %d streams", n)
+	}
+	if info := handle("GetCurrentConnectionInfo", fmt.Sprintf("<ConnectionID>%s</ConnectionID>", id)); info["PeerConnectionID"] != "3" {
+		t.Fatalf("got %v", info)
+	}
+	// Completing the connection stops the stream.
+	handle("ConnectionComplete", fmt.Sprintf("<ConnectionID>%s</ConnectionID>", id))
+	if err := <-got; err == nil {
+		t.Fatal("stream carried on after ConnectionComplete")
+	}
+	if ids := handle("GetCurrentConnectionIDs", "")["ConnectionIDs"]; ids != "0" {
+		t.Fatalf("got connection IDs %q", ids)
+	}
+}
+
+func TestCanSource(t *testing.T) {
+	s := &Server{
+		FS:          fstest.MapFS{"a.mp3": {}},
+		NoTranscode: true,
+	}
+	s.scanMimeTypes()
+	for pi, e := range map[string]bool{
+		"http-get:*:audio/mpeg:*":       true,
+		"http-get:*:*:*":                true,
+		"http-get:*:video/x-matroska:*": false,
+		"rtsp-rtp-udp:*:audio/mpeg:*":   false,
+		"garbage":                       false,
+	} {
+		if a := s.canSource(pi); a != e {
+			t.Errorf("canSource(%q): expected %v, got %v", pi, e, a)
+		}
+	}
+}
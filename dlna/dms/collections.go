@@ -0,0 +1,89 @@
+package dms
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/log"
+)
+
+// A container in the root of the library's items matching a query, like a
+// smart playlist. Collections are found in the search index, so need it.
+type Collection struct {
+	Title string
+	// UPnP ContentDirectory search criteria the items must match, such as
+	// `upnp:genre = "Documentary" and dc:date >= "2020"`. Empty matches
+	// everything.
+	Criteria string
+	// If set, only items whose path, or one of its folders, matches this
+	// path.Match pattern, such as "Kids/*" or "Films/*/*.mkv", are listed.
+	Path string
+	// Lists only the items the client hasn't played to the end.
+	Unwatched bool
+}
+
+// Prefix of the ObjectIDs of collections, followed by their index in
+// Server.Collections.
+const collectionIDPrefix = virtualIDPrefix + "collection/"
+
+// Reports whether the FS path, or a folder it's in, matches the pattern.
+func collectionPathMatch(pattern, p string) bool {
+	for p = strings.TrimPrefix(p, "/"); p != "." && p != ""; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the client has played the file to the end.
+func (me *Server) watched(client, p string) bool {
+	if me.Playback == nil {
+		return false
+	}
+	rec, ok := me.Playback.get(client, p)
+	// Plays after the first are counted once the one before finished.
+	return ok && (rec.Finished() || rec.PlayCount > 1)
+}
+
+// Returns the FS paths of the items in the collection for the client, in
+// path order.
+func (me *Server) collectionItems(c Collection, client string) (paths []string) {
+	if me.Search == nil {
+		return
+	}
+	criteria := c.Criteria
+	if strings.TrimSpace(criteria) == "" {
+		criteria = "*"
+	}
+	ids, err := me.Search.Search(criteria)
+	if err != nil {
+		me.Logger.Levelf(log.Warning, "collection %q: %v", c.Title, err)
+		return
+	}
+	for _, id := range ids {
+		if c.Path != "" && !collectionPathMatch(c.Path, id) {
+			continue
+		}
+		if c.Unwatched && me.watched(client, id) {
+			continue
+		}
+		paths = append(paths, id)
+	}
+	return
+}
+
+// Returns the virtual containers of the Collections.
+func (me *Server) collectionContainers() (ret []virtualContainer) {
+	for i, c := range me.Collections {
+		ret = append(ret, virtualContainer{
+			ID:    collectionIDPrefix + strconv.Itoa(i),
+			Title: c.Title,
+			Items: func(me *Server, client string) []string {
+				return me.collectionItems(c, client)
+			},
+		})
+	}
+	return
+}
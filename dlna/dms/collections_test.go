@@ -0,0 +1,105 @@
+package dms
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestCollections(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Earth.mkv":      {},
+			"Films/Heat.mkv":       {},
+			"Films/Old.mkv":        {},
+			"Kids/Docs/Bugs.mkv":   {},
+			"Kids/Docs/Whales.mkv": {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		Playback:       &PlaybackHistory{},
+		Search:         &search.Index{},
+		Collections: []Collection{
+			{Title: "New documentaries", Criteria: `upnp:genre = "Documentary" and dc:date >= "2020"`},
+			{Title: "Kids to watch", Path: "Kids", Unwatched: true},
+			{Title: "Nothing", Criteria: `upnp:genre = "Western"`},
+		},
+	}
+	for _, d := range []struct {
+		id, genre, date string
+	}{
+		{"Films/Earth.mkv", "Documentary", "2021-04-22"},
+		{"Films/Heat.mkv", "Crime", "1995-12-15"},
+		{"Films/Old.mkv", "Documentary", "1990"},
+		{"Kids/Docs/Bugs.mkv", "Documentary", "2022"},
+		{"Kids/Docs/Whales.mkv", "Documentary", "2023"},
+	} {
+		s.Search.Update(search.Document{ID: d.id, Fields: map[string][]string{
+			"upnp:genre": {d.genre},
+			"dc:date":    {d.date},
+		}})
+	}
+	s.Playback.record("tv", "Kids/Docs/Whales.mkv", PlaybackRecord{Position: time.Hour, Duration: time.Hour})
+	s.Playback.record("tv", "Kids/Docs/Bugs.mkv", PlaybackRecord{Position: time.Minute, Duration: time.Hour})
+
+	for _, c := range []struct {
+		collection int
+		client     string
+		want       []string
+	}{
+		{0, "tv", []string{"Films/Earth.mkv", "Kids/Docs/Bugs.mkv", "Kids/Docs/Whales.mkv"}},
+		{1, "tv", []string{"Kids/Docs/Bugs.mkv"}},
+		{1, "phone", []string{"Kids/Docs/Bugs.mkv", "Kids/Docs/Whales.mkv"}},
+		{2, "tv", nil},
+	} {
+		if got := s.collectionItems(s.Collections[c.collection], c.client); !slices.Equal(got, c.want) {
+			t.Errorf("%q for %q: got %q, want %q", s.Collections[c.collection].Title, c.client, got, c.want)
+		}
+	}
+
+	cds := &contentDirectoryService{Server: s}
+	var titles []string
+	for _, obj := range cds.rootVirtualContainers("", "tv") {
+		titles = append(titles, obj.(upnpav.Container).Title)
+	}
+	if want := []string{"Continue watching", "New documentaries", "Kids to watch"}; !slices.Equal(titles, want) {
+		t.Fatalf("got root containers %q, want %q", titles, want)
+	}
+	vc, ok := s.virtualContainerByID(collectionIDPrefix + "1")
+	if !ok {
+		t.Fatal("collection not found by ID")
+	}
+	objs := cds.virtualContainerChildren(context.Background(), vc, "localhost", "", "tv")
+	if len(objs) != 1 || objs[0].(upnpav.Item).ParentID != vc.ID {
+		t.Fatalf("got %+v", objs)
+	}
+	if _, ok := s.virtualContainerByID(collectionIDPrefix + "3"); ok {
+		t.Fatal("found a collection that isn't configured")
+	}
+}
+
+func TestCollectionPathMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"Kids", "Kids/Docs/Bugs.mkv", true},
+		{"Kids/*", "Kids/Docs/Bugs.mkv", true},
+		{"*/*.mkv", "Films/Heat.mkv", true},
+		{"Films/*.mkv", "Kids/Docs/Bugs.mkv", false},
+		{"Kid", "Kids/Docs/Bugs.mkv", false},
+	} {
+		if got := collectionPathMatch(c.pattern, c.path); got != c.want {
+			t.Errorf("%q, %q: got %v", c.pattern, c.path, got)
+		}
+	}
+}
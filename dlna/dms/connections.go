@@ -0,0 +1,178 @@
+package dms
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A ConnectionManager connection. The server only ever sources content, so
+// these correspond to streams being served, or prepared by a peer.
+type connection struct {
+	ID                    int
+	ProtocolInfo          string
+	PeerConnectionManager string
+	PeerConnectionID      int
+	Direction             string
+	Status                string
+	RemoteAddr            string
+	// Whether a peer made the connection with PrepareForConnection, so it
+	// outlives the streams served on it.
+	prepared bool
+	// Stops the stream being served on the connection, if there is one.
+	stop func()
+}
+
+// Tracks the connections exposed through the ConnectionManager service. The
+// zero value is ready for use.
+type connectionTable struct {
+	mu     sync.Mutex
+	lastID int
+	conns  map[int]*connection
+}
+
+// Allocates an ID for the connection and adds it to the table.
+func (me *connectionTable) add(c connection) int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.lastID++
+	c.ID = me.lastID
+	if me.conns == nil {
+		me.conns = make(map[int]*connection)
+	}
+	me.conns[c.ID] = &c
+	return c.ID
+}
+
+// Removes the connection, stopping its stream if one is being served.
+func (me *connectionTable) remove(id int) bool {
+	me.mu.Lock()
+	c, ok := me.conns[id]
+	delete(me.conns, id)
+	me.mu.Unlock()
+	if ok && c.stop != nil {
+		c.stop()
+	}
+	return ok
+}
+
+// Serves a stream to the client on a connection it prepared for the protocol
+// info, returning false if it has none idle.
+func (me *connectionTable) attach(client, protocolInfo string, stop func()) (id int, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	// The lowest ID, so the choice doesn't depend on map order.
+	for _, c := range me.conns {
+		if !c.prepared || c.stop != nil || remoteHost(c.RemoteAddr) != client || !protocolInfoMatch(c.ProtocolInfo, protocolInfo) {
+			continue
+		}
+		if id == 0 || c.ID < id {
+			id = c.ID
+		}
+	}
+	if id == 0 {
+		return 0, false
+	}
+	me.conns[id].stop = stop
+	return id, true
+}
+
+// Ends the stream on the connection, removing it unless a peer prepared it.
+func (me *connectionTable) endStream(id int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	c, ok := me.conns[id]
+	if !ok {
+		return
+	}
+	if c.prepared {
+		c.stop = nil
+	} else {
+		delete(me.conns, id)
+	}
+}
+
+// Returns how many connections have streams being served.
+func (me *connectionTable) streams() (n int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, c := range me.conns {
+		if c.stop != nil {
+			n++
+		}
+	}
+	return
+}
+
+func (me *connectionTable) get(id int) (c connection, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	p, ok := me.conns[id]
+	if ok {
+		c = *p
+	}
+	return
+}
+
+// Returns the IDs of current connections in ascending order.
+func (me *connectionTable) ids() (ret []int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for id := range me.conns {
+		ret = append(ret, id)
+	}
+	sort.Ints(ret)
+	return
+}
+
+// Reports whether a peer's protocol info matches a stream's, on the protocol
+// and content format fields as canSource does.
+func protocolInfoMatch(peer, stream string) bool {
+	a := strings.SplitN(peer, ":", 4)
+	b := strings.SplitN(stream, ":", 4)
+	return len(a) == 4 && len(b) == 4 && protocolInfoFieldMatch(a[0], b[0]) && protocolInfoFieldMatch(a[2], b[2])
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+type netConnContextKey struct{}
+
+// Stores the request's connection in its context, for newHTTPServer.
+func withNetConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, netConnContextKey{}, c)
+}
+
+// Registers a connection for the duration of serving a stream, or serves it
+// on one the client prepared, so GetCurrentConnectionIDs and
+// GetCurrentConnectionInfo reflect what's being streamed. ConnectionComplete
+// on it stops the stream by closing the HTTP connection. The returned func
+// must be called when the stream ends.
+func (me *Server) trackConnection(r *http.Request, protocolInfo string) (done func()) {
+	stop := func() {}
+	if c, ok := r.Context().Value(netConnContextKey{}).(net.Conn); ok {
+		stop = func() { c.Close() }
+	}
+	id, ok := me.connections.attach(remoteHost(r.RemoteAddr), protocolInfo, stop)
+	if !ok {
+		id = me.connections.add(connection{
+			ProtocolInfo:     protocolInfo,
+			PeerConnectionID: -1,
+			Direction:        "Output",
+			Status:           "OK",
+			RemoteAddr:       r.RemoteAddr,
+			stop:             stop,
+		})
+	}
+	return func() {
+		me.connections.endStream(id)
+	}
+}
@@ -0,0 +1,144 @@
+package dms
+
+import (
+	"encoding/xml"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+// Tracks changes to the library's folders, for the ContentDirectory's
+// SystemUpdateID and ContainerUpdateIDs. The zero value is ready for use.
+type containerUpdates struct {
+	mu sync.Mutex
+	// Changes seen since the server started.
+	changes uint32
+	// The listings last seen, to compare the next with.
+	listings map[string][]LibraryEntry
+}
+
+// A folder whose listing changed.
+type containerChange struct {
+	dir      string
+	updateID uint32
+	// The listing before the change.
+	old []LibraryEntry
+}
+
+// Returns the SystemUpdateID, which starts at the process ID so that clients
+// see a change across restarts, and goes up with each change.
+func (me *containerUpdates) systemUpdateID() uint32 {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return uint32(os.Getpid()) + me.changes
+}
+
+// Compares listings of the library's directories with those seen before,
+// returning the directories that changed. If whole is set, dirs is a scan of
+// the whole library, and nothing has changed on the first. Otherwise it's
+// those that were listed again, and a nil listing is of one that's gone.
+func (me *containerUpdates) update(dirs map[string][]LibraryEntry, whole bool) (changes []containerChange) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	prev := me.listings
+	if whole {
+		me.listings = dirs
+		if prev == nil {
+			return
+		}
+	} else {
+		me.listings = mergeListings(prev, dirs)
+	}
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		entries := dirs[dir]
+		old, ok := prev[dir]
+		if entries == nil && !ok || ok && entries != nil && slices.EqualFunc(old, entries, LibraryEntry.equal) {
+			continue
+		}
+		me.changes++
+		changes = append(changes, containerChange{dir, uint32(os.Getpid()) + me.changes, old})
+	}
+	return
+}
+
+// Returns the listings with the changed ones replaced, and those that are nil
+// removed, along with those within them.
+func mergeListings(listings, changed map[string][]LibraryEntry) map[string][]LibraryEntry {
+	ret := maps.Clone(listings)
+	if ret == nil {
+		ret = make(map[string][]LibraryEntry)
+	}
+	for dir, entries := range changed {
+		if entries != nil {
+			ret[dir] = entries
+			continue
+		}
+		for p := range ret {
+			if p == dir || strings.HasPrefix(p, dir+"/") {
+				delete(ret, p)
+			}
+		}
+	}
+	return ret
+}
+
+func (me LibraryEntry) equal(other LibraryEntry) bool {
+	return me.Name == other.Name && me.Mode == other.Mode && me.Size == other.Size && me.ModTime.Equal(other.ModTime)
+}
+
+// Returns the ContainerUpdateIDs value for the changes.
+func containerUpdateIDs(changes []containerChange) string {
+	var ids []string
+	for _, c := range changes {
+		ids = append(ids, object{Path: c.dir}.ID(), strconv.FormatUint(uint64(c.updateID), 10))
+	}
+	return strings.Join(ids, ",")
+}
+
+func (me *Server) contentDirectory() *contentDirectoryService {
+	cds, _ := me.services["ContentDirectory"].(*contentDirectoryService)
+	return cds
+}
+
+// Whether anyone is subscribed to ContentDirectory events, and so the library
+// should be scanned for changes to tell them of.
+func (me *Server) contentDirectorySubscribed() bool {
+	cds := me.contentDirectory()
+	return cds != nil && cds.Len() != 0
+}
+
+// Takes in new listings of the library's directories, as for
+// containerUpdates.update. Probe results of files that changed or went are
+// dropped, and ContentDirectory subscribers are told of the changes.
+func (me *Server) updateContainers(dirs map[string][]LibraryEntry, whole bool) {
+	changes := me.containerUpdates.update(dirs, whole)
+	if len(changes) == 0 {
+		return
+	}
+	if probes, ok := me.FFProbeCache.(DeletableCache); ok {
+		for _, c := range changes {
+			for _, e := range c.old {
+				if !slices.ContainsFunc(dirs[c.dir], e.equal) {
+					probes.Delete(ffmpegInfoCacheKey{path.Join(c.dir, e.Name), e.ModTime.UnixNano()})
+				}
+			}
+		}
+	}
+	if cds := me.contentDirectory(); cds != nil {
+		cds.Publish(contentDirectoryProperties(changes[len(changes)-1].updateID, containerUpdateIDs(changes)))
+	}
+}
+
+// The ContentDirectory's evented state variables.
+func contentDirectoryProperties(systemUpdateID uint32, containerUpdateIDs string) []upnp.Property {
+	return []upnp.Property{
+		{Variable: upnp.Variable{XMLName: xml.Name{Local: "SystemUpdateID"}, Value: strconv.FormatUint(uint64(systemUpdateID), 10)}},
+		{Variable: upnp.Variable{XMLName: xml.Name{Local: "ContainerUpdateIDs"}, Value: containerUpdateIDs}},
+	}
+}
@@ -0,0 +1,60 @@
+package dms
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dms/lrucache"
+)
+
+func TestContainerUpdates(t *testing.T) {
+	var cu containerUpdates
+	pid := uint32(os.Getpid())
+	heat := LibraryEntry{Name: "Heat.mkv", Size: 1, ModTime: time.Unix(1, 0)}
+	dirs := map[string][]LibraryEntry{".": {{Name: "Films"}}, "Films": {heat}}
+	if changes := cu.update(dirs, true); len(changes) != 0 {
+		t.Errorf("changed on the first scan: %v", changes)
+	}
+	if changes := cu.update(map[string][]LibraryEntry{".": {{Name: "Films"}}, "Films": {heat}}, true); len(changes) != 0 {
+		t.Errorf("changed on the same scan: %v", changes)
+	}
+	ran := LibraryEntry{Name: "Ran.mkv"}
+	dirs = map[string][]LibraryEntry{".": {{Name: "Films"}}, "Films": {heat, ran}, "Films/New": {}}
+	changes := cu.update(dirs, true)
+	if got, want := containerUpdateIDs(changes), fmt.Sprintf("Films,%d,Films%%2FNew,%d", pid+1, pid+2); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := cu.systemUpdateID(); got != pid+2 {
+		t.Errorf("got SystemUpdateID %d", got)
+	}
+	// Folders listed again, one of them gone.
+	changes = cu.update(map[string][]LibraryEntry{"Films": {heat}, "Films/New": nil, "Films/Old": nil}, false)
+	if got, want := containerUpdateIDs(changes), fmt.Sprintf("Films,%d,Films%%2FNew,%d", pid+3, pid+4); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, ok := cu.listings["Films/New"]; ok || len(cu.listings) != 2 {
+		t.Errorf("got listings %v", cu.listings)
+	}
+}
+
+func TestUpdateContainersDropsProbes(t *testing.T) {
+	probes := lrucache.New(10, 0)
+	srv := &Server{FFProbeCache: probes}
+	heat := LibraryEntry{Name: "Heat.mkv", Size: 1, ModTime: time.Unix(1, 0)}
+	ran := LibraryEntry{Name: "Ran.mkv", Size: 1, ModTime: time.Unix(1, 0)}
+	srv.updateContainers(map[string][]LibraryEntry{".": {{Name: "Films"}}, "Films": {heat, ran}}, true)
+	for _, e := range []LibraryEntry{heat, ran} {
+		probes.Set(ffmpegInfoCacheKey{"Films/" + e.Name, e.ModTime.UnixNano()}, nil)
+	}
+	newRan := ran
+	newRan.ModTime = time.Unix(2, 0)
+	srv.updateContainers(map[string][]LibraryEntry{"Films": {heat, newRan}}, false)
+	if _, ok := probes.Get(ffmpegInfoCacheKey{"Films/Heat.mkv", heat.ModTime.UnixNano()}); !ok {
+		t.Error("unchanged file's probe dropped")
+	}
+	if _, ok := probes.Get(ffmpegInfoCacheKey{"Films/Ran.mkv", ran.ModTime.UnixNano()}); ok {
+		t.Error("changed file's probe kept")
+	}
+}
@@ -0,0 +1,76 @@
+package dms
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// The methods cross-origin requests may use if Server.CORSMethods isn't set.
+var defaultCORSMethods = []string{"GET", "HEAD", "POST", "DELETE"}
+
+// Response headers web players need to see to stream and seek.
+const corsExposedHeaders = "Content-Length, Content-Range, Accept-Ranges, Content-Type, Content-Duration, X-Content-Duration"
+
+// Reports whether cross-origin requests are allowed for the path: the JSON
+// API, and the streams and subtitles web players fetch.
+func corsPath(p string) bool {
+	if strings.HasPrefix(p, "/api/") {
+		return true
+	}
+	switch p {
+	case resPath, subtitlePath, hlsPath, streamPath, slideshowPath, iconPath:
+		return true
+	}
+	return false
+}
+
+// Returns the value of Access-Control-Allow-Origin for a request from the
+// origin, or "" if it isn't allowed.
+func (me *Server) corsAllowOrigin(origin string) string {
+	for _, o := range me.CORSOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// Sets the CORS headers of the response to a request from one of
+// CORSOrigins, reporting whether it was a preflight request, which is then
+// answered.
+func (me *Server) handleCORS(w http.ResponseWriter, r *http.Request) (preflight bool) {
+	origin := r.Header.Get("Origin")
+	if len(me.CORSOrigins) == 0 || !corsPath(r.URL.Path) {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	allow := me.corsAllowOrigin(origin)
+	if origin == "" || allow == "" {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allow)
+	method := r.Header.Get("Access-Control-Request-Method")
+	if r.Method != "OPTIONS" || method == "" {
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		return false
+	}
+	methods := me.CORSMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	if !slices.ContainsFunc(methods, func(m string) bool { return strings.EqualFold(m, method) }) {
+		http.Error(w, "method not allowed", http.StatusForbidden)
+		return true
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+		w.Header().Set("Access-Control-Allow-Headers", h)
+	}
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
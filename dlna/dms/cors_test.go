@@ -0,0 +1,63 @@
+package dms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+)
+
+func TestCORS(t *testing.T) {
+	s := &Server{
+		FS:             fstest.MapFS{"Films/Heat.mkv": {}},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		Favorites:      &Favorites{},
+		CORSOrigins:    []string{"https://cast.example.com/"},
+	}
+	s.httpServeMux = http.NewServeMux()
+	s.initMux(s.httpServeMux)
+	h := s.newHTTPServer().Handler
+	do := func(method, target, origin string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("GET", favoritesAPIPath, "https://cast.example.com")
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://cast.example.com" || w.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Fatalf("got %d, %v", w.Code, w.Header())
+	}
+	if w := do("GET", favoritesAPIPath, "https://evil.example.com"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("other origin got %v", w.Header())
+	}
+	if w := do("GET", rootDescPath, "https://cast.example.com"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("device description got %v", w.Header())
+	}
+
+	w = do("OPTIONS", resPath+"?path=Films/Heat.mkv", "https://cast.example.com",
+		"Access-Control-Request-Method", "GET",
+		"Access-Control-Request-Headers", "range")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, POST, DELETE" || w.Header().Get("Access-Control-Allow-Headers") != "range" {
+		t.Fatalf("preflight got %d, %v", w.Code, w.Header())
+	}
+	if w := do("OPTIONS", favoritesAPIPath, "https://cast.example.com", "Access-Control-Request-Method", "PUT"); w.Code != http.StatusForbidden {
+		t.Fatalf("preflight for PUT got %d", w.Code)
+	}
+
+	s.CORSOrigins = []string{"*"}
+	if w := do("GET", subtitlePath, "https://anywhere.example.com"); w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("got %v with any origin allowed", w.Header())
+	}
+}
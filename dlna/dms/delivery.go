@@ -0,0 +1,49 @@
+package dms
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+const (
+	// How much of a transcode is buffered to estimate its length from.
+	prebufferTime = 10 * time.Second
+	// Buffering stops here regardless, so slow transcodes still start.
+	prebufferMaxBytes = 32 << 20
+	// Estimates are padded by this much, as the bitrate of the start of a
+	// video is usually lower than the rest.
+	prebufferMargin = 1.05
+)
+
+// Reads the start of a transcode of the given remaining duration, until the
+// stats show prebufferTime of it, prebufferMaxBytes are read, or it ends.
+// Returns a reader of the whole transcode, and its length, exact if it ended
+// and otherwise estimated from the bitrate so far, or -1 if there were no
+// stats to go by.
+func prebufferTranscode(p io.Reader, stats *transcode.StatsWriter, remaining time.Duration) (io.Reader, int64, error) {
+	var buf bytes.Buffer
+	chunk := make([]byte, 64<<10)
+	for buf.Len() < prebufferMaxBytes {
+		n, err := p.Read(chunk)
+		buf.Write(chunk[:n])
+		if err == io.EOF {
+			return &buf, int64(buf.Len()), nil
+		}
+		if err != nil {
+			return nil, -1, err
+		}
+		if s, ok := stats.Latest(); ok && s.Time >= prebufferTime {
+			break
+		}
+	}
+	body := io.MultiReader(&buf, p)
+	s, ok := stats.Latest()
+	if !ok || s.Time <= 0 || s.Size <= 0 {
+		return body, -1, nil
+	}
+	length := int64(float64(s.Size) / s.Time.Seconds() * remaining.Seconds() * prebufferMargin)
+	return body, max(length, int64(buf.Len())), nil
+}
@@ -0,0 +1,61 @@
+package dms
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+// A transcode at 100 KiB a second, reporting how far it's got on the stats as
+// it's read.
+type fakeTranscode struct {
+	stats   *transcode.StatsWriter
+	seconds int
+	read    int
+}
+
+func (me *fakeTranscode) Read(b []byte) (int, error) {
+	n := min(len(b), me.seconds*100<<10-me.read)
+	if n == 0 {
+		return 0, io.EOF
+	}
+	me.read += n
+	secs := float64(me.read) / (100 << 10)
+	fmt.Fprintf(me.stats, "frame=1 size=%8dKiB time=00:%02d:%05.2f bitrate=819.2kbits/s\r", me.read>>10, int(secs)/60, secs-float64(int(secs)/60*60))
+	return copy(b, bytes.Repeat([]byte{'x'}, n)), nil
+}
+
+func TestPrebufferTranscode(t *testing.T) {
+	stats := &transcode.StatsWriter{}
+	body, length, err := prebufferTranscode(&fakeTranscode{stats: stats, seconds: 1000}, stats, 100*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(100 << 10 * 100 * prebufferMargin); length < want-1 || length > want+1 {
+		t.Fatalf("got length %d, want about %d", length, want)
+	}
+	if n, _ := io.Copy(io.Discard, io.LimitReader(body, length)); n != length {
+		t.Fatalf("read %d", n)
+	}
+
+	// A transcode that ends within the buffer has its exact length.
+	stats = &transcode.StatsWriter{}
+	body, length, err = prebufferTranscode(&fakeTranscode{stats: stats, seconds: 3}, stats, time.Minute)
+	if err != nil || length != 3*100<<10 {
+		t.Fatalf("got %d, %v", length, err)
+	}
+	if n, _ := io.Copy(io.Discard, body); n != length {
+		t.Fatalf("read %d", n)
+	}
+
+	// Without stats, there's nothing to estimate from.
+	_, length, _ = prebufferTranscode(io.MultiReader(strings.NewReader("x"), &fakeTranscode{stats: &transcode.StatsWriter{}, seconds: 1000}), &transcode.StatsWriter{}, time.Minute)
+	if length != -1 {
+		t.Fatalf("got %d without stats", length)
+	}
+}
@@ -0,0 +1,101 @@
+package dms
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DIAL, so second screen apps can discover dms and find its web UI. See the
+// DIAL 2.1 specification.
+const (
+	dialServiceType = "urn:dial-multiscreen-org:service:dial:1"
+	dialAppsPath    = "/apps/"
+	// The one application, which is always running.
+	dialAppName = "dms"
+	// The most a launch request may carry, as DIAL requires.
+	maxDIALPayload = 4096
+)
+
+type dialService struct {
+	XMLName xml.Name `xml:"urn:dial-multiscreen-org:schemas:dial service"`
+	DialVer string   `xml:"dialVer,attr"`
+	Name    string   `xml:"name"`
+	Options struct {
+		AllowStop bool `xml:"allowStop,attr"`
+	} `xml:"options"`
+	State string `xml:"state"`
+	Link  struct {
+		Rel  string `xml:"rel,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"link"`
+	AdditionalData struct {
+		WebUI string `xml:"webUI"`
+	} `xml:"additionalData"`
+}
+
+// The base URL clients address the server at, for absolute URLs in DIAL
+// responses.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return (&url.URL{Scheme: scheme, Host: requestHost(r)}).String()
+}
+
+// Reports whether a DIAL request may come from the origin. Web pages may
+// only launch or query apps if they're one of CORSOrigins, but apps and
+// files, whose origins aren't web origins, may.
+func (me *Server) dialOriginAllowed(origin string) bool {
+	if origin == "" || !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+		return true
+	}
+	return me.corsAllowOrigin(origin) != ""
+}
+
+// Serves the DIAL application resources: GET on /apps/dms reports it
+// running, with the web UI's URL, POST launches it, which it already is,
+// and it can't be stopped.
+func (me *Server) serveDIALApps(w http.ResponseWriter, r *http.Request) {
+	if !me.dialOriginAllowed(r.Header.Get("Origin")) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	app, instance, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, dialAppsPath), "/")
+	if app != dialAppName {
+		http.NotFound(w, r)
+		return
+	}
+	appURL := requestBaseURL(r) + dialAppsPath + dialAppName
+	switch {
+	case instance == "run" && r.Method == "DELETE":
+		http.Error(w, "dms can't be stopped", http.StatusMethodNotAllowed)
+	case instance != "":
+		http.NotFound(w, r)
+	case r.Method == "POST":
+		n, _ := io.Copy(io.Discard, io.LimitReader(r.Body, maxDIALPayload+1))
+		if n > maxDIALPayload {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Header().Set("Location", appURL+"/run")
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "GET" || r.Method == "HEAD":
+		var s dialService
+		s.DialVer = "2.1"
+		s.Name = dialAppName
+		s.State = "running"
+		s.Link.Rel = "run"
+		s.Link.Href = "run"
+		s.AdditionalData.WebUI = requestBaseURL(r) + "/"
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		io.WriteString(w, xml.Header)
+		w.Write(xmlMarshalOrPanic(s))
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
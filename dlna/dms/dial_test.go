@@ -0,0 +1,74 @@
+package dms
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/log"
+)
+
+func TestDIAL(t *testing.T) {
+	s := &Server{
+		Logger:      log.Default,
+		CORSOrigins: []string{"https://app.example"},
+	}
+	if err := s.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	s.httpServeMux = http.NewServeMux()
+	s.initMux(s.httpServeMux)
+	h := s.newHTTPServer().Handler
+	do := func(method, target, origin, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Host = "192.168.1.2:1338"
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("GET", rootDescPath, "", ""); w.Header().Get("Application-URL") != "http://192.168.1.2:1338/apps/" {
+		t.Fatalf("got Application-URL %q", w.Header().Get("Application-URL"))
+	}
+	w := do("GET", "/apps/dms", "", "")
+	var svc dialService
+	if err := xml.Unmarshal(w.Body.Bytes(), &svc); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || svc.Name != "dms" || svc.State != "running" || svc.AdditionalData.WebUI != "http://192.168.1.2:1338/" {
+		t.Fatalf("got %d, %+v", w.Code, svc)
+	}
+	if w := do("POST", "/apps/dms", "package:com.example.remote", "v=1"); w.Code != http.StatusCreated || w.Header().Get("Location") != "http://192.168.1.2:1338/apps/dms/run" {
+		t.Fatalf("launch got %d, %v", w.Code, w.Header())
+	}
+	if w := do("POST", "/apps/dms", "", strings.Repeat("x", maxDIALPayload+1)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("big payload got %d", w.Code)
+	}
+	if w := do("DELETE", "/apps/dms/run", "", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("stop got %d", w.Code)
+	}
+	if w := do("GET", "/apps/YouTube", "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("other app got %d", w.Code)
+	}
+	if w := do("GET", "/apps/dms", "https://app.example", ""); w.Code != http.StatusOK {
+		t.Fatalf("allowed origin got %d", w.Code)
+	}
+	if w := do("POST", "/apps/dms", "https://evil.example", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("other origin got %d", w.Code)
+	}
+
+	s.NoDIAL = true
+	s.httpServeMux = http.NewServeMux()
+	s.initMux(s.httpServeMux)
+	if w := do("GET", "/apps/dms", "", ""); strings.Contains(w.Body.String(), "urn:dial-multiscreen-org") {
+		t.Fatalf("DIAL served with NoDIAL")
+	}
+	if w := do("GET", rootDescPath, "", ""); w.Header().Get("Application-URL") != "" {
+		t.Fatalf("got Application-URL %q with NoDIAL", w.Header().Get("Application-URL"))
+	}
+}
@@ -0,0 +1,114 @@
+package dms
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Matches the folders of a multi-disc album, like "CD1", "Disc 2" or
+// "disk 03 - Live".
+var discFolderRegexp = regexp.MustCompile(`(?i)^(?:cd|dis[ck])[\s._-]*0*(\d+)(?:\s*[-:(\[].*)?$`)
+
+// Returns the disc number of a folder holding one disc of an album.
+func discNumber(name string) (int, bool) {
+	m := discFolderRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil && n != 0
+}
+
+// A file or folder listed in a container.
+type containerEntry struct {
+	object
+	fs.FileInfo
+	// The disc the entry came from, or 0 if it's directly in the container.
+	Disc int
+}
+
+// Returns the entries to list for a directory. The files of any disc folders
+// are listed in place of the folders, so a multi-disc album reads as one, in
+// disc order. Folders within the disc folders are left out.
+func (me *contentDirectoryService) containerEntries(o object, fis []fs.FileInfo) (ret []containerEntry) {
+	var discs []containerEntry
+	discsAt := -1
+	for _, fi := range fis {
+		child := object{path.Join(o.Path, fi.Name()), me.RootObjectPath}
+		disc, isDisc := discNumber(fi.Name())
+		if !fi.IsDir() || !isDisc {
+			ret = append(ret, containerEntry{child, fi, 0})
+			continue
+		}
+		if ignored, _ := me.IgnorePath(child.FilePath()); ignored {
+			continue
+		}
+		discFis, err := child.readDir(me.FS)
+		if err != nil {
+			me.Logger.Printf("error reading %s: %s", child.FilePath(), err)
+			continue
+		}
+		if discsAt < 0 {
+			discsAt = len(ret)
+		}
+		for _, dfi := range discFis {
+			if dfi == nil || dfi.IsDir() {
+				continue
+			}
+			discs = append(discs, containerEntry{
+				object{path.Join(child.Path, dfi.Name()), me.RootObjectPath},
+				dfi,
+				disc,
+			})
+		}
+	}
+	sort.SliceStable(discs, func(i, j int) bool {
+		if discs[i].Disc != discs[j].Disc {
+			return discs[i].Disc < discs[j].Disc
+		}
+		return strings.ToLower(discs[i].Name()) < strings.ToLower(discs[j].Name())
+	})
+	if discsAt >= 0 {
+		ret = append(ret[:discsAt], append(discs, ret[discsAt:]...)...)
+	}
+	return
+}
+
+// Orders the tracks from disc folders by disc, then by their track tags, with
+// untagged tracks first in name order. They're together, as containerEntries
+// lists them.
+func (me *contentDirectoryService) sortDiscTracks(ctx context.Context, entries []containerEntry) {
+	first := slices.IndexFunc(entries, func(e containerEntry) bool { return e.Disc != 0 })
+	if first < 0 || me.NoProbe {
+		return
+	}
+	last := first
+	for last < len(entries) && entries[last].Disc != 0 {
+		last++
+	}
+	discs := entries[first:last]
+	tracks := make(map[string]int, len(discs))
+	for _, e := range discs {
+		if mt, err := me.mimeTypeByPath(e.FilePath()); err != nil || !mt.IsAudio() {
+			continue
+		}
+		info, _ := me.ffmpegProbe(ctx, e.FilePath())
+		if tag, ok := audioTag(info, "track"); ok {
+			// Tags can be like "3/12".
+			tracks[e.Path], _ = strconv.Atoi(strings.TrimSpace(strings.SplitN(tag, "/", 2)[0]))
+		}
+	}
+	sort.SliceStable(discs, func(i, j int) bool {
+		a, b := discs[i], discs[j]
+		if a.Disc != b.Disc {
+			return a.Disc < b.Disc
+		}
+		return tracks[a.Path] < tracks[b.Path]
+	})
+}
@@ -0,0 +1,76 @@
+package dms
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestDiscNumber(t *testing.T) {
+	for name, e := range map[string]int{
+		"CD1":              1,
+		"cd 2":             2,
+		"Disc 03":          3,
+		"disk_4":           4,
+		"Disc 10 - Live":   10,
+		"CD2 (Bonus)":      2,
+		"CD0":              0,
+		"Discography":      0,
+		"CDs":              0,
+		"Disc 2 and more?": 0,
+	} {
+		if a, _ := discNumber(name); a != e {
+			t.Errorf("expected %d for %q but got %d", e, name, a)
+		}
+	}
+}
+
+func TestMultiDiscAlbum(t *testing.T) {
+	cds := &contentDirectoryService{Server: &Server{
+		FS: fstest.MapFS{
+			"Album/cover.jpg":             {},
+			"Album/Disc 10/01 Ten.mp3":    {},
+			"Album/Disc 2/02 Two b.mp3":   {},
+			"Album/Disc 2/01 Two a.mp3":   {},
+			"Album/Disc 1/01 One.mp3":     {},
+			"Album/Disc 1/Scans/back.jpg": {},
+			"Album/Extras/demo.mp3":       {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+	}}
+	objs, err := cds.readContainer(context.Background(), object{"Album", "."}, "localhost", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case upnpav.Item:
+			if o.ParentID != "Album" {
+				t.Errorf("%s has parent %q", o.ID, o.ParentID)
+			}
+			ids = append(ids, o.ID)
+		case upnpav.Container:
+			ids = append(ids, o.ID)
+		}
+	}
+	e := []string{
+		"Album%2FDisc+1%2F01+One.mp3",
+		"Album%2FDisc+2%2F01+Two+a.mp3",
+		"Album%2FDisc+2%2F02+Two+b.mp3",
+		"Album%2FDisc+10%2F01+Ten.mp3",
+		"Album%2FExtras",
+		"Album%2Fcover.jpg",
+	}
+	if !slices.Equal(ids, e) {
+		t.Fatalf("expected %q but got %q", e, ids)
+	}
+	if n := cds.objectChildCount(object{"Album", "."}); n != len(e) {
+		t.Fatalf("expected %d children but got %d", len(e), n)
+	}
+}
@@ -0,0 +1,238 @@
+package dms
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+// Returns the first stream of the given codec_type, or nil.
+func firstStream(info *ffprobe.Info, codecType string) map[string]interface{} {
+	if info == nil {
+		return nil
+	}
+	for _, s := range info.Streams {
+		if s["codec_type"] == codecType {
+			return s
+		}
+	}
+	return nil
+}
+
+// Returns an integer stream (or format) field, or 0 if it's missing or
+// malformed. ffprobe output may contain json.Number, or float64 if the info
+// went through the persistent cache.
+func streamInt(s map[string]interface{}, key string) int64 {
+	switch v := s[key].(type) {
+	case float64:
+		return int64(v)
+	case json.Number:
+		i, _ := v.Int64()
+		return i
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	}
+	return 0
+}
+
+func streamString(s map[string]interface{}, key string) string {
+	v, _ := s[key].(string)
+	return v
+}
+
+// Reports whether the video stream is interlaced, from the order of its
+// fields. Progressive and unknown orders aren't.
+func streamInterlaced(s map[string]interface{}) bool {
+	switch streamString(s, "field_order") {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
+
+// Returns the frame rate of a video stream in frames per second.
+func streamFrameRate(s map[string]interface{}) float64 {
+	var num, den float64
+	n, _ := fmt.Sscanf(streamString(s, "r_frame_rate"), "%g/%g", &num, &den)
+	if n != 2 || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// Determines the DLNA.ORG_PN value for a natively served file. An empty string
+// is returned if the media doesn't conform to any profile we know of, in which
+// case renderers have to sniff the content themselves.
+func dlnaProfileName(mt mimeType, filePath string, info *ffprobe.Info) string {
+	switch {
+	case mt.IsImage():
+		return imageDLNAProfileName(mt, info)
+	case mt.IsAudio():
+		return audioDLNAProfileName(info)
+	case mt.IsVideo():
+		return videoDLNAProfileName(filePath, info)
+	}
+	return ""
+}
+
+func imageDLNAProfileName(mt mimeType, info *ffprobe.Info) string {
+	var width, height int64
+	if s := firstStream(info, "video"); s != nil {
+		width, height = streamInt(s, "width"), streamInt(s, "height")
+	}
+	fits := func(w, h int64) bool {
+		return width != 0 && height != 0 && width <= w && height <= h
+	}
+	switch mt {
+	case "image/jpeg":
+		switch {
+		case fits(640, 480):
+			return "JPEG_SM"
+		case fits(1024, 768):
+			return "JPEG_MED"
+		case fits(4096, 4096):
+			return "JPEG_LRG"
+		}
+	case "image/png":
+		if fits(4096, 4096) {
+			return "PNG_LRG"
+		}
+	case "image/gif":
+		if fits(1600, 1200) {
+			return "GIF_LRG"
+		}
+	}
+	return ""
+}
+
+func audioDLNAProfileName(info *ffprobe.Info) string {
+	a := firstStream(info, "audio")
+	if a == nil {
+		return ""
+	}
+	format := streamString(info.Format, "format_name")
+	bitrate := streamInt(a, "bit_rate")
+	switch streamString(a, "codec_name") {
+	case "mp3":
+		return "MP3"
+	case "aac":
+		if !strings.Contains(format, "mp4") {
+			return "AAC_ADTS"
+		}
+		if bitrate != 0 && bitrate <= 320000 {
+			return "AAC_ISO_320"
+		}
+		return "AAC_ISO"
+	case "ac3":
+		return "AC3"
+	case "wmav1", "wmav2":
+		if bitrate != 0 && bitrate <= 193000 {
+			return "WMABASE"
+		}
+		return "WMAFULL"
+	case "wmapro":
+		return "WMAPRO"
+	case "pcm_s16be":
+		return "LPCM"
+	}
+	return ""
+}
+
+func videoDLNAProfileName(filePath string, info *ffprobe.Info) string {
+	v := firstStream(info, "video")
+	if v == nil {
+		return ""
+	}
+	a := firstStream(info, "audio")
+	audioCodec := streamString(a, "codec_name")
+	videoCodec := streamString(v, "codec_name")
+	height := streamInt(v, "height")
+	hd := height > 576
+	// 25 and 50 fps content is European, everything else is treated as North
+	// American.
+	region := "NA"
+	if fps := streamFrameRate(v); fps > 24.5 && fps < 25.5 || fps > 49.5 && fps < 50.5 {
+		region = "EU"
+	}
+	format := streamString(info.Format, "format_name")
+	switch {
+	case format == "mpegts":
+		// Timestamped 192 byte packets are found in .m2ts and .mts files.
+		suffix := "_ISO"
+		switch strings.ToLower(path.Ext(filePath)) {
+		case ".m2ts", ".mts":
+			suffix = "_T"
+		}
+		switch videoCodec {
+		case "mpeg2video":
+			if hd {
+				return "MPEG_TS_HD_" + region + suffix
+			}
+			return "MPEG_TS_SD_" + region + suffix
+		case "h264":
+			res := "SD"
+			if hd {
+				res = "HD"
+			}
+			switch audioCodec {
+			case "ac3":
+				return "AVC_TS_MP_" + res + "_AC3" + suffix
+			case "aac":
+				return "AVC_TS_MP_" + res + "_AAC_MULT5" + suffix
+			case "mp3":
+				return "AVC_TS_MP_" + res + "_MPEG1_L3" + suffix
+			}
+		}
+	case format == "mpeg":
+		switch videoCodec {
+		case "mpeg1video":
+			return "MPEG1"
+		case "mpeg2video":
+			if region == "EU" {
+				return "MPEG_PS_PAL"
+			}
+			return "MPEG_PS_NTSC"
+		}
+	case strings.Contains(format, "mp4"):
+		switch videoCodec {
+		case "h264":
+			if audioCodec != "aac" && audioCodec != "" {
+				return ""
+			}
+			switch {
+			case !hd:
+				if streamString(v, "profile") == "Baseline" || streamString(v, "profile") == "Constrained Baseline" {
+					return "AVC_MP4_BL_CIF15_AAC_520"
+				}
+				return "AVC_MP4_MP_SD_AAC_MULT5"
+			case streamString(v, "profile") == "High":
+				return "AVC_MP4_HP_HD_AAC"
+			case height <= 720:
+				return "AVC_MP4_MP_HD_720p_AAC"
+			default:
+				return "AVC_MP4_MP_HD_1080i_AAC"
+			}
+		case "mpeg4":
+			if streamString(v, "profile") == "Simple Profile" {
+				return "MPEG4_P2_MP4_SP_AAC"
+			}
+			return "MPEG4_P2_MP4_ASP_AAC"
+		}
+	case format == "asf":
+		switch videoCodec {
+		case "wmv3":
+			if hd {
+				return "WMVHIGH_FULL"
+			}
+			return "WMVMED_FULL"
+		case "vc1":
+			return "VC1_ASF_AP_L2_WMA"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package dms
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+type dlnaProfileNameTestCase struct {
+	mimeType mimeType
+	path     string
+	info     string
+	expected string
+}
+
+func TestDLNAProfileName(t *testing.T) {
+	cases := []dlnaProfileNameTestCase{
+		{"audio/mpeg", "a.mp3", `{"format":{"format_name":"mp3"},"streams":[{"codec_type":"audio","codec_name":"mp3"}]}`, "MP3"},
+		{"audio/mp4", "a.m4a", `{"format":{"format_name":"mov,mp4,m4a,3gp,3g2,mj2"},"streams":[{"codec_type":"audio","codec_name":"aac","bit_rate":"256000"}]}`, "AAC_ISO_320"},
+		{"image/jpeg", "a.jpg", `{"format":{},"streams":[{"codec_type":"video","width":1024,"height":768}]}`, "JPEG_MED"},
+		{"image/jpeg", "a.jpg", `{"format":{},"streams":[{"codec_type":"video","width":8000,"height":6000}]}`, ""},
+		{"video/mp4", "a.mp4", `{"format":{"format_name":"mov,mp4,m4a,3gp,3g2,mj2"},"streams":[{"codec_type":"video","codec_name":"h264","profile":"Main","width":1280,"height":720},{"codec_type":"audio","codec_name":"aac"}]}`, "AVC_MP4_MP_HD_720p_AAC"},
+		{"video/vnd.dlna.mpeg-tts", "a.ts", `{"format":{"format_name":"mpegts"},"streams":[{"codec_type":"video","codec_name":"mpeg2video","height":1080,"r_frame_rate":"30000/1001"}]}`, "MPEG_TS_HD_NA_ISO"},
+		{"video/mpeg", "a.mpg", `{"format":{"format_name":"mpeg"},"streams":[{"codec_type":"video","codec_name":"mpeg2video","height":576,"r_frame_rate":"25/1"}]}`, "MPEG_PS_PAL"},
+		{"video/x-matroska", "a.mkv", `{"format":{"format_name":"matroska,webm"},"streams":[{"codec_type":"video","codec_name":"h264"}]}`, ""},
+	}
+	for _, _case := range cases {
+		var info ffprobe.Info
+		if err := json.Unmarshal([]byte(_case.info), &info); err != nil {
+			t.Fatal(err)
+		}
+		a := dlnaProfileName(_case.mimeType, _case.path, &info)
+		if a != _case.expected {
+			t.Errorf("expected %q for %s but got %q", _case.expected, _case.path, a)
+		}
+	}
+	if a := dlnaProfileName("video/mp4", "a.mp4", nil); a != "" {
+		t.Errorf("expected no profile without probe info but got %q", a)
+	}
+}
@@ -2,13 +2,16 @@ package dms
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"crypto/tls"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 	"io/fs"
-	"io/ioutil"
+	"maps"
 	"math/rand"
 	"net"
 	"net/http"
@@ -19,6 +22,7 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,10 +30,19 @@ import (
 
 	"github.com/anacrolix/ffprobe"
 	"github.com/anacrolix/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/anacrolix/dms/avtransport"
 	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/dlna/dms/clientprofile"
+	"github.com/anacrolix/dms/dlna/dms/eventing"
+	"github.com/anacrolix/dms/dlna/dms/resource"
+	"github.com/anacrolix/dms/scrobble"
+	"github.com/anacrolix/dms/search"
 	"github.com/anacrolix/dms/soap"
 	"github.com/anacrolix/dms/ssdp"
+	"github.com/anacrolix/dms/subtitles"
 	"github.com/anacrolix/dms/transcode"
 	"github.com/anacrolix/dms/upnp"
 	"github.com/anacrolix/dms/upnpav"
@@ -57,13 +70,42 @@ const (
 	contentDirectoryEventSubURL = "/evt/ContentDirectory"
 	serviceControlURL           = "/ctl"
 	deviceIconPath              = "/deviceIcon"
+	// Query parameter marking resource requests made by the server's own
+	// helper commands, with a token only the server can make.
+	loopbackQueryKey = "loopback"
 )
 
 type transcodeSpec struct {
 	mimeType        string
 	DLNAProfileName string
 	DLNAFlags       string
-	Transcode       func(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error)
+	// The audio options adapt the audio to the requesting client.
+	Transcode func(ctx context.Context, path string, start, length time.Duration, opts transcode.Options, stderr io.Writer) (r io.ReadCloser, err error)
+	// Optional. Produces the stream at a speed other than normal, for fast
+	// forward and rewind.
+	TrickPlay func(ctx context.Context, path string, speed float64, start time.Duration, stderr io.Writer) (r io.ReadCloser, err error)
+	// The transcode is offered for audio items instead of video.
+	audio bool
+	// The ffprobe name of the codec of the video produced.
+	videoCodec string
+	// If set, the transcode is only offered for these source MIME-types.
+	sources []mimeType
+	// Only offered to clients whose profile accepts DSD over PCM.
+	dop bool
+	// The audio options the transcode always applies, over those for the
+	// client.
+	audioOptions transcode.AudioOptions
+	// The sample size of uncompressed PCM audio produced, which with the
+	// rate and channels gives the bitrate.
+	pcmBitsPerSample uint
+}
+
+// Reports whether the transcode can be applied to items of the MIME-type.
+func (ts transcodeSpec) appliesTo(mt mimeType) bool {
+	if ts.sources != nil {
+		return slices.Contains(ts.sources, mt)
+	}
+	return ts.audio == mt.IsAudio()
 }
 
 var transcodes = map[string]transcodeSpec{
@@ -71,10 +113,172 @@ var transcodes = map[string]transcodeSpec{
 		mimeType:        "video/mpeg",
 		DLNAProfileName: "MPEG_PS_PAL",
 		Transcode:       transcode.Transcode,
+		TrickPlay:       trickPlay("mpegts"),
+		videoCodec:      "mpeg2video",
+	},
+	"vp8":        {mimeType: "video/webm", Transcode: transcode.VP8Transcode, TrickPlay: trickPlay("webm"), videoCodec: "vp8"},
+	"chromecast": {mimeType: "video/mp4", Transcode: transcode.ChromecastTranscode, TrickPlay: trickPlay("mp4"), videoCodec: "h264"},
+	"web":        {mimeType: "video/mp4", Transcode: transcode.WebTranscode, TrickPlay: trickPlay("mp4"), videoCodec: "h264"},
+	// 16 bit big-endian PCM, the one format all DLNA audio renderers must
+	// accept.
+	"lpcm": audioTranscodeSpec(transcodeSpec{
+		mimeType:         "audio/L16;rate=44100;channels=2",
+		DLNAProfileName:  "LPCM",
+		audio:            true,
+		pcmBitsPerSample: 16,
+		audioOptions: transcode.AudioOptions{
+			SampleRate: 44100,
+			Channels:   2,
+		},
+	}, "s16be", "pcm_s16be"),
+	// DSD decoded to high-rate PCM, for renderers without DSD support.
+	"flac": audioTranscodeSpec(transcodeSpec{
+		mimeType: "audio/flac",
+		audio:    true,
+		sources:  slices.Collect(maps.Values(dsdMimeTypes)),
+		audioOptions: transcode.AudioOptions{
+			SampleRate:   88200,
+			SampleFormat: "s32",
+		},
+	}, "flac", "flac"),
+	// DSD passed through untouched inside PCM frames.
+	"dop": {
+		mimeType:  "audio/wav",
+		Transcode: ignoreOptions(transcode.DoP),
+		audio:     true,
+		sources:   slices.Collect(maps.Values(dsdMimeTypes)),
+		dop:       true,
 	},
-	"vp8":        {mimeType: "video/webm", Transcode: transcode.VP8Transcode},
-	"chromecast": {mimeType: "video/mp4", Transcode: transcode.ChromecastTranscode},
-	"web":        {mimeType: "video/mp4", Transcode: transcode.WebTranscode},
+}
+
+// The order transcodes are offered in, unless a client profile gives
+// another: the most widely playable first.
+var transcodeOrder = []string{"t", "vp8", "chromecast", "web", "lpcm", "flac", "dop"}
+
+// The name client profiles order the resource of the file as it is by.
+const rawResourceName = "raw"
+
+// A resource offered for an item, with the name client profiles order it by.
+type namedResource struct {
+	name string
+	upnpav.Resource
+}
+
+// Returns the resources in the order the client's profile gives, without
+// those it leaves out.
+func (me *Server) orderResources(userAgent string, res []namedResource) (ret []upnpav.Resource) {
+	if p := me.clientProfile(userAgent); p != nil {
+		for _, name := range p.Resources {
+			for _, r := range res {
+				if r.name == name {
+					ret = append(ret, r.Resource)
+				}
+			}
+		}
+	}
+	if len(ret) != 0 {
+		return
+	}
+	for _, r := range res {
+		ret = append(ret, r.Resource)
+	}
+	return
+}
+
+// Returns the names of the transcodes ForceTranscodeTo accepts.
+func TranscodeNames() []string {
+	return slices.Sorted(maps.Keys(transcodes))
+}
+
+// The options given take precedence over those for the client.
+func audioTranscode(format, codec string, opts transcode.AudioOptions) func(context.Context, string, time.Duration, time.Duration, transcode.Options, io.Writer) (io.ReadCloser, error) {
+	return func(ctx context.Context, path string, start, length time.Duration, clientOpts transcode.Options, stderr io.Writer) (io.ReadCloser, error) {
+		return transcode.AudioTranscode(ctx, path, format, codec, opts.Merge(clientOpts.AudioOptions), start, length, stderr)
+	}
+}
+
+// Returns the spec with a Transcode encoding audio with the codec, in the
+// ffmpeg format, applying the spec's audio options.
+func audioTranscodeSpec(ts transcodeSpec, format, codec string) transcodeSpec {
+	ts.Transcode = audioTranscode(format, codec, ts.audioOptions)
+	return ts
+}
+
+// Adapts a transcode that passes the audio and video through as they are.
+func ignoreOptions(f func(context.Context, string, time.Duration, time.Duration, io.Writer) (io.ReadCloser, error)) func(context.Context, string, time.Duration, time.Duration, transcode.Options, io.Writer) (io.ReadCloser, error) {
+	return func(ctx context.Context, path string, start, length time.Duration, _ transcode.Options, stderr io.Writer) (io.ReadCloser, error) {
+		return f(ctx, path, start, length, stderr)
+	}
+}
+
+// Play speeds offered for transcodes that support trick play.
+var trickPlaySpeeds = []string{"-8", "-4", "-2", "2", "4", "8"}
+
+func trickPlay(format string) func(context.Context, string, float64, time.Duration, io.Writer) (io.ReadCloser, error) {
+	return func(ctx context.Context, path string, speed float64, start time.Duration, stderr io.Writer) (io.ReadCloser, error) {
+		return transcode.TrickPlay(ctx, path, format, speed, start, stderr)
+	}
+}
+
+// Returns the DLNA.ORG_PS play speeds for the transcode.
+func (ts transcodeSpec) playSpeeds() []string {
+	if ts.TrickPlay == nil {
+		return nil
+	}
+	return trickPlaySpeeds
+}
+
+func (ts transcodeSpec) supportsPlaySpeed(speed float64) bool {
+	for _, s := range ts.playSpeeds() {
+		if strconv.FormatFloat(speed, 'g', -1, 64) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Key of the transcode that adjusts audio for the client, such as to fit its
+// limits or apply ReplayGain. Its parameters depend on the client and the
+// file, so it isn't in transcodes.
+const adjustedTranscodeKey = "adjusted"
+
+// Reencodes audio as lossless FLAC. The adjustments come with the audio
+// options for the client.
+var adjustedAudioSpec = transcodeSpec{
+	mimeType:  "audio/flac",
+	Transcode: audioTranscode("flac", "flac", transcode.AudioOptions{}),
+	audio:     true,
+}
+
+// ffmpeg muxers used to remux raw files from a seek position, keyed by the
+// MIME-type they produce.
+var remuxFormats = map[mimeType]string{
+	"video/x-matroska":        "matroska",
+	"video/webm":              "webm",
+	"video/mp2t":              "mpegts",
+	"video/vnd.dlna.mpeg-tts": "mpegts",
+	"video/mpeg":              "mpeg",
+	"video/mp4":               "mp4",
+}
+
+// Reports whether raw resources of the MIME-type can honour time-based seeks.
+func (me *Server) rawTimeSeekable(mt mimeType) bool {
+	if !me.RemuxTimeSeek {
+		return false
+	}
+	_, ok := remuxFormats[mt]
+	return ok
+}
+
+// Returns a spec that stream copies a raw file into its own container format.
+func remuxSpec(mt mimeType) transcodeSpec {
+	format := remuxFormats[mt]
+	return transcodeSpec{
+		mimeType: string(mt),
+		Transcode: ignoreOptions(func(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (io.ReadCloser, error) {
+			return transcode.Remux(ctx, path, format, start, length, stderr)
+		}),
+	}
 }
 
 func makeDeviceUuid(unique string) string {
@@ -120,19 +324,27 @@ var services = []*service{
 
 // The control URL for every service is the same. We're able to infer the desired service from the request headers.
 func init() {
-	for _, s := range services {
+	for _, s := range slices.Concat(services, []*service{recordingService}) {
 		s.ControlURL = serviceControlURL
 	}
 }
 
+// Returns the services offered, which depend on the configuration.
+func (me *Server) upnpServices() []*service {
+	if me.recordingEnabled() {
+		return slices.Concat(services, []*service{recordingService})
+	}
+	return services
+}
+
 func devices() []string {
 	return []string{
 		"urn:schemas-upnp-org:device:MediaServer:1",
 	}
 }
 
-func serviceTypes() (ret []string) {
-	for _, s := range services {
+func (me *Server) serviceTypes() (ret []string) {
+	for _, s := range me.upnpServices() {
 		ret = append(ret, s.ServiceType)
 	}
 	return
@@ -142,8 +354,9 @@ func (me *Server) httpPort() int {
 	return me.HTTPConn.Addr().(*net.TCPAddr).Port
 }
 
-func (me *Server) serveHTTP() error {
-	srv := &http.Server{
+func (me *Server) newHTTPServer() *http.Server {
+	return &http.Server{
+		ConnContext: withNetConn,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if me.LogHeaders {
 				fmt.Fprintf(os.Stderr, "%s %s\r\n", r.Method, r.RequestURI)
@@ -152,13 +365,48 @@ func (me *Server) serveHTTP() error {
 			}
 			w.Header().Set("Ext", "")
 			w.Header().Set("Server", serverField)
-			me.httpServeMux.ServeHTTP(&mitmRespWriter{
-				ResponseWriter: w,
-				logHeader:      me.LogHeaders,
-			}, r)
+			if me.RemoteConn != nil && r.TLS == nil && externalRequest(r) {
+				http.Error(w, "remote access is over HTTPS", http.StatusForbidden)
+				return
+			}
+			if me.banned(r) {
+				http.Error(w, "banned", http.StatusForbidden)
+				return
+			}
+			if !me.clientAllowed(r) {
+				me.Logger.Printf("not allowed client %s, %+v", playbackClient(r), me.AllowedIpNets)
+				me.offence(r, "not allowed")
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			if me.handleCORS(w, r) {
+				return
+			}
+			if me.LogHeaders {
+				w = &mitmRespWriter{
+					ResponseWriter: w,
+					logHeader:      true,
+				}
+			}
+			// Otherwise the net/http ResponseWriter is used as is, so file
+			// bodies can be sent with sendfile.
+			me.httpServeMux.ServeHTTP(w, r)
 		}),
 	}
-	err := srv.Serve(me.HTTPConn)
+}
+
+func (me *Server) serveHTTP() error {
+	err := me.httpServer.Serve(me.HTTPConn)
+	select {
+	case <-me.closed:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (me *Server) serveHTTPS() error {
+	err := me.httpsServer.ServeTLS(me.HTTPSConn, "", "")
 	select {
 	case <-me.closed:
 		return nil
@@ -194,9 +442,14 @@ func (me *Server) ssdpInterface(if_ net.Interface, addrString string) {
 		AddrString: addrString,
 		NetAddr:    ssdp.AddrString2NetAdd[addrString],
 		Devices:    devices(),
-		Services:   serviceTypes(),
-		Location: func(ip net.IP) string {
-			return me.location(ip)
+		Services: func() []string {
+			if me.NoDIAL {
+				return me.serviceTypes()
+			}
+			return append(me.serviceTypes(), dialServiceType)
+		}(),
+		Location: func(ip net.IP, userAgent string) string {
+			return me.location(ip, userAgent)
 		},
 		Server:         serverField,
 		UUID:           me.rootDeviceUUID,
@@ -218,6 +471,17 @@ func (me *Server) ssdpInterface(if_ net.Interface, addrString string) {
 		return
 	}
 	defer s.Close()
+	me.ssdpMu.Lock()
+	if me.ssdpServers == nil {
+		me.ssdpServers = make(map[*ssdp.Server]struct{})
+	}
+	me.ssdpServers[&s] = struct{}{}
+	me.ssdpMu.Unlock()
+	defer func() {
+		me.ssdpMu.Lock()
+		delete(me.ssdpServers, &s)
+		me.ssdpMu.Unlock()
+	}()
 	logger.Levelf(log.Info, "started SSDP on %q", if_.Name)
 	stopped := make(chan struct{})
 	go func() {
@@ -242,18 +506,38 @@ type Icon struct {
 }
 
 type Server struct {
-	HTTPConn               net.Listener
+	HTTPConn net.Listener
+	// The name clients show for the server. It can be a text/template using
+	// the fields of FriendlyNameData, so that servers on different hosts or
+	// sharing different folders are told apart.
 	FriendlyName           string
 	Interfaces             []net.Interface
 	httpServeMux           *http.ServeMux
+	httpServer             *http.Server
+	started                time.Time
 	RootObjectPath         string
-	OnBrowseDirectChildren func(path string, rootObjectPath string, host, userAgent string) (ret []interface{}, err error)
-	OnBrowseMetadata       func(path string, rootObjectPath string, host, userAgent string) (ret interface{}, err error)
+	OnBrowseDirectChildren func(path string, rootObjectPath string, host, userAgent string) (ret []interface{}, err error) // Deprecated: Use Provider.
+	OnBrowseMetadata       func(path string, rootObjectPath string, host, userAgent string) (ret interface{}, err error)   // Deprecated: Use Provider.
 	rootDescXML            []byte
 	rootDeviceUUID         string
-	FFProbeCache           Cache
-	closed                 chan struct{}
-	ssdpStopped            chan struct{}
+	// Caches probe results. If nil, an LRU cache is used, so they're reused
+	// for the life of the server.
+	FFProbeCache Cache
+	// Caches thumbnails, and the DIDL-Lite items of media files. If nil, LRU
+	// caches are used.
+	ThumbnailCache Cache
+	DIDLCache      Cache
+	// Caches images converted to JPEG for clients that can't show them. If
+	// nil, an LRU cache is used.
+	ImageCache  Cache
+	closed      chan struct{}
+	ssdpStopped chan struct{}
+	// Caches subtitle tracks extracted from videos. If nil, an LRU cache is
+	// used.
+	SubtitleCache Cache
+	// The SSDP servers running, to reannounce the device when it changes.
+	ssdpMu      sync.Mutex
+	ssdpServers map[*ssdp.Server]struct{}
 	// The service SOAP handler keyed by service URN.
 	services   map[string]UPnPService
 	LogHeaders bool
@@ -261,9 +545,49 @@ type Server struct {
 	NoTranscode bool
 	// Force transcoding to certain format of the 'transcodes' map
 	ForceTranscodeTo string
+	// Hardware encoders shared among the H.264 transcodes. Each transcode
+	// uses the least busy one it may, or libx264 if they're all at their
+	// MaxSessions.
+	Encoders        []Encoder
+	encoderSessions encoderSessions
 	// Disable media probing with ffprobe
 	NoProbe bool
-	Icons   []Icon
+	// Don't announce DIAL or serve its application resources.
+	NoDIAL bool
+	// MIME-types by file extension, such as "mkv": "video/x-matroska", in
+	// place of the system's. Files of a media type are listed.
+	MimeTypes map[string]string
+	// Probe the given number of most recently modified media files, and those
+	// in the given folders relative to the root, when the server starts, so
+	// browsing them doesn't wait on many probes at once. At most
+	// WarmUpConcurrency are probed at a time.
+	WarmUpRecent      int
+	WarmUpPaths       []string
+	WarmUpConcurrency int
+	// Honour time-based seeks on raw video by remuxing from the requested
+	// position with ffmpeg.
+	RemuxTimeSeek bool
+	Icons         []Icon
+	// If Icons is empty, the standard icon set is generated from this.
+	IconSource image.Image
+	// Shown by clients in device details. They default to "dms 1" and the
+	// author.
+	ModelName    string
+	Manufacturer string
+	// Further device details, left out of the description if empty.
+	ModelNumber  string
+	SerialNumber string
+	ModelURL     string
+	// Extra elements for the device description, after those dms gives
+	// DLNA and Samsung clients, such as "<sec:deviceID>...</sec:deviceID>".
+	// The dlna and sec namespace prefixes are declared.
+	VendorXML string
+	// The page clients offer to open for the server. Defaults to the
+	// server's own page.
+	PresentationURL string
+	// Guards the fields SetBranding changes, once the server is initialized.
+	brandingMu       sync.RWMutex
+	friendlyNameData FriendlyNameData
 	// Stall event subscription requests until they drop. A workaround for
 	// some bad clients.
 	StallEventSubscribe bool
@@ -275,25 +599,205 @@ type Server struct {
 	IgnoreUnreadable bool
 	// Ignore comma separated list of directories
 	IgnorePaths []string
-	// White list of clients
+	// Leave out folders on other filesystems than the root, such as mounts
+	// within it.
+	OneFileSystem bool
+	// Opening, statting and listing files give up after this long, so a hung
+	// network mount doesn't hold up every request. Zero waits forever.
+	FSTimeout time.Duration
+	// How long each photo is shown in a folder's slideshow video. 5s if
+	// zero.
+	SlideshowDuration time.Duration
+	// Audio played under slideshows, relative to the root. If empty, the
+	// first audio file in the folder is played, if there is one.
+	SlideshowMusic string
+	// The clients allowed to use any of the HTTP endpoints. Everyone if nil.
+	// The server's own host, and remote clients with RemoteUser's
+	// credentials, are always allowed.
 	AllowedIpNets []*net.IPNet
+	// Each client may make this many control and eventing requests a second,
+	// in bursts of up to ControlBurst, or 20 if it's zero, before being told
+	// to back off with 429 Too Many Requests. Zero means no limit.
+	ControlRate       float64
+	ControlBurst      int
+	controlRateLimits clientRateLimits
+	// Clients making this many forbidden or malformed requests within 10
+	// minutes are refused everything for BanDuration, or an hour if it's
+	// zero. Zero means clients are never banned.
+	BanThreshold int
+	BanDuration  time.Duration
+	bans         banTable
+	// Renderers found on the network, to play to.
+	renderers rendererCache
+	// Web apps at these origins, such as "https://cast.example.com", or any
+	// if one is "*", may use the JSON API, streams and subtitles. CORSMethods
+	// are the methods they may use, GET, HEAD, POST and DELETE if empty.
+	CORSOrigins []string
+	CORSMethods []string
+	// If set, the web UI, JSON API and streams are served over TLS on it, for
+	// clients away from home, with RemoteTLSConfig. Clients outside the local
+	// networks must give RemoteUser and RemotePassword, and are refused on
+	// HTTPConn.
+	RemoteConn      net.Listener
+	RemoteTLSConfig *tls.Config
+	RemoteUser      string
+	RemotePassword  string
+	// RemoteConn's port is mapped on the router by UPnP IGD or NAT-PMP, so
+	// it's reachable from the internet.
+	RemotePortMapping  bool
+	remoteServer       *http.Server
+	portMappingStopped chan struct{}
 	// Activate support for dynamic streams configured via .dms.json metadata files
 	// This feature is not enabled by default, since having write access to a shared media
 	// folder allows executing arbitrary commands in the context of the DLNA server.
 	AllowDynamicStreams bool
+	// Client specific behaviour. The first matching profile is used.
+	ClientProfiles []ClientProfile
+	// Folders holding audiobooks, relative to the root. Folders containing a
+	// .audiobook file are audiobooks too.
+	AudiobookPaths []string
+	// The language of the titles of containers dms makes up, such as "de" or
+	// "pt-BR". English if empty or not one of Languages. The web UI follows
+	// the browser's Accept-Language instead, if it can.
+	Language string
+	// Folders, relative to the root, that are only listed and served to
+	// clients unlocked with PIN, through the web UI or the unlock API.
+	ProtectedPaths []string
+	// The PIN that unlocks ProtectedPaths. If empty, they're never shown.
+	PIN string
+	// How long a client stays unlocked. An hour if zero.
+	UnlockDuration time.Duration
+	unlocked       unlockTable
+	wrongPINs      wrongPINTable
+	// Limits clients to parts of the library by their address. The first
+	// that matches a client applies, and clients matching none see all of it.
+	ClientRoots []ClientRoot
+	// If set, records how far clients get through media, so they can resume.
+	Playback *PlaybackHistory
+	// If set, the Favorites container lists these, and they can be changed
+	// through the web UI and API.
+	Favorites *Favorites
+	// If set, these are hidden from every client, and can be changed through
+	// the web UI and API.
+	HiddenPaths *HiddenPaths
+	// If set, a full-text index of the library used to answer CDS Search
+	// requests. It's kept up to date by walking the library in the background.
+	Search *search.Index
+	// If set, photos located by GPS are listed by the country and city it
+	// names, in a Places container in the root. Needs Search.
+	Geocoder Geocoder
+	placesMu sync.Mutex
+	places   placeTree
+	// Places of positions rounded by roundLocation, with no city if there
+	// isn't one.
+	geocoded map[search.Location]place
+	// If set, spans are made for SOAP actions, browsing, probes and
+	// transcodes, so slow responses can be traced.
+	TracerProvider trace.TracerProvider
+	// Containers in the root of the items matching queries over Search,
+	// listed after the built in virtual containers.
+	Collections []Collection
+	// If set, directories are listed from this database rather than FS, which
+	// is much faster for large libraries. It's kept up to date by scanning FS
+	// in the background. Playback is recorded in it too.
+	Library *Library
+	// Audio that clients play far enough through is submitted to these. Needs
+	// Playback.
+	Scrobblers []scrobble.Scrobbler
+	// Directory to keep copies of MP4s remuxed with their index at the start,
+	// for renderers that stall on files with it at the end. If empty, such
+	// files are remuxed as they're streamed, and can't be seeked by byte.
+	FaststartCachePath string
 	// pattern where to write transcode logs to. The [tsname] placeholder is replaced with the name
-	// of the item currently being played. The default is $HOME/.dms/log/[tsname]
+	// of the item currently being played. The default is $HOME/.dms/log/[tsname]. Each session's
+	// log is named with the time it started after that.
 	TranscodeLogPattern string
+	// Transcode logs older than this are deleted. Zero keeps them.
+	TranscodeLogMaxAge time.Duration
+	// The oldest transcode logs are deleted to keep them to this many bytes
+	// in all. Zero means no limit.
+	TranscodeLogMaxSize int64
+	transcodeLogPruning sync.Mutex
 	Logger              log.Logger
 	eventingLogger      log.Logger
-	FS                  fs.FS
+	// Where the media is served from. If nil, it's os.DirFS of the
+	// RootObjectPath, and the Roots are mounted in it. Any fs.FS will do,
+	// such as an embed.FS, fstest.MapFS or zip.Reader, though files are
+	// probed and transcoded by ffmpeg fetching them back over HTTP.
+	FS fs.FS
+	// FS without the Library in front of it, for scanning.
+	liveFS fs.FS
+	// Listed as the Torrents folder in the root, if set, such as a
+	// torrentfs.FS. Its files are read as they're streamed, so it's left out
+	// of the Library. If it's an http.Handler, it serves /api/torrents.
+	Torrents fs.FS
+	// Channels that recordings can be scheduled from, through the
+	// ScheduledRecording service. It's offered if there are channels, a
+	// RecordingsDir and Recordings.
+	Channels []Channel
+	// The directory recordings are written to, listed as the Recordings
+	// folder in the root.
+	RecordingsDir string
+	Recordings    *Recordings
+	// More folders shared, each as a container in the root. RootObjectPath
+	// may be empty if these are given.
+	Roots []RootDir
+	// Watch the folders served for changes, with inotify on Linux, so that
+	// listings are refreshed and ContentDirectory subscribers told straight
+	// away, rather than after the next scan of the library.
+	Watch bool
+	// The OS folders to watch, found in Init.
+	watchedRoots []watchedRoot
+
+	// If set, everything HTTPConn serves is served over TLS on it too, with
+	// HTTPSTLSConfig, for networks where traffic should be encrypted. Clients
+	// that reach it are given https URLs.
+	HTTPSConn      net.Listener
+	HTTPSTLSConfig *tls.Config
+	// Give the HTTPS location in SSDP to every client, rather than only to
+	// the searches of those whose profiles ask for it.
+	AdvertiseHTTPS bool
+	httpsServer    *http.Server
+	// If set, Browse is answered from it rather than the FS, and the
+	// resources of its items are served.
+	Provider    MediaProvider
+	hlsSessions hlsSessions
+	loopbackKey loopbackKey
+	// Files seen to be still being written to.
+	growingFiles resource.GrowingFiles
+	// MIME types of media offered to clients, for the ConnectionManager
+	// source protocol info.
+	mimeTypesMu sync.Mutex
+	mimeTypes   map[mimeType]struct{}
+	// Whether the library has been scanned for mimeTypes. Until then, any
+	// type is offered.
+	mimeTypesScanned bool
+	// Streams and prepared connections, exposed by the ConnectionManager.
+	connections connectionTable
+	// CPU time, output and failures of transcodes.
+	transcodeAccounting transcodeAccounting
+	// FS paths of MP4s being remuxed into the FaststartCachePath.
+	faststartMu      sync.Mutex
+	faststartPending map[string]struct{}
+	// Only one duplicates API request runs at a time, as they read the whole
+	// library.
+	duplicatesMu sync.Mutex
+	// From the last walk of the search indexer.
+	libraryStatsMu sync.Mutex
+	libraryStats   *LibraryStats
+	// By FS path, only used by the indexer.
+	mediaFacts map[string]mediaFacts
+	// For ContentDirectory events.
+	containerUpdates containerUpdates
 }
 
 // UPnP SOAP service.
 type UPnPService interface {
 	Handle(action string, argsXML []byte, r *http.Request) (respArgs [][2]string, err error)
 	Subscribe(callback []*url.URL, timeoutSeconds int) (sid string, actualTimeout int, err error)
+	Renew(sid string, timeoutSeconds int) (actualTimeout int, err error)
 	Unsubscribe(sid string) error
+	SendInitialEvent(sid string, props []upnp.Property)
 }
 
 type Cache interface {
@@ -301,20 +805,6 @@ type Cache interface {
 	Get(key interface{}) (value interface{}, ok bool)
 }
 
-type dummyFFProbeCache struct{}
-
-func (dummyFFProbeCache) Set(interface{}, interface{}) {}
-
-func (dummyFFProbeCache) Get(interface{}) (interface{}, bool) {
-	return nil, false
-}
-
-// Public definition so that external modules can persist cache contents.
-type FfprobeCacheItem struct {
-	Key   ffmpegInfoCacheKey
-	Value *ffprobe.Info
-}
-
 // update the UPnP object fields from ffprobe data
 // priority is given the format section, and then the streams sequentially
 func itemExtra(item *upnpav.Object, info *ffprobe.Info) {
@@ -346,17 +836,36 @@ type ffmpegInfoCacheKey struct {
 	ModTime int64
 }
 
-func transcodeResources(host, path, resolution, duration string) (ret []upnpav.Resource) {
-	ret = make([]upnpav.Resource, 0, len(transcodes))
-	for k, v := range transcodes {
-		ret = append(ret, upnpav.Resource{
-			ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", v.mimeType, dlna.ContentFeatures{
+// Returns the resources for the transcodes applicable to an item of the
+// MIME-type, as served to the client with the given User-Agent. The probe of
+// the file, which may be nil, describes the transcodes' streams.
+func (me *Server) transcodeResources(host, path string, mt mimeType, resolution, duration, userAgent string, info *ffprobe.Info) (ret []namedResource) {
+	ret = make([]namedResource, 0, len(transcodes))
+	flags := me.dlnaFlags(userAgent, TranscodeResource)
+	profile := me.clientProfile(userAgent)
+	probed := probedResStreamInfo(info)
+	audioOpts := me.clientAudioOptions(userAgent, info, 0)
+	for _, k := range transcodeOrder {
+		v := transcodes[k]
+		if !v.appliesTo(mt) {
+			continue
+		}
+		if v.dop && (profile == nil || !profile.DoP) {
+			continue
+		}
+		if v.videoCodec != "" && !profile.DecodesVideo(v.videoCodec) {
+			continue
+		}
+		res := upnpav.Resource{
+			ProtocolInfo: dlna.HTTPProtocolInfo(v.mimeType, dlna.ContentFeatures{
 				SupportTimeSeek: true,
+				PlaySpeeds:      v.playSpeeds(),
 				Transcoded:      true,
 				ProfileName:     v.DLNAProfileName,
-			}.String()),
+				Flags:           flags,
+			}),
 			URL: (&url.URL{
-				Scheme: "http",
+				Scheme: me.urlScheme(host),
 				Host:   host,
 				Path:   resPath,
 				RawQuery: url.Values{
@@ -366,89 +875,136 @@ func transcodeResources(host, path, resolution, duration string) (ret []upnpav.R
 			}).String(),
 			Resolution: resolution,
 			Duration:   duration,
-		})
-	}
-	return
-}
-
-func parseDLNARangeHeader(val string) (ret dlna.NPTRange, err error) {
-	if !strings.HasPrefix(val, "npt=") {
-		err = errors.New("bad prefix")
-		return
-	}
-	ret, err = dlna.ParseNPTRange(val[len("npt="):])
-	if err != nil {
-		return
+		}
+		transcodedResStreamInfo(v, probed, audioOpts).setAttrs(&res)
+		ret = append(ret, namedResource{k, res})
+	}
+	if mt.IsVideo() && profile != nil && slices.Contains(profile.Resources, hlsTranscodeName) && !me.NoProbe && hasMovingVideo(info) {
+		// Only for clients known to take HLS, as others would fail on the
+		// playlist.
+		ret = append(ret, namedResource{hlsTranscodeName, upnpav.Resource{
+			ProtocolInfo: dlna.HTTPProtocolInfo(hlsMimeType, dlna.ContentFeatures{
+				Transcoded: true,
+				Flags:      flags,
+			}),
+			URL: (&url.URL{
+				Scheme:   me.urlScheme(host),
+				Host:     host,
+				Path:     hlsPath,
+				RawQuery: url.Values{"path": {path}}.Encode(),
+			}).String(),
+			Resolution: resolution,
+			Duration:   duration,
+		}})
 	}
 	return
 }
 
-// Determines the time-based range to transcode, and sets the appropriate
-// headers. Returns !ok if there was an error and the caller should stop
-// handling the request.
-func handleDLNARange(w http.ResponseWriter, hs http.Header, dynamicMode bool) (r dlna.NPTRange, partialResponse, ok bool) {
-	if dynamicMode || len(hs[http.CanonicalHeaderKey(dlna.TimeSeekRangeDomain)]) == 0 {
-		ok = true
+// Sets the DLNA headers telling the client the file is live, and how much of it
+// is currently available.
+func (me *Server) setGrowingFileHeaders(w http.ResponseWriter, r *http.Request, filePath string, fi fs.FileInfo) {
+	w.Header().Set(dlna.RealTimeInfoDomain, dlna.RealTimeInfoUnboundedLag)
+	if r.Header.Get(dlna.GetAvailableSeekRangeDomain) == "" {
 		return
 	}
-	partialResponse = true
-	h := hs.Get(dlna.TimeSeekRangeDomain)
-	r, err := parseDLNARangeHeader(h)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	// Passing an exact NPT duration seems to cause trouble pass the "iono"
-	// (*) duration instead.
-	//
-	// TODO: Check that the request range can't already have /.
-	w.Header().Set(dlna.TimeSeekRangeDomain, h+"/*")
-	ok = true
-	return
-}
-
-func writeResponseCode(w http.ResponseWriter, partialResponse bool) {
-	w.WriteHeader(func() int {
-		if partialResponse {
-			return http.StatusPartialContent
-		} else {
-			return http.StatusOK
+	var npt dlna.NPTRange
+	if !me.NoProbe {
+		// The probe is keyed by modification time, so this tracks the
+		// growing file.
+		if ffInfo, _ := me.ffmpegProbe(r.Context(), filePath); ffInfo != nil {
+			npt.End, _ = ffInfo.Duration()
 		}
-	}())
+	}
+	w.Header().Set(dlna.AvailableSeekRangeDomain, dlna.FormatAvailableSeekRange(npt, fi.Size()))
 }
 
 func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, path_ string, ts transcodeSpec, tsname string, dynamicMode bool) {
 	w.Header().Set(dlna.TransferModeDomain, "Streaming")
 	w.Header().Set("content-type", ts.mimeType)
+	flags := ts.DLNAFlags
+	if flags == "" {
+		kind := TranscodeResource
+		if dynamicMode {
+			kind = DynamicResource
+		}
+		flags = me.dlnaFlags(r.UserAgent(), kind)
+	}
 	w.Header().Set(dlna.ContentFeaturesDomain, (dlna.ContentFeatures{
 		Transcoded:      true,
 		SupportTimeSeek: !dynamicMode,
+		PlaySpeeds:      ts.playSpeeds(),
 		ProfileName:     ts.DLNAProfileName,
-		Flags:           ts.DLNAFlags,
+		Flags:           flags,
 	}).String())
 	// If a range of any kind is given, we have to respond with 206 if we're
 	// interpreting that range. Since only the DLNA range is handled in this
 	// function, it alone determines if we'll give a partial response.
-	range_, partialResponse, ok := handleDLNARange(w, r.Header, dynamicMode)
+	range_, partialResponse, ok := resource.HandleTimeSeekRange(w, r.Header, dynamicMode)
 	if !ok {
 		return
 	}
+	if dynamicMode {
+		// Dynamic streams are generated as they're sent, there's nothing to
+		// seek in.
+		w.Header().Set(dlna.RealTimeInfoDomain, dlna.RealTimeInfoUnboundedLag)
+	}
+	speed := 1.0
+	if h := r.Header.Get(dlna.PlaySpeedDomain); h != "" {
+		var err error
+		speed, err = dlna.ParsePlaySpeed(h)
+		if err != nil || speed != 1 && !ts.supportsPlaySpeed(speed) {
+			// DLNA requires 406 for play speeds we can't provide.
+			http.Error(w, fmt.Sprintf("unsupported play speed: %q", h), http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set(dlna.PlaySpeedDomain, h)
+	}
 
 	// Samsung Frame TVs send a HEAD request first. If we don't terminate processing here,
 	// the TV will keep reading the data and crash eventually :)
 	if r.Method == "HEAD" {
-		writeResponseCode(w, partialResponse)
+		resource.WriteResponseCode(w, partialResponse)
 		return
 	}
+	defer me.trackConnection(r, fmt.Sprintf("http-get:*:%s:%s", ts.mimeType, w.Header().Get(dlna.ContentFeaturesDomain)))()
+	if !dynamicMode && speed == 1 && me.tracksPlayback(mimeType(ts.mimeType)) {
+		defer me.recordTranscodePlayback(r, path_, range_.Start, time.Now())
+	}
 
-	var logTsName string
+	var (
+		logTsName string
+		opts      transcode.Options
+		// How much of the item the transcode covers, if it's known.
+		remaining time.Duration
+	)
 	if !dynamicMode {
-		ffInfo, _ := me.ffmpegProbe(path_)
+		ffInfo, _ := me.ffmpegProbe(r.Context(), path_)
+		opts.AudioOptions = me.clientAudioOptions(r.UserAgent(), ffInfo, me.audioTrack(r, ffInfo))
+		if !ts.audio {
+			opts.Subtitles, opts.SubtitlesArePictures = me.subtitleTrack(r, ffInfo)
+			opts.Rotation = videoRotation(ffInfo)
+			opts.Deinterlace = me.deinterlaceFilter(r.UserAgent(), ffInfo)
+		}
+		if ts.videoCodec == "h264" && speed == 1 {
+			var (
+				name    string
+				release func()
+			)
+			opts.HWEncoder, name, release = me.acquireEncoder(tsname)
+			defer release()
+			if name != "" {
+				me.Logger.Printf("transcoding %q to %s with %s (%s)", path_, tsname, name, opts.HWEncoder)
+			}
+		}
 		if ffInfo != nil {
 			if duration, err := ffInfo.Duration(); err == nil {
 				s := fmt.Sprintf("%f", duration.Seconds())
 				w.Header().Set("content-duration", s)
 				w.Header().Set("x-content-duration", s)
+				remaining = duration - range_.Start
+				if range_.End > range_.Start {
+					remaining = min(remaining, range_.End-range_.Start)
+				}
 			}
 		}
 
@@ -456,31 +1012,68 @@ func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, pat
 	} else {
 		logTsName = tsname
 	}
-	stderrPath := strings.Replace(me.TranscodeLogPattern, "[tsname]", logTsName, -1)
+	started := time.Now()
 	var logFile io.Writer
-	if stderrPath != "" {
-		os.MkdirAll(filepath.Dir(stderrPath), 0o750)
-		aLogFile, err := os.Create(stderrPath)
-		if err != nil {
-			log.Printf("couldn't create transcode log file: %s", err)
-		} else {
-			defer aLogFile.Close()
-			log.Printf("logging transcode to %q", stderrPath)
-		}
+	aLogFile, logPath, err := me.createTranscodeLog(logTsName, started)
+	if err != nil {
+		log.Printf("couldn't create transcode log file: %s", err)
+	} else if aLogFile != nil {
+		defer aLogFile.Close()
+		log.Printf("logging transcode to %q", aLogFile.Name())
 		logFile = aLogFile
 	}
-	p, err := ts.Transcode(path_, range_.Start, range_.End-range_.Start, logFile)
+	delivery := me.clientProfile(r.UserAgent()).Delivery()
+	var stats *transcode.StatsWriter
+	if delivery == clientprofile.DeliveryLength && speed == 1 && remaining > 0 {
+		stats = &transcode.StatsWriter{W: logFile}
+		logFile = stats
+	}
+	input := path_
+	if !dynamicMode {
+		// The path is relative to the server's FS, which external commands
+		// can't see.
+		input = me.loopbackResURL(path_)
+	}
+	var p io.ReadCloser
+	session := me.startTranscodeSession(r, path_, tsname, started, logPath)
+	if speed != 1 {
+		p, err = ts.TrickPlay(r.Context(), input, speed, range_.Start, logFile)
+	} else {
+		p, err = ts.Transcode(r.Context(), input, range_.Start, range_.End-range_.Start, opts, logFile)
+	}
 	if err != nil {
+		me.endTranscodeSession(session, nil, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer p.Close()
+	defer func() {
+		p.Close()
+		me.endTranscodeSession(session, p, nil)
+	}()
+	var body io.Reader = p
+	switch {
+	case stats != nil:
+		var length int64
+		body, length, err = prebufferTranscode(p, stats, remaining)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if length >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+			body = io.LimitReader(body, length)
+		}
+	case delivery == clientprofile.DeliveryClose:
+		// Go's server then neither chunks the response nor keeps the
+		// connection open after it.
+		w.Header().Set("Transfer-Encoding", "identity")
+	}
 	// I recently switched this to returning 200 if no range is specified for
 	// pure UPnP clients. It's possible that DLNA clients will *always* expect
 	// 206. It appears the HTTP standard requires that 206 only be used if a
 	// response is not interpreting any range headers.
-	writeResponseCode(w, partialResponse)
-	io.Copy(w, p)
+	resource.WriteResponseCode(w, partialResponse)
+	io.Copy(session.writer(w), body)
 }
 
 func init() {
@@ -545,13 +1138,13 @@ func (me *mitmRespWriter) Write(b []byte) (int, error) {
 	return me.ResponseWriter.Write(b)
 }
 
-func (me *mitmRespWriter) CloseNotify() <-chan bool {
-	return me.ResponseWriter.(http.CloseNotifier).CloseNotify()
+func (me *mitmRespWriter) Unwrap() http.ResponseWriter {
+	return me.ResponseWriter
 }
 
 // Set the SCPD serve paths.
 func init() {
-	for _, s := range services {
+	for _, s := range slices.Concat(services, []*service{recordingService}) {
 		lastInd := strings.LastIndex(s.ServiceId, ":")
 		p := path.Join("/scpd", s.ServiceId[lastInd+1:])
 		s.SCPDURL = p + ".xml"
@@ -559,8 +1152,8 @@ func init() {
 }
 
 // Install handlers to serve SCPD for each UPnP service.
-func handleSCPDs(mux *http.ServeMux) {
-	for _, s := range services {
+func (me *Server) handleSCPDs(mux *http.ServeMux) {
+	for _, s := range me.upnpServices() {
 		mux.HandleFunc(s.SCPDURL, func(serviceDesc string) http.HandlerFunc {
 			return func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("content-type", `text/xml; charset="utf-8"`)
@@ -570,19 +1163,6 @@ func handleSCPDs(mux *http.ServeMux) {
 	}
 }
 
-// Marshal SOAP response arguments into a response XML snippet.
-func marshalSOAPResponse(sa upnp.SoapAction, args [][2]string) []byte {
-	soapArgs := make([]soap.Arg, 0, len(args))
-	for _, arg := range args {
-		argName, value := arg[0], arg[1]
-		soapArgs = append(soapArgs, soap.Arg{
-			XMLName: xml.Name{Local: argName},
-			Value:   value,
-		})
-	}
-	return []byte(fmt.Sprintf(`<u:%[1]sResponse xmlns:u="%[2]s">%[3]s</u:%[1]sResponse>`, sa.Action, sa.ServiceURN.String(), xmlMarshalOrPanic(soapArgs)))
-}
-
 // Handle a SOAP request and return the response arguments or UPnP error.
 func (me *Server) soapActionResponse(sa upnp.SoapAction, actionRequestXML []byte, r *http.Request) ([][2]string, error) {
 	service, ok := me.services[sa.Type]
@@ -593,33 +1173,69 @@ func (me *Server) soapActionResponse(sa upnp.SoapAction, actionRequestXML []byte
 	return service.Handle(sa.Action, actionRequestXML, r)
 }
 
-// Handle a service control HTTP request.
-func (me *Server) serviceControlHandler(w http.ResponseWriter, r *http.Request) {
-	found := false
-	clientIp, _, _ := net.SplitHostPort(r.RemoteAddr)
-	if zoneDelimiterIdx := strings.Index(clientIp, "%"); zoneDelimiterIdx != -1 {
-		// IPv6 addresses may have the form address%zone (e.g. ::1%eth0)
-		clientIp = clientIp[:zoneDelimiterIdx]
+// Reads the action from a service control request. Malformed requests give
+// UPnP errors: Invalid Action if the action can't be identified, and Invalid
+// Args if the envelope can't be read.
+func readSOAPRequest(r *http.Request) (sa upnp.SoapAction, actionXML []byte, err error) {
+	sa, err = upnp.ParseActionHTTPHeader(r.Header.Get("SOAPACTION"))
+	if err != nil {
+		err = upnp.Errorf(upnp.InvalidActionErrorCode, "%s", err.Error())
+		return
 	}
-	for _, ipnet := range me.AllowedIpNets {
-		if ipnet.Contains(net.ParseIP(clientIp)) {
-			found = true
-		}
+	env, err := soap.ReadEnvelope(r.Body)
+	if err == soap.ErrRequestTooLarge {
+		return
 	}
-	if !found {
-		log.Printf("not allowed client %s, %+v", clientIp, me.AllowedIpNets)
-		http.Error(w, "forbidden", http.StatusForbidden)
+	if err != nil {
+		err = upnp.Errorf(upnp.InvalidArgsErrorCode, "malformed SOAP envelope: %s", err.Error())
 		return
 	}
-	soapActionString := r.Header.Get("SOAPACTION")
-	soapAction, err := upnp.ParseActionHTTPHeader(soapActionString)
+	name, err := env.Body.ActionName()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		err = upnp.Errorf(upnp.InvalidActionErrorCode, "%s", err.Error())
 		return
 	}
-	var env soap.Envelope
-	if err := xml.NewDecoder(r.Body).Decode(&env); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if name.Local != sa.Action {
+		err = upnp.Errorf(upnp.InvalidActionErrorCode, "body has action %q, SOAPACTION has %q", name.Local, sa.Action)
+		return
+	}
+	actionXML = env.Body.Action
+	return
+}
+
+// Reports whether the client may use the server, as AllowedIpNets says.
+func (me *Server) clientAllowed(r *http.Request) bool {
+	if me.AllowedIpNets == nil {
+		return true
+	}
+	// IPv6 addresses may have the form address%zone (e.g. ::1%eth0)
+	client, _, _ := strings.Cut(playbackClient(r), "%")
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() && me.isLoopbackRequest(r) {
+		// ffmpeg and the like read files through the server. Other requests
+		// from the server's host, such as through a reverse proxy, need to be
+		// allowed like any.
+		return true
+	}
+	for _, ipnet := range me.AllowedIpNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return viaRemoteConn(r) && me.remoteAuthorized(r)
+}
+
+// Handle a service control HTTP request.
+func (me *Server) serviceControlHandler(w http.ResponseWriter, r *http.Request) {
+	soapAction, actionXML, err := readSOAPRequest(r)
+	if err != nil {
+		me.offence(r, "malformed SOAP request")
+	}
+	if err == soap.ErrRequestTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
 		return
 	}
 	// AwoX/1.1 UPnP/1.0 DLNADOC/1.50
@@ -627,33 +1243,92 @@ func (me *Server) serviceControlHandler(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
 	w.Header().Set("Ext", "")
 	w.Header().Set("Server", serverField)
-	soapRespXML, code := func() ([]byte, int) {
-		respArgs, err := me.soapActionResponse(soapAction, env.Body.Action, r)
-		if err != nil {
-			upnpErr := upnp.ConvertError(err)
-			return xmlMarshalOrPanic(soap.NewFault("UPnPError", upnpErr)), 500
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.WriteString(soapEnvelopeStart)
+	code := http.StatusOK
+	var respArgs [][2]string
+	if err == nil && !me.allowControlRequest(w, r) {
+		err = errTooManyRequests
+	} else if err == nil {
+		ctx, span := me.startSpan(r.Context(), soapAction.Action,
+			attribute.String("upnp.service", soapAction.Type),
+			attribute.String("user_agent.original", r.UserAgent()),
+			attribute.String("client.address", playbackClient(r)),
+		)
+		respArgs, err = me.soapActionResponse(soapAction, actionXML, r.WithContext(ctx))
+		endSpan(span, err)
+	}
+	if err != nil {
+		code = http.StatusInternalServerError
+		if err == errTooManyRequests {
+			code = http.StatusTooManyRequests
 		}
-		return marshalSOAPResponse(soapAction, respArgs), 200
-	}()
-	bodyStr := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8" standalone="yes"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>%s</s:Body></s:Envelope>`, soapRespXML)
-	// Compatibility with Samsung Frame TV's - they don't display an empty content directory without this hack:
-	bodyStr = strings.Replace(bodyStr, "&#34;", `"`, -1)
+		fault := xmlMarshalOrPanic(soap.NewFault("UPnPError", upnp.ConvertError(err)))
+		// Compatibility with Samsung Frame TV's, as in writeSOAPResponse.
+		buf.Write(bytes.ReplaceAll(fault, []byte("&#34;"), []byte(`"`)))
+	} else {
+		writeSOAPResponse(buf, soapAction, respArgs)
+	}
+	buf.WriteString(soapEnvelopeEnd)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
 	w.WriteHeader(code)
-	if _, err := w.Write([]byte(bodyStr)); err != nil {
+	if _, err := w.Write(buf.Bytes()); err != nil {
 		log.Print(err)
 	}
 }
 
+// Returns the FS path of the given path under the root. FS paths are slash
+// separated on every OS, so backslashes are separators here on Windows.
 func safeFilePath(root, given string) string {
-	return filepath.Join(root, filepath.Clean(given))
+	return path.Join(filepath.ToSlash(root), path.Clean(filepath.ToSlash(given)))
 }
 
 func (s *Server) filePath(_path string) string {
 	return safeFilePath(s.RootObjectPath, _path)
 }
 
+// Serves a file from the FS. Unlike http.ServeFileFS, files that are an
+// *os.File, as from os.DirFS, are given to http.ServeContent as they are, so
+// that the body can be sent with sendfile rather than copied through user
+// space, and files that can't seek, as from a zip.Reader, are still served
+// with ranges.
+func (me *Server) serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	f, err := me.FS.Open(filePath)
+	if err == nil {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			switch f := f.(type) {
+			case *os.File:
+				defer f.Close()
+				http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+				return
+			case io.Seeker:
+			default:
+				rf := &reopeningFile{fsys: me.FS, name: filePath, size: fi.Size(), f: f}
+				defer rf.Close()
+				http.ServeContent(w, r, fi.Name(), fi.ModTime(), rf)
+				return
+			}
+		}
+		f.Close()
+	}
+	http.ServeFileFS(w, r, me.FS, filePath)
+}
+
 func (me *Server) serveIcon(w http.ResponseWriter, r *http.Request) {
 	filePath := me.filePath(r.URL.Query().Get("path"))
+	if me.hiddenFrom(playbackClient(r), filePath) {
+		http.NotFound(w, r)
+		return
+	}
+	if me.AllowDynamicStreams && strings.HasSuffix(filePath, dmsMetadataSuffix) {
+		// Artwork of its own, relative to the .dms.json file.
+		item, err := me.readDynamicStreamItem(filePath, r.URL.Query().Get("item"))
+		if err == nil && item.Artwork != "" && !isAbsURL(item.Artwork) {
+			me.serveFile(w, r, path.Join(path.Dir(filePath), item.Artwork))
+			return
+		}
+	}
 	c := r.URL.Query().Get("c")
 	if c == "" {
 		c = "png"
@@ -669,14 +1344,34 @@ func (me *Server) serveIcon(w http.ResponseWriter, r *http.Request) {
 		args = append(args, "-t", strconv.Itoa(rand.Intn(100)))
 	}
 
+	// Random thumbnails are meant to differ each time.
+	var cacheKey *thumbnailCacheKey
+	if fi, err := fs.Stat(me.FS, filePath); err == nil && !randThumbnail && me.ThumbnailCache != nil {
+		cacheKey = &thumbnailCacheKey{filePath, fi.ModTime().UnixNano(), c}
+		if body, ok := me.ThumbnailCache.Get(*cacheKey); ok {
+			http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(body.([]byte)))
+			return
+		}
+	}
+
 	args = append(args, "-i", filePath, "-o", "/dev/stdout", "-c"+c)
 	cmd := exec.Command("ffmpegthumbnailer", args...)
 	// cmd.Stderr = os.Stderr
 	body, err := cmd.Output()
+	if err == nil && cacheKey != nil {
+		me.ThumbnailCache.Set(*cacheKey, body)
+	}
 	if err != nil {
 		// serve 1st Icon if no ffmpegthumbnailer
-		w.Header().Set("Content-Type", me.Icons[0].Mimetype)
-		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(me.Icons[0].Bytes))
+		me.brandingMu.RLock()
+		icons := me.Icons
+		me.brandingMu.RUnlock()
+		if len(icons) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", icons[0].Mimetype)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(icons[0].Bytes))
 		// http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -685,120 +1380,39 @@ func (me *Server) serveIcon(w http.ResponseWriter, r *http.Request) {
 
 func (me *Server) serveSubtitle(w http.ResponseWriter, r *http.Request) {
 	filePath := me.filePath(r.URL.Query().Get("path"))
-	subtitleFilePath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".srt"
-	http.ServeFile(w, r, subtitleFilePath)
-}
-
-func (server *Server) contentDirectoryInitialEvent(urls []*url.URL, sid string) {
-	body := xmlMarshalOrPanic(upnp.PropertySet{
-		Properties: []upnp.Property{
-			{
-				Variable: upnp.Variable{
-					XMLName: xml.Name{
-						Local: "SystemUpdateID",
-					},
-					Value: "0",
-				},
-			},
-			// upnp.Property{
-			// 	Variable: upnp.Variable{
-			// 		XMLName: xml.Name{
-			// 			Local: "ContainerUpdateIDs",
-			// 		},
-			// 	},
-			// },
-			// upnp.Property{
-			// 	Variable: upnp.Variable{
-			// 		XMLName: xml.Name{
-			// 			Local: "TransferIDs",
-			// 		},
-			// 	},
-			// },
-		},
-		Space: "urn:schemas-upnp-org:event-1-0",
-	})
-	body = append([]byte(`<?xml version="1.0"?>`+"\n"), body...)
-	server.eventingLogger.Print(string(body))
-	for _, _url := range urls {
-		bodyReader := bytes.NewReader(body)
-		req, err := http.NewRequest("NOTIFY", _url.String(), bodyReader)
-		if err != nil {
-			log.Printf("Could not create a request to notify %s: %s", _url.String(), err)
-			continue
-		}
-		req.Header["CONTENT-TYPE"] = []string{`text/xml; charset="utf-8"`}
-		req.Header["NT"] = []string{"upnp:event"}
-		req.Header["NTS"] = []string{"upnp:propchange"}
-		req.Header["SID"] = []string{sid}
-		req.Header["SEQ"] = []string{"0"}
-		// req.Header["TRANSFER-ENCODING"] = []string{"chunked"}
-		// req.ContentLength = int64(bodyReader.Len())
-		server.eventingLogger.Print(req.Header)
-		server.eventingLogger.Print("starting notify")
-		resp, err := http.DefaultClient.Do(req)
-		server.eventingLogger.Print("finished notify")
+	if me.hiddenFrom(playbackClient(r), filePath) {
+		http.NotFound(w, r)
+		return
+	}
+	if q := r.URL.Query().Get(subtitleIndexQueryKey); q != "" {
+		index, err := strconv.Atoi(q)
 		if err != nil {
-			log.Printf("Could not notify %s: %s", _url.String(), err)
-			continue
+			http.Error(w, "bad index", http.StatusBadRequest)
+			return
 		}
-		server.eventingLogger.Print(resp)
-		b, _ := ioutil.ReadAll(resp.Body)
-		server.eventingLogger.Println(string(b))
-		resp.Body.Close()
-	}
-}
-
-func (server *Server) contentDirectoryEventSubHandler(w http.ResponseWriter, r *http.Request) {
-	if server.StallEventSubscribe {
-		// I have an LG TV that doesn't like my eventing implementation.
-		// Returning unimplemented (501?) errors, results in repeat subscribe
-		// attempts which hits some kind of error count limit on the TV
-		// causing it to forcefully disconnect. It also won't work if the CDS
-		// service doesn't include an EventSubURL. The best thing I can do is
-		// cause every attempt to subscribe to timeout on the TV end, which
-		// reduces the error rate enough that the TV continues to operate
-		// without eventing.
-		//
-		// I've not found a reliable way to identify this TV, since it and
-		// others don't seem to include any client-identifying headers on
-		// SUBSCRIBE requests.
-		//
-		// TODO: Get eventing to work with the problematic TV.
-		t := time.Now()
-		<-w.(http.CloseNotifier).CloseNotify()
-		server.eventingLogger.Printf("stalled subscribe connection went away after %s", time.Since(t))
+		me.serveEmbeddedSubtitles(w, r, filePath, index)
 		return
 	}
-	// The following code is a work in progress. It partially implements
-	// the spec on eventing but hasn't been completed as I have nothing to
-	// test it with.
-	server.eventingLogger.Print(r.Header)
-	service := server.services["ContentDirectory"]
-	server.eventingLogger.Println(r.RemoteAddr, r.Method, r.Header.Get("SID"))
-	if r.Method == "SUBSCRIBE" && r.Header.Get("SID") == "" {
-		urls := upnp.ParseCallbackURLs(r.Header.Get("CALLBACK"))
-		server.eventingLogger.Println(urls)
-		var timeout int
-		fmt.Sscanf(r.Header.Get("TIMEOUT"), "Second-%d", &timeout)
-		server.eventingLogger.Println(timeout, r.Header.Get("TIMEOUT"))
-		sid, timeout, _ := service.Subscribe(urls, timeout)
-		w.Header()["SID"] = []string{sid}
-		w.Header()["TIMEOUT"] = []string{fmt.Sprintf("Second-%d", timeout)}
-		// TODO: Shouldn't have to do this to get headers logged.
-		w.WriteHeader(http.StatusOK)
-		go func() {
-			time.Sleep(100 * time.Millisecond)
-			server.contentDirectoryInitialEvent(urls, sid)
-		}()
-	} else if r.Method == "SUBSCRIBE" {
-		http.Error(w, "meh", http.StatusPreconditionFailed)
-	} else {
-		server.eventingLogger.Printf("unhandled event method: %s", r.Method)
+	subtitleFilePath, ok := me.subtitleFile(r.UserAgent(), filePath)
+	if !ok {
+		http.NotFound(w, r)
+		return
 	}
+	if subtitles.CanConvert(path.Ext(subtitleFilePath)) {
+		me.serveConvertedSubtitles(w, r, subtitleFilePath)
+		return
+	}
+	me.serveFile(w, r, subtitleFilePath)
+}
+
+// The ContentDirectory state sent to new event subscribers. No containers
+// have changed since they subscribed.
+func (me *Server) contentDirectoryInitialProperties() []upnp.Property {
+	return contentDirectoryProperties(me.containerUpdates.systemUpdateID(), "")
 }
 
 func (server *Server) serveDynamicStream(w http.ResponseWriter, r *http.Request, metadataPath string) error {
-	dmsMediaItem, err := readDynamicStream(metadataPath)
+	dmsMediaItem, err := server.readDynamicStreamItem(metadataPath, r.URL.Query().Get("item"))
 	if err != nil {
 		return err
 	}
@@ -820,7 +1434,7 @@ func (server *Server) serveDynamicStream(w http.ResponseWriter, r *http.Request,
 		DLNAProfileName: dmsStream.DlnaProfileName,
 		DLNAFlags:       dmsStream.DlnaFlags,
 		mimeType:        dmsStream.MimeType,
-		Transcode:       transcode.Exec,
+		Transcode:       ignoreOptions(transcode.Exec),
 	}
 	server.serveDLNATranscode(w, r, dmsStream.Command, dmsTsSpec, filepath.Base(metadataPath), true)
 	return nil
@@ -829,27 +1443,87 @@ func (server *Server) serveDynamicStream(w http.ResponseWriter, r *http.Request,
 func (server *Server) initMux(mux *http.ServeMux) {
 	// Handle root (presentationURL)
 	mux.HandleFunc("/", func(resp http.ResponseWriter, req *http.Request) {
+		lang := server.webLanguage(req.Header.Get("Accept-Language"))
 		resp.Header().Set("content-type", "text/html")
+		resp.Header().Set("content-language", lang)
+		resp.Header().Add("vary", "Accept-Language")
 		err := rootTmpl.Execute(resp, struct {
-			Readonly bool
-			Path     string
+			Readonly         bool
+			Path             string
+			Lang             string
+			Protected        bool
+			Client           string
+			Favorites        []string
+			FavoritesEnabled bool
+			Hidden           []string
+			HidingEnabled    bool
+			Renderers        []*avtransport.Renderer
+			Library          *LibraryStats
 		}{
 			true,
 			server.RootObjectPath,
+			lang,
+			len(server.ProtectedPaths) != 0 && server.PIN != "",
+			playbackClient(req),
+			server.visibleFavorites(playbackClient(req)),
+			server.Favorites != nil,
+			func() []string {
+				if server.HiddenPaths == nil {
+					return nil
+				}
+				return server.visibleHiddenPaths(playbackClient(req))
+			}(),
+			server.HiddenPaths != nil,
+			server.cachedRenderers(),
+			func() *LibraryStats {
+				if stats, ok := server.LibraryStats(); ok {
+					return &stats
+				}
+				return nil
+			}(),
 		})
 		if err != nil {
 			log.Println(err)
 		}
 	})
-	mux.HandleFunc(contentDirectoryEventSubURL, server.contentDirectoryEventSubHandler)
+	mux.Handle(contentDirectoryEventSubURL, server.rateLimited(&eventing.Handler{
+		Service:           server.services["ContentDirectory"],
+		InitialProperties: server.contentDirectoryInitialProperties,
+		Stall:             server.StallEventSubscribe,
+		Logger:            server.eventingLogger,
+	}))
 	mux.HandleFunc(iconPath, server.serveIcon)
+	mux.HandleFunc(providerResPath, server.serveProviderResource)
 	mux.HandleFunc(subtitlePath, server.serveSubtitle)
+	mux.HandleFunc(streamPath, server.serveIcecast)
+	mux.HandleFunc(slideshowPath, server.serveSlideshow)
+	mux.HandleFunc(hlsPath, server.serveHLS)
+	mux.HandleFunc(playbackAPIPath, server.servePlaybackAPI)
+	mux.HandleFunc(searchAPIPath, server.serveSearchAPI)
+	mux.HandleFunc(unlockAPIPath, server.serveUnlockAPI)
+	mux.HandleFunc(favoritesAPIPath, server.serveFavoritesAPI)
+	mux.HandleFunc(hiddenAPIPath, server.serveHiddenAPI)
+	mux.HandleFunc(bansAPIPath, server.serveBansAPI)
+	mux.HandleFunc(duplicatesAPIPath, server.serveDuplicatesAPI)
+	mux.HandleFunc(libraryStatsAPIPath, server.serveLibraryStatsAPI)
+	mux.HandleFunc(transcodeStatsAPIPath, server.serveTranscodeStatsAPI)
+	mux.HandleFunc(transcodeLogAPIPath, server.serveTranscodeLogAPI)
+	mux.HandleFunc(torrentsAPIPath, server.serveTorrentsAPI)
+	mux.HandleFunc(renderersAPIPath, server.serveRenderersAPI)
+	if !server.NoDIAL {
+		mux.HandleFunc(dialAppsPath, server.serveDIALApps)
+	}
+	mux.HandleFunc(playToAPIPath, server.servePlayToAPI)
 	mux.HandleFunc(resPath, func(w http.ResponseWriter, r *http.Request) {
-		filePath := server.filePath(r.URL.Query().Get("path"))
+		query := r.URL.Query()
+		filePath := server.filePath(query.Get("path"))
+		// Requests from our own ffmpeg and ffprobe invocations always get the
+		// raw file, protected or not.
+		loopback := server.isLoopbackRequest(r)
 		if ignored, err := server.IgnorePath(filePath); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
-		} else if ignored {
+		} else if ignored || !loopback && server.hiddenFrom(playbackClient(r), filePath) {
 			http.Error(w, "no such object", http.StatusNotFound)
 			return
 		}
@@ -865,27 +1539,75 @@ func (server *Server) initMux(mux *http.ServeMux) {
 				return
 			}
 		}
+		mimeType, err := server.mimeTypeByPath(filePath)
+		if !loopback && mimeType.IsVideo() && r.Header.Get(getCaptionInfoHeader) != "" {
+			server.setCaptionInfoHeader(w, r, query.Get("path"), filePath)
+		}
 		var k string
-		if server.ForceTranscodeTo != "" {
+		if server.ForceTranscodeTo != "" && !loopback && transcodes[server.ForceTranscodeTo].appliesTo(mimeType) {
 			k = server.ForceTranscodeTo
 		} else {
-			k = r.URL.Query().Get("transcode")
+			k = query.Get("transcode")
 		}
-		mimeType, err := MimeTypeByPath(server.FS, filePath)
 		if k == "" || mimeType.IsImage() {
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			w.Header().Set("Content-Type", string(mimeType))
+			if !loopback && server.convertsImage(r.UserAgent(), mimeType) {
+				server.serveConvertedImage(w, r, filePath)
+				return
+			}
+			// Our own ffprobe fetches the file from here.
+			if !loopback && !server.NoProbe && !mimeType.IsImage() {
+				if info, err := server.ffmpegProbe(r.Context(), filePath); err == nil {
+					mimeType = server.probedMimeType(filePath, mimeType, info)
+				}
+			}
+			if r.Header.Get(dlna.TimeSeekRangeDomain) != "" && server.rawTimeSeekable(mimeType) {
+				server.serveDLNATranscode(w, r, filePath, remuxSpec(mimeType), "remux", false)
+				return
+			}
+			w.Header().Set("Content-Type", string(server.clientMimeType(r.UserAgent(), filePath, mimeType)))
 			w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(path.Base(filePath)))
+			fi, _ := fs.Stat(server.FS, filePath)
+			if fi != nil && !mimeType.IsImage() && server.isGrowing(filePath, fi) {
+				server.setGrowingFileHeaders(w, r, filePath, server.liveFileInfo(filePath, fi))
+			}
+			serve := func(w http.ResponseWriter, r *http.Request) {
+				server.serveFile(w, r, filePath)
+			}
+			supportRange := true
+			if !loopback && !server.NoTranscode && server.needsFaststart(filePath) {
+				serve = func(w http.ResponseWriter, r *http.Request) {
+					server.serveFaststart(w, r, filePath, fi)
+				}
+				_, _, supportRange = server.faststartCopy(filePath, fi)
+			}
+			flags := server.dlnaFlags(r.UserAgent(), server.rawResourceKind(mimeType, filePath, fi))
 			if r.Header.Get("getContentFeatures.dlna.org") != "" {
+				var ffInfo *ffprobe.Info
+				if !server.NoProbe {
+					ffInfo, _ = server.ffmpegProbe(r.Context(), filePath)
+				}
 				w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{
-					SupportTimeSeek: true,
-					SupportRange:    true,
+					ProfileName:     dlnaProfileName(mimeType, filePath, ffInfo),
+					SupportTimeSeek: server.rawTimeSeekable(mimeType),
+					SupportRange:    supportRange,
+					Flags:           flags,
 				}.String())
 			}
-			http.ServeFileFS(w, r, server.FS, filePath)
+			if r.Method != "HEAD" && !loopback {
+				defer server.trackConnection(r, dlna.HTTPProtocolInfo(server.clientMimeType(r.UserAgent(), filePath, mimeType).String(), dlna.ContentFeatures{
+					SupportRange: supportRange,
+					Flags:        flags,
+				}))()
+			}
+			if r.Method == "GET" && !loopback && fi != nil && server.tracksPlayback(mimeType) {
+				server.serveFileRecordingPlayback(w, r, filePath, fi.Size(), serve)
+				return
+			}
+			serve(w, r)
 			return
 		}
 		if server.NoTranscode {
@@ -893,35 +1615,48 @@ func (server *Server) initMux(mux *http.ServeMux) {
 			return
 		}
 		spec, ok := transcodes[k]
+		if k == adjustedTranscodeKey {
+			spec, ok = adjustedAudioSpec, true
+		}
 		if !ok {
 			http.Error(w, fmt.Sprintf("bad transcode spec key: %s", k), http.StatusBadRequest)
 			return
 		}
+		if !spec.appliesTo(mimeType) {
+			http.Error(w, fmt.Sprintf("transcode %s not applicable to %s", k, mimeType), http.StatusBadRequest)
+			return
+		}
 		server.serveDLNATranscode(w, r, filePath, spec, k, false)
 	})
 	mux.HandleFunc(rootDescPath, func(w http.ResponseWriter, r *http.Request) {
+		server.brandingMu.RLock()
+		rootDescXML := server.rootDescXML
+		server.brandingMu.RUnlock()
 		w.Header().Set("content-type", `text/xml; charset="utf-8"`)
-		w.Header().Set("content-length", fmt.Sprint(len(server.rootDescXML)))
+		w.Header().Set("content-length", fmt.Sprint(len(rootDescXML)))
 		w.Header().Set("server", serverField)
-		w.Write(server.rootDescXML)
+		if !server.NoDIAL {
+			w.Header().Set("Application-URL", requestBaseURL(r)+dialAppsPath)
+		}
+		w.Write(rootDescXML)
 	})
-	handleSCPDs(mux)
+	server.handleSCPDs(mux)
 	mux.HandleFunc(serviceControlURL, server.serviceControlHandler)
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
-	// DeviceIcons
-	iconHandl := func(w http.ResponseWriter, r *http.Request) {
-		idStr := path.Base(r.URL.Path)
-		id, _ := strconv.Atoi(idStr)
-		if id < 0 || id >= len(server.Icons) {
-			id = 0
+	// DeviceIcons. They can be replaced with SetBranding.
+	mux.HandleFunc(deviceIconPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(path.Base(r.URL.Path))
+		server.brandingMu.RLock()
+		icons := server.Icons
+		server.brandingMu.RUnlock()
+		if err != nil || id < 0 || id >= len(icons) {
+			http.NotFound(w, r)
+			return
 		}
-		di := server.Icons[id]
+		di := icons[id]
 		w.Header().Set("Content-Type", di.Mimetype)
 		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(di.Bytes))
-	}
-	for i := range server.Icons {
-		mux.HandleFunc(fmt.Sprintf("%s/%d", deviceIconPath, i), iconHandl)
-	}
+	})
 }
 
 func (s *Server) initServices() (err error) {
@@ -939,7 +1674,8 @@ func (s *Server) initServices() (err error) {
 	}
 	s.services = map[string]UPnPService{
 		urn.Type: &contentDirectoryService{
-			Server: s,
+			Server:   s,
+			Eventing: upnp.Eventing{Notify: eventing.Sender(s.eventingLogger)},
 		},
 		urn1.Type: &connectionManagerService{
 			Server: s,
@@ -948,14 +1684,54 @@ func (s *Server) initServices() (err error) {
 			Server: s,
 		},
 	}
+	if s.recordingEnabled() {
+		urn, err := upnp.ParseServiceType(recordingService.ServiceType)
+		if err != nil {
+			return err
+		}
+		s.services[urn.Type] = &scheduledRecordingService{
+			Server: s,
+		}
+	}
 	return
 }
 
 func (srv *Server) Init() (err error) {
 	if srv.FS == nil {
-		fsys := os.DirFS(srv.RootObjectPath)
+		var fsys fs.FS = os.DirFS(RootPath(srv.RootObjectPath))
+		if srv.RootObjectPath == "" && len(srv.Roots) != 0 {
+			fsys = emptyFS{}
+		} else {
+			srv.watchedRoots = append(srv.watchedRoots, watchedRoot{RootPath(srv.RootObjectPath), "."})
+		}
 		srv.FS = fsys
 	}
+	for _, r := range srv.Roots {
+		srv.watchedRoots = append(srv.watchedRoots, watchedRoot{RootPath(r.Path), r.Name})
+	}
+	if srv.OneFileSystem {
+		if srv.FS, err = oneFileSystemFS(srv.FS); err != nil {
+			return fmt.Errorf("finding the root's filesystem: %w", err)
+		}
+	}
+	if err = srv.mountRoots(); err != nil {
+		return fmt.Errorf("finding the roots' filesystems: %w", err)
+	}
+	if srv.FSTimeout != 0 {
+		srv.FS = timeoutFS(srv.FS, srv.FSTimeout, srv.Logger.WithNames("fs"))
+	}
+	srv.liveFS = srv.FS
+	if srv.Library != nil {
+		srv.Library.setLogger(srv.Logger.WithNames("library"))
+		srv.FS = srv.Library.FS(srv.FS)
+	}
+	if srv.Torrents != nil {
+		srv.FS = mountFS(srv.FS, torrentsFolder, srv.Torrents)
+	}
+	if srv.recordingEnabled() {
+		srv.FS = mountFS(srv.FS, recordingsFolder, os.DirFS(srv.RecordingsDir))
+	}
+	srv.friendlyNameData = friendlyNameData(RootPath(srv.RootObjectPath))
 	srv.RootObjectPath = "./"
 	srv.eventingLogger = srv.Logger.WithNames("eventing")
 	srv.eventingLogger.Levelf(log.Debug, "hello %v", "world")
@@ -965,6 +1741,8 @@ func (srv *Server) Init() (err error) {
 	srv.closed = make(chan struct{})
 	if srv.FriendlyName == "" {
 		srv.FriendlyName = getDefaultFriendlyName()
+	} else if srv.FriendlyName, err = expandFriendlyName(srv.FriendlyName, srv.friendlyNameData); err != nil {
+		return fmt.Errorf("expanding FriendlyName: %w", err)
 	}
 	if srv.HTTPConn == nil {
 		srv.HTTPConn, err = net.Listen("tcp", "")
@@ -986,56 +1764,47 @@ func (srv *Server) Init() (err error) {
 		}
 		srv.Interfaces = tmp
 	}
-	if srv.FFProbeCache == nil {
-		srv.FFProbeCache = dummyFFProbeCache{}
+	srv.initCaches()
+	if len(srv.Icons) == 0 && srv.IconSource != nil {
+		if srv.Icons, err = GenerateIcons(srv.IconSource); err != nil {
+			return fmt.Errorf("generating icons: %w", err)
+		}
+	}
+	if err = checkVendorXML(srv.VendorXML); err != nil {
+		return
 	}
 	srv.httpServeMux = http.NewServeMux()
 	srv.rootDeviceUUID = makeDeviceUuid(srv.FriendlyName)
-	srv.rootDescXML, err = xml.MarshalIndent(
-		upnp.DeviceDesc{
-			NSDLNA:      "urn:schemas-dlna-org:device-1-0",
-			NSSEC:       "http://www.sec.co.kr/dlna",
-			SpecVersion: upnp.SpecVersion{Major: 1, Minor: 0},
-			Device: upnp.Device{
-				DeviceType:   rootDeviceType,
-				FriendlyName: srv.FriendlyName,
-				Manufacturer: "Matt Joiner <anacrolix@gmail.com>",
-				ModelName:    rootDeviceModelName,
-				UDN:          srv.rootDeviceUUID,
-				VendorXML: `
-     <dlna:X_DLNACAP/>
-     <dlna:X_DLNADOC>DMS-1.50</dlna:X_DLNADOC>
-     <dlna:X_DLNADOC>M-DMS-1.50</dlna:X_DLNADOC>
-     <sec:ProductCap>smi,DCM10,getMediaInfo.sec,getCaptionInfo.sec</sec:ProductCap>
-     <sec:X_ProductCap>smi,DCM10,getMediaInfo.sec,getCaptionInfo.sec</sec:X_ProductCap>`,
-				ServiceList: func() (ss []upnp.Service) {
-					for _, s := range services {
-						ss = append(ss, s.Service)
-					}
-					return
-				}(),
-				IconList: func() (ret []upnp.Icon) {
-					for i, di := range srv.Icons {
-						ret = append(ret, upnp.Icon{
-							Height:   di.Height,
-							Width:    di.Width,
-							Depth:    di.Depth,
-							Mimetype: di.Mimetype,
-							URL:      fmt.Sprintf("%s/%d", deviceIconPath, i),
-						})
-					}
-					return
-				}(),
-				PresentationURL: "/",
-			},
-		},
-		" ", "  ")
+	srv.rootDescXML, err = srv.makeRootDesc()
 	if err != nil {
 		return
 	}
-	srv.rootDescXML = append([]byte(`<?xml version="1.0"?>`), srv.rootDescXML...)
 	srv.Logger.Println("HTTP srv on", srv.HTTPConn.Addr())
 	srv.initMux(srv.httpServeMux)
+	srv.httpServer = srv.newHTTPServer()
+	if srv.RemoteConn != nil {
+		if srv.RemoteTLSConfig == nil || srv.RemotePassword == "" {
+			return errors.New("remote access needs RemoteTLSConfig and RemotePassword")
+		}
+		srv.remoteServer = &http.Server{
+			Handler:     srv.remoteHandler(srv.httpServer.Handler),
+			TLSConfig:   srv.RemoteTLSConfig,
+			ConnContext: withNetConn,
+		}
+		srv.Logger.Println("remote access on", srv.RemoteConn.Addr())
+	}
+	if srv.HTTPSConn != nil {
+		if srv.HTTPSTLSConfig == nil {
+			return errors.New("HTTPSConn needs HTTPSTLSConfig")
+		}
+		srv.httpsServer = &http.Server{
+			Handler:     srv.httpServer.Handler,
+			TLSConfig:   srv.HTTPSTLSConfig,
+			ConnContext: withNetConn,
+		}
+		srv.Logger.Println("HTTPS srv on", srv.HTTPSConn.Addr())
+	}
+	srv.started = time.Now()
 	srv.ssdpStopped = make(chan struct{})
 	return nil
 }
@@ -1055,27 +1824,122 @@ func (srv *Server) Run() (err error) {
 		srv.doSSDP()
 		close(srv.ssdpStopped)
 	}()
+	go srv.maintainLibrary()
+	go srv.scanMimeTypes()
+	if srv.Watch {
+		go srv.watchFolders()
+	}
+	if !srv.NoProbe && (srv.WarmUpRecent > 0 || len(srv.WarmUpPaths) != 0) {
+		go srv.warmUp()
+	}
+	if srv.TranscodeLogMaxAge != 0 || srv.TranscodeLogMaxSize != 0 {
+		go srv.pruneTranscodeLogs()
+	}
+	if srv.recordingEnabled() {
+		go srv.scheduleRecordings()
+	}
+	if srv.httpsServer != nil {
+		go func() {
+			if err := srv.serveHTTPS(); err != nil {
+				srv.Logger.Printf("serving HTTPS: %v", err)
+			}
+		}()
+	}
+	if srv.remoteServer != nil {
+		go func() {
+			if err := srv.serveRemote(); err != nil {
+				srv.Logger.Printf("serving remote access: %v", err)
+			}
+		}()
+		if srv.RemotePortMapping {
+			srv.portMappingStopped = make(chan struct{})
+			go srv.maintainPortMapping()
+		}
+	}
 	return srv.serveHTTP()
 }
 
 func (srv *Server) Close() (err error) {
 	close(srv.closed)
 	err = srv.HTTPConn.Close()
+	if srv.httpsServer != nil {
+		srv.httpsServer.Close()
+	}
+	if srv.remoteServer != nil {
+		srv.remoteServer.Close()
+	}
 	<-srv.ssdpStopped
+	srv.waitPortMapping()
 	return
 }
 
-func didl_lite(chardata string) string {
-	return `<DIDL-Lite` +
-		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
-		` xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/"` +
-		` xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"` +
-		` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">` +
-		chardata +
-		`</DIDL-Lite>`
+// Like Close, but requests being served, such as streams, are given until the
+// context is done to finish, and then cut off.
+func (srv *Server) Shutdown(ctx context.Context) (err error) {
+	close(srv.closed)
+	err = srv.httpServer.Shutdown(ctx)
+	if err != nil {
+		srv.httpServer.Close()
+	}
+	if srv.httpsServer != nil {
+		if srv.httpsServer.Shutdown(ctx) != nil {
+			srv.httpsServer.Close()
+		}
+	}
+	if srv.remoteServer != nil {
+		if srv.remoteServer.Shutdown(ctx) != nil {
+			srv.remoteServer.Close()
+		}
+	}
+	<-srv.ssdpStopped
+	srv.waitPortMapping()
+	return
+}
+
+// Returns a context that's done when the server is closed, for work that
+// isn't on behalf of a request, or that outlives one.
+func (srv *Server) closedContext() context.Context {
+	return closedContext(srv.closed)
+}
+
+// A context that's cancelled when the channel is closed.
+type closedContext <-chan struct{}
+
+func (closedContext) Deadline() (time.Time, bool)   { return time.Time{}, false }
+func (me closedContext) Done() <-chan struct{}      { return me }
+func (closedContext) Value(interface{}) interface{} { return nil }
+
+func (me closedContext) Err() error {
+	select {
+	case <-me:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+// Returns the host and port the client reached the server at, for URLs
+// given back to it, as url.URL's Host wants them: IPv6 literals bracketed,
+// and zones unescaped. If the request has no usable Host, it's the local
+// address the request came in on.
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		u, err := url.Parse("http://" + r.Host)
+		if err == nil && u.Host != "" && u.User == nil && u.Path == "" && u.RawQuery == "" && u.Fragment == "" {
+			return u.Host
+		}
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		// The zone is the server's, which means nothing to the client.
+		return (&net.TCPAddr{IP: addr.IP, Port: addr.Port}).String()
+	}
+	return r.Host
 }
 
-func (me *Server) location(ip net.IP) string {
+// Returns the device description's URL at the IP, for SSDP. It's the HTTPS
+// one if it's advertised, or the client searching with the User-Agent asks
+// for it.
+func (me *Server) location(ip net.IP, userAgent string) string {
 	url := url.URL{
 		Scheme: "http",
 		Host: (&net.TCPAddr{
@@ -1084,11 +1948,59 @@ func (me *Server) location(ip net.IP) string {
 		}).String(),
 		Path: rootDescPath,
 	}
+	if p := me.clientProfile(userAgent); me.HTTPSConn != nil && (me.AdvertiseHTTPS || userAgent != "" && p != nil && p.HTTPS) {
+		url.Scheme = "https"
+		url.Host = (&net.TCPAddr{IP: ip, Port: me.HTTPSConn.Addr().(*net.TCPAddr).Port}).String()
+	}
 	return url.String()
 }
 
-// Can return nil info with nil err if an earlier Probe gave an error.
-func (srv *Server) ffmpegProbe(path string) (info *ffprobe.Info, err error) {
+// Returns the scheme of URLs given to a client that reached the server at the
+// host: https if it's at the HTTPS listener's port.
+func (me *Server) urlScheme(host string) string {
+	if me.HTTPSConn != nil {
+		_, port, err := net.SplitHostPort(host)
+		if err == nil && port == strconv.Itoa(me.HTTPSConn.Addr().(*net.TCPAddr).Port) {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+// Returns the host and port the server can reach itself at: loopback of the
+// listener's family, or the address it listens on if it's a particular one.
+func (srv *Server) loopbackHost() string {
+	addr := srv.HTTPConn.Addr().(*net.TCPAddr)
+	ip := addr.IP
+	switch {
+	case ip == nil || ip.Equal(net.IPv4zero):
+		ip = net.IPv4(127, 0, 0, 1)
+	case ip.Equal(net.IPv6unspecified):
+		ip = net.IPv6loopback
+	}
+	return (&net.TCPAddr{IP: ip, Port: addr.Port, Zone: addr.Zone}).String()
+}
+
+// Returns a URL from which external commands such as ffmpeg can read the raw
+// file at path in the server's FS.
+func (srv *Server) loopbackResURL(path string) string {
+	return (&url.URL{
+		Scheme: "http",
+		Host:   srv.loopbackHost(),
+		Path:   resPath,
+		RawQuery: url.Values{
+			"path":           {path},
+			loopbackQueryKey: {srv.loopbackToken(path)},
+		}.Encode(),
+	}).String()
+}
+
+// Can return nil info with nil err if an earlier Probe gave an error. Failures
+// are cached like results, as nil info keyed by the path and modification
+// time, so broken or unsupported files aren't probed again on every browse
+// until they change. A missing ffprobe says nothing about the file, and a
+// probe cut short by the context says nothing either, so neither is cached.
+func (srv *Server) ffmpegProbe(ctx context.Context, path string) (info *ffprobe.Info, err error) {
 	fi, err := fs.Stat(srv.FS, path)
 	if err != nil {
 		return
@@ -1096,43 +2008,61 @@ func (srv *Server) ffmpegProbe(path string) (info *ffprobe.Info, err error) {
 	key := ffmpegInfoCacheKey{path, fi.ModTime().UnixNano()}
 	value, ok := srv.FFProbeCache.Get(key)
 	if !ok {
-		uri := fmt.Sprintf("http://127.0.0.1:%d%s?path=%s", srv.httpPort(), resPath, path)
-		info, err = ffprobe.Run(uri)
+		var span trace.Span
+		ctx, span = srv.startSpan(ctx, "ffprobe", attribute.String("file.path", path))
+		info, err = transcode.Probe(ctx, srv.loopbackResURL(path))
 		err = suppressFFmpegProbeDataErrors(err)
+		endSpan(span, err)
+		if err == ffprobe.ExeNotFound || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			info = nil
+		}
 		srv.FFProbeCache.Set(key, info)
 		return
 	}
-	info = value.(*ffprobe.Info)
+	info, _ = value.(*ffprobe.Info)
 	return
 }
 
 // IgnorePath detects if a file/directory should be ignored.
 func (server *Server) IgnorePath(path string) (bool, error) {
+	reason, err := server.ignoreReason(path)
+	if err != nil {
+		return false, err
+	}
+	if reason == "" {
+		return false, nil
+	}
+	log.Print(path, " ignored: ", reason)
+	return true, nil
+}
+
+// Returns why a file/directory is ignored, or "" if it isn't.
+func (server *Server) ignoreReason(path string) (string, error) {
 	if server.IgnoreHidden {
 		if hidden, err := isHiddenPath(server.FS, path); err != nil {
-			return false, err
+			return "", err
 		} else if hidden {
-			log.Print(path, " ignored: hidden")
-			return true, nil
+			return "hidden", nil
 		}
 	}
 	if server.IgnoreUnreadable {
 		if readable, err := isReadablePath(server.FS, path); err != nil {
-			return false, err
+			return "", err
 		} else if !readable {
-			log.Print(path, " ignored: unreadable")
-			return true, nil
+			return "unreadable", nil
 		}
 	}
 
 	for _, element := range server.IgnorePaths {
-		if strings.Contains(path, fmt.Sprintf("/%s/", element)) {
-			log.Print(path, " ignored: in ignore list")
-			return true, nil
+		if strings.Contains(path, fmt.Sprintf("/%s/", filepath.ToSlash(element))) {
+			return "in ignore list", nil
 		}
 	}
 
-	return false, nil
+	return "", nil
 }
 
 func isReadablePath(fsys fs.FS, path string) (bool, error) {
@@ -3,10 +3,17 @@
 
 package dms
 
-func isHiddenPath(path string) (bool, error) {
+import "io/fs"
+
+func isHiddenPath(fsys fs.FS, path string) (bool, error) {
 	return false, nil
 }
 
-func isReadablePath(path string) (bool, error) {
-	return tryToOpenPath(path)
+func fileDevice(fi fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// Returns the path of a media root as os.DirFS should have it.
+func RootPath(root string) string {
+	return root
 }
@@ -2,9 +2,22 @@ package dms
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
 )
 
 type safeFilePathTestCase struct {
@@ -14,19 +27,19 @@ type safeFilePathTestCase struct {
 func TestSafeFilePath(t *testing.T) {
 	var cases []safeFilePathTestCase
 	if runtime.GOOS == "windows" {
+		// FS paths, which are slash separated.
 		cases = []safeFilePathTestCase{
-			{"c:", "/", "c:\\"},
-			{"c:", "/test", "c:\\test"},
-			{"c:\\", "/", "c:\\"},
-			{"c:\\", "/test", "c:\\test"},
-			{"c:\\hello", "../windows", "c:\\windows"},
-			{"c:\\hello", "/../windows", "c:\\hello\\windows"},
-			{"c:\\hello", "/", "c:\\hello"},
-			{"c:\\hello", "./world", "c:\\hello\\world"},
-			{"c:\\hello", "/", "c:\\hello"},
+			{"./", "/", "."},
+			{"./", "/Music\\Album", "Music/Album"},
+			{"./", "\\..\\windows", "windows"},
+			{"c:\\", "/test", "c:/test"},
+			{"c:\\hello", "../windows", "c:/windows"},
+			{"c:\\hello", "/../windows", "c:/hello/windows"},
+			{"c:\\hello", "/", "c:/hello"},
+			{"c:\\hello", "./world", "c:/hello/world"},
 			// These two ones are invalid but, as this actually prevents to serve them, it is fine
-			{"c:\\foo", "c:/windows/", "c:\\foo\\c:\\windows"},
-			{"c:\\foo", "e:/", "c:\\foo\\e:"},
+			{"c:\\foo", "c:/windows/", "c:/foo/c:/windows"},
+			{"c:\\foo", "e:/", "c:/foo/e:"},
 		}
 	} else {
 		cases = []safeFilePathTestCase{
@@ -64,3 +77,238 @@ func TestResponse(t *testing.T) {
 	resp.Write(&buf)
 	t.Logf("%q", buf.String())
 }
+
+// Records whether a body was read from an *os.File, as net/http needs to use
+// sendfile.
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	fromFile bool
+}
+
+func (me *readFromRecorder) ReadFrom(r io.Reader) (int64, error) {
+	src := r
+	if lr, ok := r.(*io.LimitedReader); ok {
+		src = lr.R
+	}
+	_, me.fromFile = src.(*os.File)
+	return io.Copy(me.ResponseRecorder, r)
+}
+
+func TestServeFileSendfile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.mp3"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{FS: os.DirFS(dir)}
+	lib, err := OpenLibrary(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Close()
+	s.FS = lib.FS(s.FS)
+	for _, tracked := range []bool{false, true} {
+		r := httptest.NewRequest("GET", "/res?path=a.mp3", nil)
+		r.Header.Set("Range", "bytes=2-5")
+		w := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+		cw := &countingResponseWriter{ResponseWriter: w}
+		if tracked {
+			s.serveFile(cw, r, "a.mp3")
+		} else {
+			s.serveFile(w, r, "a.mp3")
+		}
+		if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+			t.Errorf("got %d %q", w.Code, w.Body.String())
+		}
+		if !w.fromFile {
+			t.Errorf("tracked %v: body wasn't read from the file", tracked)
+		}
+		if tracked && cw.n != 4 {
+			t.Errorf("counted %d bytes", cw.n)
+		}
+	}
+}
+
+func TestClosedContext(t *testing.T) {
+	srv := &Server{closed: make(chan struct{})}
+	ctx := srv.closedContext()
+	if ctx.Err() != nil {
+		t.Fatal(ctx.Err())
+	}
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	close(srv.closed)
+	<-child.Done()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("got %v", ctx.Err())
+	}
+}
+
+func TestRequestHost(t *testing.T) {
+	local := &net.TCPAddr{IP: net.ParseIP("fe80::2"), Port: 1338, Zone: "eth0"}
+	for host, want := range map[string]string{
+		"192.168.1.2:1338":      "192.168.1.2:1338",
+		"[2001:db8::2]:1338":    "[2001:db8::2]:1338",
+		"[fe80::2%25wlan]:1338": "[fe80::2%wlan]:1338",
+		"media.lan:1338":        "media.lan:1338",
+		"":                      "[fe80::2]:1338",
+		"a/b":                   "[fe80::2]:1338",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = host
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+		got := requestHost(r)
+		if got != want {
+			t.Errorf("%q: got %q, want %q", host, got, want)
+		}
+		// It's the form url.URL wants.
+		u, err := url.Parse((&url.URL{Scheme: "http", Host: got}).String())
+		if err != nil || u.Host != got {
+			t.Errorf("%q: %q doesn't round trip: %v, %v", host, got, u, err)
+		}
+	}
+}
+
+func TestLoopbackHost(t *testing.T) {
+	for addr, want := range map[string]string{
+		"0.0.0.0:1338":     "127.0.0.1:1338",
+		"[::]:1338":        "[::1]:1338",
+		"192.168.1.2:1338": "192.168.1.2:1338",
+		"[::1]:1338":       "[::1]:1338",
+	} {
+		a, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Server{HTTPConn: fakeListener{a}}
+		if got := s.loopbackHost(); got != want {
+			t.Errorf("%s: got %q, want %q", addr, got, want)
+		}
+	}
+}
+
+type fakeListener struct {
+	addr net.Addr
+}
+
+func (fakeListener) Accept() (net.Conn, error) { return nil, net.ErrClosed }
+func (fakeListener) Close() error              { return nil }
+func (me fakeListener) Addr() net.Addr         { return me.addr }
+
+func TestAllowedIpNets(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+	s := &Server{
+		FS:             fstest.MapFS{"a.mp3": {Data: []byte("mp3")}},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		AllowedIpNets:  []*net.IPNet{lan},
+		RemoteConn:     fakeListener{&net.TCPAddr{Port: 1339}},
+		RemoteUser:     "dms",
+		RemotePassword: "secret",
+	}
+	if err := s.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	s.httpServeMux = http.NewServeMux()
+	s.initMux(s.httpServeMux)
+	h := s.newHTTPServer().Handler
+	do := func(target, remoteAddr string, remote bool) int {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = remoteAddr
+		h := h
+		if remote {
+			r.TLS = &tls.ConnectionState{}
+			r.SetBasicAuth("dms", "secret")
+			h = s.remoteHandler(h)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, target := range []string{rootDescPath, resPath + "?path=a.mp3", iconPath, subtitlePath, "/debug/pprof/", "/"} {
+		if code := do(target, "192.168.2.9:5000", false); code != http.StatusForbidden {
+			t.Errorf("%s from outside got %d", target, code)
+		}
+		if code := do(target, "192.168.1.9:5000", false); code == http.StatusForbidden {
+			t.Errorf("%s from the LAN got %d", target, code)
+		}
+		if code := do(target, "127.0.0.1:5000", false); code != http.StatusForbidden {
+			t.Errorf("%s from the server's host got %d", target, code)
+		}
+	}
+	if code := do(resPath+"?path=a.mp3&"+loopbackQueryKey+"="+s.loopbackToken("a.mp3"), "127.0.0.1:5000", false); code != http.StatusOK {
+		t.Errorf("the server's own request got %d", code)
+	}
+	// The token is only good from the server's host.
+	if code := do(resPath+"?path=a.mp3&"+loopbackQueryKey+"="+s.loopbackToken("a.mp3"), "192.168.2.9:5000", false); code != http.StatusForbidden {
+		t.Errorf("a loopback token from outside got %d", code)
+	}
+	if code := do("/", "203.0.113.9:5000", true); code != http.StatusOK {
+		t.Errorf("remote client with credentials got %d", code)
+	}
+	// Credentials are only good on RemoteConn, not the plain HTTPS listener.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.9:5000"
+	r.TLS = &tls.ConnectionState{}
+	r.SetBasicAuth("dms", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("credentials over the HTTPS listener got %d", w.Code)
+	}
+}
+
+func TestHTTPS(t *testing.T) {
+	// For its certificate, and a client trusting it.
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	srv := &Server{
+		FS:             fstest.MapFS{},
+		HTTPConn:       l,
+		HTTPSConn:      tl,
+		HTTPSTLSConfig: &tls.Config{Certificates: ts.TLS.Certificates},
+		Interfaces:     []net.Interface{},
+		NoProbe:        true,
+		AllowedIpNets:  []*net.IPNet{all},
+		ClientProfiles: []ClientProfile{{UserAgent: "SecureTV", HTTPS: true}},
+		Logger:         log.Default,
+	}
+	if err := srv.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+	resp, err := ts.Client().Get("https://" + tl.Addr().String() + rootDescPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got %s", resp.Status)
+	}
+	if got := srv.urlScheme(tl.Addr().String()); got != "https" {
+		t.Errorf("got scheme %q at the HTTPS port", got)
+	}
+	if got := srv.urlScheme(l.Addr().String()); got != "http" {
+		t.Errorf("got scheme %q at the HTTP port", got)
+	}
+	ip := net.IPv4(192, 168, 1, 2)
+	if got, want := srv.location(ip, "SecureTV/1.0"), "https://192.168.1.2:"+strconv.Itoa(tl.Addr().(*net.TCPAddr).Port)+rootDescPath; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, ua := range []string{"", "OtherTV"} {
+		if got := srv.location(ip, ua); !strings.HasPrefix(got, "http://") {
+			t.Errorf("%q: got %q", ua, got)
+		}
+	}
+}
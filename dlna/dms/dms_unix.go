@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 func isHiddenPath(fsys fs.FS, path string) (bool, error) {
@@ -20,3 +21,17 @@ func isHiddenPath(fsys fs.FS, path string) (bool, error) {
 
 	return isHiddenPath(fsys, filepath.Dir(path))
 }
+
+// Returns the device the file is on, to tell mounts apart.
+func fileDevice(fi fs.FileInfo) (uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// Returns the path of a media root as os.DirFS should have it.
+func RootPath(root string) string {
+	return root
+}
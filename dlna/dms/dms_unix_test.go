@@ -3,7 +3,12 @@
 
 package dms
 
-import "testing"
+import (
+	"io/fs"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
 
 func TestIsHiddenPath(t *testing.T) {
 	data := map[string]bool{
@@ -21,3 +26,26 @@ func TestIsHiddenPath(t *testing.T) {
 		}
 	}
 }
+
+func TestOneFileSystemFS(t *testing.T) {
+	root, mount := &syscall.Stat_t{Dev: 1}, &syscall.Stat_t{Dev: 2}
+	fsys, err := oneFileSystemFS(fstest.MapFS{
+		".":              {Mode: fs.ModeDir, Sys: root},
+		"Films":          {Mode: fs.ModeDir, Sys: root},
+		"Films/Heat.mkv": {Sys: root},
+		"NAS":            {Mode: fs.ModeDir, Sys: mount},
+		"NAS/Ran.mkv":    {Sys: mount},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "Films/Heat.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(fsys, "NAS/Ran.mkv"); err == nil {
+		t.Error("crossed into the mount")
+	}
+	if entries, _ := fs.ReadDir(fsys, "."); len(entries) != 1 {
+		t.Errorf("got %v", entries)
+	}
+}
@@ -5,7 +5,9 @@ package dms
 
 import (
 	"io/fs"
+	"path"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"golang.org/x/sys/windows"
@@ -13,27 +15,47 @@ import (
 
 const hiddenAttributes = windows.FILE_ATTRIBUTE_HIDDEN | windows.FILE_ATTRIBUTE_SYSTEM
 
-func isHiddenPath(fsys fs.FS, path string) (hidden bool, err error) {
-	if path == "." {
+// Reports whether the file or one of the folders it's in has the hidden or
+// system attribute, as Explorer hides them.
+func isHiddenPath(fsys fs.FS, name string) (hidden bool, err error) {
+	if name == "." {
 		return false, nil
 	}
-	f, err := fsys.Open(path)
-	if err != nil {
-		return false, err
-	}
-	defer f.Close()
-	fi, err := f.Stat()
+	fi, err := fs.Stat(fsys, name)
 	if err != nil {
 		return false, err
 	}
 	// Extract the Win32FileAttributeData from Sys()
 	sys, ok := fi.Sys().(*syscall.Win32FileAttributeData)
-	if !ok {
-		return false, nil // Not a Windows file system? Default to non-hidden.
-	}
-	if (sys.FileAttributes & hiddenAttributes) != 0 {
+	if ok && sys.FileAttributes&hiddenAttributes != 0 {
 		return true, nil
 	}
+	// FS paths are slash separated, whatever the OS.
+	return isHiddenPath(fsys, path.Dir(name))
+}
 
-	return isHiddenPath(fsys, filepath.ToSlash(filepath.Dir(path)))
+// Returns the device the file is on, to tell mounts apart. Windows doesn't
+// say.
+func fileDevice(fi fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// Returns the path of a media root as os.DirFS should have it. A \\?\ prefix
+// is removed, as DirFS joins names to the root with forward slashes, which
+// Windows then doesn't take as separators; Go adds the prefix itself to long
+// paths as it opens them. A drive letter alone, which is the drive's current
+// directory, is taken to mean the drive's root. UNC shares, \\host\share, are
+// used as they are.
+func RootPath(root string) string {
+	switch {
+	case strings.HasPrefix(root, `\\?\UNC\`):
+		root = `\\` + root[len(`\\?\UNC\`):]
+	case strings.HasPrefix(root, `\\?\`):
+		root = root[len(`\\?\`):]
+	}
+	vol := filepath.VolumeName(root)
+	if rest := root[len(vol):]; vol != "" && (rest == "" || rest == ".") {
+		return vol + `\`
+	}
+	return root
 }
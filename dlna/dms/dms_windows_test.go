@@ -0,0 +1,57 @@
+//go:build windows
+// +build windows
+
+package dms
+
+import (
+	"io/fs"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestIsHiddenPath(t *testing.T) {
+	attrs := func(a uint32) *syscall.Win32FileAttributeData {
+		return &syscall.Win32FileAttributeData{FileAttributes: a}
+	}
+	fsys := fstest.MapFS{
+		"Music":               {Mode: fs.ModeDir, Sys: attrs(windows.FILE_ATTRIBUTE_DIRECTORY)},
+		"Music/a.mp3":         {Sys: attrs(windows.FILE_ATTRIBUTE_ARCHIVE)},
+		"Music/.b.mp3":        {Sys: attrs(windows.FILE_ATTRIBUTE_ARCHIVE)},
+		"Music/desktop.ini":   {Sys: attrs(windows.FILE_ATTRIBUTE_HIDDEN | windows.FILE_ATTRIBUTE_SYSTEM)},
+		"$RECYCLE.BIN":        {Mode: fs.ModeDir, Sys: attrs(windows.FILE_ATTRIBUTE_SYSTEM)},
+		"$RECYCLE.BIN/c.mp3":  {Sys: attrs(windows.FILE_ATTRIBUTE_ARCHIVE)},
+		"Private":             {Mode: fs.ModeDir, Sys: attrs(windows.FILE_ATTRIBUTE_HIDDEN)},
+		"Private/Films/d.mkv": {},
+	}
+	for path, expected := range map[string]bool{
+		"Music/a.mp3":         false,
+		"Music/.b.mp3":        false,
+		"Music/desktop.ini":   true,
+		"$RECYCLE.BIN/c.mp3":  true,
+		"Private/Films/d.mkv": true,
+	} {
+		if actual, err := isHiddenPath(fsys, path); err != nil {
+			t.Errorf("isHiddenPath(%v) returned unexpected error: %s", path, err)
+		} else if expected != actual {
+			t.Errorf("isHiddenPath(%v), expected %v, got %v", path, expected, actual)
+		}
+	}
+}
+
+func TestRootPath(t *testing.T) {
+	for root, expected := range map[string]string{
+		`D:`:                      `D:\`,
+		`D:.`:                     `D:\`,
+		`D:\Media`:                `D:\Media`,
+		`\\?\D:\Media`:            `D:\Media`,
+		`\\nas\media`:             `\\nas\media`,
+		`\\?\UNC\nas\media\Films`: `\\nas\media\Films`,
+	} {
+		if actual := RootPath(root); actual != expected {
+			t.Errorf("RootPath(%q), expected %q, got %q", root, expected, actual)
+		}
+	}
+}
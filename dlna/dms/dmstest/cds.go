@@ -0,0 +1,91 @@
+package dmstest
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+)
+
+// A resource of a DIDL-Lite item.
+type Res struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         uint64 `xml:"size,attr"`
+	Duration     string `xml:"duration,attr"`
+	Resolution   string `xml:"resolution,attr"`
+	URL          string `xml:",chardata"`
+}
+
+// A DIDL-Lite container or item. Elements are matched by local name, whatever
+// their namespace.
+type Object struct {
+	ID          string `xml:"id,attr"`
+	ParentID    string `xml:"parentID,attr"`
+	ChildCount  int    `xml:"childCount,attr"`
+	Title       string `xml:"title"`
+	Class       string `xml:"class"`
+	AlbumArtURI string `xml:"albumArtURI"`
+	Res         []Res  `xml:"res"`
+}
+
+type DIDLLite struct {
+	Containers []Object `xml:"container"`
+	Items      []Object `xml:"item"`
+}
+
+// The result of a Browse or Search action.
+type BrowseResult struct {
+	// The Result argument as given.
+	DIDL           string
+	Objects        DIDLLite
+	NumberReturned int
+	TotalMatches   int
+	UpdateID       string
+}
+
+// Browses the ContentDirectory. The flag is "BrowseDirectChildren" or
+// "BrowseMetadata". A count of zero requests everything from start.
+func (me *Device) Browse(ctx context.Context, objectID, flag string, start, count int) (*BrowseResult, error) {
+	out, err := me.Action(ctx, "ContentDirectory", "Browse", [][2]string{
+		{"ObjectID", objectID},
+		{"BrowseFlag", flag},
+		{"Filter", "*"},
+		{"StartingIndex", strconv.Itoa(start)},
+		{"RequestedCount", strconv.Itoa(count)},
+		{"SortCriteria", ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseBrowseResult(out)
+}
+
+// Searches the ContentDirectory container with UPnP search criteria.
+func (me *Device) Search(ctx context.Context, containerID, criteria string, start, count int) (*BrowseResult, error) {
+	out, err := me.Action(ctx, "ContentDirectory", "Search", [][2]string{
+		{"ContainerID", containerID},
+		{"SearchCriteria", criteria},
+		{"Filter", "*"},
+		{"StartingIndex", strconv.Itoa(start)},
+		{"RequestedCount", strconv.Itoa(count)},
+		{"SortCriteria", ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseBrowseResult(out)
+}
+
+func parseBrowseResult(out map[string]string) (ret *BrowseResult, err error) {
+	ret = &BrowseResult{
+		DIDL:     out["Result"],
+		UpdateID: out["UpdateID"],
+	}
+	if err = xml.Unmarshal([]byte(ret.DIDL), &ret.Objects); err != nil {
+		return
+	}
+	if ret.NumberReturned, err = strconv.Atoi(out["NumberReturned"]); err != nil {
+		return
+	}
+	ret.TotalMatches, err = strconv.Atoi(out["TotalMatches"])
+	return
+}
@@ -0,0 +1,85 @@
+package dmstest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/anacrolix/dms/ssdp"
+)
+
+// A response to an SSDP search.
+type SearchResponse struct {
+	// The URL of the root device description, for OpenDevice.
+	Location string
+	ST       string
+	USN      string
+	Server   string
+}
+
+// Returns the M-SEARCH request for the search target, such as
+// "urn:schemas-upnp-org:device:MediaServer:1" or "ssdp:all".
+func searchRequest(st string) []byte {
+	return []byte(fmt.Sprintf("M-SEARCH * HTTP/1.1\r\n"+
+		"HOST: %s\r\n"+
+		"MAN: \"ssdp:discover\"\r\n"+
+		"MX: 1\r\n"+
+		"ST: %s\r\n\r\n", ssdp.AddrString, st))
+}
+
+func parseSearchResponse(b []byte) (ret SearchResponse, err error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("search response: %s", resp.Status)
+		return
+	}
+	ret = SearchResponse{
+		Location: resp.Header.Get("LOCATION"),
+		ST:       resp.Header.Get("ST"),
+		USN:      resp.Header.Get("USN"),
+		Server:   resp.Header.Get("SERVER"),
+	}
+	if ret.Location == "" {
+		err = errors.New("search response without LOCATION")
+	}
+	return
+}
+
+// Multicasts an SSDP search for the target on the IPv4 group, and returns
+// the responses received until the context is done.
+func Discover(ctx context.Context, st string) (ret []SearchResponse, err error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.WriteToUDP(searchRequest(st), ssdp.NetAddr); err != nil {
+		return
+	}
+	b := make([]byte, 65536)
+	for ctx.Err() == nil {
+		// Wake up now and then to check the context.
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFromUDP(b)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return ret, err
+		}
+		if resp, err := parseSearchResponse(b[:n]); err == nil {
+			ret = append(ret, resp)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,219 @@
+// Package dmstest is a minimal DLNA control point and renderer, for end-to-end
+// tests of media servers without real devices. It discovers servers, reads
+// their descriptions, invokes ContentDirectory actions, subscribes to events
+// and streams resources as a renderer would.
+package dmstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/soap"
+	"github.com/anacrolix/dms/upnp"
+)
+
+// Makes requests to media servers. The zero value is ready for use.
+type Client struct {
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Sent with every request, to exercise a server's client profiles.
+	UserAgent string
+}
+
+func (me *Client) do(req *http.Request) (*http.Response, error) {
+	if me.UserAgent != "" {
+		req.Header.Set("User-Agent", me.UserAgent)
+	}
+	c := me.HTTPClient
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return c.Do(req)
+}
+
+// Fetches a URL and decodes the XML in the response.
+func (me *Client) getXML(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := me.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// A media server, as described by its root device description.
+type Device struct {
+	client   *Client
+	Location *url.URL
+	Desc     upnp.DeviceDesc
+	// The service descriptions, keyed by service type, such as
+	// "ContentDirectory".
+	SCPDs map[string]upnp.SCPD
+}
+
+// Fetches the root device description at the location, as given in SSDP
+// responses, and the descriptions of its services.
+func (me *Client) OpenDevice(ctx context.Context, location string) (*Device, error) {
+	loc, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	d := &Device{
+		client:   me,
+		Location: loc,
+		SCPDs:    make(map[string]upnp.SCPD),
+	}
+	if err := me.getXML(ctx, location, &d.Desc); err != nil {
+		return nil, err
+	}
+	for _, s := range d.Desc.Device.ServiceList {
+		urn, err := upnp.ParseServiceType(s.ServiceType)
+		if err != nil {
+			return nil, err
+		}
+		var scpd upnp.SCPD
+		if err := me.getXML(ctx, d.resolve(s.SCPDURL), &scpd); err != nil {
+			return nil, err
+		}
+		d.SCPDs[urn.Type] = scpd
+	}
+	return d, nil
+}
+
+// Resolves a URL in the device description.
+func (me *Device) resolve(ref string) string {
+	u, err := me.Location.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+// Returns the service of the type, such as "ContentDirectory".
+func (me *Device) Service(serviceType string) (s upnp.Service, ok bool) {
+	for _, s := range me.Desc.Device.ServiceList {
+		if urn, err := upnp.ParseServiceType(s.ServiceType); err == nil && urn.Type == serviceType {
+			return s, true
+		}
+	}
+	return
+}
+
+// Invokes an action of the service, returning the output arguments by name.
+// UPnP errors from the device are returned as *upnp.Error.
+func (me *Device) Action(ctx context.Context, serviceType, action string, args [][2]string) (map[string]string, error) {
+	s, ok := me.Service(serviceType)
+	if !ok {
+		return nil, fmt.Errorf("no %s service", serviceType)
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<s:Envelope xmlns:s="%s" s:encodingStyle="%s"><s:Body><u:%s xmlns:u="%s">`,
+		soap.EnvelopeNS, soap.EncodingStyle, action, s.ServiceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, me.resolve(s.ControlURL), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPACTION", fmt.Sprintf(`"%s#%s"`, s.ServiceType, action))
+	resp, err := me.client.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var env soap.Envelope
+	if err := xml.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("%s: decoding response: %w", resp.Status, err)
+	}
+	var fault struct {
+		XMLName xml.Name
+		Code    uint   `xml:"detail>UPnPError>errorCode"`
+		Desc    string `xml:"detail>UPnPError>errorDescription"`
+	}
+	if err := xml.Unmarshal(env.Body.Action, &fault); err != nil {
+		return nil, err
+	}
+	if fault.XMLName.Local == "Fault" {
+		return nil, upnp.Errorf(fault.Code, "%s", fault.Desc)
+	}
+	if fault.XMLName.Local != action+"Response" {
+		return nil, fmt.Errorf("unexpected response %q", fault.XMLName.Local)
+	}
+	return childText(env.Body.Action, 1)
+}
+
+// Returns the text of the elements at the depth in the XML, by name.
+func childText(b []byte, depth int) (ret map[string]string, err error) {
+	ret = make(map[string]string)
+	d := xml.NewDecoder(bytes.NewReader(b))
+	var (
+		cur   int
+		name  string
+		value strings.Builder
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if cur == depth {
+				name = t.Name.Local
+				value.Reset()
+			}
+			cur++
+		case xml.CharData:
+			if cur == depth+1 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			cur--
+			if cur == depth {
+				ret[name] = value.String()
+			}
+		}
+	}
+}
+
+// Requests a resource as a renderer does, asking for the DLNA content
+// features. The byte range, like "bytes=0-99", and the DLNA time seek range,
+// like "npt=00:00:10.000-", are given if not empty.
+func (me *Client) Stream(ctx context.Context, url, byteRange, timeSeekRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(dlna.TransferModeDomain, "Streaming")
+	req.Header.Set("getContentFeatures.dlna.org", "1")
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	if timeSeekRange != "" {
+		req.Header.Set(dlna.TimeSeekRangeDomain, timeSeekRange)
+	}
+	return me.do(req)
+}
@@ -0,0 +1,51 @@
+package dmstest
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/anacrolix/dms/ssdp"
+)
+
+func TestSearchRequest(t *testing.T) {
+	req, err := ssdp.ReadRequest(bufio.NewReader(bytes.NewReader(searchRequest("ssdp:all"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` || req.Header.Get("ST") != "ssdp:all" || req.Header.Get("Host") != ssdp.AddrString {
+		t.Errorf("got %s %v", req.Method, req.Header)
+	}
+}
+
+func TestParseSearchResponse(t *testing.T) {
+	resp, err := parseSearchResponse([]byte("HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=75\r\n" +
+		"EXT: \r\n" +
+		"LOCATION: http://192.168.1.2:1338/rootDesc.xml\r\n" +
+		"SERVER: Linux/3.4 DLNADOC/1.50 UPnP/1.0 dms/1\r\n" +
+		"ST: urn:schemas-upnp-org:device:MediaServer:1\r\n" +
+		"USN: uuid:1::urn:schemas-upnp-org:device:MediaServer:1\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Location != "http://192.168.1.2:1338/rootDesc.xml" || resp.ST != "urn:schemas-upnp-org:device:MediaServer:1" || resp.USN == "" || resp.Server == "" {
+		t.Errorf("got %+v", resp)
+	}
+	if _, err := parseSearchResponse([]byte("HTTP/1.1 200 OK\r\nST: ssdp:all\r\n\r\n")); err == nil {
+		t.Error("no error without LOCATION")
+	}
+}
+
+func TestChildText(t *testing.T) {
+	got, err := childText([]byte(`<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0">`+
+		`<e:property><SystemUpdateID>7</SystemUpdateID></e:property>`+
+		`<e:property><ContainerUpdateIDs>0,3</ContainerUpdateIDs></e:property>`+
+		`</e:propertyset>`), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["SystemUpdateID"] != "7" || got["ContainerUpdateIDs"] != "0,3" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package dmstest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// A property change event received from a device.
+type Event struct {
+	SID string
+	Seq uint32
+	// The evented state variables, by name.
+	Properties map[string]string
+}
+
+// An event subscription, with a callback server receiving its events.
+type Subscription struct {
+	SID string
+	// In seconds, as granted by the device.
+	Timeout int
+	Events  <-chan Event
+	server  *http.Server
+}
+
+// Subscribes to the events of the service, such as "ContentDirectory". The
+// callback server listens on the address used to reach the device.
+func (me *Device) Subscribe(ctx context.Context, serviceType string) (*Subscription, error) {
+	s, ok := me.Service(serviceType)
+	if !ok {
+		return nil, fmt.Errorf("no %s service", serviceType)
+	}
+	// The local address routed to the device, which it can call back.
+	conn, err := net.Dial("udp", me.Location.Host)
+	if err != nil {
+		return nil, err
+	}
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+	l, err := net.Listen("tcp", net.JoinHostPort(localIP.String(), "0"))
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event, 16)
+	sub := &Subscription{
+		Events: events,
+		server: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "NOTIFY" {
+				http.Error(w, "expected NOTIFY", http.StatusMethodNotAllowed)
+				return
+			}
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				return
+			}
+			// Each property holds one variable, the grandchildren of the
+			// propertyset.
+			props, err := childText(b, 2)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			seq, _ := strconv.ParseUint(r.Header.Get("SEQ"), 10, 32)
+			select {
+			case events <- Event{r.Header.Get("SID"), uint32(seq), props}:
+			case <-r.Context().Done():
+			}
+		})},
+	}
+	go sub.server.Serve(l)
+	req, err := http.NewRequestWithContext(ctx, "SUBSCRIBE", me.resolve(s.EventSubURL), nil)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	req.Header.Set("CALLBACK", fmt.Sprintf("<http://%s/>", l.Addr()))
+	req.Header.Set("NT", "upnp:event")
+	req.Header.Set("TIMEOUT", "Second-1800")
+	resp, err := me.client.do(req)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		sub.Close()
+		return nil, fmt.Errorf("subscribing: %s", resp.Status)
+	}
+	sub.SID = resp.Header.Get("SID")
+	fmt.Sscanf(resp.Header.Get("TIMEOUT"), "Second-%d", &sub.Timeout)
+	return sub, nil
+}
+
+// Stops the callback server.
+func (me *Subscription) Close() error {
+	return me.server.Close()
+}
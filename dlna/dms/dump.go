@@ -0,0 +1,152 @@
+package dms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Formats for DumpTree.
+const (
+	DumpJSON = "json"
+	DumpDIDL = "didl"
+)
+
+// An object in the ContentDirectory tree, as written by DumpTree.
+type TreeNode struct {
+	ID         string
+	ParentID   string
+	Class      string
+	Title      string
+	Creator    string        `json:",omitempty"`
+	Artist     string        `json:",omitempty"`
+	Album      string        `json:",omitempty"`
+	Genre      string        `json:",omitempty"`
+	Date       string        `json:",omitempty"`
+	ChildCount int           `json:",omitempty"`
+	Res        []TreeNodeRes `json:",omitempty"`
+	Children   []*TreeNode   `json:",omitempty"`
+
+	// The synopsis and channel of recorded programmes.
+	Description string `json:",omitempty"`
+	ChannelName string `json:",omitempty"`
+}
+
+// A resource of an item in the tree.
+type TreeNodeRes struct {
+	URL          string
+	ProtocolInfo string
+	Size         uint64 `json:",omitempty"`
+	Bitrate      uint   `json:",omitempty"`
+	Duration     string `json:",omitempty"`
+	Resolution   string `json:",omitempty"`
+
+	SampleFrequency uint `json:",omitempty"`
+	NrAudioChannels uint `json:",omitempty"`
+	BitsPerSample   uint `json:",omitempty"`
+	ColorDepth      uint `json:",omitempty"`
+}
+
+func newTreeNode(o upnpav.Object) *TreeNode {
+	n := &TreeNode{
+		ID:       o.ID,
+		ParentID: o.ParentID,
+		Class:    o.Class,
+		Title:    o.Title,
+		Creator:  o.Creator,
+		Artist:   o.Artist,
+		Album:    o.Album,
+		Genre:    o.Genre,
+
+		Description: o.Description,
+		ChannelName: o.ChannelName,
+	}
+	if !o.Date.IsZero() {
+		n.Date = o.Date.Format("2006-01-02")
+	}
+	return n
+}
+
+// Walks the ContentDirectory as a client browsing every container would, and
+// writes what it sees: as a JSON tree of TreeNodes, or as a single DIDL-Lite
+// document listing every object. The res URLs refer to host, and userAgent
+// picks the client profile, so the output is what that client would see.
+func (me *Server) DumpTree(ctx context.Context, w io.Writer, format, host, userAgent string) error {
+	if format != DumpJSON && format != DumpDIDL {
+		return fmt.Errorf("unknown dump format %q", format)
+	}
+	cdService := &contentDirectoryService{Server: me}
+	root := &TreeNode{ID: "0", ParentID: "-1", Class: "object.container.storageFolder", Title: me.Branding().FriendlyName}
+	var all []interface{}
+	// Virtual containers can list items that are also in folders, but
+	// containers are only walked once.
+	seen := map[string]bool{"0": true}
+	var walk func(n *TreeNode) error
+	walk = func(n *TreeNode) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		obj, err := cdService.objectFromID(n.ID)
+		if err != nil {
+			return err
+		}
+		objs, err := cdService.browseChildren(ctx, n.ID, obj, host, userAgent, "")
+		if err != nil {
+			return fmt.Errorf("browsing %q: %w", n.ID, err)
+		}
+		for _, o := range objs {
+			var child *TreeNode
+			switch o := o.(type) {
+			case upnpav.Container:
+				child = newTreeNode(o.Object)
+				child.ChildCount = o.ChildCount
+			case upnpav.Item:
+				child = newTreeNode(o.Object)
+				for _, r := range o.Res {
+					child.Res = append(child.Res, TreeNodeRes{
+						URL:          r.URL,
+						ProtocolInfo: r.ProtocolInfo,
+						Size:         r.Size,
+						Bitrate:      r.Bitrate,
+						Duration:     r.Duration,
+						Resolution:   r.Resolution,
+
+						SampleFrequency: r.SampleFrequency,
+						NrAudioChannels: r.NrAudioChannels,
+						BitsPerSample:   r.BitsPerSample,
+						ColorDepth:      r.ColorDepth,
+					})
+				}
+			default:
+				continue
+			}
+			n.Children = append(n.Children, child)
+			all = append(all, o)
+			if _, ok := o.(upnpav.Container); ok && !seen[child.ID] {
+				seen[child.ID] = true
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(root); err != nil {
+		return err
+	}
+	if format == DumpDIDL {
+		didl, err := cds.MarshalDIDL(all)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, didl)
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(root)
+}
@@ -0,0 +1,65 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+)
+
+func TestDumpTree(t *testing.T) {
+	srv := &Server{
+		FS: fstest.MapFS{
+			"Music/Album/01.mp3": {Data: []byte("one")},
+			"Films/a.mkv":        {},
+			"notes.txt":          {},
+		},
+		RootObjectPath: ".",
+		FriendlyName:   "dump",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+	}
+	var buf bytes.Buffer
+	if err := srv.DumpTree(context.Background(), &buf, DumpJSON, "192.168.1.2:1338", ""); err != nil {
+		t.Fatal(err)
+	}
+	var root TreeNode
+	if err := json.Unmarshal(buf.Bytes(), &root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Title != "dump" || len(root.Children) != 2 {
+		t.Fatalf("got %+v", root)
+	}
+	music := root.Children[1]
+	if music.Title != "Music" || len(music.Children) != 1 || len(music.Children[0].Children) != 1 {
+		t.Fatalf("got %+v", music)
+	}
+	song := music.Children[0].Children[0]
+	if song.Title != "01.mp3" || song.ParentID != music.Children[0].ID || len(song.Res) != 1 {
+		t.Fatalf("got %+v", song)
+	}
+	if !strings.HasPrefix(song.Res[0].URL, "http://192.168.1.2:1338/res?") || song.Res[0].Size != 3 {
+		t.Errorf("got res %+v", song.Res[0])
+	}
+
+	buf.Reset()
+	if err := srv.DumpTree(context.Background(), &buf, DumpDIDL, "192.168.1.2:1338", ""); err != nil {
+		t.Fatal(err)
+	}
+	didl := buf.String()
+	if !strings.HasPrefix(didl, "<DIDL-Lite") || strings.Count(didl, "<container ") != 3 || strings.Count(didl, "<item ") != 2 {
+		t.Errorf("got %s", didl)
+	}
+	if strings.Index(didl, `id="Music"`) > strings.Index(didl, `id="Music%2FAlbum"`) {
+		t.Error("container after its children")
+	}
+
+	if err := srv.DumpTree(context.Background(), &buf, "xml", "", ""); err == nil {
+		t.Error("no error for unknown format")
+	}
+}
@@ -0,0 +1,276 @@
+package dms
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+const duplicatesAPIPath = "/api/duplicates"
+
+// Videos shorter than this aren't matched by their probe fingerprint, as
+// clips of the same length are common.
+const minFingerprintVideoSeconds = 60
+
+// How the files in a DuplicateSet were found to be duplicates.
+type DuplicateKind string
+
+const (
+	// The files have the same size and content.
+	DuplicateIdentical DuplicateKind = "identical"
+	// The files differ, but have the same duration and, for audio, the same
+	// artist and title tags, so are probably encodings of the same thing.
+	DuplicateProbable DuplicateKind = "probable"
+)
+
+// A file in a DuplicateSet.
+type DuplicateFile struct {
+	// The path relative to the root.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Files that are probably the same.
+type DuplicateSet struct {
+	Kind  DuplicateKind   `json:"kind"`
+	Files []DuplicateFile `json:"files"`
+}
+
+// Returns the bytes that deleting all but the largest file would free.
+func (me DuplicateSet) Reclaimable() (n int64) {
+	var largest int64
+	for _, f := range me.Files {
+		n += f.Size
+		largest = max(largest, f.Size)
+	}
+	return n - largest
+}
+
+func (me DuplicateSet) String() string {
+	var paths []string
+	for _, f := range me.Files {
+		paths = append(paths, f.Path)
+	}
+	return fmt.Sprintf("%s, %d bytes reclaimable: %s", me.Kind, me.Reclaimable(), strings.Join(paths, ", "))
+}
+
+// Walks the library for media files that are duplicates: those of the same
+// size with the same SHA-256, and, if byProbe, those with the same probe
+// fingerprint. Files found identical are only reported once. Like Audit,
+// unless NoProbe is set, the HTTP server must be running for probing.
+func (me *Server) FindDuplicates(ctx context.Context, byProbe bool) (ret []DuplicateSet, err error) {
+	bySize := make(map[int64][]DuplicateFile)
+	err = fs.WalkDir(me.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == "." {
+				return err
+			}
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if ignored, _ := me.IgnorePath(p); ignored {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if mt, err := me.mimeTypeByPath(p); err != nil || !mt.IsMedia() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil || fi.Size() == 0 {
+			return nil
+		}
+		bySize[fi.Size()] = append(bySize[fi.Size()], DuplicateFile{p, fi.Size()})
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	// One file of each identical set stands for it in the probe matching.
+	var distinct []DuplicateFile
+	for _, files := range bySize {
+		if len(files) == 1 {
+			distinct = append(distinct, files[0])
+			continue
+		}
+		byHash := make(map[string][]DuplicateFile)
+		for _, f := range files {
+			h, err := me.fileHash(ctx, f.Path)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err != nil {
+				me.Logger.Printf("hashing %s: %v", f.Path, err)
+				continue
+			}
+			byHash[h] = append(byHash[h], f)
+		}
+		for _, same := range byHash {
+			distinct = append(distinct, same[0])
+			if len(same) > 1 {
+				ret = append(ret, DuplicateSet{DuplicateIdentical, same})
+			}
+		}
+	}
+	if byProbe && !me.NoProbe {
+		byFingerprint := make(map[string][]DuplicateFile)
+		for _, f := range distinct {
+			info, err := me.ffmpegProbe(ctx, f.Path)
+			if err == ffprobe.ExeNotFound {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if fp, ok := probeFingerprint(info); ok {
+				byFingerprint[fp] = append(byFingerprint[fp], f)
+			}
+		}
+		for _, same := range byFingerprint {
+			if len(same) > 1 {
+				ret = append(ret, DuplicateSet{DuplicateProbable, same})
+			}
+		}
+	}
+	for _, set := range ret {
+		slices.SortFunc(set.Files, func(a, b DuplicateFile) int {
+			return strings.Compare(a.Path, b.Path)
+		})
+	}
+	// Those freeing the most space first.
+	slices.SortFunc(ret, func(a, b DuplicateSet) int {
+		if c := cmp.Compare(b.Reclaimable(), a.Reclaimable()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Files[0].Path, b.Files[0].Path)
+	})
+	return
+}
+
+func (me *Server) fileHash(ctx context.Context, filePath string) (string, error) {
+	f, err := me.FS.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	// Large files take a while, so stop if the context is done.
+	buf := make([]byte, 1<<20)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := f.Read(buf)
+		h.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Returns what probably identifies the content of a probed file, whatever its
+// encoding: the duration to the second, and for audio, the artist and title
+// tags.
+func probeFingerprint(info *ffprobe.Info) (string, bool) {
+	if info == nil {
+		return "", false
+	}
+	d, err := info.Duration()
+	if err != nil || d <= 0 {
+		return "", false
+	}
+	seconds := int64(math.Round(d.Seconds()))
+	if hasMovingVideo(info) {
+		if seconds < minFingerprintVideoSeconds {
+			return "", false
+		}
+		return fmt.Sprintf("video %d", seconds), true
+	}
+	artist, _ := audioTag(info, "artist")
+	title, _ := audioTag(info, "title")
+	if artist == "" || title == "" {
+		return "", false
+	}
+	return fmt.Sprintf("audio %d %q %q", seconds, strings.ToLower(strings.TrimSpace(artist)), strings.ToLower(strings.TrimSpace(title))), true
+}
+
+// Reports whether the file has a video stream that isn't just cover art.
+func hasMovingVideo(info *ffprobe.Info) bool {
+	return movingVideoStream(info) != nil
+}
+
+// Returns the first video stream that isn't just cover art, or nil. The info
+// may be nil.
+func movingVideoStream(info *ffprobe.Info) map[string]interface{} {
+	if info == nil {
+		return nil
+	}
+	for _, s := range info.Streams {
+		if streamString(s, "codec_type") != "video" {
+			continue
+		}
+		if disposition, _ := s["disposition"].(map[string]interface{}); disposition["attached_pic"] == float64(1) {
+			continue
+		}
+		return s
+	}
+	return nil
+}
+
+// GET lists the duplicates in the library visible to the client as JSON
+// DuplicateSets, those freeing the most space first. With probe=1, probable
+// duplicates are found too. It reads every file that shares its size with
+// another, so can take a while.
+func (me *Server) serveDuplicatesAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !me.duplicatesMu.TryLock() {
+		http.Error(w, "already finding duplicates", http.StatusServiceUnavailable)
+		return
+	}
+	defer me.duplicatesMu.Unlock()
+	sets, err := me.FindDuplicates(r.Context(), r.URL.Query().Get("probe") == "1")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client := playbackClient(r)
+	ret := []DuplicateSet{}
+	for _, set := range sets {
+		set.Files = slices.DeleteFunc(set.Files, func(f DuplicateFile) bool {
+			return me.hiddenFrom(client, f.Path)
+		})
+		if len(set.Files) > 1 {
+			for i := range set.Files {
+				set.Files[i].Path = "/" + set.Files[i].Path
+			}
+			ret = append(ret, set)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
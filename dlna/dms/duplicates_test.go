@@ -0,0 +1,70 @@
+package dms
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Music/a.mp3":        {Data: []byte("same")},
+			"Music/Copy/a.mp3":   {Data: []byte("same")},
+			"Music/b.mp3":        {Data: []byte("diff")},
+			"Films/Heat.mkv":     {Data: []byte("heat movie")},
+			"Films/Heat (1).mkv": {Data: []byte("heat movie")},
+			"Films/notes.txt":    {Data: []byte("heat movie")},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		Logger:         log.Default,
+	}
+	sets, err := s.FindDuplicates(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 2 {
+		t.Fatalf("got %v", sets)
+	}
+	// The set freeing the most comes first.
+	if a := sets[0].String(); a != "identical, 10 bytes reclaimable: Films/Heat (1).mkv, Films/Heat.mkv" {
+		t.Errorf("got %q", a)
+	}
+	if a := sets[1].String(); a != "identical, 4 bytes reclaimable: Music/Copy/a.mp3, Music/a.mp3" {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestProbeFingerprint(t *testing.T) {
+	audio := func(duration, artist, title string) *ffprobe.Info {
+		return &ffprobe.Info{
+			Format: map[string]interface{}{
+				"duration": duration,
+				"tags":     map[string]interface{}{"ARTIST": artist, "title": title},
+			},
+			Streams: []map[string]interface{}{
+				{"codec_type": "audio"},
+				{"codec_type": "video", "disposition": map[string]interface{}{"attached_pic": float64(1)}},
+			},
+		}
+	}
+	a, _ := probeFingerprint(audio("215.2", "Madonna", "Hang Up"))
+	b, _ := probeFingerprint(audio("214.9", "madonna ", "HANG UP"))
+	if a == "" || a != b {
+		t.Errorf("%q != %q", a, b)
+	}
+	if _, ok := probeFingerprint(audio("215.2", "", "Hang Up")); ok {
+		t.Error("fingerprinted audio without an artist")
+	}
+	clip := &ffprobe.Info{
+		Format:  map[string]interface{}{"duration": "30"},
+		Streams: []map[string]interface{}{{"codec_type": "video"}},
+	}
+	if _, ok := probeFingerprint(clip); ok {
+		t.Error("fingerprinted a short clip")
+	}
+}
@@ -0,0 +1,451 @@
+package dms
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// The root folder the recordings are listed in.
+const recordingsFolder = "Recordings"
+
+// The class of recorded broadcasts.
+const broadcastClass = "object.item.videoItem.videoBroadcast"
+
+// A tuner or IPTV channel recordings can be made from.
+type Channel struct {
+	Name string
+	// Where the channel streams from, such as an HDHomeRun tuner's
+	// http://192.168.1.50:5004/auto/v5.1, or an IPTV playlist entry.
+	URL string
+}
+
+// Returns the channels listed at the URL, in an HDHomeRun lineup.json, or an
+// IPTV M3U playlist.
+func FetchChannels(ctx context.Context, source string) ([]Channel, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", source, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if b = bytes.TrimSpace(b); bytes.HasPrefix(b, []byte("[")) {
+		return parseHDHomeRunLineup(b)
+	}
+	return parseM3UChannels(bytes.NewReader(b)), nil
+}
+
+// Parses the channels of an HDHomeRun's lineup.json.
+func parseHDHomeRunLineup(b []byte) (ret []Channel, err error) {
+	var lineup []struct {
+		GuideNumber string
+		GuideName   string
+		URL         string
+	}
+	if err = json.Unmarshal(b, &lineup); err != nil {
+		return
+	}
+	for _, l := range lineup {
+		if l.URL != "" {
+			ret = append(ret, Channel{Name: strings.TrimSpace(l.GuideNumber + " " + l.GuideName), URL: l.URL})
+		}
+	}
+	return
+}
+
+// Parses the channels of an IPTV M3U playlist, named by their #EXTINF titles.
+func parseM3UChannels(r io.Reader) (ret []Channel) {
+	s := bufio.NewScanner(r)
+	var name string
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// The title follows the last comma, after any attributes.
+			if i := strings.LastIndex(line, ","); i >= 0 {
+				name = strings.TrimSpace(line[i+1:])
+			}
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			if name == "" {
+				name = line
+			}
+			ret = append(ret, Channel{Name: name, URL: line})
+			name = ""
+		}
+	}
+	return
+}
+
+// Returns the channel with the name.
+func (me *Server) channel(name string) (Channel, bool) {
+	i := slices.IndexFunc(me.Channels, func(c Channel) bool { return c.Name == name })
+	if i < 0 {
+		return Channel{}, false
+	}
+	return me.Channels[i], true
+}
+
+// The states of a Recording.
+const (
+	RecordingScheduled = "scheduled"
+	RecordingActive    = "recording"
+	RecordingDone      = "done"
+	RecordingFailed    = "failed"
+)
+
+// A one off recording of a channel.
+type Recording struct {
+	ID       string
+	Title    string
+	Channel  string
+	Start    time.Time
+	Duration time.Duration
+	State    string
+	// The FS paths of the files recorded, in the Recordings folder. There's
+	// more than one if dms was restarted part way through.
+	Paths []string `json:",omitempty"`
+	// Why it failed.
+	Error string `json:",omitempty"`
+}
+
+func (me Recording) End() time.Time {
+	return me.Start.Add(me.Duration)
+}
+
+// Recordings scheduled through the ScheduledRecording service, and how they
+// went. The zero value is ready for use.
+type Recordings struct {
+	mu         sync.Mutex
+	recordings []*Recording
+	lastID     int
+	// Counts changes, as the ScheduledRecording StateUpdateID.
+	updateID uint32
+	// Wakes the scheduler when a recording is added or removed.
+	changed chan struct{}
+}
+
+// Reads recordings saved by Save, replacing the current ones. Recordings that
+// were under way when they were saved carry on in a new file if there's time
+// left.
+func (me *Recordings) Load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var saved struct {
+		Recordings []*Recording
+		LastID     int
+	}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, r := range saved.Recordings {
+		if r.State != RecordingActive {
+			continue
+		}
+		if now.Before(r.End()) {
+			r.State = RecordingScheduled
+		} else {
+			r.State, r.Error = RecordingFailed, "interrupted"
+		}
+	}
+	me.mu.Lock()
+	me.recordings = saved.Recordings
+	me.lastID = saved.LastID
+	me.mu.Unlock()
+	me.notify()
+	return nil
+}
+
+// Writes the recordings to a file.
+func (me *Recordings) Save(path string) error {
+	me.mu.Lock()
+	b, err := json.Marshal(struct {
+		Recordings []*Recording
+		LastID     int
+	}{me.recordings, me.lastID})
+	me.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// Returns the channel that's closed when a recording is added or removed.
+// me.mu must be held.
+func (me *Recordings) changedLocked() chan struct{} {
+	if me.changed == nil {
+		me.changed = make(chan struct{})
+	}
+	return me.changed
+}
+
+func (me *Recordings) changedChan() <-chan struct{} {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.changedLocked()
+}
+
+func (me *Recordings) notify() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.notifyLocked()
+}
+
+func (me *Recordings) notifyLocked() {
+	me.updateID++
+	close(me.changedLocked())
+	me.changed = nil
+}
+
+func (me *Recordings) stateUpdateID() uint32 {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.updateID
+}
+
+// Schedules a recording, returning it with its new ID.
+func (me *Recordings) add(r Recording) Recording {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.lastID++
+	r.ID = strconv.Itoa(me.lastID)
+	r.State = RecordingScheduled
+	me.recordings = append(me.recordings, &r)
+	me.notifyLocked()
+	return r
+}
+
+// Removes the recording, reporting whether there was one with the ID. Its
+// files are left in the Recordings folder.
+func (me *Recordings) remove(id string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	i := slices.IndexFunc(me.recordings, func(r *Recording) bool { return r.ID == id })
+	if i < 0 {
+		return false
+	}
+	me.recordings = slices.Delete(me.recordings, i, i+1)
+	me.notifyLocked()
+	return true
+}
+
+func (me *Recordings) get(id string) (Recording, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, r := range me.recordings {
+		if r.ID == id {
+			return *r, true
+		}
+	}
+	return Recording{}, false
+}
+
+// Returns the recordings, in the order they start.
+func (me *Recordings) list() (ret []Recording) {
+	me.mu.Lock()
+	for _, r := range me.recordings {
+		ret = append(ret, *r)
+	}
+	me.mu.Unlock()
+	slices.SortStableFunc(ret, func(a, b Recording) int { return a.Start.Compare(b.Start) })
+	return
+}
+
+// Returns the recording that made the file at the FS path.
+func (me *Recordings) byPath(p string) (Recording, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, r := range me.recordings {
+		if slices.Contains(r.Paths, p) {
+			return *r, true
+		}
+	}
+	return Recording{}, false
+}
+
+// Marks the scheduled recordings that should be under way as recording, to
+// files named by nameFile, and returns them. Those that were missed
+// altogether fail. Also returns when the next recording is due.
+func (me *Recordings) due(now time.Time, nameFile func(Recording) string) (started []Recording, next time.Time) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	changed := false
+	for _, r := range me.recordings {
+		if r.State != RecordingScheduled {
+			continue
+		}
+		switch {
+		case !now.Before(r.End()):
+			r.State, r.Error = RecordingFailed, "missed"
+			changed = true
+		case !now.Before(r.Start):
+			r.State = RecordingActive
+			r.Paths = append(r.Paths, nameFile(*r))
+			started = append(started, *r)
+			changed = true
+		case next.IsZero() || r.Start.Before(next):
+			next = r.Start
+		}
+	}
+	if changed {
+		me.updateID++
+	}
+	return
+}
+
+// Records how the recording went. Interrupted recordings with time left are
+// scheduled again, to carry on in a new file.
+func (me *Recordings) finish(id string, err error, interrupted bool, now time.Time) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	i := slices.IndexFunc(me.recordings, func(r *Recording) bool { return r.ID == id })
+	if i < 0 {
+		return
+	}
+	r := me.recordings[i]
+	switch {
+	case interrupted && now.Before(r.End()):
+		r.State = RecordingScheduled
+	case err != nil:
+		r.State, r.Error = RecordingFailed, err.Error()
+	default:
+		r.State = RecordingDone
+	}
+	me.updateID++
+}
+
+// Reports whether the ScheduledRecording service is offered.
+func (me *Server) recordingEnabled() bool {
+	return me.Recordings != nil && me.RecordingsDir != "" && len(me.Channels) != 0
+}
+
+// Returns the FS path of a new file for the recording, named for its title,
+// channel and when it starts.
+func recordingFilePath(r Recording, now time.Time) string {
+	name := fmt.Sprintf("%s (%s) %s.ts", r.Title, r.Channel, now.Format("2006-01-02 15.04"))
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return path.Join(recordingsFolder, name)
+}
+
+// Starts recordings as they fall due, until the server is closed.
+func (me *Server) scheduleRecordings() {
+	for {
+		now := time.Now()
+		changed := me.Recordings.changedChan()
+		started, next := me.Recordings.due(now, func(r Recording) string {
+			return recordingFilePath(r, now)
+		})
+		for _, r := range started {
+			go me.record(r)
+		}
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = min(wait, next.Sub(now))
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-me.closed:
+			t.Stop()
+			return
+		case <-changed:
+			t.Stop()
+		case <-t.C:
+		}
+	}
+}
+
+// Records the channel into the recording's latest file until it ends.
+func (me *Server) record(r Recording) {
+	filePath := r.Paths[len(r.Paths)-1]
+	me.Logger.Printf("recording %q from %q to %q", r.Title, r.Channel, filePath)
+	err := me.recordStream(r, filePath)
+	interrupted := me.closedContext().Err() != nil
+	switch {
+	case interrupted:
+		me.Logger.Printf("recording %q interrupted", r.Title)
+	case err != nil:
+		me.Logger.Printf("recording %q: %v", r.Title, err)
+	default:
+		me.Logger.Printf("recorded %q", r.Title)
+	}
+	me.Recordings.finish(r.ID, err, interrupted, time.Now())
+}
+
+func (me *Server) recordStream(r Recording, filePath string) error {
+	ch, ok := me.channel(r.Channel)
+	if !ok {
+		return fmt.Errorf("no channel %q", r.Channel)
+	}
+	ctx, cancel := context.WithDeadline(me.closedContext(), r.End())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ch.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", r.Channel, resp.Status)
+	}
+	osPath := filepath.Join(me.RecordingsDir, filepath.FromSlash(strings.TrimPrefix(filePath, recordingsFolder+"/")))
+	f, err := os.OpenFile(osPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	// The file is listed as it's written, and is growing while it's
+	// modified.
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = closeErr
+	}
+	if err == nil && ctx.Err() == nil {
+		return errors.New("the stream ended early")
+	}
+	return err
+}
+
+// Describes a recording's file as the broadcast it was recorded from.
+func setRecordingMetadata(obj *upnpav.Object, r Recording) {
+	obj.Class = broadcastClass
+	obj.Title = r.Title
+	obj.ChannelName = r.Channel
+	obj.ScheduledStartTime = r.Start.Local().Format(srsDateTimeLayout)
+	obj.ScheduledEndTime = r.End().Local().Format(srsDateTimeLayout)
+	obj.Date = upnpav.Timestamp{Time: r.Start}
+}
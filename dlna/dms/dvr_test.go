@@ -0,0 +1,140 @@
+package dms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestFetchChannels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/lineup.json":
+			fmt.Fprint(w, `[{"GuideNumber":"5.1","GuideName":"KPIX","URL":"http://tuner/auto/v5.1"},{"GuideNumber":"9.1","GuideName":"DRM"}]`)
+		case "/playlist.m3u":
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:-1 tvg-id=\"news\" group-title=\"A, B\",News 24\nhttp://iptv/news.ts\n\nhttp://iptv/unnamed.ts\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	for _, tc := range []struct {
+		path string
+		want []Channel
+	}{
+		{"/lineup.json", []Channel{{"5.1 KPIX", "http://tuner/auto/v5.1"}}},
+		{"/playlist.m3u", []Channel{{"News 24", "http://iptv/news.ts"}, {"http://iptv/unnamed.ts", "http://iptv/unnamed.ts"}}},
+	} {
+		got, err := FetchChannels(context.Background(), ts.URL+tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.path, got, tc.want)
+		}
+	}
+	if _, err := FetchChannels(context.Background(), ts.URL+"/missing"); err == nil {
+		t.Error("fetched a missing source")
+	}
+}
+
+func TestRecordingsDue(t *testing.T) {
+	var rs Recordings
+	now := time.Now()
+	missed := rs.add(Recording{Title: "Missed", Start: now.Add(-2 * time.Hour), Duration: time.Hour})
+	current := rs.add(Recording{Title: "Current", Start: now.Add(-time.Minute), Duration: time.Hour})
+	rs.add(Recording{Title: "Later", Start: now.Add(2 * time.Hour), Duration: time.Hour})
+	rs.add(Recording{Title: "Sooner", Start: now.Add(time.Hour), Duration: time.Hour})
+	started, next := rs.due(now, func(r Recording) string { return "Recordings/" + r.Title + ".ts" })
+	if len(started) != 1 || started[0].ID != current.ID || !next.Equal(now.Add(time.Hour)) {
+		t.Fatalf("started %v, next %v", started, next)
+	}
+	if r, _ := rs.get(missed.ID); r.State != RecordingFailed {
+		t.Errorf("missed recording is %q", r.State)
+	}
+	if r, ok := rs.byPath("Recordings/Current.ts"); !ok || r.State != RecordingActive {
+		t.Errorf("current recording is %+v", r)
+	}
+
+	// Saved part way through, it carries on when it's loaded.
+	path := filepath.Join(t.TempDir(), "recordings")
+	if err := rs.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	var loaded Recordings
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := loaded.get(current.ID); r.State != RecordingScheduled || len(r.Paths) != 1 {
+		t.Errorf("loaded recording is %+v", r)
+	}
+	if r := loaded.add(Recording{}); r.ID != "5" {
+		t.Errorf("new recording has ID %q", r.ID)
+	}
+
+	rs.finish(current.ID, nil, true, now)
+	if r, _ := rs.get(current.ID); r.State != RecordingScheduled {
+		t.Errorf("interrupted recording is %q", r.State)
+	}
+	if !rs.remove(current.ID) || rs.remove(current.ID) {
+		t.Error("unexpected remove result")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for {
+			if _, err := w.Write([]byte("ts packets")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer stream.Close()
+	dir := t.TempDir()
+	s := &Server{
+		Logger:        log.Default,
+		Channels:      []Channel{{"News", stream.URL}},
+		RecordingsDir: dir,
+		Recordings:    &Recordings{},
+	}
+	now := time.Now()
+	s.Recordings.add(Recording{Title: "Evening: news", Channel: "News", Start: now, Duration: 200 * time.Millisecond})
+	started, _ := s.Recordings.due(now, func(r Recording) string { return recordingFilePath(r, now) })
+	if len(started) != 1 {
+		t.Fatal(started)
+	}
+	s.record(started[0])
+	r, _ := s.Recordings.get(started[0].ID)
+	if r.State != RecordingDone {
+		t.Fatalf("recording is %+v", r)
+	}
+	name := strings.TrimPrefix(r.Paths[0], recordingsFolder+"/")
+	if strings.ContainsAny(name, ":/") || !strings.HasPrefix(name, "Evening_ news (News) ") {
+		t.Errorf("recorded to %q", name)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || !strings.HasPrefix(string(b), "ts packets") {
+		t.Errorf("recorded %q, %v", b, err)
+	}
+
+	var obj upnpav.Object
+	setRecordingMetadata(&obj, r)
+	if obj.Title != "Evening: news" || obj.ChannelName != "News" || obj.Class != "object.item.videoItem.videoBroadcast" || obj.ScheduledStartTime == "" {
+		t.Errorf("got %+v", obj)
+	}
+}
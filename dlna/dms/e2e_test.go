@@ -0,0 +1,123 @@
+package dms
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna/dms/dmstest"
+	"github.com/anacrolix/dms/search"
+)
+
+// Runs a server on the loopback interface, and drives it with a simulated
+// client as a TV would.
+func TestEndToEnd(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	srv := &Server{
+		FS: fstest.MapFS{
+			"Music/song.mp3": {Data: []byte("0123456789")},
+			"Films/film.mkv": {Data: []byte("film")},
+		},
+		HTTPConn:      l,
+		Interfaces:    []net.Interface{},
+		FriendlyName:  "e2e",
+		NoProbe:       true,
+		NoTranscode:   true,
+		AllowedIpNets: []*net.IPNet{all},
+		Search:        &search.Index{},
+		Logger:        log.Default,
+	}
+	if err := srv.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	defer srv.Close()
+
+	client := &dmstest.Client{UserAgent: "dmstest"}
+	dev, err := client.OpenDevice(ctx, "http://"+l.Addr().String()+rootDescPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Desc.Device.FriendlyName != "e2e" {
+		t.Errorf("got friendly name %q", dev.Desc.Device.FriendlyName)
+	}
+	if len(dev.SCPDs["ContentDirectory"].ActionList) == 0 {
+		t.Error("no ContentDirectory actions")
+	}
+
+	root, err := dev.Browse(ctx, "0", "BrowseDirectChildren", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, c := range root.Objects.Containers {
+		titles = append(titles, c.Title)
+	}
+	if root.TotalMatches != 2 || len(titles) != 2 || titles[0] != "Films" || titles[1] != "Music" {
+		t.Fatalf("got %d root containers %q", root.TotalMatches, titles)
+	}
+	music, err := dev.Browse(ctx, root.Objects.Containers[1].ID, "BrowseDirectChildren", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(music.Objects.Items) != 1 || len(music.Objects.Items[0].Res) == 0 {
+		t.Fatalf("got %+v", music.Objects)
+	}
+	song := music.Objects.Items[0]
+
+	// The library is indexed in the background.
+	for {
+		found, err := dev.Search(ctx, "0", `dc:title contains "song"`, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found.Objects.Items) == 1 && found.Objects.Items[0].ID == song.ID {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("search found %+v", found.Objects)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if _, err := dev.Browse(ctx, "no such object", "BrowseMetadata", 0, 0); err == nil {
+		t.Error("browsed a missing object")
+	}
+
+	sub, err := dev.Subscribe(ctx, "ContentDirectory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	select {
+	case ev := <-sub.Events:
+		if ev.SID != sub.SID || ev.Seq != 0 || ev.Properties["SystemUpdateID"] == "" {
+			t.Errorf("got initial event %+v for %q", ev, sub.SID)
+		}
+	case <-ctx.Done():
+		t.Fatal("no initial event")
+	}
+
+	resp, err := client.Stream(ctx, song.Res[0].URL, "bytes=2-5", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(b) != "2345" {
+		t.Errorf("got %s, %q", resp.Status, b)
+	}
+}
@@ -0,0 +1,90 @@
+package dms
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+// A hardware encoder the H.264 transcodes can use, such as an integrated or
+// a discrete GPU.
+type Encoder struct {
+	// Used for logging and Stats.
+	Name string
+	transcode.HWEncoder
+	// At most this many transcodes use it at a time. Zero means no limit.
+	MaxSessions int
+	// The transcodes, such as "chromecast" or "web", that may use it. Empty
+	// means all of them.
+	Transcodes []string
+}
+
+// Returns the names of the transcodes that can use an Encoder.
+func EncoderTranscodes() (ret []string) {
+	ret = append(ret, hlsTranscodeName)
+	for name, ts := range transcodes {
+		if ts.videoCodec == "h264" {
+			ret = append(ret, name)
+		}
+	}
+	slices.Sort(ret)
+	return
+}
+
+// Counts the transcodes using each of Server.Encoders.
+type encoderSessions struct {
+	mu sync.Mutex
+	n  map[int]int
+}
+
+// Returns the encoder for a transcode to use: of the Server.Encoders it may
+// use with a session to spare, the one with fewest sessions, earlier ones
+// first. It's the software encoder if there's none. The release func must be
+// called when the transcode is done.
+func (me *Server) acquireEncoder(tsname string) (enc transcode.HWEncoder, name string, release func()) {
+	me.encoderSessions.mu.Lock()
+	defer me.encoderSessions.mu.Unlock()
+	best := -1
+	for i, e := range me.Encoders {
+		if len(e.Transcodes) != 0 && !slices.Contains(e.Transcodes, tsname) {
+			continue
+		}
+		n := me.encoderSessions.n[i]
+		if e.MaxSessions != 0 && n >= e.MaxSessions {
+			continue
+		}
+		if best == -1 || n < me.encoderSessions.n[best] {
+			best = i
+		}
+	}
+	if best == -1 {
+		return transcode.HWEncoder{}, "", func() {}
+	}
+	if me.encoderSessions.n == nil {
+		me.encoderSessions.n = make(map[int]int)
+	}
+	me.encoderSessions.n[best]++
+	var once sync.Once
+	return me.Encoders[best].HWEncoder, me.Encoders[best].Name, func() {
+		once.Do(func() {
+			me.encoderSessions.mu.Lock()
+			me.encoderSessions.n[best]--
+			me.encoderSessions.mu.Unlock()
+		})
+	}
+}
+
+// Returns the transcodes using each of Server.Encoders, by name.
+func (me *Server) encoderSessionCounts() map[string]int {
+	if len(me.Encoders) == 0 {
+		return nil
+	}
+	me.encoderSessions.mu.Lock()
+	defer me.encoderSessions.mu.Unlock()
+	ret := make(map[string]int, len(me.Encoders))
+	for i, e := range me.Encoders {
+		ret[e.Name] += me.encoderSessions.n[i]
+	}
+	return ret
+}
@@ -0,0 +1,38 @@
+package dms
+
+import (
+	"testing"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+func TestAcquireEncoder(t *testing.T) {
+	s := &Server{Encoders: []Encoder{
+		{Name: "igpu", HWEncoder: transcode.HWEncoder{API: transcode.QSV}, MaxSessions: 1, Transcodes: []string{"web"}},
+		{Name: "dgpu", HWEncoder: transcode.HWEncoder{API: transcode.NVENC}, MaxSessions: 2},
+	}}
+	acquire := func(tsname, want string) func() {
+		t.Helper()
+		_, name, release := s.acquireEncoder(tsname)
+		if name != want {
+			t.Errorf("%s got %q, want %q", tsname, name, want)
+		}
+		return release
+	}
+	// Ties go to the earlier encoder, and then the least busy.
+	releaseWeb := acquire("web", "igpu")
+	acquire("web", "dgpu")
+	releaseChromecast := acquire("chromecast", "dgpu")
+	// Both are full.
+	acquire("web", "")
+	releaseChromecast()
+	releaseChromecast()
+	if n := s.encoderSessionCounts(); n["igpu"] != 1 || n["dgpu"] != 1 {
+		t.Errorf("sessions %v", n)
+	}
+	releaseWeb()
+	acquire("chromecast", "dgpu")
+	if n := s.encoderSessionCounts(); n["igpu"] != 0 || n["dgpu"] != 2 {
+		t.Errorf("sessions %v", n)
+	}
+}
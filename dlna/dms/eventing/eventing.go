@@ -0,0 +1,214 @@
+// Package eventing handles UPnP event subscription requests (GENA) for a
+// service, and delivers its events to the subscribers' callbacks.
+package eventing
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+// How long delivering an event to a subscriber may take.
+const notifyTimeout = 30 * time.Second
+
+// Limits on subscription requests, which come from any peer on the LAN.
+const (
+	maxCallbackHeaderLen = 1024
+	maxCallbackURLs      = 4
+	// Given to subscribers that don't ask for a timeout, or ask for an
+	// infinite one, and the most any subscriber gets.
+	defaultSubscriptionTimeout = 1800
+)
+
+// A service that can be subscribed to, such as with an embedded
+// upnp.Eventing.
+type Service interface {
+	Subscribe(callback []*url.URL, timeoutSeconds int) (sid string, actualTimeout int, err error)
+	Renew(sid string, timeoutSeconds int) (actualTimeout int, err error)
+	Unsubscribe(sid string) error
+	SendInitialEvent(sid string, props []upnp.Property)
+}
+
+// Handles requests to a service's event subscription URL.
+type Handler struct {
+	Service Service
+	// The state variables sent to new subscribers.
+	InitialProperties func() []upnp.Property
+	// Stall subscription requests until they drop. A workaround for some bad
+	// clients.
+	Stall  bool
+	Logger log.Logger
+}
+
+func (me *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if me.Stall {
+		// I have an LG TV that doesn't like my eventing implementation.
+		// Returning unimplemented (501?) errors, results in repeat subscribe
+		// attempts which hits some kind of error count limit on the TV
+		// causing it to forcefully disconnect. It also won't work if the CDS
+		// service doesn't include an EventSubURL. The best thing I can do is
+		// cause every attempt to subscribe to timeout on the TV end, which
+		// reduces the error rate enough that the TV continues to operate
+		// without eventing.
+		//
+		// I've not found a reliable way to identify this TV, since it and
+		// others don't seem to include any client-identifying headers on
+		// SUBSCRIBE requests.
+		//
+		// TODO: Get eventing to work with the problematic TV.
+		t := time.Now()
+		<-r.Context().Done()
+		me.Logger.Printf("stalled subscribe connection went away after %s", time.Since(t))
+		return
+	}
+	me.Logger.Levelf(log.Debug, "%s %s %s", r.RemoteAddr, r.Method, r.Header.Get("SID"))
+	sid := r.Header.Get("SID")
+	switch {
+	case r.Method == "SUBSCRIBE" && sid == "":
+		urls, timeout, status, err := parseSubscribe(r.Header)
+		if err != nil {
+			me.Logger.Levelf(log.Debug, "bad subscription from %s: %v", r.RemoteAddr, err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		sid, timeout, err := me.Service.Subscribe(urls, timeout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header()["SID"] = []string{sid}
+		w.Header()["TIMEOUT"] = []string{fmt.Sprintf("Second-%d", timeout)}
+		w.WriteHeader(http.StatusOK)
+		// The initial event is sent after the response, so the subscriber
+		// knows the SID.
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			var props []upnp.Property
+			if me.InitialProperties != nil {
+				props = me.InitialProperties()
+			}
+			me.Service.SendInitialEvent(sid, props)
+		}()
+	case r.Method == "SUBSCRIBE":
+		if r.Header.Get("CALLBACK") != "" || r.Header.Get("NT") != "" {
+			http.Error(w, "SID given with CALLBACK or NT", http.StatusBadRequest)
+			return
+		}
+		timeout, err := me.Service.Renew(sid, parseTimeout(r.Header))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		w.Header()["SID"] = []string{sid}
+		w.Header()["TIMEOUT"] = []string{fmt.Sprintf("Second-%d", timeout)}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "UNSUBSCRIBE":
+		if r.Header.Get("CALLBACK") != "" || r.Header.Get("NT") != "" {
+			http.Error(w, "SID given with CALLBACK or NT", http.StatusBadRequest)
+			return
+		}
+		if sid == "" {
+			http.Error(w, "no SID", http.StatusPreconditionFailed)
+			return
+		}
+		if err := me.Service.Unsubscribe(sid); err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		me.Logger.Levelf(log.Debug, "unhandled event method from %s: %s", r.RemoteAddr, r.Method)
+		w.Header().Set("Allow", "SUBSCRIBE, UNSUBSCRIBE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Checks the headers of a new subscription as in UPnP Device Architecture
+// 4.1.1, returning the callback URLs and timeout in seconds, or the status to
+// refuse it with.
+func parseSubscribe(h http.Header) (urls []*url.URL, timeout int, status int, err error) {
+	if nt := h.Get("NT"); nt != "upnp:event" {
+		return nil, 0, http.StatusPreconditionFailed, fmt.Errorf("NT is %q, not upnp:event", nt)
+	}
+	callback := h.Get("CALLBACK")
+	if len(callback) > maxCallbackHeaderLen {
+		return nil, 0, http.StatusPreconditionFailed, fmt.Errorf("CALLBACK exceeds %d bytes", maxCallbackHeaderLen)
+	}
+	urls = upnp.ParseCallbackURLs(callback)
+	if len(urls) == 0 || len(urls) > maxCallbackURLs {
+		return nil, 0, http.StatusPreconditionFailed, fmt.Errorf("CALLBACK has %d URLs, want 1 to %d", len(urls), maxCallbackURLs)
+	}
+	for _, u := range urls {
+		if u.Scheme != "http" || u.Host == "" {
+			return nil, 0, http.StatusPreconditionFailed, fmt.Errorf("bad callback URL %q", u)
+		}
+	}
+	return urls, parseTimeout(h), http.StatusOK, nil
+}
+
+// Returns the timeout in seconds a subscription asks for, as allowed.
+func parseTimeout(h http.Header) int {
+	if n, err := strconv.Atoi(strings.TrimPrefix(h.Get("TIMEOUT"), "Second-")); err == nil && n > 0 && n < defaultSubscriptionTimeout {
+		return n
+	}
+	return defaultSubscriptionTimeout
+}
+
+// Returns a upnp.Eventing Notify func that sends events with Notify, giving up
+// on each after a while.
+func Sender(logger log.Logger) func(urls []*url.URL, sid string, seq uint32, props []upnp.Property) {
+	return func(urls []*url.URL, sid string, seq uint32, props []upnp.Property) {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		Notify(ctx, logger, urls, sid, seq, props)
+	}
+}
+
+// Sends a property change event with the sequence number to a subscriber's
+// callback URLs, giving up when the context is done.
+func Notify(ctx context.Context, logger log.Logger, urls []*url.URL, sid string, seq uint32, props []upnp.Property) {
+	body, err := xml.MarshalIndent(upnp.PropertySet{
+		Properties: props,
+		Space:      "urn:schemas-upnp-org:event-1-0",
+	}, "", "  ")
+	if err != nil {
+		logger.Levelf(log.Error, "marshalling event: %v", err)
+		return
+	}
+	body = append([]byte(`<?xml version="1.0"?>`+"\n"), body...)
+	// The URLs are tried in turn until one takes the event.
+	for _, _url := range urls {
+		req, err := http.NewRequestWithContext(ctx, "NOTIFY", _url.String(), bytes.NewReader(body))
+		if err != nil {
+			logger.Levelf(log.Debug, "creating a request to notify %s: %v", _url, err)
+			continue
+		}
+		req.Header["CONTENT-TYPE"] = []string{`text/xml; charset="utf-8"`}
+		req.Header["NT"] = []string{"upnp:event"}
+		req.Header["NTS"] = []string{"upnp:propchange"}
+		req.Header["SID"] = []string{sid}
+		req.Header["SEQ"] = []string{fmt.Sprint(seq)}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			logger.Levelf(log.Debug, "notifying %s: %v", _url, err)
+			continue
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return
+		}
+		logger.Levelf(log.Debug, "notifying %s: %s", _url, resp.Status)
+	}
+}
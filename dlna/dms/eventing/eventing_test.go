@@ -0,0 +1,137 @@
+package eventing
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+func TestSubscribeSendsInitialEvent(t *testing.T) {
+	type notify struct {
+		sid, seq, body string
+	}
+	notified := make(chan notify, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		notified <- notify{r.Header.Get("SID"), r.Header.Get("SEQ"), string(b)}
+	}))
+	defer callback.Close()
+	h := &Handler{
+		Service: &upnp.Eventing{Notify: Sender(log.Default)},
+		InitialProperties: func() []upnp.Property {
+			return []upnp.Property{{Variable: upnp.Variable{XMLName: xml.Name{Local: "SystemUpdateID"}, Value: "42"}}}
+		},
+		Logger: log.Default,
+	}
+	r := httptest.NewRequest("SUBSCRIBE", "/evt", nil)
+	r.Header.Set("CALLBACK", "<"+callback.URL+"/>")
+	r.Header.Set("NT", "upnp:event")
+	r.Header.Set("TIMEOUT", "Second-60")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	// The header is set as is, rather than canonicalized, for clients that
+	// are fussy about it.
+	sid := strings.Join(w.Header()["SID"], ",")
+	if w.Code != http.StatusOK || sid == "" {
+		t.Fatalf("got status %d, SID %q", w.Code, sid)
+	}
+	n := <-notified
+	if n.sid != sid || n.seq != "0" {
+		t.Errorf("got SID %q, SEQ %q", n.sid, n.seq)
+	}
+	if !strings.Contains(n.body, "<SystemUpdateID>42</SystemUpdateID>") {
+		t.Errorf("initial event missing property: %s", n.body)
+	}
+}
+
+func TestResubscribeWithoutSubscription(t *testing.T) {
+	h := &Handler{Service: &upnp.Eventing{}, Logger: log.Default}
+	r := httptest.NewRequest("SUBSCRIBE", "/evt", nil)
+	r.Header.Set("SID", "uuid:unknown")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d", w.Code)
+	}
+}
+
+func TestParseSubscribe(t *testing.T) {
+	for _, tc := range []struct {
+		nt, callback, timeout string
+		status, wantTimeout   int
+	}{
+		{"upnp:event", "<http://192.168.1.2:1234/evt>", "Second-300", http.StatusOK, 300},
+		{"upnp:event", "<http://a/1><http://b/2>", "", http.StatusOK, defaultSubscriptionTimeout},
+		{"upnp:event", "<http://a/>", "Second-infinite", http.StatusOK, defaultSubscriptionTimeout},
+		{"upnp:event", "<http://a/>", "Second-999999", http.StatusOK, defaultSubscriptionTimeout},
+		{"", "<http://a/>", "", http.StatusPreconditionFailed, 0},
+		{"upnp:event", "", "", http.StatusPreconditionFailed, 0},
+		{"upnp:event", "<file:///etc/passwd>", "", http.StatusPreconditionFailed, 0},
+		{"upnp:event", strings.Repeat("<http://a/>", 5), "", http.StatusPreconditionFailed, 0},
+		{"upnp:event", "<http://a/" + strings.Repeat("x", maxCallbackHeaderLen) + ">", "", http.StatusPreconditionFailed, 0},
+	} {
+		h := http.Header{}
+		h.Set("NT", tc.nt)
+		h.Set("CALLBACK", tc.callback)
+		h.Set("TIMEOUT", tc.timeout)
+		_, timeout, status, err := parseSubscribe(h)
+		if status != tc.status || timeout != tc.wantTimeout || (err == nil) != (status == http.StatusOK) {
+			t.Errorf("%q %q %q: got %d, %d, %v", tc.nt, tc.callback, tc.timeout, status, timeout, err)
+		}
+	}
+}
+
+func TestSubscribeWithSIDAndCallback(t *testing.T) {
+	h := &Handler{Service: &upnp.Eventing{}, Logger: log.Default}
+	r := httptest.NewRequest("SUBSCRIBE", "/evt", nil)
+	r.Header.Set("SID", "uuid:unknown")
+	r.Header.Set("CALLBACK", "<http://a/>")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d", w.Code)
+	}
+}
+
+func TestRenewAndUnsubscribe(t *testing.T) {
+	h := &Handler{Service: &upnp.Eventing{}, Logger: log.Default}
+	serve := func(method string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/evt", nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	w := serve("SUBSCRIBE", "CALLBACK", "<http://a/>", "NT", "upnp:event", "TIMEOUT", "Second-60")
+	sid := strings.Join(w.Header()["SID"], ",")
+	if w.Code != http.StatusOK || sid == "" {
+		t.Fatalf("got status %d, SID %q", w.Code, sid)
+	}
+	w = serve("SUBSCRIBE", "SID", sid, "TIMEOUT", "Second-120")
+	if w.Code != http.StatusOK || strings.Join(w.Header()["SID"], ",") != sid || strings.Join(w.Header()["TIMEOUT"], ",") != "Second-120" {
+		t.Errorf("renewing: got status %d, headers %v", w.Code, w.Header())
+	}
+	if w := serve("UNSUBSCRIBE", "SID", sid, "NT", "upnp:event"); w.Code != http.StatusBadRequest {
+		t.Errorf("unsubscribing with NT: got status %d", w.Code)
+	}
+	if w := serve("UNSUBSCRIBE", "SID", sid); w.Code != http.StatusOK {
+		t.Errorf("unsubscribing: got status %d", w.Code)
+	}
+	for _, method := range []string{"SUBSCRIBE", "UNSUBSCRIBE"} {
+		if w := serve(method, "SID", sid); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s after unsubscribing: got status %d", method, w.Code)
+		}
+	}
+	if w := serve("UNSUBSCRIBE"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("unsubscribing without SID: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,178 @@
+package dms
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+// Extensions of the MP4 files checked for an index at the end.
+var faststartExts = map[string]bool{
+	".mp4": true,
+	".m4v": true,
+}
+
+// Reports whether an MP4's media data (mdat atom) comes before its index
+// (moov atom). Renderers have to fetch the end of such files before they can
+// start playing, and many just buffer forever instead.
+func moovAtEnd(r io.Reader) (bool, error) {
+	var hdr [16]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return false, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:8])
+		headerLen := int64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(r, hdr[8:16]); err != nil {
+				return false, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			headerLen = 16
+		}
+		switch typ {
+		case "moov":
+			return false, nil
+		case "mdat":
+			return true, nil
+		}
+		if size == 0 {
+			// The atom runs to the end of the file.
+			return false, nil
+		}
+		if size < headerLen {
+			return false, fmt.Errorf("bad %q atom size %d", typ, size)
+		}
+		if err := skip(r, size-headerLen); err != nil {
+			return false, err
+		}
+	}
+}
+
+// Discards n bytes from r, seeking past them if it can.
+func skip(r io.Reader, n int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+// Reports whether a file is an MP4 that needs remuxing with its index at the
+// start for renderers to play it.
+func (me *Server) needsFaststart(filePath string) bool {
+	if !faststartExts[strings.ToLower(path.Ext(filePath))] {
+		return false
+	}
+	f, err := me.FS.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	ret, err := moovAtEnd(f)
+	if err != nil {
+		me.Logger.Levelf(log.Debug, "checking %q for faststart: %v", filePath, err)
+	}
+	return ret
+}
+
+// Returns where the remuxed copy of a file is kept, which changes with the
+// file.
+func (me *Server) faststartCopyPath(filePath string, fi fs.FileInfo) string {
+	h := md5.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d", filePath, fi.Size(), fi.ModTime().UnixNano())
+	return filepath.Join(me.FaststartCachePath, hex.EncodeToString(h.Sum(nil))+".mp4")
+}
+
+// Returns the remuxed copy of the file, if it's been made.
+func (me *Server) faststartCopy(filePath string, fi fs.FileInfo) (fs.FileInfo, string, bool) {
+	if me.FaststartCachePath == "" || fi == nil {
+		return nil, "", false
+	}
+	p := me.faststartCopyPath(filePath, fi)
+	cfi, err := os.Stat(p)
+	return cfi, p, err == nil
+}
+
+// Remuxes the file into the FaststartCachePath, unless that's already
+// happening.
+func (me *Server) cacheFaststart(filePath string, fi fs.FileInfo) {
+	me.faststartMu.Lock()
+	if _, ok := me.faststartPending[filePath]; ok {
+		me.faststartMu.Unlock()
+		return
+	}
+	if me.faststartPending == nil {
+		me.faststartPending = make(map[string]struct{})
+	}
+	me.faststartPending[filePath] = struct{}{}
+	me.faststartMu.Unlock()
+	defer func() {
+		me.faststartMu.Lock()
+		delete(me.faststartPending, filePath)
+		me.faststartMu.Unlock()
+	}()
+	p := me.faststartCopyPath(filePath, fi)
+	if err := os.MkdirAll(me.FaststartCachePath, 0o750); err != nil {
+		me.Logger.Levelf(log.Warning, "making faststart cache: %v", err)
+		return
+	}
+	tmp := p + ".tmp"
+	if err := transcode.Faststart(me.closedContext(), me.loopbackResURL(filePath), tmp, nil); err != nil {
+		me.Logger.Levelf(log.Warning, "remuxing %q for faststart: %v", filePath, err)
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		me.Logger.Levelf(log.Warning, "caching faststart copy of %q: %v", filePath, err)
+		os.Remove(tmp)
+	}
+}
+
+// Serves an MP4 with its index at the start: the cached copy if there is one,
+// otherwise a fragmented remux streamed as it's made, while the cached copy is
+// made in the background.
+func (me *Server) serveFaststart(w http.ResponseWriter, r *http.Request, filePath string, fi fs.FileInfo) {
+	if _, p, ok := me.faststartCopy(filePath, fi); ok {
+		http.ServeFile(w, r, p)
+		return
+	}
+	if me.FaststartCachePath != "" && fi != nil && r.Method == "GET" {
+		go me.cacheFaststart(filePath, fi)
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "HEAD" {
+		return
+	}
+	session := me.startTranscodeSession(r, filePath, "faststart", time.Now(), "")
+	p, err := transcode.Remux(r.Context(), me.loopbackResURL(filePath), "mp4", 0, 0, nil)
+	if err != nil {
+		me.endTranscodeSession(session, nil, err)
+		me.Logger.Levelf(log.Warning, "remuxing %q: %v", filePath, err)
+		return
+	}
+	io.Copy(session.writer(w), p)
+	p.Close()
+	me.endTranscodeSession(session, p, nil)
+}
@@ -0,0 +1,41 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func atom(typ string, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func TestMoovAtEnd(t *testing.T) {
+	ftyp := atom("ftyp", []byte("isom\x00\x00\x02\x00"))
+	moov := atom("moov", make([]byte, 32))
+	mdat := atom("mdat", make([]byte, 64))
+	// A 64 bit size, as used for large media data.
+	largeMdat := append(append(binary.BigEndian.AppendUint32(nil, 1), "mdat"...), binary.BigEndian.AppendUint64(nil, 16)...)
+	for _, c := range []struct {
+		name  string
+		data  []byte
+		atEnd bool
+	}{
+		{"faststart", bytes.Join([][]byte{ftyp, moov, mdat}, nil), false},
+		{"at end", bytes.Join([][]byte{ftyp, atom("free", nil), mdat, moov}, nil), true},
+		{"large mdat", bytes.Join([][]byte{ftyp, largeMdat, moov}, nil), true},
+		{"neither", ftyp, false},
+	} {
+		// Readers without Seek are skipped through too.
+		for _, r := range []io.Reader{bytes.NewReader(c.data), io.MultiReader(bytes.NewReader(c.data))} {
+			if a, err := moovAtEnd(r); err != nil || a != c.atEnd {
+				t.Errorf("%s: expected %v but got %v, %v", c.name, c.atEnd, a, err)
+			}
+		}
+	}
+	if _, err := moovAtEnd(bytes.NewReader(atom("ftyp", nil)[:4])); err == nil {
+		t.Error("expected error for truncated atom")
+	}
+}
@@ -0,0 +1,45 @@
+package dms
+
+import (
+	"io/fs"
+	"net/http"
+)
+
+const favoritesAPIPath = "/api/favorites"
+
+// Files and folders pinned for quick access, listed in the Favorites
+// container on every renderer. The zero value is ready for use.
+type Favorites struct {
+	pathList
+}
+
+// Returns the favorites that still exist.
+func (me *Server) favorites(client string) (paths []string) {
+	if me.Favorites == nil {
+		return
+	}
+	for _, p := range me.Favorites.list() {
+		if _, err := fs.Stat(me.FS, p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return
+}
+
+// Returns the favorites the client may see.
+func (me *Server) visibleFavorites(client string) (ret []string) {
+	for _, p := range me.favorites(client) {
+		if !me.hiddenFrom(client, p) {
+			ret = append(ret, p)
+		}
+	}
+	return
+}
+
+func (me *Server) serveFavoritesAPI(w http.ResponseWriter, r *http.Request) {
+	if me.Favorites == nil {
+		http.Error(w, "favorites disabled", http.StatusNotFound)
+		return
+	}
+	me.servePathListAPI(w, r, &me.Favorites.pathList, me.visibleFavorites)
+}
@@ -0,0 +1,77 @@
+package dms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestFavorites(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv":           {},
+			"Music/Kind of Blue/1.mp3": {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		Favorites:      &Favorites{},
+	}
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	do := func(method, target string, form url.Values) (paths []string, code int) {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		json.Unmarshal(w.Body.Bytes(), &paths)
+		return paths, w.Code
+	}
+	if _, code := do("POST", favoritesAPIPath, url.Values{"path": {"/Films/Heat.mkv"}}); code != http.StatusOK {
+		t.Fatalf("adding: %d", code)
+	}
+	if _, code := do("POST", favoritesAPIPath, url.Values{"path": {"/Films/Gone.mkv"}}); code != http.StatusNotFound {
+		t.Fatalf("adding a missing file: %d", code)
+	}
+	paths, _ := do("POST", favoritesAPIPath, url.Values{"path": {"/Music/Kind of Blue"}})
+	if want := []string{"/Music/Kind of Blue", "/Films/Heat.mkv"}; !slices.Equal(paths, want) {
+		t.Fatalf("got %q, want %q", paths, want)
+	}
+
+	cdService := &contentDirectoryService{Server: s}
+	vc, _ := s.virtualContainerByID(virtualIDPrefix + "favorites")
+	objs := cdService.virtualContainerChildren(context.Background(), vc, "localhost", "", "")
+	if len(objs) != 2 {
+		t.Fatalf("got %+v", objs)
+	}
+	if c, ok := objs[0].(upnpav.Container); !ok || c.Title != "Kind of Blue" || c.ParentID != vc.ID {
+		t.Errorf("got %+v", objs[0])
+	}
+
+	path := filepath.Join(t.TempDir(), "favorites")
+	if err := s.Favorites.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := do("DELETE", favoritesAPIPath+"?path="+url.QueryEscape("/Films/Heat.mkv"), nil); len(paths) != 1 {
+		t.Fatalf("after removing: %q", paths)
+	}
+	var loaded Favorites
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if a := loaded.list(); len(a) != 2 {
+		t.Errorf("loaded %q", a)
+	}
+}
@@ -0,0 +1,56 @@
+package dms
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// The values a FriendlyName template can refer to, such as "{{.Share}} on
+// {{.Hostname}}".
+type FriendlyNameData struct {
+	Hostname string
+	// The name of the user running the server.
+	User string
+	// The name of the root folder.
+	Share string
+}
+
+// Returns the values for a FriendlyName template, for a server sharing root.
+func friendlyNameData(root string) (ret FriendlyNameData) {
+	ret.Hostname, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		ret.User = u.Name
+		if ret.User == "" {
+			ret.User = u.Username
+		}
+	}
+	share := filepath.Base(root)
+	if vol := filepath.VolumeName(root); share == string(filepath.Separator) && strings.HasPrefix(vol, `\\`) {
+		// The root of a UNC share, \\host\share.
+		share = vol[strings.LastIndexAny(vol, `\/`)+1:]
+	}
+	if share != "." && share != string(filepath.Separator) {
+		ret.Share = share
+	}
+	return
+}
+
+// Expands the FriendlyName template. Names without actions are used as they
+// are.
+func expandFriendlyName(name string, data FriendlyNameData) (string, error) {
+	if !strings.Contains(name, "{{") {
+		return name, nil
+	}
+	t, err := template.New("FriendlyName").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
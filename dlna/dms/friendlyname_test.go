@@ -0,0 +1,33 @@
+package dms
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandFriendlyName(t *testing.T) {
+	data := FriendlyNameData{Hostname: "nas", User: "Alice", Share: "Films"}
+	for _, c := range []struct {
+		name, want string
+	}{
+		{"Living room", "Living room"},
+		{"{{.Share}} on {{.Hostname}}", "Films on nas"},
+		{"{{.User}}'s {{.Share}}", "Alice's Films"},
+	} {
+		got, err := expandFriendlyName(c.name, data)
+		if err != nil || got != c.want {
+			t.Errorf("%q: got %q, %v, want %q", c.name, got, err, c.want)
+		}
+	}
+	for _, name := range []string{"{{.Share", "{{.Host}}"} {
+		if _, err := expandFriendlyName(name, data); err == nil {
+			t.Errorf("%q: no error", name)
+		}
+	}
+	if got := friendlyNameData(filepath.FromSlash("/srv/media/Films")).Share; got != "Films" {
+		t.Errorf("got share %q", got)
+	}
+	if got := friendlyNameData(".").Share; got != "" {
+		t.Errorf("got share %q for .", got)
+	}
+}
@@ -0,0 +1,71 @@
+package dms
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// A file of an fs.FS that can't seek, such as one in a zip.Reader, read as
+// if it could, so that range requests can be served from it. Seeking back
+// reopens the file, and seeking forward reads past what's skipped, which is
+// slow, but players mostly read on from where they are.
+type reopeningFile struct {
+	fsys fs.FS
+	name string
+	size int64
+	f    fs.File
+	// Where f has been read to, and where the next read is from.
+	at, off int64
+}
+
+func (me *reopeningFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += me.off
+	case io.SeekEnd:
+		offset += me.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	me.off = offset
+	return offset, nil
+}
+
+func (me *reopeningFile) Read(b []byte) (n int, err error) {
+	if me.f == nil {
+		return 0, fs.ErrClosed
+	}
+	if me.off < me.at {
+		me.f.Close()
+		me.f, err = me.fsys.Open(me.name)
+		if err != nil {
+			return 0, err
+		}
+		me.at = 0
+	}
+	if me.off > me.at {
+		skipped, err := io.CopyN(io.Discard, me.f, me.off-me.at)
+		me.at += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = me.f.Read(b)
+	me.at += int64(n)
+	me.off = me.at
+	return
+}
+
+func (me *reopeningFile) Close() error {
+	if me.f == nil {
+		return fs.ErrClosed
+	}
+	err := me.f.Close()
+	me.f = nil
+	return err
+}
@@ -0,0 +1,75 @@
+package dms
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeFileZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("Music/a.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("0123456789"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{FS: zr}
+	for _, c := range []struct {
+		rng  string
+		code int
+		body string
+	}{
+		{"", http.StatusOK, "0123456789"},
+		{"bytes=6-8", http.StatusPartialContent, "678"},
+		{"bytes=-3", http.StatusPartialContent, "789"},
+	} {
+		r := httptest.NewRequest("GET", "/res?path=Music%2Fa.mp3", nil)
+		if c.rng != "" {
+			r.Header.Set("Range", c.rng)
+		}
+		rec := httptest.NewRecorder()
+		s.serveFile(rec, r, "Music/a.mp3")
+		if rec.Code != c.code || rec.Body.String() != c.body {
+			t.Errorf("range %q: got %d %q", c.rng, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestReopeningFileSeekBack(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("a")
+	w.Write([]byte("abcdef"))
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf := &reopeningFile{fsys: zr, name: "a", size: 6, f: f}
+	defer rf.Close()
+	b := make([]byte, 2)
+	for _, c := range []struct {
+		off  int64
+		want string
+	}{{4, "ef"}, {1, "bc"}, {3, "de"}} {
+		rf.Seek(c.off, 0)
+		if n, err := rf.Read(b); string(b[:n]) != c.want || err != nil && err != io.EOF {
+			t.Errorf("at %d read %q, %v", c.off, b[:n], err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package dms
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Namespace of the DIDL-Lite desc element carrying gapless information.
+const gaplessDescNameSpace = "urn:schemas-anacrolix-com:dms:gapless"
+
+// The encoder delay and padding of an audio file, which a gapless player trims
+// from the decoded stream.
+type gaplessInfo struct {
+	// In samples.
+	Delay   int64
+	Padding int64
+	// The samples of real audio, excluding the delay and padding.
+	Samples    int64
+	SampleRate int64
+}
+
+// Returns the exact length of the audio.
+func (g gaplessInfo) Duration() time.Duration {
+	return time.Duration(g.Samples * int64(time.Second) / g.SampleRate)
+}
+
+func (g gaplessInfo) desc() upnpav.Desc {
+	return upnpav.Desc{
+		ID:        "gapless",
+		NameSpace: gaplessDescNameSpace,
+		Content:   fmt.Sprintf("delay=%d padding=%d samples=%d rate=%d", g.Delay, g.Padding, g.Samples, g.SampleRate),
+	}
+}
+
+// Returns the gapless information for an audio file, and whether it's known.
+// AAC encoders record it in an iTunSMPB tag, and lossless formats count their
+// samples exactly.
+func probeGapless(info *ffprobe.Info) (g gaplessInfo, ok bool) {
+	a := firstStream(info, "audio")
+	if a == nil {
+		return
+	}
+	g.SampleRate = streamInt(a, "sample_rate")
+	if g.SampleRate <= 0 {
+		return
+	}
+	if s, found := audioTag(info, "itunsmpb"); found {
+		// Hex fields: reserved, delay, padding, samples, then more reserved.
+		fields := strings.Fields(s)
+		if len(fields) < 4 {
+			return
+		}
+		var vals [3]int64
+		for i := range vals {
+			v, err := strconv.ParseInt(fields[i+1], 16, 64)
+			if err != nil {
+				return
+			}
+			vals[i] = v
+		}
+		g.Delay, g.Padding, g.Samples = vals[0], vals[1], vals[2]
+		return g, g.Samples > 0
+	}
+	if streamString(a, "time_base") == "1/"+strconv.FormatInt(g.SampleRate, 10) {
+		if g.Samples = streamInt(a, "duration_ts"); g.Samples > 0 {
+			g.Delay = streamInt(a, "initial_padding")
+			return g, true
+		}
+	}
+	return
+}
@@ -0,0 +1,28 @@
+package dms
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func TestProbeGapless(t *testing.T) {
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{"tags":{"iTunSMPB":" 00000000 00000840 000001CA 00000000001FAE76 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000"}},"streams":[{"codec_type":"audio","codec_name":"aac","sample_rate":"44100"}]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	g, ok := probeGapless(&info)
+	if !ok || g.Delay != 2112 || g.Padding != 458 || g.Samples != 0x1fae76 {
+		t.Fatalf("unexpected gapless info %+v, %v", g, ok)
+	}
+	info = ffprobe.Info{}
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[{"codec_type":"audio","codec_name":"flac","sample_rate":"44100","time_base":"1/44100","duration_ts":441000}]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	g, ok = probeGapless(&info)
+	if !ok || g.Duration() != 10*time.Second {
+		t.Fatalf("unexpected gapless info %+v, %v", g, ok)
+	}
+}
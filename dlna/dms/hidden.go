@@ -0,0 +1,36 @@
+package dms
+
+import (
+	"net/http"
+)
+
+const hiddenAPIPath = "/api/hidden"
+
+// Files and folders kept out of the ContentDirectory for every client,
+// without touching them on disk. The zero value is ready for use.
+type HiddenPaths struct {
+	pathList
+}
+
+// Reports whether the file or folder is in HiddenPaths.
+func (me *Server) softHidden(filePath string) bool {
+	return me.HiddenPaths != nil && me.HiddenPaths.containsWithin(filePath)
+}
+
+// Returns the hidden paths the client could see otherwise.
+func (me *Server) visibleHiddenPaths(client string) (ret []string) {
+	for _, p := range me.HiddenPaths.list() {
+		if !me.restrictedFrom(client, p) {
+			ret = append(ret, p)
+		}
+	}
+	return
+}
+
+func (me *Server) serveHiddenAPI(w http.ResponseWriter, r *http.Request) {
+	if me.HiddenPaths == nil {
+		http.Error(w, "hiding disabled", http.StatusNotFound)
+		return
+	}
+	me.servePathListAPI(w, r, &me.HiddenPaths.pathList, me.visibleHiddenPaths)
+}
@@ -0,0 +1,94 @@
+package dms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestHiddenPaths(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv":     {},
+			"Films/Old/Cats.mkv": {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		Search:         &search.Index{},
+		HiddenPaths:    &HiddenPaths{},
+	}
+	s.indexLibrary()
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	do := func(method, target string, form url.Values) (paths []string, code int) {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		json.Unmarshal(w.Body.Bytes(), &paths)
+		return paths, w.Code
+	}
+	paths, code := do("POST", hiddenAPIPath, url.Values{"path": {"/Films/Old"}})
+	if code != http.StatusOK || !slices.Equal(paths, []string{"/Films/Old"}) {
+		t.Fatalf("hiding: %d %q", code, paths)
+	}
+	if _, code := do("POST", hiddenAPIPath, url.Values{"path": {"/"}}); code == http.StatusOK {
+		t.Fatal("hid the root")
+	}
+
+	cdService := &contentDirectoryService{Server: s}
+	obj, _ := cdService.objectFromID("Films")
+	objs, err := cdService.browseChildren(context.Background(), "Films", obj, "localhost", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].(upnpav.Item).Title != "Heat.mkv" {
+		t.Errorf("browsed %+v", objs)
+	}
+	_, total, err := cdService.search(context.Background(), cds.SearchArgs{
+		ContainerID:    "0",
+		SearchCriteria: `upnp:class derivedfrom "object.item.videoItem"`,
+	}, "localhost", "", "")
+	if err != nil || total != 1 {
+		t.Errorf("searched %d videos: %v", total, err)
+	}
+	req := httptest.NewRequest("GET", "/res?path="+url.QueryEscape("Films/Old/Cats.mkv"), nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("fetching a hidden file got %d", w.Code)
+	}
+
+	path := filepath.Join(t.TempDir(), "hidden")
+	if err := s.HiddenPaths.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := do("POST", hiddenAPIPath, url.Values{"path": {"/Films/Old"}, "remove": {"1"}}); len(paths) != 0 {
+		t.Fatalf("after showing: %q", paths)
+	}
+	if s.softHidden("Films/Old/Cats.mkv") {
+		t.Error("still hidden")
+	}
+	var loaded HiddenPaths
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.containsWithin("Films/Old/Cats.mkv") {
+		t.Errorf("loaded %q", loaded.list())
+	}
+}
@@ -0,0 +1,296 @@
+package dms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+const (
+	// Streams a video as HLS, in the qualities of transcode.HLSLadder.
+	hlsPath = "/hls"
+	// Of the playlists.
+	hlsMimeType = "application/vnd.apple.mpegurl"
+	// The length of HLS segments, except the last.
+	hlsSegmentDuration = 6 * time.Second
+	// The name acquireEncoder is given for HLS segments, and client profiles
+	// order the HLS resource by.
+	hlsTranscodeName = "hls"
+	// How long a player's HLS segments are kept after it last fetched one.
+	hlsSessionIdle = 2 * time.Minute
+	// The most segments kept for a player's stream, those fetched longest ago
+	// going first. At the top of the ladder, they're about 4MB each.
+	hlsSessionSegments = 10
+)
+
+// Serves a video given by the path query parameter as HLS. Without a variant
+// query parameter, it's the master playlist listing the variants up to the
+// video's height, for players to switch between as their throughput allows.
+// With one, it's the variant's playlist, or with a segment too, its segment
+// transcoded. Other query parameters, such as audioTrack, are passed on.
+func (me *Server) serveHLS(w http.ResponseWriter, r *http.Request) {
+	if me.NoTranscode || me.NoProbe {
+		http.Error(w, "HLS needs transcoding and probing", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	filePath := me.filePath(query.Get("path"))
+	if ignored, err := me.IgnorePath(filePath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if ignored || me.hiddenFrom(playbackClient(r), filePath) {
+		http.Error(w, "no such object", http.StatusNotFound)
+		return
+	}
+	if mt, err := me.mimeTypeByPath(filePath); err != nil || !mt.IsVideo() {
+		http.Error(w, "not a video", http.StatusNotFound)
+		return
+	}
+	info, err := me.ffmpegProbe(r.Context(), filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	duration, err := info.Duration()
+	if err != nil || duration <= 0 || !hasMovingVideo(info) {
+		http.Error(w, "can't stream as HLS", http.StatusNotFound)
+		return
+	}
+	name := query.Get("variant")
+	if name == "" {
+		me.serveHLSPlaylist(w, r, hlsMasterPlaylist(query, info))
+		return
+	}
+	i := slices.IndexFunc(transcode.HLSLadder, func(v transcode.HLSVariant) bool { return v.Name == name })
+	if i == -1 {
+		http.Error(w, "no such variant", http.StatusNotFound)
+		return
+	}
+	v := transcode.HLSLadder[i]
+	segments := int((duration + hlsSegmentDuration - 1) / hlsSegmentDuration)
+	if !query.Has("segment") {
+		me.serveHLSPlaylist(w, r, hlsVariantPlaylist(query, duration, segments))
+		return
+	}
+	segment, err := strconv.Atoi(query.Get("segment"))
+	if err != nil || segment < 0 || segment >= segments {
+		http.Error(w, "no such segment", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	if r.Method == "HEAD" {
+		return
+	}
+	opts := transcode.Options{AudioOptions: me.clientAudioOptions(r.UserAgent(), info, me.audioTrack(r, info))}
+	opts.Subtitles, opts.SubtitlesArePictures = me.subtitleTrack(r, info)
+	opts.Rotation = videoRotation(info)
+	opts.Deinterlace = me.deinterlaceFilter(r.UserAgent(), info)
+	transcodeSegment := func(segment int) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			opts := opts
+			var release func()
+			opts.HWEncoder, _, release = me.acquireEncoder(hlsTranscodeName)
+			defer release()
+			start := time.Duration(segment) * hlsSegmentDuration
+			session := me.startTranscodeSession(r, filePath, hlsTranscodeName, time.Now(), "")
+			// Other requests may wait for it, so it isn't stopped with
+			// this one.
+			p, err := transcode.HLSSegment(me.closedContext(), me.loopbackResURL(filePath), v, start, min(hlsSegmentDuration, duration-start), opts, nil)
+			if err != nil {
+				me.endTranscodeSession(session, nil, err)
+				return nil, err
+			}
+			var b bytes.Buffer
+			_, err = io.Copy(session.writer(&b), p)
+			p.Close()
+			me.endTranscodeSession(session, p, nil)
+			if err == nil && b.Len() == 0 {
+				err = errors.New("no output")
+			}
+			return b.Bytes(), err
+		}
+	}
+	// The segments are kept for the player's stream: the variant with the
+	// tracks it picks.
+	streamQuery := maps.Clone(query)
+	delete(streamQuery, "segment")
+	key := hlsSessionKey{playbackClient(r), streamQuery.Encode()}
+	seg := me.hlsSessions.segment(key, segment, transcodeSegment(segment))
+	if segment+1 < segments {
+		// Ready for when the player's done with this one.
+		me.hlsSessions.segment(key, segment+1, transcodeSegment(segment+1))
+	}
+	select {
+	case <-seg.ready:
+	case <-r.Context().Done():
+		return
+	}
+	if seg.err != nil {
+		me.Logger.Levelf(log.Warning, "streaming %q as HLS: %v", filePath, seg.err)
+		http.Error(w, seg.err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(seg.data)))
+	w.Write(seg.data)
+}
+
+// Identifies a player's HLS stream: the client, and the query of the variant
+// with the tracks it picks.
+type hlsSessionKey struct {
+	client string
+	query  string
+}
+
+// The segments transcoded for a player's HLS stream, so that those fetched
+// again, as after seeking back or retrying, aren't transcoded again, and so
+// the next is transcoded while the player plays the last.
+type hlsSession struct {
+	segments map[int]*hlsSegment
+	// Forgets the session when it's idle.
+	timer *time.Timer
+}
+
+type hlsSegment struct {
+	// Closed when the data or err is set.
+	ready chan struct{}
+	data  []byte
+	err   error
+	used  time.Time
+}
+
+// Reports whether the segment's transcode has failed, so it's tried again.
+func (me *hlsSegment) failed() bool {
+	select {
+	case <-me.ready:
+		return me.err != nil
+	default:
+		return false
+	}
+}
+
+// Keeps the hlsSessions. The zero value is ready for use.
+type hlsSessions struct {
+	mu sync.Mutex
+	m  map[hlsSessionKey]*hlsSession
+}
+
+// Returns the segment of the stream, starting transcoding it if it isn't kept
+// already.
+func (me *hlsSessions) segment(key hlsSessionKey, i int, transcode func() ([]byte, error)) *hlsSegment {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s, ok := me.m[key]
+	if ok {
+		s.timer.Reset(hlsSessionIdle)
+	} else {
+		if me.m == nil {
+			me.m = make(map[hlsSessionKey]*hlsSession)
+		}
+		s = &hlsSession{segments: make(map[int]*hlsSegment)}
+		s.timer = time.AfterFunc(hlsSessionIdle, func() {
+			me.mu.Lock()
+			defer me.mu.Unlock()
+			if me.m[key] == s {
+				delete(me.m, key)
+			}
+		})
+		me.m[key] = s
+	}
+	seg, ok := s.segments[i]
+	if !ok || seg.failed() {
+		seg = &hlsSegment{ready: make(chan struct{})}
+		s.segments[i] = seg
+		go func() {
+			seg.data, seg.err = transcode()
+			close(seg.ready)
+		}()
+	}
+	seg.used = time.Now()
+	for len(s.segments) > hlsSessionSegments {
+		oldest := -1
+		for j, seg := range s.segments {
+			if oldest == -1 || seg.used.Before(s.segments[oldest].used) {
+				oldest = j
+			}
+		}
+		delete(s.segments, oldest)
+	}
+	return seg
+}
+
+func (me *Server) serveHLSPlaylist(w http.ResponseWriter, r *http.Request, playlist []byte) {
+	w.Header().Set("Content-Type", hlsMimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+	if r.Method != "HEAD" {
+		w.Write(playlist)
+	}
+}
+
+// Returns the URL, relative to hlsPath, of the HLS request with the query and
+// the given parameters set.
+func hlsURL(query url.Values, params ...string) string {
+	// Set replaces the values, so they aren't shared.
+	query = maps.Clone(query)
+	for i := 0; i+1 < len(params); i += 2 {
+		query.Set(params[i], params[i+1])
+	}
+	return hlsPath[1:] + "?" + query.Encode()
+}
+
+// Returns the variants of the ladder worth offering for a video of the
+// height: those no taller than it, or the smallest if they all are. All are
+// offered if the height isn't known.
+func hlsVariants(height int) (ret []transcode.HLSVariant) {
+	for _, v := range transcode.HLSLadder {
+		if height == 0 || v.Height <= height {
+			ret = append(ret, v)
+		}
+	}
+	if len(ret) == 0 {
+		ret = transcode.HLSLadder[len(transcode.HLSLadder)-1:]
+	}
+	return
+}
+
+func hlsMasterPlaylist(query url.Values, info *ffprobe.Info) []byte {
+	video := movingVideoStream(info)
+	width, height := streamInt(video, "width"), streamInt(video, "height")
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range hlsVariants(int(height)) {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,", v.Bandwidth())
+		if width != 0 && height != 0 {
+			// Scaling keeps the aspect ratio, and an even width.
+			w := int(math.Round(float64(width)*float64(v.Height)/float64(height)/2)) * 2
+			fmt.Fprintf(&b, "RESOLUTION=%dx%d,", w, v.Height)
+		}
+		fmt.Fprintf(&b, "CODECS=%q\n%s\n", transcode.HLSCodecs, hlsURL(query, "variant", v.Name))
+	}
+	return b.Bytes()
+}
+
+func hlsVariantPlaylist(query url.Values, duration time.Duration, segments int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n",
+		int(hlsSegmentDuration/time.Second))
+	for i := range segments {
+		length := min(hlsSegmentDuration, duration-time.Duration(i)*hlsSegmentDuration)
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", length.Seconds(), hlsURL(query, "segment", strconv.Itoa(i)))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.Bytes()
+}
@@ -0,0 +1,103 @@
+package dms
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func TestHLSPlaylists(t *testing.T) {
+	query := url.Values{"path": {"Films/Heat.mkv"}, "audioTrack": {"2"}}
+	info := &ffprobe.Info{Streams: []map[string]interface{}{
+		{"codec_type": "video", "width": float64(1280), "height": float64(544)},
+	}}
+	master := string(hlsMasterPlaylist(query, info))
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-STREAM-INF:BANDWIDTH=1528000,RESOLUTION=1130x480,CODECS="avc1.640028,mp4a.40.2"
+hls?audioTrack=2&path=Films%2FHeat.mkv&variant=480p
+#EXT-X-STREAM-INF:BANDWIDTH=796000,RESOLUTION=848x360,CODECS="avc1.640028,mp4a.40.2"
+hls?audioTrack=2&path=Films%2FHeat.mkv&variant=360p
+`
+	if master != want {
+		t.Errorf("got master playlist\n%s", master)
+	}
+	if vs := hlsVariants(240); len(vs) != 1 || vs[0].Name != "360p" {
+		t.Errorf("got %v", vs)
+	}
+	if vs := hlsVariants(0); len(vs) != 4 {
+		t.Errorf("got %v", vs)
+	}
+
+	query.Set("variant", "480p")
+	playlist := string(hlsVariantPlaylist(query, 14*time.Second, 3))
+	if !strings.Contains(playlist, "#EXTINF:6.000,\nhls?audioTrack=2&path=Films%2FHeat.mkv&segment=1&variant=480p\n") ||
+		!strings.Contains(playlist, "#EXTINF:2.000,\nhls?audioTrack=2&path=Films%2FHeat.mkv&segment=2&variant=480p\n#EXT-X-ENDLIST\n") {
+		t.Errorf("got variant playlist\n%s", playlist)
+	}
+}
+
+func TestHLSSessions(t *testing.T) {
+	var sessions hlsSessions
+	transcodes := 0
+	transcode := func(data string, err error) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			transcodes++
+			return []byte(data), err
+		}
+	}
+	get := func(key hlsSessionKey, i int, f func() ([]byte, error)) *hlsSegment {
+		seg := sessions.segment(key, i, f)
+		<-seg.ready
+		return seg
+	}
+	player := hlsSessionKey{"192.168.1.2", "path=a.mkv&variant=720p"}
+	if seg := get(player, 0, transcode("0", nil)); string(seg.data) != "0" || transcodes != 1 {
+		t.Fatalf("got %q after %d transcodes", seg.data, transcodes)
+	}
+	if seg := get(player, 0, transcode("again", nil)); string(seg.data) != "0" || transcodes != 1 {
+		t.Errorf("fetching again got %q after %d transcodes", seg.data, transcodes)
+	}
+	if seg := get(hlsSessionKey{"192.168.1.3", player.query}, 0, transcode("other", nil)); string(seg.data) != "other" {
+		t.Errorf("another player got %q", seg.data)
+	}
+	if seg := get(player, 1, transcode("", errors.New("failed"))); seg.err == nil {
+		t.Error("failure not kept")
+	}
+	if seg := get(player, 1, transcode("1", nil)); string(seg.data) != "1" {
+		t.Errorf("retrying got %q, %v", seg.data, seg.err)
+	}
+	for i := range hlsSessionSegments {
+		get(player, 2+i, transcode("", nil))
+	}
+	if n := len(sessions.m[player].segments); n != hlsSessionSegments {
+		t.Errorf("kept %d segments", n)
+	}
+	transcodes = 0
+	if get(player, 0, transcode("0", nil)); transcodes != 1 {
+		t.Error("the oldest segment was kept")
+	}
+}
+
+func TestHLSResource(t *testing.T) {
+	s := &Server{ClientProfiles: []ClientProfile{{UserAgent: "Safari", Resources: []string{"hls", "raw"}}}}
+	info := &ffprobe.Info{Streams: []map[string]interface{}{{"codec_type": "video", "width": float64(1920), "height": float64(1080)}}}
+	hls := func(userAgent string) *namedResource {
+		for _, r := range s.transcodeResources("localhost", "Films/Heat.mkv", "video/x-matroska", "1920x1080", "1:00:00", userAgent, info) {
+			if r.name == "hls" {
+				return &r
+			}
+		}
+		return nil
+	}
+	if r := hls("Safari"); r == nil || r.URL != "http://localhost/hls?path=Films%2FHeat.mkv" || !strings.HasPrefix(r.ProtocolInfo, "http-get:*:"+hlsMimeType+":") {
+		t.Errorf("got %+v", r)
+	}
+	if r := hls("TV"); r != nil {
+		t.Errorf("offered to a client not known to take it: %+v", r)
+	}
+}
@@ -1,19 +1,97 @@
 package dms
 
 import (
+	"fmt"
 	"html/template"
 )
 
+// Formats a size in bytes for people, as in "1.5 GB".
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	for _, prefix := range "kMGTP" {
+		f /= unit
+		if f < unit || prefix == 'P' {
+			return fmt.Sprintf("%.1f %cB", f, prefix)
+		}
+	}
+	panic("unreachable")
+}
+
 var rootTmpl *template.Template
 
 func init() {
-	rootTmpl = template.Must(template.New("root").Parse(
-		`<form method="post">
-			Path: <input type="text"
+	rootTmpl = template.Must(template.New("root").Funcs(template.FuncMap{
+		"translate": translate,
+		"size":      formatSize,
+	}).Parse(
+		`<form method="post" lang="{{.Lang}}">
+			{{translate .Lang "Path"}}: <input type="text"
 				name="path"
 				{{if .Readonly}} readonly="readonly"{{end}}
 				value="{{.Path}}"
 			/>
-			<input type="submit" value="Update"{{if .Readonly}} disabled="disabled"{{end}}/>
-		</form>`))
+			<input type="submit" value="{{translate .Lang "Update"}}"{{if .Readonly}} disabled="disabled"{{end}}/>
+		</form>
+		{{if .Protected}}<form method="post" action="/api/unlock" lang="{{.Lang}}">
+			<input type="hidden" name="redirect" value="1"/>
+			{{translate .Lang "Device"}}: {{.Client}}
+			{{translate .Lang "PIN"}}: <input type="password" name="pin" inputmode="numeric"/>
+			<input type="submit" value="{{translate .Lang "Unlock"}}"/>
+		</form>{{end}}
+		{{if .FavoritesEnabled}}<div lang="{{.Lang}}">
+			<h2>{{translate .Lang "Favorites"}}</h2>
+			{{range .Favorites}}<form method="post" action="/api/favorites">
+				<input type="hidden" name="redirect" value="1"/>
+				<input type="hidden" name="remove" value="1"/>
+				<input type="hidden" name="path" value="/{{.}}"/>
+				/{{.}} <input type="submit" value="{{translate $.Lang "Remove"}}"/>
+			</form>{{end}}
+			<form method="post" action="/api/favorites">
+				<input type="hidden" name="redirect" value="1"/>
+				{{translate .Lang "Path"}}: <input type="text" name="path"/>
+				<input type="submit" value="{{translate .Lang "Add"}}"/>
+			</form>
+		</div>{{end}}
+		{{if .HidingEnabled}}<div lang="{{.Lang}}">
+			<h2>{{translate .Lang "Hidden"}}</h2>
+			{{range .Hidden}}<form method="post" action="/api/hidden">
+				<input type="hidden" name="redirect" value="1"/>
+				<input type="hidden" name="remove" value="1"/>
+				<input type="hidden" name="path" value="/{{.}}"/>
+				/{{.}} <input type="submit" value="{{translate $.Lang "Show"}}"/>
+			</form>{{end}}
+			<form method="post" action="/api/hidden">
+				<input type="hidden" name="redirect" value="1"/>
+				{{translate .Lang "Path"}}: <input type="text" name="path"/>
+				<input type="submit" value="{{translate .Lang "Hide"}}"/>
+			</form>
+		</div>{{end}}
+		{{with .Renderers}}<div lang="{{$.Lang}}">
+			<h2>{{translate $.Lang "Play to"}}</h2>
+			<form method="post" action="/api/playto">
+				<input type="hidden" name="redirect" value="1"/>
+				{{translate $.Lang "Renderer"}}: <select name="renderer">
+					{{range .}}<option value="{{.UDN}}">{{.FriendlyName}}</option>{{end}}
+				</select>
+				{{translate $.Lang "Path"}}: <input type="text" name="path"/>
+				<button type="submit" name="action" value="play">{{translate $.Lang "Play"}}</button>
+				<button type="submit" name="action" value="pause">{{translate $.Lang "Pause"}}</button>
+				<button type="submit" name="action" value="stop">{{translate $.Lang "Stop"}}</button>
+				<input type="number" name="position" min="0" step="1" value="0"/>
+				<button type="submit" name="action" value="seek">{{translate $.Lang "Seek"}}</button>
+			</form>
+		</div>{{end}}
+		{{with .Library}}<div lang="{{$.Lang}}">
+			<h2>{{translate $.Lang "Library"}}</h2>
+			<p>{{translate $.Lang "Files"}}: {{.Files}}, {{size .TotalSize}}</p>
+			<p>{{range $k, $v := .Types}}{{$k}}: {{$v}} {{end}}</p>
+			<p>{{range $k, $v := .Codecs}}{{$k}}: {{$v}} {{end}}</p>
+			<p>{{range $k, $v := .Resolutions}}{{$k}}: {{$v}} {{end}}</p>
+			<p>{{translate $.Lang "Videos without subtitles"}}: {{.MissingSubtitles}}</p>
+			<p>{{translate $.Lang "Audio without artwork"}}: {{.MissingArtwork}}</p>
+		</div>{{end}}`))
 }
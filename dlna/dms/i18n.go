@@ -0,0 +1,344 @@
+package dms
+
+import (
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Translations of the titles of containers dms makes up, and of the web UI,
+// keyed by language and then by the English text.
+var translations = map[string]map[string]string{
+	// The untranslated text.
+	defaultLanguage: {},
+	"de": {
+		"Continue listening":       "Weiterhören",
+		"Continue watching":        "Weiterschauen",
+		"Most played":              "Meistgespielt",
+		"Music":                    "Musik",
+		"Videos":                   "Videos",
+		"Photos":                   "Fotos",
+		"Path":                     "Pfad",
+		"Update":                   "Aktualisieren",
+		"Device":                   "Gerät",
+		"PIN":                      "PIN",
+		"Unlock":                   "Entsperren",
+		"Favorites":                "Favoriten",
+		"Add":                      "Hinzufügen",
+		"Remove":                   "Entfernen",
+		"Library":                  "Mediathek",
+		"Files":                    "Dateien",
+		"Videos without subtitles": "Videos ohne Untertitel",
+		"Audio without artwork":    "Audio ohne Cover",
+		"Hidden":                   "Ausgeblendet",
+		"Hide":                     "Ausblenden",
+		"Show":                     "Anzeigen",
+		"Places":                   "Orte",
+		"Slideshow":                "Diashow",
+		"Play to":                  "Abspielen auf",
+		"Renderer":                 "Wiedergabegerät",
+		"Play":                     "Abspielen",
+		"Pause":                    "Pause",
+		"Stop":                     "Stopp",
+		"Seek":                     "Springen",
+	},
+	"es": {
+		"Continue listening":       "Seguir escuchando",
+		"Continue watching":        "Seguir viendo",
+		"Most played":              "Más reproducidos",
+		"Music":                    "Música",
+		"Videos":                   "Vídeos",
+		"Photos":                   "Fotos",
+		"Path":                     "Ruta",
+		"Update":                   "Actualizar",
+		"Device":                   "Dispositivo",
+		"PIN":                      "PIN",
+		"Unlock":                   "Desbloquear",
+		"Favorites":                "Favoritos",
+		"Add":                      "Añadir",
+		"Remove":                   "Quitar",
+		"Library":                  "Biblioteca",
+		"Files":                    "Archivos",
+		"Videos without subtitles": "Vídeos sin subtítulos",
+		"Audio without artwork":    "Audio sin carátula",
+		"Hidden":                   "Ocultos",
+		"Hide":                     "Ocultar",
+		"Show":                     "Mostrar",
+		"Places":                   "Lugares",
+		"Slideshow":                "Presentación",
+		"Play to":                  "Reproducir en",
+		"Renderer":                 "Reproductor",
+		"Play":                     "Reproducir",
+		"Pause":                    "Pausa",
+		"Stop":                     "Detener",
+		"Seek":                     "Saltar",
+	},
+	"fr": {
+		"Continue listening":       "Reprendre l'écoute",
+		"Continue watching":        "Reprendre la lecture",
+		"Most played":              "Les plus écoutés",
+		"Music":                    "Musique",
+		"Videos":                   "Vidéos",
+		"Photos":                   "Photos",
+		"Path":                     "Chemin",
+		"Update":                   "Mettre à jour",
+		"Device":                   "Appareil",
+		"PIN":                      "Code PIN",
+		"Unlock":                   "Déverrouiller",
+		"Favorites":                "Favoris",
+		"Add":                      "Ajouter",
+		"Remove":                   "Retirer",
+		"Library":                  "Bibliothèque",
+		"Files":                    "Fichiers",
+		"Videos without subtitles": "Vidéos sans sous-titres",
+		"Audio without artwork":    "Audio sans pochette",
+		"Hidden":                   "Masqués",
+		"Hide":                     "Masquer",
+		"Show":                     "Afficher",
+		"Places":                   "Lieux",
+		"Slideshow":                "Diaporama",
+		"Play to":                  "Lire sur",
+		"Renderer":                 "Lecteur",
+		"Play":                     "Lire",
+		"Pause":                    "Pause",
+		"Stop":                     "Arrêter",
+		"Seek":                     "Aller à",
+	},
+	"it": {
+		"Continue listening":       "Continua ad ascoltare",
+		"Continue watching":        "Continua a guardare",
+		"Most played":              "I più ascoltati",
+		"Music":                    "Musica",
+		"Videos":                   "Video",
+		"Photos":                   "Foto",
+		"Path":                     "Percorso",
+		"Update":                   "Aggiorna",
+		"Device":                   "Dispositivo",
+		"PIN":                      "PIN",
+		"Unlock":                   "Sblocca",
+		"Favorites":                "Preferiti",
+		"Add":                      "Aggiungi",
+		"Remove":                   "Rimuovi",
+		"Library":                  "Libreria",
+		"Files":                    "File",
+		"Videos without subtitles": "Video senza sottotitoli",
+		"Audio without artwork":    "Audio senza copertina",
+		"Hidden":                   "Nascosti",
+		"Hide":                     "Nascondi",
+		"Show":                     "Mostra",
+		"Places":                   "Luoghi",
+		"Slideshow":                "Presentazione",
+		"Play to":                  "Riproduci su",
+		"Renderer":                 "Riproduttore",
+		"Play":                     "Riproduci",
+		"Pause":                    "Pausa",
+		"Stop":                     "Ferma",
+		"Seek":                     "Vai a",
+	},
+	"nl": {
+		"Continue listening":       "Verder luisteren",
+		"Continue watching":        "Verder kijken",
+		"Most played":              "Meest afgespeeld",
+		"Music":                    "Muziek",
+		"Videos":                   "Video's",
+		"Photos":                   "Foto's",
+		"Path":                     "Pad",
+		"Update":                   "Bijwerken",
+		"Device":                   "Apparaat",
+		"PIN":                      "Pincode",
+		"Unlock":                   "Ontgrendelen",
+		"Favorites":                "Favorieten",
+		"Add":                      "Toevoegen",
+		"Remove":                   "Verwijderen",
+		"Library":                  "Bibliotheek",
+		"Files":                    "Bestanden",
+		"Videos without subtitles": "Video's zonder ondertitels",
+		"Audio without artwork":    "Audio zonder hoes",
+		"Hidden":                   "Verborgen",
+		"Hide":                     "Verbergen",
+		"Show":                     "Tonen",
+		"Places":                   "Plaatsen",
+		"Slideshow":                "Diavoorstelling",
+		"Play to":                  "Afspelen op",
+		"Renderer":                 "Afspeler",
+		"Play":                     "Afspelen",
+		"Pause":                    "Pauze",
+		"Stop":                     "Stoppen",
+		"Seek":                     "Ga naar",
+	},
+	"pl": {
+		"Continue listening":       "Kontynuuj słuchanie",
+		"Continue watching":        "Kontynuuj oglądanie",
+		"Most played":              "Najczęściej odtwarzane",
+		"Music":                    "Muzyka",
+		"Videos":                   "Filmy",
+		"Photos":                   "Zdjęcia",
+		"Path":                     "Ścieżka",
+		"Update":                   "Aktualizuj",
+		"Device":                   "Urządzenie",
+		"PIN":                      "PIN",
+		"Unlock":                   "Odblokuj",
+		"Favorites":                "Ulubione",
+		"Add":                      "Dodaj",
+		"Remove":                   "Usuń",
+		"Library":                  "Biblioteka",
+		"Files":                    "Pliki",
+		"Videos without subtitles": "Filmy bez napisów",
+		"Audio without artwork":    "Audio bez okładki",
+		"Hidden":                   "Ukryte",
+		"Hide":                     "Ukryj",
+		"Show":                     "Pokaż",
+		"Places":                   "Miejsca",
+		"Slideshow":                "Pokaz slajdów",
+		"Play to":                  "Odtwórz na",
+		"Renderer":                 "Odtwarzacz",
+		"Play":                     "Odtwórz",
+		"Pause":                    "Pauza",
+		"Stop":                     "Zatrzymaj",
+		"Seek":                     "Przewiń",
+	},
+	"pt": {
+		"Continue listening":       "Continuar a ouvir",
+		"Continue watching":        "Continuar a assistir",
+		"Most played":              "Mais tocadas",
+		"Music":                    "Música",
+		"Videos":                   "Vídeos",
+		"Photos":                   "Fotos",
+		"Path":                     "Caminho",
+		"Update":                   "Atualizar",
+		"Device":                   "Dispositivo",
+		"PIN":                      "PIN",
+		"Unlock":                   "Desbloquear",
+		"Favorites":                "Favoritos",
+		"Add":                      "Adicionar",
+		"Remove":                   "Remover",
+		"Library":                  "Biblioteca",
+		"Files":                    "Ficheiros",
+		"Videos without subtitles": "Vídeos sem legendas",
+		"Audio without artwork":    "Áudio sem capa",
+		"Hidden":                   "Ocultos",
+		"Hide":                     "Ocultar",
+		"Show":                     "Mostrar",
+		"Places":                   "Locais",
+		"Slideshow":                "Apresentação de slides",
+		"Play to":                  "Reproduzir em",
+		"Renderer":                 "Reprodutor",
+		"Play":                     "Reproduzir",
+		"Pause":                    "Pausa",
+		"Stop":                     "Parar",
+		"Seek":                     "Saltar",
+	},
+	"sv": {
+		"Continue listening":       "Fortsätt lyssna",
+		"Continue watching":        "Fortsätt titta",
+		"Most played":              "Mest spelade",
+		"Music":                    "Musik",
+		"Videos":                   "Videor",
+		"Photos":                   "Foton",
+		"Path":                     "Sökväg",
+		"Update":                   "Uppdatera",
+		"Device":                   "Enhet",
+		"PIN":                      "PIN-kod",
+		"Unlock":                   "Lås upp",
+		"Favorites":                "Favoriter",
+		"Add":                      "Lägg till",
+		"Remove":                   "Ta bort",
+		"Library":                  "Bibliotek",
+		"Files":                    "Filer",
+		"Videos without subtitles": "Videor utan undertexter",
+		"Audio without artwork":    "Ljud utan omslag",
+		"Hidden":                   "Dolda",
+		"Hide":                     "Dölj",
+		"Show":                     "Visa",
+		"Places":                   "Platser",
+		"Slideshow":                "Bildspel",
+		"Play to":                  "Spela upp på",
+		"Renderer":                 "Spelare",
+		"Play":                     "Spela",
+		"Pause":                    "Paus",
+		"Stop":                     "Stoppa",
+		"Seek":                     "Hoppa",
+	},
+}
+
+// The language the untranslated text is in.
+const defaultLanguage = "en"
+
+// Returns the languages Server.Language accepts.
+func Languages() []string {
+	return slices.Sorted(maps.Keys(translations))
+}
+
+// Returns the supported language for a BCP 47 tag such as "pt-BR", or "" if
+// there isn't one.
+func matchLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if _, ok := translations[tag]; ok {
+		return tag
+	}
+	primary, _, _ := strings.Cut(tag, "-")
+	if _, ok := translations[primary]; ok {
+		return primary
+	}
+	return ""
+}
+
+// Returns the supported language most preferred by an Accept-Language header,
+// or "" if it has none of them.
+func negotiateLanguage(acceptLanguage string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].q > choices[j].q
+	})
+	for _, c := range choices {
+		if lang := matchLanguage(c.tag); lang != "" {
+			return lang
+		}
+	}
+	return ""
+}
+
+// Returns s in the language, or as it is if there's no translation.
+func translate(lang, s string) string {
+	if t, ok := translations[matchLanguage(lang)][s]; ok {
+		return t
+	}
+	return s
+}
+
+// Returns the language of the titles of containers dms makes up.
+func (me *Server) language() string {
+	if lang := matchLanguage(me.Language); lang != "" {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// Returns the language for the web UI, preferring the browser's
+// Accept-Language over Server.Language.
+func (me *Server) webLanguage(acceptLanguage string) string {
+	if lang := negotiateLanguage(acceptLanguage); lang != "" {
+		return lang
+	}
+	return me.language()
+}
@@ -0,0 +1,78 @@
+package dms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		acceptLanguage, want string
+	}{
+		{"", ""},
+		{"de", "de"},
+		{"pt-BR,pt;q=0.9,en;q=0.8", "pt"},
+		{"ja,fr;q=0.5,de;q=0.7", "de"},
+		{"en-GB;q=0.1, NL", "nl"},
+		{"fr;q=0, ja", ""},
+		{"*", ""},
+	} {
+		if got := negotiateLanguage(tc.acceptLanguage); got != tc.want {
+			t.Errorf("negotiateLanguage(%q) = %q, want %q", tc.acceptLanguage, got, tc.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if got := translate("de-AT", "Most played"); got != "Meistgespielt" {
+		t.Errorf("got %q", got)
+	}
+	if got := translate("ja", "Most played"); got != "Most played" {
+		t.Errorf("got %q", got)
+	}
+	// Every translation covers every text, so nothing is left in English.
+	for lang, texts := range translations {
+		if lang == defaultLanguage {
+			continue
+		}
+		for text := range translations["fr"] {
+			if _, ok := texts[text]; !ok {
+				t.Errorf("%s: no translation of %q", lang, text)
+			}
+		}
+	}
+}
+
+func TestVirtualContainerLanguage(t *testing.T) {
+	srv := &Server{Language: "sv"}
+	cdService := &contentDirectoryService{Server: srv}
+	vc, _ := srv.virtualContainerByID(virtualIDPrefix + "continueWatching")
+	if got := cdService.virtualContainerObject(vc, "", "").Title; got != "Fortsätt titta" {
+		t.Errorf("got title %q", got)
+	}
+}
+
+func TestWebUILanguage(t *testing.T) {
+	srv := &Server{Language: "fr"}
+	mux := http.NewServeMux()
+	srv.initMux(mux)
+	for _, tc := range []struct {
+		acceptLanguage, want string
+	}{
+		{"", "Chemin"},
+		{"ja", "Chemin"},
+		{"it-IT,it;q=0.9", "Percorso"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tc.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", tc.acceptLanguage)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if body := w.Body.String(); !strings.Contains(body, tc.want) {
+			t.Errorf("Accept-Language %q: %q doesn't contain %q", tc.acceptLanguage, body, tc.want)
+		}
+	}
+}
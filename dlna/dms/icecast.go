@@ -0,0 +1,239 @@
+package dms
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+const (
+	// Streams a folder or playlist as continuous MP3, Icecast style.
+	streamPath = "/stream"
+	// Bytes of audio between ICY metadata blocks.
+	icyMetaInt = 16000
+	// The bitrate of the stream, in kbps.
+	icyBitrate = 128
+)
+
+// Every track is encoded the same, so the stream is a valid MP3 throughout.
+var icecastAudioOptions = transcode.AudioOptions{
+	SampleRate: 44100,
+	Channels:   2,
+	BitRate:    icyBitrate * 1000,
+}
+
+// Serves the audio in the folder or .m3u playlist given by the path query
+// parameter as one MP3 stream, looping until the listener goes away. Titles
+// are sent as ICY metadata to listeners that ask for it.
+func (me *Server) serveIcecast(w http.ResponseWriter, r *http.Request) {
+	if me.NoTranscode {
+		http.Error(w, "transcodes disabled", http.StatusNotFound)
+		return
+	}
+	given := r.URL.Query().Get("path")
+	tracks, err := me.streamTracks(me.filePath(given))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	client := playbackClient(r)
+	// Playlist entries can point anywhere in the FS, so they're filtered
+	// like folder listings.
+	tracks = slices.DeleteFunc(tracks, func(p string) bool {
+		ignored, err := me.IgnorePath(p)
+		return ignored || err != nil || me.hiddenFrom(client, p)
+	})
+	if len(tracks) == 0 {
+		http.Error(w, "no audio to stream", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("icy-name", path.Base(path.Clean("/"+given)))
+	w.Header().Set("icy-br", fmt.Sprint(icyBitrate))
+	var icy *icyWriter
+	out := io.Writer(w)
+	if r.Header.Get("Icy-MetaData") == "1" {
+		w.Header().Set("icy-metaint", fmt.Sprint(icyMetaInt))
+		icy = newICYWriter(w, icyMetaInt)
+		out = icy
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "HEAD" {
+		return
+	}
+	for {
+		// Bytes streamed in this pass over the tracks.
+		var written int64
+		for _, track := range tracks {
+			if r.Context().Err() != nil {
+				return
+			}
+			if icy != nil {
+				icy.SetTitle(me.streamTitle(r.Context(), track))
+			}
+			session := me.startTranscodeSession(r, track, "stream", time.Now(), "")
+			p, err := transcode.AudioTranscode(r.Context(), me.loopbackResURL(track), "mp3", "libmp3lame", icecastAudioOptions, 0, 0, nil)
+			if err != nil {
+				me.endTranscodeSession(session, nil, err)
+				me.Logger.Levelf(log.Warning, "streaming %q: %v", track, err)
+				return
+			}
+			n, err := io.Copy(session.writer(out), p)
+			written += n
+			p.Close()
+			me.endTranscodeSession(session, p, nil)
+			if err != nil {
+				// The listener went away.
+				return
+			}
+		}
+		if written == 0 {
+			// Looping again would spin.
+			me.Logger.Levelf(log.Warning, "streaming %q: no track gave any audio", given)
+			return
+		}
+	}
+}
+
+// Returns the audio files to stream for a folder, in name order, or the
+// entries of a playlist.
+func (me *Server) streamTracks(filePath string) (tracks []string, err error) {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".m3u", ".m3u8":
+		f, err := me.FS.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseM3U(f, path.Dir(filePath)), nil
+	}
+	err = fs.WalkDir(me.FS, filePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ignored, _ := me.IgnorePath(p); ignored {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if mt, err := me.mimeTypeByPath(p); err == nil && mt.IsAudio() && !mt.IsDSD() {
+			tracks = append(tracks, p)
+		}
+		return nil
+	})
+	return
+}
+
+// Returns the FS paths of the local entries of an M3U playlist, relative to
+// the directory containing it. URLs and paths outside the FS are skipped.
+func parseM3U(r io.Reader, dir string) (tracks []string) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(s.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "://") {
+			continue
+		}
+		p := path.Join(dir, strings.ReplaceAll(line, "\\", "/"))
+		if !fs.ValidPath(p) {
+			continue
+		}
+		tracks = append(tracks, p)
+	}
+	return
+}
+
+// Returns the "Artist - Title" for a track, or its file name.
+func (me *Server) streamTitle(ctx context.Context, track string) string {
+	if !me.NoProbe {
+		info, _ := me.ffmpegProbe(ctx, track)
+		title, _ := audioTag(info, "title")
+		artist, _ := audioTag(info, "artist")
+		if title != "" && artist != "" {
+			return artist + " - " + title
+		}
+		if title != "" {
+			return title
+		}
+	}
+	return strings.TrimSuffix(path.Base(track), path.Ext(track))
+}
+
+// Interleaves ICY metadata blocks into a stream.
+type icyWriter struct {
+	w       io.Writer
+	metaInt int
+	// Bytes of audio until the next metadata block.
+	remaining int
+	// A title that hasn't been sent yet.
+	title   string
+	pending bool
+}
+
+func newICYWriter(w io.Writer, metaInt int) *icyWriter {
+	return &icyWriter{
+		w:         w,
+		metaInt:   metaInt,
+		remaining: metaInt,
+	}
+}
+
+// Sets the title sent in the next metadata block.
+func (me *icyWriter) SetTitle(title string) {
+	me.title = title
+	me.pending = true
+}
+
+func (me *icyWriter) Write(p []byte) (n int, err error) {
+	for len(p) != 0 {
+		chunk := min(len(p), me.remaining)
+		var m int
+		m, err = me.w.Write(p[:chunk])
+		n += m
+		if err != nil {
+			return
+		}
+		p = p[chunk:]
+		me.remaining -= chunk
+		if me.remaining == 0 {
+			if _, err = me.w.Write(me.metadataBlock()); err != nil {
+				return
+			}
+			me.remaining = me.metaInt
+		}
+	}
+	return
+}
+
+// Returns the next metadata block: a length byte counting 16 byte units,
+// followed by the padded metadata, which is empty if nothing changed.
+func (me *icyWriter) metadataBlock() []byte {
+	if !me.pending {
+		return []byte{0}
+	}
+	me.pending = false
+	// Quotes would end the value early.
+	meta := "StreamTitle='" + strings.ReplaceAll(me.title, "'", "") + "';"
+	if len(meta) > 255*16 {
+		meta = meta[:255*16]
+	}
+	units := (len(meta) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], meta)
+	return block
+}
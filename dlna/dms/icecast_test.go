@@ -0,0 +1,55 @@
+package dms
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+)
+
+func TestICYWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := newICYWriter(&b, 4)
+	w.SetTitle("It's On")
+	if n, err := w.Write([]byte("abcdefghij")); n != 10 || err != nil {
+		t.Fatal(n, err)
+	}
+	e := "abcd\x02StreamTitle='Its On';" + strings.Repeat("\x00", 11) + "efgh\x00ij"
+	if b.String() != e {
+		t.Fatalf("expected %q but got %q", e, b.String())
+	}
+}
+
+func TestParseM3U(t *testing.T) {
+	m3u := "#EXTM3U\n#EXTINF:123,Artist - Title\n01 Intro.mp3\r\nCD2\\02 Outro.flac\n\nhttp://radio/stream\n../../../etc/passwd\n"
+	a := parseM3U(strings.NewReader(m3u), "Music/Album")
+	e := []string{"Music/Album/01 Intro.mp3", "Music/Album/CD2/02 Outro.flac"}
+	if !slices.Equal(a, e) {
+		t.Fatalf("expected %q but got %q", e, a)
+	}
+}
+
+func TestIcecastPlaylistFiltered(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Music/list.m3u":     {Data: []byte("Secret/a.mp3\n../Private/b.mp3\n")},
+			"Music/Secret/a.mp3": {},
+			"Private/b.mp3":      {},
+		},
+		RootObjectPath: ".",
+		Logger:         log.Default,
+		IgnorePaths:    []string{"Secret"},
+		ProtectedPaths: []string{"Private"},
+		PIN:            "1234",
+	}
+	w := httptest.NewRecorder()
+	s.serveIcecast(w, httptest.NewRequest("GET", streamPath+"?path=/Music/list.m3u", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("ignored and locked playlist entries streamed: %d", w.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package dms
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/nfnt/resize"
+)
+
+// The sizes of the generated device icons. DLNA defines the JPEG_SM, JPEG_LRG,
+// PNG_SM and PNG_LRG profiles at 48 and 120 pixels, and newer clients show
+// bigger ones.
+var standardIconSizes = []int{48, 120, 256}
+
+// Returns the standard set of device icons made from one image: PNG and JPEG
+// at each of the standard sizes, PNGs first. Images that aren't square are
+// scaled to fit, and centered.
+func GenerateIcons(src image.Image) (ret []Icon, err error) {
+	for _, mimetype := range []string{"image/png", "image/jpeg"} {
+		for _, size := range standardIconSizes {
+			var bg image.Image = image.Transparent
+			if mimetype == "image/jpeg" {
+				// JPEGs have no alpha channel.
+				bg = image.Black
+			}
+			img := image.NewRGBA(image.Rect(0, 0, size, size))
+			draw.Draw(img, img.Bounds(), bg, image.Point{}, draw.Src)
+			scaled := resize.Thumbnail(uint(size), uint(size), src, resize.Lanczos3)
+			b := scaled.Bounds()
+			offset := image.Pt((size-b.Dx())/2, (size-b.Dy())/2)
+			draw.Draw(img, b.Sub(b.Min).Add(offset), scaled, b.Min, draw.Over)
+			var buf bytes.Buffer
+			if mimetype == "image/jpeg" {
+				err = jpeg.Encode(&buf, img, nil)
+			} else {
+				err = png.Encode(&buf, img)
+			}
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, Icon{
+				Width:    size,
+				Height:   size,
+				Depth:    24,
+				Mimetype: mimetype,
+				Bytes:    buf.Bytes(),
+			})
+		}
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package dms
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGenerateIcons(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	icons, err := GenerateIcons(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icons) != 6 {
+		t.Fatalf("got %d icons", len(icons))
+	}
+	for i, icon := range icons {
+		img, format, err := image.Decode(bytes.NewReader(icon.Bytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if "image/"+format != icon.Mimetype || icon.Mimetype != []string{"image/png", "image/jpeg"}[i/3] {
+			t.Errorf("icon %d: got %s in %s", i, format, icon.Mimetype)
+		}
+		if b := img.Bounds(); b.Dx() != icon.Width || b.Dy() != icon.Height || icon.Width != standardIconSizes[i%3] {
+			t.Errorf("icon %d: got %v, advertised %dx%d", i, b, icon.Width, icon.Height)
+		}
+		// The source is letterboxed.
+		if _, _, _, a := img.At(icon.Width/2, 0).RGBA(); icon.Mimetype == "image/png" && a != 0 {
+			t.Errorf("icon %d: top isn't transparent", i)
+		}
+		if r, _, _, _ := img.At(icon.Width/2, icon.Height/2).RGBA(); r < 0xf000 {
+			t.Errorf("icon %d: middle is %v", i, color.RGBAModel.Convert(img.At(icon.Width/2, icon.Height/2)))
+		}
+	}
+}
@@ -0,0 +1,644 @@
+package dms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// How often the library is scanned for changes, for the Library and the search
+// index.
+const libraryScanInterval = 15 * time.Minute
+
+// The library database's tables. Directories are listed in dirs once they've
+// been scanned, with their entries in files. Probe results are kept in probes,
+// along with a reference to any artwork embedded in the file, and their tags
+// in tags, so they can be queried.
+const librarySchema = `
+CREATE TABLE IF NOT EXISTS dirs (
+	path TEXT PRIMARY KEY
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS files (
+	dir TEXT NOT NULL,
+	name TEXT NOT NULL,
+	mode INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	mod_time INTEGER NOT NULL,
+	PRIMARY KEY (dir, name)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS probes (
+	path TEXT PRIMARY KEY,
+	mod_time INTEGER NOT NULL,
+	info TEXT NOT NULL,
+	artwork TEXT NOT NULL
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS tags (
+	path TEXT NOT NULL,
+	name TEXT NOT NULL,
+	value TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tags_path ON tags (path);
+CREATE INDEX IF NOT EXISTS tags_value ON tags (name, value);
+CREATE TABLE IF NOT EXISTS playback (
+	client TEXT NOT NULL,
+	path TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	furthest INTEGER NOT NULL,
+	duration INTEGER NOT NULL,
+	last_played INTEGER NOT NULL,
+	play_count INTEGER NOT NULL,
+	PRIMARY KEY (client, path)
+) WITHOUT ROWID;
+`
+
+// A persistent library database, in SQLite. It holds the library's files and
+// directories with their sizes and modification times, the probe results and
+// tags of its media, their artwork, and how far clients have played them.
+// Browsing and searching list directories and get probe results from it
+// instead of the filesystem, which is slow for large libraries, especially on
+// network storage. Server.Run keeps it up to date by scanning the filesystem
+// in the background.
+type Library struct {
+	mu   sync.Mutex
+	conn *sqlite.Conn
+	// Where errors that can't be returned are logged. Server.Init sets it to
+	// the server's Logger.
+	logger log.Logger
+}
+
+// A file or directory in a Library listing.
+type LibraryEntry struct {
+	Name    string
+	Mode    fs.FileMode
+	Size    int64
+	ModTime time.Time
+}
+
+// Opens the library database at the path, creating it if it doesn't exist.
+// The path can be ":memory:" for one that isn't kept.
+func OpenLibrary(path string) (*Library, error) {
+	conn, err := sqlite.OpenConn(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := sqlitex.ExecuteScript(conn, librarySchema, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Library{conn: conn, logger: log.Default}, nil
+}
+
+// Sets where the library logs errors that can't be returned.
+func (me *Library) setLogger(logger log.Logger) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.logger = logger
+}
+
+// Closes the database.
+func (me *Library) Close() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.conn.Close()
+}
+
+// Runs the query, with the lock held.
+func (me *Library) exec(query string, resultFn func(*sqlite.Stmt) error, args ...interface{}) error {
+	return sqlitex.Execute(me.conn, query, &sqlitex.ExecOptions{Args: args, ResultFunc: resultFn})
+}
+
+func scanLibraryEntry(stmt *sqlite.Stmt) LibraryEntry {
+	return LibraryEntry{
+		Name:    stmt.GetText("name"),
+		Mode:    fs.FileMode(stmt.GetInt64("mode")),
+		Size:    stmt.GetInt64("size"),
+		ModTime: time.Unix(0, stmt.GetInt64("mod_time")),
+	}
+}
+
+func (me *Library) readDir(dir string) (entries []LibraryEntry, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	err := me.exec("SELECT 1 FROM dirs WHERE path = ?", func(*sqlite.Stmt) error {
+		ok = true
+		return nil
+	}, dir)
+	if err != nil || !ok {
+		return nil, false
+	}
+	entries = []LibraryEntry{}
+	err = me.exec("SELECT name, mode, size, mod_time FROM files WHERE dir = ? ORDER BY name", func(stmt *sqlite.Stmt) error {
+		entries = append(entries, scanLibraryEntry(stmt))
+		return nil
+	}, dir)
+	if err != nil {
+		me.logger.Printf("reading library listing of %q: %v", dir, err)
+		return nil, false
+	}
+	return entries, true
+}
+
+func (me *Library) stat(name string) (entry LibraryEntry, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	err := me.exec("SELECT name, mode, size, mod_time FROM files WHERE dir = ? AND name = ?", func(stmt *sqlite.Stmt) error {
+		entry, ok = scanLibraryEntry(stmt), true
+		return nil
+	}, path.Dir(name), path.Base(name))
+	if err != nil {
+		me.logger.Printf("reading library entry of %q: %v", name, err)
+		return LibraryEntry{}, false
+	}
+	return
+}
+
+// Replaces the listings, dropping the probe results of files that have gone
+// from them.
+func (me *Library) set(dirs map[string][]LibraryEntry) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	err := me.transaction(func() error {
+		if err := me.exec("DELETE FROM dirs", nil); err != nil {
+			return err
+		}
+		if err := me.exec("DELETE FROM files", nil); err != nil {
+			return err
+		}
+		for dir, entries := range dirs {
+			if err := me.insertListing(dir, entries); err != nil {
+				return err
+			}
+		}
+		return me.dropGoneProbes(dirs)
+	})
+	if err != nil {
+		me.logger.Printf("storing library listings: %v", err)
+	}
+}
+
+// Replaces the listings of the directories, removing those that are nil along
+// with those within them.
+func (me *Library) update(dirs map[string][]LibraryEntry) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	err := me.transaction(func() error {
+		for dir, entries := range dirs {
+			if entries != nil {
+				if err := me.exec("DELETE FROM files WHERE dir = ?", nil, dir); err != nil {
+					return err
+				}
+				if err := me.insertListing(dir, entries); err != nil {
+					return err
+				}
+				continue
+			}
+			// Those within sort between dir+"/" and dir+"0".
+			if err := me.exec("DELETE FROM dirs WHERE path = ?1 OR path >= ?1 || '/' AND path < ?1 || '0'", nil, dir); err != nil {
+				return err
+			}
+			if err := me.exec("DELETE FROM files WHERE dir = ?1 OR dir >= ?1 || '/' AND dir < ?1 || '0'", nil, dir); err != nil {
+				return err
+			}
+		}
+		return me.dropGoneProbes(dirs)
+	})
+	if err != nil {
+		me.logger.Printf("updating library listings: %v", err)
+	}
+}
+
+// Runs f in a transaction, which is rolled back if it fails.
+func (me *Library) transaction(f func() error) (err error) {
+	defer sqlitex.Save(me.conn)(&err)
+	return f()
+}
+
+func (me *Library) insertListing(dir string, entries []LibraryEntry) error {
+	if err := me.exec("INSERT OR IGNORE INTO dirs (path) VALUES (?)", nil, dir); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err := me.exec(
+			"INSERT INTO files (dir, name, mode, size, mod_time) VALUES (?, ?, ?, ?, ?)", nil,
+			dir, e.Name, int64(e.Mode), e.Size, e.ModTime.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drops the probe results and tags of files that aren't in the listings of
+// their directories, and of everything within directories that are nil. Those
+// in directories that aren't listed, such as the mounted Torrents, are left
+// alone.
+func (me *Library) dropGoneProbes(dirs map[string][]LibraryEntry) error {
+	var gone []string
+	for dir, entries := range dirs {
+		names := make(map[string]struct{}, len(entries))
+		for _, e := range entries {
+			names[e.Name] = struct{}{}
+		}
+		err := me.probesWithin(dir, entries == nil, func(p string) {
+			if _, ok := names[path.Base(p)]; !ok {
+				gone = append(gone, p)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range gone {
+		if err := me.deleteProbe(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls f with the path of each probe result in dir, or at any depth within it
+// if deep.
+func (me *Library) probesWithin(dir string, deep bool, f func(p string)) error {
+	resultFn := func(stmt *sqlite.Stmt) error {
+		f(stmt.ColumnText(0))
+		return nil
+	}
+	if dir == "." {
+		if deep {
+			return me.exec("SELECT path FROM probes", resultFn)
+		}
+		return me.exec("SELECT path FROM probes WHERE instr(path, '/') = 0", resultFn)
+	}
+	// Those within sort between dir+"/" and dir+"0".
+	query := "SELECT path FROM probes WHERE path >= ?1 || '/' AND path < ?1 || '0'"
+	if !deep {
+		query += " AND instr(substr(path, length(?1) + 2), '/') = 0"
+	}
+	return me.exec(query, resultFn, dir)
+}
+
+func (me *Library) deleteProbe(p string) error {
+	if err := me.exec("DELETE FROM probes WHERE path = ?", nil, p); err != nil {
+		return err
+	}
+	return me.exec("DELETE FROM tags WHERE path = ?", nil, p)
+}
+
+// Returns the number of directories listed.
+func (me *Library) Len() (n int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.exec("SELECT count(*) FROM dirs", func(stmt *sqlite.Stmt) error {
+		n = int(stmt.ColumnInt64(0))
+		return nil
+	})
+	return
+}
+
+// Returns a Cache of probe results, for Server.FFProbeCache, that keeps them
+// in the library.
+func (me *Library) ProbeCache() DeletableCache {
+	return libraryProbes{me}
+}
+
+// Keeps a probe result for each file, replacing it when the file changes.
+type libraryProbes struct {
+	lib *Library
+}
+
+// Returns the *ffprobe.Info for an ffmpegInfoCacheKey. It's nil for files
+// that couldn't be probed.
+func (me libraryProbes) Get(key interface{}) (value interface{}, ok bool) {
+	k, isKey := key.(ffmpegInfoCacheKey)
+	if !isKey {
+		return
+	}
+	var j string
+	me.lib.mu.Lock()
+	err := me.lib.exec("SELECT info FROM probes WHERE path = ? AND mod_time = ?", func(stmt *sqlite.Stmt) error {
+		j, ok = stmt.ColumnText(0), true
+		return nil
+	}, k.Path, k.ModTime)
+	logger := me.lib.logger
+	me.lib.mu.Unlock()
+	if err != nil || !ok {
+		return nil, false
+	}
+	var info *ffprobe.Info
+	if err := json.Unmarshal([]byte(j), &info); err != nil {
+		logger.Printf("reading probe of %q: %v", k.Path, err)
+		return nil, false
+	}
+	return info, true
+}
+
+// Stores the *ffprobe.Info, which may be nil, for an ffmpegInfoCacheKey, with
+// its tags and artwork.
+func (me libraryProbes) Set(key interface{}, value interface{}) {
+	k, ok := key.(ffmpegInfoCacheKey)
+	if !ok {
+		return
+	}
+	info, _ := value.(*ffprobe.Info)
+	me.lib.mu.Lock()
+	defer me.lib.mu.Unlock()
+	j, err := json.Marshal(info)
+	if err != nil {
+		me.lib.logger.Printf("encoding probe of %q: %v", k.Path, err)
+		return
+	}
+	err = me.lib.transaction(func() error {
+		if err := me.lib.deleteProbe(k.Path); err != nil {
+			return err
+		}
+		err := me.lib.exec(
+			"INSERT INTO probes (path, mod_time, info, artwork) VALUES (?, ?, ?, ?)", nil,
+			k.Path, k.ModTime, string(j), embeddedArtwork(info))
+		if err != nil {
+			return err
+		}
+		for name, value := range probeTags(info) {
+			if err := me.lib.exec("INSERT INTO tags (path, name, value) VALUES (?, ?, ?)", nil, k.Path, name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		me.lib.logger.Printf("storing probe of %q: %v", k.Path, err)
+	}
+}
+
+// Drops the result for an ffmpegInfoCacheKey.
+func (me libraryProbes) Delete(key interface{}) {
+	k, ok := key.(ffmpegInfoCacheKey)
+	if !ok {
+		return
+	}
+	me.lib.mu.Lock()
+	defer me.lib.mu.Unlock()
+	err := me.lib.transaction(func() error {
+		var found bool
+		err := me.lib.exec("SELECT 1 FROM probes WHERE path = ? AND mod_time = ?", func(*sqlite.Stmt) error {
+			found = true
+			return nil
+		}, k.Path, k.ModTime)
+		if err != nil || !found {
+			return err
+		}
+		return me.lib.deleteProbe(k.Path)
+	})
+	if err != nil {
+		me.lib.logger.Printf("deleting probe of %q: %v", k.Path, err)
+	}
+}
+
+// Returns the number of probe results stored.
+func (me libraryProbes) Len() (n int) {
+	me.lib.mu.Lock()
+	defer me.lib.mu.Unlock()
+	me.lib.exec("SELECT count(*) FROM probes", func(stmt *sqlite.Stmt) error {
+		n = int(stmt.ColumnInt64(0))
+		return nil
+	})
+	return
+}
+
+// Returns the tags of the file and its first audio stream, by lower case
+// name, preferring the file's as audioTag does.
+func probeTags(info *ffprobe.Info) map[string]string {
+	ret := make(map[string]string)
+	if info == nil {
+		return ret
+	}
+	for _, tags := range []interface{}{info.Format["tags"], firstStream(info, "audio")["tags"]} {
+		m, _ := tags.(map[string]interface{})
+		for k, v := range m {
+			k = strings.ToLower(k)
+			if s, ok := v.(string); ok {
+				if _, ok := ret[k]; !ok {
+					ret[k] = s
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// Returns a reference to the cover art embedded in the file, as "stream:"
+// and the index of its stream, or "" if there's none.
+func embeddedArtwork(info *ffprobe.Info) string {
+	if info == nil {
+		return ""
+	}
+	for i, s := range info.Streams {
+		if disposition, _ := s["disposition"].(map[string]interface{}); disposition["attached_pic"] == float64(1) {
+			return fmt.Sprintf("stream:%d", i)
+		}
+	}
+	return ""
+}
+
+// Adds the play state kept in the library to the history, replacing any the
+// history has for the same files.
+func (me *Library) LoadPlayback(h *PlaybackHistory) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.exec(
+		"SELECT client, path, position, furthest, duration, last_played, play_count FROM playback",
+		func(stmt *sqlite.Stmt) error {
+			h.set(stmt.ColumnText(0), stmt.ColumnText(1), PlaybackRecord{
+				Position:   time.Duration(stmt.ColumnInt64(2)),
+				Furthest:   time.Duration(stmt.ColumnInt64(3)),
+				Duration:   time.Duration(stmt.ColumnInt64(4)),
+				LastPlayed: time.Unix(0, stmt.ColumnInt64(5)),
+				PlayCount:  int(stmt.ColumnInt64(6)),
+			})
+			return nil
+		})
+}
+
+// Stores all of the history's play state in the library.
+func (me *Library) SavePlayback(h *PlaybackHistory) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.transaction(func() error {
+		for client, records := range h.all() {
+			for p, rec := range records {
+				if err := me.putPlayback(client, p, rec); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Stores a client's play state for a file.
+func (me *Library) setPlayback(client, p string, rec PlaybackRecord) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if err := me.putPlayback(client, p, rec); err != nil {
+		me.logger.Printf("storing playback of %q: %v", p, err)
+	}
+}
+
+func (me *Library) putPlayback(client, p string, rec PlaybackRecord) error {
+	return me.exec(
+		"INSERT OR REPLACE INTO playback (client, path, position, furthest, duration, last_played, play_count) VALUES (?, ?, ?, ?, ?, ?, ?)", nil,
+		client, p, int64(rec.Position), int64(rec.Furthest), int64(rec.Duration), rec.LastPlayed.UnixNano(), rec.PlayCount)
+}
+
+// Returns an FS that lists directories and stats files from the library
+// where it can, and reads files from fsys. Anything not in the library, such
+// as a directory created since the last scan, is looked up in fsys.
+func (me *Library) FS(fsys fs.FS) fs.FS {
+	return libraryFS{fsys, me}
+}
+
+type libraryFS struct {
+	fs.FS
+	lib *Library
+}
+
+func (me libraryFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, ok := me.lib.readDir(name)
+	if !ok {
+		return fs.ReadDir(me.FS, name)
+	}
+	ret := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, fs.FileInfoToDirEntry(libraryFileInfo{e}))
+	}
+	return ret, nil
+}
+
+func (me libraryFS) Stat(name string) (fs.FileInfo, error) {
+	if name != "." {
+		if e, ok := me.lib.stat(name); ok {
+			return libraryFileInfo{e}, nil
+		}
+	}
+	return fs.Stat(me.FS, name)
+}
+
+type libraryFileInfo struct {
+	LibraryEntry
+}
+
+func (me libraryFileInfo) Name() string       { return me.LibraryEntry.Name }
+func (me libraryFileInfo) Size() int64        { return me.LibraryEntry.Size }
+func (me libraryFileInfo) Mode() fs.FileMode  { return me.LibraryEntry.Mode }
+func (me libraryFileInfo) ModTime() time.Time { return me.LibraryEntry.ModTime }
+func (me libraryFileInfo) IsDir() bool        { return me.LibraryEntry.Mode.IsDir() }
+func (me libraryFileInfo) Sys() interface{}   { return nil }
+
+// Lists every directory of fsys, for the Library. It's cut short if
+// the server is closed.
+func (me *Server) scanLibrary(fsys fs.FS) (dirs map[string][]LibraryEntry, err error) {
+	dirs = make(map[string][]LibraryEntry)
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Leave unreadable directories out, rather than failing.
+			return nil
+		}
+		select {
+		case <-me.closed:
+			return fs.SkipAll
+		default:
+		}
+		if p == "." {
+			dirs["."] = []LibraryEntry{}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		dir := path.Dir(p)
+		dirs[dir] = append(dirs[dir], LibraryEntry{
+			Name:    fi.Name(),
+			Mode:    fi.Mode(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+		if d.IsDir() {
+			dirs[p] = []LibraryEntry{}
+		}
+		return nil
+	})
+	return
+}
+
+// Lists a directory of fsys, as for the Library.
+func readLibraryDir(fsys fs.FS, dir string) ([]LibraryEntry, error) {
+	des, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := []LibraryEntry{}
+	for _, d := range des {
+		fi, err := d.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, LibraryEntry{
+			Name:    fi.Name(),
+			Mode:    fi.Mode(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	return entries, nil
+}
+
+// Replaces the Library listings with a fresh scan of the filesystem, and tells
+// ContentDirectory subscribers of the folders that changed.
+func (me *Server) updateLibrary() {
+	dirs, err := me.scanLibrary(me.liveFS)
+	if err != nil {
+		me.Logger.Levelf(log.Warning, "scanning library: %v", err)
+		return
+	}
+	select {
+	case <-me.closed:
+		// The scan was cut short.
+		return
+	default:
+	}
+	me.updateContainers(dirs, true)
+	if me.Library != nil {
+		me.Library.set(dirs)
+	}
+}
+
+// Keeps the Library and the search index up to date, and
+// ContentDirectory subscribers told of changes, until the server is closed.
+func (me *Server) maintainLibrary() {
+	for {
+		started := time.Now()
+		if me.Library != nil || me.Watch || me.contentDirectorySubscribed() {
+			me.updateLibrary()
+			me.Logger.Levelf(log.Debug, "scanned library in %v", time.Since(started))
+		}
+		if me.Search != nil {
+			started := time.Now()
+			me.indexLibrary()
+			me.Logger.Levelf(log.Debug, "indexed library in %v", time.Since(started))
+		}
+		select {
+		case <-me.closed:
+			return
+		case <-time.After(libraryScanInterval):
+		}
+	}
+}
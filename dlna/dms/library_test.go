@@ -0,0 +1,188 @@
+package dms
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"zombiezen.com/go/sqlite"
+)
+
+func TestLibrary(t *testing.T) {
+	mapFS := fstest.MapFS{
+		"Music/Album/01.mp3": {Data: []byte("one")},
+		"Music/Album/02.mp3": {Data: []byte("two!")},
+		"Films/a.mkv":        {},
+	}
+	srv := &Server{closed: make(chan struct{})}
+	dirs, err := srv.scanLibrary(mapFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), "library")
+	lib, err := OpenLibrary(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib.set(dirs)
+	if lib.Len() != 4 {
+		t.Fatalf("got %d directories, want 4", lib.Len())
+	}
+	// Changes to the filesystem aren't seen until the next scan.
+	mapFS["Music/Album/03.mp3"] = &fstest.MapFile{}
+	mapFS["Podcasts/b.mp3"] = &fstest.MapFile{}
+	fsys := lib.FS(mapFS)
+
+	check := func(t *testing.T, fsys fs.FS) {
+		t.Helper()
+		entries, err := fs.ReadDir(fsys, "Music/Album")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if len(names) != 2 || names[0] != "01.mp3" || names[1] != "02.mp3" {
+			t.Errorf("got %q", names)
+		}
+		fi, err := fs.Stat(fsys, "Music/Album/02.mp3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != 4 || fi.IsDir() {
+			t.Errorf("got size %d, dir %v", fi.Size(), fi.IsDir())
+		}
+		if fi, err := fs.Stat(fsys, "Music"); err != nil || !fi.IsDir() {
+			t.Errorf("Music: %v", err)
+		}
+		// Directories created since the scan are read from the filesystem.
+		if entries, err := fs.ReadDir(fsys, "Podcasts"); err != nil || len(entries) != 1 {
+			t.Errorf("Podcasts: %v, %v", entries, err)
+		}
+	}
+	check(t, fsys)
+
+	if err := lib.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lib, err = OpenLibrary(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Close()
+	check(t, lib.FS(mapFS))
+
+	// Removed folders go along with those within them.
+	lib.update(map[string][]LibraryEntry{"Music": nil})
+	if lib.Len() != 2 {
+		t.Fatalf("got %d directories after removing Music, want 2", lib.Len())
+	}
+	if _, ok := lib.readDir("Music/Album"); ok {
+		t.Error("Music/Album is still listed")
+	}
+}
+
+func TestLibraryProbes(t *testing.T) {
+	lib, err := OpenLibrary(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Close()
+	lib.set(map[string][]LibraryEntry{
+		".":     {{Name: "Music", Mode: fs.ModeDir}},
+		"Music": {{Name: "a.mp3"}, {Name: "broken.mp3"}},
+	})
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{
+		"format": {"tags": {"TITLE": "Song", "artist": "Band"}},
+		"streams": [
+			{"codec_type": "audio", "tags": {"artist": "Other", "album": "Record"}},
+			{"codec_type": "video", "disposition": {"attached_pic": 1}}
+		]
+	}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	probes := lib.ProbeCache()
+	key := ffmpegInfoCacheKey{"Music/a.mp3", 1}
+	probes.Set(key, &info)
+	probes.Set(ffmpegInfoCacheKey{"Music/broken.mp3", 1}, nil)
+	if v, ok := probes.Get(key); !ok || v.(*ffprobe.Info).Format["tags"] == nil {
+		t.Fatalf("got %v, %v", v, ok)
+	}
+	// Failures are kept too.
+	if v, ok := probes.Get(ffmpegInfoCacheKey{"Music/broken.mp3", 1}); !ok || v.(*ffprobe.Info) != nil {
+		t.Fatalf("broken: got %v, %v", v, ok)
+	}
+	if _, ok := probes.Get(ffmpegInfoCacheKey{"Music/a.mp3", 2}); ok {
+		t.Fatal("got a probe of another version of the file")
+	}
+
+	tags := make(map[string]string)
+	var artwork string
+	lib.mu.Lock()
+	lib.exec("SELECT name, value FROM tags WHERE path = ?", func(stmt *sqlite.Stmt) error {
+		tags[stmt.ColumnText(0)] = stmt.ColumnText(1)
+		return nil
+	}, key.Path)
+	lib.exec("SELECT artwork FROM probes WHERE path = ?", func(stmt *sqlite.Stmt) error {
+		artwork = stmt.ColumnText(0)
+		return nil
+	}, key.Path)
+	lib.mu.Unlock()
+	if len(tags) != 3 || tags["title"] != "Song" || tags["artist"] != "Band" || tags["album"] != "Record" {
+		t.Errorf("got tags %q", tags)
+	}
+	if artwork != "stream:1" {
+		t.Errorf("got artwork %q", artwork)
+	}
+
+	// Probes of files that have gone are dropped with them.
+	lib.update(map[string][]LibraryEntry{"Music": {{Name: "a.mp3"}}})
+	if n := probes.(libraryProbes).Len(); n != 1 {
+		t.Fatalf("got %d probes, want 1", n)
+	}
+	// Only those in the directories updated are, and all those within the
+	// directories removed.
+	for _, p := range []string{"b.mp3", "Music/Band/b.mp3", "Music2/b.mp3", "Torrents/b.mp3"} {
+		probes.Set(ffmpegInfoCacheKey{p, 1}, nil)
+	}
+	lib.update(map[string][]LibraryEntry{".": {{Name: "b.mp3"}}, "Music": {{Name: "b.mp3"}}})
+	if n := probes.(libraryProbes).Len(); n != 4 {
+		t.Fatalf("got %d probes, want 4", n)
+	}
+	lib.update(map[string][]LibraryEntry{"Music": nil})
+	for p, want := range map[string]bool{"b.mp3": true, "Music/Band/b.mp3": false, "Music2/b.mp3": true, "Torrents/b.mp3": true} {
+		if _, ok := probes.Get(ffmpegInfoCacheKey{p, 1}); ok != want {
+			t.Errorf("%s: got %v, want %v", p, ok, want)
+		}
+	}
+}
+
+func TestLibraryPlayback(t *testing.T) {
+	lib, err := OpenLibrary(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Close()
+	var h PlaybackHistory
+	h.record("192.0.2.1", "a.mkv", PlaybackRecord{Position: time.Minute, Duration: time.Hour, LastPlayed: time.Now()})
+	if err := lib.SavePlayback(&h); err != nil {
+		t.Fatal(err)
+	}
+	lib.setPlayback("192.0.2.2", "b.mkv", PlaybackRecord{Position: time.Second, Furthest: 2 * time.Second, PlayCount: 3})
+	var loaded PlaybackHistory
+	if err := lib.LoadPlayback(&loaded); err != nil {
+		t.Fatal(err)
+	}
+	if rec, ok := loaded.get("192.0.2.1", "a.mkv"); !ok || rec.Position != time.Minute || rec.Duration != time.Hour || rec.PlayCount != 1 {
+		t.Errorf("got %+v, %v", rec, ok)
+	}
+	if rec, ok := loaded.get("192.0.2.2", "b.mkv"); !ok || rec.Furthest != 2*time.Second || rec.PlayCount != 3 {
+		t.Errorf("got %+v, %v", rec, ok)
+	}
+}
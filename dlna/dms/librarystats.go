@@ -0,0 +1,142 @@
+package dms
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+const libraryStatsAPIPath = "/api/library"
+
+// Figures about the media in the library, as of the last time the search
+// indexer walked it. Codecs, resolutions and artwork are only known for files
+// ffprobe could read.
+type LibraryStats struct {
+	// When the walk finished.
+	Updated   time.Time `json:"updated"`
+	Files     int       `json:"files"`
+	TotalSize int64     `json:"totalSize"`
+	// By "audio", "video" or "image".
+	Types map[string]int `json:"types"`
+	// Of the first video stream of videos, and the audio stream of audio.
+	Codecs map[string]int `json:"codecs"`
+	// Of videos: "2160p", "1080p", "720p" or "SD".
+	Resolutions map[string]int `json:"resolutions"`
+	// Videos without a subtitle stream or .srt file.
+	MissingSubtitles int `json:"missingSubtitles"`
+	// Audio without embedded cover art.
+	MissingArtwork int `json:"missingArtwork"`
+}
+
+func newLibraryStats() *LibraryStats {
+	return &LibraryStats{
+		Types:       make(map[string]int),
+		Codecs:      make(map[string]int),
+		Resolutions: make(map[string]int),
+	}
+}
+
+// What LibraryStats counts of a media file from probing it. They're kept
+// between walks, so unchanged files needn't be probed again.
+type mediaFacts struct {
+	modTime time.Time
+	// Empty if unknown.
+	codec      string
+	resolution string
+	// Whether there's a subtitle stream, or for audio, cover art.
+	subtitleStream bool
+	artwork        bool
+	probed         bool
+}
+
+// The ffprobe Info may be nil.
+func newMediaFacts(modTime time.Time, mt mimeType, info *ffprobe.Info) (ret mediaFacts) {
+	ret.modTime = modTime
+	ret.probed = info != nil
+	if v := firstStream(info, "video"); v != nil && mt.IsVideo() {
+		ret.codec = streamString(v, "codec_name")
+		ret.resolution = resolutionClass(streamInt(v, "width"), streamInt(v, "height"))
+	}
+	if a := firstStream(info, "audio"); a != nil && mt.IsAudio() {
+		ret.codec = streamString(a, "codec_name")
+	}
+	ret.subtitleStream = firstStream(info, "subtitle") != nil
+	ret.artwork = firstStream(info, "video") != nil
+	return
+}
+
+// Counts a media file.
+func (me *LibraryStats) add(fsys fs.FS, filePath string, fi fs.FileInfo, mt mimeType, facts mediaFacts) {
+	me.Files++
+	me.TotalSize += fi.Size()
+	me.Types[mt.Type()]++
+	if facts.codec != "" {
+		me.Codecs[facts.codec]++
+	}
+	if facts.resolution != "" {
+		me.Resolutions[facts.resolution]++
+	}
+	switch {
+	case mt.IsVideo():
+		if !facts.subtitleStream {
+			// Sidecar files can come and go without the video changing.
+			srt := strings.TrimSuffix(filePath, path.Ext(filePath)) + ".srt"
+			if _, err := fs.Stat(fsys, srt); err != nil {
+				me.MissingSubtitles++
+			}
+		}
+	case mt.IsAudio():
+		if facts.probed && !facts.artwork {
+			me.MissingArtwork++
+		}
+	}
+}
+
+// Buckets a video frame size by the usual names. Frames cropped to a wide
+// aspect ratio go by their width.
+func resolutionClass(width, height int64) string {
+	switch {
+	case width >= 3800 || height >= 2100:
+		return "2160p"
+	case width >= 1900 || height >= 1000:
+		return "1080p"
+	case width >= 1260 || height >= 700:
+		return "720p"
+	default:
+		return "SD"
+	}
+}
+
+// Returns the figures from the last complete walk of the search indexer, and
+// false if there hasn't been one, as when there's no search index.
+func (me *Server) LibraryStats() (LibraryStats, bool) {
+	me.libraryStatsMu.Lock()
+	defer me.libraryStatsMu.Unlock()
+	if me.libraryStats == nil {
+		return LibraryStats{}, false
+	}
+	return *me.libraryStats, true
+}
+
+func (me *Server) setLibraryStats(stats *LibraryStats) {
+	stats.Updated = time.Now()
+	me.libraryStatsMu.Lock()
+	me.libraryStats = stats
+	me.libraryStatsMu.Unlock()
+}
+
+// GET returns the LibraryStats as JSON.
+func (me *Server) serveLibraryStatsAPI(w http.ResponseWriter, r *http.Request) {
+	stats, ok := me.LibraryStats()
+	if !ok {
+		http.Error(w, "library not indexed yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
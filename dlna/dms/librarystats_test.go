@@ -0,0 +1,79 @@
+package dms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/search"
+)
+
+func TestLibraryStats(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv":    {Data: make([]byte, 1000)},
+			"Films/Heat.srt":    {},
+			"Films/Up.mkv":      {Data: make([]byte, 500)},
+			"Music/Hang Up.mp3": {Data: make([]byte, 20)},
+			"notes.txt":         {Data: make([]byte, 7)},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		Search:         &search.Index{},
+		Logger:         log.Default,
+	}
+	if _, ok := s.LibraryStats(); ok {
+		t.Fatal("stats before indexing")
+	}
+	s.indexLibrary()
+	stats, ok := s.LibraryStats()
+	if !ok {
+		t.Fatal("no stats after indexing")
+	}
+	if stats.Files != 3 || stats.TotalSize != 1520 || stats.Types["video"] != 2 || stats.Types["audio"] != 1 {
+		t.Errorf("got %+v", stats)
+	}
+	if stats.MissingSubtitles != 1 || stats.MissingArtwork != 0 {
+		t.Errorf("got %+v", stats)
+	}
+
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", libraryStatsAPIPath, nil))
+	if !strings.Contains(w.Body.String(), `"totalSize":1520`) {
+		t.Errorf("got %s", w.Body)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), "Files: 3, 1.5 kB") {
+		t.Errorf("got %s", w.Body)
+	}
+}
+
+func TestMediaFacts(t *testing.T) {
+	info := &ffprobe.Info{Streams: []map[string]interface{}{
+		{"codec_type": "video", "codec_name": "hevc", "width": float64(1920), "height": float64(800)},
+		{"codec_type": "audio", "codec_name": "eac3"},
+		{"codec_type": "subtitle", "codec_name": "subrip"},
+	}}
+	f := newMediaFacts(time.Time{}, "video/x-matroska", info)
+	if f.codec != "hevc" || f.resolution != "1080p" || !f.subtitleStream {
+		t.Errorf("got %+v", f)
+	}
+	f = newMediaFacts(time.Time{}, "audio/mpeg", &ffprobe.Info{Streams: info.Streams[1:2]})
+	if f.codec != "eac3" || f.artwork || !f.probed {
+		t.Errorf("got %+v", f)
+	}
+	for n, want := range map[int64]string{999: "999 B", 1520: "1.5 kB", 4_700_000_000: "4.7 GB"} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
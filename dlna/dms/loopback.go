@@ -0,0 +1,39 @@
+package dms
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Signs the loopback URLs the server gives its own commands, such as ffmpeg,
+// so that clients can't pass their requests off as those. It's made when
+// first needed, and lasts as long as the server.
+type loopbackKey struct {
+	once sync.Once
+	key  []byte
+}
+
+// Returns the token loopback URLs of the path carry.
+func (srv *Server) loopbackToken(path string) string {
+	k := &srv.loopbackKey
+	k.once.Do(func() {
+		k.key = make([]byte, 32)
+		rand.Read(k.key)
+	})
+	mac := hmac.New(sha256.New, k.key)
+	io.WriteString(mac, path)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Reports whether the request is for a loopback URL the server made, so it's
+// from one of its own commands, wherever it appears to come from.
+func (srv *Server) isLoopbackRequest(r *http.Request) bool {
+	query := r.URL.Query()
+	token := query.Get(loopbackQueryKey)
+	return token != "" && hmac.Equal([]byte(token), []byte(srv.loopbackToken(query.Get("path"))))
+}
@@ -1,12 +1,15 @@
 package dms
 
 import (
+	"bytes"
 	"io/fs"
 	"mime"
 	"net/http"
 	"path"
+	"slices"
 	"strings"
 
+	"github.com/anacrolix/ffprobe"
 	"github.com/anacrolix/log"
 )
 
@@ -20,6 +23,35 @@ func init() {
 	if err := mime.AddExtensionType(".ogg", "audio/ogg"); err != nil {
 		log.Printf("Could not register audio/ogg MIME type: %s", err)
 	}
+	// Some systems have .ts as Qt Linguist translations, rather than the TV
+	// recordings they're likelier to be.
+	if err := mime.AddExtensionType(".ts", "video/mp2t"); err != nil {
+		log.Printf("Could not register video/mp2t MIME type: %s", err)
+	}
+	if err := mime.AddExtensionType(".wtv", "video/x-ms-wtv"); err != nil {
+		log.Printf("Could not register video/x-ms-wtv MIME type: %s", err)
+	}
+	for ext, mt := range dsdMimeTypes {
+		if err := mime.AddExtensionType(ext, string(mt)); err != nil {
+			log.Printf("Could not register %s MIME type: %s", mt, err)
+		}
+	}
+}
+
+// DSD audio, keyed by file extension.
+var dsdMimeTypes = map[string]mimeType{
+	".dsf": "audio/x-dsf",
+	".dff": "audio/x-dff",
+}
+
+// IsDSD returns true for DSD audio MIME-types
+func (mt mimeType) IsDSD() bool {
+	for _, dsd := range dsdMimeTypes {
+		if mt == dsd {
+			return true
+		}
+	}
+	return false
 }
 
 // Example: "video/mpeg"
@@ -58,7 +90,8 @@ func (mt mimeType) String() string {
 // MimeTypeByPath determines the MIME-type of file at the given path
 func MimeTypeByPath(fsys fs.FS, filePath string) (ret mimeType, err error) {
 	ret = mimeTypeByBaseName(path.Base(filePath))
-	if ret == "" {
+	// Extensions that say nothing of the content, or no extension at all.
+	if ret == "" || ret == "application/octet-stream" {
 		ret, err = mimeTypeByContent(fsys, filePath)
 	}
 	if ret == "video/x-msvideo" {
@@ -88,7 +121,172 @@ func mimeTypeByContent(fsys fs.FS, path string) (ret mimeType, err error) {
 	defer file.Close()
 	var data [512]byte
 	if n, err := file.Read(data[:]); err == nil {
-		ret = mimeType(http.DetectContentType(data[:n]))
+		ret = sniffMimeType(data[:n])
 	}
 	return
 }
+
+// Guess the MIME-type from the start of a file, knowing the media containers
+// http.DetectContentType doesn't.
+func sniffMimeType(data []byte) mimeType {
+	hasPrefix := func(prefix string) bool {
+		return bytes.HasPrefix(data, []byte(prefix))
+	}
+	switch {
+	case hasPrefix("\x1a\x45\xdf\xa3"):
+		// EBML, which http.DetectContentType takes to be WebM.
+		if bytes.Contains(data[:min(len(data), 64)], []byte("matroska")) {
+			return "video/x-matroska"
+		}
+		return "video/webm"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		switch string(data[8:12]) {
+		case "M4A ", "M4B ":
+			return "audio/mp4"
+		case "qt  ":
+			return "video/quicktime"
+		case "heic", "heix", "mif1":
+			return "image/heic"
+		case "avif":
+			return "image/avif"
+		}
+		return "video/mp4"
+	case hasPrefix("fLaC"):
+		return "audio/flac"
+	case hasPrefix("DSD "):
+		return dsdMimeTypes[".dsf"]
+	case hasPrefix("FRM8"):
+		return dsdMimeTypes[".dff"]
+	case hasPrefix("FLV\x01"):
+		return "video/x-flv"
+	case hasPrefix("\x30\x26\xb2\x75\x8e\x66\xcf\x11"):
+		return "video/x-ms-asf"
+	case hasPrefix("\x00\x00\x01\xba"):
+		return "video/mpeg"
+	case isMPEGTS(data):
+		return "video/mp2t"
+	case len(data) >= 2 && data[0] == 0xff && data[1]&0xf6 == 0xf0:
+		// An ADTS header: MPEG-4 or MPEG-2 AAC, layer 0.
+		return "audio/aac"
+	case len(data) >= 2 && data[0] == 0xff && data[1]&0xe0 == 0xe0 && data[1]&0x06 != 0:
+		// An MPEG audio frame without an ID3 tag.
+		return "audio/mpeg"
+	}
+	return mimeType(http.DetectContentType(data))
+}
+
+// MPEG transport streams are 188 byte packets, each starting with a sync byte.
+func isMPEGTS(data []byte) bool {
+	const packetSize = 188
+	if len(data) < 2*packetSize+1 {
+		return false
+	}
+	for i := 0; i < len(data); i += packetSize {
+		if data[i] != 0x47 {
+			return false
+		}
+	}
+	return true
+}
+
+// How the containers ffprobe reports as its format_name are served.
+var probeFormats = []struct {
+	name string
+	// For files with, and without, moving video. Empty if the container
+	// doesn't hold that.
+	video, audio mimeType
+	// Other MIME-types files in the container go by.
+	others []mimeType
+}{
+	{"matroska,webm", "video/x-matroska", "audio/x-matroska", []mimeType{"video/webm", "audio/webm"}},
+	{"mov,mp4,m4a,3gp,3g2,mj2", "video/mp4", "audio/mp4", []mimeType{"video/quicktime", "video/3gpp", "video/x-m4v", "audio/x-m4a", "audio/m4a"}},
+	{"avi", "video/avi", "", nil},
+	{"mpegts", "video/mp2t", "", []mimeType{"video/vnd.dlna.mpeg-tts"}},
+	{"mpeg", "video/mpeg", "", nil},
+	{"asf", "video/x-ms-asf", "audio/x-ms-wma", []mimeType{"video/x-ms-wmv"}},
+	{"flv", "video/x-flv", "", nil},
+	{"ogg", "video/ogg", "audio/ogg", []mimeType{"application/ogg"}},
+	{"flac", "", "audio/flac", []mimeType{"audio/x-flac"}},
+	{"mp3", "", "audio/mpeg", nil},
+	{"wav", "", "audio/wav", []mimeType{"audio/x-wav", "audio/wave", "audio/vnd.wave"}},
+	{"aac", "", "audio/aac", []mimeType{"audio/x-aac"}},
+}
+
+// Returns the MIME-type of a probed file, correcting the one from its name or
+// content when ffprobe found another container, or a "video" with no moving
+// video. Images, and containers ffprobe doesn't name, are left as they are.
+func probedMimeType(mt mimeType, info *ffprobe.Info) mimeType {
+	if info == nil || mt.IsImage() {
+		return mt
+	}
+	name, _ := info.Format["format_name"].(string)
+	for _, f := range probeFormats {
+		if f.name != name {
+			continue
+		}
+		known := mt == f.video || mt == f.audio || slices.Contains(f.others, mt)
+		video := hasMovingVideo(info)
+		switch {
+		case known && mt.IsVideo() == video:
+			return mt
+		case video && f.video != "":
+			return f.video
+		case !video && f.audio != "":
+			return f.audio
+		case known:
+			return mt
+		case f.video != "":
+			return f.video
+		}
+		return f.audio
+	}
+	return mt
+}
+
+// Returns probedMimeType, noting any correction. Types set in
+// Server.MimeTypes are left as they are.
+func (me *Server) probedMimeType(filePath string, mt mimeType, info *ffprobe.Info) mimeType {
+	if _, ok := configuredMimeType(me.MimeTypes, filePath); ok {
+		return mt
+	}
+	ret := probedMimeType(mt, info)
+	if ret != mt {
+		me.Logger.Levelf(log.Debug, "%q is %s, not %s", filePath, ret, mt)
+	}
+	return ret
+}
+
+// Returns the MIME-type the map gives the file's extension. Extensions are
+// matched ignoring case, ".part" and the leading dot.
+func configuredMimeType(m map[string]string, filePath string) (mimeType, bool) {
+	ext := strings.TrimPrefix(path.Ext(strings.TrimSuffix(filePath, ".part")), ".")
+	if ext == "" {
+		return "", false
+	}
+	for k, v := range m {
+		if v != "" && strings.EqualFold(strings.TrimPrefix(k, "."), ext) {
+			return mimeType(v), true
+		}
+	}
+	return "", false
+}
+
+// Returns the MIME-type of the file from Server.MimeTypes, or failing that,
+// MimeTypeByPath.
+func (me *Server) mimeTypeByPath(filePath string) (mimeType, error) {
+	if mt, ok := configuredMimeType(me.MimeTypes, filePath); ok {
+		return mt, nil
+	}
+	return MimeTypeByPath(me.FS, filePath)
+}
+
+// Returns the MIME-type to give the client for a file of the type, which is
+// the client profile's for the file's extension, if it has one.
+func (me *Server) clientMimeType(userAgent, filePath string, mt mimeType) mimeType {
+	if p := me.clientProfile(userAgent); p != nil {
+		if ret, ok := configuredMimeType(p.MimeTypes, filePath); ok {
+			return ret
+		}
+	}
+	return mt
+}
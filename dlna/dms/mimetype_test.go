@@ -0,0 +1,104 @@
+package dms
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func TestMimeTypeByPathSniffs(t *testing.T) {
+	ts := bytes.Repeat(append([]byte{0x47}, make([]byte, 187)...), 3)
+	fsys := fstest.MapFS{
+		"film":      {Data: []byte("\x1a\x45\xdf\xa3\xa3\x42\x86\x81\x01\x42\x82\x88matroska")},
+		"song":      {Data: []byte("fLaC\x00\x00\x00\x22")},
+		"book.bin":  {Data: []byte("\x00\x00\x00\x20ftypM4B \x00\x00\x00\x00")},
+		"recording": {Data: ts},
+		"notes":     {Data: []byte("just some text")},
+		"cover.png": {Data: []byte("not read")},
+	}
+	for p, want := range map[string]mimeType{
+		"film":      "video/x-matroska",
+		"song":      "audio/flac",
+		"book.bin":  "audio/mp4",
+		"recording": "video/mp2t",
+		"notes":     "text/plain; charset=utf-8",
+	} {
+		if mt, err := MimeTypeByPath(fsys, p); err != nil || mt != want {
+			t.Errorf("%s: got %q, %v, want %q", p, mt, err, want)
+		}
+	}
+	// Files with a media extension aren't read.
+	if mt, _ := MimeTypeByPath(fsys, "cover.png"); mt != "image/png" {
+		t.Errorf("got %q", mt)
+	}
+}
+
+func TestProbedMimeType(t *testing.T) {
+	probe := func(format string, streams ...map[string]interface{}) *ffprobe.Info {
+		return &ffprobe.Info{Format: map[string]interface{}{"format_name": format}, Streams: streams}
+	}
+	video := map[string]interface{}{"codec_type": "video"}
+	audio := map[string]interface{}{"codec_type": "audio"}
+	cover := map[string]interface{}{"codec_type": "video", "disposition": map[string]interface{}{"attached_pic": float64(1)}}
+	for _, c := range []struct {
+		mt   mimeType
+		info *ffprobe.Info
+		want mimeType
+	}{
+		{"video/mp4", probe("mov,mp4,m4a,3gp,3g2,mj2", video, audio), "video/mp4"},
+		{"video/quicktime", probe("mov,mp4,m4a,3gp,3g2,mj2", video, audio), "video/quicktime"},
+		// Misnamed.
+		{"video/mp4", probe("matroska,webm", video, audio), "video/x-matroska"},
+		{"video/avi", probe("mpegts", video, audio), "video/mp2t"},
+		{"audio/mpeg", probe("flac", audio, cover), "audio/flac"},
+		// No moving video.
+		{"video/mp4", probe("mov,mp4,m4a,3gp,3g2,mj2", audio, cover), "audio/mp4"},
+		{"video/mpeg", probe("mpeg", audio), "video/mpeg"},
+		{"audio/ogg", probe("ogg", video, audio), "video/ogg"},
+		// Left alone.
+		{"application/vnd.rn-realmedia-vbr", probe("rm", video, audio), "application/vnd.rn-realmedia-vbr"},
+		{"image/jpeg", probe("jpeg_pipe", video), "image/jpeg"},
+		{"video/mp4", probe(""), "video/mp4"},
+		{"video/mp4", nil, "video/mp4"},
+	} {
+		if got := probedMimeType(c.mt, c.info); got != c.want {
+			t.Errorf("%s probed as %v: got %s, want %s", c.mt, c.info.Format, got, c.want)
+		}
+	}
+}
+
+func TestConfiguredMimeTypes(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/a.mkv": {},
+			"Films/b.vob": {},
+			"Music/c.MKA": {},
+		},
+		MimeTypes: map[string]string{".mka": "audio/x-matroska", "vob": "video/mpeg"},
+		ClientProfiles: []ClientProfile{{
+			UserAgent: "SEC_HHP_",
+			MimeTypes: map[string]string{"MKV": "video/x-mkv"},
+		}},
+	}
+	for p, want := range map[string]mimeType{
+		"Films/b.vob": "video/mpeg",
+		"Music/c.MKA": "audio/x-matroska",
+	} {
+		if mt, err := s.mimeTypeByPath(p); err != nil || mt != want {
+			t.Errorf("%s: got %q, %v", p, mt, err)
+		}
+	}
+	// The configured type isn't corrected by probing.
+	info := &ffprobe.Info{Format: map[string]interface{}{"format_name": "matroska,webm"}}
+	if mt := s.probedMimeType("Music/c.MKA", "audio/x-matroska", info); mt != "audio/x-matroska" {
+		t.Errorf("got %q", mt)
+	}
+	if mt := s.clientMimeType("SEC_HHP_,Samsung", "Films/a.mkv", "video/x-matroska"); mt != "video/x-mkv" {
+		t.Errorf("got %q", mt)
+	}
+	if mt := s.clientMimeType("VLC", "Films/a.mkv", "video/x-matroska"); mt != "video/x-matroska" {
+		t.Errorf("got %q", mt)
+	}
+}
@@ -0,0 +1,197 @@
+package dms
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// Returns an FS of fsys without the folders on other filesystems than its
+// root, such as mounts within it, or reached through symlinks. If the device
+// of files can't be told, as on Windows, it's fsys.
+func oneFileSystemFS(fsys fs.FS) (fs.FS, error) {
+	fi, err := fs.Stat(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	dev, ok := fileDevice(fi)
+	if !ok {
+		return fsys, nil
+	}
+	return oneFileSystem{fsys, dev}, nil
+}
+
+type oneFileSystem struct {
+	fs.FS
+	dev uint64
+}
+
+// Reports whether the file is on another filesystem than the root.
+func (me oneFileSystem) crosses(fi fs.FileInfo) bool {
+	dev, ok := fileDevice(fi)
+	return ok && dev != me.dev
+}
+
+func (me oneFileSystem) Stat(name string) (fs.FileInfo, error) {
+	fi, err := fs.Stat(me.FS, name)
+	if err == nil && me.crosses(fi) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fi, err
+}
+
+func (me oneFileSystem) Open(name string) (fs.File, error) {
+	fi, err := me.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := me.FS.Open(name)
+	if err != nil || !fi.IsDir() {
+		return f, err
+	}
+	return oneFileSystemDir{f, me, name}, nil
+}
+
+func (me oneFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	if _, err := me.Stat(name); err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(me.FS, name)
+	return me.filter(name, entries), err
+}
+
+// Removes the entries of the directory on other filesystems.
+func (me oneFileSystem) filter(dir string, entries []fs.DirEntry) []fs.DirEntry {
+	ret := entries[:0]
+	for _, e := range entries {
+		// Files can't be mounts, short of bind mounts.
+		if e.IsDir() || e.Type()&fs.ModeSymlink != 0 {
+			if fi, err := fs.Stat(me.FS, path.Join(dir, e.Name())); err == nil && me.crosses(fi) {
+				continue
+			}
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+type oneFileSystemDir struct {
+	fs.File
+	fsys oneFileSystem
+	name string
+}
+
+func (me oneFileSystemDir) ReadDir(n int) (ret []fs.DirEntry, err error) {
+	d, ok := me.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: me.name, Err: errors.New("not implemented")}
+	}
+	// Read on until there are n left after filtering.
+	for {
+		var entries []fs.DirEntry
+		entries, err = d.ReadDir(n - len(ret))
+		ret = append(ret, me.fsys.filter(me.name, entries)...)
+		if n <= 0 || len(ret) == n || err != nil {
+			return
+		}
+	}
+}
+
+// Returned for calls to an FS made with timeoutFS that took too long.
+var errFSTimeout = errors.New("timed out, the filesystem may be hung")
+
+// Returns an FS of fsys whose Open, Stat and ReadDir give up after the
+// timeout, so that a hung network mount doesn't hold up every request that
+// touches it. Calls given up on carry on in the background, and until they
+// return, calls for their path or anything in it fail straight away, rather
+// than piling up. Reading files isn't timed.
+func timeoutFS(fsys fs.FS, timeout time.Duration, logger log.Logger) fs.FS {
+	return &timedFS{FS: fsys, timeout: timeout, logger: logger}
+}
+
+type timedFS struct {
+	fs.FS
+	timeout time.Duration
+	logger  log.Logger
+
+	mu sync.Mutex
+	// The paths of calls given up on, and how many of each are running.
+	stuck map[string]int
+}
+
+// Reports whether a call given up on is for the path or a folder it's in.
+func (me *timedFS) stuckWithin(name string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for p := range me.stuck {
+		if p == "." || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (me *timedFS) setStuck(name string, delta int) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.stuck == nil {
+		me.stuck = make(map[string]int)
+	}
+	me.stuck[name] += delta
+	if me.stuck[name] == 0 {
+		delete(me.stuck, name)
+	}
+}
+
+// Calls f, the op on the path, giving up after the timeout.
+func timedCall[T any](me *timedFS, op, name string, f func() (T, error)) (ret T, err error) {
+	if me.stuckWithin(name) {
+		return ret, &fs.PathError{Op: op, Path: name, Err: errFSTimeout}
+	}
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := f()
+		done <- result{v, err}
+	}()
+	timer := time.NewTimer(me.timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-timer.C:
+	}
+	me.logger.Levelf(log.Warning, "%s %q: not done after %v, the filesystem may be hung", op, name, me.timeout)
+	me.setStuck(name, 1)
+	go func() {
+		r := <-done
+		me.setStuck(name, -1)
+		me.logger.Printf("%s %q: done after being given up on", op, name)
+		// Nobody will close a file opened too late.
+		if c, ok := any(r.v).(io.Closer); ok && r.err == nil {
+			c.Close()
+		}
+	}()
+	return ret, &fs.PathError{Op: op, Path: name, Err: errFSTimeout}
+}
+
+func (me *timedFS) Open(name string) (fs.File, error) {
+	return timedCall(me, "open", name, func() (fs.File, error) { return me.FS.Open(name) })
+}
+
+func (me *timedFS) Stat(name string) (fs.FileInfo, error) {
+	return timedCall(me, "stat", name, func() (fs.FileInfo, error) { return fs.Stat(me.FS, name) })
+}
+
+func (me *timedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return timedCall(me, "readdir", name, func() ([]fs.DirEntry, error) { return fs.ReadDir(me.FS, name) })
+}
@@ -0,0 +1,68 @@
+package dms
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// An FS whose calls for paths in NAS block until unblocked.
+type hungFS struct {
+	fstest.MapFS
+	unblock chan struct{}
+}
+
+func (me hungFS) wait(name string) {
+	if name == "NAS" || strings.HasPrefix(name, "NAS/") {
+		<-me.unblock
+	}
+}
+
+func (me hungFS) Open(name string) (fs.File, error) {
+	me.wait(name)
+	return me.MapFS.Open(name)
+}
+
+func (me hungFS) Stat(name string) (fs.FileInfo, error) {
+	me.wait(name)
+	return me.MapFS.Stat(name)
+}
+
+func (me hungFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	me.wait(name)
+	return me.MapFS.ReadDir(name)
+}
+
+func TestTimeoutFS(t *testing.T) {
+	hung := hungFS{fstest.MapFS{
+		"Films/Heat.mkv": {},
+		"NAS/Ran.mkv":    {},
+	}, make(chan struct{})}
+	fsys := timeoutFS(hung, 10*time.Millisecond, log.Default).(*timedFS)
+	if _, err := fs.ReadDir(fsys, "Films"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(fsys, "NAS"); !errors.Is(err, errFSTimeout) {
+		t.Fatalf("got %v", err)
+	}
+	// Fails straight away, rather than waiting out the timeout again.
+	started := time.Now()
+	if _, err := fsys.Open("NAS/Ran.mkv"); !errors.Is(err, errFSTimeout) || time.Since(started) >= 10*time.Millisecond {
+		t.Errorf("got %v after %v", err, time.Since(started))
+	}
+	if _, err := fs.Stat(fsys, "Films/Heat.mkv"); err != nil {
+		t.Error(err)
+	}
+	close(hung.unblock)
+	for fsys.stuckWithin("NAS") {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := fs.ReadFile(fsys, "NAS/Ran.mkv"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,136 @@
+package dms
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sync"
+)
+
+// FS paths in the order they were added, as kept for Favorites and
+// HiddenPaths. The zero value is ready for use.
+type pathList struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// Adds the path, reporting whether it wasn't there already.
+func (me *pathList) add(p string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if slices.Contains(me.paths, p) {
+		return false
+	}
+	me.paths = append(me.paths, p)
+	return true
+}
+
+// Removes the path, reporting whether it was there.
+func (me *pathList) remove(p string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	i := slices.Index(me.paths, p)
+	if i < 0 {
+		return false
+	}
+	me.paths = slices.Delete(me.paths, i, i+1)
+	return true
+}
+
+// Reports whether the path, or a folder containing it, is in the list.
+func (me *pathList) containsWithin(p string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if len(me.paths) == 0 {
+		return false
+	}
+	for dir := path.Clean(p); ; dir = path.Dir(dir) {
+		if slices.Contains(me.paths, dir) {
+			return true
+		}
+		if dir == "." || dir == "/" {
+			return false
+		}
+	}
+}
+
+// Returns the paths, most recently added first.
+func (me *pathList) list() []string {
+	me.mu.Lock()
+	ret := slices.Clone(me.paths)
+	me.mu.Unlock()
+	slices.Reverse(ret)
+	return ret
+}
+
+// Reads paths saved by Save, replacing the current ones.
+func (me *pathList) Load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	if err := json.Unmarshal(b, &paths); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	me.paths = paths
+	me.mu.Unlock()
+	return nil
+}
+
+// Writes the paths to a file.
+func (me *pathList) Save(path string) error {
+	me.mu.Lock()
+	b, err := json.Marshal(me.paths)
+	me.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// Serves an API for changing a pathList. GET lists the paths the client may
+// see, as paths from the root, most recently added first. POST adds the file
+// or folder in the path form value, or removes it if the remove form value is
+// set, and DELETE removes it too. If the redirect form value is set, a
+// successful POST redirects to the web UI.
+func (me *Server) servePathListAPI(w http.ResponseWriter, r *http.Request, l *pathList, visible func(client string) []string) {
+	client := playbackClient(r)
+	switch r.Method {
+	case "GET":
+	case "POST", "DELETE":
+		given := r.FormValue("path")
+		if given == "" {
+			http.Error(w, "no path", http.StatusBadRequest)
+			return
+		}
+		filePath := me.filePath(given)
+		if r.Method == "DELETE" || r.FormValue("remove") != "" {
+			l.remove(filePath)
+		} else {
+			if _, err := fs.Stat(me.FS, filePath); err != nil || me.restrictedFrom(client, filePath) || filePath == path.Clean(me.RootObjectPath) {
+				http.Error(w, "no such object", http.StatusNotFound)
+				return
+			}
+			l.add(filePath)
+		}
+		if r.Method == "POST" && r.FormValue("redirect") != "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ret := []string{}
+	for _, p := range visible(client) {
+		ret = append(ret, "/"+p)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
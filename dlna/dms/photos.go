@@ -0,0 +1,106 @@
+package dms
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/exif"
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/transcode"
+)
+
+// Returns the EXIF metadata of a photo, if it's a JPEG or TIFF with any.
+func (me *Server) photoMetadata(filePath string, mt mimeType) (m exif.Metadata, ok bool) {
+	if mt != "image/jpeg" && mt != "image/tiff" {
+		return
+	}
+	f, err := me.FS.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	m, err = exif.Read(f)
+	return m, err == nil
+}
+
+// Returns the search document of a photo, from the index if it's up to date,
+// so folders of photos are listed without reading each one.
+func (me *Server) photoDocument(filePath string, fi fs.FileInfo, mt mimeType) search.Document {
+	if me.Search != nil {
+		if doc, ok := me.Search.Get(filePath); ok && doc.ModTime.Equal(fi.ModTime()) {
+			return doc
+		}
+	}
+	return me.searchDocument(filePath, fi, mt, nil)
+}
+
+// Reports whether images of the type are served to the client converted to
+// JPEG, because it can't show them.
+func (me *Server) convertsImage(userAgent string, mt mimeType) bool {
+	return mt.IsImage() && !me.NoTranscode && !me.clientProfile(userAgent).ShowsImage(string(mt))
+}
+
+// Serves an image converted to JPEG, from the ImageCache if it's been
+// converted before.
+func (me *Server) serveConvertedImage(w http.ResponseWriter, r *http.Request, filePath string) {
+	var (
+		cacheKey *convertedImageCacheKey
+		modTime  time.Time
+	)
+	if fi, err := fs.Stat(me.FS, filePath); err == nil {
+		modTime = fi.ModTime()
+		if me.ImageCache != nil {
+			cacheKey = &convertedImageCacheKey{filePath, modTime.UnixNano()}
+		}
+	}
+	var body []byte
+	if cacheKey != nil {
+		if cached, ok := me.ImageCache.Get(*cacheKey); ok {
+			body = cached.([]byte)
+		}
+	}
+	if body == nil {
+		var err error
+		body, err = me.convertImage(r, filePath)
+		if err != nil {
+			me.Logger.Levelf(log.Warning, "converting %q to JPEG: %v", filePath, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if cacheKey != nil {
+			me.ImageCache.Set(*cacheKey, body)
+		}
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	if r.Header.Get("getContentFeatures.dlna.org") != "" {
+		w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{
+			SupportRange: true,
+			Flags:        me.dlnaFlags(r.UserAgent(), ImageResource),
+		}.String())
+	}
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+}
+
+func (me *Server) convertImage(r *http.Request, filePath string) ([]byte, error) {
+	session := me.startTranscodeSession(r, filePath, "jpeg", time.Now(), "")
+	p, err := transcode.ImageToJPEG(r.Context(), me.loopbackResURL(filePath), nil)
+	if err != nil {
+		me.endTranscodeSession(session, nil, err)
+		return nil, err
+	}
+	var b bytes.Buffer
+	_, err = io.Copy(session.writer(&b), p)
+	p.Close()
+	me.endTranscodeSession(session, p, nil)
+	if err == nil && b.Len() == 0 {
+		err = errors.New("ffmpeg produced nothing")
+	}
+	return b.Bytes(), err
+}
@@ -0,0 +1,150 @@
+package dms
+
+import (
+	"context"
+	"encoding/binary"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Returns a 640x480 JPEG taken with the camera at the time.
+func exifJPEG(model, dateTime string) []byte {
+	// IFD0 at 8 with two entries is 30 bytes, so the strings start at 38.
+	tiff := []byte("MM\x00*\x00\x00\x00\x08")
+	u16 := func(v uint16) { tiff = binary.BigEndian.AppendUint16(tiff, v) }
+	u32 := func(v uint32) { tiff = binary.BigEndian.AppendUint32(tiff, v) }
+	u16(2)
+	for _, e := range []struct {
+		tag   uint16
+		value string
+	}{{0x0110, model}, {0x0132, dateTime}} {
+		u16(e.tag)
+		u16(2)
+		u32(uint32(len(e.value) + 1))
+		if e.tag == 0x0110 {
+			u32(38)
+		} else {
+			u32(38 + uint32(len(model)) + 1)
+		}
+	}
+	u32(0)
+	tiff = append(tiff, model+"\x00"+dateTime+"\x00"...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	b := []byte{0xff, 0xd8, 0xff, 0xe1}
+	b = binary.BigEndian.AppendUint16(b, uint16(2+len(app1)))
+	b = append(b, app1...)
+	b = append(b, 0xff, 0xc0, 0, 11, 8, 0x01, 0xe0, 0x02, 0x80, 1, 1, 0x11, 0)
+	return append(b, 0xff, 0xda, 0, 2)
+}
+
+func TestPhotoMetadata(t *testing.T) {
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Server{
+		FS: fstest.MapFS{
+			"Photos/beach.jpg": {Data: exifJPEG("Pixel 8a", "2024:08:15 09:41:00"), ModTime: modTime},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+	}
+	cds := &contentDirectoryService{Server: s}
+	browse := func() upnpav.Item {
+		fi, err := fs.Stat(s.FS, "Photos/beach.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := cds.cdsObjectToUpnpavObject(context.Background(), object{"Photos/beach.jpg", "."}, fi, "localhost", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj.(upnpav.Item)
+	}
+	item := browse()
+	if d := item.Date.Format("2006-01-02"); d != "2024-08-15" || item.Creator != "Pixel 8a" || item.Res[0].Resolution != "640x480" {
+		t.Fatalf("got date %s, creator %q and resolution %q", d, item.Creator, item.Res[0].Resolution)
+	}
+	// An up to date document in the index is used, rather than reading the
+	// photo again.
+	s.Search = &search.Index{}
+	s.Search.Update(search.Document{
+		ID:      "Photos/beach.jpg",
+		ModTime: modTime,
+		Fields:  map[string][]string{"dc:date": {"2024-08-16"}, "dc:creator": {"Pixel 9"}},
+	})
+	if item := browse(); item.Creator != "Pixel 9" || item.Res[0].Resolution != "" {
+		t.Fatalf("got creator %q and resolution %q", item.Creator, item.Res[0].Resolution)
+	}
+}
+
+func TestConvertedImage(t *testing.T) {
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &Server{
+		FS: fstest.MapFS{
+			"Photos/cat.webp": {Data: []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), ModTime: modTime},
+			"Photos/dog.jpg":  {Data: []byte{0xff, 0xd8, 0xff, 0xda}, ModTime: modTime},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		Logger:         log.Default,
+		ClientProfiles: []ClientProfile{{Name: "old tv", UserAgent: "OldTV", ImageTypes: []string{"image/jpeg", "image/png"}}},
+	}
+	s.initCaches()
+	cds := &contentDirectoryService{Server: s}
+	res := func(name, userAgent string) upnpav.Resource {
+		fi, err := fs.Stat(s.FS, "Photos/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := cds.cdsObjectToUpnpavObject(context.Background(), object{"Photos/" + name, "."}, fi, "localhost", userAgent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj.(upnpav.Item).Res[0]
+	}
+	if r := res("cat.webp", "OldTV"); !strings.HasPrefix(r.ProtocolInfo, "http-get:*:image/jpeg:") || r.Size != 0 {
+		t.Fatalf("got %+v", r)
+	}
+	if r := res("cat.webp", "NewTV"); !strings.HasPrefix(r.ProtocolInfo, "http-get:*:image/webp:") || r.Size == 0 {
+		t.Fatalf("got %+v", r)
+	}
+	if r := res("dog.jpg", "OldTV"); r.Size == 0 {
+		t.Fatalf("got %+v", r)
+	}
+
+	// The conversion is served from the cache.
+	s.ImageCache.Set(convertedImageCacheKey{"Photos/cat.webp", modTime.UnixNano()}, []byte("converted"))
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	get := func(query, userAgent string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", resPath+"?"+query, nil)
+		r.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+	if w := get("path=Photos%2Fcat.webp", "OldTV"); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" || w.Body.String() != "converted" {
+		t.Fatalf("got %d %q: %q", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	if w := get("path=Photos%2Fcat.webp", "NewTV"); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "RIFF") {
+		t.Fatalf("got %d: %q", w.Code, w.Body)
+	}
+	// ffmpeg reads the original through the loopback.
+	if w := get("path=Photos%2Fcat.webp&"+loopbackQueryKey+"="+s.loopbackToken("Photos/cat.webp"), "OldTV"); !strings.HasPrefix(w.Body.String(), "RIFF") {
+		t.Fatalf("got %d: %q", w.Code, w.Body)
+	}
+	// Clients can't pass themselves off as it.
+	if w := get("path=Photos%2Fcat.webp&"+loopbackQueryKey+"=1", "OldTV"); w.Body.String() != "converted" {
+		t.Fatalf("got %d: %q", w.Code, w.Body)
+	}
+}
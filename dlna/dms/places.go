@@ -0,0 +1,279 @@
+package dms
+
+import (
+	"context"
+	"io/fs"
+	"math"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Names the place at a position, for grouping photos by where they were
+// taken. geonames.Cities does it offline.
+type Geocoder interface {
+	// Returns the ISO 3166-1 alpha-2 code of the country, such as "FR", and
+	// the name of the city or town.
+	ReverseGeocode(ctx context.Context, lat, lon float64) (countryCode, city string, err error)
+}
+
+// The ObjectID of the container of photos by country and then city. Those of
+// the countries and cities are under it, separated by '/'.
+const placesID = virtualIDPrefix + "places"
+
+// Paths of located photos, by country code and then city, in order.
+type placeTree map[string]map[string][]string
+
+type place struct {
+	countryCode, city string
+}
+
+// Positions are geocoded to the nearest hundredth of a degree, about a
+// kilometre, so photos taken together are geocoded once.
+func roundLocation(l search.Location) search.Location {
+	return search.Location{Lat: math.Round(l.Lat*100) / 100, Lon: math.Round(l.Lon*100) / 100}
+}
+
+// Returns the place of a position, remembering it, and failures, for the
+// next time.
+func (me *Server) geocode(ctx context.Context, l search.Location) (place, bool) {
+	l = roundLocation(l)
+	me.placesMu.Lock()
+	p, ok := me.geocoded[l]
+	me.placesMu.Unlock()
+	if ok {
+		return p, p.city != ""
+	}
+	country, city, err := me.Geocoder.ReverseGeocode(ctx, l.Lat, l.Lon)
+	if err != nil {
+		if ctx.Err() != nil {
+			return place{}, false
+		}
+		country, city = "", ""
+	}
+	p = place{strings.ToUpper(country), city}
+	me.placesMu.Lock()
+	if me.geocoded == nil {
+		me.geocoded = make(map[search.Location]place)
+	}
+	me.geocoded[l] = p
+	me.placesMu.Unlock()
+	return p, p.city != ""
+}
+
+// Groups the photos located in the search index by the places the Geocoder
+// names.
+func (me *Server) updatePlaces(ctx context.Context) {
+	if me.Geocoder == nil || me.Search == nil {
+		return
+	}
+	tree := make(placeTree)
+	for _, id := range me.Search.IDs() {
+		doc, ok := me.Search.Get(id)
+		if !ok || doc.Location == nil {
+			continue
+		}
+		p, ok := me.geocode(ctx, *doc.Location)
+		if ctx.Err() != nil {
+			return
+		}
+		if !ok {
+			continue
+		}
+		if tree[p.countryCode] == nil {
+			tree[p.countryCode] = make(map[string][]string)
+		}
+		tree[p.countryCode][p.city] = append(tree[p.countryCode][p.city], id)
+	}
+	for _, cities := range tree {
+		for _, paths := range cities {
+			slices.Sort(paths)
+		}
+	}
+	me.placesMu.Lock()
+	me.places = tree
+	me.placesMu.Unlock()
+}
+
+// Returns the name of the country in the language, or its code if it's not
+// known.
+func countryName(lang, code string) string {
+	region, err := language.ParseRegion(code)
+	if err != nil {
+		return code
+	}
+	if name := display.Regions(language.Make(lang)).Name(region); name != "" {
+		return name
+	}
+	return code
+}
+
+// Returns the country and city of a places ObjectID, and how deep it is: 0
+// for the places container, 1 for a country and 2 for a city.
+func parsePlacesID(id string) (country, city string, depth int, ok bool) {
+	rest, ok := strings.CutPrefix(id, placesID)
+	if !ok {
+		return
+	}
+	if rest == "" {
+		return "", "", 0, true
+	}
+	parts := strings.Split(strings.TrimPrefix(rest, "/"), "/")
+	if !strings.HasPrefix(rest, "/") || len(parts) > 2 {
+		return "", "", 0, false
+	}
+	country = parts[0]
+	if len(parts) == 1 {
+		return country, "", 1, country != ""
+	}
+	city, err := url.PathUnescape(parts[1])
+	return country, city, 2, err == nil && country != "" && city != ""
+}
+
+func placesCountryID(country string) string {
+	return placesID + "/" + country
+}
+
+func placesCityID(country, city string) string {
+	return placesCountryID(country) + "/" + url.PathEscape(city)
+}
+
+// Returns a function reporting whether the client may not see a photo.
+func (me *contentDirectoryService) photoHidden(userAgent, client string) func(string) bool {
+	return func(p string) bool {
+		return me.hiddenFrom(client, p) || me.hiddenByRating(userAgent, p)
+	}
+}
+
+// Returns the photos taken in the city that the client may see.
+func (me *contentDirectoryService) cityPhotos(country, city, userAgent, client string) []string {
+	me.placesMu.Lock()
+	paths := slices.Clone(me.places[country][city])
+	me.placesMu.Unlock()
+	return slices.DeleteFunc(paths, me.photoHidden(userAgent, client))
+}
+
+// Returns the cities of the country, in order, with photos the client may
+// see.
+func (me *contentDirectoryService) countryCities(country, userAgent, client string) (ret []string) {
+	// The tree is replaced rather than changed, so it can be read unlocked.
+	me.placesMu.Lock()
+	cities := me.places[country]
+	me.placesMu.Unlock()
+	hidden := me.photoHidden(userAgent, client)
+	for city, paths := range cities {
+		if !slices.ContainsFunc(paths, func(p string) bool { return !hidden(p) }) {
+			continue
+		}
+		ret = append(ret, city)
+	}
+	slices.Sort(ret)
+	return
+}
+
+// Returns the countries with photos the client may see, in order of their
+// names.
+func (me *contentDirectoryService) placesCountries(userAgent, client string) (ret []string) {
+	me.placesMu.Lock()
+	countries := make([]string, 0, len(me.places))
+	for country := range me.places {
+		countries = append(countries, country)
+	}
+	me.placesMu.Unlock()
+	for _, country := range countries {
+		if len(me.countryCities(country, userAgent, client)) != 0 {
+			ret = append(ret, country)
+		}
+	}
+	lang := me.language()
+	slices.SortFunc(ret, func(a, b string) int {
+		return strings.Compare(countryName(lang, a), countryName(lang, b))
+	})
+	return
+}
+
+// Returns the container with the places ObjectID.
+func (me *contentDirectoryService) placesObject(id, userAgent, client string) (upnpav.Container, bool) {
+	country, city, depth, ok := parsePlacesID(id)
+	if !ok {
+		return upnpav.Container{}, false
+	}
+	switch depth {
+	case 0:
+		return upnpav.Container{
+			Object: upnpav.Object{
+				ID:         placesID,
+				ParentID:   "0",
+				Restricted: 1,
+				Title:      translate(me.language(), "Places"),
+				Class:      "object.container",
+			},
+			ChildCount: len(me.placesCountries(userAgent, client)),
+		}, true
+	case 1:
+		return upnpav.Container{
+			Object: upnpav.Object{
+				ID:         placesCountryID(country),
+				ParentID:   placesID,
+				Restricted: 1,
+				Title:      countryName(me.language(), country),
+				Class:      "object.container",
+			},
+			ChildCount: len(me.countryCities(country, userAgent, client)),
+		}, true
+	default:
+		return upnpav.Container{
+			Object: upnpav.Object{
+				ID:         placesCityID(country, city),
+				ParentID:   placesCountryID(country),
+				Restricted: 1,
+				Title:      city,
+				Class:      "object.container.album.photoAlbum",
+			},
+			ChildCount: len(me.cityPhotos(country, city, userAgent, client)),
+		}, true
+	}
+}
+
+// Returns the children of the container with the places ObjectID.
+func (me *contentDirectoryService) placesChildren(ctx context.Context, id, host, userAgent, client string) (ret []interface{}, ok bool) {
+	country, city, depth, ok := parsePlacesID(id)
+	if !ok {
+		return nil, false
+	}
+	switch depth {
+	case 0:
+		for _, country := range me.placesCountries(userAgent, client) {
+			c, _ := me.placesObject(placesCountryID(country), userAgent, client)
+			ret = append(ret, c)
+		}
+	case 1:
+		for _, city := range me.countryCities(country, userAgent, client) {
+			c, _ := me.placesObject(placesCityID(country, city), userAgent, client)
+			ret = append(ret, c)
+		}
+	default:
+		for _, p := range me.cityPhotos(country, city, userAgent, client) {
+			fi, err := fs.Stat(me.FS, p)
+			if err != nil {
+				continue
+			}
+			obj, err := me.cdsObjectToUpnpavObject(ctx, object{p, me.RootObjectPath}, fi, host, userAgent)
+			if err != nil {
+				me.Logger.Printf("error with %s: %s", p, err)
+				continue
+			}
+			if item, ok := obj.(upnpav.Item); ok {
+				item.ParentID = id
+				ret = append(ret, item)
+			}
+		}
+	}
+	return ret, true
+}
@@ -0,0 +1,146 @@
+package dms
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Returns a JPEG holding only EXIF metadata with the GPS position.
+func gpsJPEG(lat, lon float64) []byte {
+	latRef, lonRef := "N", "E"
+	if lat < 0 {
+		latRef, lat = "S", -lat
+	}
+	if lon < 0 {
+		lonRef, lon = "W", -lon
+	}
+	// IFD0 with the GPS IFD pointer at 8, the GPS IFD at 26, and the degrees,
+	// minutes and seconds of the latitude at 80 and longitude at 104.
+	tiff := []byte("MM\x00*\x00\x00\x00\x08")
+	u16 := func(v uint16) { tiff = binary.BigEndian.AppendUint16(tiff, v) }
+	u32 := func(v uint32) { tiff = binary.BigEndian.AppendUint32(tiff, v) }
+	u16(1)
+	u16(0x8825)
+	u16(4)
+	u32(1)
+	u32(26)
+	u32(0)
+	u16(4)
+	for _, e := range []struct {
+		tag, typ uint16
+		value    uint32
+	}{{1, 2, uint32(latRef[0]) << 24}, {2, 5, 80}, {3, 2, uint32(lonRef[0]) << 24}, {4, 5, 104}} {
+		u16(e.tag)
+		u16(e.typ)
+		u32(map[uint16]uint32{2: 2, 5: 3}[e.typ])
+		u32(e.value)
+	}
+	u32(0)
+	for _, deg := range []float64{lat, lon} {
+		u32(uint32(math.Round(deg * 1e6)))
+		u32(1e6)
+		u32(0)
+		u32(1)
+		u32(0)
+		u32(1)
+	}
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	b := []byte{0xff, 0xd8, 0xff, 0xe1}
+	b = binary.BigEndian.AppendUint16(b, uint16(2+len(app1)))
+	return append(b, app1...)
+}
+
+// Names places from a table, by latitude.
+type testGeocoder map[float64][2]string
+
+func (me testGeocoder) ReverseGeocode(ctx context.Context, lat, lon float64) (string, string, error) {
+	p, ok := me[lat]
+	if !ok {
+		return "", "", errors.New("nowhere")
+	}
+	return p[0], p[1], nil
+}
+
+func TestPlaces(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Photos/eiffel.jpg":   {Data: gpsJPEG(48.86, 2.29)},
+			"Photos/louvre.jpg":   {Data: gpsJPEG(48.861, 2.336)},
+			"Photos/lyon.jpg":     {Data: gpsJPEG(45.76, 4.84)},
+			"Photos/berlin.jpg":   {Data: gpsJPEG(52.52, 13.40)},
+			"Photos/atlantic.jpg": {Data: gpsJPEG(30, -40)},
+			"Photos/scan.jpg":     {Data: []byte{0xff, 0xd8, 0xff, 0xda}},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		Search:         &search.Index{},
+		HiddenPaths:    &HiddenPaths{},
+		Language:       "de",
+		Geocoder: testGeocoder{
+			48.86: {"fr", "Paris"},
+			45.76: {"FR", "Lyon"},
+			52.52: {"DE", "Berlin"},
+		},
+	}
+	s.indexLibrary()
+	if doc, _ := s.Search.Get("Photos/eiffel.jpg"); doc.Location == nil || math.Abs(doc.Location.Lat-48.86) > 1e-6 {
+		t.Fatalf("got location %v", doc.Location)
+	}
+	if doc, _ := s.Search.Get("Photos/scan.jpg"); doc.Location != nil {
+		t.Errorf("got location %v", doc.Location)
+	}
+	cdService := &contentDirectoryService{Server: s}
+	browse := func(id string) (titles []string) {
+		objs, ok := cdService.placesChildren(context.Background(), id, "localhost", "", "")
+		if !ok {
+			t.Fatalf("%q isn't a places ID", id)
+		}
+		for _, obj := range objs {
+			switch obj := obj.(type) {
+			case upnpav.Container:
+				titles = append(titles, obj.Title)
+			case upnpav.Item:
+				if obj.ParentID != id {
+					t.Errorf("%q has parent %q", obj.Title, obj.ParentID)
+				}
+				titles = append(titles, obj.Title)
+			}
+		}
+		return
+	}
+	check := func(id string, want ...string) {
+		t.Helper()
+		if got := browse(id); !slices.Equal(got, want) {
+			t.Errorf("%q: got %q, want %q", id, got, want)
+		}
+	}
+	// Deutschland sorts before Frankreich.
+	check(placesID, "Deutschland", "Frankreich")
+	check(placesCountryID("FR"), "Lyon", "Paris")
+	check(placesCityID("FR", "Paris"), "eiffel.jpg", "louvre.jpg")
+	if c, ok := cdService.placesObject(placesCityID("FR", "Paris"), "", ""); !ok || c.ChildCount != 2 || c.ParentID != placesCountryID("FR") {
+		t.Errorf("got %+v", c)
+	}
+	root := cdService.rootVirtualContainers("", "")
+	if len(root) != 1 || root[0].(upnpav.Container).Title != "Orte" {
+		t.Errorf("got root %+v", root)
+	}
+
+	s.HiddenPaths.add("Photos/berlin.jpg")
+	check(placesID, "Frankreich")
+	if _, ok := cdService.placesObject(placesID+"/DE/Berlin/x", "", ""); ok {
+		t.Error("parsed a bad ID")
+	}
+}
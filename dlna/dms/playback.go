@@ -0,0 +1,399 @@
+package dms
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/scrobble"
+)
+
+// Streams that send less than this are assumed to be clients probing the
+// file, rather than playing it.
+const minPlaybackBytes = 256 << 10
+
+// Transcodes sent for less time than this are assumed to be probes.
+const minPlaybackTime = 10 * time.Second
+
+// Playback positions within this of the end count as finished.
+const playbackFinishedMargin = 15 * time.Second
+
+// What a client has played of a file.
+type PlaybackRecord struct {
+	// Where playback last got to.
+	Position time.Duration
+	// The furthest playback has got since it last finished, which is where
+	// to resume from.
+	Furthest time.Duration
+	// The length of the file, or zero if unknown.
+	Duration   time.Duration
+	LastPlayed time.Time
+	// How many times playback has been started from scratch.
+	PlayCount int
+}
+
+// Reports whether playback got to the end.
+func (me PlaybackRecord) Finished() bool {
+	return me.Duration > 0 && me.Furthest >= me.Duration-playbackFinishedMargin
+}
+
+// A PlaybackRecord with the FS path of the file it's for.
+type playbackEntry struct {
+	Path string
+	PlaybackRecord
+}
+
+// Records how far clients get streaming media, so they can resume. Clients are
+// told apart by IP address. The zero value is ready for use.
+type PlaybackHistory struct {
+	mu sync.Mutex
+	// By client, then by FS path.
+	records map[string]map[string]PlaybackRecord
+}
+
+// Stores the record, returning the one it follows on from, which is the zero
+// value if this is a new play, and what was stored.
+func (me *PlaybackHistory) record(client, path string, rec PlaybackRecord) (before, after PlaybackRecord) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.records == nil {
+		me.records = make(map[string]map[string]PlaybackRecord)
+	}
+	if me.records[client] == nil {
+		me.records[client] = make(map[string]PlaybackRecord)
+	}
+	rec.Furthest = rec.Position
+	rec.PlayCount = 1
+	if old, ok := me.records[client][path]; ok {
+		rec.PlayCount = old.PlayCount
+		if old.Finished() {
+			rec.PlayCount++
+		} else {
+			rec.Furthest = max(old.Furthest, rec.Position)
+			before = old
+		}
+	}
+	me.records[client][path] = rec
+	return before, rec
+}
+
+// Stores the record as is.
+func (me *PlaybackHistory) set(client, path string, rec PlaybackRecord) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.records == nil {
+		me.records = make(map[string]map[string]PlaybackRecord)
+	}
+	if me.records[client] == nil {
+		me.records[client] = make(map[string]PlaybackRecord)
+	}
+	me.records[client][path] = rec
+}
+
+// Returns a copy of the records, by client, then by FS path.
+func (me *PlaybackHistory) all() map[string]map[string]PlaybackRecord {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	ret := make(map[string]map[string]PlaybackRecord, len(me.records))
+	for client, records := range me.records {
+		ret[client] = maps.Clone(records)
+	}
+	return ret
+}
+
+func (me *PlaybackHistory) get(client, path string) (rec PlaybackRecord, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	rec, ok = me.records[client][path]
+	return
+}
+
+// Returns the client's records, most recently played first.
+func (me *PlaybackHistory) recent(client string) (ret []playbackEntry) {
+	me.mu.Lock()
+	for path, rec := range me.records[client] {
+		ret = append(ret, playbackEntry{path, rec})
+	}
+	me.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].LastPlayed.After(ret[j].LastPlayed)
+	})
+	return
+}
+
+// Reads history saved by Save, replacing the current history.
+func (me *PlaybackHistory) Load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var records map[string]map[string]PlaybackRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	me.records = records
+	me.mu.Unlock()
+	return nil
+}
+
+// Writes the history to a file.
+func (me *PlaybackHistory) Save(path string) error {
+	me.mu.Lock()
+	b, err := json.Marshal(me.records)
+	me.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// Returns the key playback is recorded under for the client making the
+// request.
+func playbackClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Counts the bytes of a response body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (me *countingResponseWriter) Write(b []byte) (n int, err error) {
+	n, err = me.ResponseWriter.Write(b)
+	me.n += int64(n)
+	return
+}
+
+// Passes file bodies through to the ResponseWriter's ReadFrom, so they can
+// still be sent with sendfile.
+func (me *countingResponseWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if rf, ok := me.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(me.ResponseWriter, r)
+	}
+	me.n += n
+	return
+}
+
+func (me *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return me.ResponseWriter
+}
+
+// Returns the first byte requested by a Range header, or 0.
+func rangeStart(h string) int64 {
+	spec, ok := strings.CutPrefix(h, "bytes=")
+	if !ok {
+		return 0
+	}
+	first, _, _ := strings.Cut(spec, "-")
+	n, _ := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	return n
+}
+
+// Reports whether playback of files of the MIME-type is recorded.
+func (me *Server) tracksPlayback(mt mimeType) bool {
+	return me.Playback != nil && (mt.IsAudio() || mt.IsVideo())
+}
+
+// Returns the length of a file, or zero if unknown.
+func (me *Server) fileDuration(filePath string) time.Duration {
+	if me.NoProbe {
+		return 0
+	}
+	info, _ := me.ffmpegProbe(me.closedContext(), filePath)
+	if info == nil {
+		return 0
+	}
+	d, _ := info.Duration()
+	return d
+}
+
+// Records playback of a file by the client, and scrobbles audio that playback
+// has got far enough through.
+func (me *Server) recordPlayback(client, filePath string, rec PlaybackRecord) {
+	before, after := me.Playback.record(client, filePath, rec)
+	if me.Library != nil {
+		me.Library.setPlayback(client, filePath, after)
+	}
+	if len(me.Scrobblers) == 0 || scrobble.Due(before.Furthest, after.Duration) || !scrobble.Due(after.Furthest, after.Duration) {
+		return
+	}
+	if mt, err := me.mimeTypeByPath(filePath); err != nil || !mt.IsAudio() {
+		return
+	}
+	go me.scrobble(filePath, after)
+}
+
+// Submits a played track to the Scrobblers, with its details from its tags.
+func (me *Server) scrobble(filePath string, rec PlaybackRecord) {
+	if me.NoProbe {
+		return
+	}
+	info, _ := me.ffmpegProbe(me.closedContext(), filePath)
+	t := scrobble.Track{
+		Length:  rec.Duration,
+		Started: rec.LastPlayed.Add(-rec.Position),
+	}
+	t.Artist, _ = audioTag(info, "artist")
+	t.Album, _ = audioTag(info, "album")
+	t.Title, _ = audioTag(info, "title")
+	if t.Artist == "" || t.Title == "" {
+		me.Logger.Levelf(log.Debug, "not scrobbling %q: no artist or title", filePath)
+		return
+	}
+	for _, s := range me.Scrobblers {
+		if err := s.Scrobble(t); err != nil {
+			me.Logger.Levelf(log.Warning, "scrobbling %q: %v", filePath, err)
+		}
+	}
+}
+
+// Serves a file with serve, recording how far the client gets through it.
+// Clients read ahead, and stall the connection when paused, so the position is
+// the lesser of what the bytes sent and the time spent sending suggest.
+func (me *Server) serveFileRecordingPlayback(w http.ResponseWriter, r *http.Request, filePath string, size int64, serve http.HandlerFunc) {
+	started := time.Now()
+	cw := &countingResponseWriter{ResponseWriter: w}
+	serve(cw, r)
+	duration := me.fileDuration(filePath)
+	if cw.n < minPlaybackBytes || duration <= 0 || size <= 0 {
+		return
+	}
+	start := rangeStart(r.Header.Get("Range"))
+	startPos := time.Duration(float64(duration) * float64(start) / float64(size))
+	pos := min(
+		time.Duration(float64(duration)*float64(start+cw.n)/float64(size)),
+		startPos+time.Since(started),
+	)
+	me.recordPlayback(playbackClient(r), filePath, PlaybackRecord{
+		Position:   min(pos, duration),
+		Duration:   duration,
+		LastPlayed: time.Now(),
+	})
+}
+
+// Records a transcode of the file sent from the given position, once it ends.
+// Transcodes are produced about as fast as they're played, so the position
+// moves with the time spent sending.
+func (me *Server) recordTranscodePlayback(r *http.Request, filePath string, start time.Duration, started time.Time) {
+	if time.Since(started) < minPlaybackTime {
+		return
+	}
+	duration := me.fileDuration(filePath)
+	pos := start + time.Since(started)
+	if duration > 0 {
+		pos = min(pos, duration)
+	}
+	me.recordPlayback(playbackClient(r), filePath, PlaybackRecord{
+		Position:   pos,
+		Duration:   duration,
+		LastPlayed: time.Now(),
+	})
+}
+
+// Reports and sets the requesting client's resume points, for web players and
+// other tools. Times are in seconds.
+const playbackAPIPath = "/api/playback"
+
+type playbackAPIEntry struct {
+	Path       string    `json:"path"`
+	Position   float64   `json:"position"`
+	Furthest   float64   `json:"furthest"`
+	Duration   float64   `json:"duration,omitempty"`
+	LastPlayed time.Time `json:"lastPlayed"`
+}
+
+func newPlaybackAPIEntry(e playbackEntry) playbackAPIEntry {
+	return playbackAPIEntry{
+		Path:       e.Path,
+		Position:   e.Position.Seconds(),
+		Furthest:   e.Furthest.Seconds(),
+		Duration:   e.Duration.Seconds(),
+		LastPlayed: e.LastPlayed,
+	}
+}
+
+// GET returns the client's history, most recent first, or the entry for the
+// path query parameter. POST records the position in a JSON entry.
+func (me *Server) servePlaybackAPI(w http.ResponseWriter, r *http.Request) {
+	if me.Playback == nil {
+		http.Error(w, "playback history disabled", http.StatusNotFound)
+		return
+	}
+	client := playbackClient(r)
+	var ret interface{}
+	switch r.Method {
+	case "GET":
+		if p := r.URL.Query().Get("path"); p != "" {
+			filePath := me.filePath(p)
+			if me.hiddenFrom(client, filePath) {
+				http.Error(w, "no playback recorded", http.StatusNotFound)
+				return
+			}
+			rec, ok := me.Playback.get(client, filePath)
+			if !ok {
+				http.Error(w, "no playback recorded", http.StatusNotFound)
+				return
+			}
+			ret = newPlaybackAPIEntry(playbackEntry{p, rec})
+			break
+		}
+		entries := []playbackAPIEntry{}
+		for _, e := range me.Playback.recent(client) {
+			if me.hiddenFrom(client, e.Path) {
+				continue
+			}
+			e.Path = "/" + e.Path
+			entries = append(entries, newPlaybackAPIEntry(e))
+		}
+		ret = entries
+	case "POST":
+		var e playbackAPIEntry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filePath := me.filePath(e.Path)
+		// Hidden files get the same answer as missing ones, so their
+		// existence isn't given away.
+		if _, err := fs.Stat(me.FS, filePath); err != nil || me.hiddenFrom(client, filePath) {
+			http.Error(w, "no such object", http.StatusNotFound)
+			return
+		}
+		duration := time.Duration(e.Duration * float64(time.Second))
+		if duration <= 0 {
+			duration = me.fileDuration(filePath)
+		}
+		me.recordPlayback(client, filePath, PlaybackRecord{
+			Position:   time.Duration(e.Position * float64(time.Second)),
+			Duration:   duration,
+			LastPlayed: time.Now(),
+		})
+		rec, _ := me.Playback.get(client, filePath)
+		ret = newPlaybackAPIEntry(playbackEntry{e.Path, rec})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
@@ -0,0 +1,235 @@
+package dms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/scrobble"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestPlaybackHistorySaveLoad(t *testing.T) {
+	var h PlaybackHistory
+	now := time.Now().Round(0)
+	h.record("10.0.0.2", "books/a.mp3", PlaybackRecord{Position: time.Minute, Duration: time.Hour, LastPlayed: now.Add(-time.Hour)})
+	h.record("10.0.0.2", "books/b.mp3", PlaybackRecord{Position: time.Hour, Duration: time.Hour, LastPlayed: now})
+	path := filepath.Join(t.TempDir(), "history")
+	if err := h.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	var loaded PlaybackHistory
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	recent := loaded.recent("10.0.0.2")
+	if len(recent) != 2 || recent[0].Path != "books/b.mp3" || !recent[0].Finished() || recent[1].Finished() {
+		t.Fatalf("unexpected history %+v", recent)
+	}
+	if len(loaded.recent("10.0.0.3")) != 0 {
+		t.Fatal("history leaked between clients")
+	}
+}
+
+func TestContinueListening(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Books/Dune/.audiobook":   {},
+			"Books/Dune/01.mp3":       {},
+			"Books/Dune/02.mp3":       {},
+			"Books/Emma/01.mp3":       {},
+			"Music/Album/01.mp3":      {},
+			"Books/Emma/Part 2/a.mp3": {},
+		},
+		AudiobookPaths: []string{"/Books/Emma"},
+		Playback:       &PlaybackHistory{},
+		NoProbe:        true,
+	}
+	now := time.Now()
+	s.Playback.record("c", "Books/Dune/01.mp3", PlaybackRecord{Position: time.Hour, Duration: time.Hour, LastPlayed: now})
+	s.Playback.record("c", "Books/Emma/Part 2/a.mp3", PlaybackRecord{Position: time.Minute, Duration: time.Hour, LastPlayed: now.Add(-time.Minute)})
+	s.Playback.record("c", "Music/Album/01.mp3", PlaybackRecord{Position: time.Minute, Duration: time.Hour, LastPlayed: now})
+	e := []string{"Books/Dune/02.mp3", "Books/Emma/Part 2/a.mp3"}
+	if a := s.continueListening("c"); !slices.Equal(a, e) {
+		t.Fatalf("expected %q but got %q", e, a)
+	}
+	cds := &contentDirectoryService{Server: s}
+	objs := []interface{}{upnpav.Item{Object: upnpav.Object{ID: "Books%2FEmma%2FPart+2%2Fa.mp3"}}}
+	cds.annotatePlayback(objs, "c")
+	if item := objs[0].(upnpav.Item); item.LastPlaybackPosition != "0:01:00" || item.DcmInfo != "BM=60" {
+		t.Fatalf("unexpected annotation %+v", item.Object)
+	}
+}
+
+func TestPlaybackFurthest(t *testing.T) {
+	var h PlaybackHistory
+	h.record("c", "a.mkv", PlaybackRecord{Position: 40 * time.Minute, Duration: time.Hour})
+	// Seeking back to check something doesn't lose the resume point.
+	h.record("c", "a.mkv", PlaybackRecord{Position: 5 * time.Minute, Duration: time.Hour})
+	if rec, _ := h.get("c", "a.mkv"); rec.Furthest != 40*time.Minute || rec.Position != 5*time.Minute {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	// Watching again after finishing starts over.
+	h.record("c", "a.mkv", PlaybackRecord{Position: time.Hour, Duration: time.Hour})
+	h.record("c", "a.mkv", PlaybackRecord{Position: 2 * time.Minute, Duration: time.Hour})
+	if rec, _ := h.get("c", "a.mkv"); rec.Furthest != 2*time.Minute {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+}
+
+func TestContinueWatchingMostPlayed(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/a.mkv":       {},
+			"Films/b.mkv":       {},
+			"Films/c.mkv":       {},
+			"Music/a.mp3":       {},
+			"Music/b.mp3":       {},
+			"Books/.audiobook":  {},
+			"Books/Dune/01.mp3": {},
+		},
+		Playback: &PlaybackHistory{},
+		NoProbe:  true,
+	}
+	now := time.Now()
+	play := func(p string, pos time.Duration, ago time.Duration) {
+		s.Playback.record("c", p, PlaybackRecord{Position: pos, Duration: 10 * time.Minute, LastPlayed: now.Add(-ago)})
+	}
+	play("Films/a.mkv", time.Minute, 2*time.Hour)
+	play("Films/b.mkv", time.Minute, time.Hour)
+	play("Films/c.mkv", 10*time.Minute, 0)
+	for range 3 {
+		play("Music/b.mp3", 10*time.Minute, time.Hour)
+	}
+	play("Music/a.mp3", 10*time.Minute, 0)
+	for range 5 {
+		play("Books/Dune/01.mp3", 10*time.Minute, 0)
+	}
+	if rec, _ := s.Playback.get("c", "Music/b.mp3"); rec.PlayCount != 3 {
+		t.Fatalf("expected 3 plays but got %d", rec.PlayCount)
+	}
+	e := []string{"Films/b.mkv", "Films/a.mkv"}
+	if a := s.continueWatching("c"); !slices.Equal(a, e) {
+		t.Fatalf("expected %q but got %q", e, a)
+	}
+	e = []string{"Music/b.mp3", "Music/a.mp3"}
+	if a := s.mostPlayed("c"); !slices.Equal(a, e) {
+		t.Fatalf("expected %q but got %q", e, a)
+	}
+}
+
+type mapCache map[interface{}]interface{}
+
+func (me mapCache) Get(key interface{}) (interface{}, bool) {
+	v, ok := me[key]
+	return v, ok
+}
+
+func (me mapCache) Set(key, value interface{}) {
+	me[key] = value
+}
+
+type testScrobbler chan scrobble.Track
+
+func (me testScrobbler) Scrobble(t scrobble.Track) error {
+	me <- t
+	return nil
+}
+
+func TestScrobbleOnce(t *testing.T) {
+	scrobbled := make(testScrobbler, 2)
+	s := &Server{
+		FS:           fstest.MapFS{"Music/a.flac": {}},
+		FFProbeCache: mapCache{},
+		Playback:     &PlaybackHistory{},
+		Scrobblers:   []scrobble.Scrobbler{scrobbled},
+	}
+	info := &ffprobe.Info{Format: map[string]interface{}{
+		"tags": map[string]interface{}{"ARTIST": "Nina Simone", "TITLE": "Sinnerman"},
+	}}
+	s.FFProbeCache.Set(ffmpegInfoCacheKey{"Music/a.flac", time.Time{}.UnixNano()}, info)
+	now := time.Now()
+	for _, pos := range []time.Duration{time.Minute, 5 * time.Minute, 6 * time.Minute} {
+		s.recordPlayback("c", "Music/a.flac", PlaybackRecord{Position: pos, Duration: 10 * time.Minute, LastPlayed: now})
+	}
+	select {
+	case tr := <-scrobbled:
+		if tr.Artist != "Nina Simone" || tr.Title != "Sinnerman" || !tr.Started.Equal(now.Add(-5*time.Minute)) {
+			t.Fatalf("unexpected track %+v", tr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not scrobbled")
+	}
+	select {
+	case tr := <-scrobbled:
+		t.Fatalf("scrobbled twice: %+v", tr)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPlaybackAPI(t *testing.T) {
+	s := &Server{
+		FS:             fstest.MapFS{"Films/a.mkv": {}, "Private/c.mkv": {}},
+		RootObjectPath: "./",
+		Playback:       &PlaybackHistory{},
+		NoProbe:        true,
+		ProtectedPaths: []string{"Private"},
+		PIN:            "1234",
+	}
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.servePlaybackAPI(w, r)
+		return w
+	}
+	if w := do("GET", "/api/playback?path=/Films/a.mkv", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected not found but got %d", w.Code)
+	}
+	if w := do("POST", "/api/playback", `{"path": "/Films/b.mkv", "position": 60}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected not found but got %d", w.Code)
+	}
+	// A locked file answers like a missing one.
+	missing := do("POST", "/api/playback", `{"path": "/Films/b.mkv", "position": 60}`)
+	if w := do("POST", "/api/playback", `{"path": "/Private/c.mkv", "position": 60}`); w.Code != missing.Code || w.Body.String() != missing.Body.String() {
+		t.Fatalf("locked file got %d %q", w.Code, w.Body)
+	}
+	s.recordPlayback("192.0.2.1", "Private/c.mkv", PlaybackRecord{Position: time.Minute, LastPlayed: time.Now()})
+	if w := do("GET", "/api/playback?path=/Private/c.mkv", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("locked file's playback got %d", w.Code)
+	}
+	if w := do("POST", "/api/playback", `{"path": "/Films/a.mkv", "position": 90.5, "duration": 3600}`); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body)
+	}
+	var e playbackAPIEntry
+	w := do("GET", "/api/playback?path=/Films/a.mkv", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Position != 90.5 || e.Furthest != 90.5 || e.Duration != 3600 {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	var entries []playbackAPIEntry
+	w = do("GET", "/api/playback", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "/Films/a.mkv" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+}
+
+func TestRangeStart(t *testing.T) {
+	for h, e := range map[string]int64{"": 0, "bytes=100-": 100, "bytes=5-10": 5, "bytes=-500": 0} {
+		if a := rangeStart(h); a != e {
+			t.Errorf("expected %d for %q but got %d", e, h, a)
+		}
+	}
+}
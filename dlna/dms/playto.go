@@ -0,0 +1,271 @@
+package dms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dms/avtransport"
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const (
+	renderersAPIPath = "/api/renderers"
+	playToAPIPath    = "/api/playto"
+)
+
+const (
+	// How long renderers are given to answer a search.
+	rendererSearchTimeout = 2 * time.Second
+	// How long the renderers found are listed before searching again.
+	rendererCacheDuration = time.Minute
+	// How long renderers are given to act on a request.
+	rendererActionTimeout = 10 * time.Second
+)
+
+// The renderers found by the last search.
+type rendererCache struct {
+	mu        sync.Mutex
+	renderers []*avtransport.Renderer
+	searched  time.Time
+	searching bool
+}
+
+// Searches for renderers, replacing those found before.
+func (me *Server) searchRenderers(ctx context.Context) ([]*avtransport.Renderer, error) {
+	ctx, cancel := context.WithTimeout(ctx, rendererSearchTimeout+rendererActionTimeout)
+	defer cancel()
+	found, err := avtransport.Discover(ctx, me.Interfaces, rendererSearchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	me.renderers.mu.Lock()
+	defer me.renderers.mu.Unlock()
+	me.renderers.renderers = found
+	me.renderers.searched = time.Now()
+	return found, nil
+}
+
+// Returns the renderers found before, starting a search in the background if
+// they're stale, for the web UI.
+func (me *Server) cachedRenderers() []*avtransport.Renderer {
+	me.renderers.mu.Lock()
+	defer me.renderers.mu.Unlock()
+	if time.Since(me.renderers.searched) > rendererCacheDuration && !me.renderers.searching {
+		me.renderers.searching = true
+		go func() {
+			if _, err := me.searchRenderers(me.closedContext()); err != nil {
+				me.Logger.Printf("searching for renderers: %v", err)
+			}
+			me.renderers.mu.Lock()
+			me.renderers.searching = false
+			me.renderers.mu.Unlock()
+		}()
+	}
+	return me.renderers.renderers
+}
+
+// Returns the renderer with the UDN, searching again if it's not among those
+// found before.
+func (me *Server) renderer(ctx context.Context, udn string) (*avtransport.Renderer, error) {
+	find := func(rs []*avtransport.Renderer) *avtransport.Renderer {
+		for _, r := range rs {
+			if r.UDN == udn {
+				return r
+			}
+		}
+		return nil
+	}
+	me.renderers.mu.Lock()
+	r := find(me.renderers.renderers)
+	me.renderers.mu.Unlock()
+	if r != nil {
+		return r, nil
+	}
+	rs, err := me.searchRenderers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r := find(rs); r != nil {
+		return r, nil
+	}
+	return nil, fmt.Errorf("no renderer %q", udn)
+}
+
+// Returns the address the renderer would reach the server at, and the
+// renderer's own IP.
+func (me *Server) hostForRenderer(r *avtransport.Renderer) (host string, rendererIP string, err error) {
+	u, err := url.Parse(r.ControlURL)
+	if err != nil {
+		return "", "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	// Nothing is sent; it's only routed.
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	return net.JoinHostPort(local.String(), strconv.Itoa(me.httpPort())), u.Hostname(), nil
+}
+
+var errNotPlayable = errors.New("not a playable item")
+
+// A renderer, as the API lists them.
+type rendererInfo struct {
+	UDN          string `json:"udn"`
+	Name         string `json:"name"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Model        string `json:"model,omitempty"`
+}
+
+// What a renderer's playing, as the API tells it, with times in seconds.
+type playToStatus struct {
+	State    string  `json:"state"`
+	URI      string  `json:"uri,omitempty"`
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// Has the renderer play the file, as it would be listed to it.
+func (me *Server) playTo(ctx context.Context, r *avtransport.Renderer, client, filePath string) error {
+	host, rendererIP, err := me.hostForRenderer(r)
+	if err != nil {
+		return err
+	}
+	if me.hiddenFrom(client, filePath) || me.hiddenFrom(rendererIP, filePath) {
+		return fs.ErrNotExist
+	}
+	fi, err := fs.Stat(me.FS, filePath)
+	if err != nil {
+		return err
+	}
+	cdService := &contentDirectoryService{Server: me}
+	obj, err := cdService.cdsObjectToUpnpavObject(ctx, object{filePath, me.RootObjectPath}, fi, host, "")
+	if err != nil {
+		return err
+	}
+	item, ok := obj.(upnpav.Item)
+	if !ok || len(item.Res) == 0 {
+		return errNotPlayable
+	}
+	metadata, err := cds.MarshalDIDL([]interface{}{item})
+	if err != nil {
+		return err
+	}
+	if err := r.SetAVTransportURI(ctx, item.Res[0].URL, metadata); err != nil {
+		return err
+	}
+	return r.Play(ctx)
+}
+
+// GET lists the renderers on the network, searching again if the refresh
+// form value is set.
+func (me *Server) serveRenderersAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	me.renderers.mu.Lock()
+	rs, stale := me.renderers.renderers, time.Since(me.renderers.searched) > rendererCacheDuration
+	me.renderers.mu.Unlock()
+	if stale || r.FormValue("refresh") != "" {
+		var err error
+		if rs, err = me.searchRenderers(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	ret := []rendererInfo{}
+	for _, r := range rs {
+		ret = append(ret, rendererInfo{r.UDN, r.FriendlyName, r.Manufacturer, r.ModelName})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
+
+// Controls the renderer with the UDN in the renderer form value. GET returns
+// what it's playing. POST acts on the action form value: play has it play the
+// file in the path form value, or carry on if there's none, and pause, stop
+// and seek, to the position form value in seconds, do as they say. If the
+// redirect form value is set, a successful POST redirects to the web UI.
+func (me *Server) servePlayToAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), rendererSearchTimeout+rendererActionTimeout)
+	defer cancel()
+	renderer, err := me.renderer(ctx, r.FormValue("renderer"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if r.Method == "POST" {
+		switch action := r.FormValue("action"); action {
+		case "play":
+			if p := r.FormValue("path"); p != "" {
+				err = me.playTo(ctx, renderer, playbackClient(r), me.filePath(p))
+			} else {
+				err = renderer.Play(ctx)
+			}
+		case "pause":
+			err = renderer.Pause(ctx)
+		case "stop":
+			err = renderer.Stop(ctx)
+		case "seek":
+			var secs float64
+			secs, err = strconv.ParseFloat(r.FormValue("position"), 64)
+			if err != nil || secs < 0 {
+				http.Error(w, "position isn't a number of seconds", http.StatusBadRequest)
+				return
+			}
+			err = renderer.Seek(ctx, time.Duration(secs*float64(time.Second)))
+		default:
+			http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			http.Error(w, "no such object", http.StatusNotFound)
+			return
+		case errors.Is(err, errNotPlayable):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if r.FormValue("redirect") != "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+	status, err := renderer.Status(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(playToStatus{
+		State:    status.State,
+		URI:      status.URI,
+		Position: status.Position.Seconds(),
+		Duration: status.Duration.Seconds(),
+	})
+}
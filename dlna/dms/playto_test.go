@@ -0,0 +1,96 @@
+package dms
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/avtransport"
+)
+
+func TestPlayTo(t *testing.T) {
+	var actions []string
+	renderer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, action, _ := strings.Cut(strings.Trim(r.Header.Get("SOAPAction"), `"`), "#")
+		actions = append(actions, action+" "+string(b))
+		resp := ""
+		switch action {
+		case "GetTransportInfo":
+			resp = `<CurrentTransportState>PLAYING</CurrentTransportState>`
+		case "GetPositionInfo":
+			resp = `<TrackDuration>0:01:40</TrackDuration><TrackURI>http://dms/res</TrackURI><RelTime>0:00:10</RelTime>`
+		}
+		io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:XResponse>`+resp+`</u:XResponse></s:Body></s:Envelope>`)
+	}))
+	defer renderer.Close()
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := &Server{
+		FS:             fstest.MapFS{"video.mp4": {Data: []byte("video")}},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		HTTPConn:       conn,
+	}
+	s.renderers.renderers = []*avtransport.Renderer{{
+		UDN:          "uuid:tv",
+		FriendlyName: "TV",
+		ServiceType:  "urn:schemas-upnp-org:service:AVTransport:1",
+		ControlURL:   renderer.URL + "/control",
+	}}
+	s.renderers.searched = time.Now()
+	do := func(method string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, playToAPIPath, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.servePlayToAPI(w, r)
+		return w
+	}
+
+	w := do("POST", url.Values{"renderer": {"uuid:tv"}, "action": {"play"}, "path": {"/video.mp4"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("play got %d: %s", w.Code, w.Body)
+	}
+	if len(actions) < 2 {
+		t.Fatalf("got actions %q", actions)
+	}
+	if set := actions[0]; !strings.HasPrefix(set, "SetAVTransportURI ") || !strings.Contains(set, "/res?path=video.mp4") || !strings.Contains(set, "DIDL-Lite") {
+		t.Fatalf("got %q", set)
+	}
+	if !strings.HasPrefix(actions[1], "Play ") {
+		t.Fatalf("got %q", actions[1])
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"state":"PLAYING"`) || !strings.Contains(body, `"position":10`) || !strings.Contains(body, `"duration":100`) {
+		t.Fatalf("got status %s", body)
+	}
+
+	actions = nil
+	if w := do("POST", url.Values{"renderer": {"uuid:tv"}, "action": {"seek"}, "position": {"90"}, "redirect": {"1"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("seek got %d: %s", w.Code, w.Body)
+	}
+	if len(actions) != 1 || !strings.Contains(actions[0], "<Target>0:01:30</Target>") {
+		t.Fatalf("got actions %q", actions)
+	}
+	if w := do("POST", url.Values{"renderer": {"uuid:tv"}, "action": {"seek"}, "position": {"soon"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad position got %d", w.Code)
+	}
+	if w := do("POST", url.Values{"renderer": {"uuid:tv"}, "action": {"play"}, "path": {"/missing.mp4"}}); w.Code != http.StatusNotFound {
+		t.Fatalf("missing file got %d", w.Code)
+	}
+	if w := do("POST", url.Values{"renderer": {"uuid:tv"}, "action": {"rewind"}}); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown action got %d", w.Code)
+	}
+}
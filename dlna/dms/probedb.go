@@ -0,0 +1,259 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// Probe results as JSON, after the sequence number of their entry in
+	// probeOrderBucket, by path and modification time.
+	probeBucket = []byte("probes")
+	// Keys of probeBucket, by the sequence number of when they were last
+	// used, for eviction.
+	probeOrderBucket = []byte("order")
+)
+
+// A persistent FFProbeCache, kept in a bbolt database file. Results are
+// written as they're made, so they survive restarts, and crashes, and a large
+// library needn't be probed again after each. When the results add up to more
+// than the size limit, the least recently used are evicted. Results for files
+// that have changed since are dropped as the new ones are added.
+type ProbeDB struct {
+	db       *bolt.DB
+	maxBytes int64
+
+	mu sync.Mutex
+	// The bytes of keys and values in probeBucket.
+	size int64
+	// Keys got since the last eviction, which are moved to the end of the
+	// order then, so that they're kept.
+	used map[string]struct{}
+}
+
+// Opens the probe database at the path, creating it if it doesn't exist.
+// Results are evicted beyond maxBytes, unless it's zero.
+func OpenProbeDB(path string, maxBytes int64) (*ProbeDB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	me := &ProbeDB{db: db, maxBytes: maxBytes, used: make(map[string]struct{})}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(probeBucket)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(probeOrderBucket); err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			me.size += int64(len(k) + len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return me, nil
+}
+
+// Flushes and closes the database.
+func (me *ProbeDB) Close() error {
+	return me.db.Close()
+}
+
+func encodeProbeKey(key ffmpegInfoCacheKey) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(key.Path), 0), uint64(key.ModTime))
+}
+
+func decodeProbeKey(b []byte) (key ffmpegInfoCacheKey, ok bool) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 || len(b) != i+9 {
+		return
+	}
+	return ffmpegInfoCacheKey{string(b[:i]), int64(binary.BigEndian.Uint64(b[i+1:]))}, true
+}
+
+// Returns the *ffprobe.Info for an ffmpegInfoCacheKey. It's nil for files
+// that couldn't be probed.
+func (me *ProbeDB) Get(key interface{}) (value interface{}, ok bool) {
+	k, isKey := key.(ffmpegInfoCacheKey)
+	if !isKey {
+		return
+	}
+	var info *ffprobe.Info
+	err := me.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(probeBucket).Get(encodeProbeKey(k))
+		if len(v) < 8 {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v[8:], &info)
+	})
+	if err != nil {
+		log.Printf("reading probe of %q: %v", k.Path, err)
+		return nil, false
+	}
+	if ok {
+		me.mu.Lock()
+		me.used[string(encodeProbeKey(k))] = struct{}{}
+		me.mu.Unlock()
+	}
+	return info, ok
+}
+
+// Stores the *ffprobe.Info, which may be nil, for an ffmpegInfoCacheKey.
+func (me *ProbeDB) Set(key interface{}, value interface{}) {
+	k, ok := key.(ffmpegInfoCacheKey)
+	if !ok {
+		return
+	}
+	info, _ := value.(*ffprobe.Info)
+	j, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("encoding probe of %q: %v", k.Path, err)
+		return
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	err = me.db.Update(func(tx *bolt.Tx) error {
+		b, order := tx.Bucket(probeBucket), tx.Bucket(probeOrderBucket)
+		// Any earlier results for the path are of an older version of the
+		// file, or of this one.
+		prefix := append([]byte(k.Path), 0)
+		var stale [][]byte
+		c := b.Cursor()
+		for sk, _ := c.Seek(prefix); sk != nil && bytes.HasPrefix(sk, prefix); sk, _ = c.Next() {
+			stale = append(stale, bytes.Clone(sk))
+		}
+		for _, sk := range stale {
+			if err := me.delete(b, order, sk); err != nil {
+				return err
+			}
+		}
+		if err := me.put(b, order, encodeProbeKey(k), j); err != nil {
+			return err
+		}
+		if me.maxBytes != 0 && me.size > me.maxBytes {
+			return me.evict(b, order)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("storing probe of %q: %v", k.Path, err)
+	}
+}
+
+// Drops the result for an ffmpegInfoCacheKey.
+func (me *ProbeDB) Delete(key interface{}) {
+	k, ok := key.(ffmpegInfoCacheKey)
+	if !ok {
+		return
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	err := me.db.Update(func(tx *bolt.Tx) error {
+		return me.delete(tx.Bucket(probeBucket), tx.Bucket(probeOrderBucket), encodeProbeKey(k))
+	})
+	if err != nil {
+		log.Printf("deleting probe of %q: %v", k.Path, err)
+	}
+}
+
+// Adds the entry at the end of the order.
+func (me *ProbeDB) put(b, order *bolt.Bucket, k, j []byte) error {
+	seq, err := order.NextSequence()
+	if err != nil {
+		return err
+	}
+	s := binary.BigEndian.AppendUint64(nil, seq)
+	if err := order.Put(s, k); err != nil {
+		return err
+	}
+	v := append(s, j...)
+	if err := b.Put(k, v); err != nil {
+		return err
+	}
+	me.size += int64(len(k) + len(v))
+	return nil
+}
+
+func (me *ProbeDB) delete(b, order *bolt.Bucket, k []byte) error {
+	v := b.Get(k)
+	if v == nil {
+		return nil
+	}
+	me.size -= int64(len(k) + len(v))
+	if len(v) >= 8 {
+		if err := order.Delete(v[:8]); err != nil {
+			return err
+		}
+	}
+	return b.Delete(k)
+}
+
+// Evicts the least recently used entries, to a little under the size limit so
+// that it's not done again for each result added.
+func (me *ProbeDB) evict(b, order *bolt.Bucket) error {
+	for k := range me.used {
+		v := b.Get([]byte(k))
+		if v == nil {
+			continue
+		}
+		j := bytes.Clone(v[8:])
+		if err := me.delete(b, order, []byte(k)); err != nil {
+			return err
+		}
+		if err := me.put(b, order, []byte(k), j); err != nil {
+			return err
+		}
+	}
+	clear(me.used)
+	c := order.Cursor()
+	for s, k := c.First(); s != nil && me.size > me.maxBytes*9/10; s, k = c.First() {
+		s, k = bytes.Clone(s), bytes.Clone(k)
+		if v := b.Get(k); v != nil {
+			me.size -= int64(len(k) + len(v))
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		if err := order.Delete(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the number of results stored.
+func (me *ProbeDB) Len() (n int) {
+	me.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(probeBucket).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+// Returns the keys of the results, the most recently added or used first, so
+// that the database can be exported with Server.SnapshotCaches.
+func (me *ProbeDB) Keys() (ret []interface{}) {
+	me.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(probeOrderBucket).Cursor()
+		for s, k := c.Last(); s != nil; s, k = c.Prev() {
+			if key, ok := decodeProbeKey(k); ok {
+				ret = append(ret, key)
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,75 @@
+package dms
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func TestProbeDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "probes")
+	db, err := OpenProbeDB(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &ffprobe.Info{Format: map[string]interface{}{"duration": "60"}}
+	db.Set(ffmpegInfoCacheKey{"a.mkv", 1}, info)
+	db.Set(ffmpegInfoCacheKey{"b.mkv", 1}, nil)
+	// The file changed, so the old result goes.
+	db.Set(ffmpegInfoCacheKey{"a.mkv", 2}, info)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenProbeDB(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, ok := db.Get(ffmpegInfoCacheKey{"a.mkv", 2}); !ok || v.(*ffprobe.Info).Format["duration"] != "60" {
+		t.Errorf("got %v, %v", v, ok)
+	}
+	// Failures are cached too.
+	if v, ok := db.Get(ffmpegInfoCacheKey{"b.mkv", 1}); !ok || v.(*ffprobe.Info) != nil {
+		t.Errorf("got %v, %v", v, ok)
+	}
+	if _, ok := db.Get(ffmpegInfoCacheKey{"a.mkv", 1}); ok || db.Len() != 2 {
+		t.Errorf("stale result kept, %d results", db.Len())
+	}
+	if keys := db.Keys(); len(keys) != 2 || keys[0] != (ffmpegInfoCacheKey{"a.mkv", 2}) {
+		t.Errorf("got keys %v", keys)
+	}
+	db.Delete(ffmpegInfoCacheKey{"b.mkv", 1})
+	if _, ok := db.Get(ffmpegInfoCacheKey{"b.mkv", 1}); ok || db.Len() != 1 || len(db.Keys()) != 1 {
+		t.Errorf("not deleted, %d results", db.Len())
+	}
+}
+
+func TestProbeDBEviction(t *testing.T) {
+	db, err := OpenProbeDB(filepath.Join(t.TempDir(), "probes"), 4000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	info := &ffprobe.Info{Format: map[string]interface{}{"comment": strings.Repeat("x", 300)}}
+	db.Set(ffmpegInfoCacheKey{"first.mkv", 1}, info)
+	for i := range 20 {
+		// It's used, so it outlasts the others.
+		db.Get(ffmpegInfoCacheKey{"first.mkv", 1})
+		db.Set(ffmpegInfoCacheKey{strings.Repeat("f", i+1), 1}, info)
+	}
+	if db.size > 4000 {
+		t.Errorf("%d bytes stored", db.size)
+	}
+	if _, ok := db.Get(ffmpegInfoCacheKey{"first.mkv", 1}); !ok {
+		t.Error("recently used result evicted")
+	}
+	if _, ok := db.Get(ffmpegInfoCacheKey{"f", 1}); ok {
+		t.Error("oldest result kept")
+	}
+	if n := db.Len(); n < 5 || n > 12 {
+		t.Errorf("%d results kept", n)
+	}
+}
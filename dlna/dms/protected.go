@@ -0,0 +1,184 @@
+package dms
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const unlockAPIPath = "/api/unlock"
+
+// How long a client stays unlocked if Server.UnlockDuration isn't set.
+const defaultUnlockDuration = time.Hour
+
+// Wrong PINs are answered after this, to slow down guessing.
+var wrongPINDelay = time.Second
+
+const (
+	// After this many wrong PINs in a row, a client can't try again for
+	// wrongPINLockout.
+	maxWrongPINs    = 5
+	wrongPINLockout = 15 * time.Minute
+)
+
+// The clients that have been unlocked, and when they lock again.
+type unlockTable struct {
+	mu sync.Mutex
+	m  map[string]time.Time
+}
+
+func (me *unlockTable) until(client string) (time.Time, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	until, ok := me.m[client]
+	if ok && !time.Now().Before(until) {
+		delete(me.m, client)
+		return time.Time{}, false
+	}
+	return until, ok
+}
+
+func (me *unlockTable) set(client string, until time.Time) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.m == nil {
+		me.m = make(map[string]time.Time)
+	}
+	me.m[client] = until
+}
+
+func (me *unlockTable) delete(client string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.m, client)
+}
+
+// Wrong PIN counts by client, and when locked out clients may try again.
+type wrongPINTable struct {
+	mu sync.Mutex
+	m  map[string]*wrongPINs
+}
+
+type wrongPINs struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// Counts an attempt as wrong until succeeded is called, so parallel
+// attempts can't get past maxWrongPINs. Returns false if the client is
+// locked out.
+func (me *wrongPINTable) attempt(client string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.m == nil {
+		me.m = make(map[string]*wrongPINs)
+	}
+	w := me.m[client]
+	if w == nil {
+		w = &wrongPINs{}
+		me.m[client] = w
+	}
+	if time.Now().Before(w.lockedUntil) {
+		return false
+	}
+	if w.count >= maxWrongPINs {
+		w.count = 0
+		w.lockedUntil = time.Now().Add(wrongPINLockout)
+		return false
+	}
+	w.count++
+	return true
+}
+
+func (me *wrongPINTable) succeeded(client string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.m, client)
+}
+
+// Reports whether the file or folder is in one of Server.ProtectedPaths.
+func (me *Server) isProtected(filePath string) bool {
+	for dir := path.Clean(filePath); ; dir = path.Dir(dir) {
+		for _, p := range me.ProtectedPaths {
+			if p != "" && path.Clean(strings.TrimPrefix(p, "/")) == dir {
+				return true
+			}
+		}
+		if dir == "." || dir == "/" {
+			return false
+		}
+	}
+}
+
+// Reports whether the file or folder is protected, and the client hasn't
+// been unlocked.
+func (me *Server) lockedFrom(client, filePath string) bool {
+	if len(me.ProtectedPaths) == 0 || !me.isProtected(filePath) {
+		return false
+	}
+	_, unlocked := me.unlocked.until(client)
+	return !unlocked
+}
+
+func (me *Server) unlockDuration() time.Duration {
+	if me.UnlockDuration > 0 {
+		return me.UnlockDuration
+	}
+	return defaultUnlockDuration
+}
+
+type unlockAPIStatus struct {
+	Client   string     `json:"client"`
+	Unlocked bool       `json:"unlocked"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// GET returns whether the client is unlocked. POST unlocks it given the pin
+// form value, and DELETE locks it again, always for the client making the
+// request. If the redirect form value is set, a successful POST redirects to
+// the web UI.
+func (me *Server) serveUnlockAPI(w http.ResponseWriter, r *http.Request) {
+	if len(me.ProtectedPaths) == 0 || me.PIN == "" {
+		http.Error(w, "no protected folders", http.StatusNotFound)
+		return
+	}
+	client := playbackClient(r)
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if !me.wrongPINs.attempt(client) {
+			http.Error(w, "too many wrong PINs, try again later", http.StatusTooManyRequests)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.FormValue("pin")), []byte(me.PIN)) != 1 {
+			me.Logger.Printf("wrong PIN from %s", client)
+			me.offence(r, "wrong PIN")
+			time.Sleep(wrongPINDelay)
+			http.Error(w, "wrong PIN", http.StatusForbidden)
+			return
+		}
+		me.wrongPINs.succeeded(client)
+		me.unlocked.set(client, time.Now().Add(me.unlockDuration()))
+		if r.FormValue("redirect") != "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	case "DELETE":
+		me.unlocked.delete(client)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := unlockAPIStatus{Client: client}
+	if until, ok := me.unlocked.until(client); ok {
+		status.Unlocked = true
+		status.Until = &until
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,137 @@
+package dms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestProtectedPaths(t *testing.T) {
+	wrongPINDelay = 0
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv":           {},
+			"Private/Holiday/day1.mp4": {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		ProtectedPaths: []string{"Private"},
+		PIN:            "1234",
+	}
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	cdService := &contentDirectoryService{Server: s}
+	const tv, phone = "192.0.2.1", "192.0.2.2"
+	rootTitles := func(client string) (ret []string) {
+		obj, _ := cdService.objectFromID("0")
+		objs, err := cdService.browseChildren(context.Background(), "0", obj, "localhost", "", client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range objs {
+			ret = append(ret, o.(upnpav.Container).Title)
+		}
+		return
+	}
+	do := func(method, target, client string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = client + ":1234"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	resURL := "/res?path=" + url.QueryEscape("Private/Holiday/day1.mp4")
+	if a := rootTitles(tv); len(a) != 1 || a[0] != "Films" {
+		t.Fatalf("locked client sees %q", a)
+	}
+	if w := do("GET", resURL, tv, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("locked client got res: %d", w.Code)
+	}
+	obj, _ := cdService.objectFromID("Private%2FHoliday")
+	if _, err := cdService.browseChildren(context.Background(), "Private%2FHoliday", obj, "localhost", "", tv); err == nil {
+		t.Fatal("locked client browsed a protected folder")
+	}
+	// Our own ffmpeg and ffprobe get protected files.
+	if w := do("GET", resURL+"&"+loopbackQueryKey+"="+s.loopbackToken("Private/Holiday/day1.mp4"), "127.0.0.1", nil); w.Code != http.StatusOK {
+		t.Fatalf("loopback request got res: %d", w.Code)
+	}
+	if w := do("POST", unlockAPIPath, tv, url.Values{"pin": {"0000"}}); w.Code != http.StatusForbidden {
+		t.Fatalf("wrong PIN: %d", w.Code)
+	}
+	// Other clients can't be named.
+	if w := do("POST", unlockAPIPath, phone, url.Values{"pin": {"1234"}, "client": {tv}}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"client":"`+phone+`"`) {
+		t.Fatalf("unlocking phone: %d %s", w.Code, w.Body)
+	}
+	if a := rootTitles(tv); len(a) != 1 {
+		t.Fatalf("phone unlocked the tv: %q", a)
+	}
+	if w := do("DELETE", unlockAPIPath, phone, nil); w.Code != http.StatusOK {
+		t.Fatalf("locking phone: %d", w.Code)
+	}
+	if w := do("POST", unlockAPIPath, tv, url.Values{"pin": {"1234"}}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"unlocked":true`) {
+		t.Fatalf("unlocking: %d %s", w.Code, w.Body)
+	}
+	if a := rootTitles(tv); len(a) != 2 {
+		t.Fatalf("unlocked client sees %q", a)
+	}
+	if w := do("GET", resURL, tv, nil); w.Code != http.StatusOK {
+		t.Fatalf("unlocked client got res: %d", w.Code)
+	}
+	if a := rootTitles(phone); len(a) != 1 {
+		t.Fatalf("phone was unlocked too: %q", a)
+	}
+	if w := do("DELETE", unlockAPIPath+"?client="+tv, phone, nil); w.Code != http.StatusOK {
+		t.Fatalf("locking: %d", w.Code)
+	}
+	if a := rootTitles(tv); len(a) != 2 {
+		t.Fatalf("phone locked the tv: %q", a)
+	}
+	if w := do("DELETE", unlockAPIPath, tv, nil); w.Code != http.StatusOK {
+		t.Fatalf("locking: %d", w.Code)
+	}
+	if a := rootTitles(tv); len(a) != 1 {
+		t.Fatalf("locked client sees %q", a)
+	}
+}
+
+func TestWrongPINLockout(t *testing.T) {
+	wrongPINDelay = 0
+	s := &Server{
+		FS:             fstest.MapFS{"Private/a.mp4": {}},
+		RootObjectPath: ".",
+		Logger:         log.Default,
+		ProtectedPaths: []string{"Private"},
+		PIN:            "1234",
+	}
+	post := func(pin string) int {
+		req := httptest.NewRequest("POST", unlockAPIPath, strings.NewReader(url.Values{"pin": {pin}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		s.serveUnlockAPI(w, req)
+		return w.Code
+	}
+	for range maxWrongPINs {
+		if code := post("0000"); code != http.StatusForbidden {
+			t.Fatalf("wrong PIN: %d", code)
+		}
+	}
+	// Locked out, even with the right PIN.
+	if code := post("1234"); code != http.StatusTooManyRequests {
+		t.Fatalf("after %d wrong PINs: %d", maxWrongPINs, code)
+	}
+	if _, ok := s.unlocked.until("192.0.2.1"); ok {
+		t.Fatal("unlocked while locked out")
+	}
+}
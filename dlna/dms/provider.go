@@ -0,0 +1,178 @@
+package dms
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/misc"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Where the resources of MediaProvider items are served.
+const providerResPath = "/providerRes"
+
+// A source of ContentDirectory objects other than the FS, for programs
+// embedding dms that serve media from elsewhere, such as a database or
+// another server. Set as Server.Provider, it answers every Browse, and dms
+// serves its items' resources from it, with range requests.
+type MediaProvider interface {
+	// Returns the object with the ID, "0" for the root container. Errors
+	// wrapping fs.ErrNotExist are no such object.
+	Object(ctx context.Context, id string) (ProviderObject, error)
+	// Returns the children of the container with the ID, in order.
+	Children(ctx context.Context, id string) ([]ProviderObject, error)
+	// Opens a resource of an item, by its index in the item's Resources.
+	OpenResource(ctx context.Context, id string, index int) (io.ReadSeekCloser, error)
+}
+
+// A ContentDirectory object of a MediaProvider.
+type ProviderObject struct {
+	// Its ID, ParentID, title, class and other metadata.
+	upnpav.Object
+	// Containers have children rather than resources.
+	Container  bool
+	ChildCount int
+	Resources  []ProviderResource
+}
+
+// A resource of a ProviderObject item. Only the MIME-type is required.
+type ProviderResource struct {
+	MimeType        string
+	DLNAProfileName string
+	Size            uint64
+	Duration        time.Duration
+	Resolution      string
+	// For conditional requests.
+	ModTime time.Time
+}
+
+// Returns the DIDL-Lite object for the provider's object, with resource URLs
+// at the host.
+func (me *Server) providerDIDLObject(o ProviderObject, host, userAgent string) interface{} {
+	obj := o.Object
+	obj.Restricted = 1
+	if o.Container {
+		return upnpav.Container{Object: obj, ChildCount: o.ChildCount}
+	}
+	item := upnpav.Item{Object: obj}
+	for i, r := range o.Resources {
+		res := upnpav.Resource{
+			URL: (&url.URL{
+				Scheme: me.urlScheme(host),
+				Host:   host,
+				Path:   providerResPath,
+				RawQuery: url.Values{
+					"id":    {obj.ID},
+					"index": {strconv.Itoa(i)},
+				}.Encode(),
+			}).String(),
+			ProtocolInfo: dlna.HTTPProtocolInfo(r.MimeType, dlna.ContentFeatures{
+				ProfileName:  r.DLNAProfileName,
+				SupportRange: true,
+				Flags:        me.dlnaFlags(userAgent, RawResource),
+			}),
+			Size:       r.Size,
+			Resolution: r.Resolution,
+		}
+		if r.Duration != 0 {
+			res.Duration = misc.FormatDurationSexagesimal(r.Duration)
+		}
+		item.Res = append(item.Res, res)
+	}
+	return item
+}
+
+// Answers a Browse from the Provider.
+func (me *contentDirectoryService) browseProvider(ctx context.Context, browse cds.BrowseArgs, host, userAgent string) ([][2]string, error) {
+	var objs []interface{}
+	switch browse.BrowseFlag {
+	case "BrowseDirectChildren":
+		children, err := me.Provider.Children(ctx, browse.ObjectID)
+		if err != nil {
+			return nil, providerUPnPError(err)
+		}
+		for _, c := range children {
+			objs = append(objs, me.providerDIDLObject(c, host, userAgent))
+		}
+	case "BrowseMetadata":
+		o, err := me.Provider.Object(ctx, browse.ObjectID)
+		if err != nil {
+			return nil, providerUPnPError(err)
+		}
+		objs = []interface{}{me.providerDIDLObject(o, host, userAgent)}
+	default:
+		return nil, upnp.Errorf(upnp.ArgumentValueInvalidErrorCode, "unhandled browse flag: %v", browse.BrowseFlag)
+	}
+	totalMatches := len(objs)
+	objs = cds.Page(objs, browse.StartingIndex, browse.RequestedCount)
+	result, err := cds.MarshalDIDL(objs)
+	if err != nil {
+		return nil, err
+	}
+	return [][2]string{
+		{"Result", result},
+		{"NumberReturned", strconv.Itoa(len(objs))},
+		{"TotalMatches", strconv.Itoa(totalMatches)},
+		{"UpdateID", me.updateIDString()},
+	}, nil
+}
+
+func providerUPnPError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return upnp.Errorf(upnpav.NoSuchObjectErrorCode, "%s", err.Error())
+	}
+	return err
+}
+
+// Serves a resource of a Provider item.
+func (me *Server) serveProviderResource(w http.ResponseWriter, r *http.Request) {
+	if me.Provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil {
+		http.Error(w, "bad index", http.StatusBadRequest)
+		return
+	}
+	o, err := me.Provider.Object(r.Context(), id)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && (o.Container || index < 0 || index >= len(o.Resources)) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := o.Resources[index]
+	f, err := me.Provider.OpenResource(r.Context(), id, index)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", res.MimeType)
+	w.Header().Set(dlna.TransferModeDomain, "Streaming")
+	if r.Header.Get("getContentFeatures.dlna.org") != "" {
+		w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{
+			ProfileName:  res.DLNAProfileName,
+			SupportRange: true,
+			Flags:        me.dlnaFlags(r.UserAgent(), RawResource),
+		}.String())
+	}
+	http.ServeContent(w, r, "", res.ModTime, f)
+}
@@ -0,0 +1,118 @@
+package dms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dms/upnpav"
+	"github.com/anacrolix/log"
+)
+
+// A MediaProvider of a container holding a song kept in memory.
+type memProvider map[string]string
+
+func (me memProvider) Object(ctx context.Context, id string) (ProviderObject, error) {
+	switch id {
+	case "0":
+		return ProviderObject{
+			Object:     upnpav.Object{ID: "0", ParentID: "-1", Title: "Root", Class: "object.container.storageFolder"},
+			Container:  true,
+			ChildCount: len(me),
+		}, nil
+	}
+	data, ok := me[id]
+	if !ok {
+		return ProviderObject{}, fmt.Errorf("object %q: %w", id, fs.ErrNotExist)
+	}
+	return ProviderObject{
+		Object: upnpav.Object{ID: id, ParentID: "0", Title: id, Class: "object.item.audioItem.musicTrack"},
+		Resources: []ProviderResource{{
+			MimeType: "audio/mpeg",
+			Size:     uint64(len(data)),
+			Duration: 3 * time.Minute,
+		}},
+	}, nil
+}
+
+func (me memProvider) Children(ctx context.Context, id string) (ret []ProviderObject, err error) {
+	if id != "0" {
+		return nil, fs.ErrNotExist
+	}
+	for child := range me {
+		o, _ := me.Object(ctx, child)
+		ret = append(ret, o)
+	}
+	return
+}
+
+func (me memProvider) OpenResource(ctx context.Context, id string, index int) (io.ReadSeekCloser, error) {
+	data, ok := me[id]
+	if !ok || index != 0 {
+		return nil, fs.ErrNotExist
+	}
+	return nopCloser{strings.NewReader(data)}, nil
+}
+
+type nopCloser struct{ io.ReadSeeker }
+
+func (nopCloser) Close() error { return nil }
+
+func TestProvider(t *testing.T) {
+	s := &Server{
+		Provider: memProvider{"song": "0123456789"},
+		Logger:   log.Default,
+	}
+	cdService := &contentDirectoryService{Server: s}
+	browse := func(id, flag string) (string, error) {
+		ret, err := cdService.Handle("Browse", []byte("<u:Browse><ObjectID>"+id+"</ObjectID><BrowseFlag>"+flag+"</BrowseFlag></u:Browse>"), httptest.NewRequest("POST", "/", nil))
+		if err != nil {
+			return "", err
+		}
+		return ret[0][1], nil
+	}
+
+	root, err := browse("0", "BrowseMetadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<container id="0" parentID="-1" restricted="1" searchable="0" childCount="1">`; !strings.Contains(root, want) {
+		t.Errorf("root lacks %s:\n%s", want, root)
+	}
+	children, err := browse("0", "BrowseDirectChildren")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<item id="song" parentID="0" restricted="1"`,
+		`duration="0:03:00"`,
+		`protocolInfo="http-get:*:audio/mpeg:DLNA.ORG_OP=01;`,
+		`>http://example.com/providerRes?id=song&amp;index=0</res>`,
+	} {
+		if !strings.Contains(children, want) {
+			t.Errorf("children lack %s:\n%s", want, children)
+		}
+	}
+	if _, err := browse("missing", "BrowseMetadata"); err == nil || !strings.Contains(err.Error(), "not exist") {
+		t.Errorf("browsing a missing object: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/providerRes?id=song&index=0", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	w := httptest.NewRecorder()
+	s.serveProviderResource(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" || w.Header().Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("serving a range: %d %q %v", w.Code, w.Body, w.Header())
+	}
+	w = httptest.NewRecorder()
+	s.serveProviderResource(w, httptest.NewRequest("GET", "/providerRes?id=song&index=1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("serving a missing resource: %d", w.Code)
+	}
+}
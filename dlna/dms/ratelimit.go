@@ -0,0 +1,97 @@
+package dms
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/time/rate"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+// The burst of control and eventing requests allowed if
+// Server.ControlBurst isn't set.
+const defaultControlBurst = 20
+
+// Clients whose limiters haven't been used for this long are forgotten.
+const idleRateLimiterAge = 10 * time.Minute
+
+// Answers control requests over the client's rate limit, with a 429 status.
+var errTooManyRequests = upnp.Errorf(upnp.ActionFailedErrorCode, "Too many requests")
+
+type clientRateLimiter struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+// The rate limiters of control and eventing requests, by client.
+type clientRateLimits struct {
+	mu         sync.Mutex
+	m          map[string]*clientRateLimiter
+	lastPruned time.Time
+}
+
+// Reports whether the client may make a request now, and if not, how long
+// until it may.
+func (me *clientRateLimits) allow(client string, limit rate.Limit, burst int, now time.Time) (bool, time.Duration) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	l, ok := me.m[client]
+	if !ok {
+		if now.Sub(me.lastPruned) >= idleRateLimiterAge {
+			for c, l := range me.m {
+				if now.Sub(l.lastUsed) >= idleRateLimiterAge {
+					delete(me.m, c)
+				}
+			}
+			me.lastPruned = now
+		}
+		if me.m == nil {
+			me.m = make(map[string]*clientRateLimiter)
+		}
+		l = &clientRateLimiter{Limiter: rate.NewLimiter(limit, burst)}
+		me.m[client] = l
+	}
+	l.lastUsed = now
+	r := l.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// Reports whether the request is within its client's ControlRate, and if
+// not, sets a Retry-After header on the response.
+func (me *Server) allowControlRequest(w http.ResponseWriter, r *http.Request) bool {
+	if me.ControlRate <= 0 {
+		return true
+	}
+	burst := me.ControlBurst
+	if burst <= 0 {
+		burst = defaultControlBurst
+	}
+	client := playbackClient(r)
+	ok, wait := me.controlRateLimits.allow(client, rate.Limit(me.ControlRate), burst, time.Now())
+	if !ok {
+		me.Logger.Levelf(log.Debug, "rate limiting %s: %s %s", client, r.Method, r.URL.Path)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	return ok
+}
+
+// Answers requests over their client's ControlRate with 429 Too Many
+// Requests.
+func (me *Server) rateLimited(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !me.allowControlRequest(w, r) {
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
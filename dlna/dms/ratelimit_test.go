@@ -0,0 +1,89 @@
+package dms
+
+import (
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+func TestClientRateLimits(t *testing.T) {
+	var limits clientRateLimits
+	now := time.Unix(1000, 0)
+	for i := range 3 {
+		if ok, _ := limits.allow("tv", 1, 3, now); !ok {
+			t.Fatalf("request %d of the burst refused", i)
+		}
+	}
+	ok, wait := limits.allow("tv", 1, 3, now)
+	if ok || wait != time.Second {
+		t.Fatalf("got %v, %s after the burst", ok, wait)
+	}
+	if ok, _ := limits.allow("phone", 1, 3, now); !ok {
+		t.Fatal("another client was limited")
+	}
+	if ok, _ := limits.allow("tv", 1, 3, now.Add(time.Second)); !ok {
+		t.Fatal("refused after waiting")
+	}
+	limits.allow("new", 1, 3, now.Add(idleRateLimiterAge+time.Second))
+	if _, ok := limits.m["phone"]; ok {
+		t.Fatal("idle client not forgotten")
+	}
+}
+
+func TestControlRateLimit(t *testing.T) {
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	s := &Server{
+		FS:             fstest.MapFS{"Films/Heat.mkv": {}},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		AllowedIpNets:  []*net.IPNet{all},
+		ControlRate:    0.001,
+		ControlBurst:   2,
+	}
+	if err := s.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	control := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", serviceControlURL, strings.NewReader(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+			`<u:GetSystemUpdateID xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1"/></s:Body></s:Envelope>`))
+		r.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#GetSystemUpdateID"`)
+		w := httptest.NewRecorder()
+		s.serviceControlHandler(w, r)
+		return w
+	}
+	for range 2 {
+		if w := control(); w.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", w.Code, w.Body)
+		}
+	}
+	w := control()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	var fault struct {
+		Code uint `xml:"Body>Fault>detail>UPnPError>errorCode"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &fault); err != nil || fault.Code != upnp.ActionFailedErrorCode {
+		t.Errorf("got UPnP error %d, %v: %s", fault.Code, err, w.Body)
+	}
+
+	// Eventing shares the client's limit.
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("SUBSCRIBE", contentDirectoryEventSubURL, nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d subscribing", w.Code)
+	}
+}
@@ -0,0 +1,41 @@
+package dms
+
+import (
+	"path"
+)
+
+// Returns the parental rating of a video from its NFO file, or failing that,
+// from the tvshow.nfo of the show it's an episode of, in its folder or the
+// one above, for season folders. It's empty if there's none.
+func (me *Server) rating(filePath string, mt mimeType) string {
+	if p, _, ok := me.nfoPath(filePath, mt); ok {
+		if n, err := me.readNFO(p); err == nil && n.MPAA != "" {
+			return n.MPAA
+		}
+	}
+	dir := path.Dir(filePath)
+	for range 2 {
+		if n, err := me.readNFO(path.Join(dir, "tvshow.nfo")); err == nil && n.MPAA != "" {
+			return n.MPAA
+		}
+		if dir == "." || dir == "/" {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+	return ""
+}
+
+// Reports whether the file is hidden from the client with the User-Agent,
+// because its profile's MaxRating is lower than the file's rating.
+func (me *Server) hiddenByRating(userAgent, filePath string) bool {
+	p := me.clientProfile(userAgent)
+	if p == nil || p.MaxRating == "" {
+		return false
+	}
+	mt, err := me.mimeTypeByPath(filePath)
+	if err != nil || !mt.IsVideo() {
+		return false
+	}
+	return !p.AllowsRating(me.rating(filePath, mt))
+}
@@ -0,0 +1,73 @@
+package dms
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestRatingFilter(t *testing.T) {
+	nfo := func(rating string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte("<movie><mpaa>" + rating + "</mpaa></movie>")}
+	}
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv":                  {},
+			"Films/Heat.nfo":                  nfo("Rated R"),
+			"Films/Up.mkv":                    {},
+			"Films/Up.nfo":                    nfo("Rated PG"),
+			"Films/Home movie.mkv":            {},
+			"Shows/Bluey/tvshow.nfo":          nfo("TV-Y"),
+			"Shows/Bluey/Season 1/S01E01.mkv": {},
+			"Music/Hang Up.mp3":               {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Search:         &search.Index{},
+		Logger:         log.Default,
+		ClientProfiles: []ClientProfile{{Name: "kids", UserAgent: "KidsTV", MaxRating: "PG", HideUnrated: true}},
+	}
+	s.indexLibrary()
+	cdService := &contentDirectoryService{Server: s}
+	titles := func(userAgent, id string) (ret []string) {
+		obj, err := cdService.objectFromID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs, err := cdService.browseChildren(context.Background(), id, obj, "localhost", userAgent, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range objs {
+			ret = append(ret, o.(upnpav.Item).Title)
+		}
+		return
+	}
+	if a := titles("OtherTV", "Films"); len(a) != 3 {
+		t.Errorf("other client got %q", a)
+	}
+	if a := titles("KidsTV", "Films"); len(a) != 1 || a[0] != "Up.mkv" {
+		t.Errorf("kids got %q", a)
+	}
+	// Episodes take the show's rating.
+	if a := titles("KidsTV", "Shows%2FBluey%2FSeason+1"); len(a) != 1 {
+		t.Errorf("kids got %q", a)
+	}
+	_, total, err := cdService.search(context.Background(), cds.SearchArgs{
+		ContainerID:    "0",
+		SearchCriteria: `upnp:class derivedfrom "object.item.videoItem"`,
+	}, "localhost", "KidsTV", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("kids searched %d videos", total)
+	}
+}
@@ -0,0 +1,76 @@
+package dms
+
+import (
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/dvb"
+)
+
+// How much of a transport stream is read for its EIT, which is broadcast every
+// few seconds.
+const eitReadLimit = 8 << 20
+
+// Extensions of DVB transport stream recordings.
+var transportStreamExts = []string{".ts", ".m2ts", ".mts", ".tp", ".trp"}
+
+// Returns the programme a TV recording is of: from the EIT of a DVB transport
+// stream, or the tags of a Windows Media Center .wtv file.
+func (me *Server) recordedProgramme(filePath string, ffInfo *ffprobe.Info) (p dvb.Programme, ok bool) {
+	switch ext := strings.ToLower(path.Ext(filePath)); {
+	case ext == ".wtv":
+		p = wtvProgramme(ffInfo)
+	case slices.Contains(transportStreamExts, ext):
+		f, err := me.FS.Open(filePath)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		p, _ = dvb.Read(io.LimitReader(f, eitReadLimit))
+	}
+	return p, p.Title != ""
+}
+
+// Returns the programme described by a .wtv file's tags, as ffprobe has them.
+func wtvProgramme(info *ffprobe.Info) (p dvb.Programme) {
+	if info == nil {
+		return
+	}
+	p.Title = formatTag(info, "title")
+	if sub := formatTag(info, "WM/SubTitle"); sub != "" && sub != p.Title {
+		// The episode's title.
+		p.Title += ": " + sub
+	}
+	p.Description = formatTag(info, "WM/SubTitleDescription")
+	p.Channel = formatTag(info, "service_name", "WM/MediaStationName")
+	aired := formatTag(info, "WM/MediaOriginalBroadcastDateTime")
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.Parse(layout, aired); err == nil && t.Year() > 1601 {
+			p.Start = t
+			break
+		}
+	}
+	if d, err := info.Duration(); err == nil && !p.Start.IsZero() {
+		p.Duration = d
+	}
+	return
+}
+
+// Returns the first of the container tags that's set. Tag names are matched
+// without regard to case.
+func formatTag(info *ffprobe.Info, names ...string) string {
+	tags, _ := info.Format["tags"].(map[string]interface{})
+	for _, name := range names {
+		for k, v := range tags {
+			if s, ok := v.(string); ok && strings.EqualFold(k, name) && strings.TrimSpace(s) != "" {
+				return strings.TrimSpace(s)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestRecordedTVMetadata(t *testing.T) {
+	// A transport stream of null packets, without any service information.
+	nullPackets := bytes.Repeat(append([]byte{0x47, 0x1f, 0xff, 0x10}, bytes.Repeat([]byte{0xff}, 184)...), 4)
+	s := &Server{
+		FS: fstest.MapFS{
+			"TV/BBC ONE_20240301_2000.wtv": {},
+			"TV/BBC ONE_20240302_2000.ts":  {Data: nullPackets},
+		},
+		RootObjectPath: ".",
+		FFProbeCache:   mapCache{},
+		NoTranscode:    true,
+		Logger:         log.Default,
+	}
+	s.FFProbeCache.Set(ffmpegInfoCacheKey{"TV/BBC ONE_20240301_2000.wtv", time.Time{}.UnixNano()}, &ffprobe.Info{
+		Format: map[string]interface{}{
+			"duration": "3540",
+			"tags": map[string]interface{}{
+				"Title":                             "Doctor Who",
+				"WM/SubTitle":                       "Blink",
+				"WM/SubTitleDescription":            "The Doctor is stuck in 1969.",
+				"service_name":                      "BBC ONE",
+				"WM/MediaOriginalBroadcastDateTime": "2024-03-01 20:00:00",
+			},
+		},
+	})
+	s.FFProbeCache.Set(ffmpegInfoCacheKey{"TV/BBC ONE_20240302_2000.ts", time.Time{}.UnixNano()}, &ffprobe.Info{})
+	cdService := &contentDirectoryService{Server: s}
+	get := func(p string) upnpav.Object {
+		fi, err := fs.Stat(s.FS, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := cdService.cdsObjectToUpnpavObject(context.Background(), object{p, "."}, fi, "localhost", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj.(upnpav.Item).Object
+	}
+	o := get("TV/BBC ONE_20240301_2000.wtv")
+	if o.Title != "Doctor Who: Blink" || o.Description != "The Doctor is stuck in 1969." || o.ChannelName != "BBC ONE" || o.Class != broadcastClass {
+		t.Errorf("got %+v", o)
+	}
+	if o.Date.Format("2006-01-02") != "2024-03-01" || o.ScheduledStartTime == "" || o.ScheduledEndTime == "" {
+		t.Errorf("got date %v, times %q to %q", o.Date, o.ScheduledStartTime, o.ScheduledEndTime)
+	}
+	// Without an EIT, it's titled by its file name.
+	if o := get("TV/BBC ONE_20240302_2000.ts"); o.Title != "BBC ONE_20240302_2000.ts" || o.Class != "object.item.videoItem" {
+		t.Errorf("got %+v", o)
+	}
+}
@@ -0,0 +1,224 @@
+package dms
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/upnp"
+
+	"github.com/anacrolix/dms/natpmp"
+)
+
+const (
+	// How long port mappings are asked for. They're renewed halfway through.
+	portMappingLease = time.Hour
+	// How long to wait before looking for a router again, if none mapped
+	// the port.
+	portMappingRetry = 5 * time.Minute
+	// How long routers are given to answer UPnP discovery.
+	igdDiscoveryTimeout = 3 * time.Second
+)
+
+// Reports whether the address is outside the local networks: neither
+// loopback, link-local nor private.
+func externalIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsPrivate()
+}
+
+// Reports whether the request comes from outside the local networks.
+func externalRequest(r *http.Request) bool {
+	client, _, _ := strings.Cut(playbackClient(r), "%")
+	return externalIP(net.ParseIP(client))
+}
+
+// Reports whether the path is served to remote clients: the web UI, the
+// JSON API, and the streams web players fetch. UPnP descriptions, control
+// and eventing are left to the local network.
+func remotePath(p string) bool {
+	return p == "/" || corsPath(p)
+}
+
+// Reports whether the request has the remote user's credentials.
+func (me *Server) remoteAuthorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(me.RemoteUser))
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(me.RemotePassword))
+	return userOK&passwordOK == 1
+}
+
+// Serves remote clients on RemoteConn: the paths remotePath allows, and only
+// with the remote user's credentials from outside the local networks.
+func (me *Server) remoteHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !remotePath(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		if externalRequest(r) && !me.remoteAuthorized(r) {
+			if _, _, ok := r.BasicAuth(); ok {
+				me.offence(r, "wrong remote credentials")
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="dms", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), remoteConnContextKey{}, true)))
+	})
+}
+
+type remoteConnContextKey struct{}
+
+// Reports whether the request came in on RemoteConn.
+func viaRemoteConn(r *http.Request) bool {
+	return r.Context().Value(remoteConnContextKey{}) != nil
+}
+
+func (me *Server) serveRemote() error {
+	err := me.remoteServer.ServeTLS(me.RemoteConn, "", "")
+	select {
+	case <-me.closed:
+		return nil
+	default:
+		return err
+	}
+}
+
+// Something that maps ports on the router, by UPnP IGD or NAT-PMP.
+type portMapper interface {
+	mapPort(port int, lease time.Duration) (external int, err error)
+	unmapPort(port, external int) error
+	externalIP() (net.IP, error)
+	String() string
+}
+
+type igdPortMapper struct {
+	upnp.Device
+}
+
+func (me igdPortMapper) mapPort(port int, lease time.Duration) (int, error) {
+	return me.AddPortMapping(upnp.TCP, port, port, "dms remote access", lease)
+}
+
+func (me igdPortMapper) unmapPort(port, external int) error {
+	return me.DeletePortMapping(upnp.TCP, external)
+}
+
+func (me igdPortMapper) externalIP() (net.IP, error) {
+	return me.GetExternalIPAddress()
+}
+
+func (me igdPortMapper) String() string {
+	return "UPnP gateway " + me.ID()
+}
+
+type natpmpPortMapper struct {
+	*natpmp.Client
+}
+
+func (me natpmpPortMapper) mapPort(port int, lease time.Duration) (int, error) {
+	external, _, err := me.AddPortMapping(natpmp.TCP, port, port, lease)
+	return external, err
+}
+
+func (me natpmpPortMapper) unmapPort(port, external int) error {
+	return me.DeletePortMapping(natpmp.TCP, port)
+}
+
+func (me natpmpPortMapper) externalIP() (net.IP, error) {
+	return me.ExternalAddress()
+}
+
+// Finds the routers that might map ports.
+func discoverPortMappers(logger log.Logger) (ret []portMapper) {
+	for _, d := range upnp.Discover(0, igdDiscoveryTimeout, logger) {
+		ret = append(ret, igdPortMapper{d})
+	}
+	for _, gw := range natpmp.Gateways() {
+		ret = append(ret, natpmpPortMapper{natpmp.NewClient(gw)})
+	}
+	return
+}
+
+// A port mapped on a router.
+type portMapping struct {
+	mapper   portMapper
+	external int
+}
+
+// Maps the port on each of the routers that will.
+func (me *Server) mapPort(mappers []portMapper, port int) (ret []portMapping) {
+	for _, pm := range mappers {
+		external, err := pm.mapPort(port, portMappingLease)
+		if err != nil {
+			me.Logger.Levelf(log.Debug, "mapping port %d on %s: %v", port, pm, err)
+			continue
+		}
+		addr := fmt.Sprintf("port %d", external)
+		if ip, err := pm.externalIP(); err == nil && ip != nil {
+			addr = net.JoinHostPort(ip.String(), fmt.Sprint(external))
+		}
+		me.Logger.Printf("remote access is on https://%s, mapped by %s", addr, pm)
+		ret = append(ret, portMapping{pm, external})
+	}
+	return
+}
+
+// Renews the mappings, returning those still in place.
+func (me *Server) renewPortMappings(mapped []portMapping, port int) (ret []portMapping) {
+	for _, m := range mapped {
+		if _, err := m.mapper.mapPort(port, portMappingLease); err != nil {
+			me.Logger.Printf("renewing port mapping on %s: %v", m.mapper, err)
+			continue
+		}
+		ret = append(ret, m)
+	}
+	return
+}
+
+// Keeps RemoteConn's port mapped on the routers until the server is closed,
+// and then removes the mappings.
+func (me *Server) maintainPortMapping() {
+	defer close(me.portMappingStopped)
+	port := me.RemoteConn.Addr().(*net.TCPAddr).Port
+	var mapped []portMapping
+	for {
+		if len(mapped) == 0 {
+			mapped = me.mapPort(discoverPortMappers(me.Logger), port)
+			if len(mapped) == 0 {
+				me.Logger.Printf("no router mapped port %d for remote access; trying again in %s", port, portMappingRetry)
+			}
+		} else {
+			mapped = me.renewPortMappings(mapped, port)
+		}
+		wait := portMappingLease / 2
+		if len(mapped) == 0 {
+			wait = portMappingRetry
+		}
+		select {
+		case <-me.closed:
+			for _, m := range mapped {
+				if err := m.mapper.unmapPort(port, m.external); err != nil {
+					me.Logger.Printf("removing port mapping on %s: %v", m.mapper, err)
+				}
+			}
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Waits for the port mappings to be removed, if they were made.
+func (me *Server) waitPortMapping() {
+	if me.portMappingStopped != nil {
+		<-me.portMappingStopped
+	}
+}
@@ -0,0 +1,144 @@
+package dms
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestRemoteAccess(t *testing.T) {
+	conn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s := &Server{
+		Logger:         log.Default,
+		Favorites:      &Favorites{},
+		RemoteConn:     conn,
+		RemoteUser:     "dms",
+		RemotePassword: "secret",
+		BanThreshold:   2,
+	}
+	if err := s.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	s.httpServeMux = http.NewServeMux()
+	s.initMux(s.httpServeMux)
+	h := s.newHTTPServer().Handler
+	do := func(remote bool, target, remoteAddr string, auth ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = remoteAddr
+		h := h
+		if remote {
+			r.TLS = &tls.ConnectionState{}
+			h = s.remoteHandler(h)
+		}
+		if len(auth) == 2 {
+			r.SetBasicAuth(auth[0], auth[1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	const (
+		local  = false
+		remote = true
+		away   = "203.0.113.9:5000"
+	)
+
+	if w := do(local, favoritesAPIPath, away); w.Code != http.StatusForbidden {
+		t.Fatalf("plain HTTP from away got %d", w.Code)
+	}
+	if w := do(local, favoritesAPIPath, "192.168.1.20:5000"); w.Code != http.StatusOK {
+		t.Fatalf("plain HTTP at home got %d", w.Code)
+	}
+	if w := do(remote, favoritesAPIPath, away); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("without credentials got %d, %v", w.Code, w.Header())
+	}
+	if w := do(remote, favoritesAPIPath, away, "dms", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("with credentials got %d", w.Code)
+	}
+	if w := do(remote, "/", "192.168.1.20:5000"); w.Code != http.StatusOK {
+		t.Fatalf("at home over HTTPS got %d", w.Code)
+	}
+	if w := do(remote, rootDescPath, away, "dms", "secret"); w.Code != http.StatusNotFound {
+		t.Fatalf("device description got %d", w.Code)
+	}
+	// Guessing the password gets the client banned.
+	for range 2 {
+		do(remote, favoritesAPIPath, away, "dms", "guess")
+	}
+	if w := do(remote, favoritesAPIPath, away, "dms", "secret"); w.Code != http.StatusForbidden {
+		t.Fatalf("banned client got %d", w.Code)
+	}
+}
+
+type fakePortMapper struct {
+	fail     bool
+	mapped   map[int]time.Duration
+	unmapped []int
+}
+
+func (me *fakePortMapper) mapPort(port int, lease time.Duration) (int, error) {
+	if me.fail {
+		return 0, errors.New("refused")
+	}
+	if me.mapped == nil {
+		me.mapped = make(map[int]time.Duration)
+	}
+	me.mapped[port] += lease
+	return port + 1, nil
+}
+
+func (me *fakePortMapper) unmapPort(port, external int) error {
+	me.unmapped = append(me.unmapped, external)
+	return nil
+}
+
+func (me *fakePortMapper) externalIP() (net.IP, error) {
+	return net.IPv4(203, 0, 113, 1), nil
+}
+
+func (me *fakePortMapper) String() string {
+	return "fake router"
+}
+
+func TestPortMapping(t *testing.T) {
+	s := &Server{Logger: log.Default}
+	good, bad := &fakePortMapper{}, &fakePortMapper{fail: true}
+	mapped := s.mapPort([]portMapper{bad, good}, 1339)
+	if len(mapped) != 1 || mapped[0].mapper != good || mapped[0].external != 1340 {
+		t.Fatalf("got %+v", mapped)
+	}
+	if mapped = s.renewPortMappings(mapped, 1339); len(mapped) != 1 || good.mapped[1339] != 2*portMappingLease {
+		t.Fatalf("got %+v, %v", mapped, good.mapped)
+	}
+	good.fail = true
+	if mapped = s.renewPortMappings(mapped, 1339); len(mapped) != 0 {
+		t.Fatalf("got %+v after the router stopped mapping", mapped)
+	}
+}
+
+func TestExternalIP(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"203.0.113.9": true,
+		"2001:db8::1": true,
+		"192.168.1.2": false,
+		"10.1.2.3":    false,
+		"127.0.0.1":   false,
+		"::1":         false,
+		"fe80::1":     false,
+		"fd00::1":     false,
+	} {
+		if got := externalIP(net.ParseIP(ip)); got != want {
+			t.Errorf("%s: got %v", ip, got)
+		}
+	}
+}
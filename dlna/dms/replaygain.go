@@ -0,0 +1,79 @@
+package dms
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+// Prefixes some taggers put on custom tag names, which ffprobe passes on.
+var audioTagPrefixes = []string{"----:com.apple.iTunes:", "TXXX:"}
+
+// Looks up a tag of the file or its audio stream, ignoring case. A tag named
+// exactly so is preferred over one with a prefix in audioTagPrefixes.
+func audioTag(info *ffprobe.Info, name string) (string, bool) {
+	if info == nil {
+		return "", false
+	}
+	tags := []interface{}{info.Format["tags"], firstStream(info, "audio")["tags"]}
+	for _, prefix := range append([]string{""}, audioTagPrefixes...) {
+		for _, t := range tags {
+			m, _ := t.(map[string]interface{})
+			for k, v := range m {
+				if s, ok := v.(string); ok && strings.EqualFold(k, prefix+name) {
+					return s, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Returns the gain in dB to apply to the file for the ReplayGain mode, "track"
+// or "album", and whether the file has the tags for it. Album gain falls back
+// to track gain. Gains are reduced where the tagged peak would clip.
+func replayGain(info *ffprobe.Info, mode string) (gain float64, ok bool) {
+	if mode != "track" && mode != "album" {
+		return
+	}
+	modes := []string{mode}
+	if mode == "album" {
+		modes = append(modes, "track")
+	}
+	for _, m := range modes {
+		if s, found := audioTag(info, "replaygain_"+m+"_gain"); found {
+			gain, ok = parseGain(s)
+		} else if s, found := audioTag(info, "r128_"+m+"_gain"); found {
+			// A Q7.8 number relative to -23 LUFS, where ReplayGain is
+			// relative to -18 LUFS.
+			if q, err := strconv.ParseInt(strings.TrimSpace(s), 10, 16); err == nil {
+				gain, ok = float64(q)/256+5, true
+			}
+		}
+		if !ok {
+			continue
+		}
+		if s, found := audioTag(info, "replaygain_"+m+"_peak"); found {
+			if peak, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil && peak > 0 {
+				gain = math.Min(gain, -20*math.Log10(peak))
+			}
+		}
+		return
+	}
+	return
+}
+
+// Parses gains such as "-6.48 dB".
+func parseGain(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(s, "dB"), "db"))
+	g, err := strconv.ParseFloat(s, 64)
+	return g, err == nil
+}
+
+// Returns an ffmpeg filter changing the volume by the gain in dB.
+func volumeFilter(gain float64) string {
+	return "volume=" + strconv.FormatFloat(gain, 'f', 2, 64) + "dB"
+}
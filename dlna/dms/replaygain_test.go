@@ -0,0 +1,55 @@
+package dms
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func TestReplayGain(t *testing.T) {
+	for _, _case := range []struct {
+		info     string
+		mode     string
+		expected float64
+		ok       bool
+	}{
+		{`{"format":{"tags":{"REPLAYGAIN_TRACK_GAIN":"-6.50 dB","REPLAYGAIN_ALBUM_GAIN":"-7.25 dB"}},"streams":[]}`, "album", -7.25, true},
+		{`{"format":{"tags":{"replaygain_track_gain":"+3.00 dB","replaygain_track_peak":"0.891251"}},"streams":[]}`, "album", 1, true},
+		{`{"format":{},"streams":[{"codec_type":"audio","tags":{"R128_TRACK_GAIN":"-512"}}]}`, "track", 3, true},
+		{`{"format":{"tags":{"title":"Untagged"}},"streams":[]}`, "track", 0, false},
+		{`{"format":{"tags":{"REPLAYGAIN_TRACK_GAIN":"-6.50 dB"}},"streams":[]}`, "", 0, false},
+		{`{"format":{"tags":{"----:com.apple.iTunes:replaygain_track_gain":"-2.00 dB"}},"streams":[]}`, "track", -2, true},
+	} {
+		var info ffprobe.Info
+		if err := json.Unmarshal([]byte(_case.info), &info); err != nil {
+			t.Fatal(err)
+		}
+		gain, ok := replayGain(&info, _case.mode)
+		if ok != _case.ok || ok && volumeFilter(gain) != volumeFilter(_case.expected) {
+			t.Errorf("expected %v, %v for %s but got %v, %v", _case.expected, _case.ok, _case.info, gain, ok)
+		}
+	}
+}
+
+func TestAudioTag(t *testing.T) {
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{"tags":{"album_artist":"Various","subtitle":"Live","ARTIST":"Nina","----:com.apple.iTunes:title":"Wild Is the Wind"}},"streams":[]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	for _, _case := range []struct {
+		name, expected string
+		ok             bool
+	}{
+		{"artist", "Nina", true},
+		{"title", "Wild Is the Wind", true},
+		{"album", "", false},
+	} {
+		// Map order is random, so look more than once.
+		for range 10 {
+			if s, ok := audioTag(&info, _case.name); s != _case.expected || ok != _case.ok {
+				t.Fatalf("%s: got %q, %v", _case.name, s, ok)
+			}
+		}
+	}
+}
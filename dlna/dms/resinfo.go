@@ -0,0 +1,126 @@
+package dms
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// The res attributes describing a resource's streams. Some renderers pick
+// their decoder from them, or refuse resources without them. Zero values are
+// unknown, and left out.
+type resStreamInfo struct {
+	// In bytes per second, as UPnP has it, not the bits per second of
+	// ffprobe.
+	bitrate         uint
+	sampleFrequency uint
+	nrAudioChannels uint
+	bitsPerSample   uint
+	colorDepth      uint
+}
+
+func (me resStreamInfo) setAttrs(r *upnpav.Resource) {
+	r.Bitrate = me.bitrate
+	r.SampleFrequency = me.sampleFrequency
+	r.NrAudioChannels = me.nrAudioChannels
+	r.BitsPerSample = me.bitsPerSample
+	r.ColorDepth = me.colorDepth
+}
+
+// Returns the res attributes of a file as it is, from its probe.
+func probedResStreamInfo(info *ffprobe.Info) (ret resStreamInfo) {
+	if info == nil {
+		return
+	}
+	if bps, err := info.Bitrate(); err == nil {
+		ret.bitrate = bps / 8
+	}
+	if a := firstStream(info, "audio"); a != nil {
+		ret.sampleFrequency = uint(max(streamInt(a, "sample_rate"), 0))
+		ret.nrAudioChannels = uint(max(streamInt(a, "channels"), 0))
+		ret.bitsPerSample = uint(max(streamInt(a, "bits_per_raw_sample"), streamInt(a, "bits_per_sample"), 0))
+	}
+	if v := firstStream(info, "video"); v != nil {
+		ret.colorDepth = pixelFormatDepth(streamString(v, "pix_fmt"), streamInt(v, "bits_per_raw_sample"))
+	}
+	return
+}
+
+// Returns the bits per pixel of an ffmpeg pixel format, not counting alpha.
+// The bits per component are given by ffprobe for some codecs, or otherwise
+// taken from the format's name: yuv420p10le has 10, and rgb24 has 24 bits per
+// pixel in all.
+func pixelFormatDepth(pixFmt string, componentBits int64) uint {
+	if pixFmt == "" {
+		return 0
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(pixFmt, "le"), "be")
+	if strings.HasPrefix(name, "mono") {
+		return 1
+	}
+	digits := name[strings.LastIndexFunc(name, func(r rune) bool { return r < '0' || r > '9' })+1:]
+	n, _ := strconv.Atoi(digits)
+	name = strings.TrimSuffix(name, digits)
+	gray := strings.HasPrefix(name, "gray")
+	if n != 0 && !gray && (strings.HasPrefix(name, "rgb") || strings.HasPrefix(name, "bgr")) {
+		return uint(n)
+	}
+	bits := uint(8)
+	switch {
+	case componentBits > 0:
+		bits = uint(componentBits)
+	case n != 0 && (gray || strings.HasSuffix(name, "p")):
+		bits = uint(n)
+	}
+	if gray {
+		return bits
+	}
+	return 3 * bits
+}
+
+// Returns the res attributes of a transcode of a file with the probed
+// attributes, made with the audio options. The options only say what's changed,
+// so the rest is as it was.
+func transcodedResStreamInfo(ts transcodeSpec, probed resStreamInfo, opts transcode.AudioOptions) (ret resStreamInfo) {
+	if ts.videoCodec != "" {
+		// Video transcodes are 8 bit 4:2:0. Their audio depends on the
+		// source in ways not worth working out here.
+		ret.colorDepth = 24
+		return
+	}
+	if ts.dop {
+		// DSD in 24 bit frames at a sixteenth of the DSD rate, which
+		// ffprobe doesn't report consistently.
+		ret.nrAudioChannels = probed.nrAudioChannels
+		ret.bitsPerSample = 24
+		return
+	}
+	opts = ts.audioOptions.Merge(opts)
+	ret.sampleFrequency = probed.sampleFrequency
+	if opts.SampleRate != 0 {
+		ret.sampleFrequency = uint(opts.SampleRate)
+	}
+	ret.nrAudioChannels = probed.nrAudioChannels
+	if opts.Channels != 0 {
+		ret.nrAudioChannels = uint(opts.Channels)
+	}
+	switch {
+	case ts.pcmBitsPerSample != 0:
+		ret.bitsPerSample = ts.pcmBitsPerSample
+	case opts.SampleFormat == "s16":
+		ret.bitsPerSample = 16
+	case opts.SampleFormat == "s32":
+		// FLAC, which is all that's given s32, stores it as 24 bits.
+		ret.bitsPerSample = 24
+	default:
+		ret.bitsPerSample = probed.bitsPerSample
+	}
+	if ts.pcmBitsPerSample != 0 {
+		ret.bitrate = ret.sampleFrequency * ret.nrAudioChannels * ret.bitsPerSample / 8
+	}
+	return
+}
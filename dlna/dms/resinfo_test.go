@@ -0,0 +1,73 @@
+package dms
+
+import (
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+func TestProbedResStreamInfo(t *testing.T) {
+	info := &ffprobe.Info{
+		Format: map[string]interface{}{"bit_rate": "1411200"},
+		Streams: []map[string]interface{}{
+			{"codec_type": "video", "pix_fmt": "yuv420p10le"},
+			{"codec_type": "audio", "sample_rate": "96000", "channels": float64(6), "bits_per_raw_sample": "24"},
+		},
+	}
+	want := resStreamInfo{bitrate: 176400, sampleFrequency: 96000, nrAudioChannels: 6, bitsPerSample: 24, colorDepth: 30}
+	if got := probedResStreamInfo(info); got != want {
+		t.Errorf("got %+v", got)
+	}
+	if got := probedResStreamInfo(nil); got != (resStreamInfo{}) {
+		t.Errorf("unprobed got %+v", got)
+	}
+}
+
+func TestPixelFormatDepth(t *testing.T) {
+	for _, tc := range []struct {
+		pixFmt string
+		bits   int64
+		want   uint
+	}{
+		{"", 0, 0},
+		{"yuv420p", 0, 24},
+		{"yuvj444p", 0, 24},
+		{"yuv420p10le", 0, 30},
+		{"p010le", 0, 30},
+		{"yuv420p", 12, 36},
+		{"nv12", 0, 24},
+		{"rgb24", 0, 24},
+		{"rgb48be", 0, 48},
+		{"rgba", 0, 24},
+		{"gray", 0, 8},
+		{"gray16le", 0, 16},
+		{"monob", 0, 1},
+		{"pal8", 0, 24},
+	} {
+		if got := pixelFormatDepth(tc.pixFmt, tc.bits); got != tc.want {
+			t.Errorf("%q, %d: got %d, want %d", tc.pixFmt, tc.bits, got, tc.want)
+		}
+	}
+}
+
+func TestTranscodedResStreamInfo(t *testing.T) {
+	probed := resStreamInfo{bitrate: 500000, sampleFrequency: 96000, nrAudioChannels: 6, bitsPerSample: 24, colorDepth: 30}
+	for name, tc := range map[string]struct {
+		ts   transcodeSpec
+		opts transcode.AudioOptions
+		want resStreamInfo
+	}{
+		"lpcm":       {transcodes["lpcm"], transcode.AudioOptions{}, resStreamInfo{bitrate: 176400, sampleFrequency: 44100, nrAudioChannels: 2, bitsPerSample: 16}},
+		"flac":       {transcodes["flac"], transcode.AudioOptions{}, resStreamInfo{sampleFrequency: 88200, nrAudioChannels: 6, bitsPerSample: 24}},
+		"dop":        {transcodes["dop"], transcode.AudioOptions{}, resStreamInfo{nrAudioChannels: 6, bitsPerSample: 24}},
+		"web":        {transcodes["web"], transcode.AudioOptions{}, resStreamInfo{colorDepth: 24}},
+		"adjusted":   {adjustedAudioSpec, transcode.AudioOptions{SampleRate: 48000, SampleFormat: "s16", Channels: 2}, resStreamInfo{sampleFrequency: 48000, nrAudioChannels: 2, bitsPerSample: 16}},
+		"unadjusted": {adjustedAudioSpec, transcode.AudioOptions{}, resStreamInfo{sampleFrequency: 96000, nrAudioChannels: 6, bitsPerSample: 24}},
+	} {
+		if got := transcodedResStreamInfo(tc.ts, probed, tc.opts); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package resource has helpers for serving media resources to DLNA clients
+// over HTTP, independent of where the media comes from.
+package resource
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dms/dlna"
+)
+
+// Parses a TimeSeekRange.dlna.org header value, such as "npt=10.5-".
+func ParseTimeSeekRange(val string) (ret dlna.NPTRange, err error) {
+	if !strings.HasPrefix(val, "npt=") {
+		err = errors.New("bad prefix")
+		return
+	}
+	ret, err = dlna.ParseNPTRange(val[len("npt="):])
+	if err != nil {
+		return
+	}
+	return
+}
+
+// Determines the time-based range to transcode, and sets the appropriate
+// headers. Returns !ok if there was an error and the caller should stop
+// handling the request.
+func HandleTimeSeekRange(w http.ResponseWriter, hs http.Header, dynamicMode bool) (r dlna.NPTRange, partialResponse, ok bool) {
+	if dynamicMode || len(hs[http.CanonicalHeaderKey(dlna.TimeSeekRangeDomain)]) == 0 {
+		ok = true
+		return
+	}
+	partialResponse = true
+	h := hs.Get(dlna.TimeSeekRangeDomain)
+	r, err := ParseTimeSeekRange(h)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Passing an exact NPT duration seems to cause trouble pass the "iono"
+	// (*) duration instead.
+	//
+	// TODO: Check that the request range can't already have /.
+	w.Header().Set(dlna.TimeSeekRangeDomain, h+"/*")
+	ok = true
+	return
+}
+
+// Writes the status for a response to a request handled by
+// HandleTimeSeekRange.
+func WriteResponseCode(w http.ResponseWriter, partialResponse bool) {
+	w.WriteHeader(func() int {
+		if partialResponse {
+			return http.StatusPartialContent
+		} else {
+			return http.StatusOK
+		}
+	}())
+}
+
+// A file is taken to still be growing for this long after its size was seen
+// to change, and sizes are compared with checks no older than this.
+const GrowingFileAge = time.Minute
+
+// Tells which files are still being written to, from the sizes they had when
+// they were last checked. The zero value is ready for use.
+type GrowingFiles struct {
+	mu        sync.Mutex
+	m         map[string]*growingFile
+	lastPrune time.Time
+}
+
+type growingFile struct {
+	size    int64
+	checked time.Time
+	// When the size was last seen to change.
+	changed time.Time
+}
+
+// Reports whether the file, identified by key, is still being written to:
+// it's named like a partial download, such as "film.mkv.part", or its size
+// changed between this and an earlier check.
+func (me *GrowingFiles) IsGrowing(key string, fi fs.FileInfo) bool {
+	if strings.HasSuffix(fi.Name(), ".part") {
+		return true
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	now := time.Now()
+	if now.Sub(me.lastPrune) >= GrowingFileAge {
+		for k, f := range me.m {
+			if now.Sub(f.checked) >= GrowingFileAge {
+				delete(me.m, k)
+			}
+		}
+		me.lastPrune = now
+	}
+	if me.m == nil {
+		me.m = make(map[string]*growingFile)
+	}
+	f := me.m[key]
+	if f == nil {
+		me.m[key] = &growingFile{size: fi.Size(), checked: now}
+		return false
+	}
+	if f.size != fi.Size() {
+		f.size = fi.Size()
+		f.changed = now
+	}
+	f.checked = now
+	return !f.changed.IsZero() && now.Sub(f.changed) < GrowingFileAge
+}
@@ -0,0 +1,64 @@
+package resource
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/dms/dlna"
+)
+
+func TestHandleTimeSeekRange(t *testing.T) {
+	w := httptest.NewRecorder()
+	hs := http.Header{}
+	hs.Set(dlna.TimeSeekRangeDomain, "npt=00:00:10.500-")
+	r, partial, ok := HandleTimeSeekRange(w, hs, false)
+	if !ok || !partial || r.Start != 10500*time.Millisecond {
+		t.Fatalf("got %v, %v, %v", r, partial, ok)
+	}
+	if h := w.Header().Get(dlna.TimeSeekRangeDomain); h != "npt=00:00:10.500-/*" {
+		t.Errorf("got header %q", h)
+	}
+	// Dynamic streams can't seek.
+	if _, partial, ok := HandleTimeSeekRange(httptest.NewRecorder(), hs, true); !ok || partial {
+		t.Errorf("dynamic: got %v, %v", partial, ok)
+	}
+	hs.Set(dlna.TimeSeekRangeDomain, "10.5-")
+	w = httptest.NewRecorder()
+	if _, _, ok := HandleTimeSeekRange(w, hs, false); ok || w.Code != http.StatusBadRequest {
+		t.Errorf("bad range: got %v, %d", ok, w.Code)
+	}
+}
+
+func TestGrowingFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rec.ts":         {Data: []byte("a")},
+		"film.mkv":       {Data: []byte("a"), ModTime: time.Now()},
+		"film2.mkv.part": {},
+	}
+	var g GrowingFiles
+	check := func(name string) bool {
+		fi, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g.IsGrowing(name, fi)
+	}
+	if !check("film2.mkv.part") {
+		t.Error("partial download isn't growing")
+	}
+	// Recently modified files aren't growing until their size changes.
+	if check("rec.ts") || check("film.mkv") || check("film.mkv") {
+		t.Error("unchanged file is growing")
+	}
+	fsys["rec.ts"].Data = []byte("ab")
+	if !check("rec.ts") {
+		t.Error("file that grew isn't growing")
+	}
+	if !check("rec.ts") {
+		t.Error("file stopped growing straight away")
+	}
+}
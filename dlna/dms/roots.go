@@ -0,0 +1,65 @@
+package dms
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// A folder shared as a container in the root, alongside the contents of
+// RootObjectPath.
+type RootDir struct {
+	// The container's title, and the first element of the paths within it.
+	Name string
+	// The folder on the OS, such as /mnt/movies or D:\Music.
+	Path string
+}
+
+// Lists the Roots in the root of the FS. Each is on its own filesystem, if
+// OneFileSystem is set.
+func (srv *Server) mountRoots() error {
+	for _, r := range srv.Roots {
+		var fsys fs.FS = os.DirFS(RootPath(r.Path))
+		if srv.OneFileSystem {
+			var err error
+			if fsys, err = oneFileSystemFS(fsys); err != nil {
+				return err
+			}
+		}
+		srv.FS = mountFS(srv.FS, r.Name, fsys)
+	}
+	return nil
+}
+
+// An FS of an empty root, for servers sharing only Roots.
+type emptyFS struct{}
+
+func (emptyFS) Open(name string) (fs.File, error) {
+	if name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return emptyDir{}, nil
+}
+
+type emptyDir struct{}
+
+func (emptyDir) Stat() (fs.FileInfo, error) { return emptyDir{}, nil }
+func (emptyDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: fs.ErrInvalid}
+}
+func (emptyDir) Close() error { return nil }
+
+func (emptyDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n > 0 {
+		return nil, io.EOF
+	}
+	return nil, nil
+}
+
+func (emptyDir) Name() string       { return "." }
+func (emptyDir) Size() int64        { return 0 }
+func (emptyDir) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (emptyDir) ModTime() time.Time { return time.Time{} }
+func (emptyDir) IsDir() bool        { return true }
+func (emptyDir) Sys() any           { return nil }
@@ -0,0 +1,42 @@
+package dms
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMountRoots(t *testing.T) {
+	movies, music := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(movies, "Heat.mkv"), []byte("heat"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{
+		FS:    emptyFS{},
+		Roots: []RootDir{{"Movies", movies}, {"Music", music}},
+	}
+	if err := srv.mountRoots(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := fs.ReadDir(srv.FS, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			t.Errorf("%q isn't a folder", e.Name())
+		}
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "Movies" || names[1] != "Music" {
+		t.Errorf("got %q", names)
+	}
+	if b, err := fs.ReadFile(srv.FS, "Movies/Heat.mkv"); err != nil || string(b) != "heat" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if _, err := fs.Stat(srv.FS, "Music/Heat.mkv"); err == nil {
+		t.Error("found a movie in the music")
+	}
+}
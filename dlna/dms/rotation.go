@@ -0,0 +1,49 @@
+package dms
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+// Returns the degrees, 0, 90, 180 or 270, to turn a video clockwise to stand
+// it upright. Phones record it in a display matrix, which ffprobe gives as an
+// anticlockwise rotation, and older files in a rotate tag.
+func videoRotation(info *ffprobe.Info) int {
+	s := movingVideoStream(info)
+	if s == nil {
+		return 0
+	}
+	clockwise, ok := 0.0, false
+	sideData, _ := s["side_data_list"].([]interface{})
+	for _, sd := range sideData {
+		m, _ := sd.(map[string]interface{})
+		switch v := m["rotation"].(type) {
+		case float64:
+			clockwise, ok = -v, true
+		case json.Number:
+			f, err := v.Float64()
+			clockwise, ok = -f, err == nil
+		}
+	}
+	if !ok {
+		clockwise, _ = strconv.ParseFloat(streamTag(s, "rotate"), 64)
+	}
+	return (int(math.Round(clockwise/90))%4 + 4) % 4 * 90
+}
+
+// Returns the resolution, as "WxH", of a video once it's turned by the
+// degrees.
+func uprightResolution(resolution string, rotation int) string {
+	if rotation != 90 && rotation != 270 {
+		return resolution
+	}
+	w, h, ok := strings.Cut(resolution, "x")
+	if !ok {
+		return resolution
+	}
+	return h + "x" + w
+}
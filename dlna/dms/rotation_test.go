@@ -0,0 +1,48 @@
+package dms
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func TestVideoRotation(t *testing.T) {
+	for _, test := range []struct {
+		stream string
+		want   int
+	}{
+		{`{"codec_type": "video"}`, 0},
+		{`{"codec_type": "video", "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]}`, 90},
+		{`{"codec_type": "video", "side_data_list": [{"side_data_type": "Display Matrix", "rotation": 90}]}`, 270},
+		{`{"codec_type": "video", "side_data_list": [{"rotation": 180}]}`, 180},
+		{`{"codec_type": "video", "tags": {"rotate": "270"}}`, 270},
+		{`{"codec_type": "video", "tags": {"rotate": "-90"}}`, 270},
+	} {
+		var s map[string]interface{}
+		d := json.NewDecoder(strings.NewReader(test.stream))
+		d.UseNumber()
+		if err := d.Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		if got := videoRotation(&ffprobe.Info{Streams: []map[string]interface{}{s}}); got != test.want {
+			t.Errorf("%s: got %d", test.stream, got)
+		}
+	}
+	if got := videoRotation(nil); got != 0 {
+		t.Errorf("got %d", got)
+	}
+}
+
+func TestUprightResolution(t *testing.T) {
+	if r := uprightResolution("1920x1080", 90); r != "1080x1920" {
+		t.Errorf("got %q", r)
+	}
+	if r := uprightResolution("1920x1080", 180); r != "1920x1080" {
+		t.Errorf("got %q", r)
+	}
+	if r := uprightResolution("", 270); r != "" {
+		t.Errorf("got %q", r)
+	}
+}
@@ -0,0 +1,374 @@
+package dms
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Returns search index matches as JSON, for web players and other tools.
+const searchAPIPath = "/api/search"
+
+// The properties CDS Search criteria can use.
+const searchCapabilities = "dc:title,dc:creator,dc:date,dc:description,upnp:class,upnp:artist,upnp:album,upnp:genre,upnp:actor,upnp:director,upnp:channelName"
+
+// Tags and the properties they're indexed under, in order of preference.
+var searchTagProperties = []struct {
+	tag        string
+	properties []string
+}{
+	{"title", []string{"dc:title"}},
+	{"artist", []string{"upnp:artist", "dc:creator"}},
+	{"album_artist", []string{"upnp:artist"}},
+	{"album", []string{"upnp:album"}},
+	{"genre", []string{"upnp:genre"}},
+	{"composer", []string{"dc:creator"}},
+	{"date", []string{"dc:date"}},
+	{"year", []string{"dc:date"}},
+	// When videos were recorded.
+	{"creation_time", []string{"dc:date"}},
+	{"track", []string{"upnp:originalTrackNumber"}},
+}
+
+// Tags that can hold several values, like "Rock; Pop".
+var multiValueTags = map[string]bool{
+	"artist":       true,
+	"album_artist": true,
+	"genre":        true,
+	"composer":     true,
+}
+
+// Matches the year, and any month and day, at the start of a date.
+var dateRegexp = regexp.MustCompile(`^(\d{4})(?:[-/.](\d{1,2})(?:[-/.](\d{1,2}))?)?`)
+
+// Returns a date from tags or NFO files as YYYY-MM-DD, so that dates compare
+// in order, as for decades. Missing months and days are taken as the first.
+func normalizeDate(s string) (string, bool) {
+	m := dateRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return "", false
+	}
+	part := func(s string) int {
+		n, err := strconv.Atoi(s)
+		if err != nil || n == 0 {
+			return 1
+		}
+		return n
+	}
+	return fmt.Sprintf("%s-%02d-%02d", m[1], part(m[2]), part(m[3])), true
+}
+
+// Splits a tag holding several values.
+func splitTagValues(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ';' || r == 0
+	})
+}
+
+// Kodi style metadata for a movie, episode or show.
+type nfo struct {
+	Title         string `xml:"title"`
+	OriginalTitle string `xml:"originaltitle"`
+	ShowTitle     string `xml:"showtitle"`
+	Plot          string `xml:"plot"`
+	Year          string `xml:"year"`
+	Premiered     string `xml:"premiered"`
+	// When an episode was first shown, and its number in the season.
+	Aired   string `xml:"aired"`
+	Episode string `xml:"episode"`
+	// The parental rating, such as "Rated PG-13" or "DE:FSK 12".
+	MPAA      string   `xml:"mpaa"`
+	Genres    []string `xml:"genre"`
+	Tags      []string `xml:"tag"`
+	Directors []string `xml:"director"`
+	Actors    []struct {
+		Name string `xml:"name"`
+	} `xml:"actor"`
+}
+
+// Returns the NFO file describing a video: one with the same name, or
+// movie.nfo in the same folder.
+func (me *Server) nfoPath(filePath string, mt mimeType) (string, fs.FileInfo, bool) {
+	if !mt.IsVideo() {
+		return "", nil, false
+	}
+	for _, p := range []string{
+		strings.TrimSuffix(filePath, path.Ext(filePath)) + ".nfo",
+		path.Join(path.Dir(filePath), "movie.nfo"),
+	} {
+		if fi, err := fs.Stat(me.FS, p); err == nil {
+			return p, fi, true
+		}
+	}
+	return "", nil, false
+}
+
+func (me *Server) readNFO(nfoPath string) (ret nfo, err error) {
+	b, err := fs.ReadFile(me.FS, nfoPath)
+	if err != nil {
+		return
+	}
+	err = xml.Unmarshal(b, &ret)
+	return
+}
+
+// Returns the search document for a media file, from its name, tags and, for
+// videos, any NFO file.
+func (me *Server) searchDocument(filePath string, fi fs.FileInfo, mt mimeType, ffInfo *ffprobe.Info) search.Document {
+	doc := search.Document{
+		ID:      filePath,
+		ModTime: fi.ModTime(),
+		Fields: map[string][]string{
+			// The title in browse results.
+			"dc:title":   {fi.Name()},
+			"upnp:class": {"object.item." + mt.Type() + "Item"},
+		},
+	}
+	add := func(property string, values ...string) {
+		for _, v := range values {
+			if v = strings.TrimSpace(v); v != "" {
+				doc.Fields[property] = append(doc.Fields[property], v)
+			}
+		}
+	}
+	if m, ok := me.photoMetadata(filePath, mt); ok {
+		if m.HasGPS {
+			doc.Location = &search.Location{Lat: m.Lat, Lon: m.Lon}
+		}
+		if !m.Time.IsZero() {
+			add("dc:date", m.Time.Format("2006-01-02"))
+		}
+		add("dc:creator", m.Camera())
+		if m.Width > 0 && m.Height > 0 {
+			add("res@resolution", fmt.Sprintf("%dx%d", m.Width, m.Height))
+		}
+	}
+	addDate := func(values ...string) {
+		for _, v := range values {
+			if d, ok := normalizeDate(v); ok {
+				add("dc:date", d)
+			}
+		}
+	}
+	addTrackNumber := func(values ...string) {
+		for _, v := range values {
+			// Tags can be like "3/12".
+			if n, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(v, "/", 2)[0])); err == nil && n > 0 {
+				add("upnp:originalTrackNumber", strconv.Itoa(n))
+			}
+		}
+	}
+	if mt.IsVideo() {
+		// TV recordings are titled by the programme, rather than their
+		// file names, which are often just the channel and time.
+		if p, ok := me.recordedProgramme(filePath, ffInfo); ok {
+			doc.Fields["upnp:class"] = []string{broadcastClass}
+			add("dc:title", p.Title)
+			add("dc:description", p.Description)
+			add("upnp:channelName", p.Channel)
+			if !p.Start.IsZero() {
+				add("dc:date", p.Start.Local().Format("2006-01-02"))
+				add("upnp:scheduledStartTime", p.Start.Local().Format(srsDateTimeLayout))
+			}
+			if p.Duration != 0 {
+				add("upnp:scheduledEndTime", p.End().Local().Format(srsDateTimeLayout))
+			}
+		}
+	}
+	for _, tp := range searchTagProperties {
+		v, ok := audioTag(ffInfo, tp.tag)
+		if !ok {
+			continue
+		}
+		values := []string{v}
+		if multiValueTags[tp.tag] {
+			values = splitTagValues(v)
+		}
+		for _, p := range tp.properties {
+			switch p {
+			case "dc:date":
+				addDate(values...)
+			case "upnp:originalTrackNumber":
+				addTrackNumber(values...)
+			default:
+				add(p, values...)
+			}
+		}
+	}
+	if p, nfi, ok := me.nfoPath(filePath, mt); ok {
+		if nfi.ModTime().After(doc.ModTime) {
+			doc.ModTime = nfi.ModTime()
+		}
+		n, err := me.readNFO(p)
+		if err != nil {
+			me.Logger.Levelf(log.Debug, "reading %q: %v", p, err)
+		}
+		add("dc:title", n.Title, n.OriginalTitle, n.ShowTitle)
+		add("dc:description", n.Plot)
+		addDate(n.Premiered, n.Aired, n.Year)
+		addTrackNumber(n.Episode)
+		add("upnp:genre", n.Genres...)
+		add("upnp:genre", n.Tags...)
+		add("upnp:director", n.Directors...)
+		for _, a := range n.Actors {
+			add("upnp:actor", a.Name)
+		}
+	}
+	return doc
+}
+
+// Sets the artist, album, genre, date and track number of a media item from
+// its search document, and what's known of the broadcast of TV recordings.
+func setObjectMetadata(obj *upnpav.Object, doc search.Document) {
+	first := func(property string) string {
+		if values := doc.Fields[property]; len(values) != 0 {
+			return values[0]
+		}
+		return ""
+	}
+	if first("upnp:class") == broadcastClass {
+		obj.Class = broadcastClass
+		// The programme's title follows the file name.
+		if titles := doc.Fields["dc:title"]; len(titles) > 1 {
+			obj.Title = titles[1]
+		}
+		obj.ChannelName = first("upnp:channelName")
+		obj.ScheduledStartTime = first("upnp:scheduledStartTime")
+		obj.ScheduledEndTime = first("upnp:scheduledEndTime")
+	}
+	obj.Description = first("dc:description")
+	obj.Artist = first("upnp:artist")
+	obj.Album = first("upnp:album")
+	obj.Genre = first("upnp:genre")
+	obj.Creator = first("dc:creator")
+	if t, err := time.Parse("2006-01-02", first("dc:date")); err == nil {
+		obj.Date = upnpav.Timestamp{Time: t}
+	}
+	obj.OriginalTrackNumber, _ = strconv.Atoi(first("upnp:originalTrackNumber"))
+}
+
+// Brings the search index up to date with the library, indexing files that
+// are new or changed, and dropping those that have gone. The LibraryStats are
+// counted along the way.
+func (me *Server) indexLibrary() {
+	seen := make(map[string]struct{})
+	stats := newLibraryStats()
+	facts := make(map[string]mediaFacts)
+	err := fs.WalkDir(me.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			me.Logger.Levelf(log.Debug, "indexing %q: %v", p, err)
+			return nil
+		}
+		select {
+		case <-me.closed:
+			return fs.SkipAll
+		default:
+		}
+		if ignored, _ := me.IgnorePath(p); ignored {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		mt, err := me.mimeTypeByPath(p)
+		if err != nil || !mt.IsMedia() {
+			return nil
+		}
+		fi, err := fs.Stat(me.FS, p)
+		if err != nil {
+			return nil
+		}
+		seen[p] = struct{}{}
+		modTime := fi.ModTime()
+		if _, nfi, ok := me.nfoPath(p, mt); ok && nfi.ModTime().After(modTime) {
+			modTime = nfi.ModTime()
+		}
+		var ffInfo *ffprobe.Info
+		probed := false
+		probe := func() *ffprobe.Info {
+			if !probed && !me.NoProbe && !mt.IsImage() {
+				ffInfo, _ = me.ffmpegProbe(me.closedContext(), p)
+			}
+			probed = true
+			return ffInfo
+		}
+		f, ok := me.mediaFacts[p]
+		if !ok || !f.modTime.Equal(fi.ModTime()) {
+			f = newMediaFacts(fi.ModTime(), mt, probe())
+		}
+		facts[p] = f
+		stats.add(me.FS, p, fi, mt, f)
+		if doc, ok := me.Search.Get(p); ok && doc.ModTime.Equal(modTime) {
+			return nil
+		}
+		me.Search.Update(me.searchDocument(p, fi, mt, probe()))
+		return nil
+	})
+	if err != nil {
+		me.Logger.Levelf(log.Warning, "indexing library: %v", err)
+		return
+	}
+	select {
+	case <-me.closed:
+		// The walk was cut short.
+		return
+	default:
+	}
+	for _, id := range me.Search.IDs() {
+		if _, ok := seen[id]; !ok {
+			me.Search.Remove(id)
+		}
+	}
+	me.mediaFacts = facts
+	me.setLibraryStats(stats)
+	me.updatePlaces(me.closedContext())
+}
+
+// Returns the search index matches for the q query parameter, as a JSON list
+// of paths, titles and classes.
+func (me *Server) serveSearchAPI(w http.ResponseWriter, r *http.Request) {
+	if me.Search == nil {
+		http.Error(w, "search disabled", http.StatusNotFound)
+		return
+	}
+	type result struct {
+		Path  string `json:"path"`
+		Title string `json:"title"`
+		Class string `json:"class"`
+	}
+	results := []result{}
+	client := playbackClient(r)
+	for _, id := range me.Search.Query(r.URL.Query().Get("q")) {
+		doc, ok := me.Search.Get(id)
+		if !ok || me.hiddenFrom(client, id) {
+			continue
+		}
+		res := result{Path: "/" + id}
+		if titles := doc.Fields["dc:title"]; len(titles) != 0 {
+			// Prefer a title from the tags or NFO to the file name.
+			res.Title = titles[min(1, len(titles)-1)]
+		}
+		if classes := doc.Fields["upnp:class"]; len(classes) != 0 {
+			res.Class = classes[0]
+		}
+		results = append(results, res)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
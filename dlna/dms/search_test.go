@@ -0,0 +1,184 @@
+package dms
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestIndexLibrary(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Films/Heat (1995)/Heat.mkv": {},
+		"Films/Heat (1995)/movie.nfo": {Data: []byte(`<movie>
+	<title>Heat</title>
+	<genre>Crime</genre>
+	<actor><name>Al Pacino</name></actor>
+	<actor><name>Robert De Niro</name></actor>
+</movie>`)},
+		"Films/notes.txt":   {},
+		"Music/Hang Up.mp3": {},
+	}
+	s := &Server{
+		FS:             fsys,
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Search:         &search.Index{},
+	}
+	s.indexLibrary()
+	if a := s.Search.Query("de niro"); !slices.Equal(a, []string{"Films/Heat (1995)/Heat.mkv"}) {
+		t.Fatalf("unexpected results %q", a)
+	}
+	if ids := s.Search.IDs(); len(ids) != 2 {
+		t.Fatalf("expected 2 documents but got %q", ids)
+	}
+	cdService := &contentDirectoryService{Server: s}
+	objs, total, err := cdService.search(context.Background(), cds.SearchArgs{
+		ContainerID:    "Films",
+		SearchCriteria: `upnp:class derivedfrom "object.item.videoItem" and upnp:actor contains "pacino"`,
+	}, "localhost", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(objs) != 1 || objs[0].(upnpav.Item).ID != "Films%2FHeat+%281995%29%2FHeat.mkv" {
+		t.Fatalf("unexpected results %d %+v", total, objs)
+	}
+	if _, total, _ := cdService.search(context.Background(), cds.SearchArgs{ContainerID: "Music", SearchCriteria: `dc:title contains "heat"`}, "localhost", "", ""); total != 0 {
+		t.Fatalf("matched outside the container")
+	}
+	if _, _, err := cdService.search(context.Background(), cds.SearchArgs{ContainerID: "0", SearchCriteria: `dc:title contains`}, "localhost", "", ""); err == nil {
+		t.Fatal("expected error for bad criteria")
+	}
+	// Changes to the NFO are picked up, and removed files dropped.
+	fsys["Films/Heat (1995)/movie.nfo"] = &fstest.MapFile{
+		Data:    []byte(`<movie><title>Heat</title><genre>Thriller</genre></movie>`),
+		ModTime: time.Now(),
+	}
+	delete(fsys, "Music/Hang Up.mp3")
+	s.indexLibrary()
+	if a := s.Search.Query("thriller"); len(a) != 1 {
+		t.Fatalf("NFO change not indexed: %q", a)
+	}
+	if ids := s.Search.IDs(); len(ids) != 1 {
+		t.Fatalf("removed file still indexed: %q", ids)
+	}
+}
+
+func TestNormalizeDate(t *testing.T) {
+	for s, e := range map[string]string{
+		"1995":                 "1995-01-01",
+		"1995-03":              "1995-03-01",
+		"1995/3/7":             "1995-03-07",
+		"2001-05-12T00:00:00Z": "2001-05-12",
+		"March 1995":           "",
+	} {
+		if a, _ := normalizeDate(s); a != e {
+			t.Errorf("expected %q for %q but got %q", e, s, a)
+		}
+	}
+}
+
+func TestTagSearch(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Music/a.flac": {},
+			"Music/b.flac": {},
+			"Music/c.flac": {},
+		},
+		RootObjectPath: ".",
+		FFProbeCache:   mapCache{},
+		NoTranscode:    true,
+		Search:         &search.Index{},
+	}
+	for p, tags := range map[string]map[string]interface{}{
+		"Music/a.flac": {"ARTIST": "Blondie", "ALBUM": "Parallel Lines", "GENRE": "Rock; New Wave", "DATE": "1978"},
+		"Music/b.flac": {"ARTIST": "Madonna", "ALBUM": "Like a Prayer", "GENRE": "Pop", "DATE": "1989-03-21"},
+		"Music/c.flac": {"ARTIST": "Portishead", "ALBUM": "Dummy", "GENRE": "Trip Hop", "DATE": "1994"},
+	} {
+		s.FFProbeCache.Set(ffmpegInfoCacheKey{p, time.Time{}.UnixNano()}, &ffprobe.Info{
+			Format: map[string]interface{}{"tags": tags},
+		})
+	}
+	s.indexLibrary()
+	cdService := &contentDirectoryService{Server: s}
+	for c, e := range map[string][]string{
+		`upnp:genre = "new wave"`:                            {"Music%2Fa.flac"},
+		`dc:date >= "1980" and dc:date < "1990"`:             {"Music%2Fb.flac"},
+		`upnp:artist = "portishead" or upnp:album = "dummy"`: {"Music%2Fc.flac"},
+		`upnp:genre exists true and dc:date < "1990"`:        {"Music%2Fa.flac", "Music%2Fb.flac"},
+	} {
+		objs, _, err := cdService.search(context.Background(), cds.SearchArgs{ContainerID: "0", SearchCriteria: c}, "localhost", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a []string
+		for _, obj := range objs {
+			a = append(a, obj.(upnpav.Item).ID)
+		}
+		if !slices.Equal(a, e) {
+			t.Errorf("%s: expected %q but got %q", c, e, a)
+		}
+	}
+	objs, _, _ := cdService.search(context.Background(), cds.SearchArgs{ContainerID: "0", SearchCriteria: `upnp:artist = "Madonna"`}, "localhost", "", "")
+	if o := objs[0].(upnpav.Item).Object; o.Genre != "Pop" || o.Album != "Like a Prayer" || o.Date.Format("2006-01-02") != "1989-03-21" {
+		t.Fatalf("unexpected item metadata %+v", o)
+	}
+}
+
+func TestObjectMetadata(t *testing.T) {
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := &Server{
+		FS: fstest.MapFS{
+			"Music/Dummy/03 Sour Times.flac": {},
+			"Shows/S01E04.mkv":               {},
+			"Shows/S01E04.nfo": {Data: []byte(`<episodedetails>
+	<title>Pilot</title>
+	<genre>Drama</genre>
+	<episode>4</episode>
+	<aired>2008-02-10</aired>
+</episodedetails>`)},
+			"Clips/untagged.mkv": {ModTime: modTime},
+		},
+		RootObjectPath: ".",
+		FFProbeCache:   mapCache{},
+		NoTranscode:    true,
+	}
+	s.FFProbeCache.Set(ffmpegInfoCacheKey{"Music/Dummy/03 Sour Times.flac", time.Time{}.UnixNano()}, &ffprobe.Info{
+		Format: map[string]interface{}{"tags": map[string]interface{}{"GENRE": "Trip Hop", "YEAR": "1994", "TRACK": "3/11"}},
+	})
+	for _, p := range []string{"Shows/S01E04.mkv", "Clips/untagged.mkv"} {
+		s.FFProbeCache.Set(ffmpegInfoCacheKey{p, s.FS.(fstest.MapFS)[p].ModTime.UnixNano()}, &ffprobe.Info{})
+	}
+	cdService := &contentDirectoryService{Server: s}
+	for p, want := range map[string]struct {
+		date  string
+		track int
+		genre string
+	}{
+		"Music/Dummy/03 Sour Times.flac": {"1994-01-01", 3, "Trip Hop"},
+		"Shows/S01E04.mkv":               {"2008-02-10", 4, "Drama"},
+		"Clips/untagged.mkv":             {"2021-06-01", 0, ""},
+	} {
+		fi, err := fs.Stat(s.FS, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := cdService.cdsObjectToUpnpavObject(context.Background(), object{p, "."}, fi, "localhost", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := obj.(upnpav.Item).Object
+		if o.Date.Format("2006-01-02") != want.date || o.OriginalTrackNumber != want.track || o.Genre != want.genre {
+			t.Errorf("%s: got date %v, track %d, genre %q", p, o.Date, o.OriginalTrackNumber, o.Genre)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package dms
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/dlna/dms/resource"
+	"github.com/anacrolix/dms/misc"
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const (
+	// Streams the photos of a folder as a video.
+	slideshowPath = "/slideshow"
+	// Prefix of the ObjectIDs of slideshow items, followed by the ObjectID
+	// of the folder.
+	slideshowIDPrefix = virtualIDPrefix + "slideshow:"
+	// How long each photo is shown if Server.SlideshowDuration isn't set.
+	defaultSlideshowDuration = 5 * time.Second
+)
+
+type slideshowFormat struct {
+	// The ffmpeg format.
+	format   string
+	mimeType string
+}
+
+// The containers a slideshow can be streamed in, by the format query
+// parameter, and their MIME types. The first is the default.
+var slideshowFormats = []slideshowFormat{
+	{"mp4", "video/mp4"},
+	{"mpegts", "video/mp2t"},
+}
+
+func (me *Server) slideshowDuration() time.Duration {
+	if me.SlideshowDuration > 0 {
+		return me.SlideshowDuration
+	}
+	return defaultSlideshowDuration
+}
+
+// Returns the photos directly in the folder that the client may see, in name
+// order, and the first audio file, if any, to play under them.
+func (me *Server) slideshowFiles(filePath, client string) (images []string, music string, err error) {
+	entries, err := fs.ReadDir(me.FS, filePath)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		p := path.Join(filePath, e.Name())
+		if ignored, _ := me.IgnorePath(p); ignored || me.hiddenFrom(client, p) {
+			continue
+		}
+		mt, err := me.mimeTypeByPath(p)
+		if err != nil {
+			continue
+		}
+		switch {
+		case mt.IsImage():
+			images = append(images, p)
+		case mt.IsAudio() && !mt.IsDSD() && music == "":
+			music = p
+		}
+	}
+	return
+}
+
+// Returns the music for a slideshow: SlideshowMusic if it's set, otherwise
+// the audio file found with the photos.
+func (me *Server) slideshowMusic(found, client string) string {
+	if me.SlideshowMusic == "" {
+		return found
+	}
+	p := me.filePath(me.SlideshowMusic)
+	if me.hiddenFrom(client, p) {
+		return ""
+	}
+	return p
+}
+
+// Serves the photos of the folder given by the path query parameter as a
+// video, each shown for SlideshowDuration, or the duration query parameter
+// in seconds. The format query parameter picks the container, one of
+// slideshowFormats.
+func (me *Server) serveSlideshow(w http.ResponseWriter, r *http.Request) {
+	if me.NoTranscode {
+		http.Error(w, "transcodes disabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	format := slideshowFormats[0]
+	if f := q.Get("format"); f != "" {
+		i := slices.IndexFunc(slideshowFormats, func(sf slideshowFormat) bool {
+			return sf.format == f
+		})
+		if i == -1 {
+			http.Error(w, fmt.Sprintf("unknown format %q", f), http.StatusBadRequest)
+			return
+		}
+		format = slideshowFormats[i]
+	}
+	opts := transcode.SlideshowOptions{Duration: me.slideshowDuration()}
+	if d := q.Get("duration"); d != "" {
+		secs, err := strconv.ParseFloat(d, 64)
+		if err != nil || secs <= 0 {
+			http.Error(w, fmt.Sprintf("bad duration %q", d), http.StatusBadRequest)
+			return
+		}
+		opts.Duration = time.Duration(secs * float64(time.Second))
+	}
+	filePath := me.filePath(q.Get("path"))
+	client := playbackClient(r)
+	if ignored, _ := me.IgnorePath(filePath); ignored || me.hiddenFrom(client, filePath) {
+		http.Error(w, "no such folder", http.StatusNotFound)
+		return
+	}
+	images, music, err := me.slideshowFiles(filePath, client)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if len(images) == 0 {
+		http.Error(w, "no photos in folder", http.StatusNotFound)
+		return
+	}
+	w.Header().Set(dlna.TransferModeDomain, "Streaming")
+	w.Header().Set("content-type", format.mimeType)
+	w.Header().Set(dlna.ContentFeaturesDomain, (dlna.ContentFeatures{
+		Transcoded:      true,
+		SupportTimeSeek: true,
+		Flags:           me.dlnaFlags(r.UserAgent(), TranscodeResource),
+	}).String())
+	range_, partialResponse, ok := resource.HandleTimeSeekRange(w, r.Header, false)
+	if !ok {
+		return
+	}
+	s := fmt.Sprintf("%f", (time.Duration(len(images)) * opts.Duration).Seconds())
+	w.Header().Set("content-duration", s)
+	w.Header().Set("x-content-duration", s)
+	if r.Method == "HEAD" {
+		resource.WriteResponseCode(w, partialResponse)
+		return
+	}
+	defer me.trackConnection(r, fmt.Sprintf("http-get:*:%s:%s", format.mimeType, w.Header().Get(dlna.ContentFeaturesDomain)))()
+	if music = me.slideshowMusic(music, client); music != "" {
+		opts.Music = me.loopbackResURL(music)
+	}
+	urls := make([]string, 0, len(images))
+	for _, p := range images {
+		urls = append(urls, me.loopbackResURL(p))
+	}
+	started := time.Now()
+	var logFile io.Writer
+	aLogFile, logPath, err := me.createTranscodeLog(filepath.Join("slideshow", filepath.Base(filePath)), started)
+	if err != nil {
+		log.Printf("couldn't create transcode log file: %s", err)
+	} else if aLogFile != nil {
+		defer aLogFile.Close()
+		logFile = aLogFile
+	}
+	session := me.startTranscodeSession(r, filePath, "slideshow", started, logPath)
+	p, err := transcode.Slideshow(r.Context(), urls, format.format, range_.Start, opts, logFile)
+	if err != nil {
+		me.endTranscodeSession(session, nil, err)
+		me.Logger.Levelf(log.Warning, "slideshow of %q: %v", filePath, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		p.Close()
+		me.endTranscodeSession(session, p, nil)
+	}()
+	resource.WriteResponseCode(w, partialResponse)
+	io.Copy(session.writer(w), p)
+}
+
+// Returns the slideshow item listed first in the folder, if it has at least
+// two photos the client may see, and nil otherwise.
+func (me *contentDirectoryService) slideshowItem(folder object, host, userAgent, client string) *upnpav.Item {
+	if me.NoTranscode {
+		return nil
+	}
+	images, _, err := me.slideshowFiles(folder.FilePath(), client)
+	if err != nil || len(images) < 2 {
+		return nil
+	}
+	duration := misc.FormatDurationSexagesimal(time.Duration(len(images)) * me.slideshowDuration())
+	item := upnpav.Item{
+		Object: upnpav.Object{
+			ID:         slideshowIDPrefix + folder.ID(),
+			ParentID:   folder.ID(),
+			Restricted: 1,
+			Class:      "object.item.videoItem",
+			Title:      translate(me.language(), "Slideshow"),
+		},
+	}
+	for _, f := range slideshowFormats {
+		item.Res = append(item.Res, upnpav.Resource{
+			URL: (&url.URL{
+				Scheme: me.urlScheme(host),
+				Host:   host,
+				Path:   slideshowPath,
+				RawQuery: url.Values{
+					"path":   {folder.Path},
+					"format": {f.format},
+				}.Encode(),
+			}).String(),
+			ProtocolInfo: dlna.HTTPProtocolInfo(f.mimeType, dlna.ContentFeatures{
+				SupportTimeSeek: true,
+				Transcoded:      true,
+				Flags:           me.dlnaFlags(userAgent, TranscodeResource),
+			}),
+			Duration: duration,
+		})
+	}
+	return &item
+}
+
+// Returns the slideshow item with the ObjectID, and whether the ID is one.
+func (me *contentDirectoryService) slideshowObject(id string, host, userAgent, client string) (*upnpav.Item, bool) {
+	folderID, ok := strings.CutPrefix(id, slideshowIDPrefix)
+	if !ok {
+		return nil, false
+	}
+	folder, err := me.objectFromID(folderID)
+	if err != nil || me.hiddenFrom(client, folder.FilePath()) {
+		return nil, true
+	}
+	return me.slideshowItem(folder, host, userAgent, client), true
+}
@@ -0,0 +1,95 @@
+package dms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestSlideshow(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Photos/b.jpg":      {},
+			"Photos/a.png":      {},
+			"Photos/c.jpg":      {},
+			"Photos/theme.mp3":  {},
+			"Photos/Sub/d.jpg":  {},
+			"Photos/Sub/e.jpg":  {},
+			"Music/only.jpg":    {},
+			"Music/01 Song.mp3": {},
+		},
+		RootObjectPath:    ".",
+		NoProbe:           true,
+		Logger:            log.Default,
+		HiddenPaths:       &HiddenPaths{},
+		Language:          "de",
+		SlideshowDuration: 4 * time.Second,
+	}
+	cds := &contentDirectoryService{Server: s}
+	images, music, err := s.slideshowFiles("Photos", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(images, ",") != "Photos/a.png,Photos/b.jpg,Photos/c.jpg" || music != "Photos/theme.mp3" {
+		t.Fatalf("got %q and %q", images, music)
+	}
+	objs, err := cds.readContainer(context.Background(), object{"Photos", "."}, "localhost", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, ok := objs[0].(upnpav.Item)
+	if !ok || item.ID != "dms:slideshow:Photos" || item.ParentID != "Photos" || item.Title != "Diashow" {
+		t.Fatalf("got %#v", objs[0])
+	}
+	if len(item.Res) != 2 || item.Res[0].Duration != "0:00:12" || !strings.Contains(item.Res[1].URL, "/slideshow?format=mpegts&path=Photos") {
+		t.Fatalf("got %#v", item.Res)
+	}
+	if got, ok := cds.slideshowObject("dms:slideshow:Photos", "localhost", "", ""); !ok || got == nil || got.ID != item.ID {
+		t.Fatalf("got %v, %v", got, ok)
+	}
+	// A single photo isn't a slideshow.
+	objs, err = cds.readContainer(context.Background(), object{"Music", "."}, "localhost", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		if item, ok := obj.(upnpav.Item); ok && strings.HasPrefix(item.ID, slideshowIDPrefix) {
+			t.Fatalf("got slideshow in %v", objs)
+		}
+	}
+	s.HiddenPaths.add("Photos/Sub")
+	if got, ok := cds.slideshowObject("dms:slideshow:Photos%2FSub", "localhost", "", ""); !ok || got != nil {
+		t.Fatalf("got %v, %v for hidden folder", got, ok)
+	}
+
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	for _, c := range []struct {
+		query string
+		code  int
+	}{
+		{"path=Photos%2FSub", http.StatusNotFound},
+		{"path=Music%2FNone", http.StatusNotFound},
+		{"path=Photos&format=avi", http.StatusBadRequest},
+		{"path=Photos&duration=-1", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", slideshowPath+"?"+c.query, nil))
+		if w.Code != c.code {
+			t.Errorf("%s: got %d: %s", c.query, w.Code, w.Body)
+		}
+	}
+
+	s.NoTranscode = true
+	if item := cds.slideshowItem(object{"Photos", "."}, "localhost", "", ""); item != nil {
+		t.Fatalf("got slideshow with transcoding disabled")
+	}
+}
@@ -0,0 +1,77 @@
+package dms
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/dms/upnp"
+)
+
+// Browse responses for large folders are mostly XML, so SOAP responses are
+// built in pooled buffers, without the intermediate copies of formatting them
+// in stages.
+
+// Larger buffers aren't kept for reuse, so one huge folder doesn't pin memory.
+const maxPooledBufferSize = 4 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+}
+
+const (
+	soapEnvelopeStart = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body>`
+	soapEnvelopeEnd = `</s:Body></s:Envelope>`
+)
+
+// Escapes SOAP response argument values as xml.EscapeText does, except for
+// double quotes. Samsung Frame TVs don't display an empty content directory
+// if they're escaped.
+var soapTextEscaper = strings.NewReplacer(
+	`&`, `&amp;`,
+	`<`, `&lt;`,
+	`>`, `&gt;`,
+	`'`, `&#39;`,
+	"\t", `&#x9;`,
+	"\n", `&#xA;`,
+	"\r", `&#xD;`,
+)
+
+// Writes the response element for a SOAP action with the arguments.
+func writeSOAPResponse(buf *bytes.Buffer, sa upnp.SoapAction, args [][2]string) {
+	buf.WriteString(`<u:`)
+	buf.WriteString(sa.Action)
+	buf.WriteString(`Response xmlns:u="`)
+	buf.WriteString(sa.ServiceURN.String())
+	buf.WriteString(`">`)
+	for i, arg := range args {
+		if i != 0 {
+			// As xml.MarshalIndent separated them.
+			buf.WriteByte('\n')
+		}
+		buf.WriteByte('<')
+		buf.WriteString(arg[0])
+		buf.WriteByte('>')
+		soapTextEscaper.WriteString(buf, arg[1])
+		buf.WriteString(`</`)
+		buf.WriteString(arg[0])
+		buf.WriteByte('>')
+	}
+	buf.WriteString(`</u:`)
+	buf.WriteString(sa.Action)
+	buf.WriteString(`Response>`)
+}
@@ -0,0 +1,123 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/soap"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func testBrowseObjects(n int) (ret []interface{}) {
+	for i := range n {
+		id := strconv.Itoa(i)
+		ret = append(ret, upnpav.Item{
+			Object: upnpav.Object{
+				ID:         "Films%2F" + id + ".mkv",
+				ParentID:   "Films",
+				Restricted: 1,
+				Class:      "object.item.videoItem",
+				Title:      `"Tom & Jerry's" <` + id + `>`,
+			},
+			Res: []upnpav.Resource{{
+				URL:          "http://localhost:1338/res?path=%2FFilms%2F" + id + ".mkv",
+				ProtocolInfo: "http-get:*:video/x-matroska:DLNA.ORG_OP=01;DLNA.ORG_CI=0",
+				Size:         1 << 30,
+				Duration:     "1:30:00.000",
+			}},
+		})
+	}
+	return
+}
+
+// The response is as it was when built with xml.Marshal and fmt.
+func TestWriteSOAPResponse(t *testing.T) {
+	sa, err := upnp.ParseActionHTTPHeader(`"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := testBrowseObjects(3)
+	didl, err := cds.MarshalDIDL(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := [][2]string{
+		{"Result", didl + "\t\r\n"},
+		{"NumberReturned", "3"},
+		{"Empty", ""},
+	}
+	var buf bytes.Buffer
+	writeSOAPResponse(&buf, sa, args)
+
+	var soapArgs []soap.Arg
+	for _, arg := range args {
+		soapArgs = append(soapArgs, soap.Arg{XMLName: xml.Name{Local: arg[0]}, Value: arg[1]})
+	}
+	want := fmt.Sprintf(`<u:%[1]sResponse xmlns:u="%[2]s">%[3]s</u:%[1]sResponse>`, sa.Action, sa.ServiceURN.String(), xmlMarshalOrPanic(soapArgs))
+	want = string(bytes.ReplaceAll([]byte(want), []byte("&#34;"), []byte(`"`)))
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func BenchmarkBrowseResponse(b *testing.B) {
+	sa := upnp.SoapAction{Action: "Browse"}
+	objs := testBrowseObjects(1000)
+	b.ReportAllocs()
+	for b.Loop() {
+		didl, err := cds.MarshalDIDL(objs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf := getBuffer()
+		writeSOAPResponse(buf, sa, [][2]string{{"Result", didl}})
+		putBuffer(buf)
+	}
+}
+
+func TestServiceControlMalformedRequests(t *testing.T) {
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	srv := &Server{AllowedIpNets: []*net.IPNet{all}}
+	const browseAction = `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`
+	envelope := func(action string) string {
+		return `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` + action + `</s:Body></s:Envelope>`
+	}
+	for _, tc := range []struct {
+		name, soapAction, body string
+		status                 int
+		upnpCode               uint
+	}{
+		{"unquoted action", "Browse", envelope(`<u:Browse/>`), http.StatusInternalServerError, upnp.InvalidActionErrorCode},
+		{"action mismatch", browseAction, envelope(`<u:Search/>`), http.StatusInternalServerError, upnp.InvalidActionErrorCode},
+		{"malformed", browseAction, envelope(`<u:Browse>`), http.StatusInternalServerError, upnp.InvalidArgsErrorCode},
+		{"dtd", browseAction, `<!DOCTYPE x>` + envelope(`<u:Browse/>`), http.StatusInternalServerError, upnp.InvalidArgsErrorCode},
+		{"too large", browseAction, envelope(`<u:Browse>` + strings.Repeat(" ", soap.MaxRequestSize) + `</u:Browse>`), http.StatusRequestEntityTooLarge, 0},
+	} {
+		r := httptest.NewRequest("POST", serviceControlURL, strings.NewReader(tc.body))
+		r.Header.Set("SOAPACTION", tc.soapAction)
+		w := httptest.NewRecorder()
+		srv.serviceControlHandler(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: got status %d", tc.name, w.Code)
+			continue
+		}
+		if tc.upnpCode == 0 {
+			continue
+		}
+		var fault struct {
+			Code uint `xml:"Body>Fault>detail>UPnPError>errorCode"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &fault); err != nil || fault.Code != tc.upnpCode {
+			t.Errorf("%s: got UPnP error %d, %v: %s", tc.name, fault.Code, err, w.Body)
+		}
+	}
+}
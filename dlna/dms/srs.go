@@ -0,0 +1,336 @@
+package dms
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/dms/dlna/dms/cds"
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// The ScheduledRecording service, offered when there are channels to record.
+var recordingService = &service{
+	Service: upnp.Service{
+		ServiceType: "urn:schemas-upnp-org:service:ScheduledRecording:1",
+		ServiceId:   "urn:upnp-org:serviceId:ScheduledRecording",
+	},
+	SCPD: scheduledRecordingServiceDescription,
+}
+
+const (
+	srsNamespace = "urn:schemas-upnp-org:av:srs"
+	// The only kind of schedule: a one off recording of a channel.
+	recordScheduleClass = "OBJECT.recordSchedule.direct.manual"
+	recordTaskClass     = "OBJECT.recordTask"
+	// Task IDs are their schedule's with this prefix, as each schedule has
+	// the one task.
+	recordTaskIDPrefix = "t"
+	// Date-times are in local time, without a zone, as clients show them.
+	srsDateTimeLayout = "2006-01-02T15:04:05"
+)
+
+// The properties of schedules and tasks, for GetPropertyList.
+var srsProperties = []string{
+	"@id",
+	"title",
+	"class",
+	"scheduledChannelID",
+	"scheduledChannelID@type",
+	"scheduledStartDateTime",
+	"scheduledDuration",
+	"scheduleState",
+	"recordScheduleID",
+	"taskState",
+}
+
+type scheduledRecordingService struct {
+	*Server
+	upnp.Eventing
+}
+
+// An item of an SRS XML document: a record schedule, or a record task.
+type srsItem struct {
+	XMLName                xml.Name `xml:"item"`
+	ID                     string   `xml:"id,attr,omitempty"`
+	Title                  string   `xml:"title"`
+	Class                  string   `xml:"class"`
+	ScheduledChannelID     srsChannelID
+	ScheduledStartDateTime string `xml:"scheduledStartDateTime"`
+	ScheduledDuration      string `xml:"scheduledDuration"`
+	ScheduleState          string `xml:"scheduleState,omitempty"`
+	RecordScheduleID       string `xml:"recordScheduleID,omitempty"`
+	TaskState              string `xml:"taskState,omitempty"`
+}
+
+type srsChannelID struct {
+	XMLName xml.Name `xml:"scheduledChannelID"`
+	Type    string   `xml:"type,attr"`
+	Value   string   `xml:",chardata"`
+}
+
+type srsDocument struct {
+	XMLName xml.Name  `xml:"srs"`
+	XMLNS   string    `xml:"xmlns,attr,omitempty"`
+	Items   []srsItem `xml:"item"`
+}
+
+func marshalSRS(items []srsItem) (string, error) {
+	b, err := xml.Marshal(srsDocument{XMLNS: srsNamespace, Items: items})
+	return string(b), err
+}
+
+// Formats a duration as SRS does, P[nD]hh:mm:ss.
+func formatSRSDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	s := fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	if days != 0 {
+		return fmt.Sprintf("P%dD%s", days, s)
+	}
+	return "P" + s
+}
+
+var srsDurationRegexp = regexp.MustCompile(`^P(?:(\d+)D)?(\d+):(\d{2}):(\d{2})$`)
+
+func parseSRSDuration(s string) (time.Duration, error) {
+	m := srsDurationRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		n, _ := strconv.Atoi(m[i+1])
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// Parses a date-time, taken to be local if it has no zone.
+func parseSRSDateTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(srsDateTimeLayout, s, time.Local)
+}
+
+func recordingSchedule(r Recording) srsItem {
+	state := "OPERATIONAL"
+	if r.State == RecordingDone || r.State == RecordingFailed {
+		state = "COMPLETED"
+	}
+	return srsItem{
+		ID:                     r.ID,
+		Title:                  r.Title,
+		Class:                  recordScheduleClass,
+		ScheduledChannelID:     srsChannelID{Type: "NAME", Value: r.Channel},
+		ScheduledStartDateTime: r.Start.Local().Format(srsDateTimeLayout),
+		ScheduledDuration:      formatSRSDuration(r.Duration),
+		ScheduleState:          state,
+	}
+}
+
+func recordingTask(r Recording) srsItem {
+	item := recordingSchedule(r)
+	item.ID = recordTaskIDPrefix + r.ID
+	item.Class = recordTaskClass
+	item.ScheduleState = ""
+	item.RecordScheduleID = r.ID
+	item.TaskState = map[string]string{
+		RecordingScheduled: "IDLE.READY",
+		RecordingActive:    "ACTIVE.RECORDING",
+		RecordingDone:      "DONE.FULL",
+		RecordingFailed:    "DONE.ERROR",
+	}[r.State]
+	return item
+}
+
+// Reads the record schedule a client asks to create.
+func (me *scheduledRecordingService) parseRecordSchedule(elements string) (r Recording, err error) {
+	var doc srsDocument
+	if err = xml.Unmarshal([]byte(elements), &doc); err != nil {
+		return
+	}
+	if len(doc.Items) != 1 {
+		err = fmt.Errorf("want one item, got %d", len(doc.Items))
+		return
+	}
+	item := doc.Items[0]
+	if item.Class != "" && item.Class != recordScheduleClass {
+		err = fmt.Errorf("unsupported class %q", item.Class)
+		return
+	}
+	r.Channel = item.ScheduledChannelID.Value
+	if _, ok := me.channel(r.Channel); !ok {
+		err = fmt.Errorf("no channel %q", r.Channel)
+		return
+	}
+	if r.Start, err = parseSRSDateTime(item.ScheduledStartDateTime); err != nil {
+		return
+	}
+	if r.Duration, err = parseSRSDuration(item.ScheduledDuration); err != nil {
+		return
+	}
+	if r.Duration <= 0 {
+		err = fmt.Errorf("invalid duration %q", item.ScheduledDuration)
+		return
+	}
+	r.Title = strings.TrimSpace(item.Title)
+	if r.Title == "" {
+		r.Title = r.Channel
+	}
+	return
+}
+
+func (me *scheduledRecordingService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
+	recordings := me.Recordings
+	switch action {
+	case "GetSortCapabilities":
+		return [][2]string{
+			{"SortCaps", "scheduledStartDateTime"},
+			{"SortLevelCap", "0"},
+		}, nil
+	case "GetPropertyList":
+		return [][2]string{
+			{"PropertyList", strings.Join(srsProperties, ",")},
+		}, nil
+	case "GetAllowedValues":
+		// Only the channels are restricted.
+		type propertyDef struct {
+			Name          string   `xml:"name,attr"`
+			AllowedValues []string `xml:"allowedValueList>allowedValue"`
+		}
+		info := struct {
+			XMLName     xml.Name    `xml:"propertyInfo"`
+			XMLNS       string      `xml:"xmlns,attr"`
+			PropertyDef propertyDef `xml:"propertyDef"`
+		}{XMLNS: srsNamespace, PropertyDef: propertyDef{Name: "scheduledChannelID"}}
+		for _, c := range me.Channels {
+			info.PropertyDef.AllowedValues = append(info.PropertyDef.AllowedValues, c.Name)
+		}
+		b, err := xml.Marshal(info)
+		if err != nil {
+			return nil, err
+		}
+		return [][2]string{
+			{"PropertyInfo", string(b)},
+		}, nil
+	case "GetStateUpdateID":
+		return [][2]string{
+			{"Id", strconv.FormatUint(uint64(recordings.stateUpdateID()), 10)},
+		}, nil
+	case "BrowseRecordSchedules", "BrowseRecordTasks":
+		var args struct {
+			StartingIndex  int
+			RequestedCount int
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, "%s", err.Error())
+		}
+		updateID := recordings.stateUpdateID()
+		var items []srsItem
+		for _, rec := range recordings.list() {
+			if action == "BrowseRecordSchedules" {
+				items = append(items, recordingSchedule(rec))
+			} else {
+				items = append(items, recordingTask(rec))
+			}
+		}
+		total := len(items)
+		items = cds.Page(items, args.StartingIndex, args.RequestedCount)
+		result, err := marshalSRS(items)
+		if err != nil {
+			return nil, err
+		}
+		return [][2]string{
+			{"Result", result},
+			{"NumberReturned", strconv.Itoa(len(items))},
+			{"TotalMatches", strconv.Itoa(total)},
+			{"UpdateID", strconv.FormatUint(uint64(updateID), 10)},
+		}, nil
+	case "CreateRecordSchedule":
+		var args struct {
+			Elements string
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, "%s", err.Error())
+		}
+		rec, err := me.parseRecordSchedule(args.Elements)
+		if err != nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, "%s", err.Error())
+		}
+		rec = recordings.add(rec)
+		me.Logger.Printf("scheduled recording %q of %q at %v for %v", rec.Title, rec.Channel, rec.Start, rec.Duration)
+		result, err := marshalSRS([]srsItem{recordingSchedule(rec)})
+		if err != nil {
+			return nil, err
+		}
+		return [][2]string{
+			{"RecordScheduleID", rec.ID},
+			{"Result", result},
+			{"UpdateID", strconv.FormatUint(uint64(recordings.stateUpdateID()), 10)},
+		}, nil
+	case "DeleteRecordSchedule":
+		var args struct {
+			RecordScheduleID string
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, "%s", err.Error())
+		}
+		if !recordings.remove(args.RecordScheduleID) {
+			return nil, upnp.Errorf(upnpav.NoSuchRecordScheduleErrorCode, "no such record schedule: %q", args.RecordScheduleID)
+		}
+		return nil, nil
+	case "GetRecordSchedule":
+		var args struct {
+			RecordScheduleID string
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, "%s", err.Error())
+		}
+		rec, ok := recordings.get(args.RecordScheduleID)
+		if !ok {
+			return nil, upnp.Errorf(upnpav.NoSuchRecordScheduleErrorCode, "no such record schedule: %q", args.RecordScheduleID)
+		}
+		result, err := marshalSRS([]srsItem{recordingSchedule(rec)})
+		if err != nil {
+			return nil, err
+		}
+		return [][2]string{
+			{"Result", result},
+			{"UpdateID", strconv.FormatUint(uint64(recordings.stateUpdateID()), 10)},
+		}, nil
+	case "GetRecordTask":
+		var args struct {
+			RecordTaskID string
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.Errorf(upnp.InvalidArgsErrorCode, "%s", err.Error())
+		}
+		id, ok := strings.CutPrefix(args.RecordTaskID, recordTaskIDPrefix)
+		var rec Recording
+		if ok {
+			rec, ok = recordings.get(id)
+		}
+		if !ok {
+			return nil, upnp.Errorf(upnpav.NoSuchRecordTaskErrorCode, "no such record task: %q", args.RecordTaskID)
+		}
+		result, err := marshalSRS([]srsItem{recordingTask(rec)})
+		if err != nil {
+			return nil, err
+		}
+		return [][2]string{
+			{"Result", result},
+			{"UpdateID", strconv.FormatUint(uint64(recordings.stateUpdateID()), 10)},
+		}, nil
+	default:
+		return nil, upnp.InvalidActionError
+	}
+}
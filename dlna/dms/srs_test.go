@@ -0,0 +1,102 @@
+package dms
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestSRSDuration(t *testing.T) {
+	for _, tc := range []struct {
+		d time.Duration
+		s string
+	}{
+		{90 * time.Minute, "P01:30:00"},
+		{26*time.Hour + 5*time.Second, "P1D02:00:05"},
+	} {
+		if s := formatSRSDuration(tc.d); s != tc.s {
+			t.Errorf("%v: got %q", tc.d, s)
+		}
+		if d, err := parseSRSDuration(tc.s); err != nil || d != tc.d {
+			t.Errorf("%q: got %v, %v", tc.s, d, err)
+		}
+	}
+	if _, err := parseSRSDuration("1:30"); err == nil {
+		t.Error("parsed a bad duration")
+	}
+}
+
+func TestScheduledRecordingService(t *testing.T) {
+	s := &Server{
+		Logger:        log.Default,
+		Channels:      []Channel{{"BBC One", "http://tuner/auto/v1"}},
+		RecordingsDir: t.TempDir(),
+		Recordings:    &Recordings{},
+	}
+	if !s.recordingEnabled() || !strings.Contains(strings.Join(s.serviceTypes(), " "), "ScheduledRecording") {
+		t.Fatal("ScheduledRecording not offered")
+	}
+	srs := &scheduledRecordingService{Server: s}
+	handle := func(action, args string) (map[string]string, error) {
+		ret, err := srs.Handle(action, []byte("<u:"+action+">"+args+"</u:"+action+">"), httptest.NewRequest("POST", "/", nil))
+		m := make(map[string]string)
+		for _, arg := range ret {
+			m[arg[0]] = arg[1]
+		}
+		return m, err
+	}
+	escape := func(s string) string {
+		return strings.NewReplacer("<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+	}
+
+	elements := `<srs xmlns="urn:schemas-upnp-org:av:srs"><item><title>News</title><class>OBJECT.recordSchedule.direct.manual</class><scheduledChannelID type="NAME">BBC One</scheduledChannelID><scheduledStartDateTime>2030-01-02T18:00:00</scheduledStartDateTime><scheduledDuration>P00:30:00</scheduledDuration></item></srs>`
+	m, err := handle("CreateRecordSchedule", "<Elements>"+escape(elements)+"</Elements>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := m["RecordScheduleID"]
+	if id == "" || !strings.Contains(m["Result"], "<scheduledDuration>P00:30:00</scheduledDuration>") {
+		t.Fatalf("created %v", m)
+	}
+	r, _ := s.Recordings.get(id)
+	if r.Title != "News" || r.Duration != 30*time.Minute || r.Start.Hour() != 18 {
+		t.Errorf("scheduled %+v", r)
+	}
+
+	m, err = handle("BrowseRecordTasks", "<RecordScheduleID></RecordScheduleID><StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["TotalMatches"] != "1" || !strings.Contains(m["Result"], "<taskState>IDLE.READY</taskState>") || !strings.Contains(m["Result"], `id="t`+id+`"`) {
+		t.Errorf("browsed %v", m)
+	}
+	if m, err = handle("GetRecordTask", "<RecordTaskID>t"+id+"</RecordTaskID>"); err != nil || !strings.Contains(m["Result"], "<title>News</title>") {
+		t.Errorf("got %v, %v", m, err)
+	}
+	if m, err = handle("GetAllowedValues", "<DataTypeID>A_ARG_TYPE_RecordSchedule</DataTypeID>"); err != nil || !strings.Contains(m["PropertyInfo"], "<allowedValue>BBC One</allowedValue>") {
+		t.Errorf("got %v, %v", m, err)
+	}
+
+	for _, bad := range []string{
+		strings.Replace(elements, "BBC One", "ITV", 1),
+		strings.Replace(elements, "P00:30:00", "half an hour", 1),
+		"<srs/>",
+	} {
+		if _, err := handle("CreateRecordSchedule", "<Elements>"+escape(bad)+"</Elements>"); err == nil || err.(*upnp.Error).Code != upnp.InvalidArgsErrorCode {
+			t.Errorf("%s: got %v", bad, err)
+		}
+	}
+
+	if _, err := handle("DeleteRecordSchedule", "<RecordScheduleID>"+id+"</RecordScheduleID>"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handle("GetRecordSchedule", "<RecordScheduleID>"+id+"</RecordScheduleID>"); err == nil || err.(*upnp.Error).Code != upnpav.NoSuchRecordScheduleErrorCode {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package dms
+
+import (
+	"time"
+)
+
+// A snapshot of a Server's state, for monitoring.
+type Stats struct {
+	// Since the server was initialized.
+	Uptime time.Duration
+	// Streams being served.
+	Streams int
+	// Items in the caches, or -1 for caches that can't tell.
+	FFProbeCacheItems   int
+	ThumbnailCacheItems int
+	DIDLCacheItems      int
+	// Documents in the search index, if there is one.
+	SearchDocuments int
+	// Directories in the library snapshot, if there is one.
+	LibraryDirectories int
+	// Transcodes using each of the Encoders, by name.
+	EncoderSessions map[string]int
+	// Transcodes running, and those that have ended added up.
+	RunningTranscodes int
+	Transcodes        TranscodeTotals
+}
+
+// Returns the number of items in a cache, or -1 if it can't tell.
+func cacheLen(c Cache) int {
+	switch c := c.(type) {
+	case interface{ Len() int }:
+		return c.Len()
+	case ListableCache:
+		return len(c.Keys())
+	}
+	return -1
+}
+
+// Returns a snapshot of the server's state. The server must be initialized.
+func (srv *Server) Stats() (ret Stats) {
+	ret.Uptime = time.Since(srv.started)
+	ret.Streams = srv.connections.streams()
+	ret.FFProbeCacheItems = cacheLen(srv.FFProbeCache)
+	ret.ThumbnailCacheItems = cacheLen(srv.ThumbnailCache)
+	ret.DIDLCacheItems = cacheLen(srv.DIDLCache)
+	if srv.Search != nil {
+		ret.SearchDocuments = srv.Search.Len()
+	}
+	if srv.Library != nil {
+		ret.LibraryDirectories = srv.Library.Len()
+	}
+	ret.EncoderSessions = srv.encoderSessionCounts()
+	srv.transcodeAccounting.mu.Lock()
+	ret.RunningTranscodes = len(srv.transcodeAccounting.running)
+	ret.Transcodes = srv.transcodeAccounting.total
+	srv.transcodeAccounting.mu.Unlock()
+	return
+}
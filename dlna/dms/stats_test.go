@@ -0,0 +1,54 @@
+package dms
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/search"
+)
+
+func TestStatsAndShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := &search.Index{}
+	index.Update(search.Document{ID: "a.mp3"})
+	srv := &Server{
+		FS:         fstest.MapFS{"a.mp3": {}},
+		HTTPConn:   l,
+		Interfaces: []net.Interface{},
+		NoProbe:    true,
+		Search:     index,
+		Logger:     log.Default,
+	}
+	if err := srv.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Run()
+	done := srv.trackConnection(httptest.NewRequest("GET", "/res", nil), "http-get:*:audio/mpeg:*")
+	stats := srv.Stats()
+	if stats.Streams != 1 || stats.SearchDocuments != 1 || stats.FFProbeCacheItems != 0 || stats.Uptime < 0 {
+		t.Errorf("got %+v", stats)
+	}
+	done()
+	if stats := srv.Stats(); stats.Streams != 0 {
+		t.Errorf("got %d streams", stats.Streams)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		c.Close()
+		t.Error("still listening")
+	}
+}
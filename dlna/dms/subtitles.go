@@ -0,0 +1,203 @@
+package dms
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/subtitles"
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+const (
+	// Query parameter of subtitle requests picking an embedded track, by its
+	// index among the video's subtitle streams.
+	subtitleIndexQueryKey = "index"
+	// Samsung TVs ask for the subtitles of a video they're fetching with
+	// this, and are told where they are with captionInfoHeader.
+	getCaptionInfoHeader = "getCaptionInfo.sec"
+	captionInfoHeader    = "CaptionInfo.sec"
+)
+
+// Returns the indexes, among the video's subtitle streams, of those that are
+// text, and so can be given to clients as SRT.
+func textSubtitleIndexes(info *ffprobe.Info) (ret []int) {
+	for i, s := range streamsOfType(info, "subtitle") {
+		if !slices.Contains(pictureSubtitleCodecs, streamString(s, "codec_name")) {
+			ret = append(ret, i)
+		}
+	}
+	return
+}
+
+// Returns the URL of the subtitles at the host for the video at the object
+// path.
+func (me *Server) subtitleURL(host, objPath string, index int) string {
+	q := url.Values{"path": {objPath}}
+	if index >= 0 {
+		q.Set(subtitleIndexQueryKey, strconv.Itoa(index))
+	}
+	return (&url.URL{
+		Scheme:   me.urlScheme(host),
+		Host:     host,
+		Path:     subtitlePath,
+		RawQuery: q.Encode(),
+	}).String()
+}
+
+// Returns the resources of the video's embedded text subtitle tracks.
+func (me *Server) embeddedSubtitleResources(host, objPath string, info *ffprobe.Info) (ret []upnpav.Resource) {
+	if me.NoTranscode {
+		return
+	}
+	for _, i := range textSubtitleIndexes(info) {
+		ret = append(ret, upnpav.Resource{
+			URL:          me.subtitleURL(host, objPath, i),
+			ProtocolInfo: "http-get:*:text/srt:*",
+		})
+	}
+	return
+}
+
+// Returns the URL of the subtitles to give clients that take only one, such
+// as Samsung TVs: the video's subtitle file, or else its embedded text track
+// in the first of the client's SubtitleLanguages, or its first.
+func (me *Server) captionURL(host, userAgent, objPath, filePath string, info *ffprobe.Info) (string, bool) {
+	p := me.clientProfile(userAgent)
+	if p != nil && p.NoSubtitles {
+		return "", false
+	}
+	if _, ok := me.subtitleFile(userAgent, filePath); ok {
+		return me.subtitleURL(host, objPath, -1), true
+	}
+	indexes := textSubtitleIndexes(info)
+	if me.NoTranscode || len(indexes) == 0 {
+		return "", false
+	}
+	streams := streamsOfType(info, "subtitle")
+	if p != nil {
+		for _, lang := range p.SubtitleLanguages {
+			for _, i := range indexes {
+				if sameLanguage(lang, streamTag(streams[i], "language")) {
+					return me.subtitleURL(host, objPath, i), true
+				}
+			}
+		}
+	}
+	return me.subtitleURL(host, objPath, indexes[0]), true
+}
+
+// Tells a Samsung TV fetching the video where its subtitles are.
+func (me *Server) setCaptionInfoHeader(w http.ResponseWriter, r *http.Request, objPath, filePath string) {
+	var info *ffprobe.Info
+	if !me.NoProbe {
+		info, _ = me.ffmpegProbe(r.Context(), filePath)
+	}
+	if u, ok := me.captionURL(r.Host, r.UserAgent(), objPath, filePath, info); ok {
+		w.Header().Set(captionInfoHeader, u)
+	}
+}
+
+// Serves an embedded text subtitle track of the video as SRT, from the
+// SubtitleCache if it's been extracted before.
+func (me *Server) serveEmbeddedSubtitles(w http.ResponseWriter, r *http.Request, filePath string, index int) {
+	if me.NoTranscode || me.NoProbe {
+		http.Error(w, "extracting subtitles needs transcoding and probing", http.StatusNotFound)
+		return
+	}
+	fi, err := fs.Stat(me.FS, filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := me.ffmpegProbe(r.Context(), filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !slices.Contains(textSubtitleIndexes(info), index) {
+		http.Error(w, "no such text subtitles", http.StatusNotFound)
+		return
+	}
+	cacheKey := subtitleCacheKey{filePath, fi.ModTime().UnixNano(), index}
+	var body []byte
+	if me.SubtitleCache != nil {
+		if cached, ok := me.SubtitleCache.Get(cacheKey); ok {
+			body = cached.([]byte)
+		}
+	}
+	if body == nil {
+		body, err = me.extractSubtitles(r, filePath, index)
+		if err != nil {
+			me.Logger.Levelf(log.Warning, "extracting subtitles %d of %q: %v", index, filePath, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if me.SubtitleCache != nil {
+			me.SubtitleCache.Set(cacheKey, body)
+		}
+	}
+	w.Header().Set("Content-Type", "text/srt; charset=utf-8")
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(body))
+}
+
+// Serves a subtitle file converted to SRT, from the SubtitleCache if it's
+// been converted before.
+func (me *Server) serveConvertedSubtitles(w http.ResponseWriter, r *http.Request, filePath string) {
+	fi, err := fs.Stat(me.FS, filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	cacheKey := convertedSubtitleCacheKey{filePath, fi.ModTime().UnixNano()}
+	var body []byte
+	if me.SubtitleCache != nil {
+		if cached, ok := me.SubtitleCache.Get(cacheKey); ok {
+			body = cached.([]byte)
+		}
+	}
+	if body == nil {
+		data, err := fs.ReadFile(me.FS, filePath)
+		if err == nil {
+			body, err = subtitles.ToSRT(path.Ext(filePath), data)
+		}
+		if err != nil {
+			me.Logger.Levelf(log.Warning, "converting %q to SRT: %v", filePath, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if me.SubtitleCache != nil {
+			me.SubtitleCache.Set(cacheKey, body)
+		}
+	}
+	w.Header().Set("Content-Type", "text/srt; charset=utf-8")
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(body))
+}
+
+func (me *Server) extractSubtitles(r *http.Request, filePath string, index int) ([]byte, error) {
+	session := me.startTranscodeSession(r, filePath, "subtitles", time.Now(), "")
+	p, err := transcode.Subtitles(r.Context(), me.loopbackResURL(filePath), index, nil)
+	if err != nil {
+		me.endTranscodeSession(session, nil, err)
+		return nil, err
+	}
+	var b bytes.Buffer
+	_, err = io.Copy(session.writer(&b), p)
+	p.Close()
+	me.endTranscodeSession(session, p, nil)
+	if err == nil && b.Len() == 0 {
+		err = errors.New("ffmpeg produced nothing")
+	}
+	return b.Bytes(), err
+}
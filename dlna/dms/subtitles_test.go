@@ -0,0 +1,151 @@
+package dms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestEmbeddedSubtitles(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv": {},
+			"Films/Up.mkv":   {},
+			"Films/Up.srt":   {Data: []byte("english")},
+		},
+		RootObjectPath: ".",
+		Logger:         log.Default,
+		FFProbeCache:   mapCache{},
+		SubtitleCache:  mapCache{},
+		ClientProfiles: []ClientProfile{{UserAgent: "FrenchTV", SubtitleLanguages: []string{"fr"}}},
+	}
+	modTime := time.Time{}.UnixNano()
+	s.FFProbeCache.Set(ffmpegInfoCacheKey{"Films/Heat.mkv", modTime}, &ffprobe.Info{Streams: []map[string]interface{}{
+		{"codec_type": "video", "codec_name": "h264"},
+		{"codec_type": "subtitle", "codec_name": "hdmv_pgs_subtitle"},
+		{"codec_type": "subtitle", "codec_name": "subrip", "tags": map[string]interface{}{"language": "eng"}},
+		{"codec_type": "subtitle", "codec_name": "ass", "tags": map[string]interface{}{"language": "fre"}},
+	}})
+	s.FFProbeCache.Set(ffmpegInfoCacheKey{"Films/Up.mkv", modTime}, &ffprobe.Info{Streams: []map[string]interface{}{
+		{"codec_type": "video", "codec_name": "h264"},
+		{"codec_type": "subtitle", "codec_name": "subrip"},
+	}})
+	s.SubtitleCache.Set(subtitleCacheKey{"Films/Heat.mkv", modTime, 2}, []byte("français"))
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	for _, c := range []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"index=2", http.StatusOK, "français"},
+		{"index=0", http.StatusNotFound, ""},
+		{"index=3", http.StatusNotFound, ""},
+		{"index=x", http.StatusBadRequest, ""},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/subtitle?path=Films%2FHeat.mkv&"+c.query, nil))
+		if w.Code != c.code || c.code == http.StatusOK && w.Body.String() != c.body {
+			t.Errorf("%s: %d %q", c.query, w.Code, w.Body)
+		}
+	}
+
+	cdService := &contentDirectoryService{Server: s}
+	items := func(userAgent string) map[string]upnpav.Item {
+		obj, _ := cdService.objectFromID("Films")
+		objs, err := cdService.browseChildren(context.Background(), "Films", obj, "localhost", userAgent, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := make(map[string]upnpav.Item)
+		for _, o := range objs {
+			ret[o.(upnpav.Item).Title] = o.(upnpav.Item)
+		}
+		return ret
+	}
+	captionURL := func(item upnpav.Item) string {
+		if len(item.CaptionInfoEx) != 1 || item.CaptionInfoEx[0].Type != "srt" {
+			t.Fatalf("%s has captions %+v", item.Title, item.CaptionInfoEx)
+		}
+		return item.CaptionInfoEx[0].URL
+	}
+	heat := items("FrenchTV")["Heat.mkv"]
+	var embedded []string
+	for _, r := range heat.Res {
+		if strings.HasPrefix(r.ProtocolInfo, "http-get:*:text/srt:") {
+			embedded = append(embedded, r.URL)
+		}
+	}
+	if len(embedded) != 2 || !strings.HasSuffix(embedded[0], "index=1&path=Films%2FHeat.mkv") || !strings.HasSuffix(embedded[1], "index=2&path=Films%2FHeat.mkv") {
+		t.Errorf("embedded subtitles %q", embedded)
+	}
+	if u := captionURL(heat); u != "http://localhost/subtitle?index=2&path=Films%2FHeat.mkv" {
+		t.Errorf("French captions %q", u)
+	}
+	others := items("OtherTV")
+	if u := captionURL(others["Heat.mkv"]); u != "http://localhost/subtitle?index=1&path=Films%2FHeat.mkv" {
+		t.Errorf("captions %q", u)
+	}
+	if u := captionURL(others["Up.mkv"]); u != "http://localhost/subtitle?path=Films%2FUp.mkv" {
+		t.Errorf("captions of a video with a subtitle file %q", u)
+	}
+
+	r := httptest.NewRequest("GET", "/res?path="+url.QueryEscape("Films/Heat.mkv"), nil)
+	r.Header.Set(getCaptionInfoHeader, "1")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if h := w.Header().Get(captionInfoHeader); h != "http://example.com/subtitle?index=1&path=Films%2FHeat.mkv" {
+		t.Errorf("%s header %q", captionInfoHeader, h)
+	}
+}
+
+func TestConvertedSubtitles(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv":    {},
+			"Films/Heat.fr.vtt": {Data: []byte("WEBVTT\n\n00:01.000 --> 00:02.000\nBonjour\n")},
+			"Films/Heat.en.srt": {Data: []byte("english")},
+			"Films/Up.mkv":      {},
+			"Films/Up.srt":      {Data: []byte("up")},
+			"Films/Up.ass":      {Data: []byte("[Events]\nFormat: Start, End, Text\nDialogue: 0:00:01.00,0:00:02.00,Hi\n")},
+			"Films/Jaws.mkv":    {},
+			"Films/Jaws.ass":    {Data: []byte("[Events]\nFormat: Start, End, Text\nDialogue: 0:00:01.00,0:00:02.00,Hi\n")},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		Logger:         log.Default,
+		SubtitleCache:  mapCache{},
+		ClientProfiles: []ClientProfile{{UserAgent: "FrenchTV", SubtitleLanguages: []string{"fr"}}},
+	}
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	for _, c := range []struct {
+		userAgent, path, body string
+	}{
+		{"FrenchTV", "Films/Heat.mkv", "1\n00:00:01,000 --> 00:00:02,000\nBonjour\n\n"},
+		{"OtherTV", "Films/Heat.mkv", "english"},
+		{"FrenchTV", "Films/Up.mkv", "up"},
+		{"OtherTV", "Films/Jaws.mkv", "1\n00:00:01,000 --> 00:00:02,000\nHi\n\n"},
+	} {
+		r := httptest.NewRequest("GET", "/subtitle?path="+url.QueryEscape(c.path), nil)
+		r.Header.Set("User-Agent", c.userAgent)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != c.body {
+			t.Errorf("%s fetching subtitles of %s: %d %q", c.userAgent, c.path, w.Code, w.Body)
+		}
+	}
+	if _, ok := s.SubtitleCache.Get(convertedSubtitleCacheKey{"Films/Jaws.ass", time.Time{}.UnixNano()}); !ok {
+		t.Error("conversion not cached")
+	}
+}
@@ -0,0 +1,105 @@
+package dms
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+// Query parameter of transcode resources picking the embedded subtitles to
+// burn in, by track number counting from 1, or by language.
+const subtitleTrackQueryKey = "subtitleTrack"
+
+// ffprobe names of subtitle codecs that are pictures rather than text.
+var pictureSubtitleCodecs = []string{"hdmv_pgs_subtitle", "dvd_subtitle", "dvb_subtitle", "xsub"}
+
+// Returns the embedded subtitle track to burn into a transcode for the
+// request, counting from 1, and whether it's pictures. The subtitleTrack
+// query parameter picks one by number or language, and otherwise the client's
+// SubtitleLanguages do. It's zero if none is picked.
+func (me *Server) subtitleTrack(r *http.Request, info *ffprobe.Info) (track int, pictures bool) {
+	streams := streamsOfType(info, "subtitle")
+	if len(streams) == 0 {
+		return
+	}
+	byLanguage := func(langs []string) int {
+		for _, lang := range langs {
+			for i, s := range streams {
+				if sameLanguage(lang, streamTag(s, "language")) {
+					return i + 1
+				}
+			}
+		}
+		return 0
+	}
+	if q := r.URL.Query().Get(subtitleTrackQueryKey); q != "" {
+		if n, err := strconv.Atoi(q); err == nil {
+			if n >= 1 && n <= len(streams) {
+				track = n
+			}
+		} else {
+			track = byLanguage([]string{q})
+		}
+	} else if p := me.clientProfile(r.UserAgent()); p != nil && !p.NoSubtitles {
+		track = byLanguage(p.SubtitleLanguages)
+	}
+	if track == 0 {
+		return
+	}
+	return track, slices.Contains(pictureSubtitleCodecs, streamString(streams[track-1], "codec_name"))
+}
+
+// Extensions of subtitle files, best first. Those other than SRT are
+// converted to it.
+var subtitleExts = []string{".srt", ".ass", ".ssa", ".vtt"}
+
+// Returns the subtitle file for the video to give the client: one named for
+// the first of its SubtitleLanguages there is, such as "Film.fr.srt", or else
+// "Film.srt", or else one in any language, such as "Film.en.vtt".
+func (me *Server) subtitleFile(userAgent, filePath string) (string, bool) {
+	p := me.clientProfile(userAgent)
+	if p != nil && p.NoSubtitles {
+		return "", false
+	}
+	base := strings.TrimSuffix(filePath, path.Ext(filePath))
+	var bases []string
+	if p != nil {
+		for _, lang := range p.SubtitleLanguages {
+			for _, code := range languageCodes(lang) {
+				bases = append(bases, base+"."+code)
+			}
+		}
+	}
+	bases = append(bases, base)
+	isFile := func(name string) bool {
+		fi, err := fs.Stat(me.FS, name)
+		return err == nil && fi.Mode().IsRegular()
+	}
+	for _, b := range bases {
+		for _, ext := range subtitleExts {
+			if isFile(b + ext) {
+				return b + ext, true
+			}
+		}
+	}
+	entries, _ := fs.ReadDir(me.FS, path.Dir(filePath))
+	for _, ext := range subtitleExts {
+		for _, e := range entries {
+			lang, ok := strings.CutPrefix(e.Name(), path.Base(base)+".")
+			if !ok {
+				continue
+			}
+			lang, ok = strings.CutSuffix(lang, ext)
+			name := path.Join(path.Dir(filePath), e.Name())
+			if ok && lang != "" && !strings.Contains(lang, ".") && isFile(name) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,110 @@
+package dms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestSubtitleTrack(t *testing.T) {
+	s := &Server{
+		ClientProfiles: []ClientProfile{
+			{UserAgent: "FrenchTV", SubtitleLanguages: []string{"fr"}},
+			{UserAgent: "GermanTV", SubtitleLanguages: []string{"de", "en"}},
+		},
+	}
+	var info ffprobe.Info
+	if err := json.Unmarshal([]byte(`{"format":{},"streams":[
+		{"index":0,"codec_type":"video"},
+		{"index":1,"codec_type":"audio"},
+		{"index":2,"codec_type":"subtitle","codec_name":"subrip","tags":{"language":"eng"}},
+		{"index":3,"codec_type":"subtitle","codec_name":"hdmv_pgs_subtitle","tags":{"language":"fre"}}
+	]}`), &info); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		userAgent, query string
+		track            int
+		pictures         bool
+	}{
+		{"FrenchTV", "", 2, true},
+		{"GermanTV", "", 1, false},
+		{"OtherTV", "", 0, false},
+		{"OtherTV", "?subtitleTrack=1", 1, false},
+		{"OtherTV", "?subtitleTrack=fra", 2, true},
+		{"FrenchTV", "?subtitleTrack=3", 0, false},
+		{"FrenchTV", "?subtitleTrack=jpn", 0, false},
+	} {
+		r := httptest.NewRequest("GET", "/res"+c.query, nil)
+		r.Header.Set("User-Agent", c.userAgent)
+		if track, pictures := s.subtitleTrack(r, &info); track != c.track || pictures != c.pictures {
+			t.Errorf("%s%s: got %d, %v", c.userAgent, c.query, track, pictures)
+		}
+	}
+}
+
+func TestSubtitleFile(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv":     {},
+			"Films/Heat.srt":     {Data: []byte("english")},
+			"Films/Heat.fre.srt": {Data: []byte("français")},
+			"Films/Up.mkv":       {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		Logger:         log.Default,
+		ClientProfiles: []ClientProfile{
+			{UserAgent: "FrenchTV", SubtitleLanguages: []string{"fr"}},
+			{UserAgent: "BedroomTV", AudioLanguages: []string{"de"}, NoSubtitles: true},
+		},
+	}
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	for _, c := range []struct {
+		userAgent, path string
+		code            int
+		body            string
+	}{
+		{"FrenchTV", "Films/Heat.mkv", http.StatusOK, "français"},
+		{"OtherTV", "Films/Heat.mkv", http.StatusOK, "english"},
+		{"BedroomTV", "Films/Heat.mkv", http.StatusNotFound, ""},
+		{"FrenchTV", "Films/Up.mkv", http.StatusNotFound, ""},
+	} {
+		r := httptest.NewRequest("GET", "/subtitle?path="+url.QueryEscape(c.path), nil)
+		r.Header.Set("User-Agent", c.userAgent)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != c.code || c.code == http.StatusOK && w.Body.String() != c.body {
+			t.Errorf("%s fetching subtitles of %s: %d %q", c.userAgent, c.path, w.Code, w.Body.String())
+		}
+	}
+
+	cdService := &contentDirectoryService{Server: s}
+	hasSubtitles := func(userAgent string) bool {
+		obj, _ := cdService.objectFromID("Films")
+		objs, err := cdService.browseChildren(context.Background(), "Films", obj, "localhost", userAgent, "")
+		if err != nil || len(objs) == 0 {
+			t.Fatal(objs, err)
+		}
+		for _, r := range objs[0].(upnpav.Item).Res {
+			if strings.Contains(r.URL, subtitlePath) {
+				return true
+			}
+		}
+		return false
+	}
+	if !hasSubtitles("FrenchTV") || hasSubtitles("BedroomTV") {
+		t.Error("subtitles offered wrongly")
+	}
+}
@@ -0,0 +1,124 @@
+package dms
+
+import (
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	torrentsAPIPath = "/api/torrents"
+	// The root folder the Torrents are listed in.
+	torrentsFolder = "Torrents"
+)
+
+// Returns an FS with mounted listed as the folder dir in the root of fsys,
+// hiding anything of that name in fsys.
+func mountFS(fsys fs.FS, dir string, mounted fs.FS) fs.FS {
+	return mountedFS{fsys, dir, mounted}
+}
+
+type mountedFS struct {
+	fs.FS
+	dir     string
+	mounted fs.FS
+}
+
+// Returns the path in the mounted FS, if the name is in it. Invalid names are
+// left to fsys to reject.
+func (me mountedFS) mountedPath(name string) (string, bool) {
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+	if name == me.dir {
+		return ".", true
+	}
+	return strings.CutPrefix(name, me.dir+"/")
+}
+
+func (me mountedFS) Open(name string) (fs.File, error) {
+	if p, ok := me.mountedPath(name); ok {
+		f, err := me.mounted.Open(p)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if p == "." {
+			return mountedRoot{f, me.dir}, nil
+		}
+		return f, nil
+	}
+	return me.FS.Open(name)
+}
+
+func (me mountedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if p, ok := me.mountedPath(name); ok {
+		return fs.ReadDir(me.mounted, p)
+	}
+	entries, err := fs.ReadDir(me.FS, name)
+	if name != "." {
+		return entries, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries = slices.DeleteFunc(entries, func(e fs.DirEntry) bool { return e.Name() == me.dir })
+	if fi, err := me.Stat(me.dir); err == nil {
+		entries = append(entries, fs.FileInfoToDirEntry(fi))
+		slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	}
+	return entries, nil
+}
+
+func (me mountedFS) Stat(name string) (fs.FileInfo, error) {
+	if p, ok := me.mountedPath(name); ok {
+		fi, err := fs.Stat(me.mounted, p)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+		}
+		if p == "." {
+			return mountedRootInfo{fi, me.dir}, nil
+		}
+		return fi, nil
+	}
+	return fs.Stat(me.FS, name)
+}
+
+// The root of a mounted FS, named after the folder it's mounted as.
+type mountedRoot struct {
+	fs.File
+	name string
+}
+
+func (me mountedRoot) Stat() (fs.FileInfo, error) {
+	fi, err := me.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return mountedRootInfo{fi, me.name}, nil
+}
+
+func (me mountedRoot) ReadDir(n int) ([]fs.DirEntry, error) {
+	d, ok := me.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: me.name, Err: fs.ErrInvalid}
+	}
+	return d.ReadDir(n)
+}
+
+type mountedRootInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (me mountedRootInfo) Name() string { return me.name }
+
+// Serves the API of the Torrents, if they have one.
+func (me *Server) serveTorrentsAPI(w http.ResponseWriter, r *http.Request) {
+	h, ok := me.Torrents.(http.Handler)
+	if !ok {
+		http.Error(w, "no torrents", http.StatusNotFound)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,63 @@
+package dms
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+)
+
+func TestMountFS(t *testing.T) {
+	fsys := mountFS(fstest.MapFS{
+		"Films/Heat.mkv":   {},
+		"Torrents/Old.mkv": {},
+		"Zoo.mp3":          {},
+	}, torrentsFolder, fstest.MapFS{
+		"Film/Film.mkv": {Data: []byte("film")},
+	})
+	if err := fstest.TestFS(fsys, "Films/Heat.mkv", "Torrents/Film/Film.mkv", "Zoo.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(fsys, "Torrents/Old.mkv"); err == nil {
+		t.Error("the mount doesn't hide what's under it")
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 3 || names[1] != torrentsFolder || !entries[1].IsDir() {
+		t.Errorf("got %q", names)
+	}
+}
+
+func TestTorrentsAPI(t *testing.T) {
+	s := &Server{FS: fstest.MapFS{}, RootObjectPath: ".", NoProbe: true, Logger: log.Default}
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", torrentsAPIPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("without torrents: got %d", w.Code)
+	}
+	s.Torrents = torrentsHandlerFS{fstest.MapFS{}}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", torrentsAPIPath, nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+type torrentsHandlerFS struct {
+	fs.FS
+}
+
+func (torrentsHandlerFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusTeapot)
+}
@@ -0,0 +1,34 @@
+package dms
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// The instrumentation scope of the spans dms makes.
+const tracerName = "github.com/anacrolix/dms/dlna/dms"
+
+func (me *Server) tracer() trace.Tracer {
+	if me.TracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+	return me.TracerProvider.Tracer(tracerName)
+}
+
+// Starts a span as a child of any in the context, to be ended with endSpan.
+func (me *Server) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return me.tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Ends the span, marking it failed if there's an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
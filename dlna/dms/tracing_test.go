@@ -0,0 +1,63 @@
+package dms
+
+import (
+	"net"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	recorder := tracetest.NewSpanRecorder()
+	s := &Server{
+		FS: fstest.MapFS{
+			"Films/Heat.mkv": {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		AllowedIpNets:  []*net.IPNet{all},
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	}
+	if err := s.initServices(); err != nil {
+		t.Fatal(err)
+	}
+	browse := func(objectID string) {
+		r := httptest.NewRequest("POST", serviceControlURL, strings.NewReader(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+			`<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1"><ObjectID>`+objectID+`</ObjectID><BrowseFlag>BrowseDirectChildren</BrowseFlag></u:Browse>`+
+			`</s:Body></s:Envelope>`))
+		r.Header.Set("SOAPACTION", `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+		s.serviceControlHandler(httptest.NewRecorder(), r)
+	}
+	browse("Films")
+	browse("Gone")
+
+	spans := recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	if want := []string{"read dir", "browse children", "Browse", "read dir", "browse children", "Browse"}; !slices.Equal(names, want) {
+		t.Fatalf("got spans %q, want %q", names, want)
+	}
+	action := spans[2]
+	for _, child := range spans[:2] {
+		if child.SpanContext().TraceID() != action.SpanContext().TraceID() {
+			t.Errorf("%q isn't in the Browse trace", child.Name())
+		}
+	}
+	if spans[1].Parent().SpanID() != action.SpanContext().SpanID() {
+		t.Errorf("browse children isn't a child of Browse")
+	}
+	if got := spans[5].Status().Code.String(); got != "Error" {
+		t.Errorf("browsing a missing folder: got status %s", got)
+	}
+}
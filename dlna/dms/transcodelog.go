@@ -0,0 +1,146 @@
+package dms
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const transcodeLogAPIPath = "/api/transcodes/log"
+
+// Ends the names of transcode logs, after the TranscodeLogPattern's, so each
+// session has its own. Only files named so are pruned.
+var transcodeLogSuffix = regexp.MustCompile(`\.\d{8}-\d{6}(-\d+)?\.log$`)
+
+// Logs modified this recently may still be being written, so aren't pruned.
+const transcodeLogGrace = time.Minute
+
+// Creates the log of a transcode session, from the TranscodeLogPattern with
+// [tsname] replaced by logTsName, and the time it started. Devices, such as
+// /dev/null, are written to as they are, and the returned path is empty for
+// them. The file is nil if there's no pattern.
+func (me *Server) createTranscodeLog(logTsName string, started time.Time) (f *os.File, path string, err error) {
+	p := strings.ReplaceAll(me.TranscodeLogPattern, "[tsname]", logTsName)
+	if p == "" {
+		return
+	}
+	if fi, err := os.Stat(p); err == nil && fi.Mode()&os.ModeDevice != 0 {
+		f, err = os.Create(p)
+		return f, "", err
+	}
+	os.MkdirAll(filepath.Dir(p), 0o750)
+	stamp := started.Format("20060102-150405")
+	for i := 1; ; i++ {
+		path = p + "." + stamp + ".log"
+		if i > 1 {
+			path = fmt.Sprintf("%s.%s-%d.log", p, stamp, i)
+		}
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if me.TranscodeLogMaxAge != 0 || me.TranscodeLogMaxSize != 0 {
+		go me.pruneTranscodeLogs()
+	}
+	return
+}
+
+// Returns the directory the TranscodeLogPattern puts logs in, however
+// [tsname] is replaced, or "" if it's a device directory.
+func (me *Server) transcodeLogDir() string {
+	prefix, _, _ := strings.Cut(me.TranscodeLogPattern, "[tsname]")
+	if prefix == "" {
+		return ""
+	}
+	dir := filepath.Dir(prefix)
+	if dir == "/dev" || strings.HasPrefix(dir, "/dev/") {
+		return ""
+	}
+	return dir
+}
+
+// Deletes the transcode logs older than TranscodeLogMaxAge, and the oldest
+// beyond TranscodeLogMaxSize in all.
+func (me *Server) pruneTranscodeLogs() {
+	if !me.transcodeLogPruning.TryLock() {
+		// It's being done.
+		return
+	}
+	defer me.transcodeLogPruning.Unlock()
+	dir := me.transcodeLogDir()
+	if dir == "" {
+		return
+	}
+	type logFile struct {
+		path string
+		fi   fs.FileInfo
+	}
+	var logs []logFile
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || !transcodeLogSuffix.MatchString(p) {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			logs = append(logs, logFile{p, fi})
+		}
+		return nil
+	})
+	// Newest first.
+	slices.SortFunc(logs, func(a, b logFile) int {
+		return b.fi.ModTime().Compare(a.fi.ModTime())
+	})
+	var total int64
+	for _, l := range logs {
+		total += l.fi.Size()
+		age := time.Since(l.fi.ModTime())
+		if age < transcodeLogGrace {
+			continue
+		}
+		if me.TranscodeLogMaxAge != 0 && age > me.TranscodeLogMaxAge || me.TranscodeLogMaxSize != 0 && total > me.TranscodeLogMaxSize {
+			if err := os.Remove(l.path); err == nil {
+				me.Logger.Printf("deleted old transcode log %q", l.path)
+			}
+		}
+	}
+}
+
+// GET serves the log of the latest transcode session, or with the path query
+// parameter, the latest of the file. With failed=1, only failed sessions
+// count.
+func (me *Server) serveTranscodeLogAPI(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filePath string
+	if query.Has("path") {
+		filePath = me.filePath(query.Get("path"))
+		if me.hiddenFrom(playbackClient(r), filePath) {
+			http.Error(w, "no such object", http.StatusNotFound)
+			return
+		}
+	}
+	stats := me.TranscodeStats()
+	// Running sessions are oldest first, and recent ones newest first.
+	slices.Reverse(stats.Running)
+	for _, s := range slices.Concat(stats.Running, stats.Recent) {
+		if s.Log == "" || filePath != "" && s.Path != filePath || query.Get("failed") == "1" && s.Failure == "" {
+			continue
+		}
+		if me.hiddenFrom(playbackClient(r), s.Path) {
+			continue
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, s.Log)
+		return
+	}
+	http.Error(w, "no such transcode log", http.StatusNotFound)
+}
@@ -0,0 +1,79 @@
+package dms
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestTranscodeLogs(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{
+		FS:                  fstest.MapFS{},
+		RootObjectPath:      ".",
+		NoProbe:             true,
+		Logger:              log.Default,
+		TranscodeLogPattern: filepath.Join(dir, "[tsname]"),
+	}
+	started := time.Date(2024, 1, 31, 20, 15, 0, 0, time.Local)
+	var paths []string
+	for range 2 {
+		f, p, err := s.createTranscodeLog(filepath.Join("web", "Heat.mkv"), started)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("in.mkv: Invalid data found when processing input\n")
+		f.Close()
+		paths = append(paths, p)
+	}
+	if want := filepath.Join(dir, "web", "Heat.mkv.20240131-201500.log"); paths[0] != want || paths[1] != strings.TrimSuffix(want, ".log")+"-2.log" {
+		t.Errorf("got %q", paths)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	s.endTranscodeSession(s.startTranscodeSession(req, "Films/Heat.mkv", "web", started, paths[0]), nil, errors.New("exit status 1"))
+	s.endTranscodeSession(s.startTranscodeSession(req, "Films/Heat.mkv", "web", started, ""), nil, errors.New("exit status 1"))
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	for target, want := range map[string]int{
+		transcodeLogAPIPath:                                   http.StatusOK,
+		transcodeLogAPIPath + "?path=/Films/Heat.mkv":         http.StatusOK,
+		transcodeLogAPIPath + "?path=/Films/Cats.mkv":         http.StatusNotFound,
+		transcodeLogAPIPath + "?failed=1&path=Films/Heat.mkv": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != want || want == http.StatusOK && !strings.Contains(w.Body.String(), "Invalid data") {
+			t.Errorf("%s: %d %q", target, w.Code, w.Body)
+		}
+	}
+
+	// The first is too old, and the second takes them over the size limit
+	// after the new one. Other files are left alone.
+	os.Chtimes(paths[0], time.Now(), time.Now().Add(-48*time.Hour))
+	os.Chtimes(paths[1], time.Now(), time.Now().Add(-2*time.Hour))
+	f, latest, err := s.createTranscodeLog(filepath.Join("web", "Heat.mkv"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("in.mkv: Invalid data found when processing input\n")
+	f.Close()
+	other := filepath.Join(dir, "web", "notes.txt")
+	os.WriteFile(other, []byte("notes"), 0o644)
+	s.TranscodeLogMaxAge = 24 * time.Hour
+	s.TranscodeLogMaxSize = 60
+	s.pruneTranscodeLogs()
+	for p, want := range map[string]bool{paths[0]: false, paths[1]: false, latest: true, other: true} {
+		if _, err := os.Stat(p); (err == nil) != want {
+			t.Errorf("%s: kept %v, want %v", p, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package dms
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+const (
+	transcodeStatsAPIPath = "/api/transcodes"
+	// How many ended transcodes TranscodeStats lists.
+	recentTranscodes = 100
+)
+
+// A transcode, for seeing which files and clients the server's load comes
+// from.
+type TranscodeSession struct {
+	Path string `json:"path"`
+	// Such as "web", "hls" or "stream".
+	Transcode string    `json:"transcode"`
+	Client    string    `json:"client"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Started   time.Time `json:"started"`
+	// Seconds from starting to ending, or so far if it's running.
+	WallTime float64 `json:"wallTime"`
+	// Seconds of CPU time the transcode's command used, once it's ended.
+	CPUTime float64 `json:"cpuTime"`
+	// Sent to the client.
+	Bytes   int64  `json:"bytes"`
+	Failure string `json:"failure,omitempty"`
+	// The file ffmpeg's output was logged to, if any.
+	Log string `json:"log,omitempty"`
+}
+
+// Ended transcodes, added up.
+type TranscodeTotals struct {
+	Sessions int     `json:"sessions"`
+	Failures int     `json:"failures"`
+	WallTime float64 `json:"wallTime"`
+	CPUTime  float64 `json:"cpuTime"`
+	Bytes    int64   `json:"bytes"`
+}
+
+func (me *TranscodeTotals) add(s TranscodeSession) {
+	me.Sessions++
+	if s.Failure != "" {
+		me.Failures++
+	}
+	me.WallTime += s.WallTime
+	me.CPUTime += s.CPUTime
+	me.Bytes += s.Bytes
+}
+
+// The transcodes since the server started.
+type TranscodeStats struct {
+	Running []TranscodeSession `json:"running"`
+	// The last few to end, the latest first.
+	Recent   []TranscodeSession         `json:"recent"`
+	ByPath   map[string]TranscodeTotals `json:"byPath"`
+	ByClient map[string]TranscodeTotals `json:"byClient"`
+	Total    TranscodeTotals            `json:"total"`
+}
+
+type transcodeSession struct {
+	TranscodeSession
+	bytes atomic.Int64
+	// Covers the transcode from its start until its command exits.
+	span trace.Span
+}
+
+// Returns a writer to w counting the bytes sent through it.
+func (me *transcodeSession) writer(w io.Writer) io.Writer {
+	return transcodeSessionWriter{w, me}
+}
+
+type transcodeSessionWriter struct {
+	io.Writer
+	s *transcodeSession
+}
+
+func (me transcodeSessionWriter) Write(b []byte) (n int, err error) {
+	n, err = me.Writer.Write(b)
+	me.s.bytes.Add(int64(n))
+	return
+}
+
+func (me *transcodeSession) snapshot() TranscodeSession {
+	ret := me.TranscodeSession
+	ret.Bytes = me.bytes.Load()
+	ret.WallTime = time.Since(ret.Started).Seconds()
+	return ret
+}
+
+// Keeps the TranscodeStats. The zero value is ready for use.
+type transcodeAccounting struct {
+	mu      sync.Mutex
+	running map[*transcodeSession]struct{}
+	// The latest last.
+	recent   []TranscodeSession
+	byPath   map[string]TranscodeTotals
+	byClient map[string]TranscodeTotals
+	total    TranscodeTotals
+}
+
+// Starts accounting for a transcode of the file for the request, logged to
+// the logPath, if it's not empty.
+func (me *Server) startTranscodeSession(r *http.Request, filePath, tsname string, started time.Time, logPath string) *transcodeSession {
+	s := &transcodeSession{TranscodeSession: TranscodeSession{
+		Path:      filePath,
+		Transcode: tsname,
+		Client:    playbackClient(r),
+		UserAgent: r.UserAgent(),
+		Started:   started,
+		Log:       logPath,
+	}}
+	_, s.span = me.startSpan(r.Context(), "transcode",
+		attribute.String("file.path", filePath),
+		attribute.String("dms.transcode", tsname),
+		attribute.String("user_agent.original", r.UserAgent()),
+	)
+	a := &me.transcodeAccounting
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running == nil {
+		a.running = make(map[*transcodeSession]struct{})
+		a.byPath = make(map[string]TranscodeTotals)
+		a.byClient = make(map[string]TranscodeTotals)
+	}
+	a.running[s] = struct{}{}
+	return s
+}
+
+func addTotals(m map[string]TranscodeTotals, key string, s TranscodeSession) {
+	t := m[key]
+	t.add(s)
+	m[key] = t
+}
+
+// Ends the session once the transcode's command, whose output is p, exits,
+// which it does when p is closed. If the transcode didn't start, p is nil and
+// err says why.
+func (me *Server) endTranscodeSession(s *transcodeSession, p io.Reader, err error) {
+	end := func(u transcode.Usage) {
+		ended := s.snapshot()
+		ended.CPUTime = u.CPUTime.Seconds()
+		ended.Failure = u.Failure
+		s.span.SetAttributes(
+			attribute.Int64("dms.transcode.bytes", ended.Bytes),
+			attribute.Float64("dms.transcode.cpu_seconds", ended.CPUTime),
+		)
+		var failure error
+		if u.Failure != "" {
+			failure = errors.New(u.Failure)
+		}
+		endSpan(s.span, failure)
+		a := &me.transcodeAccounting
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.running, s)
+		a.recent = append(a.recent, ended)
+		if len(a.recent) > recentTranscodes {
+			a.recent = slices.Delete(a.recent, 0, len(a.recent)-recentTranscodes)
+		}
+		addTotals(a.byPath, ended.Path, ended)
+		addTotals(a.byClient, ended.Client, ended)
+		a.total.add(ended)
+	}
+	if err != nil {
+		end(transcode.Usage{Failure: err.Error()})
+		return
+	}
+	go func() {
+		u, _ := transcode.WaitUsage(p)
+		end(u)
+	}()
+}
+
+// Returns the TranscodeStats so far.
+func (me *Server) TranscodeStats() (ret TranscodeStats) {
+	a := &me.transcodeAccounting
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ret.Running = []TranscodeSession{}
+	for s := range a.running {
+		ret.Running = append(ret.Running, s.snapshot())
+	}
+	slices.SortFunc(ret.Running, func(a, b TranscodeSession) int {
+		return a.Started.Compare(b.Started)
+	})
+	ret.Recent = slices.Clone(a.recent)
+	slices.Reverse(ret.Recent)
+	ret.ByPath = make(map[string]TranscodeTotals, len(a.byPath))
+	maps.Copy(ret.ByPath, a.byPath)
+	ret.ByClient = make(map[string]TranscodeTotals, len(a.byClient))
+	maps.Copy(ret.ByClient, a.byClient)
+	ret.Total = a.total
+	return
+}
+
+// GET returns the TranscodeStats as JSON.
+func (me *Server) serveTranscodeStatsAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(me.TranscodeStats())
+}
@@ -0,0 +1,47 @@
+package dms
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestTranscodeStats(t *testing.T) {
+	s := &Server{FS: fstest.MapFS{}, RootObjectPath: ".", NoProbe: true, Logger: log.Default}
+	mux := http.NewServeMux()
+	s.initMux(mux)
+	req := httptest.NewRequest("GET", "/res?path=a.mkv", nil)
+	req.RemoteAddr = "192.168.1.20:5000"
+
+	running := s.startTranscodeSession(req, "a.mkv", "web", time.Now(), "")
+	out := running.writer(httptest.NewRecorder())
+	out.Write([]byte("12345"))
+	failed := s.startTranscodeSession(req, "b.mkv", "t", time.Now(), "")
+	s.endTranscodeSession(failed, nil, errors.New("no ffmpeg"))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", transcodeStatsAPIPath, nil))
+	var stats TranscodeStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Running) != 1 || stats.Running[0].Bytes != 5 || stats.Running[0].Client != "192.168.1.20" || stats.Running[0].WallTime <= 0 {
+		t.Errorf("running %+v", stats.Running)
+	}
+	if len(stats.Recent) != 1 || stats.Recent[0].Path != "b.mkv" || !strings.Contains(stats.Recent[0].Failure, "no ffmpeg") {
+		t.Errorf("recent %+v", stats.Recent)
+	}
+	if stats.ByPath["b.mkv"].Failures != 1 || stats.ByClient["192.168.1.20"].Sessions != 1 || stats.Total.Sessions != 1 {
+		t.Errorf("totals %+v %+v %+v", stats.ByPath, stats.ByClient, stats.Total)
+	}
+	if got := s.Stats(); got.RunningTranscodes != 1 || got.Transcodes.Failures != 1 {
+		t.Errorf("stats %+v", got)
+	}
+}
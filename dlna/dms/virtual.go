@@ -0,0 +1,264 @@
+package dms
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/dms/misc"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Prefix of the ObjectIDs of containers that aren't directories. The IDs of
+// files and directories are query escaped, so never contain a raw ':'.
+const virtualIDPrefix = "dms:"
+
+// A container listing files picked for the client, rather than a directory.
+type virtualContainer struct {
+	ID    string
+	Title string
+	// Returns the FS paths of the items for the client.
+	Items func(me *Server, client string) []string
+	// Lists the library by media type, so it's only in the root for clients
+	// whose profile's View asks for it.
+	mediaType bool
+}
+
+// Containers of all the library's files of each type, for renderers that
+// handle flat lists better than folders.
+var mediaTypeContainers = []virtualContainer{
+	{
+		ID:        virtualIDPrefix + "music",
+		Title:     "Music",
+		Items:     libraryMediaItems("audio"),
+		mediaType: true,
+	},
+	{
+		ID:        virtualIDPrefix + "videos",
+		Title:     "Videos",
+		Items:     libraryMediaItems("video"),
+		mediaType: true,
+	},
+	{
+		ID:        virtualIDPrefix + "photos",
+		Title:     "Photos",
+		Items:     libraryMediaItems("image"),
+		mediaType: true,
+	},
+}
+
+var virtualContainers = []virtualContainer{
+	{
+		ID:    virtualIDPrefix + "favorites",
+		Title: "Favorites",
+		Items: (*Server).favorites,
+	},
+	{
+		ID:    virtualIDPrefix + "continueListening",
+		Title: "Continue listening",
+		Items: (*Server).continueListening,
+	},
+	{
+		ID:    virtualIDPrefix + "continueWatching",
+		Title: "Continue watching",
+		Items: (*Server).continueWatching,
+	},
+	{
+		ID:    virtualIDPrefix + "mostPlayed",
+		Title: "Most played",
+		Items: (*Server).mostPlayed,
+	},
+}
+
+// The most items listed in a virtual container.
+const maxVirtualContainerItems = 50
+
+// Returns the videos the client is part way through, most recent first.
+func (me *Server) continueWatching(client string) (paths []string) {
+	if me.Playback == nil {
+		return
+	}
+	for _, e := range me.Playback.recent(client) {
+		if len(paths) == maxVirtualContainerItems {
+			break
+		}
+		if e.Furthest <= 0 || e.Finished() {
+			continue
+		}
+		if mt, err := me.mimeTypeByPath(e.Path); err == nil && mt.IsVideo() {
+			paths = append(paths, e.Path)
+		}
+	}
+	return
+}
+
+// Returns the music the client has played most, excluding audiobooks. Ties go
+// to the most recently played.
+func (me *Server) mostPlayed(client string) (paths []string) {
+	if me.Playback == nil {
+		return
+	}
+	entries := me.Playback.recent(client)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].PlayCount > entries[j].PlayCount
+	})
+	for _, e := range entries {
+		if len(paths) == maxVirtualContainerItems {
+			break
+		}
+		mt, err := me.mimeTypeByPath(e.Path)
+		if err != nil || !mt.IsAudio() || me.isAudiobook(e.Path) {
+			continue
+		}
+		paths = append(paths, e.Path)
+	}
+	return
+}
+
+// Returns the media type containers and the other built in virtual
+// containers, then those of the Collections.
+func (me *Server) virtualContainers() []virtualContainer {
+	return slices.Concat(mediaTypeContainers, virtualContainers, me.collectionContainers())
+}
+
+// Returns the Items of a media type container, for the type of MIME-type such
+// as "audio".
+func libraryMediaItems(mediaType string) func(*Server, string) []string {
+	return func(me *Server, _ string) []string {
+		return me.libraryMedia(mediaType)
+	}
+}
+
+// Returns the FS paths of the library's files of the type of MIME-type, in
+// path order. They're found in the search index if there is one, and
+// otherwise by walking the library.
+func (me *Server) libraryMedia(mediaType string) (paths []string) {
+	if me.Search != nil {
+		paths, _ = me.Search.Search(`upnp:class derivedfrom "object.item.` + mediaType + `Item"`)
+		return
+	}
+	fs.WalkDir(me.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if ignored, _ := me.IgnorePath(p); ignored {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if mt, err := me.mimeTypeByPath(p); !d.IsDir() && err == nil && mt.Type() == mediaType {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	return
+}
+
+func (me *Server) virtualContainerByID(id string) (virtualContainer, bool) {
+	if strings.HasPrefix(id, virtualIDPrefix) {
+		for _, vc := range me.virtualContainers() {
+			if vc.ID == id {
+				return vc, true
+			}
+		}
+	}
+	return virtualContainer{}, false
+}
+
+// Returns the items of the virtual container for the client, leaving out those
+// its profile's MaxRating hides, and those hiddenFrom it.
+func (me *contentDirectoryService) virtualContainerItems(vc virtualContainer, userAgent, client string) []string {
+	return slices.DeleteFunc(vc.Items(me.Server, client), func(p string) bool {
+		return me.hiddenFrom(client, p) || me.hiddenByRating(userAgent, p)
+	})
+}
+
+func (me *contentDirectoryService) virtualContainerObject(vc virtualContainer, userAgent, client string) upnpav.Container {
+	return upnpav.Container{
+		Object: upnpav.Object{
+			ID:         vc.ID,
+			ParentID:   "0",
+			Restricted: 1,
+			Title:      translate(me.language(), vc.Title),
+			Class:      "object.container",
+		},
+		ChildCount: len(me.virtualContainerItems(vc, userAgent, client)),
+	}
+}
+
+// Returns the virtual containers that have something for the client, to list
+// in the root.
+func (me *contentDirectoryService) rootVirtualContainers(userAgent, client string) (ret []interface{}) {
+	mediaTypes := me.clientProfile(userAgent).ShowsMediaTypes()
+	for _, vc := range me.virtualContainers() {
+		if vc.mediaType && !mediaTypes {
+			continue
+		}
+		if c := me.virtualContainerObject(vc, userAgent, client); c.ChildCount != 0 {
+			ret = append(ret, c)
+		}
+	}
+	if c, _ := me.placesObject(placesID, userAgent, client); c.ChildCount != 0 {
+		ret = append(ret, c)
+	}
+	return
+}
+
+func (me *contentDirectoryService) virtualContainerChildren(ctx context.Context, vc virtualContainer, host, userAgent, client string) (ret []interface{}) {
+	for _, p := range me.virtualContainerItems(vc, userAgent, client) {
+		fi, err := fs.Stat(me.FS, p)
+		if err != nil {
+			continue
+		}
+		obj, err := me.cdsObjectToUpnpavObject(ctx, object{p, me.RootObjectPath}, fi, host, userAgent)
+		if err != nil {
+			me.Logger.Printf("error with %s: %s", p, err)
+			continue
+		}
+		switch obj := obj.(type) {
+		case upnpav.Item:
+			obj.ParentID = vc.ID
+			ret = append(ret, obj)
+		case upnpav.Container:
+			// Favorite folders.
+			obj.ParentID = vc.ID
+			ret = append(ret, obj)
+		}
+	}
+	return
+}
+
+// Sets when the client last played items, and where to resume those it was
+// part way through.
+func (me *contentDirectoryService) annotatePlayback(objs []interface{}, client string) {
+	if me.Playback == nil {
+		return
+	}
+	for i, obj := range objs {
+		item, ok := obj.(upnpav.Item)
+		if !ok {
+			continue
+		}
+		p, err := url.QueryUnescape(item.ID)
+		if err != nil {
+			continue
+		}
+		rec, ok := me.Playback.get(client, (&object{p, me.RootObjectPath}).FilePath())
+		if !ok {
+			continue
+		}
+		item.LastPlaybackTime = rec.LastPlayed.Format("2006-01-02T15:04:05")
+		item.PlaybackCount = rec.PlayCount
+		if rec.Furthest > 0 && !rec.Finished() {
+			item.LastPlaybackPosition = misc.FormatDurationSexagesimal(rec.Furthest)
+			// Samsung's bookmark, in seconds.
+			item.DcmInfo = fmt.Sprintf("BM=%d", int(rec.Furthest.Seconds()))
+		}
+		objs[i] = item
+	}
+}
@@ -0,0 +1,63 @@
+package dms
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestView(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{
+			"Music/Blondie/Call Me.mp3": {},
+			"Music/Dummy/Roads.flac":    {},
+			"Films/Heat.mkv":            {},
+			"Photos/2024/beach.jpg":     {},
+		},
+		RootObjectPath: ".",
+		NoProbe:        true,
+		NoTranscode:    true,
+		Logger:         log.Default,
+		ClientProfiles: []ClientProfile{
+			{UserAgent: "FlatTV", View: "types"},
+			{UserAgent: "BothTV", View: "both"},
+		},
+	}
+	cdService := &contentDirectoryService{Server: s}
+	ids := func(parent, userAgent string) (ret []string) {
+		obj, err := cdService.objectFromID(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs, err := cdService.browseChildren(context.Background(), parent, obj, "localhost", userAgent, "tv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range objs {
+			switch o := o.(type) {
+			case upnpav.Item:
+				ret = append(ret, o.ID)
+			case upnpav.Container:
+				ret = append(ret, o.ID)
+			}
+		}
+		return
+	}
+	for userAgent, want := range map[string][]string{
+		"":       {"Films", "Music", "Photos"},
+		"FlatTV": {"dms:music", "dms:videos", "dms:photos"},
+		"BothTV": {"dms:music", "dms:videos", "dms:photos", "Films", "Music", "Photos"},
+	} {
+		if got := ids("0", userAgent); !slices.Equal(got, want) {
+			t.Errorf("%q: root has %q, want %q", userAgent, got, want)
+		}
+	}
+	if got, want := ids("dms:music", "FlatTV"), []string{"Music%2FBlondie%2FCall+Me.mp3", "Music%2FDummy%2FRoads.flac"}; !slices.Equal(got, want) {
+		t.Errorf("music has %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package dms
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// The number of files probed at once while warming up, if not given.
+const defaultWarmUpConcurrency = 2
+
+// Returns the media files to probe at startup: the WarmUpRecent most recently
+// modified in the library, and all those in WarmUpPaths.
+func (me *Server) warmUpFiles() (ret []string) {
+	type file struct {
+		path    string
+		modTime time.Time
+	}
+	var recent []file
+	seen := make(map[string]struct{})
+	walk := func(root string, f func(p string, fi fs.FileInfo)) {
+		fs.WalkDir(me.FS, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			select {
+			case <-me.closed:
+				return fs.SkipAll
+			default:
+			}
+			if ignored, _ := me.IgnorePath(p); ignored {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			mt, err := me.mimeTypeByPath(p)
+			if err != nil || !mt.IsMedia() || mt.IsImage() {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			f(p, fi)
+			return nil
+		})
+	}
+	for _, dir := range me.WarmUpPaths {
+		dir = strings.Trim(dir, "/")
+		if dir == "" {
+			continue
+		}
+		walk(path.Clean(dir), func(p string, fi fs.FileInfo) {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				ret = append(ret, p)
+			}
+		})
+	}
+	if me.WarmUpRecent <= 0 {
+		return
+	}
+	walk(".", func(p string, fi fs.FileInfo) {
+		recent = append(recent, file{p, fi.ModTime()})
+	})
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].modTime.After(recent[j].modTime)
+	})
+	for _, f := range recent[:min(me.WarmUpRecent, len(recent))] {
+		if _, ok := seen[f.path]; !ok {
+			seen[f.path] = struct{}{}
+			ret = append(ret, f.path)
+		}
+	}
+	return
+}
+
+// Probes the files chosen for warming up, so that their results are cached
+// before clients browse to them.
+func (me *Server) warmUp() {
+	started := time.Now()
+	files := me.warmUpFiles()
+	concurrency := me.WarmUpConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultWarmUpConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+files:
+	for _, p := range files {
+		select {
+		case <-me.closed:
+			break files
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if _, err := me.ffmpegProbe(me.closedContext(), p); err != nil {
+				me.Logger.Levelf(log.Debug, "warming up %q: %v", p, err)
+			}
+		}()
+	}
+	wg.Wait()
+	me.Logger.Levelf(log.Debug, "probed %d files to warm up in %v", len(files), time.Since(started))
+}
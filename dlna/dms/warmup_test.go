@@ -0,0 +1,31 @@
+package dms
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestWarmUpFiles(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC) }
+	s := &Server{
+		FS: fstest.MapFS{
+			"TV/Show/e1.mkv":     {ModTime: day(1)},
+			"TV/Show/e2.mkv":     {ModTime: day(2)},
+			"Films/a.mkv":        {ModTime: day(5)},
+			"Films/b.mkv":        {ModTime: day(4)},
+			"Films/cover.jpg":    {ModTime: day(9)},
+			"Films/notes.txt":    {ModTime: day(9)},
+			"Music/old/song.mp3": {ModTime: day(3)},
+		},
+		closed:       make(chan struct{}),
+		WarmUpRecent: 3,
+		WarmUpPaths:  []string{"/TV/", ""},
+	}
+	got := s.warmUpFiles()
+	want := []string{"TV/Show/e1.mkv", "TV/Show/e2.mkv", "Films/a.mkv", "Films/b.mkv", "Music/old/song.mp3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package dms
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// How long changes are gathered before the folders are listed again, so that
+// copying in a folder of files isn't a refresh for each.
+const watchSettleTime = 2 * time.Second
+
+// A folder on the OS that's watched, and where it is in the FS.
+type watchedRoot struct {
+	osDir string
+	fsDir string
+}
+
+// Reports changes within folders on the OS.
+type folderWatcher interface {
+	// FS paths of the folders whose listings changed. An empty path is sent
+	// when changes were missed, and everything should be listed again.
+	Changes() <-chan string
+	Close() error
+}
+
+// Watches the folders served until the server is closed, refreshing the
+// listings of those that change.
+func (me *Server) watchFolders() {
+	logger := me.Logger.WithNames("watch")
+	ignore := func(fsPath string) bool {
+		ignored, _ := me.IgnorePath(fsPath)
+		return ignored
+	}
+	w, err := newFolderWatcher(me.watchedRoots, ignore, logger)
+	if err != nil {
+		logger.Levelf(log.Warning, "watching folders: %v", err)
+		return
+	}
+	defer w.Close()
+	dirs := make(map[string]struct{})
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-me.closed:
+			return
+		case dir, ok := <-w.Changes():
+			if !ok {
+				return
+			}
+			dirs[dir] = struct{}{}
+			if settled == nil {
+				settled = time.After(watchSettleTime)
+			}
+		case <-settled:
+			me.refreshFolders(dirs)
+			dirs = make(map[string]struct{})
+			settled = nil
+		}
+	}
+}
+
+// Lists the folders again, for the Library snapshot and ContentDirectory
+// subscribers.
+func (me *Server) refreshFolders(dirs map[string]struct{}) {
+	if _, ok := dirs[""]; ok {
+		me.updateLibrary()
+		return
+	}
+	listings := make(map[string][]LibraryEntry)
+	for dir := range dirs {
+		entries, err := readLibraryDir(me.liveFS, dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			listings[dir] = nil
+		} else if err != nil {
+			me.Logger.Levelf(log.Debug, "listing %q: %v", dir, err)
+		} else {
+			listings[dir] = entries
+		}
+	}
+	me.updateContainers(listings, false)
+	if me.Library != nil {
+		me.Library.update(listings)
+	}
+}
@@ -0,0 +1,166 @@
+//go:build linux
+// +build linux
+
+package dms
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/anacrolix/log"
+	"golang.org/x/sys/unix"
+)
+
+// Changes to a folder's listing, or the size or times of its files.
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_CLOSE_WRITE | unix.IN_ATTRIB | unix.IN_ONLYDIR
+
+// Watches every folder within the roots with inotify, adding folders as
+// they're created.
+type inotifyWatcher struct {
+	f       *os.File
+	ignore  func(fsPath string) bool
+	logger  log.Logger
+	changes chan string
+	closed  chan struct{}
+
+	mu sync.Mutex
+	// The folders watched, by watch descriptor.
+	dirs map[int32]watchedRoot
+	// Whether a warning has been logged that the watch limit was reached.
+	warnedLimit bool
+}
+
+func newFolderWatcher(roots []watchedRoot, ignore func(fsPath string) bool, logger log.Logger) (folderWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	me := &inotifyWatcher{
+		// Being non-blocking, reads use the runtime's poller, and are
+		// interrupted by Close.
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		ignore:  ignore,
+		logger:  logger,
+		changes: make(chan string, 64),
+		closed:  make(chan struct{}),
+		dirs:    make(map[int32]watchedRoot),
+	}
+	for _, r := range roots {
+		me.addTree(r, false)
+	}
+	go me.run()
+	return me, nil
+}
+
+func (me *inotifyWatcher) Changes() <-chan string {
+	return me.changes
+}
+
+func (me *inotifyWatcher) Close() error {
+	close(me.closed)
+	return me.f.Close()
+}
+
+// Reports a folder as changed, unless the watcher's closed.
+func (me *inotifyWatcher) report(fsDir string) {
+	select {
+	case me.changes <- fsDir:
+	case <-me.closed:
+	}
+}
+
+// Watches the folder and those within it. The folders are reported as
+// changed if report is set, as for a folder created or moved in.
+func (me *inotifyWatcher) addTree(root watchedRoot, report bool) {
+	filepath.WalkDir(root.osDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root.osDir, p)
+		if err != nil {
+			return nil
+		}
+		fsPath := path.Join(root.fsDir, filepath.ToSlash(rel))
+		if fsPath != root.fsDir && me.ignore(fsPath) {
+			return fs.SkipDir
+		}
+		wd, err := unix.InotifyAddWatch(int(me.f.Fd()), p, inotifyMask)
+		if err != nil {
+			me.mu.Lock()
+			defer me.mu.Unlock()
+			if errors.Is(err, unix.ENOSPC) {
+				if !me.warnedLimit {
+					me.logger.Levelf(log.Warning, "can't watch %q and beyond: raise fs.inotify.max_user_watches", p)
+					me.warnedLimit = true
+				}
+				return fs.SkipAll
+			}
+			me.logger.Levelf(log.Debug, "watching %q: %v", p, err)
+			return nil
+		}
+		// A folder moved within the roots keeps its watch descriptor, which
+		// now has the new path.
+		me.mu.Lock()
+		me.dirs[int32(wd)] = watchedRoot{p, fsPath}
+		me.mu.Unlock()
+		if report {
+			me.report(fsPath)
+		}
+		return nil
+	})
+}
+
+// Reads events until the watcher is closed.
+func (me *inotifyWatcher) run() {
+	defer close(me.changes)
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := me.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				me.logger.Levelf(log.Warning, "reading inotify events: %v", err)
+			}
+			return
+		}
+		for b := buf[:n]; len(b) >= unix.SizeofInotifyEvent; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&b[0]))
+			end := unix.SizeofInotifyEvent + int(ev.Len)
+			if end > len(b) {
+				break
+			}
+			name := string(b[unix.SizeofInotifyEvent:end])
+			for len(name) != 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			me.handle(ev.Wd, ev.Mask, name)
+			b = b[end:]
+		}
+	}
+}
+
+func (me *inotifyWatcher) handle(wd int32, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		me.report("")
+		return
+	}
+	me.mu.Lock()
+	dir, ok := me.dirs[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		// The folder's gone, or been unmounted.
+		delete(me.dirs, wd)
+	}
+	me.mu.Unlock()
+	if !ok || name == "" {
+		return
+	}
+	if mask&unix.IN_ISDIR != 0 && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		me.addTree(watchedRoot{filepath.Join(dir.osDir, name), path.Join(dir.fsDir, name)}, true)
+	}
+	me.report(dir.fsDir)
+}
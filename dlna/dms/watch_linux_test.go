@@ -0,0 +1,73 @@
+package dms
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestInotifyWatcher(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "Films", ".hidden"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ignore := func(fsPath string) bool { return strings.HasPrefix(filepath.Base(fsPath), ".") }
+	w, err := newFolderWatcher([]watchedRoot{{dir, "Movies"}}, ignore, log.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// Waits for the folders to be reported, in any order.
+	expect := func(what string, want ...string) {
+		t.Helper()
+		pending := make(map[string]bool)
+		for _, p := range want {
+			pending[p] = true
+		}
+		for len(pending) != 0 {
+			select {
+			case p := <-w.Changes():
+				delete(pending, p)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: %v not reported", what, pending)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Films", "Heat.mkv"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect("file created", "Movies/Films")
+	if err := os.Mkdir(filepath.Join(dir, "Films", "New"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	expect("folder created", "Movies/Films", "Movies/Films/New")
+	if err := os.WriteFile(filepath.Join(dir, "Films", "New", "Ran.mkv"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect("file created in the new folder", "Movies/Films/New")
+	if err := os.WriteFile(filepath.Join(dir, "Films", ".hidden", "x.mkv"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "Films", "Heat.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	// The ignored folder isn't watched, so the removal is what's reported
+	// next, after any more events of the file created before.
+	for {
+		select {
+		case p := <-w.Changes():
+			if p == "Movies/Films" {
+				return
+			}
+			if p != "Movies/Films/New" {
+				t.Fatalf("got %q", p)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("removal not reported")
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package dms
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/anacrolix/log"
+)
+
+func newFolderWatcher(roots []watchedRoot, ignore func(fsPath string) bool, logger log.Logger) (folderWatcher, error) {
+	return nil, fmt.Errorf("not supported on %s", runtime.GOOS)
+}
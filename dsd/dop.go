@@ -0,0 +1,104 @@
+package dsd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// DoP markers, alternated between consecutive PCM frames.
+const (
+	dopMarker0 = 0x05
+	dopMarker1 = 0xfa
+)
+
+// Returns the PCM sample rate that carries the DSD stream as DoP. Each 24 bit
+// PCM sample holds 16 DSD bits.
+func (f Format) DoPSampleRate() int {
+	return f.SampleRate / 16
+}
+
+// Encodes the stream as DoP in a 24 bit WAV container, starting from the given
+// position.
+func DoPWAV(s Stream, start time.Duration) (io.Reader, error) {
+	// Each PCM frame consumes 2 bytes per channel.
+	frameBytes := int64(2 * s.Channels)
+	skipFrames := int64(start.Seconds() * float64(s.DoPSampleRate()))
+	if _, err := io.CopyN(io.Discard, s.Data, skipFrames*frameBytes); err != nil {
+		return nil, err
+	}
+	// Oversized data lengths are tolerated by most readers, as for streams
+	// from pipes.
+	dataSize := uint32(0xffffffff - 36)
+	if s.Length >= 0 {
+		frames := s.Length/2 - skipFrames
+		if frames < 0 {
+			frames = 0
+		}
+		dataSize = uint32(frames * int64(3*s.Channels))
+	}
+	var header bytes.Buffer
+	writeWAVHeader(&header, s.Channels, s.DoPSampleRate(), 24, dataSize)
+	return io.MultiReader(&header, &dopEncoder{
+		r:        s.Data,
+		channels: s.Channels,
+		// Keep the marker phase consistent with where we started.
+		frame: skipFrames,
+	}), nil
+}
+
+func writeWAVHeader(w io.Writer, channels, sampleRate, bitsPerSample int, dataSize uint32) {
+	blockAlign := channels * bitsPerSample / 8
+	w.Write([]byte("RIFF"))
+	binary.Write(w, binary.LittleEndian, dataSize+36)
+	w.Write([]byte("WAVEfmt "))
+	binary.Write(w, binary.LittleEndian, struct {
+		Size          uint32
+		Format        uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}{16, 1, uint16(channels), uint32(sampleRate), uint32(sampleRate * blockAlign), uint16(blockAlign), uint16(bitsPerSample)})
+	w.Write([]byte("data"))
+	binary.Write(w, binary.LittleEndian, dataSize)
+}
+
+// Packs byte-interleaved DSD into little-endian 24 bit DoP frames.
+type dopEncoder struct {
+	r        io.Reader
+	channels int
+	frame    int64
+	in       []byte
+	out      []byte
+}
+
+func (me *dopEncoder) Read(p []byte) (n int, err error) {
+	if len(me.out) == 0 {
+		if me.in == nil {
+			me.in = make([]byte, 2*me.channels)
+		}
+		if _, err = io.ReadFull(me.r, me.in); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// Drop the trailing partial frame.
+				err = io.EOF
+			}
+			return
+		}
+		marker := byte(dopMarker0)
+		if me.frame%2 != 0 {
+			marker = dopMarker1
+		}
+		me.frame++
+		me.out = me.out[:0]
+		for c := 0; c < me.channels; c++ {
+			// The earlier DSD byte is the more significant.
+			me.out = append(me.out, me.in[me.channels+c], me.in[c], marker)
+		}
+	}
+	n = copy(p, me.out)
+	me.out = me.out[n:]
+	return
+}
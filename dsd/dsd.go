@@ -0,0 +1,290 @@
+// Package dsd reads DSD audio from DSF and DSDIFF (DFF) files, and encodes it
+// as DoP (DSD over PCM) for renderers that can play DSD but only accept PCM
+// containers.
+package dsd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Limits on what file headers can ask for, so a malformed file can't make the
+// reader allocate huge buffers.
+const (
+	maxChannels = 8
+	// DSF files use 4096.
+	maxBlockSize = 16 << 10
+	// DFF property chunks hold a few numbers and names.
+	maxDFFPropSize = 64 << 10
+)
+
+// Describes a DSD stream.
+type Format struct {
+	// In bits per second per channel, e.g. 2822400 for DSD64.
+	SampleRate int
+	Channels   int
+	// Bytes of DSD data per channel, or -1 if unknown.
+	Length int64
+}
+
+// Returns the duration of the stream, or -1 if unknown.
+func (f Format) Duration() time.Duration {
+	if f.Length < 0 || f.SampleRate == 0 {
+		return -1
+	}
+	return time.Duration(f.Length * 8 * int64(time.Second) / int64(f.SampleRate))
+}
+
+// A DSD stream, with the data byte-interleaved by channel, most significant
+// bit first.
+type Stream struct {
+	Format
+	// The sample data, channel-interleaved one byte at a time.
+	Data io.Reader
+}
+
+// Parses the header of a DSF or DFF file, returning the stream positioned at
+// the start of the sample data.
+func Open(r io.Reader) (s Stream, err error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return
+	}
+	switch string(magic) {
+	case "DSD ":
+		return openDSF(br)
+	case "FRM8":
+		return openDFF(br)
+	}
+	err = fmt.Errorf("unrecognized dsd file magic %q", magic)
+	return
+}
+
+func openDSF(r io.Reader) (s Stream, err error) {
+	var header struct {
+		ID              [4]byte
+		Size            uint64
+		FileSize        uint64
+		MetadataPointer uint64
+	}
+	if err = binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return
+	}
+	var fmtChunk struct {
+		ID            [4]byte
+		Size          uint64
+		Version       uint32
+		FormatID      uint32
+		ChannelType   uint32
+		Channels      uint32
+		SampleRate    uint32
+		BitsPerSample uint32
+		SampleCount   uint64
+		BlockSize     uint32
+		Reserved      uint32
+	}
+	if err = binary.Read(r, binary.LittleEndian, &fmtChunk); err != nil {
+		return
+	}
+	if string(fmtChunk.ID[:]) != "fmt " {
+		err = fmt.Errorf("expected dsf fmt chunk, got %q", fmtChunk.ID)
+		return
+	}
+	if fmtChunk.FormatID != 0 {
+		err = fmt.Errorf("unsupported dsf format id %d", fmtChunk.FormatID)
+		return
+	}
+	if fmtChunk.Channels == 0 || fmtChunk.BlockSize == 0 {
+		err = errors.New("bad dsf fmt chunk")
+		return
+	}
+	if fmtChunk.Channels > maxChannels || fmtChunk.BlockSize > maxBlockSize {
+		err = fmt.Errorf("unsupported dsf with %d channels and block size %d", fmtChunk.Channels, fmtChunk.BlockSize)
+		return
+	}
+	// Skip any extension of the fmt chunk.
+	if extra := int64(fmtChunk.Size) - 52; extra > 0 {
+		if _, err = io.CopyN(io.Discard, r, extra); err != nil {
+			return
+		}
+	}
+	var dataHeader struct {
+		ID   [4]byte
+		Size uint64
+	}
+	if err = binary.Read(r, binary.LittleEndian, &dataHeader); err != nil {
+		return
+	}
+	if string(dataHeader.ID[:]) != "data" {
+		err = fmt.Errorf("expected dsf data chunk, got %q", dataHeader.ID)
+		return
+	}
+	s.SampleRate = int(fmtChunk.SampleRate)
+	s.Channels = int(fmtChunk.Channels)
+	s.Length = int64((fmtChunk.SampleCount + 7) / 8)
+	s.Data = &dsfInterleaver{
+		r:         io.LimitReader(r, int64(dataHeader.Size)-12),
+		channels:  s.Channels,
+		blockSize: int(fmtChunk.BlockSize),
+		lsbFirst:  fmtChunk.BitsPerSample == 1,
+		remaining: s.Length * int64(s.Channels),
+	}
+	return
+}
+
+// Converts DSF's per channel blocks into byte interleaving.
+type dsfInterleaver struct {
+	r         io.Reader
+	channels  int
+	blockSize int
+	lsbFirst  bool
+	// Bytes of real data left to emit, excluding the padding of the last
+	// block.
+	remaining int64
+	buf       []byte
+	out       []byte
+}
+
+func (me *dsfInterleaver) Read(p []byte) (n int, err error) {
+	if len(me.out) == 0 {
+		if me.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if me.buf == nil {
+			me.buf = make([]byte, me.blockSize*me.channels)
+			me.out = make([]byte, 0, len(me.buf))
+		}
+		if _, err = io.ReadFull(me.r, me.buf); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		me.out = me.out[:0]
+		for i := 0; i < me.blockSize; i++ {
+			for c := 0; c < me.channels; c++ {
+				b := me.buf[c*me.blockSize+i]
+				if me.lsbFirst {
+					b = bits.Reverse8(b)
+				}
+				me.out = append(me.out, b)
+			}
+		}
+		if int64(len(me.out)) > me.remaining {
+			me.out = me.out[:me.remaining]
+		}
+		me.remaining -= int64(len(me.out))
+	}
+	n = copy(p, me.out)
+	me.out = me.out[n:]
+	return
+}
+
+func openDFF(r io.Reader) (s Stream, err error) {
+	var form struct {
+		ID   [4]byte
+		Size uint64
+		Type [4]byte
+	}
+	if err = binary.Read(r, binary.BigEndian, &form); err != nil {
+		return
+	}
+	if string(form.Type[:]) != "DSD " {
+		err = fmt.Errorf("unexpected dff form type %q", form.Type)
+		return
+	}
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint64
+		}
+		if err = binary.Read(r, binary.BigEndian, &chunk); err != nil {
+			return
+		}
+		switch string(chunk.ID[:]) {
+		case "PROP":
+			if chunk.Size > maxDFFPropSize {
+				err = fmt.Errorf("dff property chunk too large: %d bytes", chunk.Size)
+				return
+			}
+			if err = readDFFProp(io.LimitReader(r, int64(chunk.Size)), chunk.Size, &s.Format); err != nil {
+				return
+			}
+		case "DST ":
+			err = errors.New("compressed dff (dst) is not supported")
+			return
+		case "DSD ":
+			if s.Channels == 0 || s.SampleRate == 0 {
+				err = errors.New("dff sample data before properties")
+				return
+			}
+			if s.Channels > maxChannels {
+				err = fmt.Errorf("unsupported dff with %d channels", s.Channels)
+				return
+			}
+			s.Length = int64(chunk.Size) / int64(s.Channels)
+			s.Data = io.LimitReader(r, int64(chunk.Size))
+			return
+		default:
+			if _, err = io.CopyN(io.Discard, r, int64(chunk.Size+chunk.Size%2)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Reads the properties from a PROP chunk of the given size, which is at most
+// maxDFFPropSize.
+func readDFFProp(r io.Reader, size uint64, f *Format) (err error) {
+	var propType [4]byte
+	if _, err = io.ReadFull(r, propType[:]); err != nil {
+		return
+	}
+	// What's left of the PROP chunk, which its chunks must fit in.
+	remaining := size - 4
+	if string(propType[:]) != "SND " {
+		_, err = io.Copy(io.Discard, r)
+		return
+	}
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint64
+		}
+		if err = binary.Read(r, binary.BigEndian, &chunk); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return
+		}
+		remaining -= 12
+		if chunk.Size > remaining {
+			return fmt.Errorf("dff property chunk %q overruns its PROP chunk", chunk.ID)
+		}
+		// The padding byte may be left off the last chunk.
+		data := make([]byte, min(chunk.Size+chunk.Size%2, remaining))
+		remaining -= uint64(len(data))
+		if _, err = io.ReadFull(r, data); err != nil {
+			return
+		}
+		switch string(chunk.ID[:]) {
+		case "FS  ":
+			if len(data) >= 4 {
+				f.SampleRate = int(binary.BigEndian.Uint32(data))
+			}
+		case "CHNL":
+			if len(data) >= 2 {
+				f.Channels = int(binary.BigEndian.Uint16(data))
+			}
+		case "CMPR":
+			if len(data) >= 4 && string(data[:4]) != "DSD " {
+				return fmt.Errorf("unsupported dff compression %q", data[:4])
+			}
+		}
+	}
+}
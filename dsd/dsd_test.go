@@ -0,0 +1,155 @@
+package dsd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// Builds a stereo DSF with the given per channel data, LSB first.
+func makeDSF(blockSize int, left, right []byte) []byte {
+	var b bytes.Buffer
+	blocks := (len(left) + blockSize - 1) / blockSize
+	dataSize := uint64(12 + 2*blocks*blockSize)
+	b.WriteString("DSD ")
+	binary.Write(&b, binary.LittleEndian, []uint64{28, 28 + 52 + dataSize, 0})
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint64(52))
+	binary.Write(&b, binary.LittleEndian, []uint32{1, 0, 2, 2, 2822400, 1})
+	binary.Write(&b, binary.LittleEndian, uint64(len(left)*8))
+	binary.Write(&b, binary.LittleEndian, []uint32{uint32(blockSize), 0})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, dataSize)
+	for i := 0; i < blocks; i++ {
+		for _, ch := range [][]byte{left, right} {
+			block := make([]byte, blockSize)
+			copy(block, ch[i*blockSize:])
+			b.Write(block)
+		}
+	}
+	return b.Bytes()
+}
+
+func TestOpenDSF(t *testing.T) {
+	// 0x01 is 0x80 once reversed to MSB first.
+	s, err := Open(bytes.NewReader(makeDSF(4, []byte{1, 1, 1, 1, 1, 1}, []byte{2, 2, 2, 2, 2, 2})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SampleRate != 2822400 || s.Channels != 2 || s.Length != 6 {
+		t.Fatalf("unexpected format %+v", s.Format)
+	}
+	data, err := io.ReadAll(s.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := bytes.Repeat([]byte{0x80, 0x40}, 6)
+	if !bytes.Equal(data, e) {
+		t.Fatalf("expected %x but got %x", e, data)
+	}
+}
+
+func TestOpenDFF(t *testing.T) {
+	var prop bytes.Buffer
+	prop.WriteString("SND ")
+	prop.WriteString("FS  ")
+	binary.Write(&prop, binary.BigEndian, uint64(4))
+	binary.Write(&prop, binary.BigEndian, uint32(5644800))
+	prop.WriteString("CHNL")
+	binary.Write(&prop, binary.BigEndian, uint64(10))
+	binary.Write(&prop, binary.BigEndian, uint16(2))
+	prop.WriteString("SLFTSRGT")
+	var b bytes.Buffer
+	b.WriteString("FRM8")
+	binary.Write(&b, binary.BigEndian, uint64(0))
+	b.WriteString("DSD ")
+	b.WriteString("FVER")
+	binary.Write(&b, binary.BigEndian, uint64(4))
+	binary.Write(&b, binary.BigEndian, uint32(0x01050000))
+	b.WriteString("PROP")
+	binary.Write(&b, binary.BigEndian, uint64(prop.Len()))
+	b.Write(prop.Bytes())
+	b.WriteString("DSD ")
+	binary.Write(&b, binary.BigEndian, uint64(4))
+	b.Write([]byte{1, 2, 3, 4})
+	s, err := Open(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SampleRate != 5644800 || s.Channels != 2 || s.Length != 2 {
+		t.Fatalf("unexpected format %+v", s.Format)
+	}
+	data, _ := io.ReadAll(s.Data)
+	if !bytes.Equal(data, []byte{1, 2, 3, 4}) {
+		t.Fatalf("unexpected data %x", data)
+	}
+}
+
+// Malformed headers are errors, rather than huge allocations or panics.
+func TestOpenMalformed(t *testing.T) {
+	dsf := func(channels, blockSize uint32) []byte {
+		b := makeDSF(4, []byte{1, 1, 1, 1}, []byte{2, 2, 2, 2})
+		binary.LittleEndian.PutUint32(b[52:], channels)
+		binary.LittleEndian.PutUint32(b[72:], blockSize)
+		return b
+	}
+	dff := func(prop []byte, propSize uint64) []byte {
+		var b bytes.Buffer
+		b.WriteString("FRM8")
+		binary.Write(&b, binary.BigEndian, uint64(0))
+		b.WriteString("DSD ")
+		b.WriteString("PROP")
+		binary.Write(&b, binary.BigEndian, propSize)
+		b.Write(prop)
+		return b.Bytes()
+	}
+	fsChunk := func(size uint64) []byte {
+		var b bytes.Buffer
+		b.WriteString("SND FS  ")
+		binary.Write(&b, binary.BigEndian, size)
+		binary.Write(&b, binary.BigEndian, uint32(2822400))
+		return b.Bytes()
+	}
+	for name, file := range map[string][]byte{
+		"truncated dsf":          makeDSF(4, []byte{1}, []byte{2})[:60],
+		"dsf with 1000 channels": dsf(1000, 4096),
+		"dsf with huge blocks":   dsf(2, 1<<31),
+		"huge dff prop":          dff(fsChunk(4), 1<<62),
+		"dff chunk overrunning":  dff(fsChunk(1<<40), 16),
+		"dff chunk of max size":  dff(fsChunk(1<<64-1), 16),
+		"truncated dff":          dff(fsChunk(4)[:6], 16),
+	} {
+		if _, err := Open(bytes.NewReader(file)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestDoPWAV(t *testing.T) {
+	s := Stream{
+		Format: Format{SampleRate: 2822400, Channels: 2, Length: 4},
+		Data:   bytes.NewReader([]byte{0x11, 0x21, 0x12, 0x22, 0x13, 0x23, 0x14, 0x24}),
+	}
+	r, err := DoPWAV(s, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(r)
+	if len(out) != 44+12 {
+		t.Fatalf("unexpected length %d", len(out))
+	}
+	if rate := binary.LittleEndian.Uint32(out[24:]); rate != 176400 {
+		t.Fatalf("unexpected sample rate %d", rate)
+	}
+	if size := binary.LittleEndian.Uint32(out[40:]); size != 12 {
+		t.Fatalf("unexpected data size %d", size)
+	}
+	e := []byte{
+		0x12, 0x11, 0x05, 0x22, 0x21, 0x05,
+		0x14, 0x13, 0xfa, 0x24, 0x23, 0xfa,
+	}
+	if !bytes.Equal(out[44:], e) {
+		t.Fatalf("expected %x but got %x", e, out[44:])
+	}
+}
@@ -0,0 +1,503 @@
+// Package dvb reads what's on from the service information broadcast in DVB
+// transport streams, such as recordings from TV tuners: the channel's name, and
+// the title, description and times of the programme, from its event
+// information table (EIT).
+package dvb
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Returned when a stream has no event information for its first service.
+var ErrNoEIT = errors.New("no event information")
+
+// A programme, as the EIT describes it. Zero values weren't given.
+type Programme struct {
+	Title       string
+	Description string
+	// The name of the channel, from the service description table.
+	Channel  string
+	Start    time.Time
+	Duration time.Duration
+}
+
+func (p Programme) End() time.Time {
+	return p.Start.Add(p.Duration)
+}
+
+const (
+	patPID = 0x00
+	sdtPID = 0x11
+	eitPID = 0x12
+	tdtPID = 0x14
+
+	patTableID   = 0x00
+	sdtTableID   = 0x42
+	eitPFTableID = 0x4e
+	tdtTableID   = 0x70
+	totTableID   = 0x73
+
+	serviceDescriptorTag       = 0x48
+	shortEventDescriptorTag    = 0x4d
+	extendedEventDescriptorTag = 0x4e
+)
+
+// Recordings often start a few minutes early, so the programme on when they
+// start is passed over for the next one if it ends within this long.
+const earlyStart = 10 * time.Minute
+
+// Reads the programme being recorded from the start of a transport stream,
+// of 188 byte packets, or 192 byte ones with timecodes as in M2TS. The stream
+// is read until the programme is known, or it ends, so it's best limited.
+func Read(r io.Reader) (p Programme, err error) {
+	br := bufio.NewReaderSize(r, 2*192)
+	packetSize, err := detectPacketSize(br)
+	if err != nil {
+		return
+	}
+	var si serviceInformation
+	si.init()
+	packet := make([]byte, packetSize)
+	for !si.complete() {
+		if _, err = io.ReadFull(br, packet); err != nil {
+			break
+		}
+		si.packet(packet[packetSize-188:])
+	}
+	p, ok := si.programme()
+	if !ok {
+		return p, ErrNoEIT
+	}
+	return p, nil
+}
+
+// Works out the packet size from where the sync bytes are.
+func detectPacketSize(br *bufio.Reader) (int, error) {
+	b, err := br.Peek(2 * 192)
+	for _, size := range []int{188, 192} {
+		sync := size - 188
+		if len(b) >= size+sync+1 && b[sync] == 0x47 && b[size+sync] == 0x47 {
+			return size, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("not a transport stream")
+	}
+	return 0, err
+}
+
+type event struct {
+	title, description string
+	start              time.Time
+	duration           time.Duration
+}
+
+// Tables gathered from the stream.
+type serviceInformation struct {
+	sections map[uint16]*sectionAssembler
+	// The first service in the program association table.
+	serviceID    uint16
+	haveService  bool
+	serviceNames map[uint16]string
+	// The present and following events of each service.
+	events map[uint16]*[2]*event
+	// The time the stream was broadcast, from the time and date table.
+	now time.Time
+}
+
+func (me *serviceInformation) init() {
+	me.sections = make(map[uint16]*sectionAssembler)
+	for _, pid := range []uint16{patPID, sdtPID, eitPID, tdtPID} {
+		me.sections[pid] = &sectionAssembler{}
+	}
+	me.serviceNames = make(map[uint16]string)
+	me.events = make(map[uint16]*[2]*event)
+}
+
+// Reports whether there's nothing more to learn.
+func (me *serviceInformation) complete() bool {
+	if !me.haveService || me.now.IsZero() {
+		return false
+	}
+	_, named := me.serviceNames[me.serviceID]
+	pf := me.events[me.serviceID]
+	return named && pf != nil && pf[0] != nil && pf[1] != nil
+}
+
+func (me *serviceInformation) programme() (p Programme, ok bool) {
+	if !me.haveService {
+		return
+	}
+	pf := me.events[me.serviceID]
+	if pf == nil {
+		return
+	}
+	e := pf[0]
+	if e == nil || pf[1] != nil && !me.now.IsZero() && e.start.Add(e.duration).Sub(me.now) < earlyStart {
+		e = pf[1]
+	}
+	p = Programme{
+		Title:       e.title,
+		Description: e.description,
+		Channel:     me.serviceNames[me.serviceID],
+		Start:       e.start,
+		Duration:    e.duration,
+	}
+	return p, true
+}
+
+// Handles a 188 byte transport stream packet.
+func (me *serviceInformation) packet(b []byte) {
+	if b[0] != 0x47 || b[1]&0x80 != 0 {
+		return
+	}
+	pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+	sa := me.sections[pid]
+	if sa == nil {
+		return
+	}
+	payload := b[4:]
+	switch b[3] >> 4 & 3 {
+	case 1:
+	case 3:
+		if int(payload[0]) >= len(payload) {
+			return
+		}
+		payload = payload[1+payload[0]:]
+	default:
+		return
+	}
+	for _, s := range sa.payload(payload, b[1]&0x40 != 0) {
+		me.section(s)
+	}
+}
+
+func (me *serviceInformation) section(s []byte) {
+	switch s[0] {
+	case tdtTableID:
+		if len(s) >= 8 {
+			me.now, _ = parseTime(s[3:8])
+		}
+		return
+	case totTableID:
+		// Its descriptors are followed by a CRC, unlike the TDT.
+		if len(s) >= 8 && crc32(s) == 0 {
+			me.now, _ = parseTime(s[3:8])
+		}
+		return
+	}
+	// The other tables have the long section syntax, with a CRC.
+	if len(s) < 12 || s[1]&0x80 == 0 || crc32(s) != 0 {
+		return
+	}
+	// Tables being replaced by a new version aren't current.
+	if s[5]&1 == 0 {
+		return
+	}
+	body := s[8 : len(s)-4]
+	switch s[0] {
+	case patTableID:
+		for ; len(body) >= 4 && !me.haveService; body = body[4:] {
+			if n := uint16(body[0])<<8 | uint16(body[1]); n != 0 {
+				me.serviceID, me.haveService = n, true
+			}
+		}
+	case sdtTableID:
+		me.sdt(body)
+	case eitPFTableID:
+		me.eit(uint16(s[3])<<8|uint16(s[4]), s[6], body)
+	}
+}
+
+func (me *serviceInformation) sdt(b []byte) {
+	if len(b) < 3 {
+		return
+	}
+	for b = b[3:]; len(b) >= 5; {
+		id := uint16(b[0])<<8 | uint16(b[1])
+		n := int(b[3]&0x0f)<<8 | int(b[4])
+		if 5+n > len(b) {
+			return
+		}
+		forDescriptors(b[5:5+n], func(tag byte, d []byte) {
+			if tag != serviceDescriptorTag || len(d) < 2 {
+				return
+			}
+			provider := int(d[1])
+			if 3+provider > len(d) {
+				return
+			}
+			name := int(d[2+provider])
+			if 3+provider+name <= len(d) {
+				me.serviceNames[id] = strings.TrimSpace(decodeText(d[3+provider : 3+provider+name]))
+			}
+		})
+		b = b[5+n:]
+	}
+}
+
+func (me *serviceInformation) eit(serviceID uint16, sectionNumber byte, b []byte) {
+	if sectionNumber > 1 || len(b) < 6 {
+		return
+	}
+	b = b[6:]
+	if len(b) < 12 {
+		return
+	}
+	var e event
+	e.start, _ = parseTime(b[2:7])
+	e.duration = parseDuration(b[7:10])
+	n := int(b[10]&0x0f)<<8 | int(b[11])
+	if 12+n > len(b) {
+		return
+	}
+	var extended []string
+	forDescriptors(b[12:12+n], func(tag byte, d []byte) {
+		switch tag {
+		case shortEventDescriptorTag:
+			if len(d) < 4 {
+				return
+			}
+			nameLen := int(d[3])
+			if 5+nameLen > len(d) {
+				return
+			}
+			e.title = strings.TrimSpace(decodeText(d[4 : 4+nameLen]))
+			textLen := int(d[4+nameLen])
+			if 5+nameLen+textLen <= len(d) {
+				e.description = strings.TrimSpace(decodeText(d[5+nameLen : 5+nameLen+textLen]))
+			}
+		case extendedEventDescriptorTag:
+			// Longer descriptions are split across descriptors, after
+			// any items.
+			if len(d) < 5 || 5+int(d[4]) >= len(d) {
+				return
+			}
+			text := d[5+int(d[4]):]
+			if 1+int(text[0]) <= len(text) {
+				extended = append(extended, decodeText(text[1:1+text[0]]))
+			}
+		}
+	})
+	if len(extended) != 0 {
+		e.description = strings.TrimSpace(strings.Join(extended, ""))
+	}
+	if me.events[serviceID] == nil {
+		me.events[serviceID] = new([2]*event)
+	}
+	me.events[serviceID][sectionNumber] = &e
+}
+
+func forDescriptors(b []byte, f func(tag byte, d []byte)) {
+	for len(b) >= 2 {
+		n := int(b[1])
+		if 2+n > len(b) {
+			return
+		}
+		f(b[0], b[2:2+n])
+		b = b[2+n:]
+	}
+}
+
+// Reassembles the sections of a PID from its packets' payloads.
+type sectionAssembler struct {
+	buf []byte
+	// Whether buf holds the start of a section.
+	started bool
+}
+
+// Returns the sections completed by the payload. Sections start at the pointer
+// field of payloads that have one.
+func (me *sectionAssembler) payload(b []byte, unitStart bool) (sections [][]byte) {
+	if unitStart {
+		if len(b) == 0 || 1+int(b[0]) > len(b) {
+			me.buf, me.started = nil, false
+			return
+		}
+		if me.started {
+			me.buf = append(me.buf, b[1:1+b[0]]...)
+			sections = me.sections()
+		}
+		me.buf = append(me.buf[:0], b[1+b[0]:]...)
+		me.started = true
+	} else if me.started {
+		me.buf = append(me.buf, b...)
+	}
+	return append(sections, me.sections()...)
+}
+
+func (me *sectionAssembler) sections() (ret [][]byte) {
+	for me.started && len(me.buf) >= 3 {
+		if me.buf[0] == 0xff {
+			// Stuffing to the end of the packet.
+			me.buf, me.started = nil, false
+			break
+		}
+		n := 3 + (int(me.buf[1]&0x0f)<<8 | int(me.buf[2]))
+		if len(me.buf) < n {
+			break
+		}
+		ret = append(ret, append([]byte(nil), me.buf[:n]...))
+		me.buf = me.buf[n:]
+	}
+	return
+}
+
+// The MPEG-2 CRC-32, which is zero over a section ending in its CRC.
+func crc32(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, c := range b {
+		crc ^= uint32(c) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Parses a UTC time as a Modified Julian Date and BCD hours, minutes and
+// seconds. All ones means it's undefined.
+func parseTime(b []byte) (time.Time, bool) {
+	mjd := int(b[0])<<8 | int(b[1])
+	if mjd == 0xffff {
+		return time.Time{}, false
+	}
+	// MJD 0 is 17 November 1858.
+	t := time.Date(1858, 11, 17, 0, 0, 0, 0, time.UTC).AddDate(0, 0, mjd)
+	return t.Add(parseDuration(b[2:5])), true
+}
+
+func parseDuration(b []byte) time.Duration {
+	bcd := func(b byte) time.Duration {
+		return time.Duration(b>>4*10 + b&0x0f)
+	}
+	return bcd(b[0])*time.Hour + bcd(b[1])*time.Minute + bcd(b[2])*time.Second
+}
+
+// Character tables selected by a text's first byte.
+var textTables = map[byte]encoding.Encoding{
+	0x01: charmap.ISO8859_5,
+	0x02: charmap.ISO8859_6,
+	0x03: charmap.ISO8859_7,
+	0x04: charmap.ISO8859_8,
+	0x05: charmap.ISO8859_9,
+	0x06: charmap.ISO8859_10,
+	0x07: charmap.Windows874,
+	0x09: charmap.ISO8859_13,
+	0x0a: charmap.ISO8859_14,
+	0x0b: charmap.ISO8859_15,
+	0x11: unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+}
+
+// The ISO 8859 parts selected by 0x10 and a 16 bit number.
+var iso8859 = map[byte]encoding.Encoding{
+	1:  charmap.ISO8859_1,
+	2:  charmap.ISO8859_2,
+	3:  charmap.ISO8859_3,
+	4:  charmap.ISO8859_4,
+	5:  charmap.ISO8859_5,
+	6:  charmap.ISO8859_6,
+	7:  charmap.ISO8859_7,
+	8:  charmap.ISO8859_8,
+	9:  charmap.ISO8859_9,
+	10: charmap.ISO8859_10,
+	13: charmap.ISO8859_13,
+	14: charmap.ISO8859_14,
+	15: charmap.ISO8859_15,
+	16: charmap.ISO8859_16,
+}
+
+// Decodes DVB text, which is in the character table its first byte selects,
+// or ISO 6937 by default. The control codes for emphasis are dropped, and
+// line breaks kept. Texts split across descriptors are decoded separately,
+// so the spaces around them are left alone.
+func decodeText(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	var s string
+	switch {
+	case b[0] == 0x15:
+		s = strings.ToValidUTF8(string(b[1:]), "")
+	case b[0] == 0x10 && len(b) >= 3:
+		if enc, ok := iso8859[b[2]]; ok && b[1] == 0 {
+			s, _ = enc.NewDecoder().String(string(b[3:]))
+		} else {
+			s = decodeISO6937(b[3:])
+		}
+	case b[0] < 0x20:
+		if enc, ok := textTables[b[0]]; ok {
+			s, _ = enc.NewDecoder().String(string(b[1:]))
+		} else {
+			s = decodeISO6937(b[1:])
+		}
+	default:
+		s = decodeISO6937(b)
+	}
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == 0x8a:
+			return '\n'
+		case r >= 0x80 && r < 0xa0:
+			return -1
+		}
+		return r
+	}, s)
+	return s
+}
+
+// Combining diacritical marks that precede the letters they're on in ISO
+// 6937.
+var iso6937Diacritics = map[byte]rune{
+	0xc1: 0x300, 0xc2: 0x301, 0xc3: 0x302, 0xc4: 0x303, 0xc5: 0x304,
+	0xc6: 0x306, 0xc7: 0x307, 0xc8: 0x308, 0xca: 0x30a, 0xcb: 0x327,
+	0xcd: 0x30b, 0xce: 0x328, 0xcf: 0x30c,
+}
+
+// Other ISO 6937 characters, where they differ from Latin-1.
+var iso6937 = map[byte]rune{
+	0xa4: '$', 0xa6: '#', 0xa8: '¤', 0xa9: '‘', 0xaa: '“', 0xac: '←',
+	0xad: '↑', 0xae: '→', 0xaf: '↓', 0xb4: '×', 0xb8: '÷', 0xb9: '’',
+	0xba: '”', 0xbc: '↖', 0xd0: '―', 0xd1: '¹', 0xd2: '®', 0xd3: '©',
+	0xd4: '™', 0xd5: '♪', 0xe0: 'Ω', 0xe1: 'Æ', 0xe2: 'Đ', 0xe3: 'ª',
+	0xe4: 'Ħ', 0xe6: 'Ĳ', 0xe7: 'Ŀ', 0xe8: 'Ł', 0xe9: 'Ø', 0xea: 'Œ',
+	0xeb: 'º', 0xec: 'Þ', 0xed: 'Ŧ', 0xee: 'Ŋ', 0xef: 'ŉ', 0xf0: 'ĸ',
+	0xf1: 'æ', 0xf2: 'đ', 0xf3: 'ð', 0xf4: 'ħ', 0xf5: 'ı', 0xf6: 'ĳ',
+	0xf7: 'ŀ', 0xf8: 'ł', 0xf9: 'ø', 0xfa: 'œ', 0xfb: 'ß', 0xfc: 'þ',
+	0xfd: 'ŧ', 0xfe: 'ŋ', 0xff: 0xad,
+}
+
+func decodeISO6937(b []byte) string {
+	buf := make([]byte, 0, len(b))
+	var mark rune
+	for _, c := range b {
+		if m, ok := iso6937Diacritics[c]; ok {
+			mark = m
+			continue
+		}
+		r := rune(c)
+		if m, ok := iso6937[c]; ok {
+			r = m
+		}
+		buf = utf8.AppendRune(buf, r)
+		if mark != 0 {
+			buf = utf8.AppendRune(buf, mark)
+			mark = 0
+		}
+	}
+	return norm.NFC.String(string(buf))
+}
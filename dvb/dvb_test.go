@@ -0,0 +1,132 @@
+package dvb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Returns a long form section of the table, with its CRC.
+func section(tableID byte, idExtension uint16, number byte, body []byte) []byte {
+	s := []byte{tableID, 0, 0, byte(idExtension >> 8), byte(idExtension), 0xc1, number, 1}
+	s = append(s, body...)
+	n := len(s) - 3 + 4
+	s[1], s[2] = 0xb0|byte(n>>8), byte(n)
+	return binary.BigEndian.AppendUint32(s, crc32(s))
+}
+
+// Splits the section into packets of the PID, as 188 or 192 byte packets.
+func packets(pid uint16, s []byte, size int) (ret []byte) {
+	payload := append([]byte{0}, s...)
+	for i := 0; len(payload) != 0; i++ {
+		p := make([]byte, size-188, size)
+		flags := byte(0)
+		if i == 0 {
+			flags = 0x40
+		}
+		p = append(p, 0x47, flags|byte(pid>>8), byte(pid), 0x10)
+		n := copy(p[len(p):size], payload)
+		p = p[:len(p)+n]
+		payload = payload[n:]
+		for len(p) < size {
+			p = append(p, 0xff)
+		}
+		ret = append(ret, p...)
+	}
+	return
+}
+
+func mjdTime(t time.Time) []byte {
+	mjd := int(t.Sub(time.Date(1858, 11, 17, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+	return append([]byte{byte(mjd >> 8), byte(mjd)}, bcdDuration(t.Sub(t.Truncate(24*time.Hour)))...)
+}
+
+func bcdDuration(d time.Duration) []byte {
+	bcd := func(n int) byte { return byte(n/10<<4 | n%10) }
+	return []byte{bcd(int(d.Hours())), bcd(int(d.Minutes()) % 60), bcd(int(d.Seconds()) % 60)}
+}
+
+func descriptor(tag byte, b ...[]byte) []byte {
+	d := bytes.Join(b, nil)
+	return append([]byte{tag, byte(len(d))}, d...)
+}
+
+func eventSection(serviceID uint16, number byte, start time.Time, duration time.Duration, descriptors []byte) []byte {
+	body := []byte{0, 1, 0, 2, number, 0x4e}
+	body = append(body, 0, byte(number))
+	body = append(body, mjdTime(start)...)
+	body = append(body, bcdDuration(duration)...)
+	body = append(body, 0x80|byte(len(descriptors)>>8), byte(len(descriptors)))
+	return section(eitPFTableID, serviceID, number, append(body, descriptors...))
+}
+
+func shortEvent(title, text string) []byte {
+	return descriptor(shortEventDescriptorTag, []byte("eng"), []byte{byte(len(title))}, []byte(title), []byte{byte(len(text))}, []byte(text))
+}
+
+func testStream(size int) []byte {
+	start := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	var ts []byte
+	ts = append(ts, packets(patPID, section(patTableID, 1, 0, []byte{0, 0, 0xe0, 0x10, 0x10, 0x44, 0xe1, 0x00, 0x10, 0x45, 0xe2, 0x00}), size)...)
+	// Another service's event comes first.
+	ts = append(ts, packets(eitPID, eventSection(0x1045, 0, start, time.Hour, shortEvent("Other", "")), size)...)
+	ts = append(ts, packets(eitPID, eventSection(0x1044, 0, start.Add(-time.Hour), 63*time.Minute, shortEvent("Earlier", "")), size)...)
+	extended := append(
+		descriptor(extendedEventDescriptorTag, []byte{0x01}, []byte("eng"), []byte{0}, []byte{10}, []byte("Anne goes ")),
+		descriptor(extendedEventDescriptorTag, []byte{0x11}, []byte("eng"), []byte{0}, []byte{14}, []byte("\x15to the beach."))...)
+	ts = append(ts, packets(eitPID, eventSection(0x1044, 1, start.Add(3*time.Minute), 57*time.Minute, append(shortEvent("\x15Anne’s Summer", "Drama"), extended...)), size)...)
+	sdt := []byte{0, 2, 0xff, 0x10, 0x44, 0xfc, 0x80, 0}
+	service := descriptor(serviceDescriptorTag, []byte{1, 3}, []byte("BBC"), []byte{10}, []byte("BBC ONE HD"))
+	sdt[7] = byte(len(service))
+	ts = append(ts, packets(sdtPID, section(sdtTableID, 1, 0, append(sdt, service...)), size)...)
+	tdt := append([]byte{tdtTableID, 0x70, 5}, mjdTime(start.Add(-2*time.Minute))...)
+	ts = append(ts, packets(tdtPID, tdt, size)...)
+	return ts
+}
+
+func TestRead(t *testing.T) {
+	for _, size := range []int{188, 192} {
+		p, err := Read(bytes.NewReader(testStream(size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The present programme ends a minute after the recording starts,
+		// so it's the following one.
+		want := Programme{
+			Title:       "Anne’s Summer",
+			Description: "Anne goes to the beach.",
+			Channel:     "BBC ONE HD",
+			Start:       time.Date(2024, 3, 1, 20, 3, 0, 0, time.UTC),
+			Duration:    57 * time.Minute,
+		}
+		if p != want {
+			t.Errorf("%d byte packets: got %+v", size, p)
+		}
+	}
+	if _, err := Read(bytes.NewReader(testStream(188)[:2*188])); !errors.Is(err, ErrNoEIT) {
+		t.Errorf("got %v", err)
+	}
+	if _, err := Read(bytes.NewReader(make([]byte, 1000))); err == nil || errors.Is(err, ErrNoEIT) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"Plain", "Plain"},
+		{"Caf\xc2e \x86News\x87\x8aat 9", "Café News\nat 9"},
+		{"\x15Grüße", "Grüße"},
+		{"\x10\x00\x02\xb6wiat", "świat"},
+		{"\x05\xfeeker", "şeker"},
+		{"\x11\x00A\x00\xe9", "Aé"},
+	} {
+		if got := decodeText([]byte(tc.in)); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+// Package exif reads when, with what and where photos were taken from the
+// EXIF metadata of JPEG and TIFF images, such as photos from phones and
+// cameras.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Returned when an image has no GPS position.
+var ErrNoGPS = errors.New("no GPS position")
+
+// What an image's metadata says about it. Zero values weren't given.
+type Metadata struct {
+	// When the photo was taken, in the camera's time zone, which EXIF
+	// doesn't record, so it's given as UTC.
+	Time time.Time
+	// The camera's maker and model, such as "Canon" and "Canon EOS R5".
+	Make, Model string
+	// The size in pixels.
+	Width, Height int
+	// The GPS position in degrees, if HasGPS.
+	Lat, Lon float64
+	HasGPS   bool
+}
+
+// Returns the maker and model of the camera as one name, without the maker
+// repeated if the model already starts with it.
+func (m Metadata) Camera() string {
+	if m.Make == "" || strings.HasPrefix(strings.ToLower(m.Model), strings.ToLower(m.Make)) {
+		return m.Model
+	}
+	if m.Model == "" {
+		return m.Make
+	}
+	return m.Make + " " + m.Model
+}
+
+// How much of an image is read looking for the metadata. JPEG keeps it in a
+// segment of at most 64KiB near the start, and TIFF usually before the
+// pixels.
+const maxMetadataSize = 256 << 10
+
+const (
+	imageWidthTag       = 0x0100
+	imageLengthTag      = 0x0101
+	makeTag             = 0x010f
+	modelTag            = 0x0110
+	dateTimeTag         = 0x0132
+	exifIFDTag          = 0x8769
+	gpsIFDTag           = 0x8825
+	dateTimeOriginalTag = 0x9003
+	pixelXDimensionTag  = 0xa002
+	pixelYDimensionTag  = 0xa003
+	latRefTag           = 1
+	latTag              = 2
+	lonRefTag           = 3
+	lonTag              = 4
+	asciiType           = 2
+	shortType           = 3
+	longType            = 4
+	rationalType        = 5
+	exifDateTimeLayout  = "2006:01:02 15:04:05"
+)
+
+// Returns the latitude and longitude, in degrees, where the JPEG or TIFF image
+// was taken.
+func GPS(r io.Reader) (lat, lon float64, err error) {
+	m, err := Read(r)
+	if err != nil {
+		return
+	}
+	if !m.HasGPS {
+		return 0, 0, ErrNoGPS
+	}
+	return m.Lat, m.Lon, nil
+}
+
+// Returns the metadata of a JPEG or TIFF image. A JPEG without EXIF metadata
+// still has its size.
+func Read(r io.Reader) (m Metadata, err error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxMetadataSize))
+	if err != nil {
+		return
+	}
+	tiff, err := findTIFF(b, &m)
+	if err != nil || tiff == nil {
+		return
+	}
+	err = readTIFF(tiff, &m)
+	return
+}
+
+// Returns the TIFF structure holding the metadata: the file itself for TIFF,
+// or the contents of the Exif APP1 segment for JPEG, which may have none. A
+// JPEG's size is taken from its frame header.
+func findTIFF(b []byte, m *Metadata) (tiff []byte, err error) {
+	if bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*")) {
+		return b, nil
+	}
+	if !bytes.HasPrefix(b, []byte{0xff, 0xd8}) {
+		return nil, errors.New("not a JPEG or TIFF image")
+	}
+	for b = b[2:]; len(b) >= 4 && b[0] == 0xff; {
+		marker := b[1]
+		// Start of scan, after which there's only image data.
+		if marker == 0xda {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		if n < 2 || 2+n > len(b) {
+			break
+		}
+		payload := b[4 : 2+n]
+		switch {
+		case marker == 0xe1 && tiff == nil && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			tiff = payload[6:]
+		case isStartOfFrame(marker) && len(payload) >= 5:
+			m.Height = int(binary.BigEndian.Uint16(payload[1:]))
+			m.Width = int(binary.BigEndian.Uint16(payload[3:]))
+		}
+		b = b[2+n:]
+	}
+	return
+}
+
+// Reports whether a JPEG marker starts a frame, whose header gives the size
+// of the image. DHT, JPG and DAC share the range.
+func isStartOfFrame(marker byte) bool {
+	return marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc
+}
+
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	// The value, if it fits, or the offset of it.
+	value []byte
+}
+
+type tiffReader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+func (me tiffReader) ifd(offset uint32) (ret []ifdEntry, err error) {
+	if uint64(offset)+2 > uint64(len(me.b)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	n := int(me.order.Uint16(me.b[offset:]))
+	p := int(offset) + 2
+	if p+12*n > len(me.b) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	for range n {
+		e := me.b[p : p+12]
+		ret = append(ret, ifdEntry{me.order.Uint16(e), me.order.Uint16(e[2:]), me.order.Uint32(e[4:]), e[8:12]})
+		p += 12
+	}
+	return
+}
+
+// Returns the bytes of the entry's value.
+func (me tiffReader) data(e ifdEntry) ([]byte, error) {
+	var size uint64
+	switch e.typ {
+	case asciiType:
+		size = 1
+	case shortType:
+		size = 2
+	case longType:
+		size = 4
+	case rationalType:
+		size = 8
+	default:
+		return nil, errors.New("unexpected type")
+	}
+	size *= uint64(e.count)
+	if size <= 4 {
+		return e.value[:size], nil
+	}
+	offset := uint64(me.order.Uint32(e.value))
+	if offset+size > uint64(len(me.b)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return me.b[offset : offset+size], nil
+}
+
+// Returns degrees from the degrees, minutes and seconds of a GPS coordinate.
+func (me tiffReader) degrees(e ifdEntry) (float64, error) {
+	if e.typ != rationalType || e.count != 3 {
+		return 0, errors.New("bad coordinate")
+	}
+	b, err := me.data(e)
+	if err != nil {
+		return 0, err
+	}
+	var ret float64
+	for i, unit := range []float64{1, 60, 3600} {
+		num, den := me.order.Uint32(b[8*i:]), me.order.Uint32(b[8*i+4:])
+		if den == 0 {
+			if num == 0 {
+				continue
+			}
+			return 0, errors.New("bad coordinate")
+		}
+		ret += float64(num) / float64(den) / unit
+	}
+	return ret, nil
+}
+
+// Returns the value of a single SHORT or LONG entry, such as a size.
+func (me tiffReader) uint(e ifdEntry) (int, bool) {
+	if e.count != 1 {
+		return 0, false
+	}
+	switch e.typ {
+	case shortType:
+		return int(me.order.Uint16(e.value)), true
+	case longType:
+		return int(me.order.Uint32(e.value)), true
+	}
+	return 0, false
+}
+
+// Returns the text of an ASCII entry, without its terminating NULs and
+// padding.
+func (me tiffReader) string(e ifdEntry) string {
+	if e.typ != asciiType {
+		return ""
+	}
+	b, err := me.data(e)
+	if err != nil {
+		return ""
+	}
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func (me tiffReader) time(e ifdEntry) time.Time {
+	// Cameras without a clock set write zeros or spaces, which don't parse.
+	t, _ := time.Parse(exifDateTimeLayout, me.string(e))
+	return t
+}
+
+func readTIFF(b []byte, m *Metadata) error {
+	if len(b) < 8 {
+		return io.ErrUnexpectedEOF
+	}
+	r := tiffReader{b, binary.LittleEndian}
+	if b[0] == 'M' {
+		r.order = binary.BigEndian
+	}
+	ifd0, err := r.ifd(r.order.Uint32(b[4:]))
+	if err != nil {
+		return err
+	}
+	// Sizes in a TIFF's own IFD0, or a JPEG's frame header, are of the
+	// pixels themselves, so are preferred to the EXIF ones.
+	width, height := m.Width, m.Height
+	var exifIFD, gps []ifdEntry
+	for _, e := range ifd0 {
+		switch e.tag {
+		case exifIFDTag, gpsIFDTag:
+			if e.typ != longType {
+				continue
+			}
+			ifd, err := r.ifd(r.order.Uint32(e.value))
+			if err != nil {
+				return err
+			}
+			if e.tag == exifIFDTag {
+				exifIFD = ifd
+			} else {
+				gps = ifd
+			}
+		case makeTag:
+			m.Make = r.string(e)
+		case modelTag:
+			m.Model = r.string(e)
+		case dateTimeTag:
+			m.Time = r.time(e)
+		case imageWidthTag:
+			if v, ok := r.uint(e); ok && width == 0 {
+				m.Width = v
+			}
+		case imageLengthTag:
+			if v, ok := r.uint(e); ok && height == 0 {
+				m.Height = v
+			}
+		}
+	}
+	for _, e := range exifIFD {
+		switch e.tag {
+		case dateTimeOriginalTag:
+			// When the photo was taken, rather than last changed.
+			if t := r.time(e); !t.IsZero() {
+				m.Time = t
+			}
+		case pixelXDimensionTag:
+			if v, ok := r.uint(e); ok && width == 0 {
+				m.Width = v
+			}
+		case pixelYDimensionTag:
+			if v, ok := r.uint(e); ok && height == 0 {
+				m.Height = v
+			}
+		}
+	}
+	if len(gps) == 0 {
+		return nil
+	}
+	lat, lon, err := r.gps(gps)
+	if err == ErrNoGPS {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.Lat, m.Lon, m.HasGPS = lat, lon, true
+	return nil
+}
+
+// Returns the position given by the entries of a GPS IFD.
+func (me tiffReader) gps(gps []ifdEntry) (lat, lon float64, err error) {
+	var latRef, lonRef string
+	haveLat, haveLon := false, false
+	for _, e := range gps {
+		switch e.tag {
+		case latRefTag, lonRefTag:
+			if e.typ != asciiType {
+				continue
+			}
+			v, err := me.data(e)
+			if err != nil || len(v) == 0 {
+				continue
+			}
+			if e.tag == latRefTag {
+				latRef = string(v[:1])
+			} else {
+				lonRef = string(v[:1])
+			}
+		case latTag:
+			if lat, err = me.degrees(e); err != nil {
+				return
+			}
+			haveLat = true
+		case lonTag:
+			if lon, err = me.degrees(e); err != nil {
+				return
+			}
+			haveLon = true
+		}
+	}
+	if !haveLat || !haveLon || latRef == "" || lonRef == "" {
+		return 0, 0, ErrNoGPS
+	}
+	if latRef == "S" {
+		lat = -lat
+	}
+	if lonRef == "W" {
+		lon = -lon
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, errors.New("coordinates out of range")
+	}
+	return
+}
@@ -0,0 +1,169 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// Returns a TIFF structure with a GPS IFD holding the position.
+func testTIFF(order binary.ByteOrder, latRef string, lat [6]uint32, lonRef string, lon [6]uint32) []byte {
+	var b bytes.Buffer
+	if order == binary.LittleEndian {
+		b.WriteString("II*\x00")
+	} else {
+		b.WriteString("MM\x00*")
+	}
+	w := func(v any) { binary.Write(&b, order, v) }
+	entry := func(tag, typ uint16, count uint32, value []byte) {
+		w(tag)
+		w(typ)
+		w(count)
+		b.Write(append(value, make([]byte, 4-len(value))...))
+	}
+	offset := func(v uint32) []byte {
+		b := make([]byte, 4)
+		order.PutUint32(b, v)
+		return b
+	}
+	// IFD0 at 8, with one entry, is 18 bytes, and the GPS IFD after it, with
+	// four, is 54, so the rationals start at 80.
+	w(uint32(8))
+	w(uint16(1))
+	entry(gpsIFDTag, longType, 1, offset(26))
+	w(uint32(0))
+	w(uint16(4))
+	entry(latRefTag, asciiType, 2, []byte(latRef+"\x00"))
+	entry(latTag, rationalType, 3, offset(80))
+	entry(lonRefTag, asciiType, 2, []byte(lonRef+"\x00"))
+	entry(lonTag, rationalType, 3, offset(104))
+	w(uint32(0))
+	w(lat)
+	w(lon)
+	return b.Bytes()
+}
+
+func testJPEG(tiff []byte) []byte {
+	b := []byte{0xff, 0xd8}
+	// A JFIF segment first, as usual.
+	b = append(b, 0xff, 0xe0, 0, 7, 'J', 'F', 'I', 'F', 0)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	b = append(b, 0xff, 0xe1)
+	b = binary.BigEndian.AppendUint16(b, uint16(2+len(app1)))
+	b = append(b, app1...)
+	return append(b, 0xff, 0xda, 0, 2, 1, 2, 3)
+}
+
+func TestGPS(t *testing.T) {
+	// The Eiffel Tower, 48°51'29.6"N 2°17'40.2"E.
+	paris := [6]uint32{48, 1, 51, 1, 296, 10}
+	parisLon := [6]uint32{2, 1, 17, 1, 402, 10}
+	for _, test := range []struct {
+		name     string
+		image    []byte
+		lat, lon float64
+	}{
+		{"jpeg", testJPEG(testTIFF(binary.BigEndian, "N", paris, "E", parisLon)), 48.858222, 2.294500},
+		{"tiff", testTIFF(binary.LittleEndian, "N", paris, "E", parisLon), 48.858222, 2.294500},
+		// Rio de Janeiro, with degrees only.
+		{"south west", testJPEG(testTIFF(binary.LittleEndian, "S", [6]uint32{22, 1, 0, 0, 0, 0}, "W", [6]uint32{43, 1, 0, 0, 0, 0})), -22, -43},
+	} {
+		lat, lon, err := GPS(bytes.NewReader(test.image))
+		if err != nil || math.Abs(lat-test.lat) > 1e-6 || math.Abs(lon-test.lon) > 1e-6 {
+			t.Errorf("%s: got %v, %v, %v", test.name, lat, lon, err)
+		}
+	}
+}
+
+func TestNoGPS(t *testing.T) {
+	if _, _, err := GPS(bytes.NewReader([]byte{0xff, 0xd8, 0xff, 0xda, 0, 2})); !errors.Is(err, ErrNoGPS) {
+		t.Errorf("got %v", err)
+	}
+	// Truncated.
+	b := testJPEG(testTIFF(binary.BigEndian, "N", [6]uint32{1, 1}, "E", [6]uint32{1, 1}))
+	if _, _, err := GPS(bytes.NewReader(b[:60])); err == nil {
+		t.Error("no error")
+	}
+	if _, _, err := GPS(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("no error")
+	}
+}
+
+// Returns a big endian TIFF structure with IFD0 holding the make, model and
+// modification time, and an Exif IFD with the time taken and size.
+func testMetadataTIFF() []byte {
+	var b bytes.Buffer
+	order := binary.BigEndian
+	w := func(v any) { binary.Write(&b, order, v) }
+	entry := func(tag, typ uint16, count uint32, value uint32) {
+		w(tag)
+		w(typ)
+		w(count)
+		w(value)
+	}
+	short := func(tag uint16, v uint16) {
+		w(tag)
+		w(uint16(shortType))
+		w(uint32(1))
+		w(v)
+		w(uint16(0))
+	}
+	// IFD0 at 8 with 4 entries is 54 bytes, the Exif IFD after it with 3 is
+	// 42, so the strings start at 104.
+	b.WriteString("MM\x00*")
+	w(uint32(8))
+	w(uint16(4))
+	entry(makeTag, asciiType, 6, 104)
+	entry(modelTag, asciiType, 13, 110)
+	entry(dateTimeTag, asciiType, 20, 123)
+	entry(exifIFDTag, longType, 1, 62)
+	w(uint32(0))
+	w(uint16(3))
+	entry(dateTimeOriginalTag, asciiType, 20, 143)
+	short(pixelXDimensionTag, 4000)
+	entry(pixelYDimensionTag, longType, 1, 3000)
+	w(uint32(0))
+	b.WriteString("Canon\x00")
+	b.WriteString("Canon EOS R5\x00")
+	b.WriteString("2024:06:02 10:00:00\x00")
+	b.WriteString("2023:07:14 18:30:05\x00")
+	return b.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	m, err := Read(bytes.NewReader(testMetadataTIFF()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{
+		Time:   time.Date(2023, 7, 14, 18, 30, 5, 0, time.UTC),
+		Make:   "Canon",
+		Model:  "Canon EOS R5",
+		Width:  4000,
+		Height: 3000,
+	}
+	if m != want {
+		t.Errorf("got %+v", m)
+	}
+	if c := m.Camera(); c != "Canon EOS R5" {
+		t.Errorf("got camera %q", c)
+	}
+	if c := (Metadata{Make: "Apple", Model: "iPhone 12"}).Camera(); c != "Apple iPhone 12" {
+		t.Errorf("got camera %q", c)
+	}
+	// The frame header of a JPEG gives the size of its pixels, if it was
+	// resized after the EXIF was written.
+	jpeg := testJPEG(testMetadataTIFF())
+	sof := []byte{0xff, 0xc0, 0, 11, 8, 0x02, 0x58, 0x03, 0x20, 1, 1, 0x11, 0}
+	jpeg = append(jpeg[:len(jpeg)-7:len(jpeg)-7], append(sof, jpeg[len(jpeg)-7:]...)...)
+	if m, err := Read(bytes.NewReader(jpeg)); err != nil || m.Width != 800 || m.Height != 600 || m.Model != "Canon EOS R5" {
+		t.Errorf("got %+v, %v", m, err)
+	}
+	// Without EXIF.
+	if m, err := Read(bytes.NewReader(append([]byte{0xff, 0xd8}, append(sof, 0xff, 0xda, 0, 2)...))); err != nil || m != (Metadata{Width: 800, Height: 600}) {
+		t.Errorf("got %+v, %v", m, err)
+	}
+}
@@ -0,0 +1,122 @@
+// Package geonames reverse geocodes offline, finding the nearest city in a
+// GeoNames cities file, such as cities15000.txt from
+// https://download.geonames.org/export/dump/.
+package geonames
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Returned when there's no city near a position.
+var ErrNoCity = errors.New("no city nearby")
+
+// How far a position can be from the nearest city for it to count.
+const maxDistanceKm = 100
+
+// A city of a GeoNames cities file.
+type City struct {
+	Name string
+	// ISO 3166-1 alpha-2, such as "FR".
+	CountryCode string
+	Lat, Lon    float64
+}
+
+// A one degree square of latitude and longitude.
+type cell struct {
+	lat, lon int
+}
+
+func cellOf(lat, lon float64) cell {
+	return cell{int(math.Floor(lat)), int(math.Floor(lon))}
+}
+
+// The cities of a GeoNames cities file, indexed by position.
+type Cities struct {
+	cells map[cell][]City
+	n     int
+}
+
+// Reads a GeoNames cities file.
+func Load(path string) (*Cities, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Reads the tab separated lines of a GeoNames cities file.
+func Read(r io.Reader) (*Cities, error) {
+	ret := &Cities{cells: make(map[cell][]City)}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 9 {
+			return nil, fmt.Errorf("line %d: %d fields, want at least 9", line, len(fields))
+		}
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: latitude: %w", line, err)
+		}
+		lon, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: longitude: %w", line, err)
+		}
+		c := cellOf(lat, lon)
+		ret.cells[c] = append(ret.cells[c], City{fields[1], fields[8], lat, lon})
+		ret.n++
+	}
+	return ret, s.Err()
+}
+
+// Returns the number of cities.
+func (me *Cities) Len() int {
+	return me.n
+}
+
+// Returns the great-circle distance between two positions.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Returns the city nearest the position, if one's within 100km, looking in
+// the cells around it.
+func (me *Cities) Nearest(lat, lon float64) (ret City, ok bool) {
+	c := cellOf(lat, lon)
+	best := math.Inf(1)
+	for dLat := -1; dLat <= 1; dLat++ {
+		for dLon := -1; dLon <= 1; dLon++ {
+			// Longitudes wrap around at the antimeridian.
+			n := cell{c.lat + dLat, (c.lon+dLon+180+360)%360 - 180}
+			for _, city := range me.cells[n] {
+				if d := distanceKm(lat, lon, city.Lat, city.Lon); d < best {
+					best, ret = d, city
+				}
+			}
+		}
+	}
+	return ret, best <= maxDistanceKm
+}
+
+// Returns the country code and name of the city nearest the position.
+func (me *Cities) ReverseGeocode(ctx context.Context, lat, lon float64) (countryCode, city string, err error) {
+	c, ok := me.Nearest(lat, lon)
+	if !ok {
+		return "", "", ErrNoCity
+	}
+	return c.CountryCode, c.Name, nil
+}
@@ -0,0 +1,56 @@
+package geonames
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Lines of cities15000.txt, cut short after the country code.
+const testCities = "2988507\tParis\tParis\t\t48.85341\t2.3488\tP\tPPLC\tFR\n" +
+	"2995469\tMarseille\tMarseille\t\t43.29695\t5.38107\tP\tPPLA\tFR\n" +
+	"3451190\tRio de Janeiro\tRio de Janeiro\t\t-22.90642\t-43.18223\tP\tPPLA\tBR\n" +
+	"2193733\tAuckland\tAuckland\t\t-36.84853\t174.76349\tP\tPPLA\tNZ\n" +
+	"2194168\tWaiyevo\tWaiyevo\t\t-16.79\t179.98\tP\tPPL\tFJ\n"
+
+func TestNearest(t *testing.T) {
+	cities, err := Read(strings.NewReader(testCities))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cities.Len() != 5 {
+		t.Errorf("got %d cities", cities.Len())
+	}
+	for _, test := range []struct {
+		lat, lon float64
+		want     string
+	}{
+		// The Eiffel Tower.
+		{48.8582, 2.2945, "Paris"},
+		// Versailles, in the next cell west.
+		{48.8049, 2.1204, "Paris"},
+		{-22.9519, -43.2105, "Rio de Janeiro"},
+		// Across the antimeridian.
+		{-16.8, -179.98, "Waiyevo"},
+		// The middle of the Atlantic.
+		{30, -40, ""},
+	} {
+		country, city, err := cities.ReverseGeocode(context.Background(), test.lat, test.lon)
+		if test.want == "" {
+			if !errors.Is(err, ErrNoCity) {
+				t.Errorf("%v, %v: got %q, %q, %v", test.lat, test.lon, country, city, err)
+			}
+			continue
+		}
+		if err != nil || city != test.want || country == "" {
+			t.Errorf("%v, %v: got %q, %q, %v", test.lat, test.lon, country, city, err)
+		}
+	}
+}
+
+func TestReadBadLine(t *testing.T) {
+	if _, err := Read(strings.NewReader("1\tNowhere\n")); err == nil {
+		t.Error("no error")
+	}
+}
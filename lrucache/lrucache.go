@@ -0,0 +1,106 @@
+// Package lrucache implements a concurrency-safe least recently used cache.
+// When the capacity is exceeded, the item used longest ago is evicted. Items
+// can also expire a fixed time after they're set.
+package lrucache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	// Most recently used first.
+	order *list.List
+	table map[interface{}]*list.Element
+}
+
+type entry struct {
+	key     interface{}
+	value   interface{}
+	expires time.Time
+}
+
+// Returns a cache holding up to capacity items. If ttl isn't zero, items
+// expire that long after they're set.
+func New(capacity int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		table:    make(map[interface{}]*list.Element),
+	}
+}
+
+// Returns the number of items in the cache, including any that have expired
+// but not yet been evicted.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUCache) Set(key interface{}, value interface{}) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expires time.Time
+	if c.ttl != 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+	if elem, ok := c.table[key]; ok {
+		elem.Value = &entry{key, value, expires}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.table[key] = c.order.PushFront(&entry{key, value, expires})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUCache) Get(key interface{}) (value interface{}, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.table[key]
+	if !ok {
+		return
+	}
+	e := elem.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return e.value, true
+}
+
+// Drops the item, if it's there.
+func (c *LRUCache) Delete(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.table[key]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *LRUCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.table, elem.Value.(*entry).key)
+}
+
+// Returns the keys of the items in the cache, most recently used first, so
+// that its contents can be saved.
+func (c *LRUCache) Keys() (ret []interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		ret = append(ret, elem.Value.(*entry).key)
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package lrucache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(2, 0)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	// Using a makes b the least recently used.
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("got %v, %v", v, ok)
+	}
+	c.Set("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("b wasn't evicted")
+	}
+	for k, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := c.Get(k); !ok || v != want {
+			t.Errorf("%s: got %v, %v", k, v, ok)
+		}
+	}
+	c.Set("c", 4)
+	if v, _ := c.Get("c"); v != 4 {
+		t.Errorf("got %v after replacing", v)
+	}
+	if c.Len() != 2 {
+		t.Errorf("got length %d", c.Len())
+	}
+	c.Delete("a")
+	c.Delete("missing")
+	if _, ok := c.Get("a"); ok || c.Len() != 1 {
+		t.Errorf("a not deleted, length %d", c.Len())
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := New(2, time.Millisecond)
+	c.Set("a", 1)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("a didn't expire")
+	}
+	if c.Len() != 0 {
+		t.Errorf("got length %d", c.Len())
+	}
+}
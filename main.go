@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/json"
 	"flag"
@@ -15,18 +16,21 @@ import (
 	"os/signal"
 	"os/user"
 	"path/filepath"
-	"runtime"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/anacrolix/log"
 	"github.com/nfnt/resize"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	"github.com/anacrolix/dms/dlna/dms"
-	"github.com/anacrolix/dms/rrcache"
+	"github.com/anacrolix/dms/geonames"
+	"github.com/anacrolix/dms/scrobble"
+	"github.com/anacrolix/dms/search"
+	"github.com/anacrolix/dms/torrentfs"
 )
 
 //go:embed "data/VGC Sonic.png"
@@ -39,31 +43,106 @@ type dmsConfig struct {
 	FriendlyName        string
 	DeviceIcon          string
 	DeviceIconSizes     []string
+	Manufacturer        string
+	ModelName           string
+	ModelNumber         string
+	SerialNumber        string
+	ModelURL            string
+	VendorXML           string
 	LogHeaders          bool
 	FFprobeCachePath    string
 	NoTranscode         bool
 	ForceTranscodeTo    string
 	NoProbe             bool
+	RemuxTimeSeek       bool
 	StallEventSubscribe bool
 	NotifyInterval      time.Duration
 	IgnoreHidden        bool
 	IgnoreUnreadable    bool
 	IgnorePaths         []string
+	OneFileSystem       bool
+	FSTimeout           time.Duration
+	SlideshowDuration   time.Duration
+	SlideshowMusic      string
 	AllowedIps          string       // Comma-separated IPs/CIDRs for JSON config
 	AllowedIpNets       []*net.IPNet `json:"-"` // Parsed IP networks, not directly from JSON
+	ControlRate         float64
+	ControlBurst        int
+	BanThreshold        int
+	BanDuration         time.Duration
+	CORSOrigins         []string
+	CORSMethods         []string
+	RemoteAccess        bool
+	RemoteHttp          string
+	RemoteUser          string
+	RemotePassword      string
+	RemoteCert          string
+	RemoteKey           string
+	NoPortMapping       bool
+	NoDIAL              bool
 	AllowDynamicStreams bool
+	ProtectedPaths      []string
+	PIN                 string
+	UnlockDuration      time.Duration
+	ClientRoots         []clientRootConfig
 	TranscodeLogPattern string
+	TranscodeLogMaxAge  time.Duration
+	TranscodeLogMaxSize int64
+	ClientProfiles      []dms.ClientProfile
+	MimeTypes           map[string]string
+	Encoders            []dms.Encoder
+	AudiobookPaths      []string
+	Language            string
+	PlaybackHistoryPath string
+	FavoritesPath       string
+	HiddenPath          string
+	FaststartCachePath  string
+	NoSearch            bool
+	SearchIndexPath     string
+	LibraryPath         string
+	GeoNamesPath        string
+	Collections         []dms.Collection
+	TorrentDataDir      string
+	TorrentWatchDir     string
+	RecordingsDir       string
+	RecordingsPath      string
+	WarmUpRecent        int
+	WarmUpPaths         []string
+	WarmUpConcurrency   int
+	OTLPEndpoint        string
+	LastFM              *scrobble.LastFM
+	ListenBrainz        *scrobble.ListenBrainz
+	// Channels recordings can be scheduled from, as well as those listed at
+	// the ChannelSources URLs.
+	Channels       []dms.Channel
+	ChannelSources []string
+
+	FFprobeCacheMaxSize int64
+	// Folders shared as containers in the root, as well as Path, which can
+	// be left empty.
+	Roots []dms.RootDir
+	Watch bool
+
+	Https          string
+	HttpsCert      string
+	HttpsKey       string
+	AdvertiseHttps bool
+}
+
+// Limits the clients at some addresses to folders, as dms.ClientRoot.
+type clientRootConfig struct {
+	// Comma-separated IPs/CIDRs, or every client if empty.
+	IPs   string
+	Paths []string
 }
 
 func (config *dmsConfig) load(configPath string) {
-	file, err := os.Open(configPath)
+	b, err := os.ReadFile(configPath)
 	if err != nil {
 		log.Printf("config error (config file: '%s'): %v\n", configPath, err)
 		return
 	}
-	defer file.Close()
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
+	err = decodeConfig(b, config, false)
 	if err != nil {
 		log.Printf("config error: %v\n", err)
 		return
@@ -72,15 +151,21 @@ func (config *dmsConfig) load(configPath string) {
 
 // default config
 var config = &dmsConfig{
-	Path:             "",
-	IfName:           "",
-	Http:             ":1338",
-	FriendlyName:     "",
-	DeviceIcon:       "",
-	DeviceIconSizes:  []string{"48,128"},
-	LogHeaders:       false,
-	FFprobeCachePath: getDefaultFFprobeCachePath(),
-	ForceTranscodeTo: "",
+	Path:                "",
+	IfName:              "",
+	Http:                ":1338",
+	RemoteHttp:          ":1339",
+	RemoteUser:          "dms",
+	FriendlyName:        "",
+	DeviceIcon:          "",
+	LogHeaders:          false,
+	FFprobeCachePath:    getDefaultFFprobeCachePath(),
+	ForceTranscodeTo:    "",
+	PlaybackHistoryPath: getDefaultPlaybackHistoryPath(),
+	FavoritesPath:       getDefaultFavoritesPath(),
+	HiddenPath:          getDefaultHiddenPath(),
+	RecordingsPath:      getDefaultRecordingsPath(),
+	SearchIndexPath:     getDefaultSearchIndexPath(),
 }
 
 func getDefaultFFprobeCachePath() (path string) {
@@ -93,30 +178,54 @@ func getDefaultFFprobeCachePath() (path string) {
 	return
 }
 
-type fFprobeCache struct {
-	c *rrcache.RRCache
-	sync.Mutex
+func getDefaultPlaybackHistoryPath() (path string) {
+	_user, err := user.Current()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	path = filepath.Join(_user.HomeDir, ".dms-playback-history")
+	return
 }
 
-func (fc *fFprobeCache) Get(key interface{}) (value interface{}, ok bool) {
-	fc.Lock()
-	defer fc.Unlock()
-	return fc.c.Get(key)
+func getDefaultFavoritesPath() (path string) {
+	_user, err := user.Current()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	path = filepath.Join(_user.HomeDir, ".dms-favorites")
+	return
 }
 
-func (fc *fFprobeCache) Set(key interface{}, value interface{}) {
-	fc.Lock()
-	defer fc.Unlock()
-	var size int64
-	for _, v := range []interface{}{key, value} {
-		b, err := json.Marshal(v)
-		if err != nil {
-			log.Printf("Could not marshal %v: %s", v, err)
-			continue
-		}
-		size += int64(len(b))
+func getDefaultHiddenPath() (path string) {
+	_user, err := user.Current()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	path = filepath.Join(_user.HomeDir, ".dms-hidden")
+	return
+}
+
+func getDefaultRecordingsPath() (path string) {
+	_user, err := user.Current()
+	if err != nil {
+		log.Print(err)
+		return
 	}
-	fc.c.Set(key, value, size)
+	path = filepath.Join(_user.HomeDir, ".dms-recordings")
+	return
+}
+
+func getDefaultSearchIndexPath() (path string) {
+	_user, err := user.Current()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	path = filepath.Join(_user.HomeDir, ".dms-search-index")
+	return
 }
 
 func main() {
@@ -130,23 +239,79 @@ func mainErr() error {
 	path := flag.String("path", config.Path, "browse root path")
 	ifName := flag.String("ifname", config.IfName, "specific SSDP network interface")
 	http := flag.String("http", config.Http, "http server port")
-	friendlyName := flag.String("friendlyName", config.FriendlyName, "server friendly name")
+	friendlyName := flag.String("friendlyName", config.FriendlyName, "server friendly name, which may use {{.Hostname}}, {{.User}} and {{.Share}}")
 	deviceIcon := flag.String("deviceIcon", config.DeviceIcon, "device defaultIcon")
-	deviceIconSizes := flag.String("deviceIconSizes", strings.Join(config.DeviceIconSizes, ","), "comma separated list of icon sizes to advertise, eg 48,128,256. Use 48:512,128:512 format to force actual size.")
+	deviceIconSizes := flag.String("deviceIconSizes", strings.Join(config.DeviceIconSizes, ","), "comma separated list of icon sizes to advertise, eg 48,128,256. Use 48:512,128:512 format to force actual size. If empty, PNG and JPEG icons are made at 48, 120 and 256.")
 	logHeaders := flag.Bool("logHeaders", config.LogHeaders, "log HTTP headers")
 	fFprobeCachePath := flag.String("fFprobeCachePath", config.FFprobeCachePath, "path to FFprobe cache file")
+	flag.Int64Var(&config.FFprobeCacheMaxSize, "fFprobeCacheMaxSize", 64<<20, "evict the least recently used probe results beyond this many bytes; 0 means no limit")
 	configFilePath := flag.String("config", "", "json configuration file")
-	allowedIps := flag.String("allowedIps", "", "allowed ip of clients, separated by comma")
-	forceTranscodeTo := flag.String("forceTranscodeTo", config.ForceTranscodeTo, "force transcoding to certain format, supported: 'chromecast', 'vp8', 'web'")
+	writeConfig := flag.String("writeConfig", "", "write a configuration file describing every setting to this path, or stdout if '-', and exit")
+	checkConfigFile := flag.Bool("checkConfig", false, "check the -config file for problems and exit")
+	allowedIps := flag.String("allowedIps", "", "comma separated IPs and CIDRs of the clients allowed to use any part of the server; everyone if empty")
+	corsOrigins := flag.String("corsOrigins", "", "comma separated list of origins of web apps that may use the API, streams and subtitles, or * for any")
+	corsMethods := flag.String("corsMethods", "", "comma separated list of methods -corsOrigins may use; GET, HEAD, POST and DELETE by default")
+	flag.BoolVar(&config.RemoteAccess, "remote", false, "serve the web UI, API and streams over HTTPS on -remoteHttp, for clients away from home, mapping its port on the router")
+	remoteHttp := flag.String("remoteHttp", config.RemoteHttp, "https server port for -remote access")
+	flag.StringVar(&config.RemoteUser, "remoteUser", config.RemoteUser, "user name clients outside the local network must give for -remote access")
+	flag.StringVar(&config.RemotePassword, "remotePassword", "", "password clients outside the local network must give for -remote access")
+	flag.StringVar(&config.Https, "https", "", "https server address, such as :1340, serving everything the http server does over TLS; empty means none")
+	flag.StringVar(&config.HttpsCert, "httpsCert", "", "TLS certificate file for -https; by default a self-signed one is made at $HOME/.dms/https-cert.pem")
+	flag.StringVar(&config.HttpsKey, "httpsKey", "", "TLS key file of -httpsCert; $HOME/.dms/https-key.pem by default")
+	flag.BoolVar(&config.AdvertiseHttps, "advertiseHttps", false, "give every client the -https location in SSDP, not only those whose client profiles set https")
+	remoteCert := flag.String("remoteCert", "", "TLS certificate file for -remote access; by default a self-signed one is made at $HOME/.dms/remote-cert.pem")
+	remoteKey := flag.String("remoteKey", "", "TLS key file of -remoteCert; $HOME/.dms/remote-key.pem by default")
+	flag.BoolVar(&config.NoPortMapping, "noPortMapping", false, "don't map the -remoteHttp port on the router with UPnP IGD or NAT-PMP")
+	flag.BoolVar(&config.NoDIAL, "noDial", false, "don't announce dms to second screen apps with DIAL")
+	protectedPaths := flag.String("protected", "", "comma separated list of directories, relative to the root, shown only to clients unlocked with the -pin")
+	flag.StringVar(&config.PIN, "pin", "", "PIN that unlocks the -protected directories for a client, through the web UI")
+	flag.DurationVar(&config.UnlockDuration, "unlockDuration", time.Hour, "how long a client stays unlocked")
+	forceTranscodeTo := flag.String("forceTranscodeTo", config.ForceTranscodeTo, "force transcoding to certain format, supported: 'chromecast', 'vp8', 'web', and 'lpcm' for audio")
 	transcodeLogPattern := flag.String("transcodeLogPattern", "", "pattern where to write transcode logs to. The [tsname] placeholder is replaced with the name of the item currently being played. The default is $HOME/.dms/log/[tsname]")
+	flag.DurationVar(&config.TranscodeLogMaxAge, "transcodeLogMaxAge", 7*24*time.Hour, "delete transcode logs older than this; 0 keeps them")
+	flag.Int64Var(&config.TranscodeLogMaxSize, "transcodeLogMaxSize", 100<<20, "delete the oldest transcode logs beyond this many bytes in all; 0 means no limit")
 	flag.BoolVar(&config.NoTranscode, "noTranscode", false, "disable transcoding")
 	flag.BoolVar(&config.NoProbe, "noProbe", false, "disable media probing with ffprobe")
+	flag.BoolVar(&config.RemuxTimeSeek, "remuxTimeSeek", false, "support time seeking in untranscoded video by remuxing with ffmpeg")
 	flag.BoolVar(&config.StallEventSubscribe, "stallEventSubscribe", false, "workaround for some bad event subscribers")
 	flag.DurationVar(&config.NotifyInterval, "notifyInterval", 30*time.Second, "interval between SSPD announces")
 	flag.BoolVar(&config.IgnoreHidden, "ignoreHidden", false, "ignore hidden files and directories")
 	flag.BoolVar(&config.IgnoreUnreadable, "ignoreUnreadable", false, "ignore unreadable files and directories")
+	flag.BoolVar(&config.OneFileSystem, "oneFileSystem", false, "leave out directories on other filesystems than the root, such as mounts within it")
+	flag.DurationVar(&config.FSTimeout, "fsTimeout", 0, "give up opening, statting and listing files after this long, so a hung network mount doesn't hold up browsing; 0 waits forever")
+	flag.DurationVar(&config.SlideshowDuration, "slideshowDuration", 5*time.Second, "how long each photo is shown in the slideshow videos of photo folders")
+	slideshowMusic := flag.String("slideshowMusic", config.SlideshowMusic, "audio file played under slideshows, relative to the root; by default a folder's first audio file")
 	ignorePaths := flag.String("ignore", "", "comma separated list of directories to ignore (i.e. thumbnails,thumbs)")
+	flag.Float64Var(&config.ControlRate, "controlRate", config.ControlRate, "control and eventing requests a second each client may make before getting 429 Too Many Requests; 0 means no limit")
+	flag.IntVar(&config.ControlBurst, "controlBurst", config.ControlBurst, "control and eventing requests a client may make at once within -controlRate; 20 if 0")
+	flag.IntVar(&config.BanThreshold, "banThreshold", config.BanThreshold, "forbidden or malformed requests a client may make within 10 minutes before it's banned; 0 means never")
+	flag.DurationVar(&config.BanDuration, "banDuration", time.Hour, "how long -banThreshold bans clients for")
 	flag.BoolVar(&config.AllowDynamicStreams, "allowDynamicStreams", false, "activate support for dynamic streams described via .dms.json metadata files")
+	audiobookPaths := flag.String("audiobooks", "", "comma separated list of directories holding audiobooks, relative to the root")
+	language := flag.String("language", config.Language, "language of the titles of containers dms makes up, such as 'de'; English by default")
+	playbackHistoryPath := flag.String("playbackHistoryPath", config.PlaybackHistoryPath, "path to playback history file")
+	favoritesPath := flag.String("favoritesPath", config.FavoritesPath, "path to favorites file")
+	hiddenPath := flag.String("hiddenPath", config.HiddenPath, "path to file listing paths hidden from clients")
+	flag.BoolVar(&config.NoSearch, "noSearch", false, "disable the search index")
+	searchIndexPath := flag.String("searchIndexPath", config.SearchIndexPath, "path to search index file")
+	flag.BoolVar(&config.Watch, "watch", false, "watch the served folders, refreshing listings and telling clients of changes straight away (Linux only)")
+	libraryPath := flag.String("libraryPath", config.LibraryPath, "path to SQLite library database file; if set, folders are listed and probe results and playback kept in it")
+	geoNamesPath := flag.String("geoNamesPath", config.GeoNamesPath, "path to a GeoNames cities file, such as cities15000.txt; if set, photos are listed by the country and city they were taken in")
+	torrentDataDir := flag.String("torrentDataDir", config.TorrentDataDir, "directory to download torrents to; if set, torrents are listed in a Torrents folder in the root and streamed as they download")
+	torrentWatchDir := flag.String("torrentWatchDir", config.TorrentWatchDir, "directory whose .torrent files are added as torrents, and dropped when they're deleted; needs -torrentDataDir")
+	recordingsDir := flag.String("recordingsDir", config.RecordingsDir, "directory to record tuner and IPTV channels to; if set, and channels are configured, recordings can be scheduled through the ScheduledRecording service and are listed in a Recordings folder in the root")
+	recordingsPath := flag.String("recordingsPath", config.RecordingsPath, "path to file of scheduled recordings")
+	flag.IntVar(&config.WarmUpRecent, "warmUp", config.WarmUpRecent, "number of most recently modified media files to probe at startup")
+	warmUpPaths := flag.String("warmUpPaths", "", "comma separated list of directories whose media files are probed at startup, relative to the root")
+	flag.IntVar(&config.WarmUpConcurrency, "warmUpConcurrency", 2, "number of media files probed at once at startup")
+	faststartCachePath := flag.String("faststartCachePath", config.FaststartCachePath, "directory to keep copies of MP4s remuxed with their index at the start")
+	dumpTree := flag.String("dumpTree", "", "write the ContentDirectory tree to stdout as 'json' or 'didl' and exit, instead of serving")
+	dumpUserAgent := flag.String("dumpUserAgent", "", "User-Agent of the client to dump the tree for, to apply its client profile")
+	audit := flag.Bool("audit", false, "report files that would be ignored, fail probing, lack thumbnails or be transcoded, and exit, instead of serving")
+	duplicates := flag.Bool("duplicates", false, "report media files that are duplicates, by content or, unless -noProbe, by probed duration and tags, and exit, instead of serving")
+	auditProfile := flag.String("auditProfile", "", "name of the client profile to audit transcoding for")
+	otlpEndpoint := flag.String("otlpEndpoint", config.OTLPEndpoint, "URL of an OpenTelemetry collector to send traces of requests to over OTLP/HTTP, such as http://localhost:4318")
+	pidFile := flag.String("pidFile", "", "file to write the process ID to while serving")
 
 	flag.Parse()
 	if flag.NArg() != 0 {
@@ -156,18 +321,73 @@ func mainErr() error {
 
 	logger := log.Default.WithNames("main")
 
-	config.Path, _ = filepath.Abs(*path)
+	if *writeConfig != "" {
+		return writeConfigTemplate(*writeConfig)
+	}
+	if *checkConfigFile {
+		if *configFilePath == "" {
+			return fmt.Errorf("-checkConfig needs -config")
+		}
+		problems, err := checkConfig(*configFilePath)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configFilePath, p)
+		}
+		if len(problems) != 0 {
+			return fmt.Errorf("%d problems in %s", len(problems), *configFilePath)
+		}
+		fmt.Fprintf(os.Stderr, "%s: ok\n", *configFilePath)
+		return nil
+	}
+
+	if *path != "" {
+		config.Path, _ = filepath.Abs(dms.RootPath(*path))
+	}
 	config.IfName = *ifName
 	config.Http = *http
+	config.RemoteHttp = *remoteHttp
+	config.RemoteCert = *remoteCert
+	config.RemoteKey = *remoteKey
 	config.FriendlyName = *friendlyName
 	config.DeviceIcon = *deviceIcon
-	config.DeviceIconSizes = strings.Split(*deviceIconSizes, ",")
+	if *deviceIconSizes != "" {
+		config.DeviceIconSizes = strings.Split(*deviceIconSizes, ",")
+	}
 
 	config.LogHeaders = *logHeaders
 	config.FFprobeCachePath = *fFprobeCachePath
 	config.AllowedIpNets = makeIpNets(*allowedIps)
 	config.ForceTranscodeTo = *forceTranscodeTo
 	config.IgnorePaths = strings.Split(*ignorePaths, ",")
+	config.AudiobookPaths = strings.Split(*audiobookPaths, ",")
+	if *corsOrigins != "" {
+		config.CORSOrigins = strings.Split(*corsOrigins, ",")
+	}
+	if *corsMethods != "" {
+		config.CORSMethods = strings.Split(*corsMethods, ",")
+	}
+	if *protectedPaths != "" {
+		config.ProtectedPaths = strings.Split(*protectedPaths, ",")
+	}
+	config.Language = *language
+	config.PlaybackHistoryPath = *playbackHistoryPath
+	config.FavoritesPath = *favoritesPath
+	config.HiddenPath = *hiddenPath
+	config.FaststartCachePath = *faststartCachePath
+	config.SearchIndexPath = *searchIndexPath
+	config.LibraryPath = *libraryPath
+	config.GeoNamesPath = *geoNamesPath
+	config.SlideshowMusic = *slideshowMusic
+	config.TorrentDataDir = *torrentDataDir
+	config.TorrentWatchDir = *torrentWatchDir
+	config.RecordingsDir = *recordingsDir
+	config.RecordingsPath = *recordingsPath
+	config.OTLPEndpoint = *otlpEndpoint
+	if *warmUpPaths != "" {
+		config.WarmUpPaths = strings.Split(*warmUpPaths, ",")
+	}
 	config.TranscodeLogPattern = *transcodeLogPattern
 
 	if config.TranscodeLogPattern == "" {
@@ -177,185 +397,531 @@ func mainErr() error {
 		}
 		config.TranscodeLogPattern = filepath.Join(u.HomeDir, ".dms", "log", "[tsname]")
 	}
+	if config.RemoteCert == "" && config.RemoteKey == "" {
+		if u, err := user.Current(); err == nil {
+			config.RemoteCert = filepath.Join(u.HomeDir, ".dms", "remote-cert.pem")
+			config.RemoteKey = filepath.Join(u.HomeDir, ".dms", "remote-key.pem")
+		}
+	}
+	if config.HttpsCert == "" && config.HttpsKey == "" {
+		if u, err := user.Current(); err == nil {
+			config.HttpsCert = filepath.Join(u.HomeDir, ".dms", "https-cert.pem")
+			config.HttpsKey = filepath.Join(u.HomeDir, ".dms", "https-key.pem")
+		}
+	}
 
-	if len(*configFilePath) > 0 {
-		config.load(*configFilePath)
-		// Parse AllowedIps from config file if provided
-		if config.AllowedIps != "" {
-			config.AllowedIpNets = makeIpNets(config.AllowedIps)
+	// The configuration file is loaded over the flags again on reload.
+	flagConfig := config.clone()
+	loadConfig := func() {
+		if len(*configFilePath) > 0 {
+			config.load(*configFilePath)
+			// Parse AllowedIps from config file if provided
+			if config.AllowedIps != "" {
+				config.AllowedIpNets = makeIpNets(config.AllowedIps)
+			}
+		}
+		// The working directory is served, unless there are only roots.
+		if config.Path == "" && len(config.Roots) == 0 {
+			config.Path, _ = os.Getwd()
 		}
 	}
+	loadConfig()
 
 	logger.Printf("device icon sizes are %q", config.DeviceIconSizes)
 	logger.Printf("allowed ip nets are %q", config.AllowedIpNets)
-	logger.Printf("serving folder %q", config.Path)
+	if config.Path != "" {
+		logger.Printf("serving folder %q", config.Path)
+	}
+	for _, r := range config.Roots {
+		logger.Printf("serving folder %q as %q", r.Path, r.Name)
+	}
 	if config.AllowDynamicStreams {
 		logger.Printf("Dynamic streams ARE allowed")
 	}
 
-	cache := &fFprobeCache{
-		c: rrcache.New(64 << 20),
+	var library *dms.Library
+	if config.LibraryPath != "" {
+		var err error
+		library, err = openLibrary(config.LibraryPath)
+		if err != nil {
+			log.Printf("opening library: %v", err)
+		} else {
+			defer library.Close()
+		}
+	}
+	// Without it, the server keeps probe results in memory.
+	var cache dms.Cache
+	if library != nil {
+		// The library keeps them itself.
+		cache = library.ProbeCache()
+	} else if db, err := openFFprobeCache(config.FFprobeCachePath, config.FFprobeCacheMaxSize); err != nil {
+		log.Printf("opening ffprobe cache: %v", err)
+	} else {
+		cache = db
+		defer db.Close()
+	}
+	playback := &dms.PlaybackHistory{}
+	if err := playback.Load(config.PlaybackHistoryPath); err != nil && !os.IsNotExist(err) {
+		log.Print(err)
+	}
+	if library != nil {
+		if err := library.LoadPlayback(playback); err != nil {
+			log.Print(err)
+		}
+	}
+	favorites := &dms.Favorites{}
+	if err := favorites.Load(config.FavoritesPath); err != nil && !os.IsNotExist(err) {
+		log.Print(err)
 	}
-	if err := cache.load(config.FFprobeCachePath); err != nil {
+	hidden := &dms.HiddenPaths{}
+	if err := hidden.Load(config.HiddenPath); err != nil && !os.IsNotExist(err) {
 		log.Print(err)
 	}
+	recordings := &dms.Recordings{}
+	if err := recordings.Load(config.RecordingsPath); err != nil && !os.IsNotExist(err) {
+		log.Print(err)
+	}
+	var index *search.Index
+	if !config.NoSearch {
+		index = &search.Index{}
+		if err := index.Load(config.SearchIndexPath); err != nil && !os.IsNotExist(err) {
+			log.Print(err)
+		}
+	}
+	serverLibrary := library
+	if *audit || *duplicates {
+		// The audit walks the filesystem, and leaves the databases alone.
+		index = nil
+		serverLibrary = nil
+	}
+	var geocoder dms.Geocoder
+	if config.GeoNamesPath != "" && index != nil {
+		cities, err := geonames.Load(config.GeoNamesPath)
+		if err != nil {
+			log.Print(err)
+		} else {
+			logger.Printf("loaded %d cities from %q", cities.Len(), config.GeoNamesPath)
+			geocoder = cities
+		}
+	}
+	// Torrents outlive reloads, as they take a while to find peers.
+	var torrents *torrentfs.FS
+	if config.TorrentDataDir != "" && *dumpTree == "" && !*audit && !*duplicates {
+		cl, err := torrentfs.NewClient(config.TorrentDataDir)
+		if err != nil {
+			return fmt.Errorf("starting torrent client: %w", err)
+		}
+		defer cl.Close()
+		torrents = torrentfs.New(cl)
+		torrents.Logger = logger.WithNames("torrents")
+		if config.TorrentWatchDir != "" {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go torrents.Watch(ctx, config.TorrentWatchDir, torrentWatchInterval)
+		}
+	}
+	// Traces are exported in the background, across reloads, and flushed on
+	// the way out.
+	var tracerProvider *sdktrace.TracerProvider
+	if config.OTLPEndpoint != "" && *dumpTree == "" && !*audit && !*duplicates {
+		tp, err := newTracerProvider(context.Background(), config.OTLPEndpoint)
+		if err != nil {
+			return fmt.Errorf("starting tracing: %w", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := tp.Shutdown(ctx); err != nil {
+				log.Print(err)
+			}
+		}()
+		tracerProvider = tp
+	}
+	serving := *dumpTree == "" && !*audit && !*duplicates
+	// Makes a server from the config, as it stands.
+	newServer := func() *dms.Server {
+		var scrobblers []scrobble.Scrobbler
+		if config.LastFM != nil {
+			scrobblers = append(scrobblers, config.LastFM)
+		}
+		if config.ListenBrainz != nil {
+			scrobblers = append(scrobblers, config.ListenBrainz)
+		}
 
-	dmsServer := &dms.Server{
-		Logger: logger.WithNames("dms", "server"),
-		Interfaces: func(ifName string) (ifs []net.Interface) {
-			var err error
-			if ifName == "" {
-				ifs, err = net.Interfaces()
-			} else {
-				var if_ *net.Interface
-				if_, err = net.InterfaceByName(ifName)
-				if if_ != nil {
-					ifs = append(ifs, *if_)
+		dmsServer := &dms.Server{
+			Logger: logger.WithNames("dms", "server"),
+			Interfaces: func(ifName string) (ifs []net.Interface) {
+				var err error
+				if ifName == "" {
+					ifs, err = net.Interfaces()
+				} else {
+					var if_ *net.Interface
+					if_, err = net.InterfaceByName(ifName)
+					if if_ != nil {
+						ifs = append(ifs, *if_)
+					}
 				}
-			}
-			if err != nil {
-				log.Fatal(err)
-			}
-			var tmp []net.Interface
-			for _, if_ := range ifs {
-				if if_.Flags&net.FlagUp == 0 || if_.MTU <= 0 {
-					continue
+				if err != nil {
+					log.Fatal(err)
 				}
-				tmp = append(tmp, if_)
-			}
-			ifs = tmp
-			return
-		}(config.IfName),
-		HTTPConn: func() net.Listener {
-			network := "tcp"
-			host, _, err := net.SplitHostPort(config.Http)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if host == "::" {
-				network = "tcp6"
-			}
-			conn, err := net.Listen(network, config.Http)
-			if err != nil {
-				log.Fatal(err)
-			}
-			return conn
-		}(),
-		FriendlyName:        config.FriendlyName,
-		RootObjectPath:      filepath.Clean(config.Path),
-		FFProbeCache:        cache,
-		LogHeaders:          config.LogHeaders,
-		NoTranscode:         config.NoTranscode,
-		AllowDynamicStreams: config.AllowDynamicStreams,
-		ForceTranscodeTo:    config.ForceTranscodeTo,
-		TranscodeLogPattern: config.TranscodeLogPattern,
-		NoProbe:             config.NoProbe,
-		Icons: func() []dms.Icon {
-			var icons []dms.Icon
-			for _, size := range config.DeviceIconSizes {
-				s := strings.Split(size, ":")
-				if len(s) != 1 && len(s) != 2 {
-					log.Fatal("bad device icon size: ", size)
+				var tmp []net.Interface
+				for _, if_ := range ifs {
+					if if_.Flags&net.FlagUp == 0 || if_.MTU <= 0 {
+						continue
+					}
+					tmp = append(tmp, if_)
+				}
+				ifs = tmp
+				return
+			}(config.IfName),
+			HTTPConn: func() net.Listener {
+				if !serving {
+					// Only ffprobe reads from it, and the network services
+					// aren't started.
+					conn, err := net.Listen("tcp", "127.0.0.1:0")
+					if err != nil {
+						log.Fatal(err)
+					}
+					return conn
 				}
-				advertisedSize, err := strconv.Atoi(s[0])
+				network := "tcp"
+				host, _, err := net.SplitHostPort(config.Http)
 				if err != nil {
-					log.Fatal("bad device icon size: ", size)
+					log.Fatal(err)
+				}
+				if host == "::" {
+					network = "tcp6"
 				}
-				actualSize := advertisedSize
-				if len(s) == 2 {
-					// Force actual icon size to be different from advertised
-					actualSize, err = strconv.Atoi(s[1])
+				conn, err := net.Listen(network, config.Http)
+				if err != nil {
+					log.Fatal(err)
+				}
+				return conn
+			}(),
+			FriendlyName:        config.FriendlyName,
+			Manufacturer:        config.Manufacturer,
+			ModelName:           config.ModelName,
+			ModelNumber:         config.ModelNumber,
+			SerialNumber:        config.SerialNumber,
+			ModelURL:            config.ModelURL,
+			VendorXML:           config.VendorXML,
+			RootObjectPath:      rootObjectPath(config.Path),
+			Roots:               config.Roots,
+			Watch:               config.Watch,
+			FFProbeCache:        cache,
+			LogHeaders:          config.LogHeaders,
+			NoTranscode:         config.NoTranscode,
+			AllowDynamicStreams: config.AllowDynamicStreams,
+			ControlRate:         config.ControlRate,
+			ControlBurst:        config.ControlBurst,
+			BanThreshold:        config.BanThreshold,
+			BanDuration:         config.BanDuration,
+			CORSOrigins:         config.CORSOrigins,
+			CORSMethods:         config.CORSMethods,
+			ForceTranscodeTo:    config.ForceTranscodeTo,
+			TranscodeLogPattern: config.TranscodeLogPattern,
+			TranscodeLogMaxAge:  config.TranscodeLogMaxAge,
+			TranscodeLogMaxSize: config.TranscodeLogMaxSize,
+			NoProbe:             config.NoProbe,
+			NoDIAL:              config.NoDIAL,
+			RemuxTimeSeek:       config.RemuxTimeSeek,
+			Icons: func() []dms.Icon {
+				var icons []dms.Icon
+				for _, size := range config.DeviceIconSizes {
+					s := strings.Split(size, ":")
+					if len(s) != 1 && len(s) != 2 {
+						log.Fatal("bad device icon size: ", size)
+					}
+					advertisedSize, err := strconv.Atoi(s[0])
 					if err != nil {
 						log.Fatal("bad device icon size: ", size)
 					}
+					actualSize := advertisedSize
+					if len(s) == 2 {
+						// Force actual icon size to be different from advertised
+						actualSize, err = strconv.Atoi(s[1])
+						if err != nil {
+							log.Fatal("bad device icon size: ", size)
+						}
+					}
+					icons = append(icons, dms.Icon{
+						Width:    advertisedSize,
+						Height:   advertisedSize,
+						Depth:    8,
+						Mimetype: "image/png",
+						Bytes:    readIcon(config.DeviceIcon, uint(actualSize)),
+					})
+				}
+				return icons
+			}(),
+			IconSource: func() image.Image {
+				if len(config.DeviceIconSizes) != 0 {
+					return nil
+				}
+				return decodeIcon(config.DeviceIcon)
+			}(),
+			StallEventSubscribe: config.StallEventSubscribe,
+			NotifyInterval:      config.NotifyInterval,
+			IgnoreHidden:        config.IgnoreHidden,
+			IgnoreUnreadable:    config.IgnoreUnreadable,
+			OneFileSystem:       config.OneFileSystem,
+			FSTimeout:           config.FSTimeout,
+			SlideshowDuration:   config.SlideshowDuration,
+			SlideshowMusic:      config.SlideshowMusic,
+			IgnorePaths:         config.IgnorePaths,
+			AllowedIpNets:       config.AllowedIpNets,
+			ClientProfiles:      config.ClientProfiles,
+			MimeTypes:           config.MimeTypes,
+			Encoders:            config.Encoders,
+			AudiobookPaths:      config.AudiobookPaths,
+			ProtectedPaths:      config.ProtectedPaths,
+			PIN:                 config.PIN,
+			UnlockDuration:      config.UnlockDuration,
+			ClientRoots: func() (ret []dms.ClientRoot) {
+				for _, cr := range config.ClientRoots {
+					ret = append(ret, dms.ClientRoot{Nets: makeIpNets(cr.IPs), Paths: cr.Paths})
 				}
-				icons = append(icons, dms.Icon{
-					Width:    advertisedSize,
-					Height:   advertisedSize,
-					Depth:    8,
-					Mimetype: "image/png",
-					Bytes:    readIcon(config.DeviceIcon, uint(actualSize)),
-				})
+				return
+			}(),
+			Language:           config.Language,
+			Playback:           playback,
+			Favorites:          favorites,
+			HiddenPaths:        hidden,
+			Collections:        config.Collections,
+			Scrobblers:         scrobblers,
+			Search:             index,
+			Library:            serverLibrary,
+			WarmUpRecent:       config.WarmUpRecent,
+			WarmUpPaths:        config.WarmUpPaths,
+			WarmUpConcurrency:  config.WarmUpConcurrency,
+			FaststartCachePath: config.FaststartCachePath,
+		}
+		if torrents != nil {
+			dmsServer.Torrents = torrents
+		}
+		if config.RecordingsDir != "" && serving {
+			dmsServer.Channels = recordingChannels(logger, config.Channels, config.ChannelSources)
+			dmsServer.RecordingsDir = config.RecordingsDir
+			dmsServer.Recordings = recordings
+		}
+		if geocoder != nil {
+			dmsServer.Geocoder = geocoder
+		}
+		if tracerProvider != nil {
+			dmsServer.TracerProvider = tracerProvider
+		}
+		if config.RemoteAccess && serving {
+			tlsConfig, err := loadTLSConfig("remote access", config.RemoteCert, config.RemoteKey)
+			if err != nil {
+				log.Fatalf("remote access: %v", err)
 			}
-			return icons
-		}(),
-		StallEventSubscribe: config.StallEventSubscribe,
-		NotifyInterval:      config.NotifyInterval,
-		IgnoreHidden:        config.IgnoreHidden,
-		IgnoreUnreadable:    config.IgnoreUnreadable,
-		IgnorePaths:         config.IgnorePaths,
-		AllowedIpNets:       config.AllowedIpNets,
+			conn, err := net.Listen("tcp", config.RemoteHttp)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dmsServer.RemoteConn = conn
+			dmsServer.RemoteTLSConfig = tlsConfig
+			dmsServer.RemoteUser = config.RemoteUser
+			dmsServer.RemotePassword = config.RemotePassword
+			dmsServer.RemotePortMapping = !config.NoPortMapping
+		}
+		if config.Https != "" && serving {
+			tlsConfig, err := loadTLSConfig("HTTPS", config.HttpsCert, config.HttpsKey)
+			if err != nil {
+				log.Fatalf("HTTPS: %v", err)
+			}
+			conn, err := net.Listen("tcp", config.Https)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dmsServer.HTTPSConn = conn
+			dmsServer.HTTPSTLSConfig = tlsConfig
+			dmsServer.AdvertiseHTTPS = config.AdvertiseHttps
+		}
+		if *dumpTree != "" || *audit || *duplicates {
+			// Nothing is announced, but the server still runs, as probes and
+			// thumbnails are fetched from it.
+			dmsServer.Interfaces = []net.Interface{}
+			dmsServer.WarmUpRecent = 0
+			dmsServer.WarmUpPaths = nil
+		}
+		return dmsServer
 	}
+	dmsServer := newServer()
 	if err := dmsServer.Init(); err != nil {
 		log.Fatalf("error initing dms server: %v", err)
 	}
-	go func() {
-		if err := dmsServer.Run(); err != nil {
-			log.Fatal(err)
+	if *dumpTree != "" {
+		go dmsServer.Run()
+		err := dmsServer.DumpTree(context.Background(), os.Stdout, *dumpTree, dumpHost(dmsServer.HTTPConn.Addr()), *dumpUserAgent)
+		dmsServer.Close()
+		return err
+	}
+	if *audit {
+		var userAgent string
+		if *auditProfile != "" {
+			i := slices.IndexFunc(config.ClientProfiles, func(p dms.ClientProfile) bool { return p.Name == *auditProfile })
+			if i < 0 {
+				return fmt.Errorf("no client profile named %q", *auditProfile)
+			}
+			userAgent = config.ClientProfiles[i].UserAgent
+		}
+		go dmsServer.Run()
+		counts := make(map[dms.AuditProblem]int)
+		err := dmsServer.Audit(context.Background(), userAgent, func(f dms.AuditFinding) {
+			counts[f.Problem]++
+			fmt.Println(f)
+		})
+		dmsServer.Close()
+		for _, problem := range []dms.AuditProblem{dms.AuditIgnored, dms.AuditProbeFailed, dms.AuditNoThumbnail, dms.AuditTranscoded} {
+			logger.Printf("%s: %d", problem, counts[problem])
+		}
+		return err
+	}
+	if *duplicates {
+		go dmsServer.Run()
+		sets, err := dmsServer.FindDuplicates(context.Background(), true)
+		dmsServer.Close()
+		var reclaimable int64
+		for _, set := range sets {
+			fmt.Println(set)
+			reclaimable += set.Reclaimable()
 		}
-	}()
+		logger.Printf("%d sets of duplicates, %d bytes reclaimable", len(sets), reclaimable)
+		return err
+	}
+	if *pidFile != "" {
+		if err := writePidFile(*pidFile); err != nil {
+			return err
+		}
+		defer os.Remove(*pidFile)
+	}
+	run := func(s *dms.Server) {
+		go func() {
+			if err := s.Run(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	run(dmsServer)
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
-	<-sigs
-	err := dmsServer.Close()
+	signal.Notify(sigs, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, daemonSignals...)...)
+	for sig := range sigs {
+		if sig == statusSignal {
+			logStats(logger, dmsServer.Stats())
+			continue
+		}
+		if sig == reloadSignal {
+			logger.Printf("reloading configuration")
+			config = flagConfig.clone()
+			loadConfig()
+			// Streams in progress carry on with the old server.
+			if err := dmsServer.Close(); err != nil {
+				log.Print(err)
+			}
+			dmsServer = newServer()
+			if err := dmsServer.Init(); err != nil {
+				log.Fatalf("error initing dms server: %v", err)
+			}
+			run(dmsServer)
+			continue
+		}
+		break
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	err := dmsServer.Shutdown(ctx)
+	cancel()
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+	}
+	if library != nil {
+		// Playback is kept in the library from now on, including that from
+		// the history file.
+		if err := library.SavePlayback(playback); err != nil {
+			log.Print(err)
+		}
+	} else if err := playback.Save(config.PlaybackHistoryPath); err != nil {
+		log.Print(err)
 	}
-	if err := cache.save(config.FFprobeCachePath); err != nil {
+	if err := favorites.Save(config.FavoritesPath); err != nil {
 		log.Print(err)
 	}
+	if err := hidden.Save(config.HiddenPath); err != nil {
+		log.Print(err)
+	}
+	if err := recordings.Save(config.RecordingsPath); err != nil {
+		log.Print(err)
+	}
+	if index != nil {
+		if err := index.Save(config.SearchIndexPath); err != nil {
+			log.Print(err)
+		}
+	}
 	return nil
 }
 
-func (cache *fFprobeCache) load(path string) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return err
+// Returns the Server's RootObjectPath for the path, which is empty if only
+// roots are shared.
+func rootObjectPath(path string) string {
+	if path == "" {
+		return ""
 	}
-	defer f.Close()
-	dec := json.NewDecoder(f)
-	var items []dms.FfprobeCacheItem
-	err = dec.Decode(&items)
-	if err != nil {
-		return err
+	return filepath.Clean(path)
+}
+
+// Returns the host clients on the LAN would reach the HTTP server at, for the
+// URLs in a dumped tree.
+func dumpHost(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.IsUnspecified() {
+		return addr.String()
 	}
-	for _, item := range items {
-		cache.Set(item.Key, item.Value)
+	ip := net.IPv4(127, 0, 0, 1)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() {
+				ip = ipNet.IP
+				break
+			}
+		}
 	}
-	log.Printf("added %d items from cache", len(items))
-	return nil
+	return net.JoinHostPort(ip.String(), strconv.Itoa(tcpAddr.Port))
 }
 
-func (cache *fFprobeCache) save(path string) error {
-	cache.Lock()
-	items := cache.c.Items()
-	cache.Unlock()
-	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
-	if err != nil {
-		return err
+// Opens the ffprobe cache database, importing the JSON file that older
+// versions saved at the path on exit.
+func openFFprobeCache(path string, maxSize int64) (*dms.ProbeDB, error) {
+	var items []dms.FfprobeCacheItem
+	if b, err := os.ReadFile(path); err == nil && bytes.HasPrefix(b, []byte("[")) {
+		if err := json.Unmarshal(b, &items); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
 	}
-	enc := json.NewEncoder(f)
-	err = enc.Encode(items)
-	f.Close()
+	db, err := dms.OpenProbeDB(path, maxSize)
 	if err != nil {
-		os.Remove(f.Name())
-		return err
+		return nil, err
 	}
-	if runtime.GOOS == "windows" {
-		err = os.Remove(path)
-		if err == os.ErrNotExist {
-			err = nil
-		}
+	for _, item := range items {
+		db.Set(item.Key, item.Value)
 	}
-	if err == nil {
-		err = os.Rename(f.Name(), path)
+	if len(items) != 0 {
+		log.Printf("imported %d items into the ffprobe cache", len(items))
 	}
-	if err == nil {
-		log.Printf("saved cache with %d items", len(items))
-	} else {
-		os.Remove(f.Name())
+	return db, nil
+}
+
+// Opens the library database, replacing the JSON listings that older versions
+// saved at the path, which are scanned again.
+func openLibrary(path string) (*dms.Library, error) {
+	if b, err := os.ReadFile(path); err == nil && bytes.HasPrefix(b, []byte("{")) {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
 	}
-	return err
+	return dms.OpenLibrary(path)
 }
 
 func getIconReader(path string) (io.ReadCloser, error) {
@@ -365,7 +931,7 @@ func getIconReader(path string) (io.ReadCloser, error) {
 	return os.Open(path)
 }
 
-func readIcon(path string, size uint) []byte {
+func decodeIcon(path string) image.Image {
 	r, err := getIconReader(path)
 	if err != nil {
 		panic(err)
@@ -375,7 +941,11 @@ func readIcon(path string, size uint) []byte {
 	if err != nil {
 		panic(err)
 	}
-	return resizeImage(imageData, size)
+	return imageData
+}
+
+func readIcon(path string, size uint) []byte {
+	return resizeImage(decodeIcon(path), size)
 }
 
 func resizeImage(imageData image.Image, size uint) []byte {
@@ -405,12 +975,11 @@ func makeIpNets(s string) []*net.IPNet {
 				}
 
 			} else {
-				_, ipnet, err := net.ParseCIDR(el + "/32")
-				if err == nil {
-					nets = append(nets, ipnet)
-				} else {
-					log.Printf("unable to parse ip %q", el)
+				bits := 128
+				if v4 := ip.To4(); v4 != nil {
+					ip, bits = v4, 32
 				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
 			}
 		}
 	}
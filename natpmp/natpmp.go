@@ -0,0 +1,201 @@
+// Package natpmp maps ports on routers with NAT-PMP (RFC 6886), as Apple's
+// and many other home routers offer in place of, or besides, UPnP IGD.
+package natpmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The port gateways listen for NAT-PMP requests on.
+const Port = 5351
+
+type Protocol byte
+
+const (
+	UDP Protocol = 1
+	TCP Protocol = 2
+)
+
+func (me Protocol) String() string {
+	switch me {
+	case UDP:
+		return "UDP"
+	case TCP:
+		return "TCP"
+	}
+	return fmt.Sprintf("protocol %d", byte(me))
+}
+
+// The result codes of responses, besides success.
+var resultErrors = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized or refused",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+// Talks NAT-PMP to a gateway.
+type Client struct {
+	// The gateway's address, with the port, such as "192.168.1.1:5351".
+	Addr string
+	// How long to wait for the first response, doubled on each of the
+	// retries. 250ms if zero.
+	Timeout time.Duration
+	// How many times a request is sent before giving up. 4 if zero.
+	Tries int
+}
+
+// Returns a client of the gateway at the IP, on the usual port.
+func NewClient(gateway net.IP) *Client {
+	return &Client{Addr: net.JoinHostPort(gateway.String(), strconv.Itoa(Port))}
+}
+
+func (me *Client) String() string {
+	return "NAT-PMP gateway " + me.Addr
+}
+
+// Sends the request until a response to it comes, returning the response
+// after its result code.
+func (me *Client) call(req []byte, respLen int) ([]byte, error) {
+	conn, err := net.Dial("udp4", me.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	timeout := me.Timeout
+	if timeout == 0 {
+		timeout = 250 * time.Millisecond
+	}
+	tries := me.Tries
+	if tries == 0 {
+		tries = 4
+	}
+	buf := make([]byte, 16)
+	for range tries {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		timeout *= 2
+		for {
+			conn.SetReadDeadline(deadline)
+			n, err := conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			// Responses to other requests, or to none, are ignored.
+			if n < 4 || buf[0] != 0 || buf[1] != req[1]|0x80 {
+				continue
+			}
+			if code := binary.BigEndian.Uint16(buf[2:]); code != 0 {
+				if msg, ok := resultErrors[code]; ok {
+					return nil, fmt.Errorf("%s: %s", me, msg)
+				}
+				return nil, fmt.Errorf("%s: result code %d", me, code)
+			}
+			if n < respLen {
+				continue
+			}
+			return buf[4:n], nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no response", me)
+}
+
+// Returns the gateway's address on the internet.
+func (me *Client) ExternalAddress() (net.IP, error) {
+	resp, err := me.call([]byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	// The response has the gateway's epoch before the address.
+	return net.IP(resp[4:8]), nil
+}
+
+// Maps the external port, or one the gateway picks instead, to the internal
+// port of the host sending the request, for the lifetime. Returns the
+// external port mapped, and the lifetime the gateway granted.
+func (me *Client) AddPortMapping(protocol Protocol, internalPort, externalPort int, lifetime time.Duration) (int, time.Duration, error) {
+	req := make([]byte, 12)
+	req[1] = byte(protocol)
+	binary.BigEndian.PutUint16(req[4:], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	resp, err := me.call(req, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	mapped := binary.BigEndian.Uint16(resp[6:])
+	granted := time.Duration(binary.BigEndian.Uint32(resp[8:])) * time.Second
+	return int(mapped), granted, nil
+}
+
+// Removes the mapping to the internal port.
+func (me *Client) DeletePortMapping(protocol Protocol, internalPort int) error {
+	_, _, err := me.AddPortMapping(protocol, internalPort, 0, 0)
+	return err
+}
+
+// Returns the likely gateways of the host's IPv4 networks: those of its
+// default routes, where the OS tells them, and otherwise the first address of
+// each private network it's on, which is where home routers usually are.
+func Gateways() (ret []net.IP) {
+	if f, err := os.Open("/proc/net/route"); err == nil {
+		ret = parseRoutes(bufio.NewScanner(f))
+		f.Close()
+		if len(ret) != 0 {
+			return
+		}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP.To4()
+		if ip == nil || !ip.IsPrivate() {
+			continue
+		}
+		gw := ip.Mask(ipnet.Mask)
+		gw[3]++
+		if !gw.Equal(ip) {
+			ret = append(ret, gw)
+		}
+	}
+	return
+}
+
+// Returns the gateways of the default routes in the Linux routing table, as
+// in /proc/net/route, which has addresses in host byte order hex.
+func parseRoutes(s *bufio.Scanner) (ret []net.IP) {
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gw == 0 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.NativeEndian.PutUint32(ip, uint32(gw))
+		ret = append(ret, ip)
+	}
+	return
+}
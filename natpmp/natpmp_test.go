@@ -0,0 +1,89 @@
+package natpmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Answers NAT-PMP requests like a gateway whose external address is
+// 203.0.113.7, mapping ports to themselves plus 1000.
+func fakeGateway(t *testing.T) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp := make([]byte, 16)
+			resp[1] = buf[1] | 0x80
+			switch {
+			case n == 2 && buf[1] == 0:
+				copy(resp[8:], net.IPv4(203, 0, 113, 7).To4())
+				resp = resp[:12]
+			case n == 12 && Protocol(buf[1]) == TCP:
+				copy(resp[8:], buf[4:6])
+				external := binary.BigEndian.Uint16(buf[6:])
+				if external != 0 {
+					external += 1000
+				}
+				binary.BigEndian.PutUint16(resp[10:], external)
+				copy(resp[12:], buf[8:12])
+			default:
+				binary.BigEndian.PutUint16(resp[2:], 5)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestClient(t *testing.T) {
+	c := &Client{Addr: fakeGateway(t)}
+	ip, err := c.ExternalAddress()
+	if err != nil || !ip.Equal(net.IPv4(203, 0, 113, 7)) {
+		t.Fatalf("got %v, %v", ip, err)
+	}
+	port, lifetime, err := c.AddPortMapping(TCP, 1339, 1339, time.Hour)
+	if err != nil || port != 2339 || lifetime != time.Hour {
+		t.Fatalf("got %d, %v, %v", port, lifetime, err)
+	}
+	if err := c.DeletePortMapping(TCP, 1339); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.AddPortMapping(UDP, 1339, 1339, time.Hour); err == nil || !strings.Contains(err.Error(), "unsupported opcode") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestNoResponse(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &Client{Addr: conn.LocalAddr().String(), Timeout: time.Millisecond, Tries: 2}
+	if _, err := c.ExternalAddress(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	const routes = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+`
+	got := parseRoutes(bufio.NewScanner(strings.NewReader(routes)))
+	if len(got) != 1 || !got[0].Equal(net.IPv4(192, 168, 1, 1)) {
+		t.Fatalf("got %v", got)
+	}
+}
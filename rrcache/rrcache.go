@@ -32,6 +32,11 @@ func (c *RRCache) Size() int64 {
 	return c.size
 }
 
+// Returns the number of items in the cache.
+func (c *RRCache) Len() int {
+	return len(c.keys)
+}
+
 func (c *RRCache) Set(key interface{}, value interface{}, size int64) {
 	if size > c.capacity {
 		return
@@ -0,0 +1,138 @@
+package scrobble
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultLastFMURL = "https://ws.audioscrobbler.com/2.0/"
+
+// Scrobbles to a Last.fm account. The API key and secret come from creating
+// an API account. If there's no session key, one is fetched with the username
+// and password.
+type LastFM struct {
+	APIKey     string
+	Secret     string
+	SessionKey string
+	Username   string
+	Password   string
+	// The API endpoint, if not Last.fm's.
+	URL string
+
+	mu sync.Mutex
+}
+
+func (me *LastFM) Scrobble(t Track) error {
+	sk, err := me.sessionKey()
+	if err != nil {
+		return fmt.Errorf("getting session: %w", err)
+	}
+	params := url.Values{
+		"method":    {"track.scrobble"},
+		"artist":    {t.Artist},
+		"track":     {t.Title},
+		"timestamp": {strconv.FormatInt(t.Started.Unix(), 10)},
+		"sk":        {sk},
+	}
+	if t.Album != "" {
+		params.Set("album", t.Album)
+	}
+	if t.Length > 0 {
+		params.Set("duration", strconv.FormatInt(int64(t.Length.Seconds()), 10))
+	}
+	_, err = me.call(params)
+	return err
+}
+
+func (me *LastFM) sessionKey() (string, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.SessionKey != "" {
+		return me.SessionKey, nil
+	}
+	if me.Username == "" {
+		return "", errors.New("no session key or username")
+	}
+	var resp struct {
+		Session struct {
+			Key string `json:"key"`
+		} `json:"session"`
+	}
+	b, err := me.call(url.Values{
+		"method":   {"auth.getMobileSession"},
+		"username": {me.Username},
+		"password": {me.Password},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", err
+	}
+	if resp.Session.Key == "" {
+		return "", errors.New("no session key in response")
+	}
+	me.SessionKey = resp.Session.Key
+	return me.SessionKey, nil
+}
+
+// Makes a signed call to the API, returning the JSON response.
+func (me *LastFM) call(params url.Values) ([]byte, error) {
+	params.Set("api_key", me.APIKey)
+	params.Set("api_sig", lastFMSignature(params, me.Secret))
+	params.Set("format", "json")
+	u := me.URL
+	if u == "" {
+		u = defaultLastFMURL
+	}
+	resp, err := http.PostForm(u, params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var b json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return nil, fmt.Errorf("%s: %w", resp.Status, err)
+	}
+	var apiErr struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(b, &apiErr)
+	if apiErr.Error != 0 {
+		return nil, fmt.Errorf("last.fm error %d: %s", apiErr.Error, apiErr.Message)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return b, nil
+}
+
+// Returns the api_sig for the parameters: the MD5 of the names and values in
+// name order, followed by the secret.
+func lastFMSignature(params url.Values, secret string) string {
+	var names []string
+	for name := range params {
+		if name != "format" && name != "callback" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(params.Get(name))
+	}
+	b.WriteString(secret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package scrobble
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+const defaultListenBrainzURL = "https://api.listenbrainz.org"
+
+// Submits listens to a ListenBrainz account.
+type ListenBrainz struct {
+	// The user token, from the account's settings page.
+	Token string
+	// The server, if not ListenBrainz itself.
+	URL string
+}
+
+type listenBrainzSubmission struct {
+	ListenType string               `json:"listen_type"`
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64 `json:"listened_at"`
+	TrackMetadata struct {
+		ArtistName     string `json:"artist_name"`
+		TrackName      string `json:"track_name"`
+		ReleaseName    string `json:"release_name,omitempty"`
+		AdditionalInfo struct {
+			DurationMs       int64  `json:"duration_ms,omitempty"`
+			SubmissionClient string `json:"submission_client"`
+		} `json:"additional_info"`
+	} `json:"track_metadata"`
+}
+
+func (me *ListenBrainz) Scrobble(t Track) error {
+	var l listenBrainzListen
+	l.ListenedAt = t.Started.Unix()
+	l.TrackMetadata.ArtistName = t.Artist
+	l.TrackMetadata.TrackName = t.Title
+	l.TrackMetadata.ReleaseName = t.Album
+	l.TrackMetadata.AdditionalInfo.DurationMs = t.Length.Milliseconds()
+	l.TrackMetadata.AdditionalInfo.SubmissionClient = "dms"
+	b, err := json.Marshal(listenBrainzSubmission{
+		ListenType: "single",
+		Payload:    []listenBrainzListen{l},
+	})
+	if err != nil {
+		return err
+	}
+	url := me.URL
+	if url == "" {
+		url = defaultListenBrainzURL
+	}
+	req, err := http.NewRequest("POST", url+"/1/submit-listens", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+me.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return responseError(resp)
+}
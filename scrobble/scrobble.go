@@ -0,0 +1,50 @@
+// Package scrobble submits played tracks to Last.fm and ListenBrainz.
+package scrobble
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Tracks shorter than this aren't scrobbled.
+const minLength = 30 * time.Second
+
+// Tracks played this far are scrobbled, even if it's less than half of them.
+const maxThreshold = 4 * time.Minute
+
+// A played track.
+type Track struct {
+	Artist string
+	Album  string
+	Title  string
+	// Zero if unknown.
+	Length time.Duration
+	// When playback started.
+	Started time.Time
+}
+
+// Submits played tracks to a service.
+type Scrobbler interface {
+	Scrobble(Track) error
+}
+
+// Reports whether playback has got far enough through a track to scrobble it:
+// half way, or four minutes in, whichever is sooner.
+func Due(played, length time.Duration) bool {
+	if length < minLength {
+		return false
+	}
+	return played >= min(length/2, maxThreshold)
+}
+
+// Returns an error for an unsuccessful response, including the start of its
+// body.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s: %s", resp.Status, b)
+}
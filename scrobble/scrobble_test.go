@@ -0,0 +1,94 @@
+package scrobble
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var testTrack = Track{
+	Artist:  "Miles Davis",
+	Album:   "Kind of Blue",
+	Title:   "So What",
+	Length:  9*time.Minute + 22*time.Second,
+	Started: time.Unix(1700000000, 0),
+}
+
+func TestDue(t *testing.T) {
+	for _, c := range []struct {
+		played, length time.Duration
+		due            bool
+	}{
+		{10 * time.Second, 20 * time.Second, false},
+		{time.Minute, 3 * time.Minute, false},
+		{90 * time.Second, 3 * time.Minute, true},
+		{4 * time.Minute, 20 * time.Minute, true},
+		{time.Minute, 0, false},
+	} {
+		if a := Due(c.played, c.length); a != c.due {
+			t.Errorf("expected %v for %v of %v", c.due, c.played, c.length)
+		}
+	}
+}
+
+func TestListenBrainz(t *testing.T) {
+	var got listenBrainzSubmission
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token secret" {
+			http.Error(w, "bad request", http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	lb := &ListenBrainz{Token: "secret", URL: srv.URL}
+	if err := lb.Scrobble(testTrack); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Payload) != 1 {
+		t.Fatalf("unexpected submission %+v", got)
+	}
+	l := got.Payload[0]
+	if l.ListenedAt != 1700000000 || l.TrackMetadata.TrackName != "So What" || l.TrackMetadata.AdditionalInfo.DurationMs != 562000 {
+		t.Fatalf("unexpected listen %+v", l)
+	}
+	lb.Token = "wrong"
+	if err := lb.Scrobble(testTrack); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLastFM(t *testing.T) {
+	var scrobbled url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sig := r.PostForm.Get("api_sig")
+		r.PostForm.Del("api_sig")
+		if sig != lastFMSignature(r.PostForm, "shh") {
+			w.Write([]byte(`{"error": 13, "message": "Invalid method signature supplied"}`))
+			return
+		}
+		switch r.PostForm.Get("method") {
+		case "auth.getMobileSession":
+			w.Write([]byte(`{"session": {"name": "miles", "key": "sk1"}}`))
+		case "track.scrobble":
+			scrobbled = r.PostForm
+			w.Write([]byte(`{"scrobbles": {}}`))
+		}
+	}))
+	defer srv.Close()
+	lfm := &LastFM{APIKey: "key", Secret: "shh", Username: "miles", Password: "pw", URL: srv.URL}
+	if err := lfm.Scrobble(testTrack); err != nil {
+		t.Fatal(err)
+	}
+	if lfm.SessionKey != "sk1" || scrobbled.Get("sk") != "sk1" || scrobbled.Get("timestamp") != "1700000000" || scrobbled.Get("duration") != "562" {
+		t.Fatalf("unexpected scrobble %v", scrobbled)
+	}
+	lfm.Secret = "wrong"
+	if err := lfm.Scrobble(testTrack); err == nil {
+		t.Fatal("expected error")
+	}
+}
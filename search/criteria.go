@@ -0,0 +1,266 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A parsed UPnP ContentDirectory search criteria expression.
+type node interface {
+	match(*Document) bool
+	// Returns a superset of the IDs of the matching documents, if it can be
+	// found from the index rather than checking every document.
+	candidates(*Index) ([]string, bool)
+}
+
+// Matches every document, for the "*" criteria.
+type all struct{}
+
+func (all) match(*Document) bool               { return true }
+func (all) candidates(*Index) ([]string, bool) { return nil, false }
+
+type and [2]node
+
+func (me and) match(d *Document) bool {
+	return me[0].match(d) && me[1].match(d)
+}
+
+func (me and) candidates(idx *Index) ([]string, bool) {
+	a, aok := me[0].candidates(idx)
+	b, bok := me[1].candidates(idx)
+	switch {
+	case aok && bok && len(b) < len(a):
+		return b, true
+	case aok:
+		return a, true
+	default:
+		return b, bok
+	}
+}
+
+type or [2]node
+
+func (me or) match(d *Document) bool {
+	return me[0].match(d) || me[1].match(d)
+}
+
+func (me or) candidates(idx *Index) ([]string, bool) {
+	a, aok := me[0].candidates(idx)
+	b, bok := me[1].candidates(idx)
+	if !aok || !bok {
+		return nil, false
+	}
+	return append(a, b...), true
+}
+
+// Compares a property with a value, like `dc:title contains "blue"`.
+type relation struct {
+	property string
+	op       string
+	value    string
+}
+
+func (me relation) match(d *Document) bool {
+	values := d.Fields[me.property]
+	anyValue := func(f func(v string) bool) bool {
+		for _, v := range values {
+			if f(v) {
+				return true
+			}
+		}
+		return false
+	}
+	want := strings.ToLower(me.value)
+	switch me.op {
+	case "exists":
+		return (len(values) != 0) == (want == "true")
+	case "contains":
+		return matchWords(me.value, values, me.fuzzy())
+	case "doesnotcontain":
+		return !matchWords(me.value, values, false)
+	case "startswith":
+		return anyValue(func(v string) bool { return strings.HasPrefix(strings.ToLower(v), want) })
+	case "derivedfrom":
+		return anyValue(func(v string) bool {
+			v = strings.ToLower(v)
+			return v == want || strings.HasPrefix(v, want+".")
+		})
+	case "=":
+		return anyValue(func(v string) bool { return strings.EqualFold(v, me.value) })
+	case "!=":
+		return !anyValue(func(v string) bool { return strings.EqualFold(v, me.value) })
+	case "<":
+		return anyValue(func(v string) bool { return strings.ToLower(v) < want })
+	case "<=":
+		return anyValue(func(v string) bool { return strings.ToLower(v) <= want })
+	case ">":
+		return anyValue(func(v string) bool { return strings.ToLower(v) > want })
+	case ">=":
+		return anyValue(func(v string) bool { return strings.ToLower(v) >= want })
+	}
+	return false
+}
+
+// Reports whether the relation matches loosely. Titles are typed in by users,
+// so searches on them forgive typos and missing punctuation.
+func (me relation) fuzzy() bool {
+	return me.op == "contains" && me.property == "dc:title"
+}
+
+func (me relation) candidates(idx *Index) ([]string, bool) {
+	switch me.op {
+	case "contains", "=":
+		return idx.candidates(me.value, me.fuzzy())
+	}
+	return nil, false
+}
+
+// Parses search criteria as defined by the ContentDirectory service, such as
+// `upnp:class derivedfrom "object.item.audioItem" and dc:title contains "blue"`.
+// "and" binds more tightly than "or". String comparisons ignore case, and
+// "contains" matches values with a word starting with each word given.
+func parseCriteria(s string) (node, error) {
+	if strings.TrimSpace(s) == "*" {
+		return all{}, nil
+	}
+	p := &criteriaParser{s: s}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok, _ := p.next(); tok != "" {
+		return nil, fmt.Errorf("unexpected %q in search criteria", tok)
+	}
+	return n, nil
+}
+
+// Returns the error Index.Search would give for the criteria, if any, so they
+// can be checked before they're used.
+func CheckCriteria(criteria string) error {
+	_, err := parseCriteria(criteria)
+	return err
+}
+
+type criteriaParser struct {
+	s   string
+	pos int
+}
+
+// Returns the next token, and whether it's a quoted string.
+func (me *criteriaParser) next() (tok string, quoted bool) {
+	for me.pos < len(me.s) && unicode.IsSpace(rune(me.s[me.pos])) {
+		me.pos++
+	}
+	if me.pos == len(me.s) {
+		return "", false
+	}
+	start := me.pos
+	switch c := me.s[me.pos]; {
+	case c == '(' || c == ')':
+		me.pos++
+		return me.s[start:me.pos], false
+	case c == '"':
+		var b strings.Builder
+		for me.pos++; me.pos < len(me.s); me.pos++ {
+			switch me.s[me.pos] {
+			case '\\':
+				if me.pos+1 < len(me.s) {
+					me.pos++
+					b.WriteByte(me.s[me.pos])
+				}
+			case '"':
+				me.pos++
+				return b.String(), true
+			default:
+				b.WriteByte(me.s[me.pos])
+			}
+		}
+		return b.String(), true
+	case c == '=' || c == '!' || c == '<' || c == '>':
+		me.pos++
+		if me.pos < len(me.s) && me.s[me.pos] == '=' {
+			me.pos++
+		}
+		return me.s[start:me.pos], false
+	}
+	for me.pos < len(me.s) && !unicode.IsSpace(rune(me.s[me.pos])) && !strings.ContainsRune(`()"=!<>`, rune(me.s[me.pos])) {
+		me.pos++
+	}
+	return me.s[start:me.pos], false
+}
+
+func (me *criteriaParser) peek() string {
+	pos := me.pos
+	tok, _ := me.next()
+	me.pos = pos
+	return tok
+}
+
+func (me *criteriaParser) or() (node, error) {
+	n, err := me.and()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(me.peek(), "or") {
+		me.next()
+		m, err := me.and()
+		if err != nil {
+			return nil, err
+		}
+		n = or{n, m}
+	}
+	return n, nil
+}
+
+func (me *criteriaParser) and() (node, error) {
+	n, err := me.term()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(me.peek(), "and") {
+		me.next()
+		m, err := me.term()
+		if err != nil {
+			return nil, err
+		}
+		n = and{n, m}
+	}
+	return n, nil
+}
+
+func (me *criteriaParser) term() (node, error) {
+	tok, quoted := me.next()
+	if tok == "(" && !quoted {
+		n, err := me.or()
+		if err != nil {
+			return nil, err
+		}
+		if tok, _ := me.next(); tok != ")" {
+			return nil, errors.New("missing ')' in search criteria")
+		}
+		return n, nil
+	}
+	if tok == "" || quoted {
+		return nil, errors.New("expected property in search criteria")
+	}
+	op, _ := me.next()
+	op = strings.ToLower(op)
+	switch op {
+	case "exists":
+		v, _ := me.next()
+		v = strings.ToLower(v)
+		if v != "true" && v != "false" {
+			return nil, fmt.Errorf("expected true or false after exists, got %q", v)
+		}
+		return relation{tok, op, v}, nil
+	case "=", "!=", "<", "<=", ">", ">=", "contains", "doesnotcontain", "startswith", "derivedfrom":
+		v, quoted := me.next()
+		if !quoted {
+			return nil, fmt.Errorf("expected quoted value after %s, got %q", op, v)
+		}
+		return relation{tok, op, v}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q in search criteria", op)
+}
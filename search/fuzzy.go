@@ -0,0 +1,66 @@
+package search
+
+import "strings"
+
+// Query words shorter than this only match the start of words, as in the
+// middle of words they'd match too much.
+const minSubstringLen = 3
+
+// Returns how many typos are forgiven in a query word.
+func typoTolerance(word []rune) int {
+	switch {
+	case len(word) < 4:
+		return 0
+	case len(word) < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent characters to turn a into b.
+func editDistance(a, b []rune) int {
+	// Three rows of the dynamic programming matrix, for transpositions.
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// Reports whether a query word matches a word loosely: it's in the word, or
+// it's within the typo tolerance of the word or the start of it.
+func fuzzyMatch(query, word string) bool {
+	if strings.HasPrefix(word, query) {
+		return true
+	}
+	q, w := []rune(query), []rune(word)
+	if len(q) >= minSubstringLen && strings.Contains(word, query) {
+		return true
+	}
+	tol := typoTolerance(q)
+	if tol == 0 {
+		return false
+	}
+	if len(w) > len(q) && editDistance(q, w[:len(q)]) <= tol {
+		return true
+	}
+	return len(w)-len(q) <= tol && len(q)-len(w) <= tol && editDistance(q, w) <= tol
+}
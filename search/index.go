@@ -0,0 +1,312 @@
+// Package search implements a persistent full-text index of media metadata,
+// queried by free text or by UPnP ContentDirectory search criteria.
+package search
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// The metadata of an indexed item.
+type Document struct {
+	// Identifies the item, such as by the path of its file.
+	ID string
+	// When the item's sources last changed, so that unchanged items can be
+	// skipped when reindexing.
+	ModTime time.Time
+	// Values keyed by UPnP property, such as "dc:title" or "upnp:genre".
+	Fields map[string][]string
+	// Where the item was made, such as from a photo's GPS position.
+	Location *Location `json:",omitempty"`
+}
+
+// A position on Earth, in degrees.
+type Location struct {
+	Lat, Lon float64
+}
+
+// A full-text index of Documents. The zero value is ready for use.
+type Index struct {
+	mu   sync.Mutex
+	docs map[string]*Document
+	// The IDs of the documents containing each word.
+	postings map[string]map[string]struct{}
+	// The words in postings, in order for prefix lookups. Nil when they need
+	// sorting again.
+	words []string
+}
+
+// Returns the lowercase words of a string.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Returns the words of a value, and each pair of adjacent words joined, so
+// that "spiderman" finds "Spider-Man".
+func valueWords(v string) []string {
+	ws := words(v)
+	for i, n := 1, len(ws); i < n; i++ {
+		ws = append(ws, ws[i-1]+ws[i])
+	}
+	return ws
+}
+
+// Returns the distinct words in all the document's fields.
+func (d *Document) words() map[string]struct{} {
+	ret := make(map[string]struct{})
+	for _, values := range d.Fields {
+		for _, v := range values {
+			for _, w := range valueWords(v) {
+				ret[w] = struct{}{}
+			}
+		}
+	}
+	return ret
+}
+
+// Adds or replaces a document.
+func (me *Index) Update(doc Document) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.remove(doc.ID)
+	if me.docs == nil {
+		me.docs = make(map[string]*Document)
+		me.postings = make(map[string]map[string]struct{})
+	}
+	me.docs[doc.ID] = &doc
+	for w := range doc.words() {
+		ids := me.postings[w]
+		if ids == nil {
+			ids = make(map[string]struct{})
+			me.postings[w] = ids
+			me.words = nil
+		}
+		ids[doc.ID] = struct{}{}
+	}
+}
+
+// Removes a document, if it's in the index.
+func (me *Index) Remove(id string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.remove(id)
+}
+
+func (me *Index) remove(id string) {
+	doc, ok := me.docs[id]
+	if !ok {
+		return
+	}
+	for w := range doc.words() {
+		delete(me.postings[w], id)
+		if len(me.postings[w]) == 0 {
+			delete(me.postings, w)
+			me.words = nil
+		}
+	}
+	delete(me.docs, id)
+}
+
+// Returns the document with the ID.
+func (me *Index) Get(id string) (Document, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	doc, ok := me.docs[id]
+	if !ok {
+		return Document{}, false
+	}
+	return *doc, true
+}
+
+// Returns the number of documents.
+func (me *Index) Len() int {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return len(me.docs)
+}
+
+// Returns the IDs of all the documents.
+func (me *Index) IDs() []string {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.allIDs()
+}
+
+func (me *Index) allIDs() (ret []string) {
+	for id := range me.docs {
+		ret = append(ret, id)
+	}
+	return
+}
+
+// Returns the IDs of the documents containing a word matching the query
+// word: starting with it, or if fuzzy, matching it loosely.
+func (me *Index) withWord(query string, fuzzy bool) map[string]struct{} {
+	if me.words == nil {
+		me.words = make([]string, 0, len(me.postings))
+		for w := range me.postings {
+			me.words = append(me.words, w)
+		}
+		sort.Strings(me.words)
+	}
+	ret := make(map[string]struct{})
+	add := func(w string) {
+		for id := range me.postings[w] {
+			ret[id] = struct{}{}
+		}
+	}
+	if fuzzy {
+		for _, w := range me.words {
+			if fuzzyMatch(query, w) {
+				add(w)
+			}
+		}
+		return ret
+	}
+	for i := sort.SearchStrings(me.words, query); i < len(me.words) && strings.HasPrefix(me.words[i], query); i++ {
+		add(me.words[i])
+	}
+	return ret
+}
+
+// Returns the IDs of the documents containing a word matching each of the
+// query's words.
+func (me *Index) candidates(query string, fuzzy bool) (ret []string, ok bool) {
+	qws := words(query)
+	if len(qws) == 0 {
+		return nil, false
+	}
+	var sets []map[string]struct{}
+	for _, w := range qws {
+		sets = append(sets, me.withWord(w, fuzzy))
+	}
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+ids:
+	for id := range sets[0] {
+		for _, s := range sets[1:] {
+			if _, ok := s[id]; !ok {
+				continue ids
+			}
+		}
+		ret = append(ret, id)
+	}
+	return ret, true
+}
+
+// Reports whether each of the query's words starts a word in the values, or
+// if fuzzy, matches one loosely.
+func matchWords(query string, values []string, fuzzy bool) bool {
+	var have []string
+	for _, v := range values {
+		have = append(have, valueWords(v)...)
+	}
+	qws := words(query)
+	if len(qws) == 0 {
+		return false
+	}
+	for _, qw := range qws {
+		found := false
+		for _, w := range have {
+			if strings.HasPrefix(w, qw) || fuzzy && fuzzyMatch(qw, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the IDs of the documents matching free text. Each of its words must
+// match a word in one of the document's fields, forgiving typos and missing
+// punctuation. Documents where each word starts a word come first, then in ID
+// order.
+func (me *Index) Query(text string) []string {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	exact, _ := me.candidates(text, false)
+	sort.Strings(exact)
+	fuzzy, _ := me.candidates(text, true)
+	sort.Strings(fuzzy)
+	ret := exact
+	for _, id := range fuzzy {
+		if _, found := slices.BinarySearch(exact, id); !found {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// Returns the IDs of the documents matching UPnP ContentDirectory search
+// criteria, in order.
+func (me *Index) Search(criteria string) ([]string, error) {
+	n, err := parseCriteria(criteria)
+	if err != nil {
+		return nil, err
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	ids, ok := n.candidates(me)
+	if !ok {
+		ids = me.allIDs()
+	}
+	var ret []string
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		if n.match(me.docs[id]) {
+			ret = append(ret, id)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Reads an index saved by Save, replacing the current contents.
+func (me *Index) Load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var docs []Document
+	if err := json.Unmarshal(b, &docs); err != nil {
+		return err
+	}
+	me.mu.Lock()
+	me.docs = nil
+	me.postings = nil
+	me.words = nil
+	me.mu.Unlock()
+	for _, doc := range docs {
+		me.Update(doc)
+	}
+	return nil
+}
+
+// Writes the index to a file.
+func (me *Index) Save(path string) error {
+	me.mu.Lock()
+	docs := make([]*Document, 0, len(me.docs))
+	for _, doc := range me.docs {
+		docs = append(docs, doc)
+	}
+	b, err := json.Marshal(docs)
+	me.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
@@ -0,0 +1,129 @@
+package search
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func testIndex() *Index {
+	idx := &Index{}
+	for _, doc := range []Document{
+		{ID: "Music/blue.flac", Fields: map[string][]string{
+			"dc:title":    {"Blue in Green"},
+			"upnp:artist": {"Miles Davis"},
+			"upnp:class":  {"object.item.audioItem"},
+		}},
+		{ID: "Music/so-what.flac", Fields: map[string][]string{
+			"dc:title":    {"So What"},
+			"upnp:artist": {"Miles Davis"},
+			"upnp:genre":  {"Jazz"},
+			"upnp:class":  {"object.item.audioItem"},
+		}},
+		{ID: "Films/blue.mkv", Fields: map[string][]string{
+			"dc:title":   {"Three Colours: Blue"},
+			"upnp:genre": {"Drama"},
+			"upnp:class": {"object.item.videoItem"},
+		}},
+	} {
+		idx.Update(doc)
+	}
+	return idx
+}
+
+func TestQuery(t *testing.T) {
+	idx := testIndex()
+	for q, e := range map[string][]string{
+		"blue":       {"Films/blue.mkv", "Music/blue.flac"},
+		"BLU":        {"Films/blue.mkv", "Music/blue.flac"},
+		"miles blue": {"Music/blue.flac"},
+		"":           nil,
+		"xyz":        nil,
+		// Matches in the middle of words, typos and missing punctuation.
+		"lue":       {"Films/blue.mkv", "Music/blue.flac"},
+		"mlies blu": {"Music/blue.flac"},
+		"sowhat":    {"Music/so-what.flac"},
+		"colors":    {"Films/blue.mkv"},
+	} {
+		if a := idx.Query(q); !slices.Equal(a, e) {
+			t.Errorf("%q: expected %q but got %q", q, e, a)
+		}
+	}
+	idx.Remove("Music/blue.flac")
+	if a := idx.Query("blue"); !slices.Equal(a, []string{"Films/blue.mkv"}) {
+		t.Errorf("removed document still found: %q", a)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := testIndex()
+	for c, e := range map[string][]string{
+		`*`: {"Films/blue.mkv", "Music/blue.flac", "Music/so-what.flac"},
+		`upnp:class derivedfrom "object.item.audioItem" and dc:title contains "blue"`: {"Music/blue.flac"},
+		`(dc:title contains "blue" or upnp:genre = "jazz") and @refID exists false`:   {"Films/blue.mkv", "Music/blue.flac", "Music/so-what.flac"},
+		`upnp:artist = "Miles Davis" and upnp:genre exists true`:                      {"Music/so-what.flac"},
+		`upnp:class = "object.item.videoItem" or upnp:genre startsWith "ja"`:          {"Films/blue.mkv", "Music/so-what.flac"},
+		`dc:title doesNotContain "blue"`:                                              {"Music/so-what.flac"},
+		`dc:title contains "what" and dc:title contains "so"`:                         {"Music/so-what.flac"},
+		`dc:title = "So \"What"`:                                                      nil,
+		`dc:title contains "thre colurs"`:                                             {"Films/blue.mkv"},
+		`upnp:genre contains "jaz"`:                                                   {"Music/so-what.flac"},
+		`upnp:genre contains "jaxx"`:                                                  nil,
+	} {
+		a, err := idx.Search(c)
+		if err != nil {
+			t.Errorf("%s: %v", c, err)
+			continue
+		}
+		if !slices.Equal(a, e) {
+			t.Errorf("%s: expected %q but got %q", c, e, a)
+		}
+	}
+	for _, c := range []string{
+		`dc:title contains blue`,
+		`(dc:title contains "blue"`,
+		`dc:title like "blue"`,
+		`dc:title exists maybe`,
+		`dc:title = "a" "b"`,
+	} {
+		if _, err := idx.Search(c); err == nil {
+			t.Errorf("expected error for %s", c)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index")
+	if err := testIndex().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	var idx Index
+	if err := idx.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if a := idx.Query("jazz"); !slices.Equal(a, []string{"Music/so-what.flac"}) {
+		t.Fatalf("unexpected results %q", a)
+	}
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	for _, c := range []struct {
+		query, word string
+		match       bool
+	}{
+		{"beat", "beatles", true},
+		{"eat", "beatles", true},
+		{"ea", "beatles", false},
+		{"beatels", "beatles", true},
+		{"baetl", "beatles", true},
+		{"zeppelni", "zeppelin", true},
+		{"zepplein", "zeppelin", true},
+		{"cat", "car", false},
+		{"radiohead", "radio", false},
+		{"qwerty", "beatles", false},
+	} {
+		if a := fuzzyMatch(c.query, c.word); a != c.match {
+			t.Errorf("expected %v for %q and %q", c.match, c.query, c.word)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package soap
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 )
 
 const (
@@ -51,6 +55,15 @@ func NewFault(s string, detail interface{}) *Fault {
 	}
 }
 
+// Limits on requests, which come from any peer on the LAN. Real control
+// requests are a few kilobytes at most, and a handful of elements deep.
+const (
+	MaxRequestSize  = 64 << 10
+	MaxElementDepth = 16
+)
+
+var ErrRequestTooLarge = fmt.Errorf("SOAP request exceeds %d bytes", MaxRequestSize)
+
 type Envelope struct {
 	XMLName       xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
 	EncodingStyle string   `xml:"encodingStyle,attr"`
@@ -66,3 +79,85 @@ func NewEnvelope(action []byte) Envelope {
 	}
 }
 */
+
+// Reads a SOAP request envelope, refusing anything larger than
+// MaxRequestSize, nested deeper than MaxElementDepth, or with a DTD, so that
+// entity declarations are never seen, let alone expanded.
+func ReadEnvelope(r io.Reader) (*Envelope, error) {
+	b, err := io.ReadAll(io.LimitReader(r, MaxRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxRequestSize {
+		return nil, ErrRequestTooLarge
+	}
+	if err := checkXML(b); err != nil {
+		return nil, err
+	}
+	var env Envelope
+	if err := xml.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// Checks the document is well-formed, within the depth limit, and has no
+// directives.
+func checkXML(b []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth > MaxElementDepth {
+				return fmt.Errorf("elements nested deeper than %d", MaxElementDepth)
+			}
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			return errors.New("DTDs and other directives aren't allowed")
+		}
+	}
+	return nil
+}
+
+// Returns the name of the action element, which must be the only element in
+// the body.
+func (me Body) ActionName() (name xml.Name, err error) {
+	d := xml.NewDecoder(bytes.NewReader(me.Action))
+	depth := 0
+	found := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return name, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				if found {
+					return name, errors.New("more than one action in body")
+				}
+				name, found = t.Name, true
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if !found {
+		return name, errors.New("no action in body")
+	}
+	return
+}
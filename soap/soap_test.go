@@ -0,0 +1,67 @@
+package soap
+
+import (
+	"strings"
+	"testing"
+)
+
+const browseEnvelope = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1"><ObjectID>0</ObjectID></u:Browse></s:Body>
+</s:Envelope>`
+
+func TestReadEnvelope(t *testing.T) {
+	env, err := ReadEnvelope(strings.NewReader(browseEnvelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := env.Body.ActionName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name.Local != "Browse" || name.Space != "urn:schemas-upnp-org:service:ContentDirectory:1" {
+		t.Errorf("got action %v", name)
+	}
+}
+
+func TestReadEnvelopeRejects(t *testing.T) {
+	for name, body := range map[string]string{
+		"dtd": `<?xml version="1.0"?><!DOCTYPE e [<!ENTITY x SYSTEM "file:///etc/passwd">]>` +
+			`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:Browse>&x;</u:Browse></s:Body></s:Envelope>`,
+		"entity":    strings.Replace(browseEnvelope, "<ObjectID>0", "<ObjectID>&x;", 1),
+		"deep":      strings.Replace(browseEnvelope, "<ObjectID>0", strings.Repeat("<a>", MaxElementDepth)+strings.Repeat("</a>", MaxElementDepth)+"<ObjectID>0", 1),
+		"truncated": browseEnvelope[:len(browseEnvelope)-20],
+		"large":     strings.Replace(browseEnvelope, "<ObjectID>0", "<ObjectID>"+strings.Repeat("0", MaxRequestSize), 1),
+		"not soap":  `<html></html>`,
+	} {
+		env, err := ReadEnvelope(strings.NewReader(body))
+		if err == nil && name == "not soap" {
+			// Decodes, but has no action.
+			_, err = env.Body.ActionName()
+		}
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+		if (err == ErrRequestTooLarge) != (name == "large") {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestActionName(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		ok   bool
+	}{
+		{`<u:Browse xmlns:u="urn:x"><ObjectID>0</ObjectID></u:Browse>`, true},
+		{` <Browse/> `, true},
+		{``, false},
+		{`text`, false},
+		{`<Browse/><Search/>`, false},
+	} {
+		_, err := Body{Action: []byte(tc.body)}.ActionName()
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: got %v", tc.body, err)
+		}
+	}
+}
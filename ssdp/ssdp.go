@@ -104,10 +104,11 @@ type Server struct {
 	Services       []string
 	Devices        []string
 	IPFilter       func(net.IP) bool
-	Location       func(net.IP) string
+	Location       func(ip net.IP, userAgent string) string
 	UUID           string
 	NotifyInterval time.Duration
 	closed         chan struct{}
+	reannounce     chan struct{}
 	Logger         log.Logger
 }
 
@@ -158,6 +159,7 @@ func (me *Server) serve() {
 
 func (me *Server) Init() (err error) {
 	me.closed = make(chan struct{})
+	me.reannounce = make(chan struct{}, 1)
 	me.conn, err = makeConn(me.Interface, me.NetAddr)
 	if me.IPFilter == nil {
 		me.IPFilter = func(net.IP) bool { return true }
@@ -194,7 +196,9 @@ func (me *Server) Serve() (err error) {
 				}
 				panic(fmt.Sprint("unexpected addr type:", addr))
 			}()
-			if !me.IPFilter(ip) {
+			if !me.IPFilter(ip) || !sameFamily(ip, me.NetAddr.IP) {
+				// Announcing an address of the other family on the group is
+				// of no use to its members.
 				continue
 			}
 			if ip.IsLinkLocalUnicast() {
@@ -204,11 +208,25 @@ func (me *Server) Serve() (err error) {
 			}
 			extraHdrs := [][2]string{
 				{"CACHE-CONTROL", fmt.Sprintf("max-age=%d", 5*me.NotifyInterval/2/time.Second)},
-				{"LOCATION", me.Location(ip)},
+				{"LOCATION", me.Location(ip, "")},
 			}
 			me.notifyAll(aliveNTS, extraHdrs)
 		}
-		time.Sleep(me.NotifyInterval)
+		select {
+		case <-me.closed:
+		case <-me.reannounce:
+		case <-time.After(me.NotifyInterval):
+		}
+	}
+}
+
+// Says goodbye, and announces the device again straight away, so that control
+// points fetch its changed description.
+func (me *Server) Reannounce() {
+	me.sendByeBye()
+	select {
+	case me.reannounce <- struct{}{}:
+	default:
 	}
 }
 
@@ -346,7 +364,7 @@ func (me *Server) handle(buf []byte, sender *net.UDPAddr) {
 					}
 					return nil, false
 				case *net.IPAddr:
-					return data.IP, true
+					return data.IP, sameFamily(data.IP, sender.IP)
 				}
 				panic(addr)
 			}(); ok {
@@ -363,6 +381,11 @@ func (me *Server) handle(buf []byte, sender *net.UDPAddr) {
 	}
 }
 
+// Reports whether the IPs are both IPv4, or both IPv6.
+func sameFamily(a, b net.IP) bool {
+	return (a.To4() == nil) == (b.To4() == nil)
+}
+
 func (me *Server) makeResponse(ip net.IP, targ string, req *http.Request) (ret []byte) {
 	resp := &http.Response{
 		StatusCode: 200,
@@ -374,7 +397,7 @@ func (me *Server) makeResponse(ip net.IP, targ string, req *http.Request) (ret [
 	for _, pair := range [...][2]string{
 		{"CACHE-CONTROL", fmt.Sprintf("max-age=%d", 5*me.NotifyInterval/2/time.Second)},
 		{"EXT", ""},
-		{"LOCATION", me.Location(ip)},
+		{"LOCATION", me.Location(ip, req.Header.Get("User-Agent"))},
 		{"SERVER", me.Server},
 		{"ST", targ},
 		{"USN", me.usnFromTarget(targ)},
@@ -0,0 +1,224 @@
+// Package subtitles converts ASS, SSA and WebVTT subtitles to SRT, which more
+// renderers show.
+package subtitles
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned for subtitles that have no cues.
+var ErrNoCues = errors.New("no subtitles")
+
+// A subtitle shown for a time.
+type cue struct {
+	start, end time.Duration
+	text       string
+}
+
+// Reports whether subtitle files with the extension, such as ".ass", can be
+// converted.
+func CanConvert(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".ass", ".ssa", ".vtt":
+		return true
+	}
+	return false
+}
+
+// Converts the subtitle file with the extension to SRT.
+func ToSRT(ext string, data []byte) ([]byte, error) {
+	var (
+		cues []cue
+		err  error
+	)
+	text := strings.ReplaceAll(strings.TrimPrefix(string(data), "\ufeff"), "\r\n", "\n")
+	switch strings.ToLower(ext) {
+	case ".ass", ".ssa":
+		cues, err = parseASS(text)
+	case ".vtt":
+		cues, err = parseVTT(text)
+	default:
+		return nil, fmt.Errorf("can't convert %q subtitles", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cues) == 0 {
+		return nil, ErrNoCues
+	}
+	return formatSRT(cues), nil
+}
+
+func formatSRT(cues []cue) []byte {
+	var b bytes.Buffer
+	for i, c := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1, srtTime(c.start), srtTime(c.end), c.text)
+	}
+	return b.Bytes()
+}
+
+func srtTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// Parses a time such as 1:02:03.45, or 02:03.456 in WebVTT. The fraction may
+// have any number of digits.
+func parseTime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	secs, frac, _ := strings.Cut(s, ".")
+	parts := strings.Split(secs, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	var d time.Duration
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad time %q", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	d *= time.Second
+	if frac != "" {
+		n, err := strconv.Atoi(frac)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad time %q", s)
+		}
+		d += time.Duration(n) * time.Second / time.Duration(pow10(len(frac)))
+	}
+	return d, nil
+}
+
+func pow10(n int) int {
+	ret := 1
+	for range n {
+		ret *= 10
+	}
+	return ret
+}
+
+// ASS override blocks, such as {\i1} or {\pos(10,20)}.
+var assOverride = regexp.MustCompile(`\{[^}]*\}`)
+
+// The override tags kept, as SRT has them too.
+var assStyleTags = map[string]string{
+	`\i1`: "<i>", `\i0`: "</i>",
+	`\b1`: "<b>", `\b0`: "</b>",
+	`\u1`: "<u>", `\u0`: "</u>",
+}
+
+// Parses the dialogue of the [Events] section of ASS or SSA subtitles, in the
+// order it's shown.
+func parseASS(text string) (cues []cue, err error) {
+	var (
+		inEvents bool
+		format   []string
+	)
+	for line := range strings.SplitSeq(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Format":
+			format = strings.Split(value, ",")
+			for i := range format {
+				format[i] = strings.TrimSpace(format[i])
+			}
+		case "Dialogue":
+			if format == nil {
+				return nil, errors.New("dialogue before the events format")
+			}
+			fields := strings.SplitN(value, ",", len(format))
+			if len(fields) != len(format) {
+				continue
+			}
+			var c cue
+			for i, name := range format {
+				switch name {
+				case "Start":
+					c.start, err = parseTime(fields[i])
+				case "End":
+					c.end, err = parseTime(fields[i])
+				case "Text":
+					c.text = assText(fields[i])
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			if c.text != "" {
+				cues = append(cues, c)
+			}
+		}
+	}
+	// Dialogue isn't necessarily in order, as it's styled by line.
+	slices.SortStableFunc(cues, func(a, b cue) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	return
+}
+
+func assText(s string) string {
+	s = assOverride.ReplaceAllStringFunc(s, func(block string) (ret string) {
+		for tag := range strings.SplitSeq(block[1:len(block)-1], `\`) {
+			ret += assStyleTags[`\`+tag]
+		}
+		return
+	})
+	s = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(s)
+	return strings.TrimSpace(s)
+}
+
+// WebVTT tags other than those SRT has too, such as <c.yellow>, <v Bob> or
+// <00:01.000>.
+var vttTag = regexp.MustCompile(`</?(?:[^ibu/>][^>]*|[ibu][^>]+)>`)
+
+// Parses the cues of WebVTT subtitles.
+func parseVTT(text string) (cues []cue, err error) {
+	if !strings.HasPrefix(text, "WEBVTT") {
+		return nil, errors.New("not WebVTT")
+	}
+	for block := range strings.SplitSeq(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		// The timing comes after an optional identifier.
+		i := slices.IndexFunc(lines, func(l string) bool { return strings.Contains(l, "-->") })
+		if i == -1 || i > 1 {
+			// The header, and NOTE, STYLE and REGION blocks.
+			continue
+		}
+		start, rest, _ := strings.Cut(lines[i], "-->")
+		// Settings such as align:start follow the end time.
+		end, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		var c cue
+		if c.start, err = parseTime(start); err != nil {
+			return nil, err
+		}
+		if c.end, err = parseTime(end); err != nil {
+			return nil, err
+		}
+		c.text = strings.TrimSpace(vttTag.ReplaceAllString(strings.Join(lines[i+1:], "\n"), ""))
+		c.text = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ").Replace(c.text)
+		if c.text != "" {
+			cues = append(cues, c)
+		}
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package subtitles
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestASSToSRT(t *testing.T) {
+	ass := "\ufeff[Script Info]\r\nTitle: Test\r\n\r\n[V4+ Styles]\r\nFormat: Name, Fontname\r\nStyle: Default,Arial\r\n\r\n" +
+		"[Events]\r\n" +
+		"Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\r\n" +
+		"Dialogue: 0,0:00:05.50,0:00:07.00,Default,,0,0,0,,Second, with a comma\r\n" +
+		"Comment: 0,0:00:02.00,0:00:03.00,Default,,0,0,0,,Not shown\r\n" +
+		"Dialogue: 0,0:00:01.00,0:00:03.25,Default,,0,0,0,,{\\pos(10,20)}Hello {\\i1}world{\\i0}\\Nline two\r\n"
+	got, err := ToSRT(".ass", []byte(ass))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1\n00:00:01,000 --> 00:00:03,250\nHello <i>world</i>\nline two\n\n" +
+		"2\n00:00:05,500 --> 00:00:07,000\nSecond, with a comma\n\n"
+	if string(got) != want {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestVTTToSRT(t *testing.T) {
+	vtt := "WEBVTT - Test\n\nNOTE a comment\nover lines\n\nSTYLE\n::cue { color: yellow }\n\n" +
+		"intro\n00:01.000 --> 00:04.500 align:start\n<v Bob>Hi <i>there</i></v>\n\n" +
+		"01:02:03.004 --> 01:02:05.000\n<c.yellow>Fish</c> &amp; chips\n"
+	got, err := ToSRT(".VTT", []byte(vtt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1\n00:00:01,000 --> 00:00:04,500\nHi <i>there</i>\n\n" +
+		"2\n01:02:03,004 --> 01:02:05,000\nFish & chips\n\n"
+	if string(got) != want {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestToSRTErrors(t *testing.T) {
+	if _, err := ToSRT(".vtt", []byte("WEBVTT\n\n")); !errors.Is(err, ErrNoCues) {
+		t.Errorf("empty: %v", err)
+	}
+	if _, err := ToSRT(".vtt", []byte("1\n00:00:01,000 --> 00:00:02,000\nsrt\n")); err == nil {
+		t.Error("converted SRT as WebVTT")
+	}
+	if _, err := ToSRT(".sub", nil); err == nil || CanConvert(".sub") {
+		t.Error("converted .sub")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"0:00:01.5":  1500 * time.Millisecond,
+		"1:02:03.45": time.Hour + 2*time.Minute + 3450*time.Millisecond,
+		"02:03.456":  2*time.Minute + 3456*time.Millisecond,
+		"00:00:10":   10 * time.Second,
+	} {
+		if d, err := parseTime(s); err != nil || d != want {
+			t.Errorf("%q: %v, %v", s, d, err)
+		}
+	}
+	if _, err := parseTime("5"); err == nil {
+		t.Error("parsed 5")
+	}
+}
@@ -0,0 +1,353 @@
+// Package torrentfs serves the files of torrents as an fs.FS, downloading the
+// pieces that are read, so they can be streamed before they're complete.
+package torrentfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// An FS listing the files of the Client's torrents, under their names. Only
+// torrents whose info has been fetched are listed. Files are listed read-only,
+// modified when their torrent was added.
+type FS struct {
+	Client *torrent.Client
+	Logger log.Logger
+
+	mu    sync.Mutex
+	added map[metainfo.Hash]time.Time
+}
+
+// Returns an FS of the client's torrents.
+func New(cl *torrent.Client) *FS {
+	return &FS{Client: cl, Logger: log.Default}
+}
+
+func (me *FS) addTorrent(t *torrent.Torrent) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.added == nil {
+		me.added = make(map[metainfo.Hash]time.Time)
+	}
+	if _, ok := me.added[t.InfoHash()]; !ok {
+		me.added[t.InfoHash()] = time.Now()
+	}
+}
+
+// Adds the torrent in the .torrent file.
+func (me *FS) AddFile(filename string) (*torrent.Torrent, error) {
+	t, err := me.Client.AddTorrentFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	me.addTorrent(t)
+	return t, nil
+}
+
+// Adds the torrent of the magnet link. Its files are listed once its info is
+// fetched from peers.
+func (me *FS) AddMagnet(uri string) (*torrent.Torrent, error) {
+	t, err := me.Client.AddMagnet(uri)
+	if err != nil {
+		return nil, err
+	}
+	me.addTorrent(t)
+	return t, nil
+}
+
+// Drops the torrent, leaving what's been downloaded of it on disk. It reports
+// whether there was such a torrent.
+func (me *FS) Remove(h metainfo.Hash) bool {
+	t, ok := me.Client.Torrent(h)
+	if !ok {
+		return false
+	}
+	t.Drop()
+	me.mu.Lock()
+	delete(me.added, h)
+	me.mu.Unlock()
+	return true
+}
+
+// The state of a torrent, for the API.
+type Status struct {
+	InfoHash string `json:"infoHash"`
+	// Empty until the info is fetched, for magnet links.
+	Name      string    `json:"name,omitempty"`
+	Length    int64     `json:"length"`
+	Completed int64     `json:"completed"`
+	Peers     int       `json:"peers"`
+	Added     time.Time `json:"added"`
+}
+
+// Returns the state of the torrents, in the order they were added.
+func (me *FS) Torrents() (ret []Status) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, t := range me.Client.Torrents() {
+		s := Status{
+			InfoHash: t.InfoHash().HexString(),
+			Added:    me.added[t.InfoHash()],
+			Peers:    t.Stats().ActivePeers,
+		}
+		if t.Info() != nil {
+			s.Name = t.Name()
+			s.Length = t.Length()
+			s.Completed = t.BytesCompleted()
+		}
+		ret = append(ret, s)
+	}
+	slices.SortFunc(ret, func(a, b Status) int {
+		return a.Added.Compare(b.Added)
+	})
+	return
+}
+
+// Adds the .torrent files in the directory, and drops the torrents of those
+// removed from it, checking every interval until the context is done.
+func (me *FS) Watch(ctx context.Context, dir string, interval time.Duration) {
+	// The torrent added from each file.
+	watched := make(map[string]metainfo.Hash)
+	for {
+		me.scan(dir, watched)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (me *FS) scan(dir string, watched map[string]metainfo.Hash) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.torrent"))
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		if _, ok := watched[name]; ok {
+			continue
+		}
+		t, err := me.AddFile(name)
+		if err != nil {
+			me.Logger.Levelf(log.Warning, "adding torrent %q: %v", name, err)
+			continue
+		}
+		me.Logger.Printf("added torrent %q from %q", t.Name(), name)
+		watched[name] = t.InfoHash()
+	}
+	for name, h := range watched {
+		if !slices.Contains(names, name) {
+			me.Remove(h)
+			delete(watched, name)
+		}
+	}
+}
+
+// Returns the files of the torrents with info, by their paths in the FS.
+func (me *FS) files() map[string]*torrent.File {
+	ret := make(map[string]*torrent.File)
+	for _, t := range me.Client.Torrents() {
+		select {
+		case <-t.GotInfo():
+		default:
+			continue
+		}
+		for _, f := range t.Files() {
+			// The first torrent with a path has it.
+			if _, ok := ret[f.Path()]; !ok && fs.ValidPath(f.Path()) {
+				ret[f.Path()] = f
+			}
+		}
+	}
+	return ret
+}
+
+func (me *FS) modTime(t *torrent.Torrent) time.Time {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.added[t.InfoHash()]
+}
+
+func (me *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	files := me.files()
+	if f, ok := files[name]; ok {
+		r := f.NewReader()
+		// Playback can start before the pieces are verified.
+		r.SetResponsive()
+		return &file{r, fileInfo{path.Base(name), f.Length(), 0o444, me.modTime(f.Torrent())}}, nil
+	}
+	d := &dir{info: fileInfo{path.Base(name), 0, fs.ModeDir | 0o555, time.Time{}}}
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	seen := make(map[string]bool)
+	for p, f := range files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			continue
+		}
+		child, _, isDir := strings.Cut(rest, "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+		info := fileInfo{child, f.Length(), 0o444, me.modTime(f.Torrent())}
+		if isDir {
+			info.size, info.mode = 0, fs.ModeDir|0o555
+		}
+		if info.modTime.After(d.info.modTime) {
+			d.info.modTime = info.modTime
+		}
+		d.entries = append(d.entries, fs.FileInfoToDirEntry(info))
+	}
+	if name != "." && len(d.entries) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	slices.SortFunc(d.entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return d, nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (me fileInfo) Name() string       { return me.name }
+func (me fileInfo) Size() int64        { return me.size }
+func (me fileInfo) Mode() fs.FileMode  { return me.mode }
+func (me fileInfo) ModTime() time.Time { return me.modTime }
+func (me fileInfo) IsDir() bool        { return me.mode.IsDir() }
+func (me fileInfo) Sys() any           { return nil }
+
+// A torrent's file, read as its pieces arrive.
+type file struct {
+	torrent.Reader
+	info fileInfo
+}
+
+func (me *file) Stat() (fs.FileInfo, error) {
+	return me.info, nil
+}
+
+func (me *file) Read(b []byte) (int, error) {
+	// Readers can read on into the next file of the torrent, in the same
+	// piece.
+	pos, err := me.Reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if pos >= me.info.size {
+		return 0, io.EOF
+	}
+	return me.Reader.Read(b[:min(int64(len(b)), me.info.size-pos)])
+}
+
+type dir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+}
+
+func (me *dir) Stat() (fs.FileInfo, error) {
+	return me.info, nil
+}
+
+func (me *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: me.info.name, Err: errors.New("is a directory")}
+}
+
+func (me *dir) Close() error {
+	return nil
+}
+
+func (me *dir) ReadDir(n int) (ret []fs.DirEntry, err error) {
+	if n <= 0 {
+		ret, me.entries = me.entries, nil
+		return
+	}
+	if len(me.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(me.entries))
+	ret, me.entries = me.entries[:n], me.entries[n:]
+	return
+}
+
+// Returns a client downloading to the data directory.
+func NewClient(dataDir string) (*torrent.Client, error) {
+	if err := os.MkdirAll(dataDir, 0o750); err != nil {
+		return nil, err
+	}
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dataDir
+	return torrent.NewClient(cfg)
+}
+
+// Serves an API for the torrents. GET lists their Status as JSON. POST adds
+// the magnet link in the magnet form value, or drops the torrent with the
+// hex infoHash form value if the remove form value is set, and DELETE drops
+// it too. If the redirect form value is set, a successful POST redirects to
+// the web UI.
+func (me *FS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST", "DELETE":
+		if r.Method == "DELETE" || r.FormValue("remove") != "" {
+			var h metainfo.Hash
+			if err := h.FromHexString(r.FormValue("infoHash")); err != nil {
+				http.Error(w, "bad infoHash", http.StatusBadRequest)
+				return
+			}
+			if !me.Remove(h) {
+				http.Error(w, "no such torrent", http.StatusNotFound)
+				return
+			}
+		} else {
+			uri := r.FormValue("magnet")
+			if uri == "" {
+				http.Error(w, "no magnet", http.StatusBadRequest)
+				return
+			}
+			if _, err := me.AddMagnet(uri); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if r.Method == "POST" && r.FormValue("redirect") != "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ret := me.Torrents()
+	if ret == nil {
+		ret = []Status{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
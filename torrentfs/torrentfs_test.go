@@ -0,0 +1,119 @@
+package torrentfs
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Returns an FS of a torrent of the Film folder, whose data is already in the
+// client's data directory.
+func newTestFS(t *testing.T) (*FS, *torrent.Torrent) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "Film", "Extras"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]int{"Film.mkv": 100_000, "Extras/Trailer.mkv": 20_000} {
+		b := []byte(strings.Repeat(name, size/len(name)))
+		if err := os.WriteFile(filepath.Join(dir, "Film", name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info := metainfo.Info{PieceLength: 16 << 10}
+	if err := info.BuildFromFilePath(filepath.Join(dir, "Film")); err != nil {
+		t.Fatal(err)
+	}
+	var mi metainfo.MetaInfo
+	var err error
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dir
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	me := New(cl)
+	tor, err := cl.AddTorrent(&mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	me.addTorrent(tor)
+	return me, tor
+}
+
+func TestFS(t *testing.T) {
+	me, _ := newTestFS(t)
+	if err := fstest.TestFS(me, "Film/Film.mkv", "Film/Extras/Trailer.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(me, "Film/Extras/Trailer.mkv")
+	if err != nil || !strings.HasPrefix(string(b), "Extras/Trailer.mkv") {
+		t.Errorf("got %.20q, %v", b, err)
+	}
+	if _, err := me.Open("Film/Missing.mkv"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	me, tor := newTestFS(t)
+	w := httptest.NewRecorder()
+	me.ServeHTTP(w, httptest.NewRequest("GET", "/api/torrents", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"name":"Film"`) || !strings.Contains(body, tor.InfoHash().HexString()) {
+		t.Errorf("got %s", body)
+	}
+	w = httptest.NewRecorder()
+	me.ServeHTTP(w, httptest.NewRequest("POST", "/api/torrents", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("no magnet: got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	me.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/torrents?infoHash="+tor.InfoHash().HexString(), nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("got %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	me.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/torrents?infoHash="+tor.InfoHash().HexString(), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("removed twice: got %d", w.Code)
+	}
+}
+
+func TestScan(t *testing.T) {
+	me, tor := newTestFS(t)
+	dir := t.TempDir()
+	mi := tor.Metainfo()
+	f, err := os.Create(filepath.Join(dir, "Film.torrent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi.Write(f)
+	f.Close()
+	tor.Drop()
+	watched := make(map[string]metainfo.Hash)
+	me.scan(dir, watched)
+	if got := me.Torrents(); len(got) != 1 || got[0].Name != "Film" {
+		t.Fatalf("got %+v", got)
+	}
+	os.Remove(filepath.Join(dir, "Film.torrent"))
+	me.scan(dir, watched)
+	if got := me.Torrents(); len(got) != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package transcode
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Gains for mixing surround channels into the front left and right.
+type Downmix struct {
+	Center   float64
+	Surround float64
+	LFE      float64
+}
+
+// The ITU-R BS.775 coefficients, dropping the LFE.
+var DefaultDownmix = Downmix{
+	Center:   0.707,
+	Surround: 0.707,
+}
+
+// Channels of the ffmpeg layouts that can be mixed down, in order.
+var downmixLayouts = map[string][]string{
+	"quad":      {"FL", "FR", "BL", "BR"},
+	"5.0":       {"FL", "FR", "FC", "BL", "BR"},
+	"5.0(side)": {"FL", "FR", "FC", "SL", "SR"},
+	"5.1":       {"FL", "FR", "FC", "LFE", "BL", "BR"},
+	"5.1(side)": {"FL", "FR", "FC", "LFE", "SL", "SR"},
+	"6.1":       {"FL", "FR", "FC", "LFE", "BC", "SL", "SR"},
+	"7.1":       {"FL", "FR", "FC", "LFE", "BL", "BR", "SL", "SR"},
+}
+
+// Returns an ffmpeg pan filter mixing the channel layout down to stereo, or ""
+// if the layout isn't known.
+func (d Downmix) Filter(layout string) string {
+	channels, ok := downmixLayouts[layout]
+	if !ok {
+		return ""
+	}
+	gains := map[string]float64{
+		"FC":  d.Center,
+		"LFE": d.LFE,
+		"BC":  d.Surround,
+	}
+	side := func(front string, surrounds ...string) string {
+		terms := []string{front}
+		for _, ch := range channels {
+			g, ok := gains[ch]
+			for _, s := range surrounds {
+				if ch == s {
+					g, ok = d.Surround, true
+				}
+			}
+			if ok && g != 0 {
+				terms = append(terms, strconv.FormatFloat(g, 'g', -1, 64)+"*"+ch)
+			}
+		}
+		return front + "=" + strings.Join(terms, "+")
+	}
+	return "pan=stereo|" + side("FL", "BL", "SL") + "|" + side("FR", "BR", "SR")
+}
@@ -0,0 +1,78 @@
+package transcode
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	. "github.com/anacrolix/dms/misc"
+)
+
+// A quality an HLS stream is offered in.
+type HLSVariant struct {
+	// Names the variant in URLs, such as "720p".
+	Name string
+	// Of the video, which is scaled to it.
+	Height int
+	// In bits per second.
+	VideoBitRate int
+	AudioBitRate int
+}
+
+// Returns the peak bits per second of the variant, for the playlist.
+func (v HLSVariant) Bandwidth() int {
+	return v.VideoBitRate + v.AudioBitRate
+}
+
+// The variants HLS streams are offered in, best first. Players switch between
+// them as their throughput allows.
+var HLSLadder = []HLSVariant{
+	{Name: "1080p", Height: 1080, VideoBitRate: 5_000_000, AudioBitRate: 192_000},
+	{Name: "720p", Height: 720, VideoBitRate: 2_800_000, AudioBitRate: 128_000},
+	{Name: "480p", Height: 480, VideoBitRate: 1_400_000, AudioBitRate: 128_000},
+	{Name: "360p", Height: 360, VideoBitRate: 700_000, AudioBitRate: 96_000},
+}
+
+// The RFC 6381 codecs of the HLS segments: H.264 High profile and AAC-LC.
+const HLSCodecs = "avc1.640028,mp4a.40.2"
+
+// Returns the segment of an HLS variant from start, lasting length, as H.264
+// and AAC in MPEG-TS. Its timestamps carry on from start, so the segments
+// play as one stream.
+func HLSSegment(ctx context.Context, path string, v HLSVariant, start, length time.Duration, opts Options, stderr io.Writer) (r io.ReadCloser, err error) {
+	return transcodePipe(ctx, hlsSegmentArgs(path, v, start, length, opts), stderr)
+}
+
+func hlsSegmentArgs(path string, v HLSVariant, start, length time.Duration, opts Options) []string {
+	opts.Height = v.Height
+	if opts.BitRate == 0 {
+		opts.BitRate = v.AudioBitRate
+	}
+	if opts.Channels == 0 {
+		opts.Channels = 2
+	}
+	args := []string{"ffmpeg"}
+	args = append(args, opts.inputArgs()...)
+	args = append(args,
+		"-ss", FormatDurationSexagesimal(start),
+		"-i", path,
+		"-t", FormatDurationSexagesimal(length),
+	)
+	args = append(args, opts.streamArgs(path, start)...)
+	args = append(args, opts.HWEncoder.h264Args("-preset", "veryfast", "-profile:v", "high", "-pix_fmt", "yuv420p")...)
+	args = append(args,
+		"-b:v", strconv.Itoa(v.VideoBitRate),
+		"-maxrate", strconv.Itoa(v.VideoBitRate),
+		"-bufsize", strconv.Itoa(2*v.VideoBitRate),
+		"-c:a", "aac",
+	)
+	args = append(args, opts.args()...)
+	args = append(args,
+		"-sn", "-dn",
+		"-output_ts_offset", strconv.FormatFloat(start.Seconds(), 'f', -1, 64),
+		"-f", "mpegts",
+		"pipe:",
+	)
+	return args
+}
@@ -0,0 +1,86 @@
+package transcode
+
+import "fmt"
+
+// The hardware encoding APIs HWEncoder supports.
+const (
+	VAAPI = "vaapi"
+	NVENC = "nvenc"
+	QSV   = "qsv"
+)
+
+// A hardware H.264 encoder for ffmpeg to use in place of libx264. The zero
+// value is the software encoder.
+type HWEncoder struct {
+	// VAAPI, NVENC or QSV.
+	API string
+	// A DRM render node such as "/dev/dri/renderD129" for VAAPI and QSV, or
+	// a GPU number for NVENC. Empty means the first.
+	Device string
+}
+
+// The render node VAAPI uses if no Device is given.
+const defaultRenderNode = "/dev/dri/renderD128"
+
+// Reports whether the API is one HWEncoder supports.
+func KnownHWEncoderAPI(api string) bool {
+	switch api {
+	case VAAPI, NVENC, QSV:
+		return true
+	}
+	return false
+}
+
+// Returns the ffmpeg arguments opening the device, which go before the input.
+func (e HWEncoder) inputArgs() []string {
+	switch e.API {
+	case VAAPI:
+		device := e.Device
+		if device == "" {
+			device = defaultRenderNode
+		}
+		return []string{"-vaapi_device", device}
+	case QSV:
+		if e.Device != "" {
+			return []string{"-qsv_device", e.Device}
+		}
+	}
+	return nil
+}
+
+// Returns the filter moving decoded frames to the device for encoding, if
+// the encoder needs one.
+func (e HWEncoder) uploadFilter() string {
+	if e.API == VAAPI {
+		return "format=nv12,hwupload"
+	}
+	return ""
+}
+
+// Returns the ffmpeg output arguments encoding the video as H.264, with the
+// given arguments for libx264 if it's the software encoder.
+func (e HWEncoder) h264Args(software ...string) []string {
+	switch e.API {
+	case VAAPI:
+		return []string{"-c:v", "h264_vaapi"}
+	case NVENC:
+		args := []string{"-c:v", "h264_nvenc", "-preset", "p1", "-pix_fmt", "yuv420p"}
+		if e.Device != "" {
+			args = append(args, "-gpu", e.Device)
+		}
+		return args
+	case QSV:
+		return []string{"-c:v", "h264_qsv", "-preset", "veryfast", "-pix_fmt", "nv12"}
+	}
+	return append([]string{"-c:v", "libx264"}, software...)
+}
+
+func (e HWEncoder) String() string {
+	if e.API == "" {
+		return "libx264"
+	}
+	if e.Device == "" {
+		return e.API
+	}
+	return fmt.Sprintf("%s on %s", e.API, e.Device)
+}
@@ -0,0 +1,29 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// The largest width and height of images converted to JPEG, the limit of the
+// DLNA JPEG_LRG profile.
+const maxJPEGSize = 4096
+
+// Converts the first frame of an image, such as a WebP, AVIF or HEIF photo, to
+// a JPEG, shrunk if need be to fit within 4096x4096.
+func ImageToJPEG(ctx context.Context, path string, stderr io.Writer) (r io.ReadCloser, err error) {
+	return transcodePipe(ctx, imageToJPEGArgs(path), stderr)
+}
+
+func imageToJPEGArgs(path string) []string {
+	return []string{
+		"ffmpeg",
+		"-i", path,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=w='min(iw,%[1]d)':h='min(ih,%[1]d)':force_original_aspect_ratio=decrease,format=yuvj420p", maxJPEGSize),
+		"-c:v", "mjpeg", "-q:v", "2",
+		"-f", "image2pipe",
+		"pipe:",
+	}
+}
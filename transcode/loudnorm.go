@@ -0,0 +1,46 @@
+package transcode
+
+import (
+	"strconv"
+	"strings"
+)
+
+// EBU R128 targets for ffmpeg's loudnorm filter. Zero values keep ffmpeg's
+// defaults.
+type Loudnorm struct {
+	// Integrated loudness, in LUFS.
+	Integrated float64
+	// Maximum true peak, in dBTP.
+	TruePeak float64
+	// Loudness range, in LU.
+	Range float64
+}
+
+// The EBU R128 broadcast targets.
+var DefaultLoudnorm = Loudnorm{
+	Integrated: -23,
+	TruePeak:   -1,
+	Range:      7,
+}
+
+// Returns the ffmpeg loudnorm filter for the targets. The filter works at
+// 192kHz, so the sample rate should be set on the output.
+func (l Loudnorm) Filter() string {
+	var params []string
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{
+		{"I", l.Integrated},
+		{"TP", l.TruePeak},
+		{"LRA", l.Range},
+	} {
+		if p.value != 0 {
+			params = append(params, p.name+"="+strconv.FormatFloat(p.value, 'g', -1, 64))
+		}
+	}
+	if len(params) == 0 {
+		return "loudnorm"
+	}
+	return "loudnorm=" + strings.Join(params, ":")
+}
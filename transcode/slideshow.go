@@ -0,0 +1,99 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How a slideshow of images is made into a video.
+type SlideshowOptions struct {
+	// How long each image is shown.
+	Duration time.Duration
+	// An audio file or URL played, looping, under the images, or "" for
+	// silence.
+	Music string
+	// The frame size the images are scaled to fit.
+	Width, Height int
+}
+
+// The frame size of slideshows that don't give one.
+const slideshowWidth, slideshowHeight = 1920, 1080
+
+// Streams the images, which are paths or URLs, as an H.264 video in the
+// given ffmpeg format ("mp4" or "mpegts"), starting at the given position.
+func Slideshow(ctx context.Context, images []string, format string, start time.Duration, opts SlideshowOptions, stderr io.Writer) (r io.ReadCloser, err error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images")
+	}
+	if opts.Duration <= 0 {
+		return nil, fmt.Errorf("bad image duration: %s", opts.Duration)
+	}
+	list := slideshowList(images, start, opts.Duration)
+	return transcodePipeInput(ctx, slideshowArgs(format, opts), strings.NewReader(list), stderr)
+}
+
+// Returns an ffconcat list showing each image for the duration, less those
+// that would have been shown before start.
+func slideshowList(images []string, start, duration time.Duration) string {
+	skip := int(start / duration)
+	if skip >= len(images) {
+		skip = len(images) - 1
+	}
+	first := duration - (start - time.Duration(skip)*duration)
+	if first <= 0 {
+		first = duration
+	}
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	for i, image := range images[skip:] {
+		d := duration
+		if i == 0 {
+			d = first
+		}
+		fmt.Fprintf(&b, "file %s\nduration %s\n", ffconcatQuote(image), strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+	}
+	// The concat demuxer ignores the duration of the last file unless it's
+	// followed by another.
+	fmt.Fprintf(&b, "file %s\n", ffconcatQuote(images[len(images)-1]))
+	return b.String()
+}
+
+// Quotes a path for an ffconcat list, where a quote can't appear within
+// quotes.
+func ffconcatQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func slideshowArgs(format string, opts SlideshowOptions) []string {
+	w, h := opts.Width, opts.Height
+	if w <= 0 || h <= 0 {
+		w, h = slideshowWidth, slideshowHeight
+	}
+	args := []string{
+		"ffmpeg",
+		"-f", "concat", "-safe", "0",
+		"-protocol_whitelist", "pipe,file,http,https,tcp,tls",
+		"-i", "pipe:0",
+	}
+	if opts.Music != "" {
+		args = append(args, "-stream_loop", "-1", "-i", opts.Music)
+	}
+	args = append(args,
+		"-map", "0:v",
+		"-vf", fmt.Sprintf("scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=25,format=yuv420p", w, h),
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "stillimage", "-crf", "23",
+	)
+	if opts.Music != "" {
+		args = append(args, "-map", "1:a", "-c:a", "aac", "-b:a", "192k", "-shortest")
+	} else {
+		args = append(args, "-an")
+	}
+	if format == "mp4" {
+		args = append(args, "-movflags", "frag_keyframe+empty_moov")
+	}
+	return append(args, "-f", format, "pipe:")
+}
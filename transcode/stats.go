@@ -0,0 +1,113 @@
+package transcode
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How far ffmpeg has got, from the stats it writes to stderr as it encodes.
+type Stats struct {
+	// The bytes of output so far.
+	Size int64
+	// The position reached in the output.
+	Time time.Duration
+}
+
+// Parses a stats line from ffmpeg, like "frame=  240 fps= 48 q=28.0
+// size=    1536KiB time=00:00:10.00 bitrate=1258.3kbits/s speed=2.01x".
+func ParseStats(line string) (s Stats, ok bool) {
+	var haveSize, haveTime bool
+	fields := strings.Fields(line)
+	for len(fields) != 0 {
+		// Values may be padded, as in "size=    1536KiB".
+		key, value, _ := strings.Cut(fields[0], "=")
+		if value == "" && len(fields) > 1 {
+			value = fields[1]
+			fields = fields[1:]
+		}
+		fields = fields[1:]
+		switch key {
+		case "size", "Lsize":
+			s.Size, haveSize = parseStatsSize(value)
+		case "time":
+			s.Time, haveTime = parseStatsTime(value)
+		}
+	}
+	return s, haveSize && haveTime
+}
+
+func parseStatsSize(v string) (int64, bool) {
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		bytes  int64
+	}{
+		{"KiB", 1 << 10}, {"kB", 1 << 10}, {"MiB", 1 << 20}, {"MB", 1 << 20}, {"B", 1},
+	} {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			v, unit = n, u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n * unit, err == nil && n >= 0
+}
+
+// Parses an "HH:MM:SS.ss" time. Times before the output starts are given as
+// negative, and are no use.
+func parseStatsTime(v string) (time.Duration, bool) {
+	parts := strings.Split(v, ":")
+	if len(parts) != 3 || strings.HasPrefix(v, "-") {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second)), true
+}
+
+// Keeps the latest Stats in what ffmpeg writes to stderr, passing it all on
+// to W, if it's set.
+type StatsWriter struct {
+	W      io.Writer
+	mu     sync.Mutex
+	line   []byte
+	latest Stats
+	ok     bool
+}
+
+func (me *StatsWriter) Write(b []byte) (int, error) {
+	me.mu.Lock()
+	for _, c := range b {
+		// ffmpeg ends stats lines with '\r' to overwrite them on a terminal.
+		if c != '\r' && c != '\n' {
+			me.line = append(me.line, c)
+			continue
+		}
+		if bytes.Contains(me.line, []byte("time=")) {
+			if s, ok := ParseStats(string(me.line)); ok {
+				me.latest, me.ok = s, true
+			}
+		}
+		me.line = me.line[:0]
+	}
+	me.mu.Unlock()
+	if me.W == nil {
+		return len(b), nil
+	}
+	return me.W.Write(b)
+}
+
+// Returns the latest Stats, if there have been any.
+func (me *StatsWriter) Latest() (Stats, bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	return me.latest, me.ok
+}
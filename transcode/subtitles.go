@@ -0,0 +1,25 @@
+package transcode
+
+import (
+	"context"
+	"io"
+	"strconv"
+)
+
+// Returns the embedded subtitle track of the file, by its index among the
+// file's subtitle streams, as SRT. Only text subtitles can be converted, not
+// pictures.
+func Subtitles(ctx context.Context, path string, index int, stderr io.Writer) (r io.ReadCloser, err error) {
+	return transcodePipe(ctx, subtitlesArgs(path, index), stderr)
+}
+
+func subtitlesArgs(path string, index int) []string {
+	return []string{
+		"ffmpeg",
+		"-i", path,
+		"-map", "0:s:" + strconv.Itoa(index),
+		"-c:s", "srt",
+		"-f", "srt",
+		"pipe:",
+	}
+}
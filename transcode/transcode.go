@@ -3,26 +3,90 @@
 package transcode
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"os"
 	"os/exec"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/anacrolix/ffprobe"
 	"github.com/anacrolix/log"
 
+	"github.com/anacrolix/dms/dsd"
 	. "github.com/anacrolix/dms/misc"
 )
 
+// What a transcode's command used, and how it ended.
+type Usage struct {
+	// User and system CPU time.
+	CPUTime time.Duration
+	// Why the command failed, with the last thing it logged, or "" if it
+	// succeeded, or was stopped by its context or its output being closed.
+	Failure string
+}
+
+// The stdout of a transcode's command.
+type process struct {
+	io.ReadCloser
+	done  chan struct{}
+	usage Usage
+	// Whether the output was read to the end, or closed before that.
+	eof, closedEarly atomic.Bool
+}
+
+func (me *process) Read(b []byte) (n int, err error) {
+	n, err = me.ReadCloser.Read(b)
+	if err == io.EOF {
+		me.eof.Store(true)
+	}
+	return
+}
+
+func (me *process) Close() error {
+	if !me.eof.Load() {
+		me.closedEarly.Store(true)
+	}
+	return me.ReadCloser.Close()
+}
+
+// Returns the Usage of the command of a reader returned by this package,
+// once it exits, and false if the reader doesn't come from a command. Close
+// the reader first, or the command may wait for it to be read.
+func WaitUsage(r io.Reader) (Usage, bool) {
+	p, ok := r.(*process)
+	if !ok {
+		return Usage{}, false
+	}
+	<-p.done
+	return p.usage, true
+}
+
 // Invokes an external command and returns a reader from its stdout. The
-// command is waited on asynchronously.
-func transcodePipe(args []string, stderr io.Writer) (r io.ReadCloser, err error) {
+// command is waited on asynchronously, and killed if the context is done
+// first.
+func transcodePipe(ctx context.Context, args []string, stderr io.Writer) (r io.ReadCloser, err error) {
+	return transcodePipeInput(ctx, args, nil, stderr)
+}
+
+// Like transcodePipe, with the command reading its stdin from the given
+// reader, if it isn't nil.
+func transcodePipeInput(ctx context.Context, args []string, stdin io.Reader, stderr io.Writer) (r io.ReadCloser, err error) {
 	log.Println("transcode command:", args)
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stderr = stderr
-	r, err = cmd.StdoutPipe()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = stdin
+	var tail lastLine
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, &tail)
+	} else {
+		cmd.Stderr = &tail
+	}
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return
 	}
@@ -30,18 +94,77 @@ func transcodePipe(args []string, stderr io.Writer) (r io.ReadCloser, err error)
 	if err != nil {
 		return
 	}
+	p := &process{ReadCloser: stdout, done: make(chan struct{})}
 	go func() {
+		defer close(p.done)
 		err := cmd.Wait()
+		if cmd.ProcessState != nil {
+			p.usage.CPUTime = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+		}
 		if err != nil {
 			log.Printf("command %s failed: %s", args, err)
+			if ctx.Err() == nil && !p.closedEarly.Load() {
+				p.usage.Failure = err.Error()
+				if line := tail.String(); line != "" {
+					p.usage.Failure += ": " + line
+				}
+			}
 		}
 	}()
-	return
+	return p, nil
+}
+
+// Keeps the last line written to it that says something, as ffmpeg's last
+// words are usually why it failed.
+type lastLine struct {
+	line, partial []byte
+}
+
+func (me *lastLine) Write(b []byte) (int, error) {
+	for _, c := range b {
+		if c != '\n' && c != '\r' {
+			if len(me.partial) < 1024 {
+				me.partial = append(me.partial, c)
+			}
+			continue
+		}
+		me.endLine()
+	}
+	return len(b), nil
+}
+
+func (me *lastLine) endLine() {
+	// ffmpeg follows the reason it failed with this.
+	if s := strings.TrimSpace(string(me.partial)); s != "" && s != "Conversion failed!" {
+		me.line = append(me.line[:0], s...)
+	}
+	me.partial = me.partial[:0]
+}
+
+func (me *lastLine) String() string {
+	me.endLine()
+	return string(me.line)
+}
+
+// Runs ffprobe on the path, killing it if the context is done first.
+func Probe(ctx context.Context, path string) (*ffprobe.Info, error) {
+	pc, err := ffprobe.Start(path)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-pc.Done:
+		return pc.Info, pc.Err
+	case <-ctx.Done():
+		pc.Cmd.Process.Kill()
+		<-pc.Done
+		return nil, ctx.Err()
+	}
 }
 
 // Return a series of ffmpeg arguments that pick specific codecs for specific
 // streams. This requires use of the -map flag.
-func streamArgs(s map[string]interface{}) (ret []string) {
+func streamArgs(s map[string]interface{}, opts AudioOptions) (ret []string) {
 	defer func() {
 		if len(ret) != 0 {
 			ret = append(ret, []string{
@@ -60,6 +183,10 @@ func streamArgs(s map[string]interface{}) (ret []string) {
 		*/
 		return []string{"-target", "pal-dvd"}
 	case "audio":
+		if !opts.IsZero() {
+			// Adjusting the audio requires reencoding it.
+			return append([]string{"-acodec", "ac3", "-ab", "224k"}, opts.args()...)
+		}
 		if s["codec_name"] == "dca" {
 			return []string{"-acodec", "ac3", "-ab", "224k", "-ac", "2"}
 		} else {
@@ -72,13 +199,14 @@ func streamArgs(s map[string]interface{}) (ret []string) {
 }
 
 // Streams the desired file in the MPEG_PS_PAL DLNA profile.
-func Transcode(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+func Transcode(ctx context.Context, path string, start, length time.Duration, opts Options, stderr io.Writer) (r io.ReadCloser, err error) {
 	args := []string{
 		"ffmpeg",
 		"-threads", strconv.FormatInt(int64(runtime.NumCPU()), 10),
 		"-async", "1",
-		"-ss", FormatDurationSexagesimal(start),
 	}
+	args = append(args, opts.inputArgs()...)
+	args = append(args, "-ss", FormatDurationSexagesimal(start))
 	if length >= 0 {
 		args = append(args, []string{
 			"-t", FormatDurationSexagesimal(length),
@@ -87,25 +215,44 @@ func Transcode(path string, start, length time.Duration, stderr io.Writer) (r io
 	args = append(args, []string{
 		"-i", path,
 	}...)
-	info, err := ffprobe.Run(path)
+	info, err := Probe(ctx, path)
 	if err != nil {
 		return
 	}
+	burn := opts.burnFilter(path, start)
+	track := 0
 	for _, s := range info.Streams {
-		args = append(args, streamArgs(s)...)
+		switch s["codec_type"] {
+		case "audio":
+			track++
+			if opts.Track != 0 && track != opts.Track {
+				continue
+			}
+		case "video", "subtitle":
+			if burn != "" {
+				continue
+			}
+		}
+		args = append(args, streamArgs(s, opts.AudioOptions)...)
+	}
+	if burn != "" {
+		args = append(args, "-filter_complex", burn, "-map", "[v]", "-target", "pal-dvd")
+	} else if source := opts.sourceFilters(); len(source) != 0 {
+		args = append(args, "-vf", strings.Join(source, ","))
 	}
 	args = append(args, []string{"-f", "mpegts", "pipe:"}...)
-	return transcodePipe(args, stderr)
+	return transcodePipe(ctx, args, stderr)
 }
 
 // Returns a stream of Chromecast supported VP8.
-func VP8Transcode(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+func VP8Transcode(ctx context.Context, path string, start, length time.Duration, opts Options, stderr io.Writer) (r io.ReadCloser, err error) {
 	args := []string{
 		"avconv",
 		"-threads", strconv.FormatInt(int64(runtime.NumCPU()), 10),
 		"-async", "1",
-		"-ss", FormatDurationSexagesimal(start),
 	}
+	args = append(args, opts.inputArgs()...)
+	args = append(args, "-ss", FormatDurationSexagesimal(start))
 	if length > 0 {
 		args = append(args, []string{
 			"-t", FormatDurationSexagesimal(length),
@@ -115,44 +262,322 @@ func VP8Transcode(path string, start, length time.Duration, stderr io.Writer) (r
 		"-i", path,
 		// "-deadline", "good",
 		// "-c:v", "libvpx", "-crf", "10",
+	}...)
+	args = append(args, opts.streamArgs(path, start)...)
+	args = append(args, opts.args()...)
+	args = append(args, []string{
 		"-f", "webm",
 		"pipe:",
 	}...)
-	return transcodePipe(args, stderr)
+	return transcodePipe(ctx, args, stderr)
 }
 
 // Returns a stream of Chromecast supported matroska.
-func ChromecastTranscode(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+func ChromecastTranscode(ctx context.Context, path string, start, length time.Duration, opts Options, stderr io.Writer) (r io.ReadCloser, err error) {
+	args := []string{"ffmpeg"}
+	args = append(args, opts.inputArgs()...)
+	args = append(args, "-ss", FormatDurationSexagesimal(start), "-i", path)
+	args = append(args, opts.HWEncoder.h264Args("-preset", "ultrafast", "-profile:v", "high", "-level", "5.0")...)
+	args = append(args, "-movflags", "+faststart+frag_keyframe+empty_moov")
+	if length > 0 {
+		args = append(args, []string{
+			"-t", FormatDurationSexagesimal(length),
+		}...)
+	}
+	args = append(args, opts.streamArgs(path, start)...)
+	args = append(args, opts.args()...)
+	args = append(args, []string{
+		"-f", "mp4",
+		"pipe:",
+	}...)
+	return transcodePipe(ctx, args, stderr)
+}
+
+// Returns a stream of h264 video and mp3 audio
+func WebTranscode(ctx context.Context, path string, start, length time.Duration, opts Options, stderr io.Writer) (r io.ReadCloser, err error) {
+	args := []string{"ffmpeg"}
+	args = append(args, opts.inputArgs()...)
+	args = append(args, "-ss", FormatDurationSexagesimal(start), "-i", path)
+	args = append(args, opts.HWEncoder.h264Args("-crf", "25", "-preset", "ultrafast", "-pix_fmt", "yuv420p")...)
+	args = append(args,
+		"-c:a", "mp3", "-ab", "128k", "-ar", "44100",
+		"-movflags", "+faststart+frag_keyframe+empty_moov",
+	)
+	if length > 0 {
+		args = append(args, []string{
+			"-t", FormatDurationSexagesimal(length),
+		}...)
+	}
+	args = append(args, opts.streamArgs(path, start)...)
+	args = append(args, opts.args()...)
+	args = append(args, []string{
+		"-f", "mp4",
+		"pipe:",
+	}...)
+	return transcodePipe(ctx, args, stderr)
+}
+
+// Parameters for transcoded audio. Zero values keep what the source has.
+type AudioOptions struct {
+	SampleRate int
+	Channels   int
+	// An ffmpeg sample format, such as "s16" or "s32".
+	SampleFormat string
+	// ffmpeg audio filters, applied in order.
+	Filters []string
+	// In bits per second, for lossy codecs.
+	BitRate int
+	// The audio track to use, counting from 1 among the source's audio
+	// streams. Zero leaves the choice to ffmpeg.
+	Track int
+}
+
+// Reports whether the options leave the audio as it is. The Track doesn't
+// change the audio, so isn't considered.
+func (o AudioOptions) IsZero() bool {
+	return o.SampleRate == 0 && o.Channels == 0 && o.SampleFormat == "" && len(o.Filters) == 0 && o.BitRate == 0
+}
+
+// Returns the options with unset values taken from other, and other's filters
+// applied after its own.
+func (o AudioOptions) Merge(other AudioOptions) AudioOptions {
+	if o.SampleRate == 0 {
+		o.SampleRate = other.SampleRate
+	}
+	if o.Channels == 0 {
+		o.Channels = other.Channels
+	}
+	if o.SampleFormat == "" {
+		o.SampleFormat = other.SampleFormat
+	}
+	if o.BitRate == 0 {
+		o.BitRate = other.BitRate
+	}
+	if o.Track == 0 {
+		o.Track = other.Track
+	}
+	o.Filters = append(o.Filters[:len(o.Filters):len(o.Filters)], other.Filters...)
+	return o
+}
+
+// Returns the ffmpeg output arguments picking the streams for the Track: the
+// first video stream, if there is one, and the track.
+func (o AudioOptions) mapArgs() []string {
+	if o.Track == 0 {
+		return nil
+	}
+	return []string{"-map", "0:V:0?", "-map", "0:a:" + strconv.Itoa(o.Track-1)}
+}
+
+// Parameters for transcoded video.
+type Options struct {
+	AudioOptions
+	// The subtitle track burnt into the video, counting from 1 among the
+	// source's subtitle streams. Zero means none.
+	Subtitles int
+	// The subtitle track is pictures, such as PGS or DVD subtitles, so it's
+	// overlaid rather than rendered from text.
+	SubtitlesArePictures bool
+	// Used by the H.264 transcodes.
+	HWEncoder HWEncoder
+	// Scales the video to this height, keeping its aspect ratio. Zero leaves
+	// it as it is.
+	Height int
+	// Degrees, 90, 180 or 270, to turn the video clockwise to stand it
+	// upright, as phones record it with a rotation many renderers ignore.
+	// ffmpeg's own rotation is turned off, so zero leaves it as stored.
+	Rotation int
+	// The ffmpeg filter deinterlacing the video, such as "bwdif" or "yadif",
+	// or "" to leave it as it is. Only frames flagged interlaced are
+	// touched.
+	Deinterlace string
+}
+
+// Returns the ffmpeg arguments that go before the input.
+func (o Options) inputArgs() (ret []string) {
+	ret = o.HWEncoder.inputArgs()
+	if o.Rotation != 0 {
+		ret = append(ret, "-noautorotate")
+	}
+	return
+}
+
+// Returns the ffmpeg filters deinterlacing the video and turning it upright,
+// applied to the decoded frames before any others, so that subtitles are
+// burnt in the right way up.
+func (o Options) sourceFilters() (ret []string) {
+	if o.Deinterlace != "" {
+		ret = append(ret, o.Deinterlace+"=deint=interlaced")
+	}
+	switch o.Rotation {
+	case 90:
+		ret = append(ret, "transpose=clock")
+	case 180:
+		ret = append(ret, "hflip", "vflip")
+	case 270:
+		ret = append(ret, "transpose=cclock")
+	}
+	return
+}
+
+// Returns the ffmpeg filters applied to the video after any subtitles are
+// burnt in.
+func (o Options) videoFilters() (ret []string) {
+	if o.Height != 0 {
+		ret = append(ret, "scale=-2:"+strconv.Itoa(o.Height))
+	}
+	if upload := o.HWEncoder.uploadFilter(); upload != "" {
+		ret = append(ret, upload)
+	}
+	return
+}
+
+// Returns the ffmpeg filtergraph burning the subtitles into the first video
+// stream as [v], or "" if there are none. The input was seeked to start.
+func (o Options) burnFilter(input string, start time.Duration) string {
+	if o.Subtitles == 0 {
+		return ""
+	}
+	video := "[0:V:0]"
+	var graph string
+	if source := o.sourceFilters(); len(source) != 0 {
+		graph = video + strings.Join(source, ",") + "[source];"
+		video = "[source]"
+	}
+	if o.SubtitlesArePictures {
+		graph += fmt.Sprintf("%s[0:s:%d]overlay", video, o.Subtitles-1)
+	} else {
+		// The subtitles filter reads the input itself from the beginning, so
+		// the video is shifted to its timestamps there and back.
+		graph += fmt.Sprintf("%ssetpts=PTS+%s/TB,subtitles=%s:si=%d,setpts=PTS-STARTPTS",
+			video, strconv.FormatFloat(start.Seconds(), 'f', -1, 64), filterEscape(input), o.Subtitles-1)
+	}
+	for _, f := range o.videoFilters() {
+		graph += "," + f
+	}
+	return graph + "[v]"
+}
+
+// Escapes a filter option value, and then that for the filtergraph.
+func filterEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(s)
+}
+
+// Returns the ffmpeg arguments picking the streams, and burning in the
+// subtitles, for the options.
+func (o Options) streamArgs(input string, start time.Duration) []string {
+	burn := o.burnFilter(input, start)
+	if burn == "" {
+		ret := o.mapArgs()
+		if filters := append(o.sourceFilters(), o.videoFilters()...); len(filters) != 0 {
+			ret = append(ret, "-vf", strings.Join(filters, ","))
+		}
+		return ret
+	}
+	audio := "0:a:0?"
+	if o.Track != 0 {
+		audio = "0:a:" + strconv.Itoa(o.Track-1)
+	}
+	return []string{"-filter_complex", burn, "-map", "[v]", "-map", audio}
+}
+
+// Returns the ffmpeg output arguments applying the options.
+func (o AudioOptions) args() (ret []string) {
+	if o.SampleRate != 0 {
+		ret = append(ret, "-ar", strconv.Itoa(o.SampleRate))
+	}
+	if o.Channels != 0 {
+		ret = append(ret, "-ac", strconv.Itoa(o.Channels))
+	}
+	if o.SampleFormat != "" {
+		ret = append(ret, "-sample_fmt", o.SampleFormat)
+	}
+	if len(o.Filters) != 0 {
+		ret = append(ret, "-af", strings.Join(o.Filters, ","))
+	}
+	if o.BitRate != 0 {
+		ret = append(ret, "-b:a", strconv.Itoa(o.BitRate))
+	}
+	return
+}
+
+// Returns a stream of the file's audio encoded with codec, in the given ffmpeg
+// format.
+func AudioTranscode(ctx context.Context, path, format, codec string, opts AudioOptions, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
 	args := []string{
 		"ffmpeg",
 		"-ss", FormatDurationSexagesimal(start),
 		"-i", path,
-		"-c:v", "libx264", "-preset", "ultrafast", "-profile:v", "high", "-level", "5.0",
-		"-movflags", "+faststart+frag_keyframe+empty_moov",
 	}
 	if length > 0 {
 		args = append(args, []string{
 			"-t", FormatDurationSexagesimal(length),
 		}...)
 	}
+	args = append(args, opts.mapArgs()...)
 	args = append(args, []string{
-		"-f", "mp4",
+		"-vn",
+		"-c:a", codec,
+	}...)
+	args = append(args, opts.args()...)
+	args = append(args, []string{
+		"-f", format,
 		"pipe:",
 	}...)
-	return transcodePipe(args, stderr)
+	return transcodePipe(ctx, args, stderr)
 }
 
-// Returns a stream of h264 video and mp3 audio
-func WebTranscode(path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+// Returns the DSD audio in the DSF or DFF file as DoP (DSD over PCM) in a
+// 24 bit WAV. The length is ignored, the stream runs to the end of the file.
+func DoP(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+	f, err := openInput(ctx, path)
+	if err != nil {
+		return
+	}
+	s, err := dsd.Open(f)
+	if err == nil {
+		var wav io.Reader
+		wav, err = dsd.DoPWAV(s, start)
+		if err == nil {
+			return struct {
+				io.Reader
+				io.Closer
+			}{wav, f}, nil
+		}
+	}
+	f.Close()
+	return
+}
+
+// Opens a path given to a transcode, which may be a URL as well as a file.
+func openInput(ctx context.Context, path string) (io.ReadCloser, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("fetching %s: %s", path, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	return os.Open(path)
+}
+
+// Copies the streams of the file into a new container of the given ffmpeg
+// format, starting at the given position. Nothing is re-encoded, so this is
+// cheap, but seeking is only accurate to the nearest keyframe.
+func Remux(ctx context.Context, path, format string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
 	args := []string{
 		"ffmpeg",
 		"-ss", FormatDurationSexagesimal(start),
 		"-i", path,
-		"-pix_fmt", "yuv420p",
-		"-c:v", "libx264", "-crf", "25",
-		"-c:a", "mp3", "-ab", "128k", "-ar", "44100",
-		"-preset", "ultrafast",
-		"-movflags", "+faststart+frag_keyframe+empty_moov",
 	}
 	if length > 0 {
 		args = append(args, []string{
@@ -160,10 +585,95 @@ func WebTranscode(path string, start, length time.Duration, stderr io.Writer) (r
 		}...)
 	}
 	args = append(args, []string{
+		"-map", "0",
+		"-c", "copy",
+	}...)
+	if format == "mp4" {
+		args = append(args, []string{
+			"-movflags", "frag_keyframe+empty_moov",
+		}...)
+	}
+	args = append(args, []string{
+		"-f", format,
+		"pipe:",
+	}...)
+	return transcodePipe(ctx, args, stderr)
+}
+
+// Copies the streams of an MP4 into a new file with the index (moov atom) at
+// the start, so that it can be played as it's downloaded.
+func Faststart(ctx context.Context, path, outPath string, stderr io.Writer) error {
+	cmd := exec.CommandContext(ctx,
+		"ffmpeg",
+		"-i", path,
+		"-map", "0",
+		"-c", "copy",
+		"-movflags", "+faststart",
 		"-f", "mp4",
+		"-y", outPath,
+	)
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// How much of the file preceding the start position is played back for each
+// unit of rewind speed. Reversing requires buffering decoded frames, so this
+// is kept modest.
+const rewindWindowPerSpeed = 30 * time.Second
+
+// Returns a video only stream of the file in the given ffmpeg format, played
+// at speed from start. Negative speeds play backwards from start, for rewind.
+func TrickPlay(ctx context.Context, path, format string, speed float64, start time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+	args := []string{"ffmpeg"}
+	filters := []string{}
+	if speed < 0 {
+		window := time.Duration(-speed * float64(rewindWindowPerSpeed))
+		if window > start {
+			window = start
+		}
+		if window <= 0 {
+			err = fmt.Errorf("nothing to rewind before %s", FormatDurationSexagesimal(start))
+			return
+		}
+		// Only keyframes are decoded, at a reduced size, to bound the memory
+		// required by the reverse filter.
+		args = append(args, []string{
+			"-ss", FormatDurationSexagesimal(start - window),
+			"-t", FormatDurationSexagesimal(window),
+			"-skip_frame", "nokey",
+		}...)
+		filters = append(filters, "scale=-2:480", "reverse")
+		speed = -speed
+	} else {
+		args = append(args, "-ss", FormatDurationSexagesimal(start))
+	}
+	filters = append(filters, fmt.Sprintf("setpts=PTS/%g", speed))
+	args = append(args, []string{
+		"-i", path,
+		"-an",
+		"-vf", strings.Join(filters, ","),
+		"-r", "25",
+	}...)
+	switch format {
+	case "mp4":
+		args = append(args, []string{
+			"-c:v", "libx264", "-preset", "ultrafast", "-pix_fmt", "yuv420p",
+			"-movflags", "+frag_keyframe+empty_moov",
+		}...)
+	case "webm":
+		args = append(args, []string{
+			"-c:v", "libvpx", "-deadline", "realtime",
+		}...)
+	default:
+		args = append(args, []string{
+			"-c:v", "mpeg2video", "-q:v", "4",
+		}...)
+	}
+	args = append(args, []string{
+		"-f", format,
 		"pipe:",
 	}...)
-	return transcodePipe(args, stderr)
+	return transcodePipe(ctx, args, stderr)
 }
 
 // credit laurent @ https://stackoverflow.com/questions/34118732/parse-a-command-line-string-into-flags-and-arguments-in-golang
@@ -233,11 +743,11 @@ func parseCommandLine(command string) ([]string, error) {
 }
 
 // Exec runs the cmd to generate the video to stream. It does not support seeking. Used by the dynamic stream feature.
-func Exec(cmds string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+func Exec(ctx context.Context, cmds string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
 	cmda, aerr := parseCommandLine(cmds)
 	if aerr != nil {
 		err = aerr
 		return
 	}
-	return transcodePipe(cmda, stderr)
+	return transcodePipe(ctx, cmda, stderr)
 }
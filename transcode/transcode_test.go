@@ -0,0 +1,178 @@
+package transcode
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOptionsStreamArgs(t *testing.T) {
+	if a := (Options{}).streamArgs("in.mkv", 0); a != nil {
+		t.Errorf("got %q", a)
+	}
+	o := Options{AudioOptions: AudioOptions{Track: 2}, Subtitles: 3}
+	want := []string{
+		"-filter_complex", `[0:V:0]setpts=PTS+90.5/TB,subtitles=http\\://host/res?path=a\,b.mkv:si=2,setpts=PTS-STARTPTS[v]`,
+		"-map", "[v]", "-map", "0:a:1",
+	}
+	if a := o.streamArgs("http://host/res?path=a,b.mkv", 90500*time.Millisecond); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+	o = Options{Subtitles: 1, SubtitlesArePictures: true}
+	want = []string{"-filter_complex", "[0:V:0][0:s:0]overlay[v]", "-map", "[v]", "-map", "0:a:0?"}
+	if a := o.streamArgs("in.mkv", 0); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestHWEncoder(t *testing.T) {
+	o := Options{HWEncoder: HWEncoder{API: VAAPI}}
+	if a := o.HWEncoder.inputArgs(); !slices.Equal(a, []string{"-vaapi_device", "/dev/dri/renderD128"}) {
+		t.Errorf("got %q", a)
+	}
+	if a := o.streamArgs("in.mkv", 0); !slices.Equal(a, []string{"-vf", "format=nv12,hwupload"}) {
+		t.Errorf("got %q", a)
+	}
+	o.Subtitles, o.SubtitlesArePictures = 1, true
+	want := []string{"-filter_complex", "[0:V:0][0:s:0]overlay,format=nv12,hwupload[v]", "-map", "[v]", "-map", "0:a:0?"}
+	if a := o.streamArgs("in.mkv", 0); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+	nvenc := HWEncoder{API: NVENC, Device: "1"}
+	if a := nvenc.h264Args("-crf", "25"); !slices.Equal(a, []string{"-c:v", "h264_nvenc", "-preset", "p1", "-pix_fmt", "yuv420p", "-gpu", "1"}) {
+		t.Errorf("got %q", a)
+	}
+	if a := (HWEncoder{}).h264Args("-crf", "25"); !slices.Equal(a, []string{"-c:v", "libx264", "-crf", "25"}) {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestHLSSegmentArgs(t *testing.T) {
+	v := HLSVariant{Name: "720p", Height: 720, VideoBitRate: 2_800_000, AudioBitRate: 128_000}
+	opts := Options{AudioOptions: AudioOptions{Track: 2}}
+	want := []string{
+		"ffmpeg", "-ss", "0:01:30", "-i", "in.mkv", "-t", "0:00:06",
+		"-map", "0:V:0?", "-map", "0:a:1", "-vf", "scale=-2:720",
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "high", "-pix_fmt", "yuv420p",
+		"-b:v", "2800000", "-maxrate", "2800000", "-bufsize", "5600000",
+		"-c:a", "aac", "-ac", "2", "-b:a", "128000",
+		"-sn", "-dn", "-output_ts_offset", "90", "-f", "mpegts", "pipe:",
+	}
+	if a := hlsSegmentArgs("in.mkv", v, 90*time.Second, 6*time.Second, opts); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestSlideshowList(t *testing.T) {
+	images := []string{"a.jpg", "http://host/res?path=it's.jpg", "c.jpg"}
+	want := "ffconcat version 1.0\n" +
+		"file 'a.jpg'\nduration 5\n" +
+		"file 'http://host/res?path=it'\\''s.jpg'\nduration 5\n" +
+		"file 'c.jpg'\nduration 5\n" +
+		"file 'c.jpg'\n"
+	if l := slideshowList(images, 0, 5*time.Second); l != want {
+		t.Errorf("got %q", l)
+	}
+	want = "ffconcat version 1.0\n" +
+		"file 'c.jpg'\nduration 3.5\n" +
+		"file 'c.jpg'\n"
+	if l := slideshowList(images, 11500*time.Millisecond, 5*time.Second); l != want {
+		t.Errorf("got %q", l)
+	}
+}
+
+func TestSlideshowArgs(t *testing.T) {
+	want := []string{
+		"ffmpeg", "-f", "concat", "-safe", "0", "-protocol_whitelist", "pipe,file,http,https,tcp,tls", "-i", "pipe:0",
+		"-stream_loop", "-1", "-i", "music.mp3",
+		"-map", "0:v", "-vf", "scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=25,format=yuv420p",
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "stillimage", "-crf", "23",
+		"-map", "1:a", "-c:a", "aac", "-b:a", "192k", "-shortest",
+		"-movflags", "frag_keyframe+empty_moov", "-f", "mp4", "pipe:",
+	}
+	if a := slideshowArgs("mp4", SlideshowOptions{Music: "music.mp3", Width: 1280, Height: 720}); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+	a := slideshowArgs("mpegts", SlideshowOptions{})
+	if !slices.Contains(a, "-an") || !slices.Contains(a, "scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=25,format=yuv420p") {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestImageToJPEGArgs(t *testing.T) {
+	want := []string{
+		"ffmpeg", "-i", "in.webp", "-frames:v", "1",
+		"-vf", "scale=w='min(iw,4096)':h='min(ih,4096)':force_original_aspect_ratio=decrease,format=yuvj420p",
+		"-c:v", "mjpeg", "-q:v", "2", "-f", "image2pipe", "pipe:",
+	}
+	if a := imageToJPEGArgs("in.webp"); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestSubtitlesArgs(t *testing.T) {
+	want := []string{"ffmpeg", "-i", "in.mkv", "-map", "0:s:1", "-c:s", "srt", "-f", "srt", "pipe:"}
+	if a := subtitlesArgs("in.mkv", 1); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	o := Options{Rotation: 90, Height: 720}
+	if a := o.inputArgs(); !slices.Equal(a, []string{"-noautorotate"}) {
+		t.Errorf("got %q", a)
+	}
+	if a := o.streamArgs("in.mp4", 0); !slices.Equal(a, []string{"-vf", "transpose=clock,scale=-2:720"}) {
+		t.Errorf("got %q", a)
+	}
+	o = Options{Rotation: 180, Subtitles: 1}
+	want := []string{
+		"-filter_complex", "[0:V:0]hflip,vflip[source];[source]setpts=PTS+0/TB,subtitles=in.mp4:si=0,setpts=PTS-STARTPTS[v]",
+		"-map", "[v]", "-map", "0:a:0?",
+	}
+	if a := o.streamArgs("in.mp4", 0); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+	if a := (Options{}).inputArgs(); len(a) != 0 {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestDeinterlace(t *testing.T) {
+	o := Options{Deinterlace: "bwdif", Rotation: 270}
+	if a := o.streamArgs("in.ts", 0); !slices.Equal(a, []string{"-vf", "bwdif=deint=interlaced,transpose=cclock"}) {
+		t.Errorf("got %q", a)
+	}
+	o = Options{Deinterlace: "yadif", Subtitles: 2, SubtitlesArePictures: true}
+	want := []string{"-filter_complex", "[0:V:0]yadif=deint=interlaced[source];[source][0:s:1]overlay[v]", "-map", "[v]", "-map", "0:a:0?"}
+	if a := o.streamArgs("in.ts", 0); !slices.Equal(a, want) {
+		t.Errorf("got %q", a)
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		want Stats
+		ok   bool
+	}{
+		{"frame=  240 fps= 48 q=28.0 size=    1536KiB time=00:00:10.00 bitrate=1258.3kbits/s speed=2.01x", Stats{1536 << 10, 10 * time.Second}, true},
+		{"size=     862kB time=00:01:02.50 bitrate= 113.0kbits/s speed=40x", Stats{862 << 10, time.Minute + 2500*time.Millisecond}, true},
+		{"frame=    0 fps=0.0 q=0.0 size=       0KiB time=-577014:32:22.77 bitrate=  -0.0kbits/s", Stats{}, false},
+		{"Stream mapping:", Stats{}, false},
+	} {
+		got, ok := ParseStats(c.line)
+		if ok != c.ok || ok && got != c.want {
+			t.Errorf("%q: got %+v, %v", c.line, got, ok)
+		}
+	}
+	var w StatsWriter
+	w.Write([]byte("frame=1 size=  1KiB time=00:00:01.00 bitrate=8.2kbits/s\rframe=2 size=  2KiB time=00:00:0"))
+	if s, ok := w.Latest(); !ok || s.Size != 1<<10 {
+		t.Fatalf("got %+v, %v", s, ok)
+	}
+	w.Write([]byte("2.00 bitrate=8.2kbits/s\r"))
+	if s, _ := w.Latest(); s.Time != 2*time.Second {
+		t.Fatalf("got %+v", s)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package transcode
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestWaitUsage(t *testing.T) {
+	p, err := transcodePipe(context.Background(), []string{"sh", "-c", "echo out; printf 'frame=1\rin.mkv: Invalid data\nConversion failed!\n' >&2; exit 1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, p)
+	p.Close()
+	if u, ok := WaitUsage(p); !ok || u.Failure != "exit status 1: in.mkv: Invalid data" {
+		t.Errorf("got %+v", u)
+	}
+
+	// Closing the output before the end isn't a failure.
+	p, err = transcodePipe(context.Background(), []string{"sh", "-c", "yes"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Read(make([]byte, 10))
+	p.Close()
+	if u, _ := WaitUsage(p); u.Failure != "" {
+		t.Errorf("got %+v", u)
+	}
+}
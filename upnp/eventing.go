@@ -3,6 +3,7 @@ package upnp
 import (
 	"crypto/rand"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -38,16 +39,32 @@ type Variable struct {
 	Value   string `xml:",chardata"`
 }
 
+// Returned for a SID that isn't subscribed, or whose subscription expired.
+var ErrNoSubscription = errors.New("no such subscription")
+
 type subscriber struct {
 	sid     string
 	nextSeq uint32 // 0 for initial event, wraps from Uint32Max to 1.
 	urls    []*url.URL
 	expiry  time.Time
+	// Removes the subscriber when it expires.
+	timer *time.Timer
+	// Set once the initial event is queued. Events published before then
+	// aren't sent, as the initial event has the state after them.
+	started bool
+	// Events waiting to be sent, in order.
+	pending [][]Property
+	sending bool
 }
 
-// Intended to eventually be an embeddable implementation for managing
-// eventing for a service. Not complete.
+// Manages the event subscriptions to a service, as in UPnP Device
+// Architecture 4, and delivers its events to them. Embed it in the service.
+// The zero value is ready for use, but sends nothing until Notify is set.
 type Eventing struct {
+	// Sends an event to a subscriber's callback URLs. It's called for one
+	// event of a subscriber at a time, in order.
+	Notify func(urls []*url.URL, sid string, seq uint32, props []Property)
+
 	mutex       sync.Mutex
 	subscribers map[string]*subscriber
 }
@@ -64,22 +81,126 @@ func (me *Eventing) Subscribe(callback []*url.URL, timeoutSeconds int) (sid stri
 		return
 	}
 	ssr := &subscriber{
-		sid:    sid,
-		urls:   callback,
-		expiry: time.Now().Add(time.Duration(timeoutSeconds) * time.Second),
+		sid:  sid,
+		urls: callback,
 	}
 	if me.subscribers == nil {
 		me.subscribers = make(map[string]*subscriber)
 	}
 	me.subscribers[sid] = ssr
-	actualTimeout = int(ssr.expiry.Sub(time.Now()) / time.Second)
+	actualTimeout = me.setExpiry(ssr, timeoutSeconds)
 	return
 }
 
+// Sets when the subscription expires, returning the timeout in seconds.
+// Called with the mutex held.
+func (me *Eventing) setExpiry(ssr *subscriber, timeoutSeconds int) int {
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	ssr.expiry = time.Now().Add(timeout)
+	if ssr.timer != nil {
+		ssr.timer.Reset(timeout)
+	} else {
+		ssr.timer = time.AfterFunc(timeout, func() {
+			me.mutex.Lock()
+			defer me.mutex.Unlock()
+			// It may have been renewed as the timer fired.
+			if me.subscribers[ssr.sid] == ssr && !time.Now().Before(ssr.expiry) {
+				delete(me.subscribers, ssr.sid)
+			}
+		})
+	}
+	return int(timeout / time.Second)
+}
+
+// Extends a subscription by the timeout, from now.
+func (me *Eventing) Renew(sid string, timeoutSeconds int) (actualTimeout int, err error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	ssr, ok := me.subscribers[sid]
+	if !ok {
+		return 0, ErrNoSubscription
+	}
+	return me.setExpiry(ssr, timeoutSeconds), nil
+}
+
+// Cancels a subscription. Events not yet sent to it are dropped.
 func (me *Eventing) Unsubscribe(sid string) error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	ssr, ok := me.subscribers[sid]
+	if !ok {
+		return ErrNoSubscription
+	}
+	ssr.timer.Stop()
+	delete(me.subscribers, sid)
 	return nil
 }
 
+// Returns the number of subscriptions.
+func (me *Eventing) Len() int {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	return len(me.subscribers)
+}
+
+// Queues the initial event of a new subscription, with the current values of
+// all the evented state variables. Published events are sent to the
+// subscriber after it.
+func (me *Eventing) SendInitialEvent(sid string, props []Property) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	ssr, ok := me.subscribers[sid]
+	if !ok || ssr.started {
+		return
+	}
+	ssr.started = true
+	me.queue(ssr, props)
+}
+
+// Queues an event of the changed state variables for every subscriber.
+func (me *Eventing) Publish(props []Property) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	for _, ssr := range me.subscribers {
+		if ssr.started {
+			me.queue(ssr, props)
+		}
+	}
+}
+
+// Called with the mutex held.
+func (me *Eventing) queue(ssr *subscriber, props []Property) {
+	if me.Notify == nil {
+		return
+	}
+	ssr.pending = append(ssr.pending, props)
+	if !ssr.sending {
+		ssr.sending = true
+		go me.send(ssr)
+	}
+}
+
+// Sends the subscriber's pending events in order, until there are none, or
+// it's gone.
+func (me *Eventing) send(ssr *subscriber) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	for len(ssr.pending) != 0 && me.subscribers[ssr.sid] == ssr {
+		props := ssr.pending[0]
+		ssr.pending = ssr.pending[1:]
+		seq := ssr.nextSeq
+		ssr.nextSeq++
+		if ssr.nextSeq == 0 {
+			ssr.nextSeq = 1
+		}
+		me.mutex.Unlock()
+		me.Notify(ssr.urls, ssr.sid, seq, props)
+		me.mutex.Lock()
+	}
+	ssr.pending = nil
+	ssr.sending = false
+}
+
 var callbackURLRegexp = regexp.MustCompile("<(.*?)>")
 
 // Parse the CALLBACK HTTP header in an event subscription request. See UPnP
@@ -2,7 +2,10 @@ package upnp
 
 import (
 	"encoding/xml"
+	"errors"
+	"net/url"
 	"testing"
+	"time"
 )
 
 // Visually verify that property sets are marshalled correctly.
@@ -59,3 +62,62 @@ func TestSubscribeRace(t *testing.T) {
 	<-done
 	<-done
 }
+
+func TestEventingDelivery(t *testing.T) {
+	type event struct {
+		seq   uint32
+		value string
+	}
+	events := make(chan event, 10)
+	e := &Eventing{Notify: func(urls []*url.URL, sid string, seq uint32, props []Property) {
+		events <- event{seq, props[0].Variable.Value}
+	}}
+	prop := func(v string) []Property {
+		return []Property{{Variable: Variable{XMLName: xml.Name{Local: "SystemUpdateID"}, Value: v}}}
+	}
+	sid, timeout, err := e.Subscribe(nil, 60)
+	if err != nil || timeout != 60 {
+		t.Fatalf("got %d, %v", timeout, err)
+	}
+	// Not sent, as the initial event has the state after it.
+	e.Publish(prop("before"))
+	e.SendInitialEvent(sid, prop("1"))
+	e.Publish(prop("2"))
+	e.Publish(prop("3"))
+	for i, want := range []string{"1", "2", "3"} {
+		select {
+		case ev := <-events:
+			if ev.seq != uint32(i) || ev.value != want {
+				t.Errorf("got %+v, want %d %q", ev, i, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not sent", i)
+		}
+	}
+	if err := e.Unsubscribe(sid); err != nil {
+		t.Fatal(err)
+	}
+	e.Publish(prop("4"))
+	if e.Len() != 0 {
+		t.Errorf("%d subscribers", e.Len())
+	}
+	if _, err := e.Renew(sid, 60); !errors.Is(err, ErrNoSubscription) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestEventingExpiry(t *testing.T) {
+	e := &Eventing{}
+	sid, _, _ := e.Subscribe(nil, 1)
+	if _, err := e.Renew(sid, 1); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(3 * time.Second); e.Len() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription didn't expire")
+		}
+	}
+	if _, err := e.Renew(sid, 1); !errors.Is(err, ErrNoSubscription) {
+		t.Errorf("got %v", err)
+	}
+}
@@ -43,16 +43,17 @@ type SoapAction struct {
 	Action string
 }
 
+// Parses a SOAPACTION header, like
+// `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`.
 func ParseActionHTTPHeader(s string) (ret SoapAction, err error) {
-	if len(s) < 3 {
-		return
-	}
-	if s[0] != '"' || s[len(s)-1] != '"' {
+	if len(s) < 3 || s[0] != '"' || s[len(s)-1] != '"' {
+		err = fmt.Errorf("unquoted SOAPACTION %q", s)
 		return
 	}
 	s = s[1 : len(s)-1]
 	hashIndex := strings.LastIndex(s, "#")
-	if hashIndex == -1 {
+	if hashIndex == -1 || hashIndex == len(s)-1 {
+		err = fmt.Errorf("no action in SOAPACTION %q", s)
 		return
 	}
 	ret.Action = s[hashIndex+1:]
@@ -87,6 +88,9 @@ type Device struct {
 	FriendlyName    string `xml:"friendlyName"`
 	Manufacturer    string `xml:"manufacturer"`
 	ModelName       string `xml:"modelName"`
+	ModelNumber     string `xml:"modelNumber,omitempty"`
+	ModelURL        string `xml:"modelURL,omitempty"`
+	SerialNumber    string `xml:"serialNumber,omitempty"`
 	UDN             string
 	VendorXML       string    `xml:",innerxml"`
 	IconList        []Icon    `xml:"iconList>icon"`
@@ -114,6 +118,7 @@ func (e *Error) Error() string {
 
 const (
 	InvalidActionErrorCode        = 401
+	InvalidArgsErrorCode          = 402
 	ActionFailedErrorCode         = 501
 	ArgumentValueInvalidErrorCode = 600
 )
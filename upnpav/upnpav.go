@@ -8,6 +8,27 @@ import (
 const (
 	// NoSuchObjectErrorCode : The specified ObjectID is invalid.
 	NoSuchObjectErrorCode = 701
+	// IncompatibleProtocolInfoErrorCode : The connection cannot be established
+	// because the protocol info argument is incompatible.
+	IncompatibleProtocolInfoErrorCode = 701
+	// IncompatibleDirectionsErrorCode : The connection cannot be established
+	// because the directions of the involved ConnectionManagers are
+	// incompatible.
+	IncompatibleDirectionsErrorCode = 702
+	// InvalidConnectionReferenceErrorCode : The connection reference argument
+	// does not refer to a valid connection established by this service.
+	InvalidConnectionReferenceErrorCode = 706
+	// UnsupportedOrInvalidSearchCriteriaErrorCode : The search criteria
+	// specified is not supported or is invalid.
+	UnsupportedOrInvalidSearchCriteriaErrorCode = 708
+	// NoSuchContainerErrorCode : The specified ContainerID is invalid or
+	// identifies an object that is not a container.
+	NoSuchContainerErrorCode = 710
+	// NoSuchRecordScheduleErrorCode : The specified RecordScheduleID is
+	// invalid.
+	NoSuchRecordScheduleErrorCode = 713
+	// NoSuchRecordTaskErrorCode : The specified RecordTaskID is invalid.
+	NoSuchRecordTaskErrorCode = 714
 )
 
 // Resource description
@@ -19,6 +40,12 @@ type Resource struct {
 	Bitrate      uint     `xml:"bitrate,attr,omitempty"`
 	Duration     string   `xml:"duration,attr,omitempty"`
 	Resolution   string   `xml:"resolution,attr,omitempty"`
+	// In Hz.
+	SampleFrequency uint `xml:"sampleFrequency,attr,omitempty"`
+	NrAudioChannels uint `xml:"nrAudioChannels,attr,omitempty"`
+	BitsPerSample   uint `xml:"bitsPerSample,attr,omitempty"`
+	// Bits per pixel.
+	ColorDepth uint `xml:"colorDepth,attr,omitempty"`
 }
 
 // Container description
@@ -28,29 +55,66 @@ type Container struct {
 	ChildCount int      `xml:"childCount,attr"`
 }
 
+// A subtitle file for an item, of a type such as "srt".
+type CaptionInfo struct {
+	Type string `xml:"sec:type,attr"`
+	URL  string `xml:",chardata"`
+}
+
 // Item description
 type Item struct {
 	Object
 	XMLName  xml.Name `xml:"item"`
 	Res      []Resource
+	Desc     []Desc
 	InnerXML string `xml:",innerxml"`
+	// Subtitles as Samsung TVs take them.
+	CaptionInfoEx []CaptionInfo `xml:"sec:CaptionInfoEx,omitempty"`
+}
+
+// Desc holds metadata outside the DIDL-Lite schema, identified by its
+// namespace.
+type Desc struct {
+	XMLName   xml.Name `xml:"desc"`
+	ID        string   `xml:"id,attr"`
+	NameSpace string   `xml:"nameSpace,attr"`
+	Content   string   `xml:",chardata"`
 }
 
 // Object description
 type Object struct {
-	ID          string    `xml:"id,attr"`
-	ParentID    string    `xml:"parentID,attr"`
-	Restricted  int       `xml:"restricted,attr"` // indicates whether the object is modifiable
-	Title       string    `xml:"dc:title"`
-	Class       string    `xml:"upnp:class"`
-	Icon        string    `xml:"upnp:icon,omitempty"`
-	Date        Timestamp `xml:"dc:date"`
-	Artist      string    `xml:"upnp:artist,omitempty"`
-	Album       string    `xml:"upnp:album,omitempty"`
-	Genre       string    `xml:"upnp:genre,omitempty"`
-	AlbumArtURI string    `xml:"upnp:albumArtURI,omitempty"`
-	Searchable  int       `xml:"searchable,attr"`
-	SearchXML   string    `xml:",innerxml"`
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"` // indicates whether the object is modifiable
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+	// The author, such as the composer of a track or the camera a photo
+	// was taken with.
+	Creator string    `xml:"dc:creator,omitempty"`
+	Icon    string    `xml:"upnp:icon,omitempty"`
+	Date    Timestamp `xml:"dc:date"`
+	Artist  string    `xml:"upnp:artist,omitempty"`
+	Album   string    `xml:"upnp:album,omitempty"`
+	Genre   string    `xml:"upnp:genre,omitempty"`
+	// A synopsis, such as of a recorded programme.
+	Description string `xml:"dc:description,omitempty"`
+	// The track's number on its album, or the episode's in its season.
+	OriginalTrackNumber int    `xml:"upnp:originalTrackNumber,omitempty"`
+	AlbumArtURI         string `xml:"upnp:albumArtURI,omitempty"`
+	// Where the client can resume playing the item.
+	LastPlaybackPosition string `xml:"upnp:lastPlaybackPosition,omitempty"`
+	// When the client last played the item.
+	LastPlaybackTime string `xml:"upnp:lastPlaybackTime,omitempty"`
+	// How many times the client has played the item.
+	PlaybackCount int `xml:"upnp:playbackCount,omitempty"`
+	// The broadcast an item was recorded from, and when it was on.
+	ChannelName        string `xml:"upnp:channelName,omitempty"`
+	ScheduledStartTime string `xml:"upnp:scheduledStartTime,omitempty"`
+	ScheduledEndTime   string `xml:"upnp:scheduledEndTime,omitempty"`
+	// Samsung's metadata, such as the BM (bookmark) for resuming.
+	DcmInfo    string `xml:"sec:dcmInfo,omitempty"`
+	Searchable int    `xml:"searchable,attr"`
+	SearchXML  string `xml:",innerxml"`
 }
 
 // Timestamp wraps time.Time for formatting purposes
@@ -58,7 +122,11 @@ type Timestamp struct {
 	time.Time
 }
 
-// MarshalXML formats the Timestamp per DIDL-Lite spec
+// MarshalXML formats the Timestamp per DIDL-Lite spec. A zero Timestamp is
+// left out.
 func (t Timestamp) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if t.IsZero() {
+		return nil
+	}
 	return e.EncodeElement(t.Format("2006-01-02"), start)
 }